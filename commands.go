@@ -1,31 +1,45 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/ed25519"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"maps"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"plugin"
+	"runtime"
 	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/mizuchilabs/sqlite-schema-diff/pkg/diff"
 	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
 	"github.com/urfave/cli/v3"
 	_ "modernc.org/sqlite"
 )
 
-var commands = []*cli.Command{diffCMD, applyCMD, dumpCMD}
+var commands = []*cli.Command{diffCMD, applyCMD, initDBCMD, dumpCMD, verifyMigrationCMD, statusCMD, watchCMD, historyCMD, agentCMD, reconcileCMD, mcpCMD, adoptCMD, fmtCMD, lintCMD, importCMD, debugCMD, changeTypesCMD, codegenCMD}
 
 var diffCMD = &cli.Command{
 	Name:  "diff",
 	Usage: "Show schema differences between database and schema files",
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.StringFlag{
-			Name:     "database",
-			Aliases:  []string{"db"},
-			Usage:    "Path to SQLite database file",
-			Required: true,
+			Name:    "database",
+			Aliases: []string{"db"},
+			Usage:   "Path to SQLite database file",
 		},
 		&cli.StringFlag{
 			Name:    "schema",
@@ -33,36 +47,261 @@ var diffCMD = &cli.Command{
 			Value:   "schema",
 			Usage:   "Path to schema directory containing .sql files",
 		},
+		&cli.BoolFlag{
+			Name:  "offline",
+			Usage: "Parse schema files without executing them (for SQL needing extensions the tool lacks)",
+		},
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "Path to SQLite database (e.g. a backup) or schema directory (e.g. a dump) to compare from, requires --to",
+		},
+		&cli.StringFlag{
+			Name:  "to",
+			Usage: "Path to SQLite database or schema directory to compare to, requires --from",
+		},
 		&cli.BoolFlag{
 			Name:  "sql",
 			Usage: "Output migration SQL instead of human-readable diff",
 		},
-	},
+		&cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "Output format: text, sql, d1 (Cloudflare D1 / wrangler migration), json (plan with object addresses and prior/new values) or plan (editable plan file for apply --plan-file)",
+		},
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "With --format sql, write the script to this file; with --format d1, write the next numbered migration file into this directory",
+		},
+		&cli.StringFlag{
+			Name:  "name",
+			Value: "schema_diff",
+			Usage: "Name of the migration file written with --output",
+		},
+		&cli.BoolFlag{
+			Name:  "verify-plan",
+			Usage: "Apply the plan to an in-memory copy and check it reproduces the target schema",
+		},
+		&cli.StringFlag{
+			Name:  "policies",
+			Usage: "Policy file of expressions over the plan and schema; a matching deny policy fails the diff",
+		},
+		&cli.StringFlag{
+			Name:  "queries",
+			Usage: "Directory of application queries (.sql, e.g. the sqlc queries); fail if the plan breaks any of them",
+		},
+		&cli.BoolFlag{
+			Name:  "read-only",
+			Usage: "Open databases read-only (mode=ro, query_only), so inspection can never modify them",
+		},
+		&cli.BoolFlag{
+			Name:  "immutable",
+			Usage: "Like --read-only, and also skip locking; only for files nobody is writing (backups, snapshots)",
+		},
+		&cli.BoolFlag{
+			Name:  "schema-only",
+			Usage: "Like --read-only, and only read schema pages (memory-mapped, no size or CHECK scans); for very large databases",
+		},
+		&cli.StringFlag{
+			Name:  "data-dir",
+			Usage: "Directory of data migration .sql files to run after the changes they declare (-- @after: ADD_COLUMN users.status)",
+		},
+		&cli.StringFlag{
+			Name:  "column-order",
+			Value: string(diff.ColumnOrderStrict),
+			Usage: "Column order policy: strict (recreate to match declared order) or ignore (append new columns)",
+		},
+		&cli.BoolFlag{
+			Name:  "preserve-rowids",
+			Usage: "Copy the rowids of recreated tables without an INTEGER PRIMARY KEY (e.g. for FTS content tables)",
+		},
+		&cli.BoolFlag{
+			Name:  "suggest-indexes",
+			Usage: "Also suggest indexes for un-indexed foreign keys and columns views filter on (advisory, not part of the plan)",
+		},
+		&cli.StringFlag{
+			Name:  "target-version",
+			Usage: "SQLite version the migration will run on, e.g. 3.35.5 (default: detected from the database)",
+		},
+	}, schemaWalkFlags...),
 	Action: func(ctx context.Context, cmd *cli.Command) error {
 		dbPath := cmd.String("database")
 		schemaDir := cmd.String("schema")
-		outputSQL := cmd.Bool("sql")
+		fromPath := cmd.String("from")
+		toPath := cmd.String("to")
+		format := cmd.String("format")
+		if cmd.Bool("sql") {
+			format = "sql"
+		}
+		verifyPlan := cmd.Bool("verify-plan")
 
-		db, err := sql.Open("sqlite", dbPath)
+		switch format {
+		case "text", "sql", "d1", "json", "plan":
+		default:
+			return fmt.Errorf("invalid --format %q: must be text, sql, d1, json or plan", format)
+		}
+		if cmd.String("output") != "" && format != "sql" && format != "d1" {
+			return fmt.Errorf("--output requires --format sql or d1")
+		}
+
+		diffOpts, err := diffOptions(cmd)
 		if err != nil {
-			return fmt.Errorf("open database: %w", err)
+			return err
+		}
+
+		var current, target *schema.Database
+		var currentDB *sql.DB
+		noData := false
+		switch {
+		case fromPath != "" || toPath != "":
+			if fromPath == "" || toPath == "" {
+				return fmt.Errorf("--from and --to must be used together")
+			}
+			if dbPath != "" {
+				return fmt.Errorf("--database cannot be combined with --from/--to")
+			}
+
+			var fromDB *sql.DB
+			if current, fromDB, err = readSnapshot(ctx, cmd, fromPath, diffOpts.Parse); err != nil {
+				return err
+			}
+			if fromDB != nil {
+				defer func() { _ = fromDB.Close() }()
+			}
+			var toDB *sql.DB
+			if target, toDB, err = readSnapshot(ctx, cmd, toPath, diffOpts.Parse); err != nil {
+				return err
+			}
+			if toDB != nil {
+				_ = toDB.Close() // Only its schema is compared
+			}
+
+			currentDB = fromDB
+			if currentDB == nil {
+				// Schema files have no data, only the SQLite build matters
+				if currentDB, err = parser.Open(":memory:"); err != nil {
+					return err
+				}
+				defer func() { _ = currentDB.Close() }()
+				noData = true
+			}
+		case dbPath != "":
+			db, err := openDatabase(cmd, dbPath)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = db.Close() }()
+
+			currentDB = db
+			if current, err = parser.FromDBContext(ctx, db); err != nil {
+				return err
+			}
+			if target, err = parser.ReadFilesContext(ctx, schemaDir, diffOpts.Parse); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("either --database or --from/--to is required")
 		}
-		defer func() { _ = db.Close() }()
 
-		changes, err := diff.Compare(db, schemaDir)
+		caps, err := diff.DetectCapabilities(currentDB)
 		if err != nil {
 			return err
 		}
+		diffOpts = diffOpts.WithCapabilities(caps)
 
-		if len(changes) == 0 {
+		hooks, err := dataHooks(cmd)
+		if err != nil {
+			return err
+		}
+		changes := diff.DiffWithOptions(current, target, diffOpts)
+		if dbPath != "" {
+			overrides, err := diff.LoadOverrides(schemaDir)
+			if err != nil {
+				return fmt.Errorf("load overrides: %w", err)
+			}
+			if changes, err = diff.ApplyOverrides(changes, overrides); err != nil {
+				return err
+			}
+			changes = append(changes, diff.PartitionChanges(current, diffOpts.Partitions, time.Now())...)
+		}
+		changes = diff.AttachDataHooks(changes, hooks)
+		// The plan is only previewed, approvals are needed to apply it
+		if err := checkPolicies(ctx, cmd, changes, diff.SchemaSource(target)); errors.Is(err, diff.ErrApprovalRequired) {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		} else if err != nil {
+			return err
+		}
+		if err := checkQueries(ctx, cmd, diff.SchemaSource(current), changes); err != nil {
+			return err
+		}
+		if len(changes) == 0 && format != "json" && format != "plan" {
 			fmt.Println("No schema changes detected.")
+			if cmd.Bool("suggest-indexes") {
+				return showSuggestions(target, format)
+			}
 			return nil
 		}
 
-		if outputSQL {
-			fmt.Println(diff.GenerateSQL(changes))
-		} else {
-			showChanges(changes)
+		switch format {
+		case "sql":
+			if path := cmd.String("output"); path != "" {
+				if err := writeSQLFile(path, changes); err != nil {
+					return err
+				}
+				fmt.Printf("Wrote %s\n", path)
+			} else if err := diff.WriteSQL(os.Stdout, changes, diff.SQLOptions{}); err != nil {
+				return err
+			}
+		case "json":
+			out, err := diff.PlanJSON(current, target, changes)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+		case "plan":
+			if err := diff.WritePlanFile(os.Stdout, diff.NewPlanFile(current, changes)); err != nil {
+				return err
+			}
+		case "d1":
+			if dir := cmd.String("output"); dir != "" {
+				path, err := diff.WriteD1Migration(dir, cmd.String("name"), current, changes)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Wrote %s\n", path)
+			} else if err := diff.WriteSQL(os.Stdout, changes, diff.SQLOptions{D1: true, Current: current}); err != nil {
+				return err
+			}
+		default:
+			// Sizes, estimates and CHECK scans read table data
+			owners := diff.SchemaOwners(current, target)
+			if cmd.Bool("schema-only") || noData {
+				showChanges(changes, owners)
+				break
+			}
+			_ = diff.AnnotateSizesContext(ctx, currentDB, changes) // Sizes are optional, dbstat may be missing
+			showChanges(changes, owners)
+			showEstimate(ctx, currentDB, changes)
+			showViolations(ctx, currentDB, changes)
+		}
+		if cmd.Bool("suggest-indexes") {
+			if err := showSuggestions(target, format); err != nil {
+				return err
+			}
+		}
+
+		if verifyPlan {
+			residual, err := diff.VerifyPlanContext(ctx, current, target, changes, diffOpts)
+			if err != nil {
+				return fmt.Errorf("verify plan: %w", err)
+			}
+			if len(residual) > 0 {
+				fmt.Fprintln(os.Stderr, "\nPlan verification failed, differences remain after applying the plan:")
+				for _, c := range residual {
+					fmt.Fprintf(os.Stderr, "  %s: %s\n", c.Type, c.Description)
+				}
+				return fmt.Errorf("plan generation bug: %d residual differences", len(residual))
+			}
+			fmt.Fprintln(os.Stderr, "\nPlan verified: applying it reproduces the target schema.")
 		}
 		return nil
 	},
@@ -71,7 +310,7 @@ var diffCMD = &cli.Command{
 var applyCMD = &cli.Command{
 	Name:  "apply",
 	Usage: "Apply schema changes to database",
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.StringFlag{
 			Name:     "database",
 			Aliases:  []string{"db"},
@@ -84,6 +323,31 @@ var applyCMD = &cli.Command{
 			Value:   "schema",
 			Usage:   "Path to schema directory containing .sql files",
 		},
+		&cli.BoolFlag{
+			Name:  "offline",
+			Usage: "Parse schema files without executing them (for SQL needing extensions the tool lacks)",
+		},
+		&cli.StringFlag{
+			Name:  "data-dir",
+			Usage: "Directory of data migration .sql files to run after the changes they declare (-- @after: ADD_COLUMN users.status)",
+		},
+		&cli.StringFlag{
+			Name:  "column-order",
+			Value: string(diff.ColumnOrderStrict),
+			Usage: "Column order policy: strict (recreate to match declared order) or ignore (append new columns)",
+		},
+		&cli.BoolFlag{
+			Name:  "preserve-rowids",
+			Usage: "Copy the rowids of recreated tables without an INTEGER PRIMARY KEY (e.g. for FTS content tables)",
+		},
+		&cli.StringFlag{
+			Name:  "target-version",
+			Usage: "SQLite version the migration will run on, e.g. 3.35.5 (default: detected from the database)",
+		},
+		&cli.StringFlag{
+			Name:  "plan-file",
+			Usage: "Apply a saved (possibly edited) plan from diff --format plan instead of diffing, after validating it",
+		},
 		&cli.BoolFlag{
 			Name:  "dry-run",
 			Usage: "Show what would be applied without making changes",
@@ -92,17 +356,171 @@ var applyCMD = &cli.Command{
 			Name:  "skip-destructive",
 			Usage: "Skip destructive changes (drops, table recreations)",
 		},
+		&cli.StringFlag{
+			Name:  "skip-above",
+			Usage: "Skip changes more severe than this severity: info (only additions) or warn (same as --skip-destructive)",
+		},
 		&cli.BoolFlag{
 			Name:  "backup",
 			Usage: "Create backup before applying changes",
 			Value: true,
 		},
+		&cli.StringFlag{
+			Name:  "backup-strategy",
+			Value: string(diff.BackupVacuum),
+			Usage: "Backup method: vacuum (VACUUM INTO), copy (file copy with integrity check) or auto (vacuum, falling back to copy)",
+		},
 		&cli.BoolFlag{
 			Name:    "force",
 			Aliases: []string{"f"},
 			Usage:   "Skip confirmation prompt for destructive changes",
 		},
-	},
+		&cli.StringFlag{
+			Name:  "strategy",
+			Value: "in-place",
+			Usage: "How to change the database: in-place, rebuild (build a new file from the target schema and swap it in) or auto (rebuild when most tables are recreated)",
+		},
+		&cli.BoolFlag{
+			Name:  "journal",
+			Usage: "Record the plan and its progress in <database>" + diff.JournalSuffix + " while applying, to detect interrupted applies",
+			Value: true,
+		},
+		&cli.BoolFlag{
+			Name:  "resume",
+			Usage: "Finish an interrupted apply from its journal instead of planning again",
+		},
+		&cli.BoolFlag{
+			Name:  "discard-journal",
+			Usage: "Forget an interrupted apply, e.g. after restoring the backup",
+		},
+		&cli.BoolFlag{
+			Name:  "defer-indexes",
+			Usage: "Create new indexes in separate transactions after committing other changes",
+		},
+		&cli.StringFlag{
+			Name:  "pre-apply-hook",
+			Usage: "Shell command to run before writing, e.g. to stop a replication tool",
+		},
+		&cli.StringFlag{
+			Name:  "post-apply-hook",
+			Usage: "Shell command to run after applying or failing, e.g. to restart a replication tool",
+		},
+		&cli.StringFlag{
+			Name:  "changelog",
+			Usage: "Append the committed changes as JSON lines to this file, for replicas and sync layers",
+		},
+		&cli.StringFlag{
+			Name:  "export-dropped",
+			Usage: "Export the data destructive changes lose to files in this directory before applying",
+		},
+		&cli.StringFlag{
+			Name:  "export-format",
+			Value: "csv",
+			Usage: "Format of --export-dropped files: csv or jsonl",
+		},
+		&cli.IntFlag{
+			Name:  "preview-data",
+			Usage: "Print the first N rows of the tables and columns destructive changes lose before applying",
+		},
+		&cli.StringFlag{
+			Name:  "preview-file",
+			Usage: "Write the --preview-data rows as JSON to this file instead of printing them",
+		},
+		&cli.BoolFlag{
+			Name:  "quarantine",
+			Usage: "Move rows violating new CHECK constraints into <table>_quarantine instead of failing",
+		},
+		&cli.BoolFlag{
+			Name:  "self-check",
+			Usage: "Refuse to apply unless the plan reproduces the target schema on an in-memory copy",
+		},
+		&cli.BoolFlag{
+			Name:  "check-views",
+			Usage: "Select from every view before committing and fail if one no longer compiles",
+		},
+		&cli.StringFlag{
+			Name:  "policies",
+			Usage: "Policy file of expressions over the plan and schema; a matching deny policy refuses the apply, also in dry runs",
+		},
+		&cli.StringFlag{
+			Name:  "queries",
+			Usage: "Directory of application queries (.sql, e.g. the sqlc queries); refuse plans that break any of them",
+		},
+		&cli.BoolFlag{
+			Name:  "require-approval",
+			Usage: "Refuse plans changing objects other teams own (-- @owner: annotations) unless they approved with --approved-by",
+		},
+		&cli.StringFlag{
+			Name:  "team",
+			Usage: "Team applying the plan; its own objects need no approval",
+		},
+		&cli.StringSliceFlag{
+			Name:  "approved-by",
+			Usage: "Team that acknowledged the plan, for --require-approval and approve policies; can be repeated",
+		},
+		&cli.BoolFlag{
+			Name:  "low-priority",
+			Usage: "Apply changes in small batches with pauses, for busy databases (not atomic)",
+		},
+		&cli.IntFlag{
+			Name:  "batch-size",
+			Value: 1,
+			Usage: "Number of changes per transaction in low-priority mode",
+		},
+		&cli.IntFlag{
+			Name:  "batch-rows",
+			Value: 1000,
+			Usage: "Number of rows per transaction when copying a recreated table in low-priority mode",
+		},
+		&cli.DurationFlag{
+			Name:  "batch-pause",
+			Value: 100 * time.Millisecond,
+			Usage: "Pause between batches in low-priority mode",
+		},
+		&cli.StringFlag{
+			Name:  "window",
+			Usage: "Only apply destructive changes inside these maintenance windows, e.g. 'Sat,Sun 00:00-06:00; Mon-Fri 02:00-04:00' (local time)",
+		},
+		&cli.BoolFlag{
+			Name:  "override-window",
+			Usage: "Apply destructive changes outside the --window maintenance windows",
+		},
+		&cli.Int64Flag{
+			Name:  "expensive-rows",
+			Usage: "Classify changes by table size: changes to empty tables are safe, copying, indexing or dropping tables of at least N rows is expensive and needs a --window",
+		},
+		&cli.StringFlag{
+			Name:  "wal",
+			Value: "keep",
+			Usage: "WAL growth control: keep, checkpoint (truncate the WAL above --max-wal-size after every commit) or delete (use a rollback journal while applying)",
+		},
+		&cli.Int64Flag{
+			Name:  "max-wal-size",
+			Value: 64,
+			Usage: "WAL size in MiB that --wal checkpoint keeps the WAL under between commits",
+		},
+		&cli.StringFlag{
+			Name:  "temp-store",
+			Value: "default",
+			Usage: "Where SQLite keeps temporary tables and indexes while applying: default, file or memory",
+		},
+		&cli.StringFlag{
+			Name:  "temp-dir",
+			Usage: "Directory for SQLite temporary files while applying, instead of the system default",
+		},
+		&cli.Int64Flag{
+			Name:  "cache-size",
+			Usage: "Page cache of the migration connection in MiB (0 keeps the default)",
+		},
+		&cli.Int64Flag{
+			Name:  "mmap-size",
+			Usage: "Memory-mapped I/O of the migration connection in MiB (0 keeps the default, -1 disables it)",
+		},
+		&cli.Int64Flag{
+			Name:  "soft-heap-limit",
+			Usage: "Soft heap limit in MiB while applying (0 keeps the default)",
+		},
+	}, schemaWalkFlags...),
 	Action: func(ctx context.Context, cmd *cli.Command) error {
 		dbPath := cmd.String("database")
 		schemaDir := cmd.String("schema")
@@ -110,6 +528,13 @@ var applyCMD = &cli.Command{
 		skipDestructive := cmd.Bool("skip-destructive")
 		backup := cmd.Bool("backup")
 		force := cmd.Bool("force")
+		deferIndexes := cmd.Bool("defer-indexes")
+		lowPriority := cmd.Bool("low-priority")
+
+		diffOpts, err := diffOptions(cmd)
+		if err != nil {
+			return err
+		}
 
 		db, err := sql.Open("sqlite", dbPath)
 		if err != nil {
@@ -117,21 +542,157 @@ var applyCMD = &cli.Command{
 		}
 		defer func() { _ = db.Close() }()
 
-		changes, err := diff.Compare(db, schemaDir)
+		hooks, err := dataHooks(cmd)
+		if err != nil {
+			return err
+		}
+
+		// The changes shown are the ones applied; a concurrent schema change
+		// fails the apply instead of applying a plan nobody reviewed
+		version, err := diff.SchemaVersionContext(ctx, db)
 		if err != nil {
 			return err
 		}
 
+		// An interrupted apply is finished or rolled back before planning anew
+		journalFile := dbPath + diff.JournalSuffix
+		journal, err := diff.ReadJournal(journalFile)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			journal = nil
+		case err != nil:
+			return err
+		}
+		if journal != nil && cmd.Bool("discard-journal") {
+			if err := os.Remove(journalFile); err != nil {
+				return fmt.Errorf("discard journal: %w", err)
+			}
+			fmt.Println("Discarded the journal of the interrupted apply.")
+			journal = nil
+		}
+		resume := cmd.Bool("resume")
+		if journal != nil && !resume {
+			return fmt.Errorf("%w\n%s\nUse --resume to finish it, or --discard-journal once the database is in order",
+				diff.ErrInterruptedApply, journal.Guidance())
+		}
+		if journal == nil && resume {
+			return fmt.Errorf("no interrupted apply to resume, %s not found", journalFile)
+		}
+		journalPath := ""
+		if cmd.Bool("journal") || resume {
+			journalPath = journalFile
+		}
+
+		planFile := cmd.String("plan-file")
+		var changes []diff.Change
+		if resume {
+			if planFile != "" || len(hooks) > 0 {
+				return fmt.Errorf("--resume cannot be combined with --plan-file or --data-dir, it applies the journaled plan")
+			}
+			changes = journal.Remaining()
+		} else if planFile != "" {
+			if len(hooks) > 0 {
+				return fmt.Errorf("--data-dir cannot be combined with --plan-file, data migrations are part of the plan")
+			}
+			if changes, err = readPlanFile(ctx, db, planFile); err != nil {
+				return err
+			}
+		} else {
+			if changes, err = diff.CompareContext(ctx, db, schemaDir, diffOpts); err != nil {
+				return err
+			}
+			changes = diff.AttachDataHooks(changes, hooks)
+		}
+
+		if len(changes) == 0 && resume {
+			if err := diff.ResumeApplyContext(ctx, db, schemaDir, diff.ApplyOptions{JournalPath: journalPath}); err != nil {
+				return fmt.Errorf("resume: %w", err)
+			}
+			fmt.Println("The interrupted apply had committed every change, journal removed.")
+			return nil
+		}
 		if len(changes) == 0 {
 			fmt.Println("No schema changes detected.")
 			return nil
 		}
 
+		var classifier diff.Classifier
+		if n := cmd.Int64("expensive-rows"); n > 0 {
+			classifier = diff.RowCountClassifier{ExpensiveRows: n}
+			if err := diff.ClassifyContext(ctx, db, changes, classifier); err != nil {
+				return err
+			}
+		}
+
+		var skipAbove diff.Severity
+		if s := cmd.String("skip-above"); s != "" {
+			if skipAbove, err = diff.ParseSeverity(s); err != nil {
+				return fmt.Errorf("invalid --skip-above: %w", err)
+			}
+			if changes = diff.SkipAbove(changes, skipAbove); len(changes) == 0 {
+				fmt.Println("No schema changes at or below severity " + s + ".")
+				return nil
+			}
+		}
+
+		windows, err := diff.ParseWindows(cmd.String("window"))
+		if err != nil {
+			return fmt.Errorf("invalid --window: %w", err)
+		}
+		if cmd.Bool("override-window") {
+			windows = nil
+		}
+
+		owners, err := schemaOwners(ctx, db, schemaDir, diffOpts.Parse)
+		if err != nil {
+			return err
+		}
 		fmt.Println("Schema changes to be applied:")
-		showChanges(changes)
+		_ = diff.AnnotateSizesContext(ctx, db, changes) // Sizes are optional, dbstat may be missing
+		showChanges(changes, owners)
+		showEstimate(ctx, db, changes)
+		showViolations(ctx, db, changes)
+
+		planned := changes
+		if skipDestructive {
+			planned = slices.DeleteFunc(slices.Clone(changes), func(c diff.Change) bool { return c.Destructive })
+		}
+		if err := checkPolicies(ctx, cmd, planned, diff.DirSource(schemaDir, diffOpts.Parse)); errors.Is(err, diff.ErrApprovalRequired) && dryRun {
+			fmt.Printf("\n%v\n", err)
+		} else if err != nil {
+			return err
+		}
+		if cmd.Bool("require-approval") {
+			err := diff.CheckApprovals(planned, owners, cmd.String("team"), cmd.StringSlice("approved-by"))
+			switch {
+			case err != nil && dryRun:
+				fmt.Printf("\n%v\n", err)
+			case err != nil:
+				return fmt.Errorf("%w\nRerun with --approved-by <team> once the owners acknowledged the plan", err)
+			}
+		}
+		if err := checkQueries(ctx, cmd, func(ctx context.Context) (*schema.Database, error) { return parser.FromDBContext(ctx, db) }, planned); err != nil {
+			if !dryRun {
+				return err
+			}
+			fmt.Printf("\n%v\n", err)
+		}
+
+		if !dryRun && !skipDestructive {
+			if err := diff.CheckWindows(changes, windows, time.Now()); err != nil {
+				return fmt.Errorf("%w; use --override-window to apply anyway", err)
+			}
+		}
+
+		if n := cmd.Int("preview-data"); n > 0 && diff.HasDestructive(changes) {
+			if err := showPreview(db, changes, n, cmd.String("preview-file")); err != nil {
+				return err
+			}
+		}
 
 		// Confirm destructive changes
 		if diff.HasDestructive(changes) && !force && !dryRun {
+			showColumnStats(db, changes)
 			fmt.Print("\nWARNING: Destructive changes detected. Continue? (yes/no): ")
 			var response string
 			if _, err := fmt.Scanln(&response); err != nil {
@@ -152,14 +713,126 @@ var applyCMD = &cli.Command{
 		if backup {
 			backupPath = dbPath + ".backup"
 		}
+		walPolicy := diff.WALPolicy(cmd.String("wal"))
+		switch walPolicy {
+		case "keep":
+			walPolicy = diff.WALKeep
+		case diff.WALCheckpoint, diff.WALDelete:
+		default:
+			return fmt.Errorf("invalid --wal %q: must be keep, checkpoint or delete", walPolicy)
+		}
+		tempStore := diff.TempStore(cmd.String("temp-store"))
+		switch tempStore {
+		case "default":
+			tempStore = diff.TempStoreDefault
+		case diff.TempStoreFile, diff.TempStoreMemory:
+		default:
+			return fmt.Errorf("invalid --temp-store %q: must be default, file or memory", tempStore)
+		}
+		mmapSize := cmd.Int64("mmap-size")
+		if mmapSize > 0 {
+			mmapSize <<= 20
+		}
+		backupStrategy := diff.BackupStrategy(cmd.String("backup-strategy"))
+		switch backupStrategy {
+		case diff.BackupVacuum, diff.BackupCopy, diff.BackupAuto:
+		default:
+			return fmt.Errorf("invalid --backup-strategy %q: must be vacuum, copy or auto", backupStrategy)
+		}
+
+		strategy := diff.ApplyStrategy(cmd.String("strategy"))
+		switch strategy {
+		case "in-place":
+			strategy = diff.StrategyInPlace
+		case diff.StrategyRebuild, diff.StrategyAuto:
+		default:
+			return fmt.Errorf("invalid --strategy %q: must be in-place, rebuild or auto", strategy)
+		}
+
+		exportFormat := diff.ExportFormat(cmd.String("export-format"))
+		switch exportFormat {
+		case "csv":
+			exportFormat = diff.ExportCSV
+		case diff.ExportJSONL:
+		default:
+			return fmt.Errorf("invalid --export-format %q: must be csv or jsonl", exportFormat)
+		}
 
 		opts := diff.ApplyOptions{
-			DryRun:          dryRun,
-			SkipDestructive: skipDestructive,
-			BackupPath:      backupPath,
+			DiffOptions:          diffOpts,
+			DataHooks:            hooks,
+			QuarantineViolations: cmd.Bool("quarantine"),
+			SelfCheck:            cmd.Bool("self-check"),
+			CheckViews:           cmd.Bool("check-views"),
+			ExportDir:            cmd.String("export-dropped"),
+			ExportFormat:         exportFormat,
+			DryRun:               dryRun,
+			SkipDestructive:      skipDestructive,
+			SkipAbove:            skipAbove,
+			BackupPath:           backupPath,
+			BackupStrategy:       backupStrategy,
+			Strategy:             strategy,
+			JournalPath:          journalPath,
+			DeferIndexes:         deferIndexes,
+			LowPriority:          lowPriority,
+			BatchSize:            cmd.Int("batch-size"),
+			BatchRows:            cmd.Int("batch-rows"),
+			BatchPause:           cmd.Duration("batch-pause"),
+			SchemaVersion:        &version,
+			Windows:              windows,
+			Classifier:           classifier,
+			WAL:                  walPolicy,
+			MaxWALSize:           cmd.Int64("max-wal-size") << 20,
+			TempStore:            tempStore,
+			TempDir:              cmd.String("temp-dir"),
+			Memory: diff.MemoryBudget{
+				CacheSize:     cmd.Int64("cache-size") << 20,
+				MmapSize:      mmapSize,
+				SoftHeapLimit: cmd.Int64("soft-heap-limit") << 20,
+			},
+			IndexProgress: func(done, total int, index string) {
+				fmt.Printf("Created index %q (%d/%d)\n", index, done, total)
+			},
+		}
+
+		if hook := cmd.String("pre-apply-hook"); hook != "" {
+			opts.PreApply = func() error {
+				return runHook(ctx, hook, dbPath, "pre-apply")
+			}
+		}
+		if hook := cmd.String("post-apply-hook"); hook != "" {
+			opts.PostApply = func(applyErr error) error {
+				status := "success"
+				if applyErr != nil {
+					status = "failure"
+				}
+				return runHook(ctx, hook, dbPath, status)
+			}
 		}
 
-		if err := diff.Apply(db, schemaDir, opts); err != nil {
+		if path := cmd.String("changelog"); path != "" {
+			f, err := os.OpenFile(filepath.Clean(path), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+			if err != nil {
+				return fmt.Errorf("open change log: %w", err)
+			}
+			defer func() { _ = f.Close() }()
+			opts.OnCommit = diff.ChangeLogWriter(f, func(err error) {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			})
+		}
+
+		switch {
+		case resume:
+			err = diff.ResumeApplyContext(ctx, db, schemaDir, opts)
+		case planFile != "":
+			err = diff.ApplyPlanContext(ctx, db, schemaDir, changes, opts)
+		default:
+			err = diff.ApplyContext(ctx, db, schemaDir, opts)
+		}
+		if errors.Is(err, diff.ErrSchemaChanged) {
+			return fmt.Errorf("apply changes: %w; run apply again to review the new plan", err)
+		}
+		if err != nil {
 			return fmt.Errorf("apply changes: %w", err)
 		}
 
@@ -168,8 +841,76 @@ var applyCMD = &cli.Command{
 	},
 }
 
-var dumpCMD = &cli.Command{
-	Name:  "dump",
+var initDBCMD = &cli.Command{
+	Name:  "init-db",
+	Usage: "Create a new database directly from the schema directory",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:     "database",
+			Aliases:  []string{"db"},
+			Usage:    "Path of the SQLite database file to create",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "schema",
+			Aliases: []string{"s"},
+			Value:   "schema",
+			Usage:   "Path to schema directory containing .sql files",
+		},
+		&cli.BoolFlag{
+			Name:  "offline",
+			Usage: "Parse schema files without executing them (for SQL needing extensions the tool lacks)",
+		},
+		&cli.StringFlag{
+			Name:  "seeds",
+			Usage: "Directory of .sql files to run after creating the schema, in file name order",
+		},
+		&cli.StringSliceFlag{
+			Name:  "pragma",
+			Usage: "Pragma to set before creating the schema, as name=value, e.g. journal_mode=WAL (repeatable)",
+		},
+		&cli.StringFlag{
+			Name:  "changelog",
+			Usage: "Append the baseline changes as a JSON line to this file",
+		},
+	}, schemaWalkFlags...),
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		dbPath := cmd.String("database")
+		opts := diff.InitOptions{
+			DiffOptions: diff.DiffOptions{Parse: parseOptions(cmd)},
+			Pragmas:     cmd.StringSlice("pragma"),
+			SeedDir:     cmd.String("seeds"),
+		}
+
+		if path := cmd.String("changelog"); path != "" {
+			f, err := os.OpenFile(filepath.Clean(path), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+			if err != nil {
+				return fmt.Errorf("open change log: %w", err)
+			}
+			defer func() { _ = f.Close() }()
+			opts.OnCommit = diff.ChangeLogWriter(f, func(err error) {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			})
+		}
+
+		var created int
+		onCommit := opts.OnCommit
+		opts.OnCommit = func(changes []diff.Change) {
+			created = len(changes)
+			if onCommit != nil {
+				onCommit(changes)
+			}
+		}
+		if err := diff.InitDatabaseContext(ctx, dbPath, cmd.String("schema"), opts); err != nil {
+			return fmt.Errorf("init database: %w", err)
+		}
+		fmt.Printf("Created %s with %d schema objects.\n", dbPath, created)
+		return nil
+	},
+}
+
+var dumpCMD = &cli.Command{
+	Name:  "dump",
 	Usage: "Dump database schema to files",
 	Flags: []cli.Flag{
 		&cli.StringFlag{
@@ -184,47 +925,1888 @@ var dumpCMD = &cli.Command{
 			Value:   "out",
 			Usage:   "Output directory for schema files",
 		},
+		&cli.BoolFlag{
+			Name:  "read-only",
+			Usage: "Open databases read-only (mode=ro, query_only), so inspection can never modify them",
+		},
+		&cli.BoolFlag{
+			Name:  "immutable",
+			Usage: "Like --read-only, and also skip locking; only for files nobody is writing (backups, snapshots)",
+		},
+		&cli.BoolFlag{
+			Name:  "schema-only",
+			Usage: "Like --read-only, and only read schema pages (memory-mapped, no size or CHECK scans); for very large databases",
+		},
+		&cli.BoolFlag{
+			Name:  "anonymize",
+			Usage: "Replace table, column and other names with stable pseudonyms, to share the schema in bug reports",
+		},
+		&cli.StringFlag{
+			Name:    "anonymize-key",
+			Usage:   "Secret salting the pseudonyms of --anonymize, so names cannot be guessed; use the same key for snapshots diffed together",
+			Sources: cli.EnvVars("SQLITE_SCHEMA_DIFF_ANONYMIZE_KEY"),
+		},
 	},
 	Action: func(ctx context.Context, cmd *cli.Command) error {
 		dbPath := cmd.String("database")
 		outputDir := cmd.String("output")
 
-		db, err := sql.Open("sqlite", dbPath)
+		db, err := openDatabase(cmd, dbPath)
 		if err != nil {
-			return fmt.Errorf("open database: %w", err)
+			return err
 		}
 		defer func() { _ = db.Close() }()
 
-		return dumpSchema(db, outputDir)
+		s, err := parser.FromDBContext(ctx, db)
+		if err != nil {
+			return fmt.Errorf("extract schema: %w", err)
+		}
+		if cmd.Bool("anonymize") {
+			if s, err = parser.Anonymize(s, cmd.String("anonymize-key")); err != nil {
+				return err
+			}
+		}
+		return dumpSchema(s, outputDir)
 	},
 }
 
-func showChanges(changes []diff.Change) {
-	for _, c := range changes {
-		symbol := "+"
-		if c.Destructive {
-			symbol = "-"
+var verifyMigrationCMD = &cli.Command{
+	Name:  "verify-migration",
+	Usage: "Check that a migration file turns the database into the declared schema",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:     "database",
+			Aliases:  []string{"db"},
+			Usage:    "Path to SQLite database file the migration would run against",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "schema",
+			Aliases: []string{"s"},
+			Value:   "schema",
+			Usage:   "Path to schema directory containing .sql files",
+		},
+		&cli.BoolFlag{
+			Name:  "offline",
+			Usage: "Parse schema files without executing them (for SQL needing extensions the tool lacks)",
+		},
+		&cli.StringFlag{
+			Name:     "file",
+			Aliases:  []string{"f"},
+			Usage:    "Migration .sql file to verify",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "column-order",
+			Value: string(diff.ColumnOrderStrict),
+			Usage: "Column order policy: strict (recreate to match declared order) or ignore (append new columns)",
+		},
+	}, schemaWalkFlags...),
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		diffOpts, err := diffOptions(cmd)
+		if err != nil {
+			return err
 		}
-		fmt.Printf("[%s] %s: %s\n", symbol, c.Type, c.Description)
-	}
 
-	destructive := 0
-	for _, c := range changes {
-		if c.Destructive {
-			destructive++
+		migration, err := os.ReadFile(filepath.Clean(cmd.String("file")))
+		if err != nil {
+			return fmt.Errorf("read migration: %w", err)
 		}
-	}
-	fmt.Printf("\nTotal changes: %d (%d destructive)\n", len(changes), destructive)
+
+		db, err := diff.OpenReadOnly(cmd.String("database"), false)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = db.Close() }()
+
+		current, err := parser.FromDBContext(ctx, db)
+		if err != nil {
+			return err
+		}
+		target, err := parser.ReadFilesContext(ctx, cmd.String("schema"), diffOpts.Parse)
+		if err != nil {
+			return err
+		}
+
+		residual, err := diff.VerifyMigration(current, target, string(migration), diffOpts)
+		if err != nil {
+			return fmt.Errorf("verify migration: %w", err)
+		}
+		if len(residual) > 0 {
+			fmt.Fprintln(os.Stderr, "Migration drifts from the declared schema, differences remain after running it:")
+			for _, c := range residual {
+				fmt.Fprintf(os.Stderr, "  %s: %s\n", c.Type, c.Description)
+			}
+			return fmt.Errorf("migration drift: %d differences", len(residual))
+		}
+		fmt.Println("Migration verified: running it produces the declared schema.")
+		return nil
+	},
 }
 
-func dumpSchema(db *sql.DB, outputDir string) error {
-	if err := os.MkdirAll(outputDir, 0o750); err != nil {
-		return fmt.Errorf("create output directory: %w", err)
+var statusCMD = &cli.Command{
+	Name:      "status",
+	Usage:     "Show the schema drift of many databases against the schema files",
+	ArgsUsage: "[databases for --matrix]",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:  "db-glob",
+			Usage: "Glob matching the SQLite database files, e.g. 'tenants/*.db'",
+		},
+		&cli.BoolFlag{
+			Name:  "matrix",
+			Usage: "Compare the databases (arguments and --db-glob matches) with each other instead of the schema files",
+		},
+		&cli.StringFlag{
+			Name:    "schema",
+			Aliases: []string{"s"},
+			Value:   "schema",
+			Usage:   "Path to schema directory containing .sql files",
+		},
+		&cli.BoolFlag{
+			Name:  "offline",
+			Usage: "Parse schema files without executing them (for SQL needing extensions the tool lacks)",
+		},
+		&cli.StringFlag{
+			Name:  "column-order",
+			Value: string(diff.ColumnOrderStrict),
+			Usage: "Column order policy: strict (recreate to match declared order) or ignore (append new columns)",
+		},
+		&cli.BoolFlag{
+			Name:  "incremental",
+			Usage: "Compare each object's stored SQL by hash first and only read the columns of tables that differ",
+		},
+	}, schemaWalkFlags...),
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		diffOpts, err := diffOptions(cmd)
+		if err != nil {
+			return err
+		}
+		diffOpts.Incremental = cmd.Bool("incremental")
+
+		if cmd.Bool("matrix") {
+			return statusMatrix(ctx, cmd, diffOpts)
+		}
+		if cmd.String("db-glob") == "" {
+			return fmt.Errorf("--db-glob is required")
+		}
+		paths, err := filepath.Glob(cmd.String("db-glob"))
+		if err != nil {
+			return fmt.Errorf("invalid --db-glob: %w", err)
+		}
+		if len(paths) == 0 {
+			return fmt.Errorf("no databases match %q", cmd.String("db-glob"))
+		}
+
+		// Databases are only inspected, a failure to open one is part of
+		// the report
+		dbs := make(map[string]*sql.DB, len(paths))
+		openErrs := make(map[string]error)
+		for _, path := range paths {
+			db, err := diff.OpenReadOnly(path, false)
+			if err != nil {
+				openErrs[path] = err
+				continue
+			}
+			defer func() { _ = db.Close() }()
+			dbs[path] = db
+		}
+
+		desired := diff.DirSource(cmd.String("schema"), diffOpts.Parse)
+		report, err := diff.CompareManyWithOptions(ctx, dbs, desired, diffOpts)
+		if err != nil {
+			return err
+		}
+		for path, err := range openErrs {
+			report.Databases = append(report.Databases, diff.DatabaseDrift{Name: path, Err: err})
+			report.Summary.Databases++
+			report.Summary.Failed++
+		}
+		slices.SortFunc(report.Databases, func(a, b diff.DatabaseDrift) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+
+		showDriftReport(report)
+		if report.Summary.Failed > 0 {
+			return fmt.Errorf("%d databases could not be compared", report.Summary.Failed)
+		}
+		return nil
+	},
+}
+
+// statusMatrix compares the databases given as arguments and matching
+// --db-glob pairwise
+func statusMatrix(ctx context.Context, cmd *cli.Command, opts diff.DiffOptions) error {
+	paths := cmd.Args().Slice()
+	if pattern := cmd.String("db-glob"); pattern != "" {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid --db-glob: %w", err)
+		}
+		paths = append(paths, matches...)
+	}
+	slices.Sort(paths)
+	paths = slices.Compact(paths)
+	if len(paths) < 2 {
+		return fmt.Errorf("--matrix needs at least two databases, got %d", len(paths))
+	}
+
+	dbs := make(map[string]*sql.DB, len(paths))
+	failed := make(map[string]error)
+	for _, path := range paths {
+		db, err := diff.OpenReadOnly(path, false)
+		if err != nil {
+			failed[path] = err
+			continue
+		}
+		defer func() { _ = db.Close() }()
+		dbs[path] = db
 	}
 
-	s, err := parser.FromDB(db)
+	matrix, err := diff.CompareMatrix(ctx, dbs, opts)
 	if err != nil {
-		return fmt.Errorf("extract schema: %w", err)
+		return err
+	}
+	for path, err := range failed {
+		matrix.Failed[path] = err
+		matrix.Summary.Databases++
+		matrix.Summary.Failed++
+	}
+
+	showMatrix(matrix)
+	if matrix.Summary.Failed > 0 {
+		return fmt.Errorf("%d databases could not be compared", matrix.Summary.Failed)
+	}
+	return nil
+}
+
+var watchCMD = &cli.Command{
+	Name:      "watch",
+	Usage:     "Watch live databases for schema changes made out of band and report them as drift events",
+	ArgsUsage: "<database>...",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:    "schema",
+			Aliases: []string{"s"},
+			Usage:   "Schema directory the databases should match; events then list the changes that restore it",
+		},
+		&cli.StringFlag{
+			Name:  "webhook",
+			Usage: "URL every drift event is POSTed to as JSON",
+		},
+		&cli.DurationFlag{
+			Name:  "interval",
+			Value: 10 * time.Second,
+			Usage: "Time between polls",
+		},
+		&cli.BoolFlag{
+			Name:  "offline",
+			Usage: "Parse schema files without executing them (for SQL needing extensions the tool lacks)",
+		},
+		&cli.StringFlag{
+			Name:  "column-order",
+			Value: string(diff.ColumnOrderStrict),
+			Usage: "Column order policy: strict (recreate to match declared order) or ignore (append new columns)",
+		},
+	}, schemaWalkFlags...),
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		diffOpts, err := diffOptions(cmd)
+		if err != nil {
+			return err
+		}
+		paths := cmd.Args().Slice()
+		if len(paths) == 0 {
+			return fmt.Errorf("no databases to watch")
+		}
+
+		return diff.Watch(ctx, paths, diff.WatchOptions{
+			DiffOptions: diffOpts,
+			SchemaDir:   cmd.String("schema"),
+			Interval:    cmd.Duration("interval"),
+			WebhookURL:  cmd.String("webhook"),
+			OnDrift:     showDriftEvent,
+			OnError: func(path string, err error) {
+				fmt.Fprintf(os.Stderr, "%s %s: %v\n", time.Now().UTC().Format(time.RFC3339), path, err)
+			},
+		})
+	},
+}
+
+var historyCMD = &cli.Command{
+	Name:      "history",
+	Usage:     "Reconstruct the schema changes made between the backups of a database, and since the last one",
+	ArgsUsage: "[database]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "backups",
+			Usage:    "Glob matching the backup files, e.g. 'backups/app-*.db'; they are ordered by modification time",
+			Required: true,
+		},
+		&cli.IntFlag{
+			Name:  "last",
+			Usage: "Only use the N most recent backups (0 = all)",
+		},
+		&cli.StringFlag{
+			Name:  "column-order",
+			Value: string(diff.ColumnOrderStrict),
+			Usage: "Column order policy: strict (recreate to match declared order) or ignore (append new columns)",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "Output format: text or json",
+		},
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		diffOpts, err := diffOptions(cmd)
+		if err != nil {
+			return err
+		}
+		format := cmd.String("format")
+		if format != "text" && format != "json" {
+			return fmt.Errorf("invalid --format %q: must be text or json", format)
+		}
+
+		paths, err := filepath.Glob(cmd.String("backups"))
+		if err != nil {
+			return fmt.Errorf("invalid --backups: %w", err)
+		}
+		if len(paths) == 0 {
+			return fmt.Errorf("no backups match %q", cmd.String("backups"))
+		}
+		var snapshots []diff.Snapshot
+		for _, path := range paths {
+			s, err := diff.FileSnapshot(path, true)
+			if err != nil {
+				return err
+			}
+			snapshots = append(snapshots, s)
+		}
+		slices.SortStableFunc(snapshots, func(a, b diff.Snapshot) int { return a.Time.Compare(b.Time) })
+		if n := cmd.Int("last"); n > 0 && n < len(snapshots) {
+			snapshots = snapshots[len(snapshots)-n:]
+		}
+		if cmd.Args().Len() > 0 {
+			s, err := diff.FileSnapshot(cmd.Args().First(), false)
+			if err != nil {
+				return err
+			}
+			s.Time = time.Now() // The live database is the end of the timeline
+			snapshots = append(snapshots, s)
+		}
+		if len(snapshots) < 2 {
+			return fmt.Errorf("need at least two snapshots, got %d", len(snapshots))
+		}
+
+		steps, err := diff.Timeline(ctx, snapshots, diffOpts)
+		if err != nil {
+			return err
+		}
+		if format == "json" {
+			out, err := json.MarshalIndent(steps, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			return nil
+		}
+		showTimeline(steps)
+		return nil
+	},
+}
+
+var agentCMD = &cli.Command{
+	Name:  "agent",
+	Usage: "Keep a database in line with a signed schema published at a URL, applying non-destructive changes",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:     "database",
+			Aliases:  []string{"db"},
+			Usage:    "Path to SQLite database file",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "schema-url",
+			Usage:    "URL of the schema SQL; its base64 Ed25519 signature is fetched from <url>.sig",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "public-key",
+			Usage:    "Base64 Ed25519 public key the schema must be signed with, or @file to read it from a file",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "cache-dir",
+			Usage: "Directory keeping the last verified schema, used while the URL is unreachable (default: <database>.agent)",
+		},
+		&cli.StringFlag{
+			Name:  "report-url",
+			Usage: "URL the status of each pass is POSTed to as JSON",
+		},
+		&cli.StringFlag{
+			Name:  "name",
+			Usage: "Name of the database in status reports (default: host name)",
+		},
+		&cli.DurationFlag{
+			Name:  "interval",
+			Value: 5 * time.Minute,
+			Usage: "Time between reconciliations",
+		},
+		&cli.BoolFlag{
+			Name:  "once",
+			Usage: "Reconcile once and exit",
+		},
+		&cli.BoolFlag{
+			Name:  "offline",
+			Usage: "Parse schema files without executing them (for SQL needing extensions the tool lacks)",
+		},
+		&cli.StringFlag{
+			Name:  "column-order",
+			Value: string(diff.ColumnOrderStrict),
+			Usage: "Column order policy: strict (recreate to match declared order) or ignore (append new columns)",
+		},
+		&cli.BoolFlag{
+			Name:  "backup",
+			Usage: "Create backup before applying changes",
+			Value: true,
+		},
+	}, schemaWalkFlags...),
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		dbPath := cmd.String("database")
+
+		diffOpts, err := diffOptions(cmd)
+		if err != nil {
+			return err
+		}
+		key, err := readPublicKey(cmd.String("public-key"))
+		if err != nil {
+			return err
+		}
+
+		name := cmd.String("name")
+		if name == "" {
+			name, _ = os.Hostname()
+		}
+		cacheDir := cmd.String("cache-dir")
+		if cacheDir == "" {
+			cacheDir = dbPath + ".agent"
+		}
+		backupPath := ""
+		if cmd.Bool("backup") {
+			backupPath = dbPath + ".backup"
+		}
+
+		db, err := sql.Open("sqlite", dbPath)
+		if err != nil {
+			return fmt.Errorf("open database: %w", err)
+		}
+		defer func() { _ = db.Close() }()
+
+		opts := diff.AgentOptions{
+			ApplyOptions: diff.ApplyOptions{
+				DiffOptions: diffOpts,
+				BackupPath:  backupPath,
+			},
+			Name:      name,
+			SchemaURL: cmd.String("schema-url"),
+			PublicKey: key,
+			CacheDir:  cacheDir,
+			ReportURL: cmd.String("report-url"),
+			Interval:  cmd.Duration("interval"),
+			OnReconcile: func(status diff.AgentStatus, err error) {
+				showAgentStatus(status, err)
+			},
+		}
+
+		if cmd.Bool("once") {
+			status, err := diff.Reconcile(ctx, db, opts)
+			showAgentStatus(status, err)
+			return err
+		}
+		return diff.RunAgent(ctx, db, opts)
+	},
+}
+
+// Reconcile policies
+const (
+	policySafe  = "safe"  // Apply non-destructive changes, fail if destructive ones remain
+	policyAll   = "all"   // Apply every change
+	policyCheck = "check" // Apply nothing, fail unless in sync
+)
+
+var reconcileCMD = &cli.Command{
+	Name:  "reconcile",
+	Usage: "Bring a database in line with the schema once and exit 0 only when in sync; configurable through environment variables, for init containers",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "database",
+			Aliases:  []string{"db"},
+			Usage:    "Path or DSN of the SQLite database, created if missing",
+			Sources:  cli.EnvVars("SQLITE_SCHEMA_DIFF_DATABASE"),
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "schema",
+			Aliases: []string{"s"},
+			Value:   "schema",
+			Usage:   "Path to schema directory containing .sql files",
+			Sources: cli.EnvVars("SQLITE_SCHEMA_DIFF_SCHEMA"),
+		},
+		&cli.StringFlag{
+			Name:    "policy",
+			Value:   policySafe,
+			Usage:   "safe (apply non-destructive changes), all (apply every change) or check (apply nothing)",
+			Sources: cli.EnvVars("SQLITE_SCHEMA_DIFF_POLICY"),
+		},
+		&cli.StringFlag{
+			Name:    "backup-path",
+			Usage:   "Back up the database here before applying changes",
+			Sources: cli.EnvVars("SQLITE_SCHEMA_DIFF_BACKUP_PATH"),
+		},
+		&cli.StringFlag{
+			Name:    "ready-file",
+			Usage:   "File created once the schema is in sync, for readiness probes",
+			Sources: cli.EnvVars("SQLITE_SCHEMA_DIFF_READY_FILE"),
+		},
+		&cli.BoolFlag{
+			Name:    "offline",
+			Usage:   "Parse schema files without executing them (for SQL needing extensions the tool lacks)",
+			Sources: cli.EnvVars("SQLITE_SCHEMA_DIFF_OFFLINE"),
+		},
+		&cli.BoolFlag{
+			Name:    "follow-symlinks",
+			Usage:   "Read symlinked files and directories below the schema directory",
+			Sources: cli.EnvVars("SQLITE_SCHEMA_DIFF_FOLLOW_SYMLINKS"),
+		},
+		&cli.BoolFlag{
+			Name:    "include-hidden",
+			Usage:   "Read hidden files and directories (like .git) below the schema directory",
+			Sources: cli.EnvVars("SQLITE_SCHEMA_DIFF_INCLUDE_HIDDEN"),
+		},
+		&cli.IntFlag{
+			Name:    "max-depth",
+			Usage:   "Only read files this many levels below the schema directory (1 = top level only, 0 = no limit)",
+			Sources: cli.EnvVars("SQLITE_SCHEMA_DIFF_MAX_DEPTH"),
+		},
+		&cli.StringFlag{
+			Name:    "column-order",
+			Value:   string(diff.ColumnOrderStrict),
+			Usage:   "Column order policy: strict (recreate to match declared order) or ignore (append new columns)",
+			Sources: cli.EnvVars("SQLITE_SCHEMA_DIFF_COLUMN_ORDER"),
+		},
+		&cli.StringFlag{
+			Name:    "target-version",
+			Usage:   "SQLite version the migration will run on, e.g. 3.35.5 (default: detected from the database)",
+			Sources: cli.EnvVars("SQLITE_SCHEMA_DIFF_TARGET_VERSION"),
+		},
+		&cli.StringFlag{
+			Name:    "plan-cache",
+			Usage:   "Cache plans in this directory, so replicas starting after the first one skip planning",
+			Sources: cli.EnvVars("SQLITE_SCHEMA_DIFF_PLAN_CACHE"),
+		},
+		&cli.StringFlag{
+			Name:    "window",
+			Usage:   "Only apply destructive changes inside these maintenance windows, e.g. 'Sat,Sun 00:00-06:00; Mon-Fri 02:00-04:00' (local time)",
+			Sources: cli.EnvVars("SQLITE_SCHEMA_DIFF_WINDOW"),
+		},
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		schemaDir := cmd.String("schema")
+		planCache := cmd.String("plan-cache")
+		policy := cmd.String("policy")
+		switch policy {
+		case policySafe, policyAll, policyCheck:
+		default:
+			return fmt.Errorf("invalid --policy %q: must be safe, all or check", policy)
+		}
+
+		diffOpts, err := diffOptions(cmd)
+		if err != nil {
+			return err
+		}
+		windows, err := diff.ParseWindows(cmd.String("window"))
+		if err != nil {
+			return fmt.Errorf("invalid --window: %w", err)
+		}
+
+		// A ready file left by an earlier run must not signal this one
+		readyFile := cmd.String("ready-file")
+		if readyFile != "" {
+			if err := os.Remove(readyFile); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("remove ready file: %w", err)
+			}
+		}
+
+		db, err := sql.Open("sqlite", cmd.String("database"))
+		if err != nil {
+			return fmt.Errorf("open database: %w", err)
+		}
+		defer func() { _ = db.Close() }()
+
+		compare := func() ([]diff.Change, error) {
+			if planCache != "" {
+				return diff.CompareCached(db, schemaDir, diffOpts, planCache)
+			}
+			return diff.CompareContext(ctx, db, schemaDir, diffOpts)
+		}
+
+		changes, err := compare()
+		if err != nil {
+			return err
+		}
+		destructive := 0
+		for _, c := range changes {
+			if c.Destructive {
+				destructive++
+			}
+		}
+		fmt.Printf("reconcile: %d changes planned (%d destructive), policy %s\n", len(changes), destructive, policy)
+
+		if len(changes) > 0 && policy != policyCheck {
+			skipDestructive := policy == policySafe
+			if !skipDestructive && diff.CheckWindows(changes, windows, time.Now()) != nil {
+				fmt.Println("reconcile: outside the maintenance windows, destructive changes postponed")
+				skipDestructive = true
+			}
+			opts := diff.ApplyOptions{
+				DiffOptions:     diffOpts,
+				SkipDestructive: skipDestructive,
+				BackupPath:      cmd.String("backup-path"),
+				PlanCacheDir:    planCache,
+				OnCommit: func(committed []diff.Change) {
+					for _, c := range committed {
+						fmt.Printf("reconcile: applied %s: %s\n", c.Type, c.Description)
+					}
+				},
+			}
+			if err := diff.ApplyContext(ctx, db, schemaDir, opts); err != nil {
+				return fmt.Errorf("apply changes: %w", err)
+			}
+		}
+
+		remaining, err := compare()
+		if err != nil {
+			return err
+		}
+		if len(remaining) > 0 {
+			for _, c := range remaining {
+				fmt.Printf("reconcile: pending %s: %s\n", c.Type, c.Description)
+			}
+			return fmt.Errorf("not ready: %d schema changes pending", len(remaining))
+		}
+
+		if readyFile != "" {
+			if err := os.WriteFile(filepath.Clean(readyFile), []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0o644); err != nil {
+				return fmt.Errorf("write ready file: %w", err)
+			}
+		}
+		fmt.Println("reconcile: ready, schema in sync")
+		return nil
+	},
+}
+
+var mcpCMD = &cli.Command{
+	Name:  "mcp",
+	Usage: "Serve diff, explain, lint, verify_plan and apply as Model Context Protocol tools on stdin/stdout",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:    "database",
+			Aliases: []string{"db"},
+			Usage:   "Default SQLite database of the tools",
+		},
+		&cli.StringFlag{
+			Name:    "schema",
+			Aliases: []string{"s"},
+			Value:   "schema",
+			Usage:   "Default schema directory of the tools",
+		},
+		&cli.StringSliceFlag{
+			Name:  "allow-database",
+			Usage: "Further database the tools may use besides --database; can be repeated",
+		},
+		&cli.StringSliceFlag{
+			Name:  "allow-schema",
+			Usage: "Further schema directory the tools may use besides --schema; can be repeated",
+		},
+		&cli.BoolFlag{
+			Name:  "allow-apply",
+			Usage: "Let the apply tool write to databases; without it apply only dry-runs",
+		},
+		&cli.BoolFlag{
+			Name:  "offline",
+			Usage: "Parse schema files without executing them (for SQL needing extensions the tool lacks)",
+		},
+		&cli.StringFlag{
+			Name:  "column-order",
+			Value: string(diff.ColumnOrderStrict),
+			Usage: "Column order policy: strict (recreate to match declared order) or ignore (append new columns)",
+		},
+		&cli.StringFlag{
+			Name:  "target-version",
+			Usage: "SQLite version the migration will run on, e.g. 3.35.5 (default: detected from the database)",
+		},
+	}, schemaWalkFlags...),
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		diffOpts, err := diffOptions(cmd)
+		if err != nil {
+			return err
+		}
+		s := &mcpServer{
+			database:   cmd.String("database"),
+			schema:     cmd.String("schema"),
+			opts:       diffOpts,
+			rules:      diff.NamingRules{SnakeCaseTables: true, IndexPattern: "idx_{table}_{columns}"}, // The defaults of lint
+			allowApply: cmd.Bool("allow-apply"),
+			databases:  cmd.StringSlice("allow-database"),
+			schemas:    cmd.StringSlice("allow-schema"),
+		}
+		return s.serve(ctx, os.Stdin, os.Stdout)
+	},
+}
+
+var adoptCMD = &cli.Command{
+	Name:  "adopt",
+	Usage: "Rewrite the CREATE statements in the schema files to the text SQLite stores, so later diffs stay clean",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "database",
+			Aliases:  []string{"db"},
+			Usage:    "Path to SQLite database file, already in sync with the schema",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "schema",
+			Aliases: []string{"s"},
+			Value:   "schema",
+			Usage:   "Path to schema directory containing .sql files",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "List the files that would be rewritten without writing them",
+		},
+		&cli.BoolFlag{
+			Name:  "offline",
+			Usage: "Parse schema files without executing them (for SQL needing extensions the tool lacks)",
+		},
+		&cli.StringFlag{
+			Name:  "column-order",
+			Value: string(diff.ColumnOrderStrict),
+			Usage: "Column order policy: strict (recreate to match declared order) or ignore (append new columns)",
+		},
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		schemaDir := cmd.String("schema")
+		diffOpts, err := diffOptions(cmd)
+		if err != nil {
+			return err
+		}
+
+		db, err := diff.OpenReadOnly(cmd.String("database"), false)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = db.Close() }()
+
+		// Only text that means the same as the schema files may replace them
+		changes, err := diff.CompareContext(ctx, db, schemaDir, diffOpts)
+		if err != nil {
+			return err
+		}
+		if len(changes) > 0 {
+			return fmt.Errorf("database is not in sync with the schema (%d changes pending), apply first", len(changes))
+		}
+
+		current, err := parser.FromDBContext(ctx, db)
+		if err != nil {
+			return err
+		}
+		adopted, err := parser.Adopt(schemaDir, current)
+		if err != nil {
+			return err
+		}
+		if len(adopted) == 0 {
+			fmt.Println("Schema files already match the database.")
+			return nil
+		}
+
+		for _, path := range slices.Sorted(maps.Keys(adopted)) {
+			if cmd.Bool("dry-run") {
+				fmt.Printf("Would rewrite %s\n", path)
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(path, []byte(adopted[path]), info.Mode().Perm()); err != nil {
+				return fmt.Errorf("write %s: %w", path, err)
+			}
+			fmt.Printf("Rewrote %s\n", path)
+		}
+		return nil
+	},
+}
+
+var fmtCMD = &cli.Command{
+	Name:      "fmt",
+	Usage:     "Format schema files to a canonical style, keeping comments",
+	ArgsUsage: "[schema directories or .sql files]",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:    "schema",
+			Aliases: []string{"s"},
+			Value:   "schema",
+			Usage:   "Path to schema directory containing .sql files, used when no paths are given",
+		},
+		&cli.BoolFlag{
+			Name:  "check",
+			Usage: "List the files that are not formatted and fail instead of rewriting them (for CI)",
+		},
+		&cli.StringFlag{
+			Name:  "keyword-case",
+			Value: "upper",
+			Usage: "Keyword case: upper, lower or preserve",
+		},
+		&cli.StringFlag{
+			Name:  "indent",
+			Value: "2",
+			Usage: "Indentation of column definitions and trigger bodies: a number of spaces or tab",
+		},
+		&cli.StringFlag{
+			Name:  "comma",
+			Value: "trailing",
+			Usage: "Comma placement between column definitions: trailing or leading",
+		},
+	}, schemaWalkFlags...),
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		opts, err := formatOptions(cmd)
+		if err != nil {
+			return err
+		}
+		paths := cmd.Args().Slice()
+		if len(paths) == 0 {
+			paths = []string{cmd.String("schema")}
+		}
+
+		var files []string
+		for _, p := range paths {
+			info, err := os.Stat(p)
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				files = append(files, p)
+				continue
+			}
+			_, found, err := parser.SchemaFiles(p, parseOptions(cmd))
+			if err != nil {
+				return fmt.Errorf("read schema directory %s: %w", p, err)
+			}
+			for _, f := range found {
+				files = append(files, filepath.Join(p, filepath.FromSlash(f)))
+			}
+		}
+
+		var unformatted []string
+		for _, path := range files {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			formatted, err := parser.Format(string(data), opts)
+			if err != nil {
+				return fmt.Errorf("format %s: %w", path, err)
+			}
+			if formatted == string(data) {
+				continue
+			}
+			if cmd.Bool("check") {
+				fmt.Println(path)
+				unformatted = append(unformatted, path)
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(path, []byte(formatted), info.Mode().Perm()); err != nil {
+				return fmt.Errorf("write %s: %w", path, err)
+			}
+			fmt.Printf("Formatted %s\n", path)
+		}
+		if len(unformatted) > 0 {
+			return fmt.Errorf("%d schema files are not formatted, run sqlite-schema-diff fmt", len(unformatted))
+		}
+		return nil
+	},
+}
+
+// formatOptions builds format options from the fmt flags
+func formatOptions(cmd *cli.Command) (parser.FormatOptions, error) {
+	var opts parser.FormatOptions
+
+	switch c := cmd.String("keyword-case"); c {
+	case "upper":
+		opts.KeywordCase = parser.KeywordUpper
+	case "lower", "preserve":
+		opts.KeywordCase = parser.KeywordCase(c)
+	default:
+		return opts, fmt.Errorf("invalid --keyword-case %q: must be upper, lower or preserve", c)
+	}
+
+	switch indent := cmd.String("indent"); indent {
+	case "tab":
+		opts.Indent = "\t"
+	default:
+		n, err := strconv.Atoi(indent)
+		if err != nil || n < 1 || n > 8 {
+			return opts, fmt.Errorf("invalid --indent %q: must be 1 to 8 spaces or tab", indent)
+		}
+		opts.Indent = strings.Repeat(" ", n)
+	}
+
+	switch comma := cmd.String("comma"); comma {
+	case "trailing":
+	case "leading":
+		opts.LeadingCommas = true
+	default:
+		return opts, fmt.Errorf("invalid --comma %q: must be trailing or leading", comma)
+	}
+	return opts, nil
+}
+
+var lintCMD = &cli.Command{
+	Name:  "lint",
+	Usage: "Check the names in the schema files against naming conventions",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:    "schema",
+			Aliases: []string{"s"},
+			Value:   "schema",
+			Usage:   "Path to schema directory containing .sql files",
+		},
+		&cli.BoolFlag{
+			Name:  "offline",
+			Usage: "Parse schema files without executing them (for SQL needing extensions the tool lacks)",
+		},
+		&cli.BoolFlag{
+			Name:  "snake-case",
+			Value: true,
+			Usage: "Require snake_case table names",
+		},
+		&cli.StringFlag{
+			Name:  "index-pattern",
+			Value: "idx_{table}_{columns}",
+			Usage: "Required index name, {table} and {columns} are replaced (empty to allow any)",
+		},
+		&cli.StringFlag{
+			Name:  "trigger-prefix",
+			Usage: "Required trigger name prefix, e.g. trg_",
+		},
+		&cli.StringFlag{
+			Name:  "table-number",
+			Usage: "Require singular or plural table names",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "Output format: text, json or sarif (for GitHub code scanning)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "rules-plugin",
+			Usage: "Go plugin (.so) registering custom lint rules with diff.RegisterLintRule; can be repeated",
+		},
+		&cli.StringFlag{
+			Name:  "policies",
+			Usage: "Policy file of expressions over the schema; matching policies are reported as issues",
+		},
+	}, schemaWalkFlags...),
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		for _, path := range cmd.StringSlice("rules-plugin") {
+			// The plugin's init functions register its rules
+			if _, err := plugin.Open(path); err != nil {
+				return fmt.Errorf("load rules plugin: %w", err)
+			}
+		}
+		rules := diff.NamingRules{
+			SnakeCaseTables: cmd.Bool("snake-case"),
+			IndexPattern:    cmd.String("index-pattern"),
+			TriggerPrefix:   cmd.String("trigger-prefix"),
+		}
+		switch number := diff.TableNumber(cmd.String("table-number")); number {
+		case diff.TableNumberAny, diff.TableNumberSingular, diff.TableNumberPlural:
+			rules.TableNumber = number
+		default:
+			return fmt.Errorf("invalid --table-number %q: must be singular or plural", number)
+		}
+
+		target, err := parser.ReadFilesWithOptions(cmd.String("schema"), parseOptions(cmd))
+		if err != nil {
+			return err
+		}
+		issues := diff.Lint(target, rules)
+		locs, err := parser.Locations(cmd.String("schema"), parseOptions(cmd))
+		if err != nil {
+			return err
+		}
+		diff.LocateIssues(issues, locs)
+		if path := cmd.String("policies"); path != "" {
+			policies, err := diff.LoadPolicies(path)
+			if err != nil {
+				return err
+			}
+			matched, err := diff.MatchPolicies(policies, nil, target)
+			if err != nil {
+				return err
+			}
+			for _, p := range matched {
+				issues = append(issues, diff.LintIssue{
+					Rule:    "policy-" + string(p.Action),
+					Object:  "policy",
+					Message: cmp.Or(p.Message, p.Expr),
+					File:    path,
+					Line:    p.Line,
+				})
+			}
+		}
+
+		switch format := cmd.String("format"); format {
+		case "sarif":
+			if err := diff.WriteSARIF(os.Stdout, issues, Version); err != nil {
+				return err
+			}
+		case "json":
+			if issues == nil {
+				issues = []diff.LintIssue{}
+			}
+			out, err := json.MarshalIndent(issues, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+		case "text":
+			for _, issue := range issues {
+				if issue.File != "" {
+					fmt.Printf("%s:%d: ", issue.File, issue.Line)
+				}
+				fmt.Println(issue)
+			}
+		default:
+			return fmt.Errorf("invalid --format %q: must be text, json or sarif", format)
+		}
+		if len(issues) > 0 {
+			return fmt.Errorf("%d naming issues", len(issues))
+		}
+		return nil
+	},
+}
+
+var importCMD = &cli.Command{
+	Name:      "import",
+	Usage:     "Load CSV or JSONL files into tables, converting values to the column types of the schema",
+	ArgsUsage: "<files...>",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:     "database",
+			Aliases:  []string{"db"},
+			Usage:    "Path to SQLite database file",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "schema",
+			Aliases: []string{"s"},
+			Value:   "schema",
+			Usage:   "Path to schema directory containing .sql files",
+		},
+		&cli.BoolFlag{
+			Name:  "offline",
+			Usage: "Parse schema files without executing them (for SQL needing extensions the tool lacks)",
+		},
+		&cli.StringFlag{
+			Name:  "table",
+			Usage: "Table to load every file into (default: the file name without extension)",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "File format: csv or jsonl (default: from the file extension)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "map",
+			Usage: "Load a file field into a differently named column, as field=column (repeatable)",
+		},
+	}, schemaWalkFlags...),
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		files := cmd.Args().Slice()
+		if len(files) == 0 {
+			return fmt.Errorf("no files to import")
+		}
+		opts := diff.ImportOptions{Table: cmd.String("table")}
+		switch format := diff.ExportFormat(cmd.String("format")); format {
+		case "", "csv":
+		case diff.ExportJSONL:
+			opts.Format = format
+		default:
+			return fmt.Errorf("invalid --format %q: must be csv or jsonl", format)
+		}
+		for _, m := range cmd.StringSlice("map") {
+			field, column, ok := strings.Cut(m, "=")
+			if !ok || field == "" || column == "" {
+				return fmt.Errorf("invalid --map %q: must be field=column", m)
+			}
+			if opts.Columns == nil {
+				opts.Columns = map[string]string{}
+			}
+			opts.Columns[field] = column
+		}
+
+		target, err := parser.ReadFilesWithOptions(cmd.String("schema"), parseOptions(cmd))
+		if err != nil {
+			return err
+		}
+		db, err := openExisting(cmd.String("database"))
+		if err != nil {
+			return err
+		}
+		defer func() { _ = db.Close() }()
+
+		for _, file := range files {
+			n, err := diff.ImportFile(db, target, file, opts)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Imported %d rows from %s\n", n, file)
+		}
+		return nil
+	},
+}
+
+var debugCMD = &cli.Command{
+	Name:  "debug",
+	Usage: "Tools for reporting bugs",
+	Commands: []*cli.Command{
+		{
+			Name:  "bundle",
+			Usage: "Package schemas, plan, versions and pragmas into one archive to attach to a bug report",
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:     "database",
+					Aliases:  []string{"db"},
+					Usage:    "Path to SQLite database file, opened read-only",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:    "schema",
+					Aliases: []string{"s"},
+					Value:   "schema",
+					Usage:   "Path to schema directory containing .sql files",
+				},
+				&cli.BoolFlag{
+					Name:  "offline",
+					Usage: "Parse schema files without executing them (for SQL needing extensions the tool lacks)",
+				},
+				&cli.StringFlag{
+					Name:  "column-order",
+					Value: string(diff.ColumnOrderStrict),
+					Usage: "Column order policy: strict (recreate to match declared order) or ignore (append new columns)",
+				},
+				&cli.StringFlag{
+					Name:  "target-version",
+					Usage: "SQLite version the migration will run on, e.g. 3.35.5 (default: detected from the database)",
+				},
+				&cli.StringFlag{
+					Name:    "output",
+					Aliases: []string{"o"},
+					Value:   "sqlite-schema-diff-bundle.tar.gz",
+					Usage:   "Path of the archive to write",
+				},
+				&cli.BoolFlag{
+					Name:  "anonymize",
+					Usage: "Replace table, column and other names with pseudonyms",
+				},
+				&cli.StringFlag{
+					Name:    "anonymize-key",
+					Usage:   "Secret salting the pseudonyms of --anonymize (default: random per bundle)",
+					Sources: cli.EnvVars("SQLITE_SCHEMA_DIFF_ANONYMIZE_KEY"),
+				},
+			}, schemaWalkFlags...),
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				diffOpts, err := diffOptions(cmd)
+				if err != nil {
+					return err
+				}
+				target, err := parser.ReadFilesWithOptions(cmd.String("schema"), diffOpts.Parse)
+				if err != nil {
+					return err
+				}
+				db, err := diff.OpenReadOnly(cmd.String("database"), false)
+				if err != nil {
+					return err
+				}
+				defer func() { _ = db.Close() }()
+
+				output := cmd.String("output")
+				f, err := os.OpenFile(filepath.Clean(output), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+				if err != nil {
+					return fmt.Errorf("create bundle: %w", err)
+				}
+				err = diff.WriteBundleContext(ctx, f, db, target, diff.BundleOptions{
+					Diff:         diffOpts,
+					ToolVersion:  Version,
+					Anonymize:    cmd.Bool("anonymize"),
+					AnonymizeKey: cmd.String("anonymize-key"),
+				})
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Wrote %s\n", output)
+				return nil
+			},
+		},
+	},
+}
+
+var changeTypesCMD = &cli.Command{
+	Name:  "change-types",
+	Usage: "List the change types plans can contain, with display name, destructiveness, severity, reversibility and apply order",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "format",
+			Value: "text",
+			Usage: "Output format: text or json",
+		},
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		types := diff.ChangeTypes()
+		switch format := cmd.String("format"); format {
+		case "json":
+			out, err := json.MarshalIndent(types, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+		case "text":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "TYPE\tNAME\tOBJECT\tACTION\tDESTRUCTIVE\tSEVERITY\tREVERSIBLE\tPRIORITY")
+			for _, t := range types {
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%v\t%s\t%v\t%d\n",
+					t.Type, t.Name, cmp.Or(t.Object, "-"), t.Action, t.Destructive, t.Severity, t.Reversible, t.Priority)
+			}
+			return w.Flush()
+		default:
+			return fmt.Errorf("invalid --format %q: must be text or json", format)
+		}
+		return nil
+	},
+}
+
+var codegenCMD = &cli.Command{
+	Name:  "codegen",
+	Usage: "Generate type definitions for the rows of every table from the schema files",
+	Commands: []*cli.Command{
+		{
+			Name:   "typescript",
+			Usage:  "Write TypeScript interfaces for every table and a DB interface of all tables",
+			Flags:  codegenFlags,
+			Action: codegenAction(diff.WriteTypeScript),
+		},
+		{
+			Name:   "jsonschema",
+			Usage:  "Write a JSON Schema with a definition for the rows of every table",
+			Flags:  codegenFlags,
+			Action: codegenAction(diff.WriteJSONSchema),
+		},
+	},
+}
+
+var codegenFlags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:    "schema",
+		Aliases: []string{"s"},
+		Value:   "schema",
+		Usage:   "Path to schema directory containing .sql files",
+	},
+	&cli.BoolFlag{
+		Name:  "offline",
+		Usage: "Parse schema files without executing them (for SQL needing extensions the tool lacks)",
+	},
+	&cli.StringFlag{
+		Name:    "database",
+		Aliases: []string{"db"},
+		Usage:   "Generate from the schema of this database instead of the schema files",
+	},
+	&cli.StringFlag{
+		Name:    "output",
+		Aliases: []string{"o"},
+		Usage:   "File to write (default: stdout)",
+	},
+}, schemaWalkFlags...)
+
+// codegenAction reads the schema selected by the codegen flags and writes
+// it with write
+func codegenAction(write func(io.Writer, *schema.Database) error) cli.ActionFunc {
+	return func(ctx context.Context, cmd *cli.Command) (err error) {
+		var s *schema.Database
+		if dbPath := cmd.String("database"); dbPath != "" {
+			db, err := diff.OpenSchemaOnly(dbPath, false)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = db.Close() }()
+			if s, err = parser.FromDBContext(ctx, db); err != nil {
+				return fmt.Errorf("extract schema: %w", err)
+			}
+		} else if s, err = parser.ReadFilesWithOptions(cmd.String("schema"), parseOptions(cmd)); err != nil {
+			return fmt.Errorf("parse schema files: %w", err)
+		}
+
+		path := cmd.String("output")
+		if path == "" {
+			return write(os.Stdout, s)
+		}
+		f, err := os.Create(filepath.Clean(path))
+		if err != nil {
+			return fmt.Errorf("create %s: %w", path, err)
+		}
+		defer func() {
+			err = errors.Join(err, f.Close())
+		}()
+		if err := write(f, s); err != nil {
+			return fmt.Errorf("write %s: %w", path, err)
+		}
+		fmt.Printf("Wrote %s\n", path)
+		return nil
+	}
+}
+
+// openExisting opens a database file that must already exist, so that a
+// mistyped path is reported instead of silently creating an empty database
+func openExisting(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	return db, nil
+}
+
+// readOnly reports whether --read-only, --immutable or --schema-only was given
+func readOnly(cmd *cli.Command) bool {
+	return cmd.Bool("read-only") || cmd.Bool("immutable") || cmd.Bool("schema-only")
+}
+
+// openReadOnly opens a database read-only as selected by the flags
+func openReadOnly(cmd *cli.Command, path string) (*sql.DB, error) {
+	if cmd.Bool("schema-only") {
+		return diff.OpenSchemaOnly(path, cmd.Bool("immutable"))
+	}
+	return diff.OpenReadOnly(path, cmd.Bool("immutable"))
+}
+
+// openInspected opens an existing database for inspection, read-only if
+// requested
+func openInspected(cmd *cli.Command, path string) (*sql.DB, error) {
+	if readOnly(cmd) {
+		return openReadOnly(cmd, path)
+	}
+	return openExisting(path)
+}
+
+// openDatabase opens the --database of diff and dump, which may not exist
+// yet unless it is opened read-only
+func openDatabase(cmd *cli.Command, path string) (*sql.DB, error) {
+	if readOnly(cmd) {
+		return openReadOnly(cmd, path)
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	return db, nil
+}
+
+// readSnapshot reads the schema of --from or --to: a database, or a
+// directory of schema files such as an anonymized dump. Only a database is
+// returned open, schema files have no data.
+func readSnapshot(ctx context.Context, cmd *cli.Command, path string, opts parser.Options) (*schema.Database, *sql.DB, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		s, err := parser.ReadFilesWithOptions(path, opts)
+		return s, nil, err
+	}
+
+	db, err := openInspected(cmd, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := parser.FromDBContext(ctx, db)
+	if err != nil {
+		_ = db.Close()
+		return nil, nil, err
+	}
+	return s, db, nil
+}
+
+// readPlanFile reads a saved plan and validates it against the database
+func readPlanFile(ctx context.Context, db *sql.DB, path string) ([]diff.Change, error) {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("open plan file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	pf, err := diff.ReadPlanFile(f)
+	if err != nil {
+		return nil, err
+	}
+	if err := pf.ValidateContext(ctx, db); err != nil {
+		return nil, fmt.Errorf("invalid plan file %s: %w", path, err)
+	}
+	return pf.Changes, nil
+}
+
+// schemaWalkFlags control which files below the schema directory are read
+var schemaWalkFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "follow-symlinks",
+		Usage: "Read symlinked files and directories below the schema directory",
+	},
+	&cli.BoolFlag{
+		Name:  "include-hidden",
+		Usage: "Read hidden files and directories (like .git) below the schema directory",
+	},
+	&cli.IntFlag{
+		Name:  "max-depth",
+		Usage: "Only read files this many levels below the schema directory (1 = top level only, 0 = no limit)",
+	},
+}
+
+// diffOptions builds diff options from the shared diff/apply flags
+func diffOptions(cmd *cli.Command) (diff.DiffOptions, error) {
+	var opts diff.DiffOptions
+
+	switch policy := diff.ColumnOrderPolicy(cmd.String("column-order")); policy {
+	case diff.ColumnOrderStrict, diff.ColumnOrderIgnore:
+		opts.ColumnOrder = policy
+	default:
+		return opts, fmt.Errorf("invalid --column-order %q: must be strict or ignore", policy)
+	}
+	opts.TargetVersion = cmd.String("target-version")
+	opts.PreserveRowids = cmd.Bool("preserve-rowids")
+	opts.Parse = parseOptions(cmd)
+	opts.OnWarning = printWarning
+	if dir := cmd.String("schema"); dir != "" {
+		partitions, err := diff.LoadPartitions(dir)
+		if err != nil {
+			return opts, fmt.Errorf("load partitions: %w", err)
+		}
+		opts.Partitions = partitions
+	}
+
+	return opts, nil
+}
+
+// parseOptions builds parse options from --offline and the walk flags
+func parseOptions(cmd *cli.Command) parser.Options {
+	return parser.Options{
+		Offline:        cmd.Bool("offline"),
+		FollowSymlinks: cmd.Bool("follow-symlinks"),
+		IncludeHidden:  cmd.Bool("include-hidden"),
+		MaxDepth:       cmd.Int("max-depth"),
+	}
+}
+
+// dataHooks loads the data migrations from --data-dir, if set
+func dataHooks(cmd *cli.Command) ([]diff.DataHook, error) {
+	dir := cmd.String("data-dir")
+	if dir == "" {
+		return nil, nil
+	}
+	hooks, err := diff.LoadDataHooks(dir)
+	if err != nil {
+		return nil, fmt.Errorf("load data hooks: %w", err)
+	}
+	return hooks, nil
+}
+
+// runHook runs an apply hook through the system shell. The database path and
+// the apply status (pre-apply, success or failure) are passed in the
+// SQLITE_SCHEMA_DIFF_DATABASE and SQLITE_SCHEMA_DIFF_STATUS variables.
+func runHook(ctx context.Context, script, dbPath, status string) error {
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+
+	c := exec.CommandContext(ctx, shell, flag, script)
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	c.Env = append(os.Environ(),
+		"SQLITE_SCHEMA_DIFF_DATABASE="+dbPath,
+		"SQLITE_SCHEMA_DIFF_STATUS="+status,
+	)
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("run %q: %w", script, err)
+	}
+	return nil
+}
+
+// schemaOwners returns the owners annotated in a database and its schema
+// files, or only in the database if there are no schema files (e.g. when
+// applying a plan file)
+func schemaOwners(ctx context.Context, db *sql.DB, schemaDir string, opts parser.Options) (diff.Owners, error) {
+	current, err := parser.FromDBContext(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	target, err := parser.ReadFilesWithOptions(schemaDir, opts)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return diff.SchemaOwners(current, target), nil
+}
+
+// checkPolicies checks a plan against the --policies file, if any. The
+// target schema is only read when there are policies.
+func checkPolicies(ctx context.Context, cmd *cli.Command, changes []diff.Change, target diff.Source) error {
+	path := cmd.String("policies")
+	if path == "" {
+		return nil
+	}
+	policies, err := diff.LoadPolicies(path)
+	if err != nil {
+		return err
+	}
+	s, err := target(ctx)
+	if err != nil {
+		return err
+	}
+	return diff.CheckPolicies(policies, changes, s, cmd.StringSlice("approved-by")...)
+}
+
+// checkQueries checks that a plan keeps the application queries in the
+// --queries directory working, if any. The current schema is only read
+// when there are queries.
+func checkQueries(ctx context.Context, cmd *cli.Command, current diff.Source, changes []diff.Change) error {
+	dir := cmd.String("queries")
+	if dir == "" {
+		return nil
+	}
+	queries, err := diff.LoadQueries(dir)
+	if err != nil {
+		return err
+	}
+	if len(queries) == 0 {
+		return fmt.Errorf("no queries found in %s", dir)
+	}
+	s, err := current(ctx)
+	if err != nil {
+		return err
+	}
+	return diff.CheckQueries(s, changes, queries)
+}
+
+// showChanges lists a plan, grouped by the owners of the objects it
+// touches if any are owned
+func showChanges(changes []diff.Change, owners diff.Owners) {
+	groups := diff.GroupByOwner(changes, owners)
+	if len(groups) == 1 && groups[0].Owner == "" {
+		showChangeLines(changes)
+	} else {
+		for i, g := range groups {
+			if i > 0 {
+				fmt.Println()
+			}
+			if g.Owner == "" {
+				fmt.Println("Unowned:")
+			} else {
+				fmt.Printf("Owner %s:\n", g.Owner)
+			}
+			showChangeLines(g.Changes)
+		}
+	}
+
+	destructive, expensive := 0, 0
+	for _, c := range changes {
+		switch {
+		case c.Destructive:
+			destructive++
+		case c.Class == diff.ClassExpensive:
+			expensive++
+		}
+	}
+	if expensive > 0 {
+		fmt.Printf("\nTotal changes: %d (%d destructive, %d expensive)\n", len(changes), destructive, expensive)
+		return
+	}
+	fmt.Printf("\nTotal changes: %d (%d destructive)\n", len(changes), destructive)
+}
+
+func showChangeLines(changes []diff.Change) {
+	for _, c := range changes {
+		symbol := "+"
+		switch {
+		case c.Destructive:
+			symbol = "-"
+		case c.Class == diff.ClassExpensive:
+			symbol = "~"
+		}
+		size := ""
+		if c.Bytes > 0 {
+			size = fmt.Sprintf(" (%s)", diff.FormatBytes(c.Bytes))
+		}
+		if c.Sensitive {
+			size += " [sensitive]"
+		}
+		fmt.Printf("[%s] %s %s [%s]: %s%s\n", symbol, c.ID(), c.Type, c.Severity, c.Description, size)
+	}
+}
+
+func showDriftReport(report *diff.DriftReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATABASE\tSTATUS\tCHANGES\tDESTRUCTIVE")
+	for _, d := range report.Databases {
+		switch {
+		case d.Err != nil:
+			_, _ = fmt.Fprintf(w, "%s\tfailed\t-\t-\t%v\n", d.Name, d.Err)
+		case d.Drifted():
+			destructive := 0
+			for _, c := range d.Changes {
+				if c.Destructive {
+					destructive++
+				}
+			}
+			_, _ = fmt.Fprintf(w, "%s\tdrifted\t%d\t%d\n", d.Name, len(d.Changes), destructive)
+		default:
+			_, _ = fmt.Fprintf(w, "%s\tin sync\t0\t0\n", d.Name)
+		}
+	}
+	_ = w.Flush()
+
+	s := report.Summary
+	fmt.Printf("\n%d databases: %d in sync, %d drifted (%d with destructive changes), %d failed\n",
+		s.Databases, s.InSync, s.Drifted, s.Destructive, s.Failed)
+	if len(s.Changes) > 0 {
+		var counts []string
+		for _, t := range slices.Sorted(maps.Keys(s.Changes)) {
+			counts = append(counts, fmt.Sprintf("%s %d", t, s.Changes[t]))
+		}
+		fmt.Printf("Planned changes: %s\n", strings.Join(counts, ", "))
+	}
+}
+
+func showMatrix(m *diff.SchemaMatrix) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "FROM \\ TO\t%s\n", strings.Join(m.Names, "\t"))
+	for _, from := range m.Names {
+		cells := make([]string, len(m.Names))
+		for i, to := range m.Names {
+			p, ok := m.Pair(from, to)
+			switch {
+			case !ok:
+				cells[i] = "-"
+			case p.Identical():
+				cells[i] = "identical"
+			default:
+				cells[i] = fmt.Sprintf("%d changes", len(p.Changes))
+			}
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\n", from, strings.Join(cells, "\t"))
+	}
+	_ = w.Flush()
+	for _, path := range slices.Sorted(maps.Keys(m.Failed)) {
+		fmt.Printf("%s: failed: %v\n", path, m.Failed[path])
+	}
+
+	s := m.Summary
+	fmt.Printf("\n%d databases, %d pairs: %d identical, %d differ; %d distinct schemas, %d failed\n",
+		s.Databases, s.Pairs, s.Identical, s.Differ, s.Distinct, s.Failed)
+}
+
+// readPublicKey decodes a base64 Ed25519 public key, read from a file if
+// the value starts with @
+func readPublicKey(value string) (ed25519.PublicKey, error) {
+	if file, ok := strings.CutPrefix(value, "@"); ok {
+		content, err := os.ReadFile(filepath.Clean(file))
+		if err != nil {
+			return nil, fmt.Errorf("read public key: %w", err)
+		}
+		value = string(content)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("decode public key: %w", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key: want %d bytes, got %d", ed25519.PublicKeySize, len(key))
+	}
+	return key, nil
+}
+
+// showAgentStatus prints the outcome of an agent pass
+func showAgentStatus(status diff.AgentStatus, err error) {
+	ts := status.Time.Format(time.RFC3339)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s reconcile failed: %v\n", ts, err)
+		return
+	}
+	for _, c := range status.Applied {
+		fmt.Printf("%s applied: %s\n", ts, c)
+	}
+	for _, c := range status.Pending {
+		fmt.Printf("%s pending (destructive): %s\n", ts, c)
+	}
+	if status.InSync() {
+		fmt.Printf("%s in sync (schema %.12s)\n", ts, status.SchemaHash)
+	}
+}
+
+func showDriftEvent(event diff.DriftEvent) {
+	ts := event.Time.Format(time.RFC3339)
+	fmt.Printf("%s %s: schema changed (schema_version %d -> %d)\n", ts, event.Path, event.PreviousVersion, event.SchemaVersion)
+	for _, c := range event.Changes {
+		fmt.Printf("%s %s: changed: %s\n", ts, event.Path, c)
+	}
+	for _, c := range event.Pending {
+		fmt.Printf("%s %s: drift: %s\n", ts, event.Path, c)
+	}
+}
+
+func showTimeline(steps []diff.TimelineStep) {
+	fmt.Printf("%s %s\n", steps[0].FromTime.UTC().Format(time.RFC3339), steps[0].From)
+	for _, step := range steps {
+		fmt.Printf("%s %s: ", step.ToTime.UTC().Format(time.RFC3339), step.To)
+		if len(step.Changes) == 0 {
+			fmt.Println("no schema changes")
+			continue
+		}
+		fmt.Printf("%d schema changes\n", len(step.Changes))
+		for _, c := range step.Changes {
+			fmt.Printf("    %s: %s\n", c.Type, c.Description)
+		}
+	}
+}
+
+func showEstimate(ctx context.Context, db *sql.DB, changes []diff.Change) {
+	est, err := diff.EstimateChangesContext(ctx, db, changes)
+	if err != nil {
+		return
+	}
+	fmt.Printf("Estimated cost: %s\n", est)
+}
+
+func showViolations(ctx context.Context, db *sql.DB, changes []diff.Change) {
+	violations, err := diff.ScanCheckViolationsContext(ctx, db, changes)
+	if err != nil || len(violations) == 0 {
+		return
+	}
+	fmt.Println("\nExisting rows violate new CHECK constraints (use --quarantine to set them aside):")
+	for _, v := range violations {
+		fmt.Printf("  %s\n", v)
+	}
+}
+
+// showColumnStats prints what the data about to be lost looks like, so the
+// operator confirming destructive changes knows what they are deleting
+func showColumnStats(db *sql.DB, changes []diff.Change) {
+	stats, err := diff.DestructiveColumnStats(db, changes)
+	if err != nil || len(stats) == 0 {
+		return
+	}
+	fmt.Println("\nData in the columns about to be lost:")
+	for _, s := range stats {
+		fmt.Printf("  %s\n", s)
+	}
+}
+
+// showPreview prints the first n rows of the data destructive changes lose,
+// or writes them as JSON to file
+func showPreview(db *sql.DB, changes []diff.Change, n int, file string) error {
+	previews, err := diff.PreviewLostData(db, changes, n)
+	if err != nil {
+		return fmt.Errorf("preview data: %w", err)
+	}
+	if file != "" {
+		out, err := json.MarshalIndent(previews, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Clean(file), append(out, '\n'), 0o600); err != nil {
+			return fmt.Errorf("write preview: %w", err)
+		}
+		fmt.Printf("\nWrote data preview to %s\n", file)
+		return nil
+	}
+
+	for _, p := range previews {
+		what := "Columns lost from"
+		if p.Dropped {
+			what = "Dropped table"
+		}
+		fmt.Printf("\n%s %q (first %d rows):\n", what, p.Table, n)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "  %s\n", strings.Join(p.Columns, "\t"))
+		for _, row := range p.Rows {
+			values := make([]string, len(row))
+			for i, v := range row {
+				values[i] = "NULL"
+				if v != nil {
+					values[i] = *v
+				}
+			}
+			fmt.Fprintf(w, "  %s\n", strings.Join(values, "\t"))
+		}
+		if len(p.Rows) == 0 {
+			fmt.Fprintln(w, "  (no rows)")
+		}
+		_ = w.Flush()
+	}
+	return nil
+}
+
+// showSuggestions prints the suggested indexes for the target schema,
+// to stderr unless the output is text so that machine output stays valid
+func showSuggestions(target *schema.Database, format string) error {
+	suggestions, err := diff.SuggestIndexes(target)
+	if err != nil {
+		return fmt.Errorf("suggest indexes: %w", err)
+	}
+	if len(suggestions) == 0 {
+		return nil
+	}
+	w := os.Stdout
+	if format != "text" {
+		w = os.Stderr
+	}
+	fmt.Fprintln(w, "\nSuggested indexes (not part of the plan):")
+	for _, s := range suggestions {
+		fmt.Fprintf(w, "  %s\n", s)
+	}
+	return nil
+}
+
+// writeSQLFile streams the migration script for changes into a file
+func writeSQLFile(path string, changes []diff.Change) (err error) {
+	f, err := os.Create(filepath.Clean(path))
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	defer func() {
+		err = errors.Join(err, f.Close())
+	}()
+	if err := diff.WriteSQL(f, changes, diff.SQLOptions{}); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+func dumpSchema(s *schema.Database, outputDir string) error {
+	if err := os.MkdirAll(outputDir, 0o750); err != nil {
+		return fmt.Errorf("create output directory: %w", err)
 	}
 
 	// Write tables
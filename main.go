@@ -8,6 +8,7 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/diff"
 	"github.com/urfave/cli/v3"
 )
 
@@ -28,6 +29,8 @@ func main() {
 		Commands:              commands,
 	}
 
+	diff.SetWarningHandler(printWarning)
+
 	// Graceful shutdown
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
@@ -36,3 +39,8 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// printWarning prints a warning of the diff package to stderr
+func printWarning(w diff.Warning) {
+	fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/diff"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// mcpProtocolVersion is the Model Context Protocol revision the server speaks
+const mcpProtocolVersion = "2025-06-18"
+
+// maxMessageSize limits a single JSON-RPC message
+const maxMessageSize = 16 << 20
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidParams  = -32602
+	rpcMethodNotFound = -32601
+)
+
+// mcpServer serves the diff tools over the Model Context Protocol, one
+// JSON-RPC message per line on stdin and stdout
+type mcpServer struct {
+	database   string // Default database of the tools
+	schema     string // Default schema directory of the tools
+	opts       diff.DiffOptions
+	rules      diff.NamingRules // Rules of the lint tool
+	allowApply bool             // Whether apply may run without dry_run
+
+	// Further databases and schema directories the tools may be pointed
+	// at; any other path is refused
+	databases []string
+	schemas   []string
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type mcpTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type mcpToolResult struct {
+	Content []mcpContent `json:"content"`
+	IsError bool         `json:"isError,omitempty"`
+}
+
+// toolArgs are the arguments shared by all tools
+type toolArgs struct {
+	Database        string `json:"database"`
+	Schema          string `json:"schema"`
+	DryRun          *bool  `json:"dry_run"`
+	SkipDestructive bool   `json:"skip_destructive"`
+}
+
+// serve handles requests until r is exhausted
+func (s *mcpServer) serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxMessageSize)
+	enc := json.NewEncoder(w)
+
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return nil
+		}
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var req rpcRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			resp := rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{rpcParseError, err.Error()}}
+			if err := enc.Encode(resp); err != nil {
+				return err
+			}
+			continue
+		}
+		// Notifications get no response
+		if req.ID == nil {
+			continue
+		}
+
+		result, rpcErr := s.handle(ctx, req)
+		if err := enc.Encode(rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rpcErr}); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *mcpServer) handle(ctx context.Context, req rpcRequest) (any, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		return map[string]any{
+			"protocolVersion": mcpProtocolVersion,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]any{"name": "sqlite-schema-diff", "version": Version},
+			"instructions": "Inspect schema drift between SQLite databases and .sql schema files. " +
+				"Databases are opened read-only except by apply, which only dry-runs unless the server allows it. " +
+				"Only the databases and schema directories the server was started with can be used.",
+		}, nil
+	case "ping":
+		return map[string]any{}, nil
+	case "tools/list":
+		return map[string]any{"tools": s.tools()}, nil
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{rpcInvalidParams, err.Error()}
+		}
+		var args toolArgs
+		if len(params.Arguments) > 0 {
+			if err := json.Unmarshal(params.Arguments, &args); err != nil {
+				return nil, &rpcError{rpcInvalidParams, err.Error()}
+			}
+		}
+
+		var content []mcpContent
+		var err error
+		switch params.Name {
+		case "diff":
+			content, err = s.diffTool(ctx, args)
+		case "explain":
+			content, err = s.explainTool(ctx, args)
+		case "lint":
+			content, err = s.lintTool(ctx, args)
+		case "verify_plan":
+			content, err = s.verifyPlanTool(ctx, args)
+		case "apply":
+			content, err = s.applyTool(ctx, args)
+		default:
+			return nil, &rpcError{rpcInvalidParams, fmt.Sprintf("unknown tool %q", params.Name)}
+		}
+		// Tool failures are results, so the model can see and react to them
+		if err != nil {
+			return mcpToolResult{Content: []mcpContent{{Type: "text", Text: err.Error()}}, IsError: true}, nil
+		}
+		return mcpToolResult{Content: content}, nil
+	}
+	return nil, &rpcError{rpcMethodNotFound, fmt.Sprintf("method %q not found", req.Method)}
+}
+
+// tools describes the available tools
+func (s *mcpServer) tools() []mcpTool {
+	target := map[string]any{
+		"database": map[string]any{"type": "string", "description": "Path to the SQLite database (default: " + describeDefault(s.database) + ")" + describeAllowed(s.databases)},
+		"schema":   map[string]any{"type": "string", "description": "Schema directory with .sql files (default: " + describeDefault(s.schema) + ")" + describeAllowed(s.schemas)},
+	}
+	input := func(extra map[string]any) map[string]any {
+		props := maps.Clone(target)
+		maps.Copy(props, extra)
+		return map[string]any{"type": "object", "properties": props}
+	}
+
+	applyDesc := "Apply the schema changes to the database. Only dry runs are allowed, which return the migration SQL."
+	if s.allowApply {
+		applyDesc = "Apply the schema changes to the database, after a backup to <database>.backup. Defaults to a dry run; pass dry_run=false to write."
+	}
+	return []mcpTool{
+		{
+			Name:        "diff",
+			Description: "Compare the database with the schema files. Returns the plan as JSON (object addresses, actions, before/after values) and the migration SQL.",
+			InputSchema: input(nil),
+		},
+		{
+			Name:        "explain",
+			Description: "Explain each planned change: why it is needed, whether it loses data, object sizes, the expected cost and rows violating new CHECK constraints.",
+			InputSchema: input(nil),
+		},
+		{
+			Name:        "lint",
+			Description: "Check the names in the schema files against the naming conventions and the registered lint rules. Returns the issues as JSON.",
+			InputSchema: map[string]any{"type": "object", "properties": map[string]any{"schema": target["schema"]}},
+		},
+		{
+			Name:        "verify_plan",
+			Description: "Apply the plan to an in-memory copy of the schema and report any difference that would remain.",
+			InputSchema: input(nil),
+		},
+		{
+			Name:        "apply",
+			Description: applyDesc,
+			InputSchema: input(map[string]any{
+				"dry_run":          map[string]any{"type": "boolean", "description": "Only show what would be applied (default true)"},
+				"skip_destructive": map[string]any{"type": "boolean", "description": "Skip drops and table recreations"},
+			}),
+		},
+	}
+}
+
+// describeDefault describes a default path in a tool schema
+func describeDefault(value string) string {
+	if value == "" {
+		return "none, required"
+	}
+	return value
+}
+
+// describeAllowed lists the further paths a tool argument may take
+func describeAllowed(paths []string) string {
+	if len(paths) == 0 {
+		return ""
+	}
+	return "; also allowed: " + strings.Join(paths, ", ")
+}
+
+// mcpPlan is a diff computed for a tool call
+type mcpPlan struct {
+	db              *sql.DB
+	current, target *schema.Database
+	changes         []diff.Change
+	opts            diff.DiffOptions
+}
+
+// plan opens the database read-only and diffs it against the schema
+func (s *mcpServer) plan(ctx context.Context, args toolArgs) (*mcpPlan, error) {
+	dbPath, schemaDir, err := s.paths(args)
+	if err != nil {
+		return nil, err
+	}
+	if dbPath == "" {
+		return nil, errors.New("database is required")
+	}
+	db, err := diff.OpenReadOnly(dbPath, false)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &mcpPlan{db: db}
+	if p.current, err = parser.FromDBContext(ctx, db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if p.target, err = parser.ReadFilesContext(ctx, schemaDir, s.opts.Parse); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	caps, err := diff.DetectCapabilities(db)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	p.opts = s.opts.WithCapabilities(caps)
+	p.changes = diff.DiffWithOptions(p.current, p.target, p.opts)
+	return p, nil
+}
+
+// paths returns the database and schema of a call, falling back to the
+// server defaults. Paths other than the defaults and the allowed ones are
+// refused, so a model cannot read or write arbitrary files.
+func (s *mcpServer) paths(args toolArgs) (string, string, error) {
+	dbPath, schemaDir := args.Database, args.Schema
+	if dbPath == "" {
+		dbPath = s.database
+	} else if !allowedPath(dbPath, append([]string{s.database}, s.databases...)) {
+		return "", "", fmt.Errorf("database %q is not allowed; start the server with --allow-database to use it", dbPath)
+	}
+	if schemaDir == "" {
+		schemaDir = s.schema
+	} else if !allowedPath(schemaDir, append([]string{s.schema}, s.schemas...)) {
+		return "", "", fmt.Errorf("schema %q is not allowed; start the server with --allow-schema to use it", schemaDir)
+	}
+	return dbPath, schemaDir, nil
+}
+
+// allowedPath reports whether path names one of allowed
+func allowedPath(path string, allowed []string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(allowed, func(p string) bool {
+		a, err := filepath.Abs(p)
+		return p != "" && err == nil && a == abs
+	})
+}
+
+func (s *mcpServer) diffTool(ctx context.Context, args toolArgs) ([]mcpContent, error) {
+	p, err := s.plan(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = p.db.Close() }()
+
+	if len(p.changes) == 0 {
+		return []mcpContent{{Type: "text", Text: "No schema changes detected."}}, nil
+	}
+	planJSON, err := diff.PlanJSON(p.current, p.target, p.changes)
+	if err != nil {
+		return nil, err
+	}
+	return []mcpContent{
+		{Type: "text", Text: string(planJSON)},
+		{Type: "text", Text: diff.GenerateSQL(p.changes)},
+	}, nil
+}
+
+func (s *mcpServer) explainTool(ctx context.Context, args toolArgs) ([]mcpContent, error) {
+	p, err := s.plan(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = p.db.Close() }()
+
+	if len(p.changes) == 0 {
+		return []mcpContent{{Type: "text", Text: "No schema changes detected."}}, nil
+	}
+
+	_ = diff.AnnotateSizesContext(ctx, p.db, p.changes) // Sizes are optional, dbstat may be missing
+	var sb strings.Builder
+	for i, c := range p.changes {
+		fmt.Fprintf(&sb, "%d. %s: %s\n", i+1, c.Type, c.Description)
+		fmt.Fprintf(&sb, "   reason: %s\n", c.Reason)
+		fmt.Fprintf(&sb, "   severity: %s\n", c.Severity)
+		if c.Destructive {
+			sb.WriteString("   destructive: may lose data\n")
+		}
+		if c.Bytes > 0 {
+			fmt.Fprintf(&sb, "   current size: %s\n", diff.FormatBytes(c.Bytes))
+		}
+	}
+	if est, err := diff.EstimateChangesContext(ctx, p.db, p.changes); err == nil {
+		fmt.Fprintf(&sb, "\nEstimate: %s\n", est)
+	}
+	if violations, err := diff.ScanCheckViolationsContext(ctx, p.db, p.changes); err == nil && len(violations) > 0 {
+		sb.WriteString("\nRows violating new CHECK constraints (apply would fail):\n")
+		for _, v := range violations {
+			fmt.Fprintf(&sb, "  %s\n", v)
+		}
+	}
+	return []mcpContent{{Type: "text", Text: sb.String()}}, nil
+}
+
+func (s *mcpServer) lintTool(ctx context.Context, args toolArgs) ([]mcpContent, error) {
+	_, schemaDir, err := s.paths(toolArgs{Schema: args.Schema})
+	if err != nil {
+		return nil, err
+	}
+	target, err := parser.ReadFilesContext(ctx, schemaDir, s.opts.Parse)
+	if err != nil {
+		return nil, err
+	}
+	issues := diff.Lint(target, s.rules)
+	if len(issues) == 0 {
+		return []mcpContent{{Type: "text", Text: "No lint issues."}}, nil
+	}
+	locs, err := parser.Locations(schemaDir, s.opts.Parse)
+	if err != nil {
+		return nil, err
+	}
+	diff.LocateIssues(issues, locs)
+	out, err := json.MarshalIndent(issues, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return []mcpContent{{Type: "text", Text: string(out)}}, nil
+}
+
+func (s *mcpServer) verifyPlanTool(ctx context.Context, args toolArgs) ([]mcpContent, error) {
+	p, err := s.plan(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = p.db.Close() }()
+
+	residual, err := diff.VerifyPlanContext(ctx, p.current, p.target, p.changes, p.opts)
+	if err != nil {
+		return nil, fmt.Errorf("verify plan: %w", err)
+	}
+	if len(residual) == 0 {
+		return []mcpContent{{Type: "text", Text: "Plan verified: applying it reproduces the target schema."}}, nil
+	}
+	var sb strings.Builder
+	sb.WriteString("Differences remain after applying the plan:\n")
+	for _, c := range residual {
+		fmt.Fprintf(&sb, "  %s: %s\n", c.Type, c.Description)
+	}
+	return []mcpContent{{Type: "text", Text: sb.String()}}, nil
+}
+
+func (s *mcpServer) applyTool(ctx context.Context, args toolArgs) ([]mcpContent, error) {
+	dryRun := args.DryRun == nil || *args.DryRun
+	if !dryRun && !s.allowApply {
+		return nil, errors.New("apply is restricted to dry runs; start the server with --allow-apply to write")
+	}
+
+	p, err := s.plan(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	_ = p.db.Close()
+
+	changes := p.changes
+	if args.SkipDestructive {
+		changes = nil
+		for _, c := range p.changes {
+			if !c.Destructive {
+				changes = append(changes, c)
+			}
+		}
+	}
+	if len(changes) == 0 {
+		return []mcpContent{{Type: "text", Text: "No schema changes to apply."}}, nil
+	}
+	if dryRun {
+		return []mcpContent{{Type: "text", Text: "Dry run - no changes applied.\n\n" + diff.GenerateSQL(changes)}}, nil
+	}
+
+	dbPath, schemaDir, err := s.paths(args)
+	if err != nil {
+		return nil, err
+	}
+	db, err := openExisting(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = db.Close() }()
+
+	var applied []string
+	opts := diff.ApplyOptions{
+		DiffOptions:     s.opts,
+		SkipDestructive: args.SkipDestructive,
+		BackupPath:      dbPath + ".backup",
+		OnCommit: func(committed []diff.Change) {
+			for _, c := range committed {
+				applied = append(applied, fmt.Sprintf("%s: %s", c.Type, c.Description))
+			}
+		},
+	}
+	if err := diff.ApplyContext(ctx, db, schemaDir, opts); err != nil {
+		return nil, fmt.Errorf("apply changes: %w", err)
+	}
+	return []mcpContent{{Type: "text", Text: "Applied:\n  " + strings.Join(applied, "\n  ")}}, nil
+}
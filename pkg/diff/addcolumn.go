@@ -0,0 +1,247 @@
+package diff
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+var (
+	// primaryKeyRe and uniqueRe match constraints ALTER TABLE ADD COLUMN rejects
+	primaryKeyRe = regexp.MustCompile(`(?i)\bprimary\s+key\b`)
+	uniqueRe     = regexp.MustCompile(`(?i)\bunique\b`)
+	// referencesRe matches a column-level foreign key
+	referencesRe = regexp.MustCompile(`(?i)\breferences\b`)
+	// nonConstantDefaultRe matches CURRENT_TIME, CURRENT_DATE and
+	// CURRENT_TIMESTAMP defaults and function calls
+	nonConstantDefaultRe = regexp.MustCompile(`(?i)^\(?\s*current_|\w\s*\(`)
+	// expressionDefaultRe matches a DEFAULT (expr), which PRAGMA table_info
+	// reports without the parentheses
+	expressionDefaultRe = regexp.MustCompile(`(?i)\bdefault\s*\(`)
+	// tableConstraintRe matches definitions that are table constraints, not columns
+	tableConstraintRe = regexp.MustCompile(`(?i)^(constraint|primary|unique|check|foreign)\b`)
+)
+
+// addColumnRestriction returns the clause that keeps SQLite from adding col
+// with ALTER TABLE ADD COLUMN, or "" if it can be added. def is the column
+// definition from the CREATE TABLE statement, if known.
+func addColumnRestriction(col schema.Column, def string) string {
+	masked := maskQuoted(def)
+	dflt := ""
+	if col.Default != nil {
+		dflt = strings.ToUpper(strings.TrimSpace(*col.Default))
+	}
+
+	switch {
+	case col.PrimaryKey > 0 || primaryKeyRe.MatchString(masked):
+		return "PRIMARY KEY"
+	case uniqueRe.MatchString(masked):
+		return "UNIQUE"
+	case col.Hidden == 3:
+		return "a STORED generated value"
+	case nonConstantDefaultRe.MatchString(maskQuoted(dflt)) || expressionDefaultRe.MatchString(masked):
+		return fmt.Sprintf("the non-constant DEFAULT %s", *col.Default)
+	case referencesRe.MatchString(masked) && dflt != "" && dflt != "NULL":
+		return "REFERENCES and a non-NULL DEFAULT"
+	case col.NotNull && col.Hidden == 0 && (dflt == "" || dflt == "NULL"):
+		return "NOT NULL and no DEFAULT"
+	}
+	return ""
+}
+
+// checkReferencesOtherColumns reports whether a CHECK in the definition of
+// col refers to another column of the table
+func checkReferencesOtherColumns(table *schema.Table, col schema.Column, def string) bool {
+	for _, expr := range checkExpressions(def) {
+		expr = stringLiteralRe.ReplaceAllString(expr, "''")
+		for _, other := range table.Columns {
+			if strings.EqualFold(other.Name, col.Name) {
+				continue
+			}
+			re := regexp.MustCompile(`(?i)(^|[^\w])["\x60\[]?` + regexp.QuoteMeta(other.Name) + `["\x60\]]?([^\w]|$)`)
+			if re.MatchString(expr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// versionAtLeast reports whether SQLite version v is min or newer. An empty
+// version is the bundled driver's, which is always new enough.
+func versionAtLeast(v, min string) bool {
+	if v == "" {
+		return true
+	}
+	have, want := strings.Split(v, "."), strings.Split(min, ".")
+	for i := range want {
+		var h, w int
+		if i < len(have) {
+			h, _ = strconv.Atoi(have[i])
+		}
+		w, _ = strconv.Atoi(want[i])
+		if h != w {
+			return h > w
+		}
+	}
+	return true
+}
+
+// addColumnSQL generates ADD COLUMN for col, keeping every clause of its
+// definition (CHECK, REFERENCES, COLLATE, GENERATED) when it is known
+func addColumnSQL(tableName string, col schema.Column, def string) string {
+	ident := leadingIdentRe.FindString(def)
+	if ident == "" {
+		return generateAddColumnSQL(tableName, col)
+	}
+	rest := strings.TrimSpace(def[len(ident):])
+	if rest == "" {
+		return fmt.Sprintf("ALTER TABLE %q ADD COLUMN %q;", tableName, col.Name)
+	}
+	return fmt.Sprintf("ALTER TABLE %q ADD COLUMN %q %s;", tableName, col.Name, rest)
+}
+
+// leadingIdentRe matches the identifier at the start of a column definition
+var leadingIdentRe = regexp.MustCompile(
+	`^("(?:[^"]|"")*"|\x60(?:[^\x60]|\x60\x60)*\x60|\[[^\]]*\]|[^\s(),]+)`,
+)
+
+// tableDefinitions splits a CREATE TABLE statement into the part before the
+// definition list, the top-level column and constraint definitions with
+// comments removed, and the table options after it
+func tableDefinitions(createSQL string) (head string, defs []string, tail string, ok bool) {
+	clean := blankComments(createSQL)
+	masked := maskQuoted(clean)
+
+	open := strings.Index(masked, "(")
+	if open < 0 {
+		return "", nil, "", false
+	}
+
+	depth, start := 0, open+1
+	for i := open; i < len(masked); i++ {
+		switch masked[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				defs = append(defs, strings.TrimSpace(clean[start:i]))
+				return clean[:open], defs, clean[i+1:], true
+			}
+		case ',':
+			if depth == 1 {
+				defs = append(defs, strings.TrimSpace(clean[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return "", nil, "", false
+}
+
+// columnDefinition returns the definition of the named column, or ""
+func columnDefinition(defs []string, name string) string {
+	for _, def := range defs {
+		if tableConstraintRe.MatchString(def) {
+			continue
+		}
+		if ident := leadingIdentRe.FindString(def); strings.EqualFold(unquoteIdent(ident), name) {
+			return def
+		}
+	}
+	return ""
+}
+
+// onlyColumnsAdded reports whether to differs from from by nothing but the
+// given new column definitions. Definitions are compared in order unless
+// ignoreOrder is set. If either statement cannot be split, it reports true.
+func onlyColumnsAdded(from, to *schema.Table, newDefs []string, ignoreOrder bool) bool {
+	fromHead, fromDefs, fromTail, ok := tableDefinitions(from.SQL)
+	if !ok {
+		return true
+	}
+	toHead, toDefs, toTail, ok := tableDefinitions(to.SQL)
+	if !ok {
+		return true
+	}
+
+	var remaining []string
+	for _, def := range toDefs {
+		if !slices.Contains(newDefs, def) {
+			remaining = append(remaining, normalizeSQL(def))
+		}
+	}
+	existing := make([]string, len(fromDefs))
+	for i, def := range fromDefs {
+		existing[i] = normalizeSQL(def)
+	}
+	if ignoreOrder {
+		slices.Sort(remaining)
+		slices.Sort(existing)
+	}
+
+	return normalizeSQL(fromHead) == normalizeSQL(toHead) &&
+		normalizeSQL(fromTail) == normalizeSQL(toTail) &&
+		slices.Equal(existing, remaining)
+}
+
+// onlyDefinitionChanged reports whether to differs from from by nothing but
+// the definition of the named column. If either statement cannot be split,
+// it reports false.
+func onlyDefinitionChanged(from, to *schema.Table, column string) bool {
+	fromHead, fromDefs, fromTail, ok := tableDefinitions(from.SQL)
+	if !ok {
+		return false
+	}
+	toHead, toDefs, toTail, ok := tableDefinitions(to.SQL)
+	if !ok || len(fromDefs) != len(toDefs) {
+		return false
+	}
+	changed := columnDefinition(toDefs, column)
+	for i := range toDefs {
+		if toDefs[i] != changed && normalizeSQL(fromDefs[i]) != normalizeSQL(toDefs[i]) {
+			return false
+		}
+	}
+	return normalizeSQL(fromHead) == normalizeSQL(toHead) && normalizeSQL(fromTail) == normalizeSQL(toTail)
+}
+
+// blankComments replaces SQL comments outside of quotes with spaces,
+// keeping byte offsets intact
+func blankComments(s string) string {
+	b := []byte(s)
+	var quote byte
+	for i := 0; i < len(b); i++ {
+		switch {
+		case quote != 0:
+			if b[i] == quote {
+				quote = 0
+			}
+		case b[i] == '\'' || b[i] == '"' || b[i] == '`':
+			quote = b[i]
+		case b[i] == '[':
+			quote = ']'
+		case b[i] == '-' && i+1 < len(b) && b[i+1] == '-':
+			for ; i < len(b) && b[i] != '\n'; i++ {
+				b[i] = ' '
+			}
+		case b[i] == '/' && i+1 < len(b) && b[i+1] == '*':
+			end := strings.Index(string(b[i+2:]), "*/")
+			stop := len(b)
+			if end >= 0 {
+				stop = i + 2 + end + 2
+			}
+			for ; i < stop; i++ {
+				if b[i] != '\n' {
+					b[i] = ' '
+				}
+			}
+			i--
+		}
+	}
+	return string(b)
+}
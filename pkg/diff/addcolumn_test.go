@@ -0,0 +1,175 @@
+package diff
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+func TestTableDefinitions(t *testing.T) {
+	sql := `CREATE TABLE "t" (
+    id INTEGER PRIMARY KEY, -- row id, (alias)
+    "full name" TEXT DEFAULT 'a,b' CHECK (length("full name") > 0),
+    /* legacy */ b TEXT,
+    UNIQUE (id, b)
+) STRICT`
+
+	head, defs, tail, ok := tableDefinitions(sql)
+	if !ok {
+		t.Fatal("tableDefinitions() failed")
+	}
+	want := []string{
+		"id INTEGER PRIMARY KEY",
+		`"full name" TEXT DEFAULT 'a,b' CHECK (length("full name") > 0)`,
+		"b TEXT",
+		"UNIQUE (id, b)",
+	}
+	if !slices.Equal(defs, want) {
+		t.Errorf("defs = %q, want %q", defs, want)
+	}
+	if normalizeSQL(head) != "create table t" || normalizeSQL(tail) != "strict" {
+		t.Errorf("head = %q, tail = %q", head, tail)
+	}
+
+	if got := columnDefinition(defs, "Full Name"); got != want[1] {
+		t.Errorf("columnDefinition() = %q, want %q", got, want[1])
+	}
+	if got := columnDefinition(defs, "unique"); got != "" {
+		t.Errorf("columnDefinition() matched a table constraint: %q", got)
+	}
+}
+
+func TestAddColumnSQL(t *testing.T) {
+	tests := []struct {
+		name string
+		col  schema.Column
+		def  string
+		want string
+	}{
+		{
+			name: "keeps all clauses",
+			col:  schema.Column{Name: "b", Type: "TEXT"},
+			def:  "b TEXT COLLATE NOCASE REFERENCES p(id)",
+			want: `ALTER TABLE "t" ADD COLUMN "b" TEXT COLLATE NOCASE REFERENCES p(id);`,
+		},
+		{
+			name: "quoted name",
+			col:  schema.Column{Name: "full name"},
+			def:  `"full name"`,
+			want: `ALTER TABLE "t" ADD COLUMN "full name";`,
+		},
+		{
+			name: "unknown definition",
+			col:  schema.Column{Name: "b", Type: "TEXT"},
+			want: `ALTER TABLE "t" ADD COLUMN "b" TEXT;`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := addColumnSQL("t", tt.col, tt.def); got != tt.want {
+				t.Errorf("addColumnSQL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddColumnRestriction(t *testing.T) {
+	tests := []struct {
+		name string
+		col  schema.Column
+		def  string
+		want bool
+	}{
+		{name: "plain", col: schema.Column{Name: "b", Type: "TEXT"}, def: "b TEXT"},
+		{name: "literal looks like function", col: schema.Column{Name: "b", Default: new("'f(x)'")}, def: "b DEFAULT 'f(x)'"},
+		{name: "quoted name unique", col: schema.Column{Name: "unique"}, def: `"unique" TEXT`},
+		{name: "unique", col: schema.Column{Name: "b"}, def: "b TEXT UNIQUE", want: true},
+		{name: "current date without definition", col: schema.Column{Name: "b", Default: new("CURRENT_DATE")}, want: true},
+		{name: "not null default null", col: schema.Column{Name: "b", NotNull: true, Default: new("NULL")}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := addColumnRestriction(tt.col, tt.def); (got != "") != tt.want {
+				t.Errorf("addColumnRestriction() = %q, want restricted %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	tests := []struct {
+		v, min string
+		want   bool
+	}{
+		{"", "3.37.0", true},
+		{"3.37.0", "3.37.0", true},
+		{"3.37.2", "3.37.0", true},
+		{"3.40", "3.37.0", true},
+		{"3.35.5", "3.37.0", false},
+		{"3.9.2", "3.37.0", false},
+	}
+	for _, tt := range tests {
+		if got := versionAtLeast(tt.v, tt.min); got != tt.want {
+			t.Errorf("versionAtLeast(%q, %q) = %v, want %v", tt.v, tt.min, got, tt.want)
+		}
+	}
+}
+
+func TestDiff_AddColumnCheckOnOlderSQLite(t *testing.T) {
+	from, err := parser.FromSQL(`CREATE TABLE t (id INTEGER PRIMARY KEY, qty INTEGER);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		schema  string
+		version string
+		want    ChangeType
+	}{
+		{
+			name:    "check on other column, old version",
+			schema:  `CREATE TABLE t (id INTEGER PRIMARY KEY, qty INTEGER, max_qty INTEGER CHECK (max_qty >= "qty"));`,
+			version: "3.35.5",
+			want:    RecreateTable,
+		},
+		{
+			name:    "check on other column, current version",
+			schema:  `CREATE TABLE t (id INTEGER PRIMARY KEY, qty INTEGER, max_qty INTEGER CHECK (max_qty >= qty));`,
+			version: "3.45.1",
+			want:    AddColumn,
+		},
+		{
+			name:    "check on own column, old version",
+			schema:  `CREATE TABLE t (id INTEGER PRIMARY KEY, qty INTEGER, max_qty INTEGER CHECK (max_qty > 0 AND max_qty != 'qty'));`,
+			version: "3.35.5",
+			want:    AddColumn,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			to, err := parser.FromSQL(tt.schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			changes := DiffWithOptions(from, to, DiffOptions{TargetVersion: tt.version})
+			if len(changes) != 1 || changes[0].Type != tt.want {
+				t.Fatalf("changes = %+v, want one %s", changes, tt.want)
+			}
+			c := changes[0]
+			if tt.want == RecreateTable &&
+				(c.Reason != AddColumnUnsupported || !strings.Contains(c.Description, "CHECK")) {
+				t.Errorf("recreate should explain the CHECK fallback, got %s: %s", c.Reason, c.Description)
+			}
+			if tt.want == AddColumn && !strings.Contains(c.SQL[0], "CHECK") {
+				t.Errorf("ADD COLUMN should keep the CHECK, got %s", c.SQL[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package diff
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+// maxSchemaSize limits how much an agent downloads for a schema or signature
+const maxSchemaSize = 32 << 20
+
+// agentSchemaFile is the name of the verified schema in the agent cache
+const agentSchemaFile = "schema.sql"
+
+// releaseAnnotationRe matches the "-- @version: 42" header of a signed schema
+var releaseAnnotationRe = regexp.MustCompile(`(?m)^\s*--\s*@version:\s*(\S+)\s*$`)
+
+// ErrStaleSchema is returned by Reconcile for a signed schema older than
+// the last one the agent applied, e.g. an old release served again by a
+// mirror or an attacker. Its signature is still valid.
+var ErrStaleSchema = errors.New("signed schema is older than the last one applied")
+
+// AgentOptions configures an agent that keeps a database in line with a
+// schema published at a URL. Destructive changes are never applied by the
+// agent; they are reported as pending for an operator to apply.
+type AgentOptions struct {
+	ApplyOptions
+
+	Name      string            // Name of the database in status reports
+	SchemaURL string            // URL of the schema SQL, signed at SchemaURL + ".sig"
+	PublicKey ed25519.PublicKey // Key the schema must be signed with
+	CacheDir  string            // Directory keeping the last verified schema
+	ReportURL string            // URL the status is POSTed to as JSON (empty = no reports)
+	Interval  time.Duration     // Time between reconciliations (default 5m)
+	Client    *http.Client      // HTTP client (default http.DefaultClient)
+
+	// OnReconcile is called after each reconciliation
+	OnReconcile func(status AgentStatus, err error)
+}
+
+// AgentStatus is the outcome of a reconciliation, as reported upstream
+type AgentStatus struct {
+	Name       string    `json:"name,omitempty"`
+	Time       time.Time `json:"time"`
+	SchemaHash string    `json:"schema_hash,omitempty"` // SHA-256 of the verified schema
+	Version    int64     `json:"version,omitempty"`     // From the "-- @version:" header of the verified schema
+	Cached     bool      `json:"cached,omitempty"`      // The schema could not be fetched, the cached one was used
+	Applied    []string  `json:"applied,omitempty"`
+	Pending    []string  `json:"pending,omitempty"` // Destructive changes left for an operator
+	Error      string    `json:"error,omitempty"`
+}
+
+// InSync reports whether the database matched the schema after reconciling
+func (s AgentStatus) InSync() bool {
+	return s.Error == "" && len(s.Pending) == 0
+}
+
+// FetchSignedSchema downloads a schema and its detached signature from
+// url + ".sig" and verifies it against key. The signature file holds the
+// base64 encoded Ed25519 signature of the schema bytes.
+func FetchSignedSchema(ctx context.Context, client *http.Client, url string, key ed25519.PublicKey) ([]byte, error) {
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key: want %d bytes, got %d", ed25519.PublicKeySize, len(key))
+	}
+	content, err := fetch(ctx, client, url)
+	if err != nil {
+		return nil, fmt.Errorf("fetch schema: %w", err)
+	}
+	encoded, err := fetch(ctx, client, url+".sig")
+	if err != nil {
+		return nil, fmt.Errorf("fetch signature: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("decode signature: %w", err)
+	}
+	if !ed25519.Verify(key, content, sig) {
+		return nil, errors.New("schema signature verification failed")
+	}
+	return content, nil
+}
+
+// fetch GETs a URL, failing on non-200 responses and oversized bodies
+func fetch(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cmp.Or(client, http.DefaultClient).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSchemaSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxSchemaSize {
+		return nil, fmt.Errorf("GET %s: response exceeds %d bytes", url, maxSchemaSize)
+	}
+	return body, nil
+}
+
+// Reconcile runs one agent pass: it fetches and verifies the schema, caches
+// it, and applies the non-destructive changes. If the schema cannot be
+// fetched, the last verified schema in the cache is used, so a device keeps
+// converging while offline. The status is reported to ReportURL if set.
+//
+// The schema must have a "-- @version: N" header. A schema with a lower
+// version than the cached one fails with ErrStaleSchema. Only the verified
+// bytes are applied; nothing else in the cache directory is read.
+func Reconcile(ctx context.Context, db *sql.DB, opts AgentOptions) (AgentStatus, error) {
+	status := AgentStatus{Name: opts.Name, Time: time.Now().UTC()}
+	err := reconcile(ctx, db, opts, &status)
+	if err != nil {
+		status.Error = err.Error()
+	}
+	if opts.ReportURL != "" {
+		if reportErr := postJSON(ctx, opts.Client, opts.ReportURL, status); reportErr != nil {
+			err = errors.Join(err, fmt.Errorf("report status: %w", reportErr))
+		}
+	}
+	return status, err
+}
+
+func reconcile(ctx context.Context, db *sql.DB, opts AgentOptions, status *AgentStatus) error {
+	if opts.CacheDir == "" {
+		return errors.New("agent cache directory is required")
+	}
+	cached := filepath.Join(opts.CacheDir, agentSchemaFile)
+
+	content, err := FetchSignedSchema(ctx, opts.Client, opts.SchemaURL, opts.PublicKey)
+	if err != nil {
+		// Only a schema that was verified before may be used offline
+		var readErr error
+		if content, readErr = os.ReadFile(cached); readErr != nil {
+			return err
+		}
+		warn("", "using cached schema: %v", err)
+		status.Cached = true
+	}
+	if status.Version, err = schemaRelease(content); err != nil {
+		return err
+	}
+	if !status.Cached {
+		if err := checkRelease(cached, status.Version); err != nil {
+			return err
+		}
+		if err := writeCachedSchema(opts.CacheDir, content); err != nil {
+			return err
+		}
+	}
+	sum := sha256.Sum256(content)
+	status.SchemaHash = hex.EncodeToString(sum[:])
+
+	target, err := parser.FromSQLContext(ctx, string(content), opts.Parse)
+	if err != nil {
+		return err
+	}
+	version, err := SchemaVersionContext(ctx, db)
+	if err != nil {
+		return err
+	}
+	var hashes map[string]string
+	if opts.Incremental {
+		hashes = ObjectHashes(target)
+	}
+	changes, err := compareTo(ctx, db, target, hashes, opts.DiffOptions)
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		if c.Destructive {
+			status.Pending = append(status.Pending, c.Description)
+		}
+	}
+
+	apply := opts.ApplyOptions
+	apply.SkipDestructive = true
+	apply.target = target
+	if apply.SchemaVersion == nil {
+		apply.SchemaVersion = &version
+	}
+	apply.OnCommit = func(committed []Change) {
+		for _, c := range committed {
+			status.Applied = append(status.Applied, c.Description)
+		}
+		opts.committed(committed)
+	}
+	return ApplyPlanContext(ctx, db, "", changes, apply)
+}
+
+// schemaRelease returns the version in the "-- @version:" header of a
+// signed schema
+func schemaRelease(content []byte) (int64, error) {
+	m := releaseAnnotationRe.FindSubmatch(content)
+	if m == nil {
+		return 0, errors.New(`signed schema has no "-- @version: N" header`)
+	}
+	version, err := strconv.ParseInt(string(m[1]), 10, 64)
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("signed schema has an invalid version %q", m[1])
+	}
+	return version, nil
+}
+
+// checkRelease fails with ErrStaleSchema if the cached schema has a higher
+// version
+func checkRelease(cached string, version int64) error {
+	content, err := os.ReadFile(cached)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read agent cache: %w", err)
+	}
+	last, err := schemaRelease(content)
+	if err != nil {
+		return fmt.Errorf("read agent cache: %w", err)
+	}
+	if version < last {
+		return fmt.Errorf("%w (version %d, applied %d)", ErrStaleSchema, version, last)
+	}
+	return nil
+}
+
+// writeCachedSchema atomically replaces the cached schema
+func writeCachedSchema(dir string, content []byte) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create agent cache: %w", err)
+	}
+	tmp := filepath.Join(dir, agentSchemaFile+".tmp")
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		return fmt.Errorf("write agent cache: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, agentSchemaFile)); err != nil {
+		return fmt.Errorf("write agent cache: %w", err)
+	}
+	return nil
+}
+
+// postJSON POSTs a value as JSON, e.g. an agent status
+func postJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := cmp.Or(client, http.DefaultClient).Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("POST %s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// RunAgent reconciles the database immediately and then every Interval
+// until ctx is cancelled. Failed passes are reported to OnReconcile and
+// retried on the next tick.
+func RunAgent(ctx context.Context, db *sql.DB, opts AgentOptions) error {
+	ticker := time.NewTicker(cmp.Or(opts.Interval, 5*time.Minute))
+	defer ticker.Stop()
+
+	for {
+		status, err := Reconcile(ctx, db, opts)
+		if opts.OnReconcile != nil {
+			opts.OnReconcile(status, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
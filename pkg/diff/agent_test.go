@@ -0,0 +1,234 @@
+package diff
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// schemaServer serves a schema signed with priv and records status reports
+func schemaServer(t *testing.T, priv ed25519.PrivateKey, content string) (*httptest.Server, *[]AgentStatus) {
+	t.Helper()
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(content)))
+	var reports []AgentStatus
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /schema.sql", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(content))
+	})
+	mux.HandleFunc("GET /schema.sql.sig", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(sig + "\n"))
+	})
+	mux.HandleFunc("POST /status", func(w http.ResponseWriter, r *http.Request) {
+		var s AgentStatus
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		reports = append(reports, s)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, &reports
+}
+
+func TestReconcile(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, reports := schemaServer(t, priv, "-- @version: 1\n"+`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`)
+
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY);
+		CREATE TABLE legacy (id INTEGER PRIMARY KEY);
+	`)
+	defer func() { _ = db.Close() }()
+
+	opts := AgentOptions{
+		Name:      "device-1",
+		SchemaURL: srv.URL + "/schema.sql",
+		PublicKey: pub,
+		CacheDir:  filepath.Join(t.TempDir(), "cache"),
+		ReportURL: srv.URL + "/status",
+	}
+	status, err := Reconcile(context.Background(), db, opts)
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	if len(status.Applied) != 1 || !strings.Contains(status.Applied[0], "name") {
+		t.Errorf("Applied = %v, want the added column", status.Applied)
+	}
+	if len(status.Pending) != 1 || !strings.Contains(status.Pending[0], "legacy") {
+		t.Errorf("Pending = %v, want the dropped table", status.Pending)
+	}
+	if status.InSync() || status.Cached || status.SchemaHash == "" {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	// The destructive change is left for an operator
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM sqlite_schema WHERE name = 'legacy'").Scan(&n); err != nil || n != 1 {
+		t.Errorf("legacy table was dropped (count %d, err %v)", n, err)
+	}
+
+	if len(*reports) != 1 || (*reports)[0].Name != "device-1" || len((*reports)[0].Applied) != 1 {
+		t.Errorf("reports = %+v, want one report of the pass", *reports)
+	}
+
+	// Offline, the cached schema is used
+	srv.Close()
+	opts.ReportURL = ""
+	status, err = Reconcile(context.Background(), db, opts)
+	if err != nil {
+		t.Fatalf("Reconcile() offline error = %v", err)
+	}
+	if !status.Cached || len(status.Applied) != 0 || len(status.Pending) != 1 {
+		t.Errorf("offline status = %+v, want cached schema with the drop pending", status)
+	}
+}
+
+func TestReconcile_BadSignature(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := schemaServer(t, priv, "-- @version: 1\n"+`CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+
+	db, _ := createTestDBWithPath(t, `CREATE TABLE legacy (id INTEGER PRIMARY KEY);`)
+	defer func() { _ = db.Close() }()
+
+	status, err := Reconcile(context.Background(), db, AgentOptions{
+		SchemaURL: srv.URL + "/schema.sql",
+		PublicKey: other,
+		CacheDir:  t.TempDir(),
+	})
+	if err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Fatalf("Reconcile() error = %v, want signature failure", err)
+	}
+	if status.Error == "" || len(status.Applied) != 0 {
+		t.Errorf("status = %+v, want the error and nothing applied", status)
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM sqlite_schema WHERE name = 'users'").Scan(&n); err != nil || n != 0 {
+		t.Errorf("unverified schema was applied (count %d, err %v)", n, err)
+	}
+}
+
+func TestReconcile_OnlyVerifiedSchema(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := schemaServer(t, priv, "-- @version: 1\n"+`CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+
+	db, _ := createTestDBWithPath(t, ``)
+	defer func() { _ = db.Close() }()
+
+	// Files planted next to the cached schema are not part of it
+	cacheDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(cacheDir, "extra.sql"), []byte(`CREATE TABLE planted (id INTEGER);`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(cacheDir, "checks"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cacheDir, "checks", "fail.sql"), []byte(`SELECT 1;`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := Reconcile(context.Background(), db, AgentOptions{
+		SchemaURL: srv.URL + "/schema.sql",
+		PublicKey: pub,
+		CacheDir:  cacheDir,
+	})
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if len(status.Applied) != 1 || !strings.Contains(status.Applied[0], "users") || status.Version != 1 {
+		t.Errorf("status = %+v, want only the signed table applied", status)
+	}
+}
+
+func TestReconcile_StaleSchema(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, _ := schemaServer(t, priv, "-- @version: 2\n"+`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`)
+	old, _ := schemaServer(t, priv, "-- @version: 1\n"+`CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	unversioned, _ := schemaServer(t, priv, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+
+	db, _ := createTestDBWithPath(t, ``)
+	defer func() { _ = db.Close() }()
+	opts := AgentOptions{
+		SchemaURL: current.URL + "/schema.sql",
+		PublicKey: pub,
+		CacheDir:  t.TempDir(),
+	}
+	if _, err := Reconcile(context.Background(), db, opts); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+
+	// The old release is validly signed, but must not roll the device back
+	opts.SchemaURL = old.URL + "/schema.sql"
+	if _, err := Reconcile(context.Background(), db, opts); !errors.Is(err, ErrStaleSchema) {
+		t.Fatalf("Reconcile() error = %v, want ErrStaleSchema", err)
+	}
+	opts.SchemaURL = unversioned.URL + "/schema.sql"
+	if _, err := Reconcile(context.Background(), db, opts); err == nil || !strings.Contains(err.Error(), "@version") {
+		t.Fatalf("Reconcile() error = %v, want the missing header", err)
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM pragma_table_info('users') WHERE name = 'name'").Scan(&n); err != nil || n != 1 {
+		t.Errorf("column name was dropped (count %d, err %v)", n, err)
+	}
+	cached, err := os.ReadFile(filepath.Join(opts.CacheDir, agentSchemaFile))
+	if err != nil || !strings.Contains(string(cached), "@version: 2") {
+		t.Errorf("cached schema = %q (err %v), want version 2", cached, err)
+	}
+}
+
+func TestRunAgent_StopsOnCancel(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := schemaServer(t, priv, "-- @version: 1\n"+`CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+
+	db, _ := createTestDBWithPath(t, ``)
+	defer func() { _ = db.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var passes int
+	err = RunAgent(ctx, db, AgentOptions{
+		SchemaURL: srv.URL + "/schema.sql",
+		PublicKey: pub,
+		CacheDir:  t.TempDir(),
+		OnReconcile: func(status AgentStatus, err error) {
+			passes++
+			if err != nil || len(status.Applied) != 1 {
+				t.Errorf("pass: status %+v, err %v", status, err)
+			}
+			cancel()
+		},
+	})
+	if err != nil || passes != 1 {
+		t.Errorf("RunAgent() = %v after %d passes, want nil after 1", err, passes)
+	}
+}
@@ -1,29 +1,292 @@
 package diff
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"os"
+	"slices"
 	"strings"
+	"time"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
 )
 
 // ApplyOptions configures how changes are applied
 type ApplyOptions struct {
+	DiffOptions
+
 	DryRun          bool
 	SkipDestructive bool
-	BackupPath      string // Path to create backup (empty = no backup)
+	SkipAbove       Severity       // Skip changes more severe than this, e.g. SeverityInfo to only add (empty = none)
+	BackupPath      string         // Path to create backup (empty = no backup)
+	BackupStrategy  BackupStrategy // How to create the backup (default BackupVacuum)
+	Strategy        ApplyStrategy  // How to change the database file (default StrategyInPlace)
+	SkipDiskCheck   bool           // Do not check free disk space before applying
+
+	// JournalPath writes the plan and its progress to this sidecar file
+	// while applying, usually the database path plus JournalSuffix. An
+	// existing journal makes applying fail with ErrInterruptedApply until
+	// the apply is resumed (see ResumeApply) or the journal is removed.
+	JournalPath string
+
+	// SelfCheck applies the plan to an in-memory copy of the schema first
+	// and refuses to apply it with ErrSelfCheckFailed unless the result
+	// equals the target (see SelfCheckPlan)
+	SelfCheck bool
+
+	// DeferIndexes commits all structural changes first and then creates new
+	// indexes one by one in their own short transactions, so writers are not
+	// blocked for the whole duration of large index builds
+	DeferIndexes bool
+	// IndexProgress is called after each deferred index has been created
+	IndexProgress func(done, total int, index string)
+
+	// PreApply runs after planning and before the backup and first write,
+	// e.g. to pause a replication tool. An error aborts the apply.
+	PreApply func() error
+	// PostApply runs after the apply finished or failed, once PreApply has
+	// succeeded, with the WAL checkpointed into the database file so an
+	// external tool resuming from it never sees a half-applied schema
+	PostApply func(applyErr error) error
+
+	// OnCommit is called after every committed transaction with the changes
+	// it contained, e.g. to inform replicas (see ChangeLogWriter)
+	OnCommit func(changes []Change)
+
+	// DataHooks are data migrations run right after the changes they are
+	// declared for, in the same transaction (see LoadDataHooks)
+	DataHooks []DataHook
+
+	// Reorder customizes the order of the planned changes, including data
+	// migrations, before they run. Orders that break a dependency are
+	// rejected (see ReorderChanges).
+	Reorder func(changes []Change) []Change
+
+	// SchemaVersion is the PRAGMA schema_version the changes were planned at
+	// (see SchemaVersion). If set, applying fails with ErrSchemaChanged when
+	// another process changed the schema since. When unset, Apply records it
+	// itself and plans again if the schema changes before the first write.
+	SchemaVersion *int64
+
+	// Windows restricts destructive and expensive changes to maintenance
+	// windows; outside all of them applying fails with ErrOutsideWindow.
+	// Empty means any time.
+	Windows []Window
+
+	// Classifier rates the planned changes before the safety checks run, so
+	// SkipDestructive and Windows follow it (see Classify). Nil keeps the
+	// planned Destructive flags.
+	Classifier Classifier
+
+	// WAL bounds the growth of the write-ahead log while applying (see
+	// WALPolicy). MaxWALSize is the size in bytes WALCheckpoint keeps the
+	// WAL under between commits.
+	WAL        WALPolicy
+	MaxWALSize int64
+
+	// TempStore selects where SQLite keeps temporary tables and indexes
+	// while applying. TempDir moves temporary files out of the system
+	// default, e.g. a small /tmp; it is set process-wide for the apply.
+	TempStore TempStore
+	TempDir   string
+
+	// Memory limits the page cache, memory-mapped I/O and heap used while
+	// applying (see MemoryBudget)
+	Memory MemoryBudget
+
+	// PlanCacheDir caches plans in this directory, so processes migrating
+	// the same database on startup skip planning once one of them has
+	// applied the schema (see CompareCached)
+	PlanCacheDir string
+
+	// Checks must pass before the changes are committed, in addition to
+	// the checks/*.sql queries in the schema directory (see LoadChecks)
+	Checks []Check
+
+	// Policies are checked against the plan that is applied, after
+	// SkipDestructive and SkipAbove, and the schema in schemaDir: a
+	// matching deny policy fails with ErrPolicyDenied, a matching approve
+	// policy with ErrApprovalRequired unless its approver is in ApprovedBy
+	// (see CheckPolicies)
+	Policies []Policy
+
+	// RequireApproval refuses with ErrApprovalRequired to apply plans that
+	// change objects owned by other teams than Team, unless their owners
+	// are in ApprovedBy (see SchemaOwners and CheckApprovals)
+	RequireApproval bool
+	Team            string
+	ApprovedBy      []string
+
+	// Queries are application queries the plan that is applied must not
+	// break: any that prepare now and fail against the migrated schema fail
+	// the apply with ErrQueriesBroken (see QueryImpact)
+	Queries []Query
+
+	// CheckViews selects no rows from every view before committing, so
+	// views that no longer compile against the new schema (e.g. because
+	// they use a dropped column) fail the apply instead of the application
+	CheckViews bool
+
+	// ExportDir exports the data destructive changes lose to files in this
+	// directory right before applying, as a lightweight escape hatch next
+	// to full backups (see ExportLostData)
+	ExportDir    string
+	ExportFormat ExportFormat
+
+	// QuarantineViolations moves existing rows that violate a new CHECK
+	// constraint of a recreated table into a "<table>_quarantine" table
+	// instead of refusing to apply (see ScanCheckViolations)
+	QuarantineViolations bool
+
+	// LowPriority applies changes in small batches, each committed in its own
+	// transaction with a pause in between, keeps dirty pages in memory
+	// (cache_spill off) and checkpoints the WAL after every batch, so busy
+	// databases keep serving writers. Recreated tables whose rows keep their
+	// rowids (see Change.CopyKey) are copied in batches of rows, while
+	// triggers mirror concurrent writes into the copy, and swapped in the
+	// batch of the recreate. The changes of a recreated table, such as
+	// dropping and creating its indexes and triggers, share a batch.
+	//
+	// The plan is no longer applied atomically: Checks run before the last
+	// batch commits, so a failing check only rolls back that batch, and
+	// the earlier ones stay applied (see JournalPath to resume).
+	LowPriority bool
+	BatchSize   int           // Changes per batch in low-priority mode (default 1)
+	BatchRows   int           // Rows per batch when copying a recreated table in low-priority mode (default 1000)
+	BatchPause  time.Duration // Pause between batches in low-priority mode (default 100ms)
+
+	resumeBackup string           // Backup of the interrupted apply ResumeApply continues
+	target       *schema.Database // Schema applied without a schema directory, see Reconcile
 }
 
+// ErrSchemaChanged is returned when the database schema changed between
+// planning and applying
+var ErrSchemaChanged = errors.New("database schema changed since the plan was made")
+
+// maxReplans is how often Apply plans again after a concurrent schema change
+const maxReplans = 3
+
 // Apply applies schema changes to a database
 func Apply(db *sql.DB, schemaDir string, opts ApplyOptions) error {
-	changes, err := Compare(db, schemaDir)
+	return ApplyContext(context.Background(), db, schemaDir, opts)
+}
+
+// ApplyContext is Apply with a context. Cancelling ctx stops planning,
+// interrupts the running statement and rolls back the open transaction;
+// changes of transactions committed before stay applied.
+func ApplyContext(ctx context.Context, db *sql.DB, schemaDir string, opts ApplyOptions) error {
+	pinned := opts.SchemaVersion != nil
+	for attempt := 0; ; attempt++ {
+		version, err := SchemaVersionContext(ctx, db)
+		if err != nil {
+			return err
+		}
+		changes, err := opts.compare(ctx, db, schemaDir)
+		if err != nil {
+			return err
+		}
+		if !pinned {
+			opts.SchemaVersion = &version
+		}
+
+		err = ApplyPlanContext(ctx, db, schemaDir, changes, opts)
+		if err == nil && len(changes) > 0 && !opts.DryRun && opts.PlanCacheDir != "" {
+			// Plan the migrated schema once, for the processes starting next
+			_, err = opts.compare(ctx, db, schemaDir)
+		}
+		if pinned || attempt == maxReplans || !errors.Is(err, ErrSchemaChanged) {
+			return err
+		}
+	}
+}
+
+// compare plans the changes, from the plan cache if configured
+func (opts ApplyOptions) compare(ctx context.Context, db *sql.DB, schemaDir string) ([]Change, error) {
+	if opts.PlanCacheDir != "" {
+		return compareCached(ctx, db, schemaDir, opts.DiffOptions, opts.PlanCacheDir)
+	}
+	return CompareContext(ctx, db, schemaDir, opts.DiffOptions)
+}
+
+// SchemaVersion returns PRAGMA schema_version, which SQLite increments on
+// every schema change
+func SchemaVersion(db querier) (int64, error) {
+	return SchemaVersionContext(context.Background(), db)
+}
+
+// SchemaVersionContext is SchemaVersion with a context
+func SchemaVersionContext(ctx context.Context, db querier) (int64, error) {
+	rows, err := db.QueryContext(ctx, "PRAGMA schema_version")
+	if err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var version int64
+	if rows.Next() {
+		if err := rows.Scan(&version); err != nil {
+			return 0, fmt.Errorf("read schema version: %w", err)
+		}
+	}
+	return version, rows.Err()
+}
+
+// checkSchemaVersion fails with ErrSchemaChanged if the schema is no longer
+// at the expected version
+func checkSchemaVersion(ctx context.Context, db querier, expected *int64) error {
+	if expected == nil {
+		return nil
+	}
+	version, err := SchemaVersionContext(ctx, db)
 	if err != nil {
 		return err
 	}
+	if version != *expected {
+		return fmt.Errorf("%w (schema version %d, planned at %d)", ErrSchemaChanged, version, *expected)
+	}
+	return nil
+}
+
+// ApplyPlan applies previously planned changes, e.g. from a plan file, with
+// the same safety checks, backup and hooks as Apply. The schema directory is
+// only read for checks.
+func ApplyPlan(db *sql.DB, schemaDir string, changes []Change, opts ApplyOptions) error {
+	return ApplyPlanContext(context.Background(), db, schemaDir, changes, opts)
+}
+
+// ApplyPlanContext is ApplyPlan with a context, see ApplyContext
+func ApplyPlanContext(ctx context.Context, db *sql.DB, schemaDir string, changes []Change, opts ApplyOptions) error {
+	if err := checkJournal(opts.JournalPath); err != nil {
+		return err
+	}
+	return applyPlan(ctx, db, schemaDir, changes, opts)
+}
 
+// applyPlan is ApplyPlan without looking for an interrupted apply
+func applyPlan(ctx context.Context, db *sql.DB, schemaDir string, changes []Change, opts ApplyOptions) error {
 	if opts.DryRun || len(changes) == 0 {
 		return nil
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if opts.Classifier != nil {
+		changes = slices.Clone(changes)
+		if err := ClassifyContext(ctx, db, changes, opts.Classifier); err != nil {
+			return err
+		}
+	}
+
+	// Check the whole plan, skipping destructive changes leaves differences
+	if opts.SelfCheck {
+		if err := selfCheckApply(ctx, db, schemaDir, opts.target, changes, opts.DiffOptions); err != nil {
+			return err
+		}
+	}
 
 	// Filter out destructive if requested
 	if opts.SkipDestructive {
@@ -38,18 +301,388 @@ func Apply(db *sql.DB, schemaDir string, opts ApplyOptions) error {
 			return nil
 		}
 	}
+	if opts.SkipAbove != "" {
+		if _, err := ParseSeverity(string(opts.SkipAbove)); err != nil {
+			return err
+		}
+		if changes = SkipAbove(changes, opts.SkipAbove); len(changes) == 0 {
+			return nil
+		}
+	}
+
+	if len(opts.Policies) > 0 || opts.RequireApproval {
+		target := opts.target
+		if target == nil && schemaDir != "" {
+			var err error
+			if target, err = parser.ReadFilesContext(ctx, schemaDir, opts.Parse); err != nil {
+				return err
+			}
+		}
+		if err := CheckPolicies(opts.Policies, changes, target, opts.ApprovedBy...); err != nil {
+			return err
+		}
+		if opts.RequireApproval {
+			current, err := parser.FromDBContext(ctx, db)
+			if err != nil {
+				return err
+			}
+			if err := CheckApprovals(changes, SchemaOwners(current, target), opts.Team, opts.ApprovedBy); err != nil {
+				return err
+			}
+		}
+	}
+	if len(opts.Queries) > 0 {
+		current, err := parser.FromDBContext(ctx, db)
+		if err != nil {
+			return err
+		}
+		if err := CheckQueries(current, changes, opts.Queries); err != nil {
+			return err
+		}
+	}
+
+	if err := CheckWindows(changes, opts.Windows, time.Now()); err != nil {
+		return err
+	}
+
+	// Find rows that would make a recreate fail on a new CHECK constraint
+	violations, err := ScanCheckViolationsContext(ctx, db, changes)
+	if err != nil {
+		return fmt.Errorf("scan check violations: %w", err)
+	}
+	if len(violations) > 0 {
+		if !opts.QuarantineViolations {
+			msgs := make([]string, len(violations))
+			for i, v := range violations {
+				msgs[i] = v.String()
+			}
+			return fmt.Errorf("existing rows violate new CHECK constraints:\n  %s", strings.Join(msgs, "\n  "))
+		}
+		for i := range changes {
+			var checks []string
+			for _, v := range violations {
+				if v.Table == changes[i].Object && changes[i].RecreatesTable() {
+					checks = append(checks, v.Check)
+				}
+			}
+			if err := quarantineViolations(db, &changes[i], checks); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Refuse to start when the disk cannot hold the backup and temporary copies
+	if !opts.SkipDiskCheck {
+		if err := checkApplyDiskSpace(ctx, db, changes, opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.PreApply != nil {
+		if err := opts.PreApply(); err != nil {
+			return fmt.Errorf("pre-apply hook: %w", err)
+		}
+	}
+	err = applyChanges(ctx, db, schemaDir, changes, opts)
+	if opts.PostApply != nil {
+		// Move all committed pages into the database file before resuming
+		_, _ = db.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+		if hookErr := opts.PostApply(err); hookErr != nil {
+			err = errors.Join(err, fmt.Errorf("post-apply hook: %w", hookErr))
+		}
+	}
+	return err
+}
+
+// checkApplyDiskSpace refuses to apply changes when the disk cannot hold the
+// backup, the temporary copies and the WAL/journal growth. Without table
+// sizes there is nothing to check against, and the check is skipped.
+func checkApplyDiskSpace(ctx context.Context, db *sql.DB, changes []Change, opts ApplyOptions) error {
+	est, err := EstimateChangesContext(ctx, db, changes)
+	if errors.Is(err, ErrNoSizes) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("estimate migration: %w", err)
+	}
+	if opts.BackupStrategy == BackupCopy || opts.BackupStrategy == BackupAuto {
+		// A copy holds the whole file and WAL, free pages included
+		est.BackupBytes = max(est.BackupBytes, fileBytes(db))
+	}
+	if opts.Strategy != StrategyInPlace {
+		// A rebuild writes a compacted copy of the whole database
+		est.TempBytes = max(est.TempBytes, est.BackupBytes)
+	}
+	return CheckDiskSpaceAt(db, est, opts.BackupPath)
+}
+
+// applyChanges backs up the database and executes a planned migration
+func applyChanges(ctx context.Context, db *sql.DB, schemaDir string, changes []Change, opts ApplyOptions) (err error) {
+	changes = AttachDataHooks(changes, opts.DataHooks)
+	if opts.Reorder != nil {
+		if changes, err = ReorderChanges(changes, opts.Reorder); err != nil {
+			return fmt.Errorf("reorder changes: %w", err)
+		}
+	}
+
+	if err := checkSchemaVersion(ctx, db, opts.SchemaVersion); err != nil {
+		return err
+	}
+
+	var current *schema.Database
+	rebuild := false
+	if opts.Strategy != StrategyInPlace {
+		if current, err = parser.FromDBContext(ctx, db); err != nil {
+			return err
+		}
+		var mode string
+		if err := db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&mode); err != nil {
+			return fmt.Errorf("journal mode: %w", err)
+		}
+		if rebuild, err = opts.useRebuild(current, changes, strings.EqualFold(mode, "wal")); err != nil {
+			return err
+		}
+	}
 
 	// Create backup if path provided
 	if opts.BackupPath != "" {
-		_ = os.Remove(opts.BackupPath)                             // Ignore error if doesn't exist
-		safePath := strings.ReplaceAll(opts.BackupPath, "'", "''") // Escape single quotes for SQL
-		if _, err := db.Exec(fmt.Sprintf("VACUUM INTO '%s'", safePath)); err != nil {
+		if err := createBackup(ctx, db, opts.BackupPath, opts.BackupStrategy); err != nil {
 			return fmt.Errorf("create backup: %w", err)
 		}
 	}
 
-	// Execute in transaction with foreign keys disabled
-	tx, err := db.Begin()
+	if opts.ExportDir != "" {
+		if _, err := ExportLostDataContext(ctx, db, changes, opts.ExportDir, opts.ExportFormat); err != nil {
+			return fmt.Errorf("export lost data: %w", err)
+		}
+	}
+
+	var checks []Check
+	if schemaDir != "" {
+		if checks, err = LoadChecks(schemaDir); err != nil {
+			return fmt.Errorf("load checks: %w", err)
+		}
+	}
+	checks = append(checks, opts.Checks...)
+	if opts.CheckViews {
+		views, err := viewChecks(db, changes)
+		if err != nil {
+			return err
+		}
+		checks = append(checks, views...)
+	}
+
+	// The swap is atomic, there is no partial state to journal
+	if rebuild {
+		if err := rebuildDatabase(ctx, db, current, changes, checks); err != nil {
+			return err
+		}
+		opts.committed(changes)
+		return nil
+	}
+
+	var deferred []Change
+	if opts.DeferIndexes {
+		changes, deferred = splitDeferredIndexes(changes)
+	}
+
+	// Resolved before holding the connection, which may be the only one
+	walPath, err := databasePath(db)
+	if err != nil {
+		return err
+	}
+	if walPath != "" {
+		walPath += "-wal"
+	}
+
+	// Use a single connection so connection-level pragmas apply to every batch
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire connection: %w", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// Journal the plan in the order it runs, deferred indexes last
+	journal, err := startJournal(ctx, conn, opts.JournalPath, cmp.Or(opts.BackupPath, opts.resumeBackup),
+		append(slices.Clone(changes), deferred...))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, journal.finish(err))
+	}()
+
+	restoreWAL, err := prepareWAL(ctx, conn, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, restoreWAL())
+	}()
+	restoreTemp, err := prepareTempStore(ctx, conn, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, restoreTemp())
+	}()
+	restoreMemory, err := prepareMemory(ctx, conn, opts.Memory)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, restoreMemory())
+	}()
+
+	batches := [][]Change{changes}
+	if opts.LowPriority {
+		// Keep dirty pages in memory instead of taking an exclusive lock mid-transaction
+		if _, err := conn.ExecContext(ctx, "PRAGMA cache_spill = OFF"); err != nil {
+			return fmt.Errorf("disable cache spill: %w", err)
+		}
+		defer func() {
+			_, _ = conn.ExecContext(ctx, "PRAGMA cache_spill = ON")
+		}()
+		batches = batchChanges(changes, cmp.Or(opts.BatchSize, 1))
+	}
+
+	for i, batch := range batches {
+		if i > 0 {
+			opts.pause(ctx)
+		}
+		// Checks run once, against the final state before the last commit
+		var batchChecks []Check
+		if i == len(batches)-1 {
+			batchChecks = checks
+		}
+		// Later batches see the schema changed by the earlier ones
+		var version *int64
+		if i == 0 {
+			version = opts.SchemaVersion
+		}
+		if err := applyBatchCopying(ctx, conn, batch, batchChecks, version, walPath, opts); err != nil {
+			return err
+		}
+		opts.committed(batch)
+		if err := journal.record(ctx, conn, len(batch)); err != nil {
+			return err
+		}
+		if err := limitWAL(ctx, conn, walPath, opts); err != nil {
+			return err
+		}
+		if opts.LowPriority {
+			if _, err := conn.ExecContext(ctx, "PRAGMA wal_checkpoint(PASSIVE)"); err != nil {
+				return fmt.Errorf("checkpoint: %w", err)
+			}
+		}
+	}
+
+	for i, change := range deferred {
+		opts.pause(ctx)
+		if err := createDeferredIndex(ctx, conn, change, opts.OnEvent); err != nil {
+			return err
+		}
+		opts.committed([]Change{change})
+		if err := journal.record(ctx, conn, 1); err != nil {
+			return err
+		}
+		if err := limitWAL(ctx, conn, walPath, opts); err != nil {
+			return err
+		}
+		if opts.IndexProgress != nil {
+			opts.IndexProgress(i+1, len(deferred), change.Object)
+		}
+	}
+
+	return nil
+}
+
+// pause sleeps between batches in low-priority mode, or until ctx is
+// cancelled
+func (opts ApplyOptions) pause(ctx context.Context) {
+	if opts.LowPriority {
+		select {
+		case <-time.After(cmp.Or(opts.BatchPause, 100*time.Millisecond)):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// committed reports a committed transaction to OnCommit
+func (opts ApplyOptions) committed(changes []Change) {
+	if opts.OnCommit != nil {
+		opts.OnCommit(changes)
+	}
+}
+
+// batchChanges splits changes into groups of at most size changes. Data
+// migrations always stay in the batch of the change they run after, and
+// the changes of a recreated table, from dropping its triggers and indexes
+// to creating them again, stay in one batch, so no commit leaves the table
+// without them.
+func batchChanges(changes []Change, size int) [][]Change {
+	// joined[i] keeps change i in the batch of change i-1
+	joined := make([]bool, len(changes))
+	for i, c := range changes {
+		joined[i] = joined[i] || c.Type == DataMigration
+		if !c.RecreatesTable() {
+			continue
+		}
+		first, last := i, i
+		for j, other := range changes {
+			// Including a copy a killed low-priority apply left behind
+			if other.Object == c.Object || other.Table == c.Object || other.Object == c.Object+"__new" {
+				first, last = min(first, j), max(last, j)
+			}
+		}
+		for j := first + 1; j <= last; j++ {
+			joined[j] = true
+		}
+	}
+
+	var batches [][]Change
+	for i, c := range changes {
+		last := len(batches) - 1
+		if last < 0 || (len(batches[last]) >= size && !joined[i]) {
+			batches = append(batches, nil)
+			last++
+		}
+		batches[last] = append(batches[last], c)
+	}
+	return batches
+}
+
+// applyBatchCopying applies a batch with applyBatch. In low-priority mode,
+// the rows of recreated tables are copied in batches of rows first (see
+// copyInBatches), and the batch only swaps the tables.
+func applyBatchCopying(ctx context.Context, conn *sql.Conn, batch []Change, checks []Check, version *int64, walPath string, opts ApplyOptions) (err error) {
+	var copies []batchCopy
+	if opts.LowPriority {
+		batch, copies = splitBatchCopies(batch)
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, discardBatchCopies(ctx, conn, copies))
+		}
+	}()
+
+	for _, bc := range copies {
+		if err := copyInBatches(ctx, conn, bc, version, walPath, opts); err != nil {
+			return err
+		}
+		// The copy changed the schema after checking the version
+		version = nil
+	}
+	return applyBatch(ctx, conn, batch, checks, version, opts.OnEvent)
+}
+
+// applyBatch executes changes in a single transaction with foreign keys
+// disabled and checks for foreign key violations and failing checks before
+// committing
+func applyBatch(ctx context.Context, conn *sql.Conn, changes []Change, checks []Check, version *int64, onEvent func(Event)) error {
+	tx, err := conn.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("begin transaction: %w", err)
 	}
@@ -57,20 +690,18 @@ func Apply(db *sql.DB, schemaDir string, opts ApplyOptions) error {
 		_ = tx.Rollback()
 	}()
 
+	// Reading the version starts the transaction's snapshot, so a schema
+	// change after this point makes the first write fail instead
+	if err := checkSchemaVersion(ctx, tx, version); err != nil {
+		return err
+	}
+
 	if _, err := tx.Exec("PRAGMA foreign_keys = OFF"); err != nil {
 		return fmt.Errorf("disable foreign keys: %w", err)
 	}
 
-	for _, change := range changes {
-		for _, stmt := range change.SQL {
-			stmt = strings.TrimSpace(stmt)
-			if stmt == "" || strings.HasPrefix(stmt, "--") {
-				continue
-			}
-			if _, err := tx.Exec(stmt); err != nil {
-				return fmt.Errorf("%s: %w\nSQL: %s", change.Description, err, stmt)
-			}
-		}
+	if err := executeChanges(ctx, tx, changes, onEvent); err != nil {
+		return err
 	}
 
 	if _, err := tx.Exec("PRAGMA foreign_keys = ON"); err != nil {
@@ -78,7 +709,23 @@ func Apply(db *sql.DB, schemaDir string, opts ApplyOptions) error {
 	}
 
 	// Check for FK violations before committing
-	rows, err := tx.Query("PRAGMA foreign_key_check")
+	if err := checkForeignKeys(ctx, tx); err != nil {
+		return err
+	}
+
+	if err := runChecks(ctx, tx, checks); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// checkForeignKeys fails if any row violates a foreign key
+func checkForeignKeys(ctx context.Context, db querier) error {
+	rows, err := db.QueryContext(ctx, "PRAGMA foreign_key_check")
 	if err != nil {
 		return fmt.Errorf("foreign key check: %w", err)
 	}
@@ -98,10 +745,91 @@ func Apply(db *sql.DB, schemaDir string, opts ApplyOptions) error {
 		return fmt.Errorf("migration would create foreign key violations")
 	}
 	_ = rows.Close()
+	return nil
+}
 
+// splitDeferredIndexes separates index creation from the rest of the plan
+func splitDeferredIndexes(changes []Change) (structural, indexes []Change) {
+	for _, c := range changes {
+		if c.Type == CreateIndex {
+			indexes = append(indexes, c)
+		} else {
+			structural = append(structural, c)
+		}
+	}
+	return structural, indexes
+}
+
+// createDeferredIndex builds a single index in its own transaction
+func createDeferredIndex(ctx context.Context, conn *sql.Conn, change Change, onEvent func(Event)) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := executeChanges(ctx, tx, []Change{change}, onEvent); err != nil {
+		return err
+	}
 	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("commit: %w", err)
+		return fmt.Errorf("commit index %q: %w", change.Object, err)
 	}
+	return nil
+}
+
+// execer is implemented by *sql.DB, *sql.Tx and *sql.Conn
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
 
+// executeChanges runs the SQL of every change in order, reporting each
+// statement to onEvent if it is set. Cancelling ctx interrupts the running
+// statement.
+func executeChanges(ctx context.Context, db execer, changes []Change, onEvent func(Event)) error {
+	for _, change := range changes {
+		for _, stmt := range change.SQL {
+			if isCommentOnly(stmt) {
+				continue
+			}
+			start := time.Now()
+			result, err := db.ExecContext(ctx, stmt)
+			if err != nil {
+				return fmt.Errorf("%s: %w\nSQL: %s", change.Description, err, stmt)
+			}
+			if onEvent == nil {
+				continue
+			}
+			onEvent(StatementExecuted{Change: change, SQL: stmt, Duration: time.Since(start)})
+			if isCopyStatement(change, stmt) {
+				rows, _ := result.RowsAffected()
+				onEvent(BatchCopied{Table: change.Object, Rows: rows})
+			}
+		}
+	}
 	return nil
 }
+
+// isCommentOnly reports whether stmt contains nothing but whitespace and comments
+func isCommentOnly(stmt string) bool {
+	for {
+		stmt = strings.TrimSpace(stmt)
+		switch {
+		case strings.HasPrefix(stmt, "--"):
+			_, rest, found := strings.Cut(stmt, "\n")
+			if !found {
+				return true
+			}
+			stmt = rest
+		case strings.HasPrefix(stmt, "/*"):
+			_, rest, found := strings.Cut(stmt, "*/")
+			if !found {
+				return true
+			}
+			stmt = rest
+		default:
+			return stmt == ""
+		}
+	}
+}
@@ -1,14 +1,62 @@
 package diff
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
-	_ "modernc.org/sqlite"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"modernc.org/sqlite"
 )
 
+func TestApplyContext_Cancelled(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "schema.sql", `
+		CREATE TABLE users (id INTEGER PRIMARY KEY);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY);
+		CREATE TABLE tags (id INTEGER PRIMARY KEY);
+	`)
+
+	// Cancelled after the first batch committed, the pause between batches
+	// ends early and the second batch never starts
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var committed []string
+	opts := ApplyOptions{
+		LowPriority: true,
+		BatchSize:   1,
+		BatchPause:  time.Hour,
+		OnCommit: func(changes []Change) {
+			committed = append(committed, changes[0].Object)
+			cancel()
+		},
+	}
+	if err := ApplyContext(ctx, db, schemaDir, opts); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ApplyContext() error = %v, want context.Canceled", err)
+	}
+	if len(committed) != 1 {
+		t.Fatalf("committed %v, want only the first batch", committed)
+	}
+
+	// The committed batch stays, the rest is planned again
+	changes, err := Compare(db, schemaDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Object == committed[0] {
+		t.Errorf("changes after cancel = %+v, want the table of the second batch", changes)
+	}
+}
+
 func TestApply_NoChanges(t *testing.T) {
 	db, _ := createTestDBWithPath(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
 	defer func() { _ = db.Close() }()
@@ -223,3 +271,758 @@ func TestApply_NoBackupWhenEmpty(t *testing.T) {
 		t.Error("backup file should not exist when BackupPath is empty")
 	}
 }
+
+func TestApply_DeferIndexes(t *testing.T) {
+	db, dbPath := createTestDBWithPath(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "users.sql", `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT);
+		CREATE INDEX idx_users_name ON users(name);
+		CREATE UNIQUE INDEX idx_users_email ON users(email);
+	`)
+
+	var progress []string
+	err := Apply(db, schemaDir, ApplyOptions{
+		DeferIndexes: true,
+		IndexProgress: func(done, total int, index string) {
+			if total != 2 {
+				t.Errorf("IndexProgress total = %d, want 2", total)
+			}
+			progress = append(progress, index)
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(progress) != 2 {
+		t.Errorf("expected progress for 2 indexes, got %v", progress)
+	}
+
+	checkDB, _ := sql.Open("sqlite", dbPath)
+	defer func() { _ = checkDB.Close() }()
+
+	var count int
+	if err := checkDB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='index' AND name LIKE 'idx_users_%'").
+		Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 indexes, got %d", count)
+	}
+}
+
+func TestApply_LowPriority(t *testing.T) {
+	db, dbPath := createTestDBWithPath(t, `
+		PRAGMA journal_mode = WAL;
+		CREATE TABLE users (id INTEGER PRIMARY KEY);
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "schema.sql", `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id));
+		CREATE INDEX idx_posts_user ON posts(user_id);
+	`)
+
+	err := Apply(db, schemaDir, ApplyOptions{
+		LowPriority:  true,
+		BatchSize:    2,
+		BatchPause:   time.Millisecond,
+		DeferIndexes: true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	checkDB, _ := sql.Open("sqlite", dbPath)
+	defer func() { _ = checkDB.Close() }()
+
+	var count int
+	if err := checkDB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name IN ('posts', 'idx_posts_user')").
+		Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected posts table and index, got %d objects", count)
+	}
+}
+
+func TestBatchChanges_KeepsRecreatedTableTogether(t *testing.T) {
+	changes := []Change{
+		{Type: DropTrigger, Object: "audit_users", Table: "users"},
+		{Type: RecreateTable, Object: "users"},
+		{Type: CreateIndex, Object: "idx_users_email", Table: "users"},
+		{Type: CreateTrigger, Object: "audit_users", Table: "users"},
+		{Type: CreateTable, Object: "posts"},
+	}
+	batches := batchChanges(changes, 1)
+	if len(batches) != 2 || len(batches[0]) != 4 || batches[1][0].Object != "posts" {
+		t.Errorf("batchChanges() = %+v, want the changes of users in the first batch", batches)
+	}
+}
+
+func TestApply_LowPriorityCopiesInBatches(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 25)
+		INSERT INTO users (id, name) SELECT i, 'user ' || i FROM n;
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "users.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);`)
+
+	changes, err := Compare(db, schemaDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].CopyKey == "" {
+		t.Fatalf("changes = %+v, want a recreate keeping the rowids", changes)
+	}
+
+	// Other connections keep writing between the batches
+	writes := []string{
+		`INSERT INTO users (id, name) VALUES (100, 'new')`,
+		`UPDATE users SET name = 'renamed' WHERE id IN (1, 20)`,
+		`DELETE FROM users WHERE id IN (2, 21)`,
+	}
+	var copied []int64
+	opts := ApplyOptions{LowPriority: true, BatchRows: 10, BatchPause: time.Nanosecond}
+	opts.OnEvent = func(e Event) {
+		if b, ok := e.(BatchCopied); ok {
+			copied = append(copied, b.Rows)
+			if len(copied) == 1 {
+				for _, stmt := range writes {
+					if _, err := db.Exec(stmt); err != nil {
+						t.Errorf("%s: %v", stmt, err)
+					}
+				}
+			}
+		}
+	}
+	if err := Apply(db, schemaDir, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// The later batches skip the rows the triggers already mirrored
+	if !slices.Equal(copied, []int64{10, 9, 4}) {
+		t.Errorf("copied batches of %v rows", copied)
+	}
+	var count int
+	var renamed, deleted string
+	if err := db.QueryRow(`SELECT count(*), group_concat(CASE WHEN name = 'renamed' THEN id END),
+		(SELECT group_concat(id) FROM (SELECT 2 AS id UNION SELECT 21) WHERE id NOT IN (SELECT id FROM users))
+		FROM users`).Scan(&count, &renamed, &deleted); err != nil {
+		t.Fatal(err)
+	}
+	if count != 24 || renamed != "1,20" || deleted != "2,21" {
+		t.Errorf("users has %d rows, renamed %s and deleted %s, want 24, 1,20 and 2,21", count, renamed, deleted)
+	}
+	if left, _ := queryStrings(db, "SELECT name FROM sqlite_master WHERE name LIKE 'users__new%'"); len(left) > 0 {
+		t.Errorf("copy left %v behind", left)
+	}
+}
+
+func TestApply_LowPriorityCancelledCopy(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+		INSERT INTO users (name) VALUES ('a'), ('b'), ('c');
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "users.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := ApplyOptions{LowPriority: true, BatchRows: 1, BatchPause: time.Hour}
+	opts.OnEvent = func(e Event) {
+		if _, ok := e.(BatchCopied); ok {
+			cancel()
+		}
+	}
+	if err := ApplyContext(ctx, db, schemaDir, opts); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ApplyContext() error = %v, want context.Canceled", err)
+	}
+
+	// The old table is untouched and the copy is gone
+	if left, _ := queryStrings(db, "SELECT name FROM sqlite_master WHERE name LIKE 'users__new%'"); len(left) > 0 {
+		t.Errorf("cancelled copy left %v behind", left)
+	}
+	if changes, err := Compare(db, schemaDir); err != nil || len(changes) != 1 {
+		t.Errorf("changes after cancel = %+v (%v), want the recreate again", changes, err)
+	}
+}
+
+func TestApply_LowPriorityChecksLastBatch(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "schema.sql", `
+		CREATE TABLE users (id INTEGER PRIMARY KEY);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY);
+		CREATE TABLE tags (id INTEGER PRIMARY KEY);
+	`)
+
+	// A failing check rolls back the last batch only
+	err := Apply(db, schemaDir, ApplyOptions{
+		LowPriority: true,
+		BatchPause:  time.Nanosecond,
+		Checks:      []Check{{Name: "fails", SQL: "SELECT 1"}},
+	})
+	if err == nil {
+		t.Fatal("expected the check to fail")
+	}
+	changes, err := Compare(db, schemaDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Object != "tags" {
+		t.Errorf("changes after the failed check = %+v, want only the last batch", changes)
+	}
+}
+
+func TestApply_IgnoreColumnOrder(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);
+		INSERT INTO users (email) VALUES ('a@example.com');
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(
+		t,
+		"users.sql",
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT);`,
+	)
+	ignore := DiffOptions{ColumnOrder: ColumnOrderIgnore}
+
+	changes, err := CompareWithOptions(db, schemaDir, ignore)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(changes) != 1 || changes[0].Type != AddColumn || changes[0].Destructive {
+		t.Fatalf("expected a single ADD COLUMN, got %+v", changes)
+	}
+
+	if err := Apply(db, schemaDir, ApplyOptions{DiffOptions: ignore}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The appended column is out of order but must not be reported again
+	changes, err = CompareWithOptions(db, schemaDir, ignore)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes after apply, got %+v", changes)
+	}
+
+	// The strict policy still wants the declared order
+	changes, err = Compare(db, schemaDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(changes) != 1 || changes[0].Type != RecreateTable {
+		t.Errorf("expected RECREATE_TABLE under the strict policy, got %+v", changes)
+	}
+}
+
+func TestApply_Backfill(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);
+		INSERT INTO users (email) VALUES ('Ann@Example.com'), (NULL);
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "users.sql", `CREATE TABLE users (
+		id INTEGER PRIMARY KEY,
+		email TEXT,
+		email_lower TEXT NOT NULL, -- @backfill: coalesce(lower(email), '')
+		domain TEXT
+	);`)
+	opts := ApplyOptions{DiffOptions: DiffOptions{Backfill: map[string]string{
+		"users.domain": "substr(email, instr(email, '@') + 1)",
+	}}}
+
+	changes, err := CompareWithOptions(db, schemaDir, opts.DiffOptions)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(changes) != 1 || changes[0].Type != RecreateTable {
+		t.Fatalf("expected NOT NULL backfill to recreate the table, got %+v", changes)
+	}
+
+	if err := Apply(db, schemaDir, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var lower, domain string
+	if err := db.QueryRow("SELECT email_lower, domain FROM users WHERE id = 1").
+		Scan(&lower, &domain); err != nil {
+		t.Fatalf("query backfilled row: %v", err)
+	}
+	if lower != "ann@example.com" || domain != "Example.com" {
+		t.Errorf("backfilled values = %q, %q", lower, domain)
+	}
+
+	changes, err = CompareWithOptions(db, schemaDir, opts.DiffOptions)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes after apply, got %+v", changes)
+	}
+}
+
+func TestApply_TriggersDoNotFireDuringCopy(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		strategy ApplyStrategy
+		table    string
+	}{
+		{"recreate", StrategyInPlace, "CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL);"},
+		{"rebuild", StrategyRebuild, "CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL);"},
+		{"backfill", StrategyInPlace, `CREATE TABLE users (
+			id INTEGER PRIMARY KEY,
+			email TEXT,
+			domain TEXT -- @backfill: substr(email, instr(email, '@') + 1)
+		);`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			const triggers = `
+				CREATE TABLE audit (user_id INTEGER, action TEXT);
+				CREATE TRIGGER audit_insert AFTER INSERT ON users BEGIN INSERT INTO audit VALUES (new.id, 'insert'); END;
+				CREATE TRIGGER audit_update AFTER UPDATE ON users BEGIN INSERT INTO audit VALUES (new.id, 'update'); END;
+			`
+			db, _ := createTestDBWithPath(t, `
+				CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);
+				INSERT INTO users (email) VALUES ('ann@example.com'), ('bob@example.com');
+			`+triggers)
+			defer func() { _ = db.Close() }()
+			schemaDir := createSchemaDir(t, "schema.sql", tt.table+triggers)
+
+			if err := Apply(db, schemaDir, ApplyOptions{Strategy: tt.strategy}); err != nil {
+				t.Fatalf("Apply() error: %v", err)
+			}
+			var audited int
+			if err := db.QueryRow("SELECT count(*) FROM audit").Scan(&audited); err != nil {
+				t.Fatal(err)
+			}
+			if audited != 0 {
+				t.Errorf("triggers fired %d times during the migration", audited)
+			}
+
+			// The triggers are back afterwards
+			if _, err := db.Exec("INSERT INTO users (email) VALUES ('cid@example.com')"); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.QueryRow("SELECT count(*) FROM audit").Scan(&audited); err != nil {
+				t.Fatal(err)
+			}
+			if audited != 1 {
+				t.Errorf("got %d audit rows after an insert, want 1", audited)
+			}
+		})
+	}
+}
+
+func TestApply_ColumnExpressions(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, first_name TEXT, last_name TEXT);
+		INSERT INTO users (first_name, last_name) VALUES ('Ann', 'Lee');
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "users.sql", `CREATE TABLE users (
+		id INTEGER PRIMARY KEY,
+		-- @from: first_name || ' ' || last_name
+		name TEXT NOT NULL
+	);`)
+
+	if err := Apply(db, schemaDir, ApplyOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var name string
+	if err := db.QueryRow("SELECT name FROM users WHERE id = 1").Scan(&name); err != nil {
+		t.Fatalf("query mapped row: %v", err)
+	}
+	if name != "Ann Lee" {
+		t.Errorf("name = %q, want Ann Lee", name)
+	}
+}
+
+func TestApply_SingleConnection(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts ApplyOptions
+	}{
+		{"default", ApplyOptions{}},
+		{"low priority", ApplyOptions{LowPriority: true}},
+		{"wal checkpoint", ApplyOptions{WAL: WALCheckpoint, MaxWALSize: 1}},
+		{"journal", ApplyOptions{JournalPath: filepath.Join(t.TempDir(), "apply"+JournalSuffix)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := createTestDBWithPath(t, `
+				CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+				INSERT INTO users (name) VALUES ('ann');
+			`)
+			defer func() { _ = db.Close() }()
+			// A common setting for SQLite, nothing may wait for a second connection
+			db.SetMaxOpenConns(1)
+			schemaDir := createSchemaDir(t, "users.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);`)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := ApplyContext(ctx, db, schemaDir, tt.opts); err != nil {
+				t.Fatalf("ApplyContext() error: %v", err)
+			}
+		})
+	}
+}
+
+func TestApply_ColumnExpressionsApplyOnce(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE items (id INTEGER PRIMARY KEY, price REAL, first_name TEXT, last_name TEXT);
+		INSERT INTO items (price, first_name, last_name) VALUES (1.5, 'Ann', 'Lee');
+	`)
+	defer func() { _ = db.Close() }()
+	const columns = `
+		id INTEGER PRIMARY KEY,
+		-- @from: CAST(price * 100 AS INTEGER)
+		price INTEGER,
+		-- @from: first_name || ' ' || last_name
+		name TEXT NOT NULL`
+
+	if err := Apply(db, createSchemaDir(t, "items.sql", "CREATE TABLE items ("+columns+"\n);"), ApplyOptions{}); err != nil {
+		t.Fatalf("first apply: %v", err)
+	}
+	// The annotations stay in the schema, an unrelated recreate must not
+	// run them again
+	schemaDir := createSchemaDir(t, "items.sql", "CREATE TABLE items ("+columns+",\n\t\tCHECK (price >= 0)\n);")
+	if err := Apply(db, schemaDir, ApplyOptions{}); err != nil {
+		t.Fatalf("second apply: %v", err)
+	}
+
+	var price int
+	var name string
+	if err := db.QueryRow("SELECT price, name FROM items WHERE id = 1").Scan(&price, &name); err != nil {
+		t.Fatal(err)
+	}
+	if price != 150 || name != "Ann Lee" {
+		t.Errorf("price = %d, name = %q, want 150 and Ann Lee", price, name)
+	}
+}
+
+func TestApply_CustomCollation(t *testing.T) {
+	// Collations the host application registers must also be available on
+	// the connections the library opens itself
+	sqlite.MustRegisterCollationUtf8("app_test_reverse", func(left, right string) int {
+		return strings.Compare(right, left)
+	})
+	var opened int
+	parser.SetOpener(func(dsn string) (*sql.DB, error) {
+		opened++
+		return sql.Open("sqlite", dsn)
+	})
+	defer parser.SetOpener(nil)
+
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE tags (id INTEGER PRIMARY KEY, name TEXT);
+		INSERT INTO tags (name) VALUES ('a'), ('b'), ('c');
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "tags.sql", `
+		CREATE TABLE tags (id INTEGER PRIMARY KEY, name TEXT COLLATE app_test_reverse);
+		CREATE INDEX idx_tags_name ON tags(name COLLATE app_test_reverse);
+	`)
+
+	current, err := parser.FromDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := parser.ReadFiles(schemaDir)
+	if err != nil {
+		t.Fatalf("parse schema: %v", err)
+	}
+	residual, err := VerifyPlan(current, target, Diff(current, target), DiffOptions{})
+	if err != nil || len(residual) != 0 {
+		t.Fatalf("VerifyPlan() = %+v, %v", residual, err)
+	}
+
+	if err := Apply(db, schemaDir, ApplyOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if opened == 0 {
+		t.Error("expected the library to open databases through the opener")
+	}
+
+	var first string
+	if err := db.QueryRow("SELECT name FROM tags ORDER BY name LIMIT 1").Scan(&first); err != nil {
+		t.Fatalf("query tags: %v", err)
+	}
+	if first != "c" {
+		t.Errorf("first tag = %q, want c in reverse collation order", first)
+	}
+}
+
+func TestApply_VirtualTables(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE VIRTUAL TABLE places USING rtree(id, min_x, max_x, min_y, max_y);
+		INSERT INTO places VALUES (1, 0, 1, 0, 1), (2, 5, 6, 5, 6);
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "places.sql", `
+		CREATE VIRTUAL TABLE places USING rtree(id, min_x, max_x, min_y, max_y, +name TEXT);
+		CREATE VIRTUAL TABLE shapes USING geopoly(name);
+	`)
+
+	changes, err := Compare(db, schemaDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, string(c.Type)+" "+c.Object)
+	}
+	want := []string{"RECREATE_TABLE places", "CREATE_TABLE shapes"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("changes = %v, want %v", got, want)
+	}
+
+	if err := Apply(db, schemaDir, ApplyOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM places WHERE min_x >= 5").Scan(&count); err != nil {
+		t.Fatalf("query recreated rtree: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("count = %d, want 1", count)
+	}
+
+	changes, err = Compare(db, schemaDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes after apply, got %+v", changes)
+	}
+}
+
+func TestApply_PrePostHooks(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "users.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);`)
+
+	var events []string
+	opts := ApplyOptions{
+		PreApply: func() error {
+			events = append(events, "pre")
+			return nil
+		},
+		OnCommit: func([]Change) {
+			events = append(events, "commit")
+		},
+		PostApply: func(applyErr error) error {
+			events = append(events, fmt.Sprintf("post %v", applyErr))
+			return nil
+		},
+	}
+	if err := Apply(db, schemaDir, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"pre", "commit", "post <nil>"}; !slices.Equal(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+
+	// Nothing to apply, so replication is never paused
+	events = nil
+	if err := Apply(db, schemaDir, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("events = %v, want none without changes", events)
+	}
+
+	// A failing pre-apply hook aborts before writing
+	schemaDir = createSchemaDir(t, "users.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, name TEXT);`)
+	opts.PreApply = func() error { return errors.New("replication still running") }
+	if err := Apply(db, schemaDir, opts); err == nil {
+		t.Fatal("expected pre-apply hook error")
+	}
+	if len(events) != 0 {
+		t.Errorf("events = %v, want none after a failed pre-apply hook", events)
+	}
+
+	// The post-apply hook sees apply failures
+	opts.PreApply = nil
+	opts.Checks = []Check{{Name: "fail", SQL: "SELECT 1"}}
+	if err := Apply(db, schemaDir, opts); err == nil {
+		t.Fatal("expected failing check")
+	}
+	if len(events) != 1 || !strings.HasPrefix(events[0], "post check fail failed") {
+		t.Errorf("events = %v, want post-apply with the apply error", events)
+	}
+}
+
+func TestApply_AddColumnRestrictions(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial string
+		schema  string
+		want    ChangeType
+	}{
+		{
+			name:   "UNIQUE",
+			schema: `CREATE TABLE t (id INTEGER PRIMARY KEY, a TEXT, b TEXT UNIQUE);`,
+			want:   RecreateTable,
+		},
+		{
+			name:    "PRIMARY KEY",
+			initial: `CREATE TABLE t (a TEXT); INSERT INTO t (a) VALUES ('x'), ('y');`,
+			schema:  `CREATE TABLE t (a TEXT, id INTEGER PRIMARY KEY);`,
+			want:    RecreateTable,
+		},
+		{
+			name:   "CURRENT_TIMESTAMP default",
+			schema: `CREATE TABLE t (id INTEGER PRIMARY KEY, a TEXT, created_at TEXT DEFAULT CURRENT_TIMESTAMP);`,
+			want:   RecreateTable,
+		},
+		{
+			name:   "function default",
+			schema: `CREATE TABLE t (id INTEGER PRIMARY KEY, a TEXT, b TEXT DEFAULT (datetime('now')));`,
+			want:   RecreateTable,
+		},
+		{
+			name:   "parenthesized default",
+			schema: `CREATE TABLE t (id INTEGER PRIMARY KEY, a TEXT, b INTEGER DEFAULT (1 + 1));`,
+			want:   RecreateTable,
+		},
+		{
+			name:   "STORED generated column",
+			schema: `CREATE TABLE t (id INTEGER PRIMARY KEY, a TEXT, b TEXT AS (upper(a)) STORED);`,
+			want:   RecreateTable,
+		},
+		{
+			name:   "REFERENCES with non-NULL default",
+			schema: `CREATE TABLE t (id INTEGER PRIMARY KEY, a TEXT, parent_id INTEGER DEFAULT 1 REFERENCES t(id));`,
+			want:   RecreateTable,
+		},
+		{
+			name:   "NOT NULL without default",
+			schema: `CREATE TABLE t (id INTEGER PRIMARY KEY, a TEXT, b TEXT NOT NULL);`,
+			want:   RecreateTable,
+		},
+		{
+			name:   "table constraint declared with new column",
+			schema: `CREATE TABLE t (id INTEGER PRIMARY KEY, a TEXT, b TEXT, UNIQUE (a, b));`,
+			want:   RecreateTable,
+		},
+		{
+			name:   "CHECK on other columns is kept",
+			schema: `CREATE TABLE t (id INTEGER PRIMARY KEY, a TEXT, b TEXT CHECK (b IS NULL OR b != a));`,
+			want:   AddColumn,
+		},
+		{
+			name:   "COLLATE is kept",
+			schema: `CREATE TABLE t (id INTEGER PRIMARY KEY, a TEXT, b TEXT COLLATE NOCASE);`,
+			want:   AddColumn,
+		},
+		{
+			name:   "REFERENCES without default",
+			schema: `CREATE TABLE t (id INTEGER PRIMARY KEY, a TEXT, parent_id INTEGER REFERENCES t(id));`,
+			want:   AddColumn,
+		},
+		{
+			name:   "VIRTUAL generated column",
+			schema: `CREATE TABLE t (id INTEGER PRIMARY KEY, a TEXT, b TEXT AS (upper(a)) VIRTUAL);`,
+			want:   AddColumn,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initial := cmp.Or(tt.initial, `
+				CREATE TABLE t (id INTEGER PRIMARY KEY, a TEXT);
+				INSERT INTO t (a) VALUES ('x'), ('y');
+			`)
+			db, _ := createTestDBWithPath(t, initial)
+			defer func() { _ = db.Close() }()
+			schemaDir := createSchemaDir(t, "t.sql", tt.schema)
+
+			changes, err := Compare(db, schemaDir)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(changes) != 1 || changes[0].Type != tt.want {
+				t.Fatalf("changes = %+v, want one %s", changes, tt.want)
+			}
+
+			if err := Apply(db, schemaDir, ApplyOptions{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var count int
+			if err := db.QueryRow("SELECT count(*) FROM t").Scan(&count); err != nil {
+				t.Fatal(err)
+			}
+			if count != 2 {
+				t.Errorf("count = %d, want the 2 existing rows", count)
+			}
+
+			// Every clause of the new column must have been applied
+			changes, err = Compare(db, schemaDir)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(changes) != 0 {
+				t.Errorf("expected no changes after apply, got %+v", changes)
+			}
+		})
+	}
+}
+
+func TestApply_SchemaChangedSincePlanning(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "users.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);`)
+
+	version, err := SchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE other (id INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+
+	err = Apply(db, schemaDir, ApplyOptions{SchemaVersion: &version})
+	if !errors.Is(err, ErrSchemaChanged) {
+		t.Fatalf("Apply() = %v, want ErrSchemaChanged", err)
+	}
+	if changes, _ := Compare(db, schemaDir); len(changes) != 2 {
+		t.Errorf("Apply() changed the database: %d changes left, want 2", len(changes))
+	}
+}
+
+func TestApply_ReplansAfterConcurrentSchemaChange(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "users.sql", `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);
+		CREATE TABLE other (id INTEGER);
+	`)
+
+	// Another process creates a table between planning and applying
+	calls := 0
+	opts := ApplyOptions{PreApply: func() error {
+		calls++
+		if calls == 1 {
+			_, err := db.Exec(`CREATE TABLE other (id INTEGER)`)
+			return err
+		}
+		return nil
+	}}
+	if err := Apply(db, schemaDir, opts); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("PreApply called %d times, want 2 (one replan)", calls)
+	}
+	if changes, err := Compare(db, schemaDir); err != nil || len(changes) != 0 {
+		t.Errorf("changes remaining after Apply: %v (err %v)", changes, err)
+	}
+}
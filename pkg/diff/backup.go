@@ -0,0 +1,145 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+// BackupStrategy selects how Apply backs up the database before migrating
+type BackupStrategy string
+
+const (
+	// BackupVacuum writes a compacted copy with VACUUM INTO. This is the default.
+	BackupVacuum BackupStrategy = "vacuum"
+	// BackupCopy copies the database file and its WAL while holding the write
+	// lock, then checks the integrity of the copy. It works where VACUUM INTO
+	// does not, e.g. on filesystems without the locking VACUUM INTO needs.
+	BackupCopy BackupStrategy = "copy"
+	// BackupAuto uses BackupVacuum and falls back to BackupCopy if it fails
+	BackupAuto BackupStrategy = "auto"
+)
+
+// createBackup backs up db to path using the given strategy
+func createBackup(ctx context.Context, db *sql.DB, path string, strategy BackupStrategy) error {
+	switch strategy {
+	case "", BackupVacuum:
+		return vacuumBackup(ctx, db, path)
+	case BackupCopy:
+		return copyBackup(ctx, db, path)
+	case BackupAuto:
+		err := vacuumBackup(ctx, db, path)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if copyErr := copyBackup(ctx, db, path); copyErr != nil {
+			return errors.Join(err, copyErr)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown backup strategy %q", strategy)
+	}
+}
+
+// vacuumBackup writes a compacted copy of the database with VACUUM INTO
+func vacuumBackup(ctx context.Context, db *sql.DB, path string) error {
+	_ = os.Remove(path)                             // Ignore error if doesn't exist
+	safePath := strings.ReplaceAll(path, "'", "''") // Escape single quotes for SQL
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("VACUUM INTO '%s'", safePath)); err != nil {
+		return fmt.Errorf("vacuum into backup: %w", err)
+	}
+	return nil
+}
+
+// copyBackup copies the database file and its WAL. Holding the write lock
+// keeps other writers from appending to the WAL or restarting it, so the
+// copied file and WAL together are a consistent snapshot. The -shm file is
+// not copied, SQLite rebuilds it when the backup is opened.
+func copyBackup(ctx context.Context, db *sql.DB, path string) error {
+	var seq int
+	var name, file string
+	if err := db.QueryRowContext(ctx, "PRAGMA database_list").Scan(&seq, &name, &file); err != nil {
+		return fmt.Errorf("locate database file: %w", err)
+	}
+	if file == "" {
+		return fmt.Errorf("copy backup needs a database file, not an in-memory database")
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire connection: %w", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return fmt.Errorf("lock database: %w", err)
+	}
+	defer func() {
+		_, _ = conn.ExecContext(ctx, "ROLLBACK")
+	}()
+
+	_ = os.Remove(path + "-wal")
+	_ = os.Remove(path + "-shm")
+	if err := copyFile(file, path); err != nil {
+		return err
+	}
+	if err := copyFile(file+"-wal", path+"-wal"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return verifyBackup(path)
+}
+
+// verifyBackup runs an integrity check on a backup file
+func verifyBackup(path string) error {
+	backup, err := parser.Open(path)
+	if err != nil {
+		return fmt.Errorf("open backup: %w", err)
+	}
+	defer func() {
+		_ = backup.Close()
+	}()
+
+	var result string
+	if err := backup.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("verify backup: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("verify backup: integrity check failed: %s", result)
+	}
+	return nil
+}
+
+// copyFile copies src to dst and syncs dst to disk
+func copyFile(src, dst string) error {
+	in, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return fmt.Errorf("open %s: %w", src, err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+
+	out, err := os.OpenFile(filepath.Clean(dst), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("copy %s: %w", src, err)
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("sync %s: %w", dst, err)
+	}
+	return out.Close()
+}
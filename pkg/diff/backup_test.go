@@ -0,0 +1,58 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateBackup(t *testing.T) {
+	for _, strategy := range []BackupStrategy{"", BackupVacuum, BackupCopy, BackupAuto} {
+		t.Run(string(strategy), func(t *testing.T) {
+			db, dbPath := createTestDBWithPath(t, `
+				PRAGMA journal_mode = WAL;
+				PRAGMA wal_autocheckpoint = 0;
+				CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+				INSERT INTO users (name) VALUES ('ann'), ('bob');
+			`)
+			defer func() { _ = db.Close() }()
+
+			// Rows only in the WAL must be part of the backup
+			backupPath := dbPath + ".backup"
+			if err := createBackup(context.Background(), db, backupPath, strategy); err != nil {
+				t.Fatalf("createBackup() error: %v", err)
+			}
+
+			backup, err := sql.Open("sqlite", backupPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = backup.Close() }()
+
+			var count int
+			if err := backup.QueryRow("SELECT count(*) FROM users").Scan(&count); err != nil {
+				t.Fatalf("query backup: %v", err)
+			}
+			if count != 2 {
+				t.Errorf("backup has %d rows, want 2", count)
+			}
+		})
+	}
+}
+
+func TestCreateBackup_Errors(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = db.Close() }()
+	path := filepath.Join(t.TempDir(), "backup.db")
+
+	if err := createBackup(context.Background(), db, path, BackupCopy); err == nil {
+		t.Error("expected copy backup of an in-memory database to fail")
+	}
+	if err := createBackup(context.Background(), db, path, "rsync"); err == nil {
+		t.Error("expected unknown strategy to fail")
+	}
+}
@@ -0,0 +1,216 @@
+package diff
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// defaultBatchRows is the number of rows low-priority mode copies into a
+// recreated table per transaction
+const defaultBatchRows = 1000
+
+// syncTriggerEvents are the writes the triggers of a batched copy mirror
+// from the old table into the new one
+var syncTriggerEvents = []string{"insert", "update", "delete"}
+
+// batchCopy is the row copy of a recreate that low-priority mode runs in
+// batches of rows instead of a single INSERT ... SELECT. Triggers on the old
+// table mirror the writes of other connections into the new table while
+// the batches are copied, which works because the copy keeps the rowids
+// (see Change.CopyKey).
+type batchCopy struct {
+	change Change       // The recreate
+	setup  []string     // Statements before the copy, e.g. creating the new table
+	copy   recreateCopy // The copy statement
+	key    string       // Rowid name of both tables
+}
+
+// splitBatchCopies takes the row copy out of every recreate of a batch that
+// copies rows with their rowids, leaving the statements after the copy,
+// which swap the tables, in the batch
+func splitBatchCopies(batch []Change) ([]Change, []batchCopy) {
+	var copies []batchCopy
+	batch = slices.Clone(batch)
+	for i, c := range batch {
+		if c.CopyKey == "" || !c.RecreatesTable() {
+			continue
+		}
+		rc, ok := findRecreateCopy(c)
+		if !ok {
+			continue
+		}
+		copies = append(copies, batchCopy{change: c, setup: c.SQL[:rc.index], copy: rc, key: c.CopyKey})
+		batch[i].SQL = c.SQL[rc.index+1:]
+	}
+	return batch, copies
+}
+
+// syncTrigger returns the quoted name of the trigger mirroring an event
+func (bc batchCopy) syncTrigger(event string) string {
+	return fmt.Sprintf("%q", bc.change.Object+"__new_sync_"+event)
+}
+
+// cleanup returns the statements removing the new table and the triggers
+// of an interrupted batched copy
+func (bc batchCopy) cleanup() []string {
+	var stmts []string
+	for _, event := range syncTriggerEvents {
+		stmts = append(stmts, fmt.Sprintf("DROP TRIGGER IF EXISTS %s;", bc.syncTrigger(event)))
+	}
+	return append(stmts, fmt.Sprintf("DROP TABLE IF EXISTS %s;", bc.copy.target))
+}
+
+// condition joins the copy's own condition, if any, to cond
+func (bc batchCopy) condition(cond string) string {
+	if bc.copy.where == "" {
+		return cond
+	}
+	return cond + " AND " + bc.copy.where
+}
+
+// syncTriggers returns the triggers mirroring writes to the old table into
+// the new one
+func (bc batchCopy) syncTriggers() []string {
+	rc, key := bc.copy, bc.key
+	insert := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s WHERE %s;",
+		rc.target, rc.cols, rc.exprs, rc.table, bc.condition(fmt.Sprintf("%s = NEW.%s", key, key)))
+	remove := fmt.Sprintf("DELETE FROM %s WHERE %s = OLD.%s;", rc.target, key, key)
+
+	bodies := map[string]string{
+		"insert": insert,
+		"update": remove + " " + insert,
+		"delete": remove,
+	}
+	stmts := make([]string, len(syncTriggerEvents))
+	for i, event := range syncTriggerEvents {
+		stmts[i] = fmt.Sprintf("CREATE TRIGGER %s AFTER %s ON %s BEGIN %s END;",
+			bc.syncTrigger(event), strings.ToUpper(event), rc.table, bodies[event])
+	}
+	return stmts
+}
+
+// copyInBatches creates the new table of a recreate and copies the rows of
+// the old one into it in transactions of at most BatchRows rows, pausing in
+// between. Rows written meanwhile are mirrored by triggers, and rows already
+// in the new table are skipped. The recreate's remaining statements swap
+// the tables, which drops the triggers with the old table; until then,
+// discardBatchCopies removes the new table and the triggers again.
+func copyInBatches(ctx context.Context, conn *sql.Conn, bc batchCopy, version *int64, walPath string, opts ApplyOptions) error {
+	setup := bc.change
+	setup.SQL = slices.Concat(bc.cleanup(), bc.setup, bc.syncTriggers())
+	if err := runInTransaction(ctx, conn, version, func(tx *sql.Tx) error {
+		return executeChanges(ctx, tx, []Change{setup}, opts.OnEvent)
+	}); err != nil {
+		return err
+	}
+
+	rc, key := bc.copy, bc.key
+	size := cmp.Or(opts.BatchRows, defaultBatchRows)
+	var last sql.NullInt64 // Highest rowid copied so far
+	for i := 0; ; i++ {
+		if i > 0 {
+			opts.pause(ctx)
+		}
+		done := false
+		var copied int64
+		err := runInTransaction(ctx, conn, nil, func(tx *sql.Tx) error {
+			// The rowid ending the next batch
+			lower, args := "", []any{size}
+			if last.Valid {
+				lower, args = fmt.Sprintf(" WHERE %s > ?", key), []any{last.Int64, size}
+			}
+			var end sql.NullInt64
+			if err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT max(k) FROM (SELECT %s AS k FROM %s%s ORDER BY %s LIMIT ?)",
+				key, rc.table, lower, key), args...).Scan(&end); err != nil {
+				return fmt.Errorf("copy %q: %w", bc.change.Object, err)
+			}
+			if !end.Valid {
+				done = true
+				return nil
+			}
+
+			// Rows the triggers mirrored are already there
+			lower, args = "", []any{end.Int64, end.Int64}
+			if last.Valid {
+				lower, args = fmt.Sprintf("%s > ? AND ", key), []any{last.Int64, end.Int64, last.Int64, end.Int64}
+			}
+			stmt := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s WHERE %s;", rc.target, rc.cols, rc.exprs, rc.table,
+				bc.condition(fmt.Sprintf("%[1]s%[2]s <= ? AND %[2]s NOT IN (SELECT %[2]s FROM %[3]s WHERE %[1]s%[2]s <= ?)",
+					lower, key, rc.target)))
+			result, err := tx.ExecContext(ctx, stmt, args...)
+			if err != nil {
+				return fmt.Errorf("copy %q: %w\nSQL: %s", bc.change.Object, err, stmt)
+			}
+			copied, _ = result.RowsAffected()
+			last = end
+			return nil
+		})
+		if err != nil || done {
+			return err
+		}
+		opts.emit(BatchCopied{Table: bc.change.Object, Rows: copied})
+		if err := limitWAL(ctx, conn, walPath, opts); err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, "PRAGMA wal_checkpoint(PASSIVE)"); err != nil {
+			return fmt.Errorf("checkpoint: %w", err)
+		}
+	}
+}
+
+// discardBatchCopies removes the new tables and triggers of batched copies
+// whose tables were not swapped, even if ctx was cancelled
+func discardBatchCopies(ctx context.Context, conn *sql.Conn, copies []batchCopy) error {
+	ctx = context.WithoutCancel(ctx)
+	for _, bc := range copies {
+		for _, stmt := range bc.cleanup() {
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("discard copy of %q: %w", bc.change.Object, err)
+			}
+		}
+	}
+	return nil
+}
+
+// runInTransaction runs fn in a transaction on conn and commits it
+func runInTransaction(ctx context.Context, conn *sql.Conn, version *int64, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := checkSchemaVersion(ctx, tx, version); err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// withoutBatchCopies returns s without the new tables and triggers that
+// batched copies of changes left behind when the process was killed
+func withoutBatchCopies(s *schema.Database, changes []Change) *schema.Database {
+	for _, c := range changes {
+		if c.CopyKey == "" {
+			continue
+		}
+		delete(s.Tables, c.Object+"__new")
+		for _, event := range syncTriggerEvents {
+			delete(s.Triggers, c.Object+"__new_sync_"+event)
+		}
+	}
+	return s
+}
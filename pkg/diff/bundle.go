@@ -0,0 +1,182 @@
+package diff
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// bundlePragmas are the settings that change how schemas and migrations
+// behave, recorded in a debug bundle
+var bundlePragmas = []string{
+	"application_id", "auto_vacuum", "encoding", "foreign_keys", "journal_mode",
+	"legacy_alter_table", "page_size", "recursive_triggers", "trusted_schema", "user_version",
+}
+
+// BundleOptions configures WriteBundle
+type BundleOptions struct {
+	Diff        DiffOptions
+	ToolVersion string // Version of the tool writing the bundle
+	// Anonymize replaces names in both schemas and the plan with pseudonyms,
+	// see parser.Anonymize
+	Anonymize bool
+	// AnonymizeKey salts the pseudonyms. Empty means a random key, so names
+	// cannot be guessed but pseudonyms differ between bundles.
+	AnonymizeKey string
+}
+
+// BundleEnvironment describes where a debug bundle was made
+type BundleEnvironment struct {
+	ToolVersion    string            `json:"tool_version"`
+	SQLiteVersion  string            `json:"sqlite_version"`
+	CompileOptions []string          `json:"compile_options"`
+	Modules        []string          `json:"modules"`
+	Pragmas        map[string]string `json:"pragmas"`
+	OS             string            `json:"os"`
+	Arch           string            `json:"arch"`
+	Anonymized     bool              `json:"anonymized"`
+	Created        time.Time         `json:"created"`
+}
+
+// WriteBundle writes a reproduction bundle for a bug report to w, as a
+// gzipped tar archive of:
+//
+//	current.sql       the schema of db, formatted in a stable order
+//	target.sql        the target schema, likewise
+//	plan.json         the plan from current to target
+//	plan.sql          its SQL
+//	environment.json  tool and SQLite versions, compile options and pragmas
+//
+// No table data is read. With opts.Anonymize both schemas are anonymized
+// with the same key before diffing, so the plan has no real names either.
+func WriteBundle(w io.Writer, db *sql.DB, target *schema.Database, opts BundleOptions) error {
+	return WriteBundleContext(context.Background(), w, db, target, opts)
+}
+
+// WriteBundleContext is WriteBundle with a context, which cancels reading
+// the database
+func WriteBundleContext(ctx context.Context, w io.Writer, db *sql.DB, target *schema.Database, opts BundleOptions) error {
+	current, err := parser.FromDBContext(ctx, db)
+	if err != nil {
+		return fmt.Errorf("extract schema: %w", err)
+	}
+	caps, err := DetectCapabilities(db)
+	if err != nil {
+		return err
+	}
+	env := BundleEnvironment{
+		ToolVersion:    opts.ToolVersion,
+		SQLiteVersion:  caps.Version,
+		CompileOptions: caps.CompileOptions,
+		Modules:        caps.Modules,
+		Pragmas:        map[string]string{},
+		OS:             runtime.GOOS,
+		Arch:           runtime.GOARCH,
+		Anonymized:     opts.Anonymize,
+		Created:        time.Now().UTC().Truncate(time.Second),
+	}
+	for _, name := range bundlePragmas {
+		var value string
+		if err := db.QueryRowContext(ctx, "PRAGMA "+name).Scan(&value); err == nil {
+			env.Pragmas[name] = value // Pragmas may be compiled out
+		}
+	}
+
+	if opts.Anonymize {
+		key := opts.AnonymizeKey
+		if key == "" {
+			b := make([]byte, 16)
+			_, _ = rand.Read(b)
+			key = hex.EncodeToString(b)
+		}
+		if current, err = parser.Anonymize(current, key); err != nil {
+			return fmt.Errorf("anonymize current schema: %w", err)
+		}
+		if target, err = parser.Anonymize(target, key); err != nil {
+			return fmt.Errorf("anonymize target schema: %w", err)
+		}
+	}
+	changes := DiffWithOptions(current, target, opts.Diff.WithCapabilities(caps))
+	plan, err := PlanJSON(current, target, changes)
+	if err != nil {
+		return err
+	}
+	envJSON, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"current.sql", []byte(bundleSchemaSQL(current))},
+		{"target.sql", []byte(bundleSchemaSQL(target))},
+		{"plan.json", append(plan, '\n')},
+		{"plan.sql", []byte(GenerateSQL(changes))},
+		{"environment.json", append(envJSON, '\n')},
+	}
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.data)), ModTime: env.Created}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("write bundle: %w", err)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return fmt.Errorf("write bundle: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("write bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("write bundle: %w", err)
+	}
+	return nil
+}
+
+// bundleSchemaSQL renders a schema as tables, indexes, views and triggers
+// in name order, formatted where the formatter can keep the statement
+func bundleSchemaSQL(s *schema.Database) string {
+	var stmts []string
+	for _, name := range slices.Sorted(maps.Keys(s.Tables)) {
+		stmts = append(stmts, s.Tables[name].SQL)
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Indexes)) {
+		stmts = append(stmts, s.Indexes[name].SQL)
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Views)) {
+		stmts = append(stmts, s.Views[name].SQL)
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Triggers)) {
+		stmts = append(stmts, s.Triggers[name].SQL)
+	}
+
+	var b strings.Builder
+	for i, stmt := range stmts {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if formatted, err := parser.Format(stmt, parser.FormatOptions{KeywordCase: parser.KeywordPreserve}); err == nil {
+			b.WriteString(formatted)
+		} else {
+			b.WriteString(stmt + ";\n")
+		}
+	}
+	return b.String()
+}
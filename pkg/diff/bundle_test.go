@@ -0,0 +1,114 @@
+package diff
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+func TestWriteBundle(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      BundleOptions
+		contains  map[string]string // File to expected substring
+		forbidden []string
+	}{
+		{
+			name: "plain",
+			opts: BundleOptions{ToolVersion: "1.2.3"},
+			contains: map[string]string{
+				"current.sql":      "CREATE TABLE customers",
+				"target.sql":       "email TEXT",
+				"plan.json":        `"email"`,
+				"plan.sql":         `ALTER TABLE "customers" ADD COLUMN`,
+				"environment.json": `"tool_version": "1.2.3"`,
+			},
+		},
+		{
+			name: "anonymized",
+			opts: BundleOptions{Anonymize: true},
+			contains: map[string]string{
+				"current.sql":      "CREATE TABLE t_",
+				"plan.sql":         "ADD COLUMN",
+				"environment.json": `"anonymized": true`,
+			},
+			forbidden: []string{"customers", "email", "secret"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := createTestDBWithPath(t, `
+				CREATE TABLE customers (id INTEGER PRIMARY KEY, name TEXT); -- secret
+				INSERT INTO customers VALUES (1, 'secret');
+			`)
+			defer func() { _ = db.Close() }()
+			target, err := parser.FromSQL(`CREATE TABLE customers (id INTEGER PRIMARY KEY, name TEXT, email TEXT);`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err := WriteBundle(&buf, db, target, tt.opts); err != nil {
+				t.Fatalf("WriteBundle() error = %v", err)
+			}
+			files := readBundle(t, &buf)
+
+			for _, name := range []string{"current.sql", "target.sql", "plan.json", "plan.sql", "environment.json"} {
+				if _, ok := files[name]; !ok {
+					t.Errorf("bundle has no %s", name)
+				}
+			}
+			for name, want := range tt.contains {
+				if !strings.Contains(files[name], want) {
+					t.Errorf("%s = %s, want it to contain %q", name, files[name], want)
+				}
+			}
+			for name, content := range files {
+				for _, leak := range tt.forbidden {
+					if strings.Contains(content, leak) {
+						t.Errorf("%s contains %q:\n%s", name, leak, content)
+					}
+				}
+			}
+
+			var env BundleEnvironment
+			if err := json.Unmarshal([]byte(files["environment.json"]), &env); err != nil {
+				t.Fatal(err)
+			}
+			if env.SQLiteVersion == "" || env.Pragmas["page_size"] == "" {
+				t.Errorf("environment = %+v, want SQLite version and pragmas", env)
+			}
+		})
+	}
+}
+
+func readBundle(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	files := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(data)
+	}
+}
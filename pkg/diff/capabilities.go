@@ -0,0 +1,122 @@
+package diff
+
+import (
+	"database/sql"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// Feature is an SQLite feature that schemas or generated SQL may depend on
+type Feature struct {
+	Name       string
+	MinVersion string // First SQLite version with the feature
+}
+
+var (
+	FeatureRenameColumn     = Feature{Name: "ALTER TABLE RENAME COLUMN", MinVersion: "3.25.0"}
+	FeatureGeneratedColumns = Feature{Name: "generated columns", MinVersion: "3.31.0"}
+	FeatureDropColumn       = Feature{Name: "ALTER TABLE DROP COLUMN", MinVersion: "3.35.0"}
+	FeatureStrictTables     = Feature{Name: "STRICT tables", MinVersion: "3.37.0"}
+	FeatureAddColumnCheck   = Feature{Name: "CHECK validation in ADD COLUMN", MinVersion: "3.37.0"}
+)
+
+// Features is the capability matrix: every version-gated feature
+var Features = []Feature{
+	FeatureRenameColumn,
+	FeatureGeneratedColumns,
+	FeatureDropColumn,
+	FeatureStrictTables,
+	FeatureAddColumnCheck,
+}
+
+// Capabilities describes the SQLite library behind a database
+type Capabilities struct {
+	Version        string
+	CompileOptions []string // e.g. ENABLE_FTS5, without the SQLITE_ prefix
+	Modules        []string // Virtual table modules, nil if unknown
+}
+
+// Supports reports whether the library has a feature
+func (c Capabilities) Supports(f Feature) bool {
+	return versionAtLeast(c.Version, f.MinVersion)
+}
+
+// DetectCapabilities queries the version, compile options and virtual table
+// modules of the SQLite library behind db
+func DetectCapabilities(db *sql.DB) (Capabilities, error) {
+	version, err := SQLiteVersion(db)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	caps := Capabilities{Version: version}
+
+	// Both pragmas may be compiled out, the version alone still gates features
+	if caps.CompileOptions, err = queryStrings(db, "PRAGMA compile_options"); err != nil {
+		caps.CompileOptions = nil
+	}
+	if caps.Modules, err = queryStrings(db, "SELECT name FROM pragma_module_list ORDER BY name"); err != nil {
+		caps.Modules = nil
+	}
+	return caps, nil
+}
+
+// SQLiteVersion returns the version of the SQLite library behind db
+func SQLiteVersion(db *sql.DB) (string, error) {
+	var version string
+	if err := db.QueryRow("SELECT sqlite_version()").Scan(&version); err != nil {
+		return "", fmt.Errorf("query sqlite version: %w", err)
+	}
+	return version, nil
+}
+
+// WithCapabilities returns opts targeting the given library, keeping a
+// TargetVersion or Modules that were set explicitly
+func (opts DiffOptions) WithCapabilities(caps Capabilities) DiffOptions {
+	if opts.TargetVersion == "" {
+		opts.TargetVersion = caps.Version
+	}
+	if opts.Modules == nil {
+		opts.Modules = caps.Modules
+	}
+	return opts
+}
+
+// supports reports whether the target of a plan has a feature
+func (opts DiffOptions) supports(f Feature) bool {
+	return versionAtLeast(opts.TargetVersion, f.MinVersion)
+}
+
+// virtualModuleRe matches the module of a CREATE VIRTUAL TABLE statement
+var virtualModuleRe = regexp.MustCompile(`(?i)\bUSING\s+(\w+)`)
+
+// warnUnsupported warns about target tables that use features the target
+// SQLite lacks and that no generated SQL can emulate
+func warnUnsupported(to *schema.Database, opts DiffOptions) {
+	for _, name := range slices.Sorted(maps.Keys(to.Tables)) {
+		table := to.Tables[name]
+
+		if isVirtualTableSQL(table.SQL) {
+			m := virtualModuleRe.FindStringSubmatch(table.SQL)
+			if m != nil && opts.Modules != nil && !slices.ContainsFunc(opts.Modules, func(mod string) bool {
+				return strings.EqualFold(mod, m[1])
+			}) {
+				opts.warn(name, "virtual table module %q is not available in the target SQLite", m[1])
+			}
+			continue
+		}
+
+		if _, _, tail, ok := tableDefinitions(table.SQL); ok &&
+			strings.Contains(normalizeSQL(tail), "strict") && !opts.supports(FeatureStrictTables) {
+			opts.warn(name, "%s need SQLite %s, target is %s", FeatureStrictTables.Name, FeatureStrictTables.MinVersion, opts.TargetVersion)
+		}
+		if slices.ContainsFunc(table.Columns, func(c schema.Column) bool { return c.Hidden >= 2 }) &&
+			!opts.supports(FeatureGeneratedColumns) {
+			opts.warn(name, "%s need SQLite %s, target is %s", FeatureGeneratedColumns.Name, FeatureGeneratedColumns.MinVersion, opts.TargetVersion)
+		}
+	}
+}
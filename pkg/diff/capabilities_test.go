@@ -0,0 +1,156 @@
+package diff
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+func TestDetectCapabilities(t *testing.T) {
+	db := openTestDB(t, "SELECT 1")
+	defer func() { _ = db.Close() }()
+
+	caps, err := DetectCapabilities(db)
+	if err != nil {
+		t.Fatalf("DetectCapabilities() error = %v", err)
+	}
+	if caps.Version == "" {
+		t.Error("expected a version")
+	}
+	for _, f := range Features {
+		if !caps.Supports(f) {
+			t.Errorf("bundled SQLite %s should support %s", caps.Version, f.Name)
+		}
+	}
+	if !slices.Contains(caps.Modules, "fts5") {
+		t.Errorf("expected fts5 module, got %v", caps.Modules)
+	}
+	if len(caps.CompileOptions) == 0 {
+		t.Error("expected compile options")
+	}
+}
+
+func TestDiff_RenameColumnOnOlderSQLite(t *testing.T) {
+	from, err := parser.FromSQL(`CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := parser.FromSQL(`CREATE TABLE t (id INTEGER PRIMARY KEY, full_name TEXT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		version  string
+		want     ChangeType
+		recreate bool
+	}{
+		{version: "3.24.0", want: RenameColumn, recreate: true},
+		{version: "3.25.0", want: RenameColumn},
+		{version: "", want: RenameColumn},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			changes := DiffWithOptions(from, to, DiffOptions{TargetVersion: tt.version})
+			if len(changes) != 1 || changes[0].Type != tt.want {
+				t.Fatalf("changes = %+v, want one %s", changes, tt.want)
+			}
+			if changes[0].Reason != ColumnRenamed {
+				t.Errorf("reason = %s, want %s", changes[0].Reason, ColumnRenamed)
+			}
+			if got := changes[0].RecreatesTable(); got != tt.recreate {
+				t.Errorf("RecreatesTable() = %v, want %v", got, tt.recreate)
+			}
+		})
+	}
+
+	// The recreate must carry the data over to the renamed column
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = db.Close() }()
+	db.SetMaxOpenConns(1)
+
+	changes := DiffWithOptions(from, to, DiffOptions{TargetVersion: "3.24.0"})
+	if _, err := db.Exec(`CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT);
+		INSERT INTO t VALUES (1, 'alice');`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(GenerateSQL(changes)); err != nil {
+		t.Fatalf("apply recreate: %v", err)
+	}
+	var name string
+	if err := db.QueryRow("SELECT full_name FROM t WHERE id = 1").Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "alice" {
+		t.Errorf("full_name = %q, want %q", name, "alice")
+	}
+}
+
+func TestDiff_WarnsUnsupportedFeatures(t *testing.T) {
+	from, err := parser.FromSQL(`CREATE TABLE t (id INTEGER PRIMARY KEY);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := parser.FromSQL(`
+		CREATE TABLE t (id INTEGER PRIMARY KEY);
+		CREATE TABLE s (id INTEGER PRIMARY KEY, a INTEGER, b INTEGER AS (a + 1)) STRICT;
+		CREATE VIRTUAL TABLE docs USING fts5(body);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts DiffOptions
+		want []string
+	}{
+		{
+			name: "current sqlite",
+			opts: DiffOptions{TargetVersion: "3.45.1", Modules: []string{"fts5"}},
+		},
+		{
+			name: "old sqlite without fts5",
+			opts: DiffOptions{TargetVersion: "3.30.0", Modules: []string{"rtree"}},
+			want: []string{"docs", "s", "s"},
+		},
+		{
+			name: "unknown modules",
+			opts: DiffOptions{TargetVersion: "3.36.0"},
+			want: []string{"s"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			SetWarningHandler(func(w Warning) { got = append(got, w.Object) })
+			defer SetWarningHandler(nil)
+
+			DiffWithOptions(from, to, tt.opts)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("warnings for %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithCapabilities(t *testing.T) {
+	caps := Capabilities{Version: "3.45.1", Modules: []string{"fts5"}}
+
+	opts := DiffOptions{}.WithCapabilities(caps)
+	if opts.TargetVersion != "3.45.1" || !slices.Equal(opts.Modules, caps.Modules) {
+		t.Errorf("WithCapabilities() = %+v, want detected values", opts)
+	}
+
+	opts = DiffOptions{TargetVersion: "3.31.1", Modules: []string{}}.WithCapabilities(caps)
+	if opts.TargetVersion != "3.31.1" || len(opts.Modules) != 0 {
+		t.Errorf("WithCapabilities() = %+v, want explicit values kept", opts)
+	}
+}
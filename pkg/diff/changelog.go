@@ -0,0 +1,48 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ChangeLogEntry is one line of a logical change log, describing the
+// changes committed together in a single transaction
+type ChangeLogEntry struct {
+	Time    time.Time       `json:"time"`
+	Changes []ChangeLogItem `json:"changes"`
+}
+
+// ChangeLogItem is a committed change as recorded in the change log
+type ChangeLogItem struct {
+	Type        ChangeType `json:"type"`
+	Object      string     `json:"object"`
+	Column      string     `json:"column,omitempty"`
+	SQL         []string   `json:"sql"`
+	Destructive bool       `json:"destructive"`
+	Severity    Severity   `json:"severity"`
+}
+
+// ChangeLogWriter returns an ApplyOptions.OnCommit callback that appends a
+// JSON line per committed transaction to w, so replicas and sync layers can
+// replay or react to schema changes. Write errors are passed to onError, if set.
+func ChangeLogWriter(w io.Writer, onError func(error)) func([]Change) {
+	enc := json.NewEncoder(w)
+	return func(changes []Change) {
+		entry := ChangeLogEntry{Time: time.Now().UTC(), Changes: make([]ChangeLogItem, len(changes))}
+		for i, c := range changes {
+			entry.Changes[i] = ChangeLogItem{
+				Type:        c.Type,
+				Object:      c.Object,
+				Column:      c.Column,
+				SQL:         c.SQL,
+				Destructive: c.Destructive,
+				Severity:    changeSeverity(c),
+			}
+		}
+		if err := enc.Encode(entry); err != nil && onError != nil {
+			onError(fmt.Errorf("write change log: %w", err))
+		}
+	}
+}
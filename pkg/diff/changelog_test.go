@@ -0,0 +1,51 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestApply_ChangeLog(t *testing.T) {
+	db := openTestDB(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "users.sql", `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);
+		CREATE INDEX idx_users_email ON users(email);
+	`)
+
+	var buf bytes.Buffer
+	opts := ApplyOptions{
+		SkipDiskCheck: true,
+		DeferIndexes:  true,
+		OnCommit:      ChangeLogWriter(&buf, func(err error) { t.Error(err) }),
+	}
+	if err := Apply(db, schemaDir, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// One line for the structural changes and one for the deferred index
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 change log entries, got %d:\n%s", len(lines), buf.String())
+	}
+
+	var entry ChangeLogEntry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("unmarshal entry: %v", err)
+	}
+	if len(entry.Changes) != 1 || entry.Changes[0].Type != AddColumn || entry.Changes[0].Column != "email" {
+		t.Errorf("first entry = %+v, want ADD_COLUMN email", entry)
+	}
+	if entry.Time.IsZero() {
+		t.Error("entry time should be set")
+	}
+
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil {
+		t.Fatalf("unmarshal entry: %v", err)
+	}
+	if len(entry.Changes) != 1 || entry.Changes[0].Object != "idx_users_email" {
+		t.Errorf("second entry = %+v, want the deferred index", entry)
+	}
+}
@@ -0,0 +1,62 @@
+package diff
+
+import "slices"
+
+// ChangeTypeInfo describes a change type, so tools rendering plans need
+// not hardcode what each type means
+type ChangeTypeInfo struct {
+	Type        ChangeType `json:"type"`
+	Name        string     `json:"name"`        // Display name, e.g. "Create table"
+	Object      string     `json:"object"`      // Kind of object changed, empty for data migrations
+	Action      string     `json:"action"`      // Plan action (ActionCreate, ActionDelete or ActionUpdate)
+	Destructive bool       `json:"destructive"` // Whether changes of this type are planned as destructive
+	Severity    Severity   `json:"severity"`    // Severity changes of this type are planned with, danger exactly when destructive
+	Reversible  bool       `json:"reversible"`  // Whether the change can be undone from the old schema alone, without a backup
+	Priority    int        `json:"priority"`    // Position in the apply order, lower first; 0 runs next to the change it belongs to
+}
+
+// changeTypes is the registry of all change types in apply order. Types
+// applied as recreates share the priority of RECREATE_TABLE.
+var changeTypes = []ChangeTypeInfo{
+	{Type: DropTrigger, Name: "Drop trigger", Object: "trigger", Action: ActionDelete, Severity: SeverityWarn, Reversible: true, Priority: 1},
+	{Type: DropView, Name: "Drop view", Object: "view", Action: ActionDelete, Severity: SeverityWarn, Reversible: true, Priority: 2},
+	{Type: DropIndex, Name: "Drop index", Object: "index", Action: ActionDelete, Severity: SeverityWarn, Reversible: true, Priority: 3},
+	{Type: DropTable, Name: "Drop table", Object: "table", Action: ActionDelete, Destructive: true, Severity: SeverityDanger, Priority: 4},
+	{Type: RenameTable, Name: "Rename table", Object: "table", Action: ActionUpdate, Severity: SeverityWarn, Reversible: true, Priority: 5},
+	{Type: RecreateTable, Name: "Recreate table", Object: "table", Action: ActionUpdate, Destructive: true, Severity: SeverityDanger, Priority: 6},
+	{Type: DropColumn, Name: "Drop column", Object: "column", Action: ActionDelete, Destructive: true, Severity: SeverityDanger, Priority: 6},
+	{Type: AlterColumnDefault, Name: "Alter column default", Object: "column", Action: ActionUpdate, Destructive: true, Severity: SeverityDanger, Reversible: true, Priority: 6},
+	{Type: CreateTable, Name: "Create table", Object: "table", Action: ActionCreate, Severity: SeverityInfo, Reversible: true, Priority: 7},
+	{Type: RenameColumn, Name: "Rename column", Object: "column", Action: ActionUpdate, Severity: SeverityWarn, Reversible: true, Priority: 8},
+	{Type: AddColumn, Name: "Add column", Object: "column", Action: ActionCreate, Severity: SeverityInfo, Reversible: true, Priority: 9},
+	{Type: CreateIndex, Name: "Create index", Object: "index", Action: ActionCreate, Severity: SeverityInfo, Reversible: true, Priority: 10},
+	{Type: CreateView, Name: "Create view", Object: "view", Action: ActionCreate, Severity: SeverityInfo, Reversible: true, Priority: 11},
+	{Type: CreateTrigger, Name: "Create trigger", Object: "trigger", Action: ActionCreate, Severity: SeverityInfo, Reversible: true, Priority: 12},
+	{Type: DataMigration, Name: "Data migration", Action: ActionUpdate, Severity: SeverityWarn},
+}
+
+// ChangeTypes returns every change type the planner can produce, in
+// apply order. New types are added here, so tools listing them pick them
+// up without changes.
+func ChangeTypes() []ChangeTypeInfo {
+	return slices.Clone(changeTypes)
+}
+
+// LookupChangeType returns the registry entry of a change type. Types from
+// newer plan files are not found.
+func LookupChangeType(t ChangeType) (ChangeTypeInfo, bool) {
+	i := slices.IndexFunc(changeTypes, func(info ChangeTypeInfo) bool { return info.Type == t })
+	if i < 0 {
+		return ChangeTypeInfo{}, false
+	}
+	return changeTypes[i], true
+}
+
+// changePriorities maps change types to their Priority for sorting
+func changePriorities() map[ChangeType]int {
+	priorities := make(map[ChangeType]int, len(changeTypes))
+	for _, info := range changeTypes {
+		priorities[info.Type] = info.Priority
+	}
+	return priorities
+}
@@ -0,0 +1,51 @@
+package diff
+
+import (
+	"testing"
+)
+
+func TestChangeTypes(t *testing.T) {
+	all := []ChangeType{
+		CreateTable, DropTable, AddColumn, RenameColumn, RecreateTable, CreateIndex,
+		DropIndex, CreateView, DropView, CreateTrigger, DropTrigger, DataMigration,
+		DropColumn, AlterColumnDefault, RenameTable,
+	}
+	for _, ct := range all {
+		info, ok := LookupChangeType(ct)
+		if !ok {
+			t.Errorf("LookupChangeType(%s) not found", ct)
+			continue
+		}
+		if info.Name == "" || info.Action == "" {
+			t.Errorf("LookupChangeType(%s) = %+v, want name and action", ct, info)
+		}
+		if info.Destructive != (info.Severity == SeverityDanger) {
+			t.Errorf("LookupChangeType(%s) = %+v, want danger exactly when destructive", ct, info)
+		}
+	}
+	if got := len(ChangeTypes()); got != len(all) {
+		t.Errorf("ChangeTypes() has %d types, want %d", got, len(all))
+	}
+
+	last := 0
+	for _, info := range ChangeTypes() {
+		if info.Priority == 0 {
+			continue
+		}
+		if info.Priority < last {
+			t.Errorf("%s has priority %d after %d, want ascending", info.Type, info.Priority, last)
+		}
+		last = info.Priority
+		if changePriority[info.Type] != info.Priority {
+			t.Errorf("changePriority[%s] = %d, want %d", info.Type, changePriority[info.Type], info.Priority)
+		}
+	}
+
+	if _, ok := LookupChangeType("ALTER_UNIVERSE"); ok {
+		t.Error("LookupChangeType() found an unknown type")
+	}
+	ChangeTypes()[0].Name = "changed"
+	if info, _ := LookupChangeType(DropTrigger); info.Name != "Drop trigger" {
+		t.Errorf("ChangeTypes() exposes the registry, name = %q", info.Name)
+	}
+}
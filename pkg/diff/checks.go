@@ -0,0 +1,158 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+// Check is a data invariant that must hold after migrating, such as
+// "no orders without a customer". Apply runs checks before committing and
+// rolls back if any of them fails.
+type Check struct {
+	Name   string  // Shown in errors, e.g. the file name
+	SQL    string  // Query to run
+	Expect *string // Expected single value, or nil if the query must return no rows
+}
+
+// expectAnnotationRe matches the "-- @expect: <value>" header of a check file
+var expectAnnotationRe = regexp.MustCompile(`(?m)^\s*--\s*@expect:\s*(.*?)\s*$`)
+
+// LoadChecks reads the check queries from the checks/ subdirectory of a
+// schema directory. By default a check must return no rows:
+//
+//	SELECT id FROM orders WHERE customer_id NOT IN (SELECT id FROM customers);
+//
+// A "-- @expect: <value>" header instead requires a single row whose first
+// column equals the value. If parser.SetBaseFS was called, reads from that
+// filesystem instead.
+func LoadChecks(schemaDir string) ([]Check, error) {
+	files, err := readSQLFiles(filepath.Join(schemaDir, parser.ChecksDir))
+	if err != nil {
+		return nil, err
+	}
+
+	checks := make([]Check, 0, len(files))
+	for _, file := range files {
+		check := Check{Name: file.name, SQL: strings.TrimSpace(file.content)}
+		if m := expectAnnotationRe.FindStringSubmatch(file.content); m != nil {
+			check.Expect = &m[1]
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+// viewChecks returns a check for every view the database has once the
+// changes ran, which selects no rows from it. It fails if the view no
+// longer compiles against the new schema, e.g. because it uses a dropped
+// column.
+func viewChecks(db *sql.DB, changes []Change) ([]Check, error) {
+	names, err := queryStrings(db, "SELECT name FROM sqlite_master WHERE type = 'view'")
+	if err != nil {
+		return nil, fmt.Errorf("list views: %w", err)
+	}
+	views := make(map[string]bool, len(names))
+	for _, name := range names {
+		views[name] = true
+	}
+	for _, c := range changes {
+		switch c.Type {
+		case DropView:
+			delete(views, c.Object)
+		case CreateView:
+			views[c.Object] = true
+		}
+	}
+
+	checks := make([]Check, 0, len(views))
+	for _, name := range slices.Sorted(maps.Keys(views)) {
+		checks = append(checks, Check{
+			Name: fmt.Sprintf("view %q", name),
+			SQL:  fmt.Sprintf("SELECT * FROM %q LIMIT 0", name),
+		})
+	}
+	return checks, nil
+}
+
+// querier is implemented by *sql.DB, *sql.Conn and *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// runChecks runs every check and returns an error for the first one that fails
+func runChecks(ctx context.Context, db querier, checks []Check) error {
+	for _, check := range checks {
+		if err := runCheck(ctx, db, check); err != nil {
+			return fmt.Errorf("check %s failed: %w", check.Name, err)
+		}
+	}
+	return nil
+}
+
+func runCheck(ctx context.Context, db querier, check Check) error {
+	rows, err := db.QueryContext(ctx, check.SQL)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	var first []string
+	count := 0
+	for rows.Next() {
+		count++
+		if count > 1 {
+			continue
+		}
+
+		values := make([]sql.NullString, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		for _, v := range values {
+			if v.Valid {
+				first = append(first, v.String)
+			} else {
+				first = append(first, "NULL")
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if check.Expect == nil {
+		if count > 0 {
+			return fmt.Errorf("expected no rows, got %d (first: %s)", count, strings.Join(first, "|"))
+		}
+		return nil
+	}
+
+	if count != 1 || len(first) == 0 || first[0] != *check.Expect {
+		return fmt.Errorf(
+			"expected %q, got %d rows (first: %s)",
+			*check.Expect,
+			count,
+			strings.Join(first, "|"),
+		)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+func TestRunCheck(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE customers (id INTEGER PRIMARY KEY);
+		CREATE TABLE orders (id INTEGER PRIMARY KEY, customer_id INTEGER);
+		INSERT INTO customers (id) VALUES (1);
+		INSERT INTO orders (customer_id) VALUES (1), (2), (NULL);
+	`)
+	defer func() { _ = db.Close() }()
+
+	tests := []struct {
+		name    string
+		sql     string
+		expect  *string
+		wantErr string
+	}{
+		{
+			name: "no rows",
+			sql:  "SELECT id FROM orders WHERE customer_id > 100",
+		},
+		{
+			name:    "violating rows",
+			sql:     "SELECT id, customer_id FROM orders WHERE customer_id IS NULL OR customer_id NOT IN (SELECT id FROM customers)",
+			wantErr: "expected no rows, got 2 (first: 2|2)",
+		},
+		{
+			name:   "expected value",
+			sql:    "SELECT count(*) FROM orders",
+			expect: new("3"),
+		},
+		{
+			name:    "unexpected value",
+			sql:     "SELECT count(*) FROM customers",
+			expect:  new("3"),
+			wantErr: `expected "3", got 1 rows (first: 1)`,
+		},
+		{
+			name:    "invalid query",
+			sql:     "SELECT * FROM missing",
+			wantErr: "no such table",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runCheck(context.Background(), db, Check{Name: tt.name, SQL: tt.sql, Expect: tt.expect})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadChecks(t *testing.T) {
+	schemaDir := createSchemaDir(t, "schema.sql", `CREATE TABLE t (id INTEGER PRIMARY KEY);`)
+	checksDir := filepath.Join(schemaDir, "checks")
+	if err := os.Mkdir(checksDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"b_count.sql":   "-- @expect: 0\nSELECT count(*) FROM t;",
+		"a_orphans.sql": "SELECT id FROM t WHERE id < 0;",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(checksDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checks, err := LoadChecks(schemaDir)
+	if err != nil {
+		t.Fatalf("LoadChecks() error: %v", err)
+	}
+	if len(checks) != 2 || checks[0].Name != "a_orphans.sql" || checks[1].Name != "b_count.sql" {
+		t.Fatalf("LoadChecks() = %+v", checks)
+	}
+	if checks[0].Expect != nil {
+		t.Errorf("a_orphans.sql Expect = %q, want nil", *checks[0].Expect)
+	}
+	if checks[1].Expect == nil || *checks[1].Expect != "0" {
+		t.Errorf("b_count.sql Expect = %v, want 0", checks[1].Expect)
+	}
+
+	// A schema directory without checks is fine
+	checks, err = LoadChecks(t.TempDir())
+	if err != nil || len(checks) != 0 {
+		t.Errorf("LoadChecks() on empty dir = %v, %v", checks, err)
+	}
+}
+
+func TestApply_Checks(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE customers (id INTEGER PRIMARY KEY);
+		CREATE TABLE orders (id INTEGER PRIMARY KEY, customer_id INTEGER);
+		INSERT INTO orders (customer_id) VALUES (7);
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "schema.sql", `
+		CREATE TABLE customers (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE orders (id INTEGER PRIMARY KEY, customer_id INTEGER);
+	`)
+	if err := os.Mkdir(filepath.Join(schemaDir, "checks"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	check := `SELECT id FROM orders WHERE customer_id NOT IN (SELECT id FROM customers);`
+	if err := os.WriteFile(filepath.Join(schemaDir, "checks", "orphans.sql"), []byte(check), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Apply(db, schemaDir, ApplyOptions{})
+	if err == nil || !strings.Contains(err.Error(), "check orphans.sql failed") {
+		t.Fatalf("expected failing check, got %v", err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM pragma_table_info('customers')").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("changes should be rolled back when a check fails, customers has %d columns", count)
+	}
+
+	if _, err := db.Exec("INSERT INTO customers (id) VALUES (7)"); err != nil {
+		t.Fatal(err)
+	}
+	extra := Check{Name: "one customer", SQL: "SELECT count(*) FROM customers", Expect: new("1")}
+	if err := Apply(db, schemaDir, ApplyOptions{Checks: []Check{extra}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestLoadChecks_BaseFS(t *testing.T) {
+	parser.SetBaseFS(fstest.MapFS{
+		"db/schema/schema.sql":       {Data: []byte("CREATE TABLE t (id INTEGER PRIMARY KEY);")},
+		"db/schema/checks/count.sql": {Data: []byte("-- @expect: 0\nSELECT count(*) FROM t;")},
+	})
+	defer parser.SetBaseFS(nil)
+
+	// Windows-style paths must work against an embedded filesystem
+	for _, dir := range []string{"db/schema", `db\schema`, "./db/schema/"} {
+		checks, err := LoadChecks(dir)
+		if err != nil {
+			t.Fatalf("LoadChecks(%q) error: %v", dir, err)
+		}
+		if len(checks) != 1 || checks[0].Name != "count.sql" {
+			t.Errorf("LoadChecks(%q) = %+v, want count.sql", dir, checks)
+		}
+	}
+	if checks, err := LoadChecks("db/missing"); err != nil || len(checks) != 0 {
+		t.Errorf("LoadChecks() on missing dir = %v, %v", checks, err)
+	}
+}
+
+func TestApply_CheckViews(t *testing.T) {
+	setup := func(t *testing.T) (*sql.DB, string) {
+		db, _ := createTestDBWithPath(t, `
+			CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, status TEXT);
+			CREATE TABLE legacy_flags (user_id INTEGER, flag TEXT);
+			CREATE VIEW active_users AS SELECT name FROM users WHERE status = 'active';
+			CREATE VIEW flagged AS SELECT u.name, f.flag FROM users u JOIN legacy_flags f ON f.user_id = u.id;
+		`)
+		// The schema files still declare a view on the dropped table
+		schemaDir := createSchemaDir(t, "schema.sql", `
+			CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, status TEXT);
+			CREATE VIEW active_users AS SELECT name FROM users WHERE status = 'active';
+			CREATE VIEW flagged AS SELECT u.name, f.flag FROM users u JOIN legacy_flags f ON f.user_id = u.id;
+		`)
+		return db, schemaDir
+	}
+
+	db, schemaDir := setup(t)
+	defer func() { _ = db.Close() }()
+	err := Apply(db, schemaDir, ApplyOptions{CheckViews: true})
+	if err == nil || !strings.Contains(err.Error(), `view "flagged"`) {
+		t.Fatalf("Apply() error = %v, want the broken view reported", err)
+	}
+	current, err := parser.FromDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := current.Tables["legacy_flags"]; !ok {
+		t.Error("failed view check did not roll back")
+	}
+
+	// Without the check the broken view is left behind
+	db2, schemaDir2 := setup(t)
+	defer func() { _ = db2.Close() }()
+	if err := Apply(db2, schemaDir2, ApplyOptions{}); err != nil {
+		t.Fatalf("Apply() without CheckViews error: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Class rates how risky applying a change is
+type Class string
+
+const (
+	ClassSafe        Class = "safe"        // Can be applied at any time
+	ClassExpensive   Class = "expensive"   // Keeps all data but is slow or locks a large table, needs a maintenance window
+	ClassDestructive Class = "destructive" // May lose data, needs confirmation and a maintenance window
+)
+
+// NeedsWindow reports whether changes of this class are restricted to
+// maintenance windows
+func (c Class) NeedsWindow() bool {
+	return c == ClassExpensive || c == ClassDestructive
+}
+
+// Classifier decides the class of a planned change, e.g. from the size of
+// the table it touches. db is the database the change will be applied to.
+type Classifier interface {
+	Classify(db *sql.DB, c Change) (Class, error)
+}
+
+// ContextClassifier is a Classifier whose queries can be cancelled.
+// ClassifyContext calls ClassifyContext instead of Classify on classifiers
+// implementing it.
+type ContextClassifier interface {
+	Classifier
+	ClassifyContext(ctx context.Context, db *sql.DB, c Change) (Class, error)
+}
+
+// ClassifierFunc adapts a function to a Classifier
+type ClassifierFunc func(db *sql.DB, c Change) (Class, error)
+
+func (f ClassifierFunc) Classify(db *sql.DB, c Change) (Class, error) {
+	return f(db, c)
+}
+
+// DefaultClassifier classifies changes by their planned Destructive flag
+var DefaultClassifier Classifier = ClassifierFunc(func(_ *sql.DB, c Change) (Class, error) {
+	if c.Destructive {
+		return ClassDestructive, nil
+	}
+	return ClassSafe, nil
+})
+
+// Classify sets the Class of every change with the classifier, nil for
+// DefaultClassifier. Destructive and Severity follow the class, so
+// confirmation, SkipDestructive and maintenance windows honor it.
+func Classify(db *sql.DB, changes []Change, classifier Classifier) error {
+	return ClassifyContext(context.Background(), db, changes, classifier)
+}
+
+// ClassifyContext is Classify with a context, checked between changes and
+// passed to a ContextClassifier
+func ClassifyContext(ctx context.Context, db *sql.DB, changes []Change, classifier Classifier) error {
+	if classifier == nil {
+		classifier = DefaultClassifier
+	}
+	for i := range changes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var class Class
+		var err error
+		if cc, ok := classifier.(ContextClassifier); ok {
+			class, err = cc.ClassifyContext(ctx, db, changes[i])
+		} else {
+			class, err = classifier.Classify(db, changes[i])
+		}
+		if err != nil {
+			return fmt.Errorf("classify %s %s: %w", changes[i].Type, changes[i].Object, err)
+		}
+		switch class {
+		case ClassSafe, ClassExpensive, ClassDestructive:
+		default:
+			return fmt.Errorf("classify %s %s: unknown class %q", changes[i].Type, changes[i].Object, class)
+		}
+		changes[i].Class = class
+		changes[i].Destructive = class == ClassDestructive
+		changes[i].Severity = severityOf(changes[i])
+		if class == ClassExpensive && changes[i].Severity == SeverityInfo {
+			changes[i].Severity = SeverityWarn
+		}
+	}
+	return nil
+}
+
+// HasClass returns true if any change is of one of the classes
+func HasClass(changes []Change, classes ...Class) bool {
+	return slices.ContainsFunc(changes, func(c Change) bool {
+		return slices.Contains(classes, c.Class)
+	})
+}
+
+// RowCountClassifier classifies by the number of rows in the table a
+// change touches. Destructive changes to empty tables are safe, since
+// there is nothing to lose. Changes that copy, index or drop a table of at
+// least ExpensiveRows rows are expensive unless already destructive; zero
+// disables that rule. Row counts are estimated from the largest rowid.
+type RowCountClassifier struct {
+	ExpensiveRows int64
+}
+
+func (r RowCountClassifier) Classify(db *sql.DB, c Change) (Class, error) {
+	return r.ClassifyContext(context.Background(), db, c)
+}
+
+func (r RowCountClassifier) ClassifyContext(ctx context.Context, db *sql.DB, c Change) (Class, error) {
+	table := classifiedTable(c)
+	if table == "" {
+		return DefaultClassifier.Classify(db, c)
+	}
+	rows, err := estimateRows(ctx, db, table, r.ExpensiveRows)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case rows == 0:
+		return ClassSafe, nil
+	case c.Destructive:
+		return ClassDestructive, nil
+	case r.ExpensiveRows > 0 && rows >= r.ExpensiveRows && touchesAllRows(c):
+		return ClassExpensive, nil
+	}
+	return ClassSafe, nil
+}
+
+// classifiedTable returns the existing table a change reads or writes,
+// empty for views and triggers
+func classifiedTable(c Change) string {
+	switch objectKind(c.Type) {
+	case "index":
+		if c.Table != "" {
+			return c.Table
+		}
+		return indexTable(c)
+	case "view", "trigger":
+		return ""
+	}
+	return c.Object
+}
+
+// touchesAllRows reports whether a change reads or rewrites every row of
+// its table
+func touchesAllRows(c Change) bool {
+	switch {
+	case c.RecreatesTable(), c.Type == CreateIndex, c.Type == DropIndex, c.Type == DropTable, c.Type == DataMigration:
+		return true
+	case c.Type == AddColumn:
+		// Adding a column is instant unless it is backfilled
+		return c.backfills()
+	}
+	return false
+}
+
+// estimateRows estimates the rows of a table from its largest rowid, or
+// counts them up to limit for WITHOUT ROWID tables. It is 0 only for empty
+// tables and tables that do not exist yet.
+func estimateRows(ctx context.Context, db *sql.DB, table string, limit int64) (int64, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx,
+		"SELECT count(*) > 0 FROM sqlite_master WHERE type='table' AND name=?", table,
+	).Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("look up table %s: %w", table, err)
+	}
+	if !exists {
+		return 0, nil
+	}
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT 1 FROM %q LIMIT 1", table)).Scan(&exists); errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("read table %s: %w", table, err)
+	}
+
+	var rows sql.NullInt64
+	if err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT max(rowid) FROM %q", table)).Scan(&rows); err == nil {
+		return max(rows.Int64, 1), nil
+	}
+	query := fmt.Sprintf("SELECT count(*) FROM (SELECT 1 FROM %q LIMIT ?)", table)
+	if err := db.QueryRowContext(ctx, query, max(limit, 1)).Scan(&rows); err != nil {
+		return 0, fmt.Errorf("count rows of %s: %w", table, err)
+	}
+	return rows.Int64, nil
+}
@@ -0,0 +1,180 @@
+package diff
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRowCountClassifier(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE staging (id INTEGER PRIMARY KEY, payload TEXT);
+		CREATE TABLE events (id INTEGER PRIMARY KEY, kind TEXT);
+		CREATE INDEX idx_events_kind ON events (kind);
+		CREATE TABLE small (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE tags (name TEXT PRIMARY KEY) WITHOUT ROWID;
+		INSERT INTO events (id, kind) VALUES (1, 'a'), (1000, 'b');
+		INSERT INTO small (id, name) VALUES (1, 'x');
+		INSERT INTO tags (name) VALUES ('a'), ('b'), ('c');
+	`)
+	defer func() { _ = db.Close() }()
+	classifier := RowCountClassifier{ExpensiveRows: 100}
+
+	tests := []struct {
+		name   string
+		change Change
+		want   Class
+	}{
+		{
+			name:   "drop empty table",
+			change: Change{Type: DropTable, Object: "staging", Destructive: true},
+			want:   ClassSafe,
+		},
+		{
+			name:   "drop large table",
+			change: Change{Type: DropTable, Object: "events", Destructive: true},
+			want:   ClassDestructive,
+		},
+		{
+			name:   "drop index on large table",
+			change: Change{Type: DropIndex, Object: "idx_events_kind", Table: "events"},
+			want:   ClassExpensive,
+		},
+		{
+			name:   "create index on large table",
+			change: Change{Type: CreateIndex, Object: "idx_events_id", SQL: []string{`CREATE INDEX idx_events_id ON "events" (id);`}},
+			want:   ClassExpensive,
+		},
+		{
+			name:   "create index on small table",
+			change: Change{Type: CreateIndex, Object: "idx_small_name", Table: "small"},
+			want:   ClassSafe,
+		},
+		{
+			name:   "add column without backfill",
+			change: Change{Type: AddColumn, Object: "events", SQL: []string{`ALTER TABLE "events" ADD COLUMN "at" TEXT;`}},
+			want:   ClassSafe,
+		},
+		{
+			name: "add column with backfill",
+			change: Change{Type: AddColumn, Object: "events", SQL: []string{
+				`ALTER TABLE "events" ADD COLUMN "at" TEXT;`,
+				`UPDATE "events" SET "at" = 'now';`,
+			}},
+			want: ClassExpensive,
+		},
+		{
+			name:   "create table",
+			change: Change{Type: CreateTable, Object: "new_table"},
+			want:   ClassSafe,
+		},
+		{
+			name:   "drop without rowid table",
+			change: Change{Type: DropTable, Object: "tags", Destructive: true},
+			want:   ClassDestructive,
+		},
+		{
+			name:   "drop trigger",
+			change: Change{Type: DropTrigger, Object: "trg", Table: "events"},
+			want:   ClassSafe,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := classifier.Classify(db, tt.change)
+			if err != nil {
+				t.Fatalf("Classify() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Classify() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	changes := []Change{
+		{Type: DropTable, Object: "a", Destructive: true},
+		{Type: CreateIndex, Object: "b"},
+		{Type: CreateTable, Object: "c"},
+	}
+	classes := map[string]Class{"a": ClassSafe, "b": ClassExpensive, "c": ClassDestructive}
+	classifier := ClassifierFunc(func(_ *sql.DB, c Change) (Class, error) {
+		return classes[c.Object], nil
+	})
+
+	if err := Classify(nil, changes, classifier); err != nil {
+		t.Fatalf("Classify() error = %v", err)
+	}
+	for _, c := range changes {
+		if c.Class != classes[c.Object] || c.Destructive != (c.Class == ClassDestructive) {
+			t.Errorf("%s: class %q, destructive %v", c.Object, c.Class, c.Destructive)
+		}
+	}
+	if !HasClass(changes, ClassExpensive) || HasClass(changes[:1], ClassExpensive, ClassDestructive) {
+		t.Error("HasClass() mismatch")
+	}
+
+	unknown := ClassifierFunc(func(*sql.DB, Change) (Class, error) { return "risky", nil })
+	if err := Classify(nil, changes, unknown); err == nil {
+		t.Error("Classify() accepted an unknown class")
+	}
+
+	if err := Classify(nil, changes[:1], nil); err != nil || changes[0].Class != ClassSafe {
+		t.Errorf("Classify() with DefaultClassifier = %q, %v", changes[0].Class, err)
+	}
+}
+
+func TestCheckWindows_Expensive(t *testing.T) {
+	windows := []Window{{Start: 2 * time.Hour, End: 4 * time.Hour}}
+	noon := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	changes := []Change{{Type: CreateIndex, Object: "idx", Class: ClassExpensive}}
+
+	if err := CheckWindows(changes, windows, noon); !errors.Is(err, ErrOutsideWindow) {
+		t.Errorf("CheckWindows() error = %v, want ErrOutsideWindow", err)
+	}
+	changes[0].Class = ClassSafe
+	if err := CheckWindows(changes, windows, noon); err != nil {
+		t.Errorf("CheckWindows() error = %v", err)
+	}
+}
+
+func TestApplyPlan_Classifier(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE staging (id INTEGER PRIMARY KEY);
+		INSERT INTO users (id, name) VALUES (1, 'a');
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "schema.sql", ``)
+
+	changes, err := CompareWithOptions(db, schemaDir, DiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := ApplyOptions{SkipDestructive: true, SkipDiskCheck: true, Classifier: RowCountClassifier{}}
+	if err := ApplyPlan(db, schemaDir, changes, opts); err != nil {
+		t.Fatalf("ApplyPlan() error = %v", err)
+	}
+	if changes[0].Class != "" {
+		t.Error("ApplyPlan() classified the caller's changes")
+	}
+
+	var tables []string
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table' ORDER BY name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, name)
+	}
+	if len(tables) != 1 || tables[0] != "users" {
+		t.Errorf("tables after apply = %v, want only users", tables)
+	}
+}
@@ -0,0 +1,237 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// codegenHeader marks generated files, so linters and reviewers skip them
+const codegenHeader = "Code generated by sqlite-schema-diff codegen. DO NOT EDIT."
+
+// withoutRowidRe matches the WITHOUT ROWID clause of a CREATE TABLE
+var withoutRowidRe = regexp.MustCompile(`(?i)\bwithout\s+rowid\b`)
+
+// tsTypes maps value kinds to TypeScript types
+var tsTypes = map[string]string{
+	"INTEGER": "number",
+	"REAL":    "number",
+	"NUMERIC": "number",
+	"TEXT":    "string",
+	"BLOB":    "Uint8Array",
+	"ANY":     "unknown",
+}
+
+// tsIdentRe matches property names TypeScript accepts without quotes
+var tsIdentRe = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// valueKind is what a column holds as seen by a client reading rows: the
+// type affinity of its declared type, except that dates, times and JSON
+// are read as text and ANY or no type can hold anything
+func valueKind(col schema.Column) string {
+	t := strings.ToUpper(col.Type)
+	switch {
+	case t == "" || t == "ANY":
+		return "ANY"
+	case strings.Contains(t, "DATE"), strings.Contains(t, "TIME"), strings.Contains(t, "JSON"):
+		return "TEXT"
+	}
+	return affinity(col.Type)
+}
+
+// nullable reports whether reading a column can return NULL. Primary key
+// columns may hold NULL in rowid tables unless they are the rowid itself.
+func nullable(t *schema.Table, col schema.Column) bool {
+	if col.NotNull {
+		return false
+	}
+	if col.PrimaryKey > 0 {
+		rowid := strings.EqualFold(col.Type, "INTEGER") && !slices.ContainsFunc(t.Columns, func(c schema.Column) bool {
+			return c.PrimaryKey > 1
+		})
+		return !rowid && !withoutRowidRe.MatchString(t.SQL)
+	}
+	return true
+}
+
+// codegenColumns returns the columns a SELECT * returns, without the hidden
+// columns of virtual tables
+func codegenColumns(t *schema.Table) []schema.Column {
+	var columns []schema.Column
+	for _, col := range t.Columns {
+		if col.Hidden != 1 {
+			columns = append(columns, col)
+		}
+	}
+	return columns
+}
+
+// tableTypeName turns a table name into a type name, e.g. user_roles into
+// UserRoles
+func tableTypeName(table string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range table {
+		switch {
+		case r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9':
+			if upper && r >= 'a' && r <= 'z' {
+				r -= 'a' - 'A'
+			}
+			b.WriteRune(r)
+			upper = false
+		default:
+			upper = true
+		}
+	}
+	name := b.String()
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "T" + name
+	}
+	return name
+}
+
+// codegenTables returns the tables of a schema in name order, with the
+// type name of each. Tables whose type names collide get a numeric suffix.
+func codegenTables(s *schema.Database) ([]*schema.Table, []string) {
+	tables := make([]*schema.Table, 0, len(s.Tables))
+	names := make([]string, 0, len(s.Tables))
+	used := make(map[string]int)
+	for _, name := range slices.Sorted(maps.Keys(s.Tables)) {
+		t := s.Tables[name]
+		typ := tableTypeName(t.Name)
+		if used[typ]++; used[typ] > 1 {
+			typ = fmt.Sprintf("%s%d", typ, used[typ])
+		}
+		tables = append(tables, t)
+		names = append(names, typ)
+	}
+	return tables, names
+}
+
+// WriteTypeScript writes a TypeScript interface for the rows of every
+// table, as JavaScript drivers for SQLite (better-sqlite3, D1, libSQL)
+// return them, and a DB interface mapping table names to row types:
+//
+//	export interface UserRoles {
+//	  user_id: number;
+//	  role: string | null;
+//	}
+//
+// INTEGER, REAL and NUMERIC columns are numbers, TEXT, date, time and JSON
+// columns strings, BLOB columns Uint8Array and columns without a type (or
+// ANY) unknown. Columns that can be NULL include null. Columns tagged as
+// sensitive get a JSDoc tag like @pii.
+func WriteTypeScript(w io.Writer, s *schema.Database) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// %s\n", codegenHeader)
+
+	tables, names := codegenTables(s)
+	for i, t := range tables {
+		fmt.Fprintf(&b, "\nexport interface %s {\n", names[i])
+		for _, col := range codegenColumns(t) {
+			typ := tsTypes[valueKind(col)]
+			if typ != "unknown" && nullable(t, col) {
+				typ += " | null"
+			}
+			if col.Sensitive != "" {
+				fmt.Fprintf(&b, "  /** @%s */\n", col.Sensitive)
+			}
+			fmt.Fprintf(&b, "  %s: %s;\n", tsProperty(col.Name), typ)
+		}
+		b.WriteString("}\n")
+	}
+
+	b.WriteString("\nexport interface DB {\n")
+	for i, t := range tables {
+		fmt.Fprintf(&b, "  %s: %s;\n", tsProperty(t.Name), names[i])
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// tsProperty quotes a property name unless it is an identifier
+func tsProperty(name string) string {
+	if tsIdentRe.MatchString(name) {
+		return name
+	}
+	quoted, _ := json.Marshal(name)
+	return string(quoted)
+}
+
+// WriteJSONSchema writes a JSON Schema (draft 2020-12) with a definition
+// for the rows of every table in $defs, keyed by table name, e.g. to
+// validate API payloads at the edge. The top-level schema describes an
+// object of table names to arrays of rows. Column types follow
+// WriteTypeScript; BLOB columns are base64 strings. Columns tagged as
+// sensitive carry the tag in "x-sensitive".
+func WriteJSONSchema(w io.Writer, s *schema.Database) error {
+	defs := make(map[string]any, len(s.Tables))
+	properties := make(map[string]any, len(s.Tables))
+	tables, names := codegenTables(s)
+	for i, t := range tables {
+		columns := codegenColumns(t)
+		props := make(map[string]any, len(columns))
+		required := make([]string, 0, len(columns))
+		for _, col := range columns {
+			prop := map[string]any{}
+			switch valueKind(col) {
+			case "INTEGER":
+				prop["type"] = "integer"
+			case "REAL", "NUMERIC":
+				prop["type"] = "number"
+			case "TEXT":
+				prop["type"] = "string"
+			case "BLOB":
+				prop["type"] = "string"
+				prop["contentEncoding"] = "base64"
+			}
+			if typ, ok := prop["type"]; ok && nullable(t, col) {
+				prop["type"] = []any{typ, "null"}
+			}
+			if col.Type != "" {
+				prop["description"] = col.Type
+			}
+			if col.Sensitive != "" {
+				prop["x-sensitive"] = col.Sensitive
+			}
+			props[col.Name] = prop
+			required = append(required, col.Name)
+		}
+		defs[t.Name] = map[string]any{
+			"title":                names[i],
+			"type":                 "object",
+			"properties":           props,
+			"required":             required,
+			"additionalProperties": false,
+		}
+		properties[t.Name] = map[string]any{
+			"type":  "array",
+			"items": map[string]any{"$ref": "#/$defs/" + jsonPointerEscape(t.Name)},
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(map[string]any{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"$comment":    codegenHeader,
+		"type":        "object",
+		"properties":  properties,
+		"$defs":       defs,
+		"description": "Rows of the tables of a SQLite database",
+	})
+}
+
+// jsonPointerEscape escapes a name for use in a JSON pointer fragment
+func jsonPointerEscape(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1", "%", "%25", " ", "%20", `"`, "%22").Replace(name)
+}
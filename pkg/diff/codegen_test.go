@@ -0,0 +1,111 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+func codegenSchema(t *testing.T) *schema.Database {
+	t.Helper()
+	s, err := parser.FromSQL(`
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY,
+			email TEXT NOT NULL,
+			created_at DATETIME,
+			avatar BLOB,
+			score REAL,
+			meta
+		);
+		CREATE TABLE "user-roles" (user_id INTEGER NOT NULL, role TEXT NOT NULL, PRIMARY KEY (user_id, role));
+		CREATE TABLE kv (k TEXT PRIMARY KEY, v ANY) STRICT, WITHOUT ROWID;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestWriteTypeScript(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteTypeScript(&buf, codegenSchema(t)); err != nil {
+		t.Fatal(err)
+	}
+	want := `// Code generated by sqlite-schema-diff codegen. DO NOT EDIT.
+
+export interface Kv {
+  k: string;
+  v: unknown;
+}
+
+export interface UserRoles {
+  user_id: number;
+  role: string;
+}
+
+export interface Users {
+  id: number;
+  email: string;
+  created_at: string | null;
+  avatar: Uint8Array | null;
+  score: number | null;
+  meta: unknown;
+}
+
+export interface DB {
+  kv: Kv;
+  "user-roles": UserRoles;
+  users: Users;
+}
+`
+	if buf.String() != want {
+		t.Errorf("WriteTypeScript() =\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestWriteJSONSchema(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJSONSchema(&buf, codegenSchema(t)); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Properties map[string]struct {
+			Items struct {
+				Ref string `json:"$ref"`
+			} `json:"items"`
+		} `json:"properties"`
+		Defs map[string]struct {
+			Properties map[string]map[string]any `json:"properties"`
+			Required   []string                  `json:"required"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if ref := doc.Properties["user-roles"].Items.Ref; ref != "#/$defs/user-roles" {
+		t.Errorf("user-roles items = %q", ref)
+	}
+	users := doc.Defs["users"]
+	if !reflect.DeepEqual(users.Required, []string{"id", "email", "created_at", "avatar", "score", "meta"}) {
+		t.Errorf("users required = %v", users.Required)
+	}
+	for column, want := range map[string]any{
+		"id":         "integer",
+		"email":      "string",
+		"created_at": []any{"string", "null"},
+		"score":      []any{"number", "null"},
+		"meta":       nil,
+	} {
+		if got := users.Properties[column]["type"]; !reflect.DeepEqual(got, want) {
+			t.Errorf("users.%s type = %v, want %v", column, got, want)
+		}
+	}
+	if enc := users.Properties["avatar"]["contentEncoding"]; enc != "base64" {
+		t.Errorf("users.avatar contentEncoding = %v, want base64", enc)
+	}
+}
@@ -0,0 +1,136 @@
+package diff
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxStatsRows bounds the rows read per column by DestructiveColumnStats,
+// so gathering statistics stays fast on large tables
+const maxStatsRows = 100_000
+
+// ColumnStats summarizes the data of a column a destructive change loses
+type ColumnStats struct {
+	Table     string
+	Column    string
+	Rows      int64
+	Distinct  int64
+	Nulls     int64
+	Min       string // Smallest value, shortened for display
+	Max       string // Largest value, shortened for display
+	Truncated bool   // Only the first maxStatsRows rows were read
+	Sensitive string // Sensitivity tag of the column; Min and Max are redacted
+}
+
+func (s ColumnStats) String() string {
+	rows := fmt.Sprint(s.Rows)
+	if s.Truncated {
+		rows = "first " + rows
+	}
+	if s.Rows == 0 {
+		return fmt.Sprintf("%s.%s: no rows", s.Table, s.Column)
+	}
+	if s.Rows == s.Nulls {
+		return fmt.Sprintf("%s.%s: %s rows, all NULL", s.Table, s.Column, rows)
+	}
+	if s.Sensitive != "" {
+		return fmt.Sprintf("%s.%s: %s rows, %d distinct, %d NULL, values redacted (%s)",
+			s.Table, s.Column, rows, s.Distinct, s.Nulls, s.Sensitive)
+	}
+	return fmt.Sprintf("%s.%s: %s rows, %d distinct, %d NULL, min %s, max %s",
+		s.Table, s.Column, rows, s.Distinct, s.Nulls, s.Min, s.Max)
+}
+
+// DestructiveColumnStats gathers statistics of the columns whose data the
+// destructive changes lose: every column of a dropped table, and the
+// columns a recreated table does not copy. Each column is read with a
+// bounded query, so the result describes at most maxStatsRows rows. The
+// smallest and largest values of columns tagged as sensitive are redacted.
+func DestructiveColumnStats(db *sql.DB, changes []Change) ([]ColumnStats, error) {
+	var stats []ColumnStats
+	for _, c := range changes {
+		if !c.Destructive {
+			continue
+		}
+		lost, err := lostColumns(db, c)
+		if err != nil {
+			return nil, err
+		}
+		tags, err := sensitiveColumns(db, c.Object)
+		if err != nil {
+			return nil, err
+		}
+		for _, col := range lost {
+			s, err := columnStats(db, c.Object, col)
+			if err != nil {
+				return nil, fmt.Errorf("column stats of %s.%s: %w", c.Object, col, err)
+			}
+			if s.Sensitive = tags[col]; s.Sensitive != "" {
+				s.Min, s.Max = redacted, redacted
+			}
+			stats = append(stats, s)
+		}
+	}
+	return stats, nil
+}
+
+// lostColumns returns the columns of the current table a change loses
+func lostColumns(db *sql.DB, c Change) ([]string, error) {
+	var keep func(col string) bool
+	switch {
+	case c.Type == DropTable:
+		keep = func(string) bool { return false }
+	case c.RecreatesTable():
+		rc, ok := findRecreateCopy(c)
+		if !ok {
+			return nil, nil // Edited or unusual SQL, the lost columns are unknown
+		}
+		exprs := stringLiteralRe.ReplaceAllString(rc.exprs, "''")
+		keep = func(col string) bool {
+			if strings.Contains(rc.cols, fmt.Sprintf("%q", col)) {
+				return true
+			}
+			// Expressions filling other columns may read it
+			re := regexp.MustCompile(`(?i)(^|[^\w$])["` + "`" + `\[]?` + regexp.QuoteMeta(col) + `["` + "`" + `\]]?($|[^\w$])`)
+			return re.MatchString(exprs)
+		}
+	default:
+		return nil, nil
+	}
+
+	cols, err := queryStrings(db, "SELECT name FROM pragma_table_info(?) ORDER BY cid", c.Object)
+	if err != nil {
+		return nil, fmt.Errorf("read columns of %q: %w", c.Object, err)
+	}
+	var lost []string
+	for _, col := range cols {
+		if !keep(col) {
+			lost = append(lost, col)
+		}
+	}
+	return lost, nil
+}
+
+// columnStats reads the statistics of one column within maxStatsRows rows
+func columnStats(db *sql.DB, table, col string) (ColumnStats, error) {
+	s := ColumnStats{Table: table, Column: col}
+	sample := func(v string) string {
+		return fmt.Sprintf(
+			`CASE typeof(%[1]s) WHEN 'blob' THEN 'x''' || hex(substr(%[1]s, 1, 16)) || ''''
+				WHEN 'text' THEN '''' || substr(%[1]s, 1, 40) || '''' ELSE CAST(%[1]s AS TEXT) END`, v)
+	}
+	query := fmt.Sprintf(
+		`SELECT count(*), count(DISTINCT v), count(*) - count(v), %s, %s
+		FROM (SELECT %q AS v FROM %q LIMIT %d)`,
+		sample("min(v)"), sample("max(v)"), col, table, maxStatsRows,
+	)
+	var lo, hi sql.NullString
+	if err := db.QueryRow(query).Scan(&s.Rows, &s.Distinct, &s.Nulls, &lo, &hi); err != nil {
+		return s, err
+	}
+	s.Min, s.Max = lo.String, hi.String
+	s.Truncated = s.Rows == maxStatsRows
+	return s, nil
+}
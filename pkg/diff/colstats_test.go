@@ -0,0 +1,51 @@
+package diff
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+func TestDestructiveColumnStats(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, legacy TEXT, avatar BLOB, score INT);
+		INSERT INTO users (name, legacy, avatar, score) VALUES
+			('ann', 'a', x'0102', 3), ('bob', 'a', NULL, 5), ('cy', NULL, NULL, 1);
+		CREATE TABLE sessions (token TEXT);
+	`)
+	defer func() { _ = db.Close() }()
+
+	current, err := parser.FromDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := parser.FromSQL(`
+		-- legacy and avatar are dropped, score is read by the new total column
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, total INT DEFAULT 0 -- @from: score * 2
+		);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := Diff(current, target)
+
+	stats, err := DestructiveColumnStats(db, changes)
+	if err != nil {
+		t.Fatalf("DestructiveColumnStats() error = %v", err)
+	}
+	var got []string
+	for _, s := range stats {
+		got = append(got, s.String())
+	}
+	want := []string{
+		"sessions.token: no rows",
+		"users.legacy: 3 rows, 1 distinct, 1 NULL, min 'a', max 'a'",
+		"users.avatar: 3 rows, 1 distinct, 2 NULL, min x'0102', max x'0102'",
+	}
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("DestructiveColumnStats() =\n%q\nwant\n%q", got, want)
+	}
+}
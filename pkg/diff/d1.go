@@ -0,0 +1,122 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// d1MigrationRe matches wrangler migration file names like 0001_init.sql
+var d1MigrationRe = regexp.MustCompile(`^(\d+)_.*\.sql$`)
+
+// ErrD1OnDelete is returned for D1 output when a recreated table is the
+// parent of a foreign key with an ON DELETE action
+var ErrD1OnDelete = errors.New("recreate would fire ON DELETE actions on D1")
+
+// GenerateD1SQL generates migration SQL for Cloudflare D1. Wrangler runs every
+// migration file in its own transaction and rejects BEGIN/COMMIT, and
+// foreign_keys cannot be toggled inside a transaction, so foreign key checks
+// are deferred to the commit instead. Deferring does not stop ON DELETE
+// actions, so check the plan with CheckD1 first, or use WriteSQL with
+// SQLOptions.Current, which does.
+func GenerateD1SQL(changes []Change) string {
+	var sb strings.Builder
+	_ = WriteSQL(&sb, changes, SQLOptions{D1: true})
+	return sb.String()
+}
+
+// CheckD1 fails with ErrD1OnDelete if the changes recreate a table that a
+// foreign key in current references with ON DELETE CASCADE, SET NULL or
+// SET DEFAULT. D1 keeps foreign keys enforced, so dropping the old table
+// would run the action on every child row, deleting or clearing them.
+func CheckD1(current *schema.Database, changes []Change) error {
+	recreated := make(map[string]bool)
+	for _, c := range changes {
+		if c.RecreatesTable() {
+			recreated[strings.ToLower(c.Object)] = true
+		}
+	}
+	if len(recreated) == 0 {
+		return nil
+	}
+
+	db, err := buildDatabase(current)
+	if err != nil {
+		return fmt.Errorf("build current schema: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	var problems []string
+	for _, child := range slices.Sorted(maps.Keys(current.Tables)) {
+		fks, err := queryStrings(db, `SELECT DISTINCT "table" || ' ' || on_delete FROM pragma_foreign_key_list(?)
+			WHERE on_delete IN ('CASCADE', 'SET NULL', 'SET DEFAULT')`, child)
+		if err != nil {
+			return fmt.Errorf("foreign keys of %s: %w", child, err)
+		}
+		for _, fk := range fks {
+			parent, action, _ := strings.Cut(fk, " ")
+			if recreated[strings.ToLower(parent)] {
+				problems = append(problems, fmt.Sprintf("%q references %q with ON DELETE %s", child, parent, action))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrD1OnDelete, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// WriteD1Migration writes changes as the next numbered wrangler migration in
+// dir (e.g. migrations/0003_name.sql) and returns the path of the new file.
+// The plan is checked with CheckD1 against current first.
+func WriteD1Migration(dir, name string, current *schema.Database, changes []Change) (string, error) {
+	if err := CheckD1(current, changes); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create migrations directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("read migrations directory: %w", err)
+	}
+	next := 1
+	for _, e := range entries {
+		m := d1MigrationRe.FindStringSubmatch(e.Name())
+		if m == nil || e.IsDir() {
+			continue
+		}
+		if n, err := strconv.Atoi(m[1]); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+
+	number := fmt.Sprintf("%04d", next)
+	path := filepath.Join(dir, fmt.Sprintf("%s_%s.sql", number, name))
+	header := fmt.Sprintf("-- Migration number: %s \t %s\n", number, time.Now().UTC().Format(time.RFC3339))
+
+	// O_EXCL so a concurrently created migration is never overwritten
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", fmt.Errorf("create migration: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err := f.WriteString(header); err != nil {
+		return "", fmt.Errorf("write migration: %w", err)
+	}
+	if err := WriteSQL(f, changes, SQLOptions{D1: true}); err != nil {
+		return "", fmt.Errorf("write migration: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,136 @@
+package diff
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+func TestGenerateD1SQL(t *testing.T) {
+	changes := []Change{{
+		Type:        AddColumn,
+		Description: `Add column "email" to table "users"`,
+		SQL:         []string{`ALTER TABLE "users" ADD COLUMN "email" TEXT;`},
+	}}
+
+	got := GenerateD1SQL(changes)
+	for _, forbidden := range []string{"BEGIN", "COMMIT", "PRAGMA foreign_keys"} {
+		if strings.Contains(got, forbidden) {
+			t.Errorf("D1 SQL must not contain %s:\n%s", forbidden, got)
+		}
+	}
+	if !strings.Contains(got, "PRAGMA defer_foreign_keys = true;") {
+		t.Errorf("D1 SQL should defer foreign keys:\n%s", got)
+	}
+	if !strings.Contains(got, changes[0].SQL[0]) {
+		t.Errorf("D1 SQL missing change statement:\n%s", got)
+	}
+	if GenerateD1SQL(nil) != "" {
+		t.Error("expected empty SQL without changes")
+	}
+}
+
+func TestWriteD1Migration(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"0001_init.sql", "0007_users.sql", "README.md"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes := []Change{{Type: CreateTable, SQL: []string{"CREATE TABLE t (id INT);"}}}
+	path, err := WriteD1Migration(dir, "schema_diff", schema.NewDatabase(), changes)
+	if err != nil {
+		t.Fatalf("WriteD1Migration() error: %v", err)
+	}
+	if filepath.Base(path) != "0008_schema_diff.sql" {
+		t.Errorf("path = %s, want 0008_schema_diff.sql", path)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(content), "-- Migration number: 0008") ||
+		!strings.Contains(string(content), "CREATE TABLE t (id INT);") {
+		t.Errorf("unexpected migration content:\n%s", content)
+	}
+}
+
+func TestD1_ForeignKeyActions(t *testing.T) {
+	for _, tt := range []struct {
+		action  string
+		refused bool
+	}{
+		{"CASCADE", true},
+		{"SET NULL", true},
+		{"NO ACTION", false},
+	} {
+		t.Run(tt.action, func(t *testing.T) {
+			db := openTestDB(t, `
+				PRAGMA foreign_keys = ON;
+				CREATE TABLE parents (id INTEGER PRIMARY KEY, name TEXT);
+				CREATE TABLE children (id INTEGER PRIMARY KEY, parent_id INTEGER REFERENCES parents(id) ON DELETE `+tt.action+`);
+				INSERT INTO parents (id, name) VALUES (1, 'a');
+				INSERT INTO children (id, parent_id) VALUES (1, 1);
+			`)
+			defer func() { _ = db.Close() }()
+			schemaDir := createSchemaDir(t, "schema.sql", `
+				CREATE TABLE parents (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+				CREATE TABLE children (id INTEGER PRIMARY KEY, parent_id INTEGER REFERENCES parents(id) ON DELETE `+tt.action+`);
+			`)
+			current, err := parser.FromDB(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			changes, err := Compare(db, schemaDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var sb strings.Builder
+			err = WriteSQL(&sb, changes, SQLOptions{D1: true, Current: current})
+			if tt.refused {
+				if !errors.Is(err, ErrD1OnDelete) || sb.Len() > 0 {
+					t.Fatalf("WriteSQL() error = %v with %d bytes written, want ErrD1OnDelete and nothing", err, sb.Len())
+				}
+				if _, err := WriteD1Migration(t.TempDir(), "recreate", current, changes); !errors.Is(err, ErrD1OnDelete) {
+					t.Errorf("WriteD1Migration() error = %v, want ErrD1OnDelete", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WriteSQL() error: %v", err)
+			}
+
+			// Run the script the way D1 does: foreign keys on, in one
+			// transaction. The orphaned rows may fail the deferred check at
+			// the commit, which rolls back, but are never deleted.
+			conn, err := db.Conn(t.Context())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = conn.Close() }()
+			for _, stmt := range []string{"PRAGMA foreign_keys = ON", "BEGIN", sb.String()} {
+				if _, err := conn.ExecContext(t.Context(), stmt); err != nil {
+					t.Fatalf("%s: %v", stmt, err)
+				}
+			}
+			if _, err := conn.ExecContext(t.Context(), "COMMIT"); err != nil {
+				_, _ = conn.ExecContext(t.Context(), "ROLLBACK")
+			}
+			var children int
+			if err := conn.QueryRowContext(t.Context(), "SELECT count(*) FROM children WHERE parent_id = 1").Scan(&children); err != nil {
+				t.Fatal(err)
+			}
+			if children != 1 {
+				t.Errorf("children = %d after the recreate, want 1", children)
+			}
+		})
+	}
+}
@@ -3,11 +3,15 @@ package diff
 
 import (
 	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"maps"
 	"regexp"
 	"slices"
 	"strings"
 
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
 	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
 )
 
@@ -26,60 +30,279 @@ const (
 	DropView      ChangeType = "DROP_VIEW"
 	CreateTrigger ChangeType = "CREATE_TRIGGER"
 	DropTrigger   ChangeType = "DROP_TRIGGER"
+	DataMigration ChangeType = "DATA_MIGRATION" // User-supplied data hook, see DataHook
+
+	// Column and table operations SQLite may only support by recreating the
+	// table. They are planned as their own types so policies and reports can
+	// tell them apart; Change.RecreatesTable reports how they are applied.
+	DropColumn         ChangeType = "DROP_COLUMN"
+	AlterColumnDefault ChangeType = "ALTER_COLUMN_DEFAULT"
+	RenameTable        ChangeType = "RENAME_TABLE"
+)
+
+// Reason describes why the diff engine planned a change
+type Reason string
+
+const (
+	ObjectAdded          Reason = "OBJECT_ADDED"
+	ObjectRemoved        Reason = "OBJECT_REMOVED"
+	DefinitionChanged    Reason = "DEFINITION_CHANGED"   // Index, view, trigger or virtual table SQL differs
+	DependencyRecreated  Reason = "DEPENDENCY_RECREATED" // Parent table is being recreated
+	ColumnAdded          Reason = "COLUMN_ADDED"
+	AddColumnUnsupported Reason = "ADD_COLUMN_UNSUPPORTED" // The new column cannot be added with ALTER TABLE, see Change.Description
+	ColumnDropped        Reason = "COLUMN_DROPPED"
+	ColumnRenamed        Reason = "COLUMN_RENAMED"
+	TableRenamed         Reason = "TABLE_RENAMED" // The only dropped and the only new table have the same definition
+	ColumnOrderChanged   Reason = "COLUMN_ORDER_CHANGED"
+	ColumnTypeChanged    Reason = "COLUMN_TYPE_CHANGED"
+	NullabilityChanged   Reason = "NULLABILITY_CHANGED"
+	PrimaryKeyChanged    Reason = "PRIMARY_KEY_CHANGED"
+	DefaultChanged       Reason = "DEFAULT_CHANGED"
+	GeneratedChanged     Reason = "GENERATED_CHANGED"
+	ConstraintAdded      Reason = "CONSTRAINT_ADDED"
+	ConstraintRemoved    Reason = "CONSTRAINT_REMOVED"
+	RawSQLMismatch       Reason = "RAW_SQL_MISMATCH"    // Normalized CREATE TABLE text differs, columns do not
+	DataHookMatched      Reason = "DATA_HOOK_MATCHED"   // A data hook is declared to run after the preceding change
+	RowsBackfilled       Reason = "ROWS_BACKFILLED"     // The table's rows are backfilled, its triggers must not fire
+	PartitionScheduled   Reason = "PARTITION_SCHEDULED" // A partition of the current or an upcoming period is missing
+	PartitionExpired     Reason = "PARTITION_EXPIRED"   // A partition is older than its table retains
 )
 
 // Change represents a single schema change
 type Change struct {
-	Type        ChangeType
-	Object      string   // Name of the object being changed
-	Description string   // Human-readable description
-	SQL         []string // SQL statements to apply
-	Destructive bool     // Whether this change may lose data
+	Type        ChangeType `json:"type"`
+	Object      string     `json:"object"`              // Name of the object being changed
+	Column      string     `json:"column,omitempty"`    // Column added or renamed (new name) by ADD_COLUMN and RENAME_COLUMN
+	Table       string     `json:"table,omitempty"`     // Table an index or trigger belongs to
+	Description string     `json:"description"`         // Human-readable description
+	SQL         []string   `json:"sql"`                 // SQL statements to apply
+	Destructive bool       `json:"destructive"`         // Whether this change may lose data
+	Severity    Severity   `json:"severity"`            // Impact of the change, danger exactly when Destructive
+	Class       Class      `json:"class,omitempty"`     // How risky applying the change is, set by Classify
+	Reason      Reason     `json:"reason,omitempty"`    // Why the change was planned
+	Sensitive   bool       `json:"sensitive,omitempty"` // Touches a column tagged as sensitive, e.g. "-- @pii"
+	Pages       int64      `json:"pages,omitempty"`     // Current size of the affected object in pages (see AnnotateSizes)
+	Bytes       int64      `json:"bytes,omitempty"`     // Current size of the affected object in bytes (see AnnotateSizes)
+	CopyKey     string     `json:"copy_key,omitempty"`  // Rowid of both tables if a recreate copies rows with their rowids, so low-priority apply can copy in batches
+}
+
+// ID returns a short identifier derived from the change's type, object,
+// column and SQL. The same change planned again gets the same ID, so plans
+// from different runs can be compared and approvals can refer to a change.
+// Editing the SQL of a change gives it a new ID.
+func (c Change) ID() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s", c.Type, strings.ToLower(c.Object), strings.ToLower(c.Column), c.Table)
+	for _, stmt := range c.SQL {
+		fmt.Fprintf(h, "\x00%s", stmt)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// RecreatesTable reports whether the change rebuilds its table by copying
+// it into a new one. RECREATE_TABLE always does; column-level types do
+// when SQLite cannot change the table in place.
+func (c Change) RecreatesTable() bool {
+	if c.Type == RecreateTable {
+		return true
+	}
+	if objectKind(c.Type) != "table" {
+		return false
+	}
+	newTable := fmt.Sprintf("%q", c.Object+"__new")
+	return slices.ContainsFunc(c.SQL, func(stmt string) bool {
+		return strings.HasPrefix(strings.ToUpper(stripComments(stmt)), "CREATE") && strings.Contains(stmt, newTable)
+	})
+}
+
+// backfills reports whether the change adds a column and fills it for the
+// existing rows
+func (c Change) backfills() bool {
+	return c.Type == AddColumn && len(c.SQL) > 1
+}
+
+// ColumnOrderPolicy controls whether the declared column order is significant
+type ColumnOrderPolicy string
+
+const (
+	// ColumnOrderStrict recreates tables so their columns match the declared
+	// order. This is the default.
+	ColumnOrderStrict ColumnOrderPolicy = "strict"
+	// ColumnOrderIgnore treats column order as insignificant: new columns are
+	// appended with ADD COLUMN wherever they are declared, and reordered
+	// columns are not reported as a change
+	ColumnOrderIgnore ColumnOrderPolicy = "ignore"
+)
+
+// DiffOptions configures how schemas are compared
+type DiffOptions struct {
+	ColumnOrder ColumnOrderPolicy // Default ColumnOrderStrict
+
+	// Backfill maps "table.column" to an SQL expression that fills new columns
+	// (and NULLs in columns that become NOT NULL) for existing rows. It takes
+	// precedence over "-- @backfill:" annotations in the schema files.
+	Backfill map[string]string
+
+	// ColumnExpressions maps "table.column" to an SQL expression over the old
+	// row that computes the column when its table is recreated, e.g.
+	// first_name || ' ' || last_name. It takes precedence over "-- @from:"
+	// annotations in the schema files.
+	ColumnExpressions map[string]string
+
+	// TargetVersion is the SQLite version the plan will run on, e.g.
+	// "3.35.5". Features missing from older versions are planned with
+	// recreates instead. Empty means the bundled driver's version, and
+	// CompareWithOptions fills it in from the database.
+	TargetVersion string
+
+	// Modules lists the virtual table modules of the target SQLite. Virtual
+	// tables using other modules are reported, nil skips the check.
+	Modules []string
+
+	// Parse selects how Compare and Apply read the schema files, e.g.
+	// without executing them
+	Parse parser.Options
+
+	// PreserveRowids includes the rowid in the copy of recreated rowid
+	// tables, instead of letting the copy number the rows anew, so external
+	// references to rowids (e.g. FTS content tables) stay valid. Tables
+	// whose new definition has a rowid alias (INTEGER PRIMARY KEY) take the
+	// rowid from that column instead.
+	PreserveRowids bool
+
+	// Incremental makes Compare and CompareMany hash the stored SQL of each
+	// table first (see ObjectHashes) and only read the columns of tables
+	// that differ from the target. The plan is the same; drift checks of
+	// large schemas that mostly match get much cheaper.
+	Incremental bool
+
+	// Partitions are the time-partitioned tables: their partitions are
+	// left out of the comparison, so they are never reported as drift, and
+	// Compare plans creating and dropping them (see PartitionChanges).
+	// CompareWithOptions loads them from the schema directory if nil.
+	Partitions []Partitioning
+
+	// OnEvent receives progress events while diffing and applying, e.g. to
+	// render live progress in a UI (see Event)
+	OnEvent func(Event)
+
+	// OnWarning receives the warnings emitted while diffing. Nil falls back
+	// to the handler set with SetWarningHandler.
+	OnWarning func(Warning)
+}
+
+// backfill returns the backfill expression for a column of the target table
+func (opts DiffOptions) backfill(from, to *schema.Table, col schema.Column) string {
+	if expr, ok := opts.Backfill[to.Name+"."+col.Name]; ok {
+		return expr
+	}
+	if expr := col.Backfill; expr != "" {
+		return expr
+	}
+	return opts.columnExpression(from, to, col)
+}
+
+// columnExpression returns the expression that computes a column of the
+// target table from the old row on recreate. A "-- @from:" annotation stays
+// in the schema, so it only applies while the column is new or changes its
+// type, not again on every later recreate.
+func (opts DiffOptions) columnExpression(from, to *schema.Table, col schema.Column) string {
+	if expr, ok := opts.ColumnExpressions[to.Name+"."+col.Name]; ok {
+		return expr
+	}
+	if old := from.GetColumn(col.Name); old != nil && strings.EqualFold(old.Type, col.Type) {
+		return ""
+	}
+	return col.From
 }
 
 // Diff compares two schemas and returns the changes
 func Diff(from, to *schema.Database) []Change {
+	return DiffWithOptions(from, to, DiffOptions{})
+}
+
+// DiffWithOptions compares two schemas using the given options
+func DiffWithOptions(from, to *schema.Database, opts DiffOptions) []Change {
 	var changes []Change
 
+	from, to = withoutPartitions(from, opts.Partitions), withoutPartitions(to, opts.Partitions)
+	from = withoutQuarantines(from, to)
+
 	// Track tables being recreated - their indexes will be dropped implicitly
 	// and need to be recreated as part of the table recreation
 	recreatedTables := make(map[string]bool)
 
-	tableChanges := diffTables(from, to, recreatedTables)
+	warnUnsupported(to, opts)
+
+	tableChanges := diffTables(from, to, recreatedTables, opts)
 	changes = append(changes, tableChanges...)
 	changes = append(changes, diffIndexes(from, to, recreatedTables)...)
 	changes = append(changes, diffViews(from, to)...)
-	changes = append(changes, diffTriggers(from, to, recreatedTables)...)
+
+	// Triggers must not fire while rows are copied or backfilled, e.g. into
+	// audit tables: those of recreated and backfilled tables are dropped
+	// before and created after (DropTrigger and CreateTrigger sort first
+	// and last)
+	backfilledTables := make(map[string]bool)
+	for _, c := range tableChanges {
+		if c.backfills() {
+			backfilledTables[c.Object] = true
+		}
+	}
+	changes = append(changes, diffTriggers(from, to, recreatedTables, backfilledTables)...)
 
 	sortChanges(changes)
+	setSeverities(changes)
+	markSensitive(from, to, changes)
+	emitPlan(from, to, changes, opts)
 	return changes
 }
 
-func diffTables(from, to *schema.Database, recreatedTables map[string]bool) []Change {
+func diffTables(from, to *schema.Database, recreatedTables map[string]bool, opts DiffOptions) []Change {
 	var changes []Change
 
+	renamedFrom, renamedTo := renamedTable(from, to)
+	if renamedFrom != "" {
+		changes = append(changes, Change{
+			Type:        RenameTable,
+			Object:      renamedTo,
+			Description: fmt.Sprintf("Rename table %q to %q", renamedFrom, renamedTo),
+			SQL:         []string{fmt.Sprintf("ALTER TABLE %q RENAME TO %q;", renamedFrom, renamedTo)},
+			Destructive: false,
+			Reason:      TableRenamed,
+		})
+	}
+
 	// Dropped tables
 	for name := range from.Tables {
-		if _, exists := to.Tables[name]; !exists {
+		if _, exists := to.Tables[name]; !exists && name != renamedFrom {
 			changes = append(changes, Change{
 				Type:        DropTable,
 				Object:      name,
 				Description: fmt.Sprintf("Drop table %q", name),
 				SQL:         []string{fmt.Sprintf("DROP TABLE %q;", name)},
 				Destructive: true,
+				Reason:      ObjectRemoved,
 			})
 		}
 	}
 
 	// New tables
 	for name, table := range to.Tables {
-		if _, exists := from.Tables[name]; !exists {
+		if _, exists := from.Tables[name]; !exists && name != renamedTo {
+			description := fmt.Sprintf("Create table %q", name)
+			if table.AsSelect != "" {
+				// Only the derived column definitions are applied
+				description += " (empty, AS SELECT data is not copied)"
+				opts.warn(name, "defined with CREATE TABLE ... AS SELECT, the table is created empty and must be populated separately")
+			}
 			changes = append(changes, Change{
 				Type:        CreateTable,
 				Object:      name,
-				Description: fmt.Sprintf("Create table %q", name),
+				Description: description,
 				SQL:         []string{ensureSemicolon(table.SQL)},
 				Destructive: false,
+				Reason:      ObjectAdded,
 			})
 		}
 	}
@@ -91,9 +314,9 @@ func diffTables(from, to *schema.Database, recreatedTables map[string]bool) []Ch
 			continue
 		}
 
-		tableChanges := diffTableColumns(fromTable, toTable)
+		tableChanges := diffTableColumns(fromTable, toTable, opts)
 		for _, c := range tableChanges {
-			if c.Type == RecreateTable {
+			if c.RecreatesTable() {
 				recreatedTables[name] = true
 			}
 		}
@@ -103,7 +326,47 @@ func diffTables(from, to *schema.Database, recreatedTables map[string]bool) []Ch
 	return changes
 }
 
-func diffTableColumns(from, to *schema.Table) []Change {
+// renamedTable finds a renamed table the way renamed columns are found:
+// the only dropped and the only new table, with the same definition apart
+// from the name. Both names are empty if there is none.
+func renamedTable(from, to *schema.Database) (oldName, newName string) {
+	var dropped, added []string
+	for name := range from.Tables {
+		if _, exists := to.Tables[name]; !exists {
+			dropped = append(dropped, name)
+		}
+	}
+	for name := range to.Tables {
+		if _, exists := from.Tables[name]; !exists {
+			added = append(added, name)
+		}
+	}
+	if len(dropped) != 1 || len(added) != 1 {
+		return "", ""
+	}
+
+	oldTable, newTable := from.Tables[dropped[0]], to.Tables[added[0]]
+	if isVirtualTableSQL(oldTable.SQL) || isVirtualTableSQL(newTable.SQL) || newTable.AsSelect != "" {
+		return "", ""
+	}
+	if !sqlEquivalent(replaceTableName(oldTable.SQL, newTable.Name), newTable.SQL) {
+		return "", ""
+	}
+	return oldTable.Name, newTable.Name
+}
+
+func diffTableColumns(from, to *schema.Table, opts DiffOptions) []Change {
+	ignoreOrder := opts.ColumnOrder == ColumnOrderIgnore
+
+	// Virtual tables cannot be altered, any change to the declaration
+	// (module or arguments) recreates them
+	if isVirtualTableSQL(from.SQL) || isVirtualTableSQL(to.SQL) {
+		if sqlEquivalent(from.SQL, to.SQL) {
+			return nil
+		}
+		return []Change{recreateTableChange(from.Name, from, to, DefinitionChanged, opts)}
+	}
+
 	var changes []Change
 
 	var droppedCols []schema.Column
@@ -125,8 +388,8 @@ func diffTableColumns(from, to *schema.Table) []Change {
 		oldCol := droppedCols[0]
 		newCol := newCols[0]
 
-		// Ensure column properties match
-		if !columnChanged(oldCol, newCol) {
+		// Ensure column properties match, a mapped column is computed instead
+		if !columnChanged(oldCol, newCol) && opts.columnExpression(from, to, newCol) == "" {
 			fromNorm := normalizeSQL(from.SQL)
 			toNorm := normalizeSQL(to.SQL)
 
@@ -136,10 +399,33 @@ func diffTableColumns(from, to *schema.Table) []Change {
 			re := regexp.MustCompile(`\b` + oldNameLower + `\b`)
 			fromNormRenamed := re.ReplaceAllString(fromNorm, strings.ToLower(newCol.Name))
 
+			if fromNormRenamed == toNorm && !opts.supports(FeatureRenameColumn) {
+				// Older SQLite cannot rename in place, copy the old column
+				// into the new one while recreating the table
+				exprs := maps.Clone(opts.ColumnExpressions)
+				if exprs == nil {
+					exprs = make(map[string]string)
+				}
+				exprs[to.Name+"."+newCol.Name] = fmt.Sprintf("%q", oldCol.Name)
+				opts.ColumnExpressions = exprs
+
+				c := recreateTableChange(from.Name, from, to, ColumnRenamed, opts)
+				c.Type, c.Column = RenameColumn, newCol.Name
+				c.Description = fmt.Sprintf(
+					"Recreate table %q to rename column %q to %q (%s needs SQLite %s)",
+					from.Name,
+					oldCol.Name,
+					newCol.Name,
+					FeatureRenameColumn.Name,
+					FeatureRenameColumn.MinVersion,
+				)
+				return []Change{c}
+			}
 			if fromNormRenamed == toNorm {
 				return []Change{{
 					Type:   RenameColumn,
 					Object: from.Name,
+					Column: newCol.Name,
 					Description: fmt.Sprintf(
 						"Rename column %q to %q on table %q",
 						oldCol.Name,
@@ -155,6 +441,7 @@ func diffTableColumns(from, to *schema.Table) []Change {
 						),
 					},
 					Destructive: false,
+					Reason:      ColumnRenamed,
 				}}
 			}
 		}
@@ -162,13 +449,21 @@ func diffTableColumns(from, to *schema.Table) []Change {
 
 	if len(droppedCols) > 0 {
 		// Column removed (or complex rename) - needs table recreation
-		return []Change{recreateTableChange(from.Name, from, to)}
+		c := recreateTableChange(from.Name, from, to, ColumnDropped, opts)
+		_, fromDefs, _, _ := tableDefinitions(from.SQL)
+		if len(droppedCols) == 1 && len(newCols) == 0 &&
+			onlyColumnsAdded(to, from, []string{columnDefinition(fromDefs, droppedCols[0].Name)}, false) {
+			c.Type, c.Column = DropColumn, droppedCols[0].Name
+			c.Description = fmt.Sprintf("Recreate table %q to drop column %q", from.Name, droppedCols[0].Name)
+		}
+		return []Change{c}
 	}
 
-	// If new columns are not at the end of the target schema,
-	// we need RECREATE_TABLE to preserve column order
-	if len(newCols) > 0 && !newColumnsAtEnd(from, to) {
-		return []Change{recreateTableChange(from.Name, from, to)}
+	// If new columns are not at the end of the target schema, or existing
+	// columns were reordered, the strict policy needs RECREATE_TABLE to
+	// preserve the declared column order
+	if !ignoreOrder && (len(newCols) > 0 && !newColumnsAtEnd(from, to) || columnsReordered(from, to)) {
+		return []Change{recreateTableChange(from.Name, from, to, ColumnOrderChanged, opts)}
 	}
 
 	// Check for modified columns (requires table recreation)
@@ -178,27 +473,82 @@ func diffTableColumns(from, to *schema.Table) []Change {
 			continue // new column, handled above
 		}
 
-		if columnChanged(*fromCol, toCol) {
+		if reason := columnChangeReason(*fromCol, toCol); reason != "" {
 			// Column modified - needs table recreation
-			return []Change{recreateTableChange(from.Name, from, to)}
+			c := recreateTableChange(from.Name, from, to, reason, opts)
+			if reason == DefaultChanged && len(newCols) == 0 && onlyDefinitionChanged(from, to, toCol.Name) {
+				c.Type, c.Column = AlterColumnDefault, toCol.Name
+				c.Description = fmt.Sprintf("Recreate table %q to change the default of column %q", from.Name, toCol.Name)
+			}
+			return []Change{c}
 		}
 	}
 
 	// If we're only adding columns, check if the table SQL has other changes
 	// (e.g., UNIQUE, CHECK, FOREIGN KEY constraints that PRAGMA table_info doesn't expose)
-	definitionChanged := normalizeSQL(from.SQL) != normalizeSQL(to.SQL)
-	if len(newCols) == 0 && definitionChanged {
-		return []Change{recreateTableChange(from.Name, from, to)}
+	fromNorm, toNorm := normalizeSQL(from.SQL), normalizeSQL(to.SQL)
+	if len(newCols) == 0 && fromNorm != toNorm {
+		if ignoreOrder && sameDefinitionsInAnyOrder(fromNorm, toNorm) {
+			return nil
+		}
+		reason := constraintReason(fromNorm, toNorm)
+		// Guard against normalization false positives before planning a
+		// recreate. Offline parsing must not execute SQL, so it skips this.
+		if reason == RawSQLMismatch && !opts.Parse.Offline {
+			if same, err := structurallyEqual(from, to); err == nil && same {
+				opts.warn(from.Name, "definition text differs but structure is identical, skipping recreate")
+				return nil
+			}
+		}
+		return []Change{recreateTableChange(from.Name, from, to, reason, opts)}
+	}
+
+	// ALTER TABLE cannot add every column definition, such columns are added
+	// (and computed from backfills) while copying into a recreated table
+	_, toDefs, _, _ := tableDefinitions(to.SQL)
+	newDefs := make([]string, len(newCols))
+	for i, col := range newCols {
+		newDefs[i] = columnDefinition(toDefs, col.Name)
+		restriction := addColumnRestriction(col, newDefs[i])
+		if restriction == "" && !opts.supports(FeatureAddColumnCheck) &&
+			checkReferencesOtherColumns(to, col, newDefs[i]) {
+			restriction = fmt.Sprintf("a CHECK on other columns before SQLite %s", FeatureAddColumnCheck.MinVersion)
+		}
+		if restriction != "" {
+			c := recreateTableChange(from.Name, from, to, AddColumnUnsupported, opts)
+			c.Description = fmt.Sprintf(
+				"Recreate table %q to add column %q (ADD COLUMN does not allow %s)",
+				from.Name,
+				col.Name,
+				restriction,
+			)
+			return []Change{c}
+		}
+	}
+
+	// Constraints declared along with new columns cannot be added by ALTER TABLE
+	if !onlyColumnsAdded(from, to, newDefs, ignoreOrder) {
+		return []Change{
+			recreateTableChange(from.Name, from, to, constraintReason(fromNorm, toNorm), opts),
+		}
 	}
 
 	// Add new columns via ALTER TABLE
-	for _, col := range newCols {
+	for i, col := range newCols {
+		description := fmt.Sprintf("Add column %q to table %q", col.Name, from.Name)
+		stmts := []string{addColumnSQL(from.Name, col, newDefs[i])}
+		if expr := opts.backfill(from, to, col); expr != "" {
+			description += fmt.Sprintf(" (backfilled with %s)", expr)
+			stmts = append(stmts, fmt.Sprintf("UPDATE %q SET %q = %s;", from.Name, col.Name, expr))
+		}
 		changes = append(changes, Change{
 			Type:        AddColumn,
 			Object:      from.Name,
-			Description: fmt.Sprintf("Add column %q to table %q", col.Name, from.Name),
-			SQL:         []string{generateAddColumnSQL(from.Name, col)},
+			Column:      col.Name,
+			Description: description,
+			SQL:         stmts,
 			Destructive: false,
+			Reason:      ColumnAdded,
 		})
 	}
 
@@ -206,23 +556,29 @@ func diffTableColumns(from, to *schema.Table) []Change {
 }
 
 func columnChanged(from, to schema.Column) bool {
+	return columnChangeReason(from, to) != ""
+}
+
+// columnChangeReason returns the first property that differs between two
+// columns, or an empty Reason if they are equivalent
+func columnChangeReason(from, to schema.Column) Reason {
 	// Compare type (case-insensitive)
 	if !strings.EqualFold(from.Type, to.Type) {
-		return true
+		return ColumnTypeChanged
 	}
 
 	if from.Hidden != to.Hidden {
-		return true
+		return GeneratedChanged
 	}
 
 	// Compare NOT NULL
 	if from.NotNull != to.NotNull {
-		return true
+		return NullabilityChanged
 	}
 
 	// Compare PRIMARY KEY
 	if (from.PrimaryKey > 0) != (to.PrimaryKey > 0) {
-		return true
+		return PrimaryKeyChanged
 	}
 
 	// Compare default values
@@ -235,10 +591,28 @@ func columnChanged(from, to schema.Column) bool {
 		toDefault = strings.ToLower(strings.TrimSpace(*to.Default))
 	}
 	if fromDefault != toDefault {
-		return true
+		return DefaultChanged
 	}
 
-	return false
+	return ""
+}
+
+// constraintKeywordRe matches keywords that introduce table or column constraints
+var constraintKeywordRe = regexp.MustCompile(`\b(check|unique|references|foreign key|collate)\b`)
+
+// constraintReason classifies a CREATE TABLE mismatch that is not visible in the
+// column info by comparing how many constraints each definition declares
+func constraintReason(fromNorm, toNorm string) Reason {
+	fromCount := len(constraintKeywordRe.FindAllString(fromNorm, -1))
+	toCount := len(constraintKeywordRe.FindAllString(toNorm, -1))
+	switch {
+	case toCount > fromCount:
+		return ConstraintAdded
+	case toCount < fromCount:
+		return ConstraintRemoved
+	default:
+		return RawSQLMismatch
+	}
 }
 
 // newColumnsAtEnd checks if all new columns appear at the end of the target schema.
@@ -257,6 +631,85 @@ func newColumnsAtEnd(from, to *schema.Table) bool {
 	return true
 }
 
+// sameDefinitionsInAnyOrder reports whether two normalized CREATE TABLE
+// statements contain the same column and constraint definitions, ignoring
+// their order
+func sameDefinitionsInAnyOrder(fromNorm, toNorm string) bool {
+	fromHead, fromDefs, fromTail, ok := splitTableDefinitions(fromNorm)
+	if !ok {
+		return false
+	}
+	toHead, toDefs, toTail, ok := splitTableDefinitions(toNorm)
+	if !ok {
+		return false
+	}
+	slices.Sort(fromDefs)
+	slices.Sort(toDefs)
+	return fromHead == toHead && fromTail == toTail && slices.Equal(fromDefs, toDefs)
+}
+
+// splitTableDefinitions splits a normalized CREATE TABLE statement into the
+// part before the definition list, the top-level definitions, and the table
+// options after it
+func splitTableDefinitions(norm string) (head string, defs []string, tail string, ok bool) {
+	open := strings.Index(norm, "(")
+	if open < 0 {
+		return "", nil, "", false
+	}
+
+	depth, start := 0, open+1
+	for i := open; i < len(norm); i++ {
+		switch norm[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				defs = append(defs, strings.TrimSpace(norm[start:i]))
+				return norm[:open], defs, norm[i+1:], true
+			}
+		case ',':
+			if depth == 1 {
+				defs = append(defs, strings.TrimSpace(norm[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return "", nil, "", false
+}
+
+// columnsReordered checks if the columns both tables have appear in a
+// different relative order
+func columnsReordered(from, to *schema.Table) bool {
+	var fromOrder, toOrder []string
+	for _, col := range from.Columns {
+		if to.HasColumn(col.Name) {
+			fromOrder = append(fromOrder, col.Name)
+		}
+	}
+	for _, col := range to.Columns {
+		if from.HasColumn(col.Name) {
+			toOrder = append(toOrder, col.Name)
+		}
+	}
+	return !slices.Equal(fromOrder, toOrder)
+}
+
+// columnOrderComment documents how columns are mapped when a table is
+// recreated only to match the declared column order
+func columnOrderComment(from, to *schema.Table) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "-- Reorder columns to the declared order (column order policy: %s):", ColumnOrderStrict)
+	for i, col := range to.Columns {
+		was := "new"
+		if pos := slices.Index(from.ColumnNames(), col.Name); pos >= 0 {
+			was = fmt.Sprintf("was %d", pos+1)
+		}
+		fmt.Fprintf(&sb, "\n--   %d. %q (%s)", i+1, col.Name, was)
+	}
+	return sb.String()
+}
+
 func generateAddColumnSQL(tableName string, col schema.Column) string {
 	var sb strings.Builder
 	fmt.Fprintf(&sb, "ALTER TABLE %q ADD COLUMN %q", tableName, col.Name)
@@ -296,17 +749,38 @@ func defaultForType(colType string) string {
 	}
 }
 
-func recreateTableChange(name string, from, to *schema.Table) Change {
+func recreateTableChange(name string, from, to *schema.Table, reason Reason, opts DiffOptions) Change {
+	description := fmt.Sprintf("Recreate table %q (schema changed)", name)
+	stmts := generateRecreateSQL(name, from, to, opts)
+
+	// Make the column order policy visible instead of an implicit side effect
+	if reason == ColumnOrderChanged {
+		description = fmt.Sprintf(
+			"Recreate table %q to reorder columns (column order policy: %s)",
+			name,
+			ColumnOrderStrict,
+		)
+		stmts = append([]string{columnOrderComment(from, to)}, stmts...)
+	}
+
+	// Changing the rowid alias keeps the data but not the rowids, say so
+	if note := rowidAliasChange(from, to, opts); note != "" {
+		opts.warn(name, "%s", note)
+		stmts = append([]string{"-- Rowid alias changed: " + note}, stmts...)
+	}
+
 	return Change{
 		Type:        RecreateTable,
 		Object:      name,
-		Description: fmt.Sprintf("Recreate table %q (schema changed)", name),
-		SQL:         generateRecreateSQL(name, from, to),
+		Description: description,
+		SQL:         stmts,
 		Destructive: true,
+		Reason:      reason,
+		CopyKey:     copyKey(from, to, opts),
 	}
 }
 
-func generateRecreateSQL(name string, from, to *schema.Table) []string {
+func generateRecreateSQL(name string, from, to *schema.Table, opts DiffOptions) []string {
 	tempName := name + "__new"
 
 	// Find common columns for data migration
@@ -315,16 +789,24 @@ func generateRecreateSQL(name string, from, to *schema.Table) []string {
 	// Create SELECT expressions, using COALESCE for columns that became NOT NULL
 	var selectExprs []string
 	var insertCols []string
+	if rowid := preservedRowid(from, to, opts); rowid != "" {
+		insertCols = append(insertCols, rowid)
+		selectExprs = append(selectExprs, rowid)
+	}
 	for _, colName := range common {
 		fromCol := from.GetColumn(colName)
 		toCol := to.GetColumn(colName)
 
 		insertCols = append(insertCols, fmt.Sprintf("%q", colName))
 
-		if fromCol != nil && toCol != nil && !fromCol.NotNull && toCol.NotNull {
+		if expr := opts.columnExpression(from, to, *toCol); expr != "" {
+			selectExprs = append(selectExprs, expr)
+		} else if fromCol != nil && toCol != nil && !fromCol.NotNull && toCol.NotNull {
 			// Column became NOT NULL, provide a default value to prevent constraint failure
 			defValue := defaultForType(toCol.Type)
-			if toCol.Default != nil {
+			if expr := opts.backfill(from, to, *toCol); expr != "" {
+				defValue = expr
+			} else if toCol.Default != nil {
 				defValue = *toCol.Default
 			}
 			selectExprs = append(selectExprs, fmt.Sprintf("COALESCE(%q, %s)", colName, defValue))
@@ -333,6 +815,29 @@ func generateRecreateSQL(name string, from, to *schema.Table) []string {
 		}
 	}
 
+	// Fill new columns from their mapped or backfill expressions, evaluated
+	// against the old table
+	for _, col := range to.Columns {
+		if from.HasColumn(col.Name) {
+			continue
+		}
+		if col.Hidden != 0 {
+			continue // Generated or hidden, not insertable
+		}
+		expr := opts.columnExpression(from, to, col)
+		if expr == "" {
+			expr = opts.backfill(from, to, col)
+		}
+		if expr == "" && col.NotNull && col.Default == nil {
+			// Existing rows need a value, like columns that become NOT NULL
+			expr = defaultForType(col.Type)
+		}
+		if expr != "" {
+			insertCols = append(insertCols, fmt.Sprintf("%q", col.Name))
+			selectExprs = append(selectExprs, expr)
+		}
+	}
+
 	cols := strings.Join(insertCols, ", ")
 	selects := strings.Join(selectExprs, ", ")
 
@@ -342,7 +847,7 @@ func generateRecreateSQL(name string, from, to *schema.Table) []string {
 		ensureSemicolon(createSQL),
 	}
 
-	if len(common) > 0 {
+	if len(insertCols) > 0 {
 		stmts = append(
 			stmts,
 			fmt.Sprintf("INSERT INTO %q (%s) SELECT %s FROM %q;", tempName, cols, selects, name),
@@ -365,7 +870,8 @@ func commonColumns(from, to *schema.Table) []string {
 
 	var common []string
 	for _, c := range to.Columns {
-		if fromCols[c.Name] {
+		// Generated and hidden columns cannot be inserted into
+		if fromCols[c.Name] && c.Hidden == 0 {
 			common = append(common, c.Name)
 		}
 	}
@@ -373,7 +879,7 @@ func commonColumns(from, to *schema.Table) []string {
 }
 
 var tableNameRe = regexp.MustCompile(
-	`(?i)(CREATE\s+TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?)\s*(?:"(?:[^"]|"")*"|'(?:[^']|'')*'|\x60(?:[^\x60]|\x60\x60)*\x60|\[[^\]]*\]|[a-zA-Z0-9_]+)`,
+	`(?i)(CREATE\s+(?:VIRTUAL\s+)?TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?)\s*(?:"(?:[^"]|"")*"|'(?:[^']|'')*'|\x60(?:[^\x60]|\x60\x60)*\x60|\[[^\]]*\]|[a-zA-Z0-9_]+)`,
 )
 
 func replaceTableName(sql, newName string) string {
@@ -392,9 +898,11 @@ func diffIndexes(from, to *schema.Database, recreatedTables map[string]bool) []C
 			changes = append(changes, Change{
 				Type:        DropIndex,
 				Object:      name,
+				Table:       idx.Table,
 				Description: fmt.Sprintf("Drop index %q", name),
 				SQL:         []string{fmt.Sprintf("DROP INDEX IF EXISTS %q;", name)},
 				Destructive: false,
+				Reason:      ObjectRemoved,
 			})
 		}
 	}
@@ -408,9 +916,11 @@ func diffIndexes(from, to *schema.Database, recreatedTables map[string]bool) []C
 			changes = append(changes, Change{
 				Type:        CreateIndex,
 				Object:      name,
+				Table:       toIdx.Table,
 				Description: fmt.Sprintf("Create index %q", name),
 				SQL:         []string{ensureSemicolon(toIdx.SQL)},
 				Destructive: false,
+				Reason:      DependencyRecreated,
 			})
 			continue
 		}
@@ -419,25 +929,31 @@ func diffIndexes(from, to *schema.Database, recreatedTables map[string]bool) []C
 			changes = append(changes, Change{
 				Type:        CreateIndex,
 				Object:      name,
+				Table:       toIdx.Table,
 				Description: fmt.Sprintf("Create index %q", name),
 				SQL:         []string{ensureSemicolon(toIdx.SQL)},
 				Destructive: false,
+				Reason:      ObjectAdded,
 			})
-		} else if normalizeSQL(fromIdx.SQL) != normalizeSQL(toIdx.SQL) {
+		} else if !sqlEquivalent(fromIdx.SQL, toIdx.SQL) {
 			// Index changed - drop and recreate
 			changes = append(changes, Change{
 				Type:        DropIndex,
 				Object:      name,
+				Table:       fromIdx.Table,
 				Description: fmt.Sprintf("Drop index %q (will recreate)", name),
 				SQL:         []string{fmt.Sprintf("DROP INDEX IF EXISTS %q;", name)},
 				Destructive: false,
+				Reason:      DefinitionChanged,
 			})
 			changes = append(changes, Change{
 				Type:        CreateIndex,
 				Object:      name,
+				Table:       toIdx.Table,
 				Description: fmt.Sprintf("Create index %q", name),
 				SQL:         []string{ensureSemicolon(toIdx.SQL)},
 				Destructive: false,
+				Reason:      ObjectAdded,
 			})
 		}
 	}
@@ -456,6 +972,7 @@ func diffViews(from, to *schema.Database) []Change {
 				Description: fmt.Sprintf("Drop view %q", name),
 				SQL:         []string{fmt.Sprintf("DROP VIEW IF EXISTS %q;", name)},
 				Destructive: false,
+				Reason:      ObjectRemoved,
 			})
 		}
 	}
@@ -469,14 +986,16 @@ func diffViews(from, to *schema.Database) []Change {
 				Description: fmt.Sprintf("Create view %q", name),
 				SQL:         []string{ensureSemicolon(toView.SQL)},
 				Destructive: false,
+				Reason:      ObjectAdded,
 			})
-		} else if normalizeSQL(fromView.SQL) != normalizeSQL(toView.SQL) {
+		} else if !sqlEquivalent(fromView.SQL, toView.SQL) {
 			changes = append(changes, Change{
 				Type:        DropView,
 				Object:      name,
 				Description: fmt.Sprintf("Drop view %q (will recreate)", name),
 				SQL:         []string{fmt.Sprintf("DROP VIEW IF EXISTS %q;", name)},
 				Destructive: false,
+				Reason:      DefinitionChanged,
 			})
 			changes = append(changes, Change{
 				Type:        CreateView,
@@ -484,6 +1003,7 @@ func diffViews(from, to *schema.Database) []Change {
 				Description: fmt.Sprintf("Create view %q", name),
 				SQL:         []string{ensureSemicolon(toView.SQL)},
 				Destructive: false,
+				Reason:      ObjectAdded,
 			})
 		}
 	}
@@ -491,28 +1011,45 @@ func diffViews(from, to *schema.Database) []Change {
 	return changes
 }
 
-func diffTriggers(from, to *schema.Database, recreatedTables map[string]bool) []Change {
+func diffTriggers(from, to *schema.Database, recreatedTables, backfilledTables map[string]bool) []Change {
 	var changes []Change
 
 	// Dropped triggers (explicitly drop before table recreation to prevent SQLite errors)
 	for name, trig := range from.Triggers {
+		_, exists := to.Triggers[name]
 		if recreatedTables[trig.Table] {
 			changes = append(changes, Change{
 				Type:        DropTrigger,
 				Object:      name,
+				Table:       trig.Table,
 				Description: fmt.Sprintf("Drop trigger %q (will recreate)", name),
 				SQL:         []string{fmt.Sprintf("DROP TRIGGER IF EXISTS %q;", name)},
 				Destructive: false,
+				Reason:      DependencyRecreated,
 			})
 			continue
 		}
-		if _, exists := to.Triggers[name]; !exists {
+		if exists && backfilledTables[trig.Table] {
 			changes = append(changes, Change{
 				Type:        DropTrigger,
 				Object:      name,
+				Table:       trig.Table,
+				Description: fmt.Sprintf("Drop trigger %q (will recreate after the backfill)", name),
+				SQL:         []string{fmt.Sprintf("DROP TRIGGER IF EXISTS %q;", name)},
+				Destructive: false,
+				Reason:      RowsBackfilled,
+			})
+			continue
+		}
+		if !exists {
+			changes = append(changes, Change{
+				Type:        DropTrigger,
+				Object:      name,
+				Table:       trig.Table,
 				Description: fmt.Sprintf("Drop trigger %q", name),
 				SQL:         []string{fmt.Sprintf("DROP TRIGGER IF EXISTS %q;", name)},
 				Destructive: false,
+				Reason:      ObjectRemoved,
 			})
 		}
 	}
@@ -525,9 +1062,23 @@ func diffTriggers(from, to *schema.Database, recreatedTables map[string]bool) []
 			changes = append(changes, Change{
 				Type:        CreateTrigger,
 				Object:      name,
+				Table:       toTrig.Table,
+				Description: fmt.Sprintf("Create trigger %q", name),
+				SQL:         []string{ensureSemicolon(toTrig.SQL)},
+				Destructive: false,
+				Reason:      DependencyRecreated,
+			})
+			continue
+		}
+		if exists && backfilledTables[toTrig.Table] {
+			changes = append(changes, Change{
+				Type:        CreateTrigger,
+				Object:      name,
+				Table:       toTrig.Table,
 				Description: fmt.Sprintf("Create trigger %q", name),
 				SQL:         []string{ensureSemicolon(toTrig.SQL)},
 				Destructive: false,
+				Reason:      RowsBackfilled,
 			})
 			continue
 		}
@@ -536,24 +1087,30 @@ func diffTriggers(from, to *schema.Database, recreatedTables map[string]bool) []
 			changes = append(changes, Change{
 				Type:        CreateTrigger,
 				Object:      name,
+				Table:       toTrig.Table,
 				Description: fmt.Sprintf("Create trigger %q", name),
 				SQL:         []string{ensureSemicolon(toTrig.SQL)},
 				Destructive: false,
+				Reason:      ObjectAdded,
 			})
-		} else if normalizeSQL(fromTrig.SQL) != normalizeSQL(toTrig.SQL) {
+		} else if !sqlEquivalent(fromTrig.SQL, toTrig.SQL) {
 			changes = append(changes, Change{
 				Type:        DropTrigger,
 				Object:      name,
+				Table:       fromTrig.Table,
 				Description: fmt.Sprintf("Drop trigger %q (will recreate)", name),
 				SQL:         []string{fmt.Sprintf("DROP TRIGGER IF EXISTS %q;", name)},
 				Destructive: false,
+				Reason:      DefinitionChanged,
 			})
 			changes = append(changes, Change{
 				Type:        CreateTrigger,
 				Object:      name,
+				Table:       toTrig.Table,
 				Description: fmt.Sprintf("Create trigger %q", name),
 				SQL:         []string{ensureSemicolon(toTrig.SQL)},
 				Destructive: false,
+				Reason:      ObjectAdded,
 			})
 		}
 	}
@@ -569,24 +1126,14 @@ func ensureSemicolon(sql string) string {
 	return sql
 }
 
+// changePriority is the order in which change types are applied, see
+// ChangeTypeInfo.Priority
+var changePriority = changePriorities()
+
 // sortChanges orders changes for safe execution
 func sortChanges(changes []Change) {
-	priority := map[ChangeType]int{
-		DropTrigger:   1,
-		DropView:      2,
-		DropIndex:     3,
-		DropTable:     4,
-		RecreateTable: 5,
-		CreateTable:   6,
-		RenameColumn:  7,
-		AddColumn:     8,
-		CreateIndex:   9,
-		CreateView:    10,
-		CreateTrigger: 11,
-	}
-
 	slices.SortStableFunc(changes, func(a, b Change) int {
-		pa, pb := priority[a.Type], priority[b.Type]
+		pa, pb := changePriority[a.Type], changePriority[b.Type]
 		if pa != pb {
 			return cmp.Compare(pa, pb)
 		}
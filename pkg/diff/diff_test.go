@@ -1,8 +1,11 @@
 package diff
 
 import (
+	"slices"
+	"strings"
 	"testing"
 
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
 	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
 )
 
@@ -80,7 +83,7 @@ func TestDiff(t *testing.T) {
 					Columns: []schema.Column{{Name: "id", Type: "INTEGER", PrimaryKey: 1}},
 				},
 			}},
-			wantChangeTypes: []ChangeType{RecreateTable},
+			wantChangeTypes: []ChangeType{DropColumn},
 			wantDestructive: true,
 		},
 		{
@@ -518,7 +521,7 @@ func TestRecreatedTableCascades(t *testing.T) {
 					},
 				},
 			},
-			wantChangeTypes: []ChangeType{RecreateTable, CreateIndex},
+			wantChangeTypes: []ChangeType{DropColumn, CreateIndex},
 			wantObjects:     []string{"users", "idx_users_name"},
 		},
 		{
@@ -562,7 +565,7 @@ func TestRecreatedTableCascades(t *testing.T) {
 					},
 				},
 			},
-			wantChangeTypes: []ChangeType{DropTrigger, RecreateTable, CreateTrigger},
+			wantChangeTypes: []ChangeType{DropTrigger, DropColumn, CreateTrigger},
 			wantObjects:     []string{"trg_users_audit", "users", "trg_users_audit"},
 		},
 		{
@@ -601,7 +604,7 @@ func TestRecreatedTableCascades(t *testing.T) {
 				Triggers: map[string]*schema.Trigger{}, // trigger removed
 			},
 			// Explicitly drops trigger to prevent errors during recreation
-			wantChangeTypes: []ChangeType{DropTrigger, RecreateTable},
+			wantChangeTypes: []ChangeType{DropTrigger, DropColumn},
 			wantObjects:     []string{"trg_old", "users"},
 		},
 		{
@@ -659,7 +662,7 @@ func TestRecreatedTableCascades(t *testing.T) {
 					},
 				},
 			},
-			wantChangeTypes: []ChangeType{DropTrigger, RecreateTable, CreateIndex, CreateTrigger},
+			wantChangeTypes: []ChangeType{DropTrigger, DropColumn, CreateIndex, CreateTrigger},
 			wantObjects:     []string{"trg_posts_ts", "posts", "idx_posts_title", "trg_posts_ts"},
 		},
 	}
@@ -696,6 +699,125 @@ func TestRecreatedTableCascades(t *testing.T) {
 	}
 }
 
+func TestDiffReasons(t *testing.T) {
+	users := func(sql string, cols ...schema.Column) *schema.Database {
+		db := &schema.Database{Tables: map[string]*schema.Table{
+			"users": {Name: "users", SQL: sql, Columns: cols},
+		}}
+		initMaps(db)
+		return db
+	}
+	id := schema.Column{Name: "id", Type: "INTEGER", PrimaryKey: 1}
+
+	tests := []struct {
+		name       string
+		from       *schema.Database
+		to         *schema.Database
+		wantReason Reason
+	}{
+		{
+			name: "column dropped",
+			from: users(
+				"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+				id,
+				schema.Column{Name: "name", Type: "TEXT"},
+			),
+			to:         users("CREATE TABLE users (id INTEGER PRIMARY KEY)", id),
+			wantReason: ColumnDropped,
+		},
+		{
+			name: "existing columns reordered",
+			from: users(
+				"CREATE TABLE users (id INTEGER PRIMARY KEY, a TEXT, b TEXT)",
+				id,
+				schema.Column{Name: "a", Type: "TEXT"},
+				schema.Column{Name: "b", Type: "TEXT"},
+			),
+			to: users(
+				"CREATE TABLE users (id INTEGER PRIMARY KEY, b TEXT, a TEXT)",
+				id,
+				schema.Column{Name: "b", Type: "TEXT"},
+				schema.Column{Name: "a", Type: "TEXT"},
+			),
+			wantReason: ColumnOrderChanged,
+		},
+		{
+			name: "column type changed",
+			from: users(
+				"CREATE TABLE users (id INTEGER PRIMARY KEY, age TEXT)",
+				id,
+				schema.Column{Name: "age", Type: "TEXT"},
+			),
+			to: users(
+				"CREATE TABLE users (id INTEGER PRIMARY KEY, age INTEGER)",
+				id,
+				schema.Column{Name: "age", Type: "INTEGER"},
+			),
+			wantReason: ColumnTypeChanged,
+		},
+		{
+			name: "default changed",
+			from: users(
+				"CREATE TABLE users (id INTEGER PRIMARY KEY, age INTEGER DEFAULT 0)",
+				id,
+				schema.Column{Name: "age", Type: "INTEGER", Default: new("0")},
+			),
+			to: users(
+				"CREATE TABLE users (id INTEGER PRIMARY KEY, age INTEGER DEFAULT 1)",
+				id,
+				schema.Column{Name: "age", Type: "INTEGER", Default: new("1")},
+			),
+			wantReason: DefaultChanged,
+		},
+		{
+			name: "constraint added",
+			from: users(
+				"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)",
+				id,
+				schema.Column{Name: "email", Type: "TEXT"},
+			),
+			to: users(
+				"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT UNIQUE)",
+				id,
+				schema.Column{Name: "email", Type: "TEXT"},
+			),
+			wantReason: ConstraintAdded,
+		},
+		{
+			name:       "raw sql mismatch",
+			from:       users("CREATE TABLE users (id INTEGER PRIMARY KEY)", id),
+			to:         users("CREATE TABLE users (id INTEGER PRIMARY KEY) STRICT", id),
+			wantReason: RawSQLMismatch,
+		},
+		{
+			name: "column renamed",
+			from: users(
+				"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+				id,
+				schema.Column{Name: "name", Type: "TEXT"},
+			),
+			to: users(
+				"CREATE TABLE users (id INTEGER PRIMARY KEY, full_name TEXT)",
+				id,
+				schema.Column{Name: "full_name", Type: "TEXT"},
+			),
+			wantReason: ColumnRenamed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := Diff(tt.from, tt.to)
+			if len(changes) != 1 {
+				t.Fatalf("expected 1 change, got %d: %+v", len(changes), changes)
+			}
+			if changes[0].Reason != tt.wantReason {
+				t.Errorf("Reason = %v, want %v", changes[0].Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
 func TestGenerateAddColumnSQL(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -951,3 +1073,450 @@ func initMaps(db *schema.Database) {
 		db.Triggers = make(map[string]*schema.Trigger)
 	}
 }
+
+func TestDiff_CreateTableAsSelect(t *testing.T) {
+	var warnings []Warning
+	SetWarningHandler(func(w Warning) { warnings = append(warnings, w) })
+	defer SetWarningHandler(nil)
+
+	from := &schema.Database{}
+	to := &schema.Database{Tables: map[string]*schema.Table{
+		"totals": {
+			Name:     "totals",
+			SQL:      "CREATE TABLE totals(\n  customer TEXT,\n  total\n)",
+			AsSelect: "SELECT customer, sum(amount) AS total FROM orders GROUP BY customer",
+		},
+	}}
+	initMaps(from)
+	initMaps(to)
+
+	changes := Diff(from, to)
+	if len(changes) != 1 || changes[0].Type != CreateTable {
+		t.Fatalf("expected one CREATE_TABLE change, got %+v", changes)
+	}
+	if !strings.Contains(changes[0].Description, "empty") {
+		t.Errorf("description should flag the empty table, got %q", changes[0].Description)
+	}
+	if sql := strings.Join(changes[0].SQL, "\n"); strings.Contains(sql, "SELECT") {
+		t.Errorf("SQL should only create the derived columns, got %s", sql)
+	}
+	if len(warnings) != 1 || warnings[0].Object != "totals" {
+		t.Errorf("expected one warning for table totals, got %v", warnings)
+	}
+}
+
+func TestDiff_IgnoresIfNotExistsAndQualifiers(t *testing.T) {
+	from := &schema.Database{
+		Tables: map[string]*schema.Table{
+			"users": {Name: "users", SQL: "CREATE TABLE users (id INTEGER PRIMARY KEY)"},
+		},
+		Views: map[string]*schema.View{
+			"v": {Name: "v", SQL: "CREATE VIEW v AS SELECT id FROM users"},
+		},
+	}
+	to := &schema.Database{
+		Tables: map[string]*schema.Table{
+			"users": {Name: "users", SQL: `CREATE TABLE IF NOT EXISTS main."users" (id INTEGER PRIMARY KEY)`},
+		},
+		Views: map[string]*schema.View{
+			"v": {Name: "v", SQL: `CREATE VIEW IF NOT EXISTS "main".v AS SELECT id FROM main.users`},
+			"w": {Name: "w", SQL: `CREATE VIEW IF NOT EXISTS main.w AS SELECT id FROM main.users`},
+		},
+	}
+	initMaps(from)
+	initMaps(to)
+
+	changes := Diff(from, to)
+	if len(changes) != 1 || changes[0].Object != "w" {
+		t.Fatalf("expected only view w to be created, got %+v", changes)
+	}
+	// Generated SQL keeps the target definition verbatim
+	if want := to.Views["w"].SQL + ";"; changes[0].SQL[0] != want {
+		t.Errorf("SQL = %q, want %q", changes[0].SQL[0], want)
+	}
+}
+
+func TestSameDefinitionsInAnyOrder(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{"create table t(a int, b text)", "create table t(b text, a int)", true},
+		{"create table t(a int, b text, unique(a, b))", "create table t(unique(a, b), b text, a int)", true},
+		{"create table t(a int, b text) strict", "create table t(b text, a int)", false},
+		{"create table t(a int, b text)", "create table t(a int, b int)", false},
+		{"create table t(a int, b text)", "create table u(b text, a int)", false},
+		{"create table t(a int check(a in(1, 2)))", "create table t(a int check(a in(2, 1)))", false},
+	}
+	for _, tt := range tests {
+		if got := sameDefinitionsInAnyOrder(tt.from, tt.to); got != tt.want {
+			t.Errorf("sameDefinitionsInAnyOrder(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestDiff_StrictColumnOrder(t *testing.T) {
+	from := &schema.Database{Tables: map[string]*schema.Table{
+		"users": {
+			Name: "users",
+			SQL:  "CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)",
+			Columns: []schema.Column{
+				{Name: "id", Type: "INTEGER", PrimaryKey: 1},
+				{Name: "email", Type: "TEXT"},
+			},
+		},
+	}}
+	to := &schema.Database{Tables: map[string]*schema.Table{
+		"users": {
+			Name: "users",
+			SQL:  "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT)",
+			Columns: []schema.Column{
+				{Name: "id", Type: "INTEGER", PrimaryKey: 1},
+				{Name: "name", Type: "TEXT"},
+				{Name: "email", Type: "TEXT"},
+			},
+		},
+	}}
+	initMaps(from)
+	initMaps(to)
+
+	changes := DiffWithOptions(from, to, DiffOptions{ColumnOrder: ColumnOrderStrict})
+	if len(changes) != 1 || changes[0].Reason != ColumnOrderChanged {
+		t.Fatalf("expected one column order recreate, got %+v", changes)
+	}
+
+	c := changes[0]
+	if !strings.Contains(c.Description, "column order policy: strict") {
+		t.Errorf("description should name the policy, got %q", c.Description)
+	}
+
+	wantComment := `-- Reorder columns to the declared order (column order policy: strict):
+--   1. "id" (was 1)
+--   2. "name" (new)
+--   3. "email" (was 2)`
+	if c.SQL[0] != wantComment {
+		t.Errorf("column mapping comment = %q, want %q", c.SQL[0], wantComment)
+	}
+
+	wantInsert := `INSERT INTO "users__new" ("id", "email") SELECT "id", "email" FROM "users";`
+	if !slices.Contains(c.SQL, wantInsert) {
+		t.Errorf("expected explicit column mapping %q in %v", wantInsert, c.SQL)
+	}
+}
+
+func TestDiff_ColumnExpressions(t *testing.T) {
+	from := &schema.Database{Tables: map[string]*schema.Table{
+		"users": {
+			Name: "users",
+			SQL:  "CREATE TABLE users (id INTEGER PRIMARY KEY, first_name TEXT, last_name TEXT, age TEXT)",
+			Columns: []schema.Column{
+				{Name: "id", Type: "INTEGER", PrimaryKey: 1},
+				{Name: "first_name", Type: "TEXT"},
+				{Name: "last_name", Type: "TEXT"},
+				{Name: "age", Type: "TEXT"},
+			},
+		},
+	}}
+	to := &schema.Database{Tables: map[string]*schema.Table{
+		"users": {
+			Name: "users",
+			SQL:  "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL, age INTEGER)",
+			Columns: []schema.Column{
+				{Name: "id", Type: "INTEGER", PrimaryKey: 1},
+				{Name: "name", Type: "TEXT", NotNull: true, From: "first_name || ' ' || last_name"},
+				{Name: "age", Type: "INTEGER"},
+			},
+		},
+	}}
+	initMaps(from)
+	initMaps(to)
+
+	changes := DiffWithOptions(from, to, DiffOptions{ColumnExpressions: map[string]string{
+		"users.age": "CAST(age AS INTEGER)",
+	}})
+	if len(changes) != 1 || changes[0].Type != RecreateTable {
+		t.Fatalf("expected one RECREATE_TABLE, got %+v", changes)
+	}
+	want := `INSERT INTO "users__new" ("id", "age", "name") ` +
+		`SELECT "id", CAST(age AS INTEGER), first_name || ' ' || last_name FROM "users";`
+	if !slices.Contains(changes[0].SQL, want) {
+		t.Errorf("SQL = %v, want it to contain %s", changes[0].SQL, want)
+	}
+}
+
+func TestDiff_ColumnExpressionPreventsRename(t *testing.T) {
+	from := &schema.Database{Tables: map[string]*schema.Table{
+		"users": {
+			Name:    "users",
+			SQL:     "CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)",
+			Columns: []schema.Column{{Name: "id", Type: "INTEGER", PrimaryKey: 1}, {Name: "email", Type: "TEXT"}},
+		},
+	}}
+	to := &schema.Database{Tables: map[string]*schema.Table{
+		"users": {
+			Name: "users",
+			SQL:  "CREATE TABLE users (id INTEGER PRIMARY KEY, mail TEXT)",
+			Columns: []schema.Column{
+				{Name: "id", Type: "INTEGER", PrimaryKey: 1},
+				{Name: "mail", Type: "TEXT", From: "lower(email)"},
+			},
+		},
+	}}
+	initMaps(from)
+	initMaps(to)
+
+	changes := Diff(from, to)
+	if len(changes) != 1 || changes[0].Type != RecreateTable {
+		t.Fatalf("expected mapped column to recreate instead of rename, got %+v", changes)
+	}
+}
+
+func TestDiff_BackfillAddColumn(t *testing.T) {
+	from := &schema.Database{Tables: map[string]*schema.Table{
+		"users": {
+			Name:    "users",
+			SQL:     "CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)",
+			Columns: []schema.Column{{Name: "id", Type: "INTEGER", PrimaryKey: 1}, {Name: "email", Type: "TEXT"}},
+		},
+	}}
+	to := &schema.Database{Tables: map[string]*schema.Table{
+		"users": {
+			Name: "users",
+			SQL:  "CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, email_lower TEXT)",
+			Columns: []schema.Column{
+				{Name: "id", Type: "INTEGER", PrimaryKey: 1},
+				{Name: "email", Type: "TEXT"},
+				{Name: "email_lower", Type: "TEXT", Backfill: "lower(email)"},
+			},
+		},
+	}}
+	initMaps(from)
+	initMaps(to)
+
+	changes := Diff(from, to)
+	if len(changes) != 1 || changes[0].Type != AddColumn {
+		t.Fatalf("expected one ADD COLUMN, got %+v", changes)
+	}
+	want := []string{
+		`ALTER TABLE "users" ADD COLUMN "email_lower" TEXT;`,
+		`UPDATE "users" SET "email_lower" = lower(email);`,
+	}
+	if !slices.Equal(changes[0].SQL, want) {
+		t.Errorf("SQL = %v, want %v", changes[0].SQL, want)
+	}
+
+	// Options take precedence over annotations
+	changes = DiffWithOptions(from, to, DiffOptions{Backfill: map[string]string{
+		"users.email_lower": "upper(email)",
+	}})
+	if got := changes[0].SQL[1]; got != `UPDATE "users" SET "email_lower" = upper(email);` {
+		t.Errorf("override SQL = %q", got)
+	}
+}
+
+func TestDiff_ColumnLevelTypes(t *testing.T) {
+	tests := []struct {
+		name       string
+		from, to   string
+		want       []ChangeType
+		wantColumn string
+	}{
+		{
+			name: "drop column",
+			from: `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, legacy TEXT);`,
+			to:   `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`,
+			want: []ChangeType{DropColumn}, wantColumn: "legacy",
+		},
+		{
+			name: "drop column and add constraint",
+			from: `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, legacy TEXT);`,
+			to:   `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT UNIQUE);`,
+			want: []ChangeType{RecreateTable},
+		},
+		{
+			name: "drop two columns",
+			from: `CREATE TABLE users (id INTEGER PRIMARY KEY, a TEXT, b TEXT);`,
+			to:   `CREATE TABLE users (id INTEGER PRIMARY KEY);`,
+			want: []ChangeType{RecreateTable},
+		},
+		{
+			name: "alter default",
+			from: `CREATE TABLE users (id INTEGER PRIMARY KEY, role TEXT DEFAULT 'user');`,
+			to:   `CREATE TABLE users (id INTEGER PRIMARY KEY, role TEXT DEFAULT 'member');`,
+			want: []ChangeType{AlterColumnDefault}, wantColumn: "role",
+		},
+		{
+			name: "alter default and type",
+			from: `CREATE TABLE users (id INTEGER PRIMARY KEY, role TEXT DEFAULT 'user', age TEXT);`,
+			to:   `CREATE TABLE users (id INTEGER PRIMARY KEY, role TEXT DEFAULT 'member', age INTEGER);`,
+			want: []ChangeType{RecreateTable},
+		},
+		{
+			name: "rename table",
+			from: `CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT);
+				CREATE INDEX idx_name ON people (name);`,
+			to: `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+				CREATE INDEX idx_name ON users (name);`,
+			want: []ChangeType{DropIndex, RenameTable, CreateIndex},
+		},
+		{
+			name: "rename table with changes",
+			from: `CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT);`,
+			to:   `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);`,
+			want: []ChangeType{DropTable, CreateTable},
+		},
+		{
+			name: "two tables replaced",
+			from: `CREATE TABLE a (id INTEGER); CREATE TABLE b (id INTEGER);`,
+			to:   `CREATE TABLE c (id INTEGER); CREATE TABLE d (id INTEGER);`,
+			want: []ChangeType{DropTable, DropTable, CreateTable, CreateTable},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, err := parser.FromSQL(tt.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			to, err := parser.FromSQL(tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			changes := Diff(from, to)
+			types := make([]ChangeType, len(changes))
+			for i, c := range changes {
+				types[i] = c.Type
+			}
+			if !slices.Equal(types, tt.want) {
+				t.Fatalf("change types = %v, want %v", types, tt.want)
+			}
+			for _, c := range changes {
+				if c.Type == DropColumn || c.Type == AlterColumnDefault {
+					if c.Column != tt.wantColumn || !c.RecreatesTable() {
+						t.Errorf("%s column = %q, recreates = %v, want %q and a recreate", c.Type, c.Column, c.RecreatesTable(), tt.wantColumn)
+					}
+				}
+				if c.Type == RenameTable && (c.Destructive || c.RecreatesTable()) {
+					t.Errorf("RENAME_TABLE = %+v, want an in-place rename", c)
+				}
+			}
+
+			report, err := SelfCheck(from, to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !report.OK() {
+				t.Errorf("SelfCheck() =\n%s", report)
+			}
+		})
+	}
+}
+
+func TestChange_ID(t *testing.T) {
+	base := Change{Type: AddColumn, Object: "users", Column: "email", SQL: []string{`ALTER TABLE users ADD COLUMN email TEXT;`}}
+
+	same := base
+	same.Object = "Users"
+	same.Description = "Add column users.email (12 KiB)"
+	same.Destructive = true
+	if base.ID() != same.ID() {
+		t.Errorf("ID() differs for the same change: %s != %s", base.ID(), same.ID())
+	}
+	if len(base.ID()) != 12 {
+		t.Errorf("ID() = %q, want 12 characters", base.ID())
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *Change)
+	}{
+		{"type", func(c *Change) { c.Type = RenameColumn }},
+		{"object", func(c *Change) { c.Object = "accounts" }},
+		{"column", func(c *Change) { c.Column = "mail" }},
+		{"SQL", func(c *Change) { c.SQL = []string{`ALTER TABLE users ADD COLUMN email TEXT DEFAULT '';`} }},
+		{"split SQL", func(c *Change) { c.SQL = []string{`ALTER TABLE users`, `ADD COLUMN email TEXT;`} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := base
+			tt.modify(&c)
+			if c.ID() == base.ID() {
+				t.Errorf("ID() unchanged after changing the %s", tt.name)
+			}
+		})
+	}
+
+	// The same schemas give the same IDs on every run
+	from := &schema.Database{Tables: map[string]*schema.Table{}}
+	to := &schema.Database{Tables: map[string]*schema.Table{
+		"users": {Name: "users", SQL: "CREATE TABLE users (id INTEGER PRIMARY KEY)", Columns: []schema.Column{{Name: "id", Type: "INTEGER", PrimaryKey: 1}}},
+	}}
+	initMaps(from)
+	initMaps(to)
+	first, second := Diff(from, to), Diff(from, to)
+	if first[0].ID() != second[0].ID() {
+		t.Errorf("ID() differs between runs: %s != %s", first[0].ID(), second[0].ID())
+	}
+}
+
+func TestDiff_TriggersAfterDataCopy(t *testing.T) {
+	audit := &schema.Trigger{
+		Name:  "audit_users",
+		Table: "users",
+		SQL:   "CREATE TRIGGER audit_users AFTER UPDATE ON users BEGIN INSERT INTO audit VALUES (new.id); END",
+	}
+	users := func(sql string, columns ...schema.Column) *schema.Database {
+		db := &schema.Database{
+			Tables: map[string]*schema.Table{
+				"users": {Name: "users", SQL: sql, Columns: append([]schema.Column{{Name: "id", Type: "INTEGER", PrimaryKey: 1}}, columns...)},
+			},
+			Triggers: map[string]*schema.Trigger{"audit_users": audit},
+		}
+		initMaps(db)
+		return db
+	}
+	from := users("CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT)", schema.Column{Name: "email", Type: "TEXT"})
+
+	tests := []struct {
+		name string
+		to   *schema.Database
+		want []ChangeType
+	}{
+		{
+			"recreate",
+			users("CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL)", schema.Column{Name: "email", Type: "TEXT", NotNull: true}),
+			[]ChangeType{DropTrigger, RecreateTable, CreateTrigger},
+		},
+		{
+			"backfill",
+			users(
+				"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, domain TEXT)",
+				schema.Column{Name: "email", Type: "TEXT"},
+				schema.Column{Name: "domain", Type: "TEXT", Backfill: "substr(email, instr(email, '@') + 1)"},
+			),
+			[]ChangeType{DropTrigger, AddColumn, CreateTrigger},
+		},
+		{
+			"add column without backfill",
+			users(
+				"CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, domain TEXT)",
+				schema.Column{Name: "email", Type: "TEXT"},
+				schema.Column{Name: "domain", Type: "TEXT"},
+			),
+			[]ChangeType{AddColumn},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := Diff(from, tt.to)
+			var got []ChangeType
+			for _, c := range changes {
+				got = append(got, c.Type)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("change types = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !windows
+
+package diff
+
+import "errors"
+
+// freeDiskSpace is not implemented on this platform
+func freeDiskSpace(string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+// sameFilesystem assumes that both directories share a filesystem
+func sameFilesystem(string, string) bool {
+	return true
+}
@@ -0,0 +1,25 @@
+//go:build linux || darwin
+
+package diff
+
+import "golang.org/x/sys/unix"
+
+// freeDiskSpace returns the bytes available to unprivileged users at path
+func freeDiskSpace(path string) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	// Field types differ between platforms
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// sameFilesystem reports whether two directories are on the same filesystem.
+// If either cannot be inspected, they are assumed to share it.
+func sameFilesystem(a, b string) bool {
+	var sa, sb unix.Stat_t
+	if unix.Stat(a, &sa) != nil || unix.Stat(b, &sb) != nil {
+		return true
+	}
+	return sa.Dev == sb.Dev
+}
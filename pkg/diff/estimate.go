@@ -0,0 +1,334 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Throughput assumptions used for estimates. They are deliberately
+// conservative so that commodity SSDs and embedded flash are not underestimated.
+const (
+	copyBytesPerSecond   = 50 << 20  // INSERT ... SELECT into a recreated table
+	indexBytesPerSecond  = 30 << 20  // Sorting and writing a new index
+	dropBytesPerSecond   = 500 << 20 // Moving dropped pages to the freelist
+	backupBytesPerSecond = 100 << 20 // VACUUM INTO
+)
+
+// Estimate is a rough prediction of what applying a plan will cost
+type Estimate struct {
+	Duration       time.Duration // Expected time to apply the changes
+	BackupDuration time.Duration // Expected time to create a VACUUM INTO backup
+	BackupBytes    int64         // Size of a VACUUM INTO backup
+	TempBytes      int64         // Extra disk needed while applying (new copies plus WAL/journal)
+	JournalBytes   int64         // Part of TempBytes written to the WAL or rollback journal
+}
+
+func (e Estimate) String() string {
+	return fmt.Sprintf(
+		"~%s, %s temporary disk space (backup: ~%s, %s)",
+		e.Duration.Round(time.Second),
+		FormatBytes(e.TempBytes),
+		e.BackupDuration.Round(time.Second),
+		FormatBytes(e.BackupBytes),
+	)
+}
+
+// ErrNoSizes is returned by EstimateChanges and AnnotateSizes when the
+// sizes of tables cannot be read, e.g. because SQLite was built without the
+// dbstat virtual table
+var ErrNoSizes = errors.New("table sizes not available")
+
+// EstimateChanges predicts how long applying changes to db takes and how much
+// disk space it needs, based on the current sizes of the tables the changes
+// touch. Only those tables are read. Returns an error wrapping ErrNoSizes if
+// dbstat is not available.
+func EstimateChanges(db *sql.DB, changes []Change) (Estimate, error) {
+	return EstimateChangesContext(context.Background(), db, changes)
+}
+
+// EstimateChangesContext is EstimateChanges with a context, which cancels
+// reading the table sizes
+func EstimateChangesContext(ctx context.Context, db *sql.DB, changes []Change) (Estimate, error) {
+	var est Estimate
+
+	dbBytes, err := databaseBytes(ctx, db)
+	if err != nil {
+		return est, err
+	}
+	est.BackupBytes = dbBytes
+	est.BackupDuration = bytesDuration(dbBytes, backupBytesPerSecond)
+
+	tables, _, err := objectSizes(ctx, db, sizedTables(changes))
+	if err != nil {
+		return est, err
+	}
+	sizeOf := func(table string) int64 {
+		return tables[table].bytes
+	}
+
+	for _, c := range changes {
+		switch {
+		case c.RecreatesTable():
+			size := sizeOf(c.Object)
+			est.Duration += bytesDuration(size, copyBytesPerSecond)
+			// New copy of the table plus the same amount again in the WAL/journal
+			est.TempBytes += 2 * size
+			est.JournalBytes += size
+		case c.Type == CreateIndex:
+			size := sizeOf(indexTable(c))
+			est.Duration += bytesDuration(size, indexBytesPerSecond)
+			est.TempBytes += size
+		case c.Type == DropTable:
+			est.Duration += bytesDuration(sizeOf(c.Object), dropBytesPerSecond)
+		}
+	}
+
+	return est, nil
+}
+
+// CheckDiskSpace returns an error if the filesystem holding db does not have
+// enough free space for the estimated backup and temporary files.
+// Databases without a file (in-memory) and unsupported platforms are not checked.
+func CheckDiskSpace(db *sql.DB, est Estimate, withBackup bool) error {
+	path, err := databasePath(db)
+	if err != nil || path == "" {
+		return err
+	}
+	backupPath := ""
+	if withBackup {
+		backupPath = path + ".backup"
+	}
+	return CheckDiskSpaceAt(db, est, backupPath)
+}
+
+// CheckDiskSpaceAt is CheckDiskSpace for a backup written to backupPath, or
+// no backup if it is empty. A backup on another filesystem is checked
+// against that filesystem's free space.
+func CheckDiskSpaceAt(db *sql.DB, est Estimate, backupPath string) error {
+	path, err := databasePath(db)
+	if err != nil || path == "" {
+		return err
+	}
+	dir := filepath.Dir(path)
+
+	need := est.TempBytes
+	parts := []string{
+		fmt.Sprintf("table copies ~%s", FormatBytes(est.TempBytes-est.JournalBytes)),
+		fmt.Sprintf("WAL/journal ~%s", FormatBytes(est.JournalBytes)),
+	}
+	if backupPath != "" {
+		backupDir := filepath.Dir(backupPath)
+		if sameFilesystem(dir, backupDir) {
+			need += est.BackupBytes
+			parts = append([]string{fmt.Sprintf("backup ~%s", FormatBytes(est.BackupBytes))}, parts...)
+		} else if err := checkFree(backupDir, est.BackupBytes, "backup needs ~"+FormatBytes(est.BackupBytes)); err != nil {
+			return err
+		}
+	}
+	return checkFree(dir, need, fmt.Sprintf("migration needs ~%s (%s)", FormatBytes(need), strings.Join(parts, ", ")))
+}
+
+// checkFree returns an error if dir has less than need bytes free. Unknown
+// free space does not block the apply.
+func checkFree(dir string, need int64, what string) error {
+	free, err := freeDiskSpace(dir)
+	if err != nil || free >= uint64(max(need, 0)) {
+		return nil
+	}
+	return fmt.Errorf("insufficient disk space in %s: %s, %s available", dir, what, FormatBytes(int64(min(free, 1<<62))))
+}
+
+// FormatBytes renders a byte count in human-readable binary units
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func bytesDuration(n, perSecond int64) time.Duration {
+	return time.Duration(float64(n) / float64(perSecond) * float64(time.Second))
+}
+
+// databaseBytes returns the size of the used pages of the main database
+func databaseBytes(ctx context.Context, db *sql.DB) (int64, error) {
+	var pageCount, freePages, pageSize int64
+	if err := db.QueryRowContext(ctx, "PRAGMA page_count").Scan(&pageCount); err != nil {
+		return 0, fmt.Errorf("page count: %w", err)
+	}
+	if err := db.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&freePages); err != nil {
+		return 0, fmt.Errorf("freelist count: %w", err)
+	}
+	if err := db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, fmt.Errorf("page size: %w", err)
+	}
+	return (pageCount - freePages) * pageSize, nil
+}
+
+// fileBytes returns the size of the database file and its WAL, or 0 for
+// databases without a file
+func fileBytes(db *sql.DB) int64 {
+	path, err := databasePath(db)
+	if err != nil || path == "" {
+		return 0
+	}
+	var n int64
+	for _, f := range []string{path, path + "-wal"} {
+		if info, err := os.Stat(f); err == nil {
+			n += info.Size()
+		}
+	}
+	return n
+}
+
+// AnnotateSizes sets Pages and Bytes on every change that touches an existing
+// table or index, using the dbstat virtual table. Table sizes include the
+// table's indexes. New indexes are annotated with the size of the table they
+// are built on, as that is what has to be scanned. Only the tables the
+// changes touch are read. Returns an error wrapping ErrNoSizes if dbstat is
+// not available.
+func AnnotateSizes(db *sql.DB, changes []Change) error {
+	return AnnotateSizesContext(context.Background(), db, changes)
+}
+
+// AnnotateSizesContext is AnnotateSizes with a context, which cancels
+// reading the sizes
+func AnnotateSizesContext(ctx context.Context, db *sql.DB, changes []Change) error {
+	tables, indexes, err := objectSizes(ctx, db, sizedTables(changes))
+	if err != nil {
+		return err
+	}
+
+	for i := range changes {
+		c := &changes[i]
+
+		var size btreeSize
+		switch c.Type {
+		case DropTable, AddColumn, RenameColumn, RecreateTable, DropColumn, AlterColumnDefault:
+			size = tables[c.Object]
+		case DropIndex:
+			size = indexes[c.Object]
+		case CreateIndex:
+			size = tables[indexTable(*c)]
+		}
+		c.Pages, c.Bytes = size.pages, size.bytes
+	}
+	return nil
+}
+
+type btreeSize struct {
+	pages int64
+	bytes int64
+}
+
+// sizedTables returns the existing tables whose size matters for changes:
+// the tables they change or drop, and the tables indexes are built on or
+// dropped from
+func sizedTables(changes []Change) []string {
+	var tables []string
+	for _, c := range changes {
+		switch c.Type {
+		case CreateIndex:
+			tables = append(tables, indexTable(c))
+		case DropIndex:
+			tables = append(tables, c.Table)
+		default:
+			if objectKind(c.Type) == "table" {
+				tables = append(tables, c.Object)
+			}
+		}
+	}
+	slices.Sort(tables)
+	return slices.Compact(tables)
+}
+
+// objectSizes returns the space used by each of the given tables including
+// its indexes, and by each of their indexes on its own, using the dbstat
+// virtual table. Only the b-trees of these tables are read.
+func objectSizes(ctx context.Context, db *sql.DB, tables []string) (sizes, indexes map[string]btreeSize, err error) {
+	sizes = make(map[string]btreeSize)
+	indexes = make(map[string]btreeSize)
+	for _, table := range tables {
+		names, err := queryStrings(db,
+			"SELECT type || '|' || name FROM sqlite_master WHERE tbl_name = ? AND type IN ('table', 'index')", table)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, entry := range names {
+			typ, name, _ := strings.Cut(entry, "|")
+			// With aggregate = TRUE, pageno is the number of pages in the b-tree
+			var size btreeSize
+			if err := db.QueryRowContext(ctx,
+				"SELECT coalesce(sum(pageno), 0), coalesce(sum(pgsize), 0) FROM dbstat WHERE name = ? AND aggregate = TRUE",
+				name,
+			).Scan(&size.pages, &size.bytes); err != nil {
+				if ctx.Err() != nil {
+					return nil, nil, ctx.Err()
+				}
+				return nil, nil, fmt.Errorf("%w: %v", ErrNoSizes, err)
+			}
+
+			total := sizes[table]
+			total.pages += size.pages
+			total.bytes += size.bytes
+			sizes[table] = total
+			if typ == "index" {
+				indexes[name] = size
+			}
+		}
+	}
+	return sizes, indexes, nil
+}
+
+// databasePath returns the file backing the main database, or "" if in-memory
+func databasePath(db *sql.DB) (string, error) {
+	var file string
+	if err := db.QueryRow("SELECT file FROM pragma_database_list WHERE name = 'main'").
+		Scan(&file); err != nil {
+		return "", fmt.Errorf("database path: %w", err)
+	}
+	return file, nil
+}
+
+var indexTableRe = regexp.MustCompile(
+	`(?is)\bON\s+("(?:[^"]|"")*"|\x60(?:[^\x60]|\x60\x60)*\x60|\[[^\]]*\]|[^\s(]+)`,
+)
+
+// indexTable returns the table a CREATE INDEX change is defined on
+func indexTable(c Change) string {
+	for _, stmt := range c.SQL {
+		if m := indexTableRe.FindStringSubmatch(stmt); m != nil {
+			return unquoteIdent(m[1])
+		}
+	}
+	return ""
+}
+
+// unquoteIdent strips SQLite identifier quoting
+func unquoteIdent(name string) string {
+	if len(name) >= 2 {
+		switch {
+		case name[0] == '"' && name[len(name)-1] == '"':
+			return strings.ReplaceAll(name[1:len(name)-1], `""`, `"`)
+		case name[0] == '`' && name[len(name)-1] == '`':
+			return strings.ReplaceAll(name[1:len(name)-1], "``", "`")
+		case name[0] == '[' && name[len(name)-1] == ']':
+			return name[1 : len(name)-1]
+		}
+	}
+	return name
+}
@@ -0,0 +1,189 @@
+package diff
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEstimateChanges(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE events (id INTEGER PRIMARY KEY, payload TEXT);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 2000)
+		INSERT INTO events (payload) SELECT printf('%0500d', i) FROM n;
+	`)
+	defer func() { _ = db.Close() }()
+
+	changes := []Change{
+		{Type: RecreateTable, Object: "events"},
+		{Type: CreateIndex, Object: "idx_events_payload", SQL: []string{
+			`CREATE INDEX idx_events_payload ON "events"(payload);`,
+		}},
+		{Type: AddColumn, Object: "events"},
+	}
+
+	est, err := EstimateChanges(db, changes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := AnnotateSizes(db, changes); err != nil {
+		t.Fatalf("AnnotateSizes() error: %v", err)
+	}
+	size := changes[0].Bytes
+
+	// 2000 rows of ~500 bytes
+	if size < 900<<10 {
+		t.Errorf("events size = %d, want at least 900 KiB", size)
+	}
+	// Recreate copy and journal, plus the new index
+	if est.TempBytes != 3*size {
+		t.Errorf("TempBytes = %d, want %d", est.TempBytes, 3*size)
+	}
+	if est.JournalBytes != size {
+		t.Errorf("JournalBytes = %d, want %d", est.JournalBytes, size)
+	}
+	if est.BackupBytes < size {
+		t.Errorf("BackupBytes = %d, want at least %d", est.BackupBytes, size)
+	}
+	if est.Duration <= 0 {
+		t.Errorf("Duration = %v, want > 0", est.Duration)
+	}
+
+	if err := CheckDiskSpace(db, est, true); err != nil {
+		t.Errorf("CheckDiskSpace() unexpected error: %v", err)
+	}
+	est.TempBytes = 1 << 62
+	if err := CheckDiskSpace(db, est, true); err == nil ||
+		!strings.Contains(err.Error(), "insufficient disk space") {
+		t.Errorf("CheckDiskSpace() error = %v, want insufficient disk space", err)
+	}
+
+	// The error breaks the requirement down
+	err = CheckDiskSpaceAt(db, est, filepath.Join(t.TempDir(), "app.db.backup"))
+	for _, part := range []string{"backup ~", "table copies ~", "WAL/journal ~"} {
+		if err == nil || !strings.Contains(err.Error(), part) {
+			t.Errorf("CheckDiskSpaceAt() error = %v, want it to contain %q", err, part)
+		}
+	}
+	if err := CheckDiskSpaceAt(db, est, ""); err == nil || strings.Contains(err.Error(), "backup") {
+		t.Errorf("CheckDiskSpaceAt() without a backup = %v, want an error without the backup", err)
+	}
+}
+
+func TestFileBytes(t *testing.T) {
+	db, dbPath := createTestDBWithPath(t, `
+		PRAGMA journal_mode = WAL;
+		CREATE TABLE events (id INTEGER PRIMARY KEY, payload TEXT);
+		INSERT INTO events (payload) VALUES (randomblob(100000));
+	`)
+	defer func() { _ = db.Close() }()
+
+	var want int64
+	for _, f := range []string{dbPath, dbPath + "-wal"} {
+		if info, err := os.Stat(f); err == nil {
+			want += info.Size()
+		}
+	}
+	if got := fileBytes(db); got != want || got < 100000 {
+		t.Errorf("fileBytes() = %d, want %d", got, want)
+	}
+	mem, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = mem.Close() }()
+	if got := fileBytes(mem); got != 0 {
+		t.Errorf("fileBytes() of an in-memory database = %d, want 0", got)
+	}
+}
+
+func TestAnnotateSizes(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE events (id INTEGER PRIMARY KEY, payload TEXT);
+		CREATE INDEX idx_events_payload ON events(payload);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 2000)
+		INSERT INTO events (payload) SELECT printf('%0100d', i) FROM n;
+	`)
+	defer func() { _ = db.Close() }()
+
+	changes := []Change{
+		{Type: RecreateTable, Object: "events"},
+		{Type: DropIndex, Object: "idx_events_payload"},
+		{Type: CreateIndex, Object: "idx_new", SQL: []string{`CREATE INDEX idx_new ON events(id);`}},
+		{Type: CreateTable, Object: "missing"},
+		{Type: CreateView, Object: "events"},
+	}
+	if err := AnnotateSizes(db, changes); err != nil {
+		t.Fatalf("AnnotateSizes() error: %v", err)
+	}
+
+	table, index := changes[0], changes[1]
+	if index.Bytes <= 0 || index.Pages <= 0 {
+		t.Errorf("index size = %d bytes, %d pages, want > 0", index.Bytes, index.Pages)
+	}
+	if table.Bytes <= index.Bytes || table.Pages <= index.Pages {
+		t.Errorf("table size %d should include its index (%d)", table.Bytes, index.Bytes)
+	}
+	if changes[2].Bytes != table.Bytes {
+		t.Errorf("new index size = %d, want size of indexed table %d", changes[2].Bytes, table.Bytes)
+	}
+	for _, c := range changes[3:] {
+		if c.Bytes != 0 || c.Pages != 0 {
+			t.Errorf("%s %s: size = %d, want 0", c.Type, c.Object, c.Bytes)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 30, "5.0 GiB"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatBytes(tt.n); got != tt.want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestIndexTable(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"CREATE INDEX i ON users(name)", "users"},
+		{`CREATE UNIQUE INDEX i ON "my table" (name)`, "my table"},
+		{"CREATE INDEX i on [t] (a) WHERE a > 0", "t"},
+	}
+
+	for _, tt := range tests {
+		if got := indexTable(Change{SQL: []string{tt.sql}}); got != tt.want {
+			t.Errorf("indexTable(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestSizedTables(t *testing.T) {
+	changes := []Change{
+		{Type: RecreateTable, Object: "users"},
+		{Type: CreateIndex, Object: "idx_posts_user", SQL: []string{"CREATE INDEX idx_posts_user ON posts(user_id);"}},
+		{Type: DropIndex, Object: "idx_users_name", Table: "users"},
+		{Type: CreateView, Object: "active_users"},
+		{Type: DropTable, Object: "legacy"},
+	}
+	got := sizedTables(changes)
+	if want := []string{"legacy", "posts", "users"}; !slices.Equal(got, want) {
+		t.Errorf("sizedTables() = %q, want %q", got, want)
+	}
+}
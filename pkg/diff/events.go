@@ -0,0 +1,108 @@
+package diff
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// Event reports progress of the diff and apply pipeline to DiffOptions.OnEvent.
+// It is one of ObjectCompared, ChangePlanned, StatementExecuted or BatchCopied.
+type Event interface {
+	event()
+}
+
+// ObjectCompared is emitted for every table, index, view and trigger of
+// either schema once the diff is complete
+type ObjectCompared struct {
+	Kind    string // "table", "index", "view" or "trigger"
+	Name    string
+	Changed bool // Whether the plan changes the object
+}
+
+// ChangePlanned is emitted for every change of a plan, in plan order
+type ChangePlanned struct {
+	Change Change
+}
+
+// StatementExecuted is emitted after every statement Apply runs
+type StatementExecuted struct {
+	Change   Change
+	SQL      string
+	Duration time.Duration
+}
+
+// BatchCopied is emitted when Apply has copied the rows of a recreated
+// table into its new definition, or in low-priority mode every batch of them
+type BatchCopied struct {
+	Table string
+	Rows  int64
+}
+
+func (ObjectCompared) event()    {}
+func (ChangePlanned) event()     {}
+func (StatementExecuted) event() {}
+func (BatchCopied) event()       {}
+
+// emit sends an event to OnEvent
+func (opts DiffOptions) emit(e Event) {
+	if opts.OnEvent != nil {
+		opts.OnEvent(e)
+	}
+}
+
+// objectKind returns the kind of object a change type affects
+func objectKind(t ChangeType) string {
+	switch t {
+	case CreateIndex, DropIndex:
+		return "index"
+	case CreateView, DropView:
+		return "view"
+	case CreateTrigger, DropTrigger:
+		return "trigger"
+	case DataMigration:
+		return ""
+	}
+	return "table"
+}
+
+// emitPlan reports every compared object and every planned change
+func emitPlan(from, to *schema.Database, changes []Change, opts DiffOptions) {
+	if opts.OnEvent == nil {
+		return
+	}
+
+	changed := make(map[string]bool)
+	for _, c := range changes {
+		changed[objectKind(c.Type)+"."+c.Object] = true
+	}
+	kinds := []struct {
+		kind     string
+		from, to []string
+	}{
+		{"table", slices.Collect(maps.Keys(from.Tables)), slices.Collect(maps.Keys(to.Tables))},
+		{"index", slices.Collect(maps.Keys(from.Indexes)), slices.Collect(maps.Keys(to.Indexes))},
+		{"view", slices.Collect(maps.Keys(from.Views)), slices.Collect(maps.Keys(to.Views))},
+		{"trigger", slices.Collect(maps.Keys(from.Triggers)), slices.Collect(maps.Keys(to.Triggers))},
+	}
+	for _, k := range kinds {
+		names := slices.Concat(k.from, k.to)
+		slices.Sort(names)
+		for _, name := range slices.Compact(names) {
+			opts.emit(ObjectCompared{Kind: k.kind, Name: name, Changed: changed[k.kind+"."+name]})
+		}
+	}
+
+	for _, c := range changes {
+		opts.emit(ChangePlanned{Change: c})
+	}
+}
+
+// isCopyStatement reports whether stmt copies the rows of a recreated table
+func isCopyStatement(c Change, stmt string) bool {
+	return c.RecreatesTable() && strings.HasPrefix(stmt, fmt.Sprintf("INSERT INTO %q ", c.Object+"__new"))
+}
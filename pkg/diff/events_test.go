@@ -0,0 +1,58 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestApply_Events(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, legacy TEXT);
+		CREATE INDEX idx_users_name ON users(name);
+		CREATE VIEW answer AS SELECT 42 AS value;
+		INSERT INTO users (name) VALUES ('a'), ('b'), ('c');
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "schema.sql", `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE INDEX idx_users_name ON users(name);
+		CREATE VIEW answer AS SELECT 42 AS value;
+		CREATE TABLE posts (id INTEGER PRIMARY KEY);
+	`)
+
+	var events []string
+	opts := ApplyOptions{DiffOptions: DiffOptions{OnEvent: func(e Event) {
+		switch e := e.(type) {
+		case ObjectCompared:
+			events = append(events, fmt.Sprintf("compared %s %s %v", e.Kind, e.Name, e.Changed))
+		case ChangePlanned:
+			events = append(events, fmt.Sprintf("planned %s %s", e.Change.Type, e.Change.Object))
+		case StatementExecuted:
+			if e.Change.Object == "posts" {
+				events = append(events, "executed "+e.SQL)
+			}
+		case BatchCopied:
+			events = append(events, fmt.Sprintf("copied %s %d", e.Table, e.Rows))
+		}
+	}}}
+
+	if err := Apply(db, schemaDir, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{
+		"compared table posts true",
+		"compared table users true",
+		"compared index idx_users_name true", // Recreated along with its table
+		"compared view answer false",
+		"planned DROP_COLUMN users",
+		"planned CREATE_TABLE posts",
+		"planned CREATE_INDEX idx_users_name",
+		"copied users 3",
+		`executed CREATE TABLE posts (id INTEGER PRIMARY KEY);`,
+	}
+	if got := strings.Join(events, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("events:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,194 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportFormat is the file format ExportLostData writes
+type ExportFormat string
+
+const (
+	ExportCSV   ExportFormat = "" // One CSV file per table with a header row, the default
+	ExportJSONL ExportFormat = "jsonl"
+)
+
+// ExportLostData writes the data the destructive changes lose to files in
+// dir, one per table named <time>_<table>.csv or .jsonl, and returns their
+// paths. A dropped table is exported whole; for a recreated table the lost
+// columns are exported with the primary key (or rowid) so rows can be
+// matched up again. In CSV, NULL is an empty field and blobs are hex; in
+// JSONL, blobs are base64.
+func ExportLostData(db *sql.DB, changes []Change, dir string, format ExportFormat) ([]string, error) {
+	return ExportLostDataContext(context.Background(), db, changes, dir, format)
+}
+
+// ExportLostDataContext is ExportLostData with a context, which cancels the
+// export; the files written so far are returned
+func ExportLostDataContext(ctx context.Context, db *sql.DB, changes []Change, dir string, format ExportFormat) ([]string, error) {
+	ext := "csv"
+	switch format {
+	case ExportCSV:
+	case ExportJSONL:
+		ext = "jsonl"
+	default:
+		return nil, fmt.Errorf("invalid export format %q: must be csv or jsonl", format)
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("create export directory: %w", err)
+	}
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	var paths []string
+	for _, c := range changes {
+		if !c.Destructive {
+			continue
+		}
+		lost, err := lostColumns(db, c)
+		if err != nil {
+			return paths, err
+		}
+		if len(lost) == 0 {
+			continue
+		}
+		cols := lost
+		if c.RecreatesTable() {
+			keys, err := keyColumns(db, c.Object)
+			if err != nil {
+				return paths, err
+			}
+			cols = append(keys, lost...)
+		}
+
+		path := filepath.Join(dir, fmt.Sprintf("%s_%s.%s", stamp, safeFileName(c.Object), ext))
+		if err := exportTable(ctx, db, c.Object, cols, path, format); err != nil {
+			return paths, fmt.Errorf("export %q: %w", c.Object, err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// keyColumns returns the primary key columns of a table, or rowid if it has
+// none
+func keyColumns(db *sql.DB, table string) ([]string, error) {
+	keys, err := queryStrings(db, "SELECT name FROM pragma_table_info(?) WHERE pk > 0 ORDER BY pk", table)
+	if err != nil {
+		return nil, fmt.Errorf("read primary key of %q: %w", table, err)
+	}
+	if len(keys) == 0 {
+		keys = []string{"rowid"}
+	}
+	return keys, nil
+}
+
+// safeFileName replaces the characters of a table name that are not safe
+// in file names
+func safeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+func exportTable(ctx context.Context, db *sql.DB, table string, cols []string, path string, format ExportFormat) (err error) {
+	quoted := make([]string, len(cols))
+	for i, col := range cols {
+		quoted[i] = fmt.Sprintf("%q", col)
+		if col == "rowid" {
+			quoted[i] = "rowid"
+		}
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %q", strings.Join(quoted, ", "), table))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	f, err := os.OpenFile(filepath.Clean(path), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	var write func(values []any) error
+	var flush func() error
+	if format == ExportJSONL {
+		enc := json.NewEncoder(f)
+		write = func(values []any) error {
+			row := make(map[string]any, len(cols))
+			for i, col := range cols {
+				row[col] = values[i]
+			}
+			return enc.Encode(row)
+		}
+		flush = func() error { return nil }
+	} else {
+		w := csv.NewWriter(f)
+		if err := w.Write(cols); err != nil {
+			return err
+		}
+		record := make([]string, len(cols))
+		write = func(values []any) error {
+			for i, v := range values {
+				record[i] = csvValue(v)
+			}
+			return w.Write(record)
+		}
+		flush = func() error {
+			w.Flush()
+			return w.Error()
+		}
+	}
+
+	values := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		if err := write(values); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// csvValue renders a column value for CSV
+func csvValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return hex.EncodeToString(v)
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,95 @@
+package diff
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+func TestExportLostData(t *testing.T) {
+	tests := []struct {
+		format ExportFormat
+		want   map[string]string // Table to file content
+	}{
+		{ExportCSV, map[string]string{
+			"users": "id,legacy\n1,x\n2,\n",
+			"logs":  "msg,data\n\"a, b\",00ff\n",
+		}},
+		{ExportJSONL, map[string]string{
+			"users": `{"id":1,"legacy":"x"}` + "\n" + `{"id":2,"legacy":null}` + "\n",
+			"logs":  `{"data":"AP8=","msg":"a, b"}` + "\n",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			db, _ := createTestDBWithPath(t, `
+				CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, legacy TEXT);
+				INSERT INTO users VALUES (1, 'ann', 'x'), (2, 'bob', NULL);
+				CREATE TABLE logs (msg TEXT, data BLOB);
+				INSERT INTO logs VALUES ('a, b', x'00ff');
+			`)
+			defer func() { _ = db.Close() }()
+			current, err := parser.FromDB(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target, err := parser.FromSQL(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dir := filepath.Join(t.TempDir(), "export")
+			paths, err := ExportLostData(db, Diff(current, target), dir, tt.format)
+			if err != nil {
+				t.Fatalf("ExportLostData() error = %v", err)
+			}
+			if len(paths) != len(tt.want) {
+				t.Fatalf("ExportLostData() = %v, want %d files", paths, len(tt.want))
+			}
+			for _, path := range paths {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				base := filepath.Base(path)
+				table := strings.TrimSuffix(base[strings.IndexByte(base, '_')+1:], filepath.Ext(base))
+				if string(data) != tt.want[table] {
+					t.Errorf("%s =\n%s\nwant\n%s", base, data, tt.want[table])
+				}
+			}
+		})
+	}
+}
+
+func TestApply_ExportDir(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE items (id INTEGER PRIMARY KEY, qty INT, note TEXT);
+		INSERT INTO items VALUES (1, 5, 'keep me'), (2, -1, 'bad');
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "items.sql", `CREATE TABLE items (id INTEGER PRIMARY KEY, qty INT CHECK (qty >= 0));`)
+	dir := t.TempDir()
+
+	err := Apply(db, schemaDir, ApplyOptions{ExportDir: dir, QuarantineViolations: true})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*_items.csv"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("exported files = %v, want one items export", files)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "id,note\n1,keep me\n2,bad\n"; string(data) != want {
+		t.Errorf("export =\n%s\nwant\n%s", data, want)
+	}
+
+	if _, err := ExportLostData(db, nil, dir, "xml"); err == nil {
+		t.Error("ExportLostData(xml) error = nil, want invalid format")
+	}
+}
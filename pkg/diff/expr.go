@@ -0,0 +1,758 @@
+package diff
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The policy expression language is a small subset of CEL, enough for
+// guardrails over a plan without linking a full CEL implementation:
+//
+//   - literals: "strings" or 'strings', integers, true, false, null and
+//     [lists]
+//   - variables and field access: changes, c.type, schema.tables
+//   - operators, by precedence: || then && then == != < <= > >= in, then
+//     + -, then * / %, then unary ! and -
+//   - string methods: contains, startsWith, endsWith, matches (a regular
+//     expression), lower, upper and size
+//   - list macros: exists, all, exists_one, filter and map, e.g.
+//     changes.exists(c, c.destructive); size(x) works on strings, lists
+//     and objects
+//
+// Values are nil, bool, int64, string, []any and map[string]any.
+
+// exprFunc evaluates a compiled expression against its variables
+type exprFunc func(vars map[string]any) (any, error)
+
+// exprToken is a lexical token of an expression
+type exprToken struct {
+	kind string // "ident", "int", "string", "eof" or the operator itself
+	text string
+	pos  int
+}
+
+// exprOperators are the operator tokens, longest first
+var exprOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ".", ","}
+
+// lexExpr splits an expression into tokens
+func lexExpr(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(src) && (src[i] == '_' || unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))) {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: "ident", text: src[start:i], pos: start})
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(src) && unicode.IsDigit(rune(src[i])) {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: "int", text: src[start:i], pos: start})
+		case c == '"' || c == '\'':
+			start := i
+			var b strings.Builder
+			for i++; ; i++ {
+				if i >= len(src) {
+					return nil, fmt.Errorf("at %d: unterminated string", start)
+				}
+				if src[i] == byte(c) {
+					i++
+					break
+				}
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+					switch src[i] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					default:
+						b.WriteByte(src[i])
+					}
+					continue
+				}
+				b.WriteByte(src[i])
+			}
+			tokens = append(tokens, exprToken{kind: "string", text: b.String(), pos: start})
+		default:
+			op := ""
+			for _, o := range exprOperators {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("at %d: unexpected %q", i, c)
+			}
+			tokens = append(tokens, exprToken{kind: op, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, exprToken{kind: "eof", pos: len(src)}), nil
+}
+
+// compileExpr parses an expression into a function evaluating it
+func compileExpr(src string) (exprFunc, error) {
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	fn, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != "eof" {
+		return nil, fmt.Errorf("at %d: unexpected %q", t.pos, t.text)
+	}
+	return fn, nil
+}
+
+// exprParser is a recursive descent parser with one function per
+// precedence level
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.pos]
+	if t.kind != "eof" {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) accept(kinds ...string) (exprToken, bool) {
+	t := p.peek()
+	for _, k := range kinds {
+		if t.kind == k {
+			return p.next(), true
+		}
+	}
+	return t, false
+}
+
+func (p *exprParser) expect(kind string) (exprToken, error) {
+	t, ok := p.accept(kind)
+	if !ok {
+		if t.kind == "eof" {
+			return t, fmt.Errorf("at %d: expected %q, got end of expression", t.pos, kind)
+		}
+		return t, fmt.Errorf("at %d: expected %q, got %q", t.pos, kind, t.text)
+	}
+	return t, nil
+}
+
+func (p *exprParser) or() (exprFunc, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("||"); !ok {
+			return left, nil
+		}
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = logical(left, right, true)
+	}
+}
+
+func (p *exprParser) and() (exprFunc, error) {
+	left, err := p.relation()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("&&"); !ok {
+			return left, nil
+		}
+		right, err := p.relation()
+		if err != nil {
+			return nil, err
+		}
+		left = logical(left, right, false)
+	}
+}
+
+// logical evaluates || (or) and && (not or), skipping the right side when
+// the left one decides
+func logical(left, right exprFunc, or bool) exprFunc {
+	return func(vars map[string]any) (any, error) {
+		l, err := evalBool(left, vars)
+		if err != nil || l == or {
+			return l, err
+		}
+		return evalBool(right, vars)
+	}
+}
+
+func (p *exprParser) relation() (exprFunc, error) {
+	left, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("==", "!=", "<", "<=", ">", ">=")
+	if !ok {
+		if t := p.peek(); t.kind == "ident" && t.text == "in" {
+			op, ok = p.next(), true
+			op.kind = "in"
+		}
+	}
+	if !ok {
+		return left, nil
+	}
+	right, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	return binary(left, right, func(l, r any) (any, error) { return compareValues(op.kind, l, r) }), nil
+}
+
+func (p *exprParser) sum() (exprFunc, error) {
+	left, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		left = binary(left, right, func(l, r any) (any, error) { return arithmetic(op.kind, l, r) })
+	}
+}
+
+func (p *exprParser) product() (exprFunc, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("*", "/", "%")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = binary(left, right, func(l, r any) (any, error) { return arithmetic(op.kind, l, r) })
+	}
+}
+
+func binary(left, right exprFunc, op func(l, r any) (any, error)) exprFunc {
+	return func(vars map[string]any) (any, error) {
+		l, err := left(vars)
+		if err != nil {
+			return nil, err
+		}
+		r, err := right(vars)
+		if err != nil {
+			return nil, err
+		}
+		return op(l, r)
+	}
+}
+
+func (p *exprParser) unary() (exprFunc, error) {
+	if _, ok := p.accept("!"); ok {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(vars map[string]any) (any, error) {
+			b, err := evalBool(operand, vars)
+			return !b, err
+		}, nil
+	}
+	if _, ok := p.accept("-"); ok {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(vars map[string]any) (any, error) {
+			v, err := operand(vars)
+			if err != nil {
+				return nil, err
+			}
+			return arithmetic("-", int64(0), v)
+		}, nil
+	}
+	return p.postfix()
+}
+
+// exprMacros are the list methods whose first argument names a variable
+// bound to each element while the second is evaluated
+var exprMacros = map[string]bool{"exists": true, "all": true, "exists_one": true, "filter": true, "map": true}
+
+func (p *exprParser) postfix() (exprFunc, error) {
+	fn, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch t := p.peek(); t.kind {
+		case ".":
+			p.next()
+			name, err := p.expect("ident")
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.accept("("); !ok {
+				fn = field(fn, name.text)
+				continue
+			}
+			if exprMacros[name.text] {
+				if fn, err = p.macro(fn, name.text); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			args, err := p.args()
+			if err != nil {
+				return nil, err
+			}
+			fn = method(fn, name.text, args)
+		case "[":
+			p.next()
+			index, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			fn = binary(fn, index, indexValue)
+		default:
+			return fn, nil
+		}
+	}
+}
+
+// args parses call arguments after the opening parenthesis
+func (p *exprParser) args() ([]exprFunc, error) {
+	var args []exprFunc
+	if _, ok := p.accept(")"); ok {
+		return nil, nil
+	}
+	for {
+		arg, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if _, ok := p.accept(","); ok {
+			continue
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return args, nil
+	}
+}
+
+func (p *exprParser) primary() (exprFunc, error) {
+	t := p.next()
+	switch t.kind {
+	case "int":
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("at %d: %w", t.pos, err)
+		}
+		return constant(n), nil
+	case "string":
+		return constant(t.text), nil
+	case "(":
+		fn, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.expect(")")
+		return fn, err
+	case "[":
+		items, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+		return func(vars map[string]any) (any, error) {
+			list := make([]any, len(items))
+			for i, item := range items {
+				v, err := item(vars)
+				if err != nil {
+					return nil, err
+				}
+				list[i] = v
+			}
+			return list, nil
+		}, nil
+	case "ident":
+		switch t.text {
+		case "true":
+			return constant(true), nil
+		case "false":
+			return constant(false), nil
+		case "null":
+			return constant(nil), nil
+		}
+		if _, ok := p.accept("("); ok {
+			if t.text != "size" {
+				return nil, fmt.Errorf("at %d: unknown function %s", t.pos, t.text)
+			}
+			args, err := p.args()
+			if err != nil {
+				return nil, err
+			}
+			if len(args) != 1 {
+				return nil, fmt.Errorf("at %d: size takes one argument", t.pos)
+			}
+			return method(args[0], "size", nil), nil
+		}
+		name := t.text
+		return func(vars map[string]any) (any, error) {
+			v, ok := vars[name]
+			if !ok {
+				return nil, fmt.Errorf("undeclared variable %s", name)
+			}
+			return v, nil
+		}, nil
+	case "eof":
+		return nil, fmt.Errorf("at %d: unexpected end of expression", t.pos)
+	}
+	return nil, fmt.Errorf("at %d: unexpected %q", t.pos, t.text)
+}
+
+// list parses list items after the opening bracket
+func (p *exprParser) list() ([]exprFunc, error) {
+	var items []exprFunc
+	if _, ok := p.accept("]"); ok {
+		return nil, nil
+	}
+	for {
+		item, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if _, ok := p.accept(","); ok {
+			continue
+		}
+		if _, err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+}
+
+// macro parses the arguments of a list macro like exists(c, c.destructive)
+func (p *exprParser) macro(target exprFunc, name string) (exprFunc, error) {
+	v, err := p.expect("ident")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(","); err != nil {
+		return nil, err
+	}
+	body, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	return func(vars map[string]any) (any, error) {
+		t, err := target(vars)
+		if err != nil {
+			return nil, err
+		}
+		list, ok := t.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s() needs a list, got %s", name, typeName(t))
+		}
+		scope := maps.Clone(vars)
+		var matches int
+		var results []any
+		for _, item := range list {
+			scope[v.text] = item
+			if name == "map" {
+				r, err := body(scope)
+				if err != nil {
+					return nil, err
+				}
+				results = append(results, r)
+				continue
+			}
+			ok, err := evalBool(body, scope)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case name == "exists" && ok:
+				return true, nil
+			case name == "all" && !ok:
+				return false, nil
+			case ok:
+				matches++
+				results = append(results, item)
+			}
+		}
+		switch name {
+		case "exists":
+			return false, nil
+		case "all":
+			return true, nil
+		case "exists_one":
+			return matches == 1, nil
+		}
+		if results == nil {
+			results = []any{}
+		}
+		return results, nil
+	}, nil
+}
+
+func constant(v any) exprFunc {
+	return func(map[string]any) (any, error) { return v, nil }
+}
+
+func evalBool(fn exprFunc, vars map[string]any) (bool, error) {
+	v, err := fn(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected a bool, got %s", typeName(v))
+	}
+	return b, nil
+}
+
+func field(target exprFunc, name string) exprFunc {
+	return func(vars map[string]any) (any, error) {
+		t, err := target(vars)
+		if err != nil {
+			return nil, err
+		}
+		obj, ok := t.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("cannot read field %s of %s", name, typeName(t))
+		}
+		v, ok := obj[name]
+		if !ok {
+			return nil, fmt.Errorf("no such field %s", name)
+		}
+		return v, nil
+	}
+}
+
+func indexValue(t, index any) (any, error) {
+	switch t := t.(type) {
+	case []any:
+		i, ok := index.(int64)
+		if !ok || i < 0 || i >= int64(len(t)) {
+			return nil, fmt.Errorf("invalid list index %v", index)
+		}
+		return t[i], nil
+	case map[string]any:
+		key, ok := index.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid key %v", index)
+		}
+		v, ok := t[key]
+		if !ok {
+			return nil, fmt.Errorf("no such key %q", key)
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("cannot index %s", typeName(t))
+}
+
+func method(target exprFunc, name string, args []exprFunc) exprFunc {
+	return func(vars map[string]any) (any, error) {
+		t, err := target(vars)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]any, len(args))
+		for i, arg := range args {
+			if values[i], err = arg(vars); err != nil {
+				return nil, err
+			}
+		}
+
+		if name == "size" && len(values) == 0 {
+			switch t := t.(type) {
+			case string:
+				return int64(len(t)), nil
+			case []any:
+				return int64(len(t)), nil
+			case map[string]any:
+				return int64(len(t)), nil
+			}
+			return nil, fmt.Errorf("size() of %s", typeName(t))
+		}
+
+		s, ok := t.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s() of %s", name, typeName(t))
+		}
+		switch name {
+		case "lower", "upper":
+			if len(values) != 0 {
+				return nil, fmt.Errorf("%s() takes no arguments", name)
+			}
+			if name == "lower" {
+				return strings.ToLower(s), nil
+			}
+			return strings.ToUpper(s), nil
+		case "contains", "startsWith", "endsWith", "matches":
+			if len(values) != 1 {
+				return nil, fmt.Errorf("%s() takes one argument", name)
+			}
+			arg, ok := values[0].(string)
+			if !ok {
+				return nil, fmt.Errorf("%s() needs a string, got %s", name, typeName(values[0]))
+			}
+			switch name {
+			case "contains":
+				return strings.Contains(s, arg), nil
+			case "startsWith":
+				return strings.HasPrefix(s, arg), nil
+			case "endsWith":
+				return strings.HasSuffix(s, arg), nil
+			}
+			re, err := regexp.Compile(arg)
+			if err != nil {
+				return nil, fmt.Errorf("matches(): %w", err)
+			}
+			return re.MatchString(s), nil
+		}
+		return nil, fmt.Errorf("unknown method %s", name)
+	}
+}
+
+func compareValues(op string, l, r any) (any, error) {
+	switch op {
+	case "==":
+		return reflect.DeepEqual(l, r), nil
+	case "!=":
+		return !reflect.DeepEqual(l, r), nil
+	case "in":
+		switch r := r.(type) {
+		case []any:
+			for _, item := range r {
+				if reflect.DeepEqual(l, item) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]any:
+			key, ok := l.(string)
+			if !ok {
+				return false, nil
+			}
+			_, ok = r[key]
+			return ok, nil
+		}
+		return nil, fmt.Errorf("in needs a list or object, got %s", typeName(r))
+	}
+
+	var c int
+	switch l := l.(type) {
+	case int64:
+		r, ok := r.(int64)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare int with %s", typeName(r))
+		}
+		c = cmp.Compare(l, r)
+	case string:
+		r, ok := r.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare string with %s", typeName(r))
+		}
+		c = strings.Compare(l, r)
+	default:
+		return nil, fmt.Errorf("cannot order %s", typeName(l))
+	}
+	switch op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	}
+	return c >= 0, nil
+}
+
+func arithmetic(op string, l, r any) (any, error) {
+	if ls, ok := l.(string); ok && op == "+" {
+		if rs, ok := r.(string); ok {
+			return ls + rs, nil
+		}
+	}
+	a, ok1 := l.(int64)
+	b, ok2 := r.(int64)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("cannot apply %s to %s and %s", op, typeName(l), typeName(r))
+	}
+	switch op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	}
+	if b == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	if op == "/" {
+		return a / b, nil
+	}
+	return a % b, nil
+}
+
+// typeName names the type of a value in error messages
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case string:
+		return "string"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
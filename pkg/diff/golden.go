@@ -0,0 +1,120 @@
+package diff
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// Golden renders the plan as canonical text for golden-file tests: one
+// block per resource change in plan order, marked + create, - delete,
+// ~ update or -/+ replace, with its severity, change types, reasons and
+// SQL. It has no timestamps, IDs or map-ordered values, so the same
+// schemas always render the same text.
+func (p *Plan) Golden() string {
+	if len(p.ResourceChanges) == 0 {
+		return "No changes.\n"
+	}
+
+	var b strings.Builder
+	destructive := ""
+	if p.Destructive {
+		destructive = ", destructive"
+	}
+	fmt.Fprintf(&b, "Plan format %s: %d resource changes%s\n", p.FormatVersion, len(p.ResourceChanges), destructive)
+
+	for _, rc := range p.ResourceChanges {
+		mark := "~"
+		switch strings.Join(rc.Change.Actions, ",") {
+		case ActionCreate:
+			mark = "+"
+		case ActionDelete:
+			mark = "-"
+		case ActionDelete + "," + ActionCreate:
+			mark = "-/+"
+		}
+		fmt.Fprintf(&b, "\n%s %s (%s)", mark, rc.Address, strings.Join(rc.Change.Actions, ", "))
+		if rc.PreviousAddress != "" {
+			fmt.Fprintf(&b, " from %s", rc.PreviousAddress)
+		}
+		fmt.Fprintf(&b, " [%s]", rc.Change.Severity)
+		if rc.Change.Destructive {
+			b.WriteString(" destructive")
+		}
+		b.WriteString("\n")
+
+		types := make([]string, len(rc.Change.ChangeTypes))
+		for i, t := range rc.Change.ChangeTypes {
+			types[i] = string(t)
+		}
+		fmt.Fprintf(&b, "    changes: %s\n", strings.Join(types, ", "))
+		if len(rc.Change.Reasons) > 0 {
+			reasons := make([]string, len(rc.Change.Reasons))
+			for i, r := range rc.Change.Reasons {
+				reasons[i] = string(r)
+			}
+			fmt.Fprintf(&b, "    reasons: %s\n", strings.Join(reasons, ", "))
+		}
+		for _, stmt := range rc.Change.SQL {
+			for line := range strings.SplitSeq(strings.TrimSpace(stmt), "\n") {
+				if line = strings.TrimRight(line, " \t\r"); line != "" {
+					b.WriteString("    | " + line + "\n")
+				} else {
+					b.WriteString("    |\n")
+				}
+			}
+		}
+	}
+	return b.String()
+}
+
+// GoldenPlan renders BuildPlan as canonical text, see Plan.Golden
+func GoldenPlan(from, to *schema.Database, changes []Change) string {
+	return BuildPlan(from, to, changes).Golden()
+}
+
+// CheckGolden compares got with the golden file at path, for snapshot
+// tests of plans. With update it writes got to the file instead, e.g. when
+// tests run with an -update flag. A mismatch is reported with the first
+// differing line.
+func CheckGolden(path, got string, update bool) error {
+	if update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			return fmt.Errorf("create golden directory: %w", err)
+		}
+		if err := os.WriteFile(filepath.Clean(path), []byte(got), 0o644); err != nil {
+			return fmt.Errorf("write golden file: %w", err)
+		}
+		return nil
+	}
+	want, err := os.ReadFile(filepath.Clean(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("golden file %s does not exist, run with update to create it", path)
+	}
+	if err != nil {
+		return fmt.Errorf("read golden file: %w", err)
+	}
+	if bytes.Equal(want, []byte(got)) {
+		return nil
+	}
+
+	wantLines := strings.Split(string(want), "\n")
+	gotLines := strings.Split(got, "\n")
+	for i := 0; ; i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g || i >= len(wantLines) || i >= len(gotLines) {
+			return fmt.Errorf("plan differs from golden file %s at line %d:\n  want: %s\n  got:  %s", path, i+1, w, g)
+		}
+	}
+}
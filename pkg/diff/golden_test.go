@@ -0,0 +1,70 @@
+package diff
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+func TestGoldenPlan(t *testing.T) {
+	from, err := parser.FromSQL(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, legacy TEXT);
+		CREATE TABLE old (id INTEGER);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := parser.FromSQL(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id));
+		CREATE INDEX idx_posts_user_id ON posts (user_id);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := GoldenPlan(from, to, Diff(from, to))
+	for _, want := range []string{
+		"Plan format 1.0: 4 resource changes, destructive\n",
+		"\n+ table.posts (create) [info]\n    changes: CREATE_TABLE\n",
+		"    | CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id));\n",
+		"\n- table.old (delete) [danger] destructive\n",
+		"\n+ index.idx_posts_user_id (create) [info]\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("GoldenPlan() =\n%s\nwant it to contain %q", got, want)
+		}
+	}
+	for i := range 5 {
+		if again := GoldenPlan(from, to, Diff(from, to)); again != got {
+			t.Fatalf("GoldenPlan() run %d =\n%s\nwant\n%s", i+2, again, got)
+		}
+	}
+
+	if got := GoldenPlan(to, to, Diff(to, to)); got != "No changes.\n" {
+		t.Errorf("GoldenPlan() without changes = %q", got)
+	}
+}
+
+func TestCheckGolden(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "testdata", "plan.golden")
+
+	if err := CheckGolden(path, "a\nb\n", false); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("CheckGolden() missing file error = %v", err)
+	}
+	if err := CheckGolden(path, "a\nb\n", true); err != nil {
+		t.Fatalf("CheckGolden() update error = %v", err)
+	}
+	if err := CheckGolden(path, "a\nb\n", false); err != nil {
+		t.Errorf("CheckGolden() same error = %v", err)
+	}
+	err := CheckGolden(path, "a\nc\n", false)
+	if err == nil || !strings.Contains(err.Error(), "line 2:\n  want: b\n  got:  c") {
+		t.Errorf("CheckGolden() mismatch error = %v", err)
+	}
+	if err := CheckGolden(path, "a\nb\nextra\n", false); err == nil {
+		t.Error("CheckGolden() extra lines error = nil")
+	}
+}
@@ -0,0 +1,149 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+// DataHook is a user-supplied data migration that runs right after a
+// specific schema change, in the same transaction. It only runs when the
+// plan contains a matching change, so it never runs twice for the same
+// schema change.
+type DataHook struct {
+	Name   string     // Shown in output and errors, e.g. the file name
+	After  ChangeType // Type of the change to run after
+	Object string     // Object of the change, usually a table name
+	Column string     // Optional column for column changes like ADD_COLUMN
+	SQL    string     // Statements to execute
+}
+
+// matches reports whether the hook is declared to run after c. A
+// RECREATE_TABLE hook also runs after column changes applied as recreates.
+func (h DataHook) matches(c Change) bool {
+	return (c.Type == h.After || h.After == RecreateTable && c.RecreatesTable()) &&
+		strings.EqualFold(c.Object, h.Object) &&
+		(h.Column == "" || strings.EqualFold(c.Column, h.Column))
+}
+
+// afterAnnotationRe matches the "-- @after: ADD_COLUMN users.status" header of a hook file
+var afterAnnotationRe = regexp.MustCompile(`(?m)^\s*--\s*@after:\s*([A-Za-z_]+)\s+(\S+)\s*$`)
+
+// LoadDataHooks reads data migration hooks from the .sql files in dir, in
+// file name order. Every file must declare the change it runs after:
+//
+//	-- @after: ADD_COLUMN users.status
+//	UPDATE users SET status = CASE WHEN legacy_active THEN 'active' ELSE 'inactive' END;
+//
+// If parser.SetBaseFS was called, reads from that filesystem instead.
+func LoadDataHooks(dir string) ([]DataHook, error) {
+	files, err := readSQLFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var hooks []DataHook
+	for _, file := range files {
+		hook, err := parseDataHook(file.name, file.content)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+type sqlFile struct {
+	name    string
+	content string
+}
+
+// readSQLFiles reads the .sql files directly in dir, in file name order.
+// A missing directory yields no files. If parser.SetBaseFS was called,
+// reads from that filesystem instead.
+func readSQLFiles(dir string) ([]sqlFile, error) {
+	fsys, root, err := parser.SchemaFS(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fs.ReadDir(fsys, root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %w", dir, err)
+	}
+
+	var files []sqlFile
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		content, err := fs.ReadFile(fsys, path.Join(root, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", filepath.Join(dir, entry.Name()), err)
+		}
+		files = append(files, sqlFile{name: entry.Name(), content: string(content)})
+	}
+	return files, nil
+}
+
+// parseDataHook parses a hook file with its @after header
+func parseDataHook(name, content string) (DataHook, error) {
+	m := afterAnnotationRe.FindStringSubmatch(content)
+	if m == nil {
+		return DataHook{}, fmt.Errorf("%s: missing \"-- @after: <CHANGE_TYPE> <object>\" header", name)
+	}
+
+	hook := DataHook{
+		Name:   name,
+		After:  ChangeType(strings.ToUpper(m[1])),
+		Object: m[2],
+		SQL:    strings.TrimSpace(content),
+	}
+	if hook.After == AddColumn || hook.After == RenameColumn {
+		hook.Object, hook.Column, _ = strings.Cut(m[2], ".")
+	}
+	if _, ok := changePriority[hook.After]; !ok {
+		return DataHook{}, fmt.Errorf("%s: unknown change type %q", name, m[1])
+	}
+	return hook, nil
+}
+
+// AttachDataHooks returns changes with a DATA_MIGRATION change inserted right
+// after the first change each hook is declared to run after. Hooks without a
+// matching change are not part of the plan.
+func AttachDataHooks(changes []Change, hooks []DataHook) []Change {
+	if len(hooks) == 0 {
+		return changes
+	}
+
+	attached := make([]bool, len(hooks))
+	result := make([]Change, 0, len(changes))
+	for _, c := range changes {
+		result = append(result, c)
+		for i, hook := range hooks {
+			if attached[i] || !hook.matches(c) {
+				continue
+			}
+			attached[i] = true
+			result = append(result, Change{
+				Type:        DataMigration,
+				Object:      c.Object,
+				Column:      c.Column,
+				Description: fmt.Sprintf("Run data migration %s", hook.Name),
+				SQL:         parser.SplitStatements(hook.SQL),
+				Destructive: false,
+				Severity:    SeverityWarn,
+				Reason:      DataHookMatched,
+			})
+		}
+	}
+	return result
+}
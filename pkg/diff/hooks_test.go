@@ -0,0 +1,156 @@
+package diff
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDataHook(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    DataHook
+		wantErr bool
+	}{
+		{
+			name:    "column hook",
+			content: "-- @after: ADD_COLUMN users.status\nUPDATE users SET status = 'active';",
+			want:    DataHook{After: AddColumn, Object: "users", Column: "status"},
+		},
+		{
+			name:    "table hook",
+			content: "-- Seed lookup values\n-- @after: create_table roles\nINSERT INTO roles (name) VALUES ('admin');",
+			want:    DataHook{After: CreateTable, Object: "roles"},
+		},
+		{
+			name:    "dotted object outside column changes",
+			content: "-- @after: RECREATE_TABLE a.b\nSELECT 1;",
+			want:    DataHook{After: RecreateTable, Object: "a.b"},
+		},
+		{
+			name:    "missing header",
+			content: "UPDATE users SET status = 'active';",
+			wantErr: true,
+		},
+		{
+			name:    "unknown change type",
+			content: "-- @after: ALTER_TABLE users\nSELECT 1;",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDataHook("hook.sql", tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDataHook() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.After != tt.want.After || got.Object != tt.want.Object || got.Column != tt.want.Column {
+				t.Errorf("parseDataHook() = %+v, want %+v", got, tt.want)
+			}
+			if got.SQL != strings.TrimSpace(tt.content) {
+				t.Errorf("SQL = %q, want the file content", got.SQL)
+			}
+		})
+	}
+}
+
+func TestAttachDataHooks(t *testing.T) {
+	changes := []Change{
+		{Type: AddColumn, Object: "users", Column: "name"},
+		{Type: AddColumn, Object: "users", Column: "status"},
+		{Type: CreateIndex, Object: "idx_users_status"},
+	}
+	hooks := []DataHook{
+		{Name: "status.sql", After: AddColumn, Object: "users", Column: "status", SQL: "UPDATE 1"},
+		{Name: "any.sql", After: AddColumn, Object: "USERS", SQL: "UPDATE 2; UPDATE 3"},
+		{Name: "unmatched.sql", After: DropTable, Object: "users", SQL: "UPDATE 3"},
+	}
+
+	got := AttachDataHooks(changes, hooks)
+
+	var types []string
+	for _, c := range got {
+		types = append(types, string(c.Type)+" "+c.Column+" "+strings.Join(c.SQL, "|"))
+	}
+	want := []string{
+		"ADD_COLUMN name ",
+		"DATA_MIGRATION name UPDATE 2;|UPDATE 3;",
+		"ADD_COLUMN status ",
+		"DATA_MIGRATION status UPDATE 1;",
+		"CREATE_INDEX  ",
+	}
+	if strings.Join(types, "\n") != strings.Join(want, "\n") {
+		t.Errorf("AttachDataHooks() =\n%s\nwant\n%s", strings.Join(types, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestBatchChanges_KeepsDataMigrationWithChange(t *testing.T) {
+	changes := []Change{
+		{Type: AddColumn, Object: "a"},
+		{Type: DataMigration, Object: "a"},
+		{Type: AddColumn, Object: "b"},
+	}
+	batches := batchChanges(changes, 1)
+	if len(batches) != 2 || len(batches[0]) != 2 || batches[0][1].Type != DataMigration {
+		t.Errorf("batchChanges() = %+v, want the data migration in the first batch", batches)
+	}
+}
+
+func TestApply_DataHooks(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, legacy_active INTEGER);
+		INSERT INTO users (legacy_active) VALUES (1), (0);
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(
+		t,
+		"users.sql",
+		`CREATE TABLE users (id INTEGER PRIMARY KEY, legacy_active INTEGER, status TEXT);`,
+	)
+
+	dataDir := t.TempDir()
+	hook := `-- @after: ADD_COLUMN users.status
+UPDATE users SET status = CASE WHEN legacy_active THEN 'active' ELSE 'inactive' END;`
+	if err := os.WriteFile(filepath.Join(dataDir, "001_status.sql"), []byte(hook), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	hooks, err := LoadDataHooks(dataDir)
+	if err != nil {
+		t.Fatalf("LoadDataHooks() error: %v", err)
+	}
+	if len(hooks) != 1 || hooks[0].Name != "001_status.sql" {
+		t.Fatalf("LoadDataHooks() = %+v", hooks)
+	}
+
+	// A failing hook rolls back the schema change it belongs to
+	failing := []DataHook{{Name: "bad.sql", After: AddColumn, Object: "users", SQL: "UPDATE nope SET x = 1;"}}
+	if err := Apply(db, schemaDir, ApplyOptions{DataHooks: failing}); err == nil {
+		t.Fatal("expected failing data hook to fail the apply")
+	}
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM pragma_table_info('users') WHERE name = 'status'").
+		Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("column should not be added when its data hook fails")
+	}
+
+	if err := Apply(db, schemaDir, ApplyOptions{DataHooks: hooks}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var status string
+	if err := db.QueryRow("SELECT group_concat(status, ',') FROM users").Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status != "active,inactive" {
+		t.Errorf("status = %q, want active,inactive", status)
+	}
+}
@@ -0,0 +1,183 @@
+package diff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// ErrQueriesBroken is returned when a plan breaks application queries
+var ErrQueriesBroken = errors.New("plan breaks application queries")
+
+// Query is a query of the application the schema has to keep serving
+type Query struct {
+	Name string // sqlc "-- name:" annotation, or file:line
+	File string
+	Line int
+	SQL  string
+}
+
+var (
+	// queryNameRe matches the "-- name: GetUser :one" annotation of sqlc
+	queryNameRe = regexp.MustCompile(`--\s*name:\s*(\S+)`)
+	// sqlcArgRe matches the sqlc.arg, sqlc.narg and sqlc.slice macros
+	sqlcArgRe = regexp.MustCompile(`(?i)\bsqlc\.(?:n?arg|slice)\(\s*['"]?(\w+)['"]?\s*\)`)
+	// sqlcEmbedRe matches the sqlc.embed macro
+	sqlcEmbedRe = regexp.MustCompile(`(?i)\bsqlc\.embed\(\s*(\w+)\s*\)`)
+	// missingObjectRe matches the object SQLite could not find
+	missingObjectRe = regexp.MustCompile(`no such (table|column): (?:\w+\.)?(\w+)|has no column named (\w+)`)
+)
+
+// LoadQueries reads the queries of the .sql files directly in dir, e.g. the
+// queries directory of sqlc, one query per statement. Queries are named by
+// their sqlc "-- name:" annotation and the sqlc.arg, sqlc.narg, sqlc.slice
+// and sqlc.embed macros are rewritten to plain SQLite, so they prepare
+// without sqlc. A missing directory yields no queries.
+func LoadQueries(dir string) ([]Query, error) {
+	files, err := readSQLFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var queries []Query
+	for _, file := range files {
+		offset := 0
+		for _, stmt := range parser.SplitStatements(file.content) {
+			start := offset
+			if i := strings.Index(file.content[offset:], strings.TrimSuffix(stmt, ";")); i >= 0 {
+				start = offset + i
+			}
+			q := Query{
+				File: filepath.Join(dir, file.name),
+				Line: 1 + strings.Count(file.content[:start], "\n"),
+				SQL:  sqlcToSQLite(stmt),
+			}
+			q.Name = fmt.Sprintf("%s:%d", file.name, q.Line)
+			if m := queryNameRe.FindAllStringSubmatch(file.content[offset:start], -1); m != nil {
+				q.Name = m[len(m)-1][1]
+			}
+			queries = append(queries, q)
+			offset = start + len(strings.TrimSuffix(stmt, ";"))
+		}
+	}
+	return queries, nil
+}
+
+// sqlcToSQLite replaces the sqlc macros in a query with named parameters
+// and table.* columns
+func sqlcToSQLite(query string) string {
+	query = sqlcArgRe.ReplaceAllString(query, "@$1")
+	return sqlcEmbedRe.ReplaceAllString(query, "$1.*")
+}
+
+// BrokenQuery is a query a plan breaks
+type BrokenQuery struct {
+	Query
+	Err     error    // Error preparing the query after the plan
+	Changes []Change // Changes likely responsible, may be empty
+}
+
+func (b BrokenQuery) String() string {
+	s := fmt.Sprintf("%s (%s:%d): %v", b.Name, b.File, b.Line, b.Err)
+	for _, c := range b.Changes {
+		s += "\n    caused by " + c.Description
+	}
+	return s
+}
+
+// QueryImpact applies a plan to an in-memory copy of the current schema and
+// prepares every query against the result, so queries using dropped or
+// renamed tables and columns are found before the plan is applied. It
+// returns the queries that prepare against the current schema and fail
+// after the plan; queries that are already broken are not reported.
+func QueryImpact(current *schema.Database, changes []Change, queries []Query) ([]BrokenQuery, error) {
+	if len(queries) == 0 {
+		return nil, nil
+	}
+	db, err := buildDatabase(current)
+	if err != nil {
+		return nil, fmt.Errorf("build current schema: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	prepares := func(q Query) error {
+		stmt, err := db.Prepare(q.SQL)
+		if err != nil {
+			return err
+		}
+		return stmt.Close()
+	}
+	var working []Query
+	for _, q := range queries {
+		if prepares(q) == nil {
+			working = append(working, q)
+		}
+	}
+
+	if _, err := db.Exec("PRAGMA foreign_keys = OFF"); err != nil {
+		return nil, fmt.Errorf("disable foreign keys: %w", err)
+	}
+	if err := executeChanges(context.Background(), db, changes, nil); err != nil {
+		return nil, fmt.Errorf("apply plan in memory: %w", err)
+	}
+
+	var broken []BrokenQuery
+	for _, q := range working {
+		if err := prepares(q); err != nil {
+			broken = append(broken, BrokenQuery{Query: q, Err: err, Changes: queryCauses(q, err, changes)})
+		}
+	}
+	return broken, nil
+}
+
+// queryCauses returns the changes a broken query most likely fails on: the
+// changes to the table SQLite names, or for a missing column the changes
+// to the columns of the tables the query mentions
+func queryCauses(q Query, err error, changes []Change) []Change {
+	mentions := func(name string) bool {
+		re := regexp.MustCompile(`(?i)(^|[^\w])["\x60\[]?` + regexp.QuoteMeta(name) + `["\x60\]]?([^\w]|$)`)
+		return re.MatchString(q.SQL)
+	}
+	m := missingObjectRe.FindStringSubmatch(err.Error())
+
+	var causes []Change
+	for _, c := range changes {
+		var cause bool
+		switch {
+		case m != nil && m[1] == "table":
+			cause = strings.EqualFold(c.Object, m[2])
+		case slices.Contains([]ChangeType{DropColumn, RenameColumn, RecreateTable}, c.Type):
+			cause = mentions(c.Object)
+		default:
+			cause = m == nil && (c.Type == DropTable || c.Type == RenameTable) && mentions(c.Object)
+		}
+		if cause {
+			causes = append(causes, c)
+		}
+	}
+	return causes
+}
+
+// CheckQueries fails with ErrQueriesBroken if the plan breaks any of the
+// queries (see QueryImpact)
+func CheckQueries(current *schema.Database, changes []Change, queries []Query) error {
+	broken, err := QueryImpact(current, changes, queries)
+	if err != nil {
+		return err
+	}
+	if len(broken) > 0 {
+		lines := make([]string, len(broken))
+		for i, b := range broken {
+			lines[i] = b.String()
+		}
+		return fmt.Errorf("%w:\n  %s", ErrQueriesBroken, strings.Join(lines, "\n  "))
+	}
+	return nil
+}
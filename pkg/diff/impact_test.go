@@ -0,0 +1,110 @@
+package diff
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+func TestLoadQueries(t *testing.T) {
+	dir := createSchemaDir(t, "users.sql", `-- name: GetUser :one
+SELECT id, email FROM users
+WHERE id = sqlc.arg(id);
+
+-- name: ListUsers :many
+SELECT sqlc.embed(users) FROM users WHERE id IN (sqlc.slice('ids'));
+
+DELETE FROM users WHERE id = ?;
+`)
+	queries, err := LoadQueries(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, q := range queries {
+		got = append(got, q.Name+" "+q.File+" "+q.SQL)
+	}
+	file := filepath.Join(dir, "users.sql")
+	want := []string{
+		"GetUser " + file + " SELECT id, email FROM users\nWHERE id = @id;",
+		"ListUsers " + file + " SELECT users.* FROM users WHERE id IN (@ids);",
+		"users.sql:8 " + file + " DELETE FROM users WHERE id = ?;",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("LoadQueries() = %q, want %q", got, want)
+	}
+	if queries[1].Line != 6 {
+		t.Errorf("ListUsers line = %d, want 6", queries[1].Line)
+	}
+}
+
+func TestQueryImpact(t *testing.T) {
+	current, err := parser.FromSQL(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, name TEXT);
+		CREATE TABLE sessions (id INTEGER PRIMARY KEY, user_id INTEGER);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := parser.FromSQL(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := Diff(current, target)
+	queries := []Query{
+		{Name: "GetUser", SQL: "SELECT id, name FROM users WHERE id = @id"},
+		{Name: "GetEmail", SQL: "SELECT email FROM users WHERE id = ?"},
+		{Name: "CountSessions", SQL: "SELECT count(*) FROM sessions"},
+		{Name: "AlreadyBroken", SQL: "SELECT missing FROM users"},
+	}
+
+	broken, err := QueryImpact(current, changes, queries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, b := range broken {
+		names = append(names, b.Name)
+		if len(b.Changes) == 0 {
+			t.Errorf("%s: no causing change found for %v", b.Name, b.Err)
+		}
+	}
+	if !slices.Equal(names, []string{"GetEmail", "CountSessions"}) {
+		t.Errorf("broken queries = %q, want GetEmail and CountSessions", names)
+	}
+	if broken[1].Changes[0].Type != DropTable {
+		t.Errorf("CountSessions caused by %+v, want the dropped table", broken[1].Changes)
+	}
+
+	err = CheckQueries(current, changes, queries)
+	if !errors.Is(err, ErrQueriesBroken) || !strings.Contains(err.Error(), "no such column: email") {
+		t.Errorf("CheckQueries() error = %v, want the missing email column", err)
+	}
+	if err := CheckQueries(current, changes, queries[:1]); err != nil {
+		t.Errorf("CheckQueries() of a working query: %v", err)
+	}
+}
+
+func TestApply_Queries(t *testing.T) {
+	db := openTestDB(t, `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "schema.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+
+	opts := ApplyOptions{Queries: []Query{{Name: "GetEmail", SQL: "SELECT email FROM users"}}}
+	if err := Apply(db, schemaDir, opts); !errors.Is(err, ErrQueriesBroken) {
+		t.Fatalf("Apply() error = %v, want ErrQueriesBroken", err)
+	}
+	current, err := parser.FromDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !current.Tables["users"].HasColumn("email") {
+		t.Error("refused plan was applied")
+	}
+}
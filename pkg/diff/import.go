@@ -0,0 +1,303 @@
+package diff
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// ImportOptions configures ImportFile
+type ImportOptions struct {
+	// Table is the table to load, by default the file name without its
+	// extension and without the time prefix of ExportLostData files
+	Table string
+	// Format is the file format, by default from the extension: .jsonl and
+	// .ndjson are JSON lines, anything else CSV with a header row
+	Format ExportFormat
+	// Columns maps fields of the file to columns named differently
+	Columns map[string]string
+}
+
+// exportStampRe matches the time prefix of ExportLostData file names
+var exportStampRe = regexp.MustCompile(`^\d{8}T\d{6}Z_`)
+
+// ImportFile loads a CSV or JSONL file into a table of the schema in one
+// transaction and returns the number of rows inserted. Fields are matched
+// to columns by name, ignoring case, and converted to the column's type
+// affinity: numbers for INTEGER and REAL columns, hex (CSV) or base64
+// (JSONL) for BLOB columns. An empty CSV field is NULL, or an empty string
+// for a NOT NULL text column. Files written by ExportLostData load back
+// unchanged.
+func ImportFile(db *sql.DB, s *schema.Database, path string, opts ImportOptions) (n int64, err error) {
+	base := filepath.Base(path)
+	ext := strings.ToLower(filepath.Ext(base))
+	if opts.Table == "" {
+		opts.Table = exportStampRe.ReplaceAllString(strings.TrimSuffix(base, filepath.Ext(base)), "")
+	}
+	if opts.Format == "" && (ext == ".jsonl" || ext == ".ndjson") {
+		opts.Format = ExportJSONL
+	}
+	table := s.Tables[opts.Table]
+	if table == nil {
+		return 0, fmt.Errorf("import %s: table %q is not in the schema", path, opts.Table)
+	}
+
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return 0, fmt.Errorf("import %s: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var rows rowReader
+	switch opts.Format {
+	case ExportCSV:
+		rows, err = newCSVRows(f)
+	case ExportJSONL:
+		rows = newJSONRows(f)
+	default:
+		return 0, fmt.Errorf("invalid import format %q: must be csv or jsonl", opts.Format)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("import %s: %w", path, err)
+	}
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			n = 0
+		}
+	}()
+
+	// Statements are prepared per distinct set of fields, JSON lines may
+	// differ from row to row
+	stmts := map[string]*sql.Stmt{}
+	defer func() {
+		for _, stmt := range stmts {
+			_ = stmt.Close()
+		}
+	}()
+
+	for line := 1; ; line++ {
+		fields, values, rerr := rows.next()
+		if errors.Is(rerr, io.EOF) {
+			break
+		}
+		if rerr != nil {
+			return n, fmt.Errorf("import %s: row %d: %w", path, line, rerr)
+		}
+
+		cols := make([]*schema.Column, len(fields))
+		names := make([]string, len(fields))
+		args := make([]any, len(fields))
+		for i, field := range fields {
+			if cols[i], err = importColumn(table, field, opts.Columns); err != nil {
+				return n, fmt.Errorf("import %s: %w", path, err)
+			}
+			names[i] = fmt.Sprintf("%q", cols[i].Name)
+			if args[i], err = coerce(*cols[i], values[i], opts.Format); err != nil {
+				return n, fmt.Errorf("import %s: row %d: column %q: %w", path, line, cols[i].Name, err)
+			}
+		}
+
+		key := strings.Join(names, ",")
+		stmt := stmts[key]
+		if stmt == nil {
+			placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+			stmt, err = tx.PrepareContext(ctx, fmt.Sprintf(
+				"INSERT INTO %q (%s) VALUES (%s)", table.Name, strings.Join(names, ", "), placeholders,
+			))
+			if err != nil {
+				return n, fmt.Errorf("import %s: %w", path, err)
+			}
+			stmts[key] = stmt
+		}
+		if _, err = stmt.ExecContext(ctx, args...); err != nil {
+			return n, fmt.Errorf("import %s: row %d: %w", path, line, err)
+		}
+		n++
+	}
+	if err = tx.Commit(); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// importColumn finds the column a field of an imported file is loaded into
+func importColumn(table *schema.Table, field string, mapping map[string]string) (*schema.Column, error) {
+	name := field
+	if mapped, ok := mapping[field]; ok {
+		name = mapped
+	}
+	for i, col := range table.Columns {
+		if strings.EqualFold(col.Name, name) {
+			if col.Hidden != 0 {
+				return nil, fmt.Errorf("column %q of table %q is generated", col.Name, table.Name)
+			}
+			return &table.Columns[i], nil
+		}
+	}
+	if strings.EqualFold(name, "rowid") {
+		return &schema.Column{Name: "rowid", Type: "INTEGER"}, nil
+	}
+	return nil, fmt.Errorf("table %q has no column %q", table.Name, name)
+}
+
+// affinity returns the type affinity SQLite gives a declared column type
+func affinity(declared string) string {
+	t := strings.ToUpper(declared)
+	switch {
+	case strings.Contains(t, "INT"):
+		return "INTEGER"
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"):
+		return "TEXT"
+	case t == "" || strings.Contains(t, "BLOB"):
+		return "BLOB"
+	case strings.Contains(t, "REAL"), strings.Contains(t, "FLOA"), strings.Contains(t, "DOUB"):
+		return "REAL"
+	}
+	return "NUMERIC"
+}
+
+// coerce converts a field value to the column's affinity. CSV values are
+// strings or nil for empty fields; JSON values are decoded with UseNumber.
+func coerce(col schema.Column, v any, format ExportFormat) (any, error) {
+	aff := affinity(col.Type)
+	switch v := v.(type) {
+	case nil:
+		if format == ExportCSV && col.NotNull && aff == "TEXT" {
+			return "", nil
+		}
+		return nil, nil
+	case bool:
+		if v {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case json.Number:
+		return coerceString(aff, v.String(), false)
+	case string:
+		if aff == "BLOB" && col.Type != "" {
+			if format == ExportJSONL {
+				return base64.StdEncoding.DecodeString(v)
+			}
+			return hex.DecodeString(v)
+		}
+		return coerceString(aff, v, true)
+	default:
+		// Objects and arrays are stored as JSON text
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+}
+
+func coerceString(aff, s string, quoted bool) (any, error) {
+	switch aff {
+	case "INTEGER":
+		if i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", s)
+		}
+		return f, nil
+	case "REAL":
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid real %q", s)
+		}
+		return f, nil
+	case "NUMERIC", "BLOB":
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil && (aff == "NUMERIC" || !quoted) {
+			return i, nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil && (aff == "NUMERIC" || !quoted) {
+			return f, nil
+		}
+	}
+	return s, nil
+}
+
+// rowReader reads the fields and values of the rows of an imported file
+type rowReader interface {
+	next() (fields []string, values []any, err error)
+}
+
+type csvRows struct {
+	r      *csv.Reader
+	header []string
+}
+
+func newCSVRows(r io.Reader) (*csvRows, error) {
+	cr := csv.NewReader(bufio.NewReader(r))
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("empty file, want a header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+	header[0] = strings.TrimPrefix(header[0], "\uFEFF") // Byte order mark
+	return &csvRows{r: cr, header: header}, nil
+}
+
+func (c *csvRows) next() ([]string, []any, error) {
+	record, err := c.r.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	values := make([]any, len(record))
+	for i, v := range record {
+		if v != "" {
+			values[i] = v
+		}
+	}
+	return c.header, values, nil
+}
+
+type jsonRows struct {
+	dec *json.Decoder
+}
+
+func newJSONRows(r io.Reader) *jsonRows {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	dec.UseNumber()
+	return &jsonRows{dec: dec}
+}
+
+func (j *jsonRows) next() ([]string, []any, error) {
+	var row map[string]any
+	if err := j.dec.Decode(&row); err != nil {
+		return nil, nil, err
+	}
+	fields := make([]string, 0, len(row))
+	for field := range row {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+	values := make([]any, len(fields))
+	for i, field := range fields {
+		values[i] = row[field]
+	}
+	return fields, values, nil
+}
@@ -0,0 +1,167 @@
+package diff
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+const importSchema = `
+	CREATE TABLE items (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		price REAL,
+		qty INT,
+		code NUMERIC,
+		data BLOB,
+		total REAL GENERATED ALWAYS AS (price * qty)
+	);`
+
+func TestImportFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		opts    ImportOptions
+		want    []string // Rows as typeof/quote pairs
+		wantErr string
+	}{
+		{
+			name:    "csv",
+			file:    "items.csv",
+			content: "ID,name,price,qty,code,data\n1,apple,1.5,3,007,00ff\n2,,,,abc,\n",
+			want: []string{
+				"1|'apple'|1.5|3|7|X'00FF'",
+				"2|''|NULL|NULL|'abc'|NULL",
+			},
+		},
+		{
+			name: "jsonl",
+			file: "items.jsonl",
+			content: `{"id":1,"name":"pear","price":2,"qty":"4","data":"AP8="}` + "\n" +
+				`{"id":2,"name":"fig","code":true,"data":null}` + "\n",
+			want: []string{
+				"1|'pear'|2.0|4|NULL|X'00FF'",
+				"2|'fig'|NULL|NULL|1|NULL",
+			},
+		},
+		{
+			name:    "export file name and mapping",
+			file:    "20260102T030405Z_items.csv",
+			content: "id,title\n1,kiwi\n",
+			opts:    ImportOptions{Columns: map[string]string{"title": "name"}},
+			want:    []string{"1|'kiwi'|NULL|NULL|NULL|NULL"},
+		},
+		{
+			name:    "explicit table and format",
+			file:    "data.txt",
+			content: `{"id":1,"name":"plum"}`,
+			opts:    ImportOptions{Table: "items", Format: ExportJSONL},
+			want:    []string{"1|'plum'|NULL|NULL|NULL|NULL"},
+		},
+		{
+			name:    "unknown column",
+			file:    "items.csv",
+			content: "id,color\n1,red\n",
+			wantErr: `table "items" has no column "color"`,
+		},
+		{
+			name:    "generated column",
+			file:    "items.csv",
+			content: "id,name,total\n1,a,2\n",
+			wantErr: `column "total" of table "items" is generated`,
+		},
+		{
+			name:    "invalid integer rolls back",
+			file:    "items.csv",
+			content: "id,name,qty\n1,a,2\n2,b,many\n",
+			wantErr: `row 2: column "qty": invalid integer "many"`,
+		},
+		{
+			name:    "unknown table",
+			file:    "orders.csv",
+			content: "id\n1\n",
+			wantErr: `table "orders" is not in the schema`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := createTestDBWithPath(t, importSchema)
+			defer func() { _ = db.Close() }()
+			s, err := parser.FromSQL(importSchema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			n, err := ImportFile(db, s, path, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ImportFile() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("ImportFile() error = %v", err)
+			}
+			if n != int64(len(tt.want)) {
+				t.Errorf("ImportFile() = %d rows, want %d", n, len(tt.want))
+			}
+
+			got, err := queryStrings(db, `SELECT id, quote(name), quote(price), quote(qty), quote(code), quote(data) FROM items ORDER BY id`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("rows =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestImportFile_ExportRoundTrip(t *testing.T) {
+	for name, format := range map[string]ExportFormat{"csv": ExportCSV, "jsonl": ExportJSONL} {
+		t.Run(name, func(t *testing.T) {
+			db, _ := createTestDBWithPath(t, `
+				CREATE TABLE logs (id INTEGER PRIMARY KEY, msg TEXT, data BLOB, n REAL);
+				INSERT INTO logs VALUES (1, 'a, "b"', x'00ff', 1.25), (2, NULL, NULL, NULL);
+			`)
+			defer func() { _ = db.Close() }()
+			current, err := parser.FromDB(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target, err := parser.FromSQL("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := queryStrings(db, `SELECT id, quote(msg), quote(data), quote(n) FROM logs ORDER BY id`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			paths, err := ExportLostData(db, Diff(current, target), t.TempDir(), format)
+			if err != nil || len(paths) != 1 {
+				t.Fatalf("ExportLostData() = %v, %v", paths, err)
+			}
+			if _, err := db.Exec(`DELETE FROM logs`); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ImportFile(db, current, paths[0], ImportOptions{}); err != nil {
+				t.Fatalf("ImportFile() error = %v", err)
+			}
+
+			got, err := queryStrings(db, `SELECT id, quote(msg), quote(data), quote(n) FROM logs ORDER BY id`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("rows =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+		})
+	}
+}
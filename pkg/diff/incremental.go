@@ -0,0 +1,60 @@
+package diff
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// ObjectHashes returns a SHA-256 hash of the normalized SQL of every object
+// in a schema, keyed by kind and name, e.g. "table users". Objects with the
+// same hash are equal to the diff, however their SQL is formatted.
+func ObjectHashes(s *schema.Database) map[string]string {
+	hashes := make(map[string]string, len(s.Tables)+len(s.Indexes)+len(s.Views)+len(s.Triggers))
+	add := func(kind, name, sql string) {
+		sum := sha256.Sum256([]byte(normalizeSQL(sql)))
+		hashes[kind+" "+name] = hex.EncodeToString(sum[:])
+	}
+	for name, t := range s.Tables {
+		add("table", name, t.SQL)
+	}
+	for name, idx := range s.Indexes {
+		add("index", name, idx.SQL)
+	}
+	for name, v := range s.Views {
+		add("view", name, v.SQL)
+	}
+	for name, tr := range s.Triggers {
+		add("trigger", name, tr.SQL)
+	}
+	return hashes
+}
+
+// extractIncremental reads the current schema of db for a diff against
+// target, whose ObjectHashes are targetHashes. Tables whose stored SQL hashes
+// the same as in the target are equal to it, so their columns are taken from
+// the target instead of being queried; only the others are read.
+func extractIncremental(ctx context.Context, db *sql.DB, target *schema.Database, targetHashes map[string]string) (*schema.Database, error) {
+	current, err := parser.FromDBWithoutColumnsContext(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []*schema.Table
+	for name, table := range current.Tables {
+		sum := sha256.Sum256([]byte(normalizeSQL(table.SQL)))
+		if hash, ok := targetHashes["table "+name]; ok && hash == hex.EncodeToString(sum[:]) {
+			table.Columns = target.Tables[name].Clone().Columns
+			continue
+		}
+		changed = append(changed, table)
+	}
+	if err := parser.ReadColumnsContext(ctx, db, changed...); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
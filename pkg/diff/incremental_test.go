@@ -0,0 +1,56 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+func TestCompare_Incremental(t *testing.T) {
+	db := openTestDB(t, `
+		CREATE TABLE "users" (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER, body TEXT);
+		CREATE INDEX idx_posts_user ON posts(user_id);
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "schema.sql", `
+		-- Formatted differently, but the same table
+		CREATE TABLE users (
+			id   INTEGER PRIMARY KEY,
+			name TEXT NOT NULL
+		);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER, body TEXT NOT NULL DEFAULT '');
+		CREATE INDEX idx_posts_user ON posts(user_id);
+		CREATE TABLE tags (id INTEGER PRIMARY KEY);
+	`)
+
+	full, err := CompareWithOptions(db, schemaDir, DiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	incremental, err := CompareWithOptions(db, schemaDir, DiffOptions{Incremental: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if GenerateSQL(incremental) != GenerateSQL(full) {
+		t.Errorf("incremental plan differs:\n%s\nwant:\n%s", GenerateSQL(incremental), GenerateSQL(full))
+	}
+	if len(full) != 3 {
+		t.Errorf("got %d changes, want 3 (recreate posts and its index, create tags)", len(full))
+	}
+}
+
+func TestObjectHashes(t *testing.T) {
+	a, err := parser.FromSQL(`CREATE TABLE "users" (id INTEGER PRIMARY KEY); CREATE INDEX idx ON users(id);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := parser.FromSQL("CREATE TABLE users (\n  id INTEGER PRIMARY KEY -- key\n);\nCREATE INDEX idx ON users (id);")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ha, hb := ObjectHashes(a), ObjectHashes(b)
+	if len(ha) != 2 || ha["table users"] != hb["table users"] || ha["index idx"] != hb["index idx"] {
+		t.Errorf("hashes of equivalent schemas differ: %v vs %v", ha, hb)
+	}
+}
@@ -0,0 +1,123 @@
+package diff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+// InitOptions configures InitDatabase
+type InitOptions struct {
+	DiffOptions
+
+	// Pragmas run on the new database before anything is created, as
+	// "name = value", e.g. "page_size = 8192" or "journal_mode = WAL"
+	Pragmas []string
+
+	// SeedDir holds .sql files run in file name order after the schema is
+	// created, in the same transaction, e.g. to fill lookup tables. If
+	// parser.SetBaseFS was called, reads from that filesystem instead.
+	SeedDir string
+
+	// OnCommit is called with the baseline changes once they are committed,
+	// e.g. to record them in the change log (see ChangeLogWriter)
+	OnCommit func(changes []Change)
+}
+
+// pragmaRe matches a "name = value" pragma setting
+var pragmaRe = regexp.MustCompile(`^\s*([A-Za-z_]+)\s*=\s*('[^']*'|[A-Za-z0-9_.+-]+)\s*$`)
+
+// InitDatabase creates a new database at path directly from a schema
+// directory: the pragmas are set, the schema is created with the plan apply
+// would make for an empty database, then the seeds run and the checks in
+// the schema directory must pass before anything is committed. An existing
+// non-empty file is refused. If creating the database fails, the file is
+// removed again.
+func InitDatabase(path, schemaDir string, opts InitOptions) error {
+	return InitDatabaseContext(context.Background(), path, schemaDir, opts)
+}
+
+// InitDatabaseContext is InitDatabase with a context. Cancelling it rolls
+// back the setup and removes the file.
+func InitDatabaseContext(ctx context.Context, path, schemaDir string, opts InitOptions) (err error) {
+	if info, statErr := os.Stat(path); statErr == nil && info.Size() > 0 {
+		return fmt.Errorf("database %s already exists", path)
+	}
+	for _, p := range opts.Pragmas {
+		if !pragmaRe.MatchString(p) {
+			return fmt.Errorf("invalid pragma %q: must be name = value", p)
+		}
+	}
+	var seeds []sqlFile
+	if opts.SeedDir != "" {
+		if seeds, err = readSQLFiles(opts.SeedDir); err != nil {
+			return fmt.Errorf("read seeds: %w", err)
+		}
+	}
+	checks, err := LoadChecks(schemaDir)
+	if err != nil {
+		return fmt.Errorf("load checks: %w", err)
+	}
+
+	db, err := parser.Open(filepath.Clean(path))
+	if err != nil {
+		return fmt.Errorf("create database: %w", err)
+	}
+	// One connection, so pragmas set on it apply to the whole setup
+	db.SetMaxOpenConns(1)
+	defer func() {
+		err = errors.Join(err, db.Close())
+		if err != nil {
+			for _, p := range []string{path, path + "-journal", path + "-wal", path + "-shm"} {
+				_ = os.Remove(p)
+			}
+		}
+	}()
+
+	for _, p := range opts.Pragmas {
+		if _, err := db.ExecContext(ctx, "PRAGMA "+p); err != nil {
+			return fmt.Errorf("pragma %s: %w", p, err)
+		}
+	}
+
+	changes, err := CompareContext(ctx, db, schemaDir, opts.DiffOptions)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := executeChanges(ctx, tx, changes, opts.OnEvent); err != nil {
+		return err
+	}
+	for _, seed := range seeds {
+		if _, err := tx.ExecContext(ctx, seed.content); err != nil {
+			return fmt.Errorf("seed %s: %w", seed.name, err)
+		}
+	}
+	if err := checkForeignKeys(ctx, tx); err != nil {
+		return err
+	}
+	if err := runChecks(ctx, tx, checks); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	if opts.OnCommit != nil {
+		opts.OnCommit(changes)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package diff
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+func TestInitDatabase(t *testing.T) {
+	schemaDir := createSchemaDir(t, "schema.sql", `
+		CREATE TABLE roles (id INTEGER PRIMARY KEY, name TEXT NOT NULL UNIQUE);
+		CREATE TABLE users (id INTEGER PRIMARY KEY, role_id INTEGER REFERENCES roles(id));
+		CREATE INDEX idx_users_role ON users(role_id);
+	`)
+	seedDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(seedDir, "01_roles.sql"), []byte(`INSERT INTO roles (name) VALUES ('admin'), ('user');`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "new.db")
+
+	var baseline []Change
+	opts := InitOptions{
+		Pragmas:  []string{"journal_mode = WAL", "user_version = 3"},
+		SeedDir:  seedDir,
+		OnCommit: func(changes []Change) { baseline = changes },
+	}
+	if err := InitDatabase(path, schemaDir, opts); err != nil {
+		t.Fatalf("InitDatabase() error: %v", err)
+	}
+	if len(baseline) != 3 {
+		t.Errorf("baseline has %d changes, want 3", len(baseline))
+	}
+
+	db, err := parser.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = db.Close() }()
+
+	changes, err := Compare(db, schemaDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("initialized database differs from the schema: %v", changes)
+	}
+	var roles, version int
+	var mode string
+	if err := db.QueryRow("SELECT count(*) FROM roles").Scan(&roles); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil {
+		t.Fatal(err)
+	}
+	if roles != 2 || version != 3 || !strings.EqualFold(mode, "wal") {
+		t.Errorf("got %d roles, user_version %d, journal_mode %q; want 2, 3, wal", roles, version, mode)
+	}
+
+	if err := InitDatabase(path, schemaDir, InitOptions{}); err == nil {
+		t.Error("expected an existing database to be refused")
+	}
+}
+
+func TestInitDatabase_FailureRemovesFile(t *testing.T) {
+	schemaDir := createSchemaDir(t, "schema.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, role_id INTEGER REFERENCES roles(id));
+		CREATE TABLE roles (id INTEGER PRIMARY KEY);`)
+	seedDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(seedDir, "users.sql"), []byte(`INSERT INTO users (role_id) VALUES (42);`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "new.db")
+
+	if err := InitDatabase(path, schemaDir, InitOptions{SeedDir: seedDir}); err == nil {
+		t.Fatal("expected a seed violating a foreign key to fail")
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("database left behind after a failed init: %v", err)
+	}
+
+	if err := InitDatabase(path, schemaDir, InitOptions{Pragmas: []string{"user_version = 1; DROP TABLE x"}}); err == nil {
+		t.Error("expected an invalid pragma to be refused")
+	}
+}
+
+func TestInitDatabaseContext_Cancelled(t *testing.T) {
+	schemaDir := createSchemaDir(t, "schema.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY);
+		CREATE TABLE roles (id INTEGER PRIMARY KEY);`)
+	path := filepath.Join(t.TempDir(), "new.db")
+
+	// Cancel once the first statement ran
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := InitOptions{}
+	opts.OnEvent = func(e Event) {
+		if _, ok := e.(StatementExecuted); ok {
+			cancel()
+		}
+	}
+	if err := InitDatabaseContext(ctx, path, schemaDir, opts); !errors.Is(err, context.Canceled) {
+		t.Fatalf("InitDatabaseContext() error = %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("database left behind after a cancelled init: %v", err)
+	}
+}
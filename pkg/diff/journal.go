@@ -0,0 +1,232 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+// JournalVersion is the version of the apply journal format
+const JournalVersion = 1
+
+// JournalSuffix is appended to the database path to name its apply journal.
+// It differs from SQLite's own "-journal" rollback journal.
+const JournalSuffix = ".apply-journal"
+
+// ErrInterruptedApply is returned when the journal of an earlier apply is
+// still present, i.e. that apply was killed or failed after committing
+// part of its plan
+var ErrInterruptedApply = errors.New("a previous apply was interrupted")
+
+// Journal records a running apply in a sidecar file: the plan as it is
+// executed and how much of it is committed. It is written before the first
+// write and removed once the apply finished, so finding one means the
+// process died (or failed) halfway and the database holds a partial
+// migration. See ResumeApply.
+type Journal struct {
+	Version    int       `json:"version"`
+	Started    time.Time `json:"started"`
+	BackupPath string    `json:"backup_path,omitempty"` // Backup made before the first change
+	SchemaHash string    `json:"schema_hash"`           // SchemaHash after the committed changes
+	Committed  int       `json:"committed"`             // Number of changes committed
+	Changes    []Change  `json:"changes"`
+}
+
+// ReadJournal reads an apply journal. A missing journal is reported as an
+// error wrapping os.ErrNotExist.
+func ReadJournal(path string) (*Journal, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("read apply journal: %w", err)
+	}
+	var j Journal
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, fmt.Errorf("read apply journal %s: %w", path, err)
+	}
+	if j.Version != JournalVersion {
+		return nil, fmt.Errorf("unsupported apply journal version %d, want %d", j.Version, JournalVersion)
+	}
+	if j.Committed < 0 || j.Committed > len(j.Changes) {
+		return nil, fmt.Errorf("apply journal %s: %d of %d changes committed", path, j.Committed, len(j.Changes))
+	}
+	return &j, nil
+}
+
+// Remaining returns the changes the interrupted apply did not commit
+func (j *Journal) Remaining() []Change {
+	return j.Changes[j.Committed:]
+}
+
+// Guidance explains how to recover from the interrupted apply
+func (j *Journal) Guidance() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "The apply started at %s committed %d of %d changes.\n",
+		j.Started.Local().Format(time.DateTime), j.Committed, len(j.Changes))
+	if remaining := j.Remaining(); len(remaining) > 0 {
+		b.WriteString("Not applied yet:\n")
+		for _, c := range remaining {
+			fmt.Fprintf(&b, "  - %s\n", c.Description)
+		}
+	}
+	b.WriteString("Resuming applies the remaining changes as planned.\n")
+	if j.BackupPath != "" {
+		fmt.Fprintf(&b, "To roll back, restore the backup %s (copy it over the database and delete the database's -wal and -shm files).", j.BackupPath)
+	} else {
+		b.WriteString("No backup was made, so rolling back means restoring one of your own.")
+	}
+	return b.String()
+}
+
+// write replaces the journal file atomically and syncs it to disk, so a
+// power loss leaves either the previous or the new journal
+func (j *Journal) write(path string) error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return fmt.Errorf("write apply journal: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("write apply journal: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	err = errors.Join(err, tmp.Close())
+	if err != nil {
+		return fmt.Errorf("write apply journal: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write apply journal: %w", err)
+	}
+	return nil
+}
+
+// checkJournal fails with ErrInterruptedApply if an apply journal exists
+func checkJournal(path string) error {
+	if path == "" {
+		return nil
+	}
+	j, err := ReadJournal(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w (%d of %d changes committed, see %s)", ErrInterruptedApply, j.Committed, len(j.Changes), path)
+}
+
+// ResumeApply finishes the apply interrupted with the journal at
+// opts.JournalPath. The remaining changes are applied as planned back then,
+// without planning again and without a new backup, so the backup in the
+// journal still holds the database from before the interrupted apply. The
+// schema must be the one the journal recorded after the last committed
+// change; if the process died between a commit and the journal update,
+// the state is unknown and restoring the backup is the way out.
+func ResumeApply(db *sql.DB, schemaDir string, opts ApplyOptions) error {
+	return ResumeApplyContext(context.Background(), db, schemaDir, opts)
+}
+
+// ResumeApplyContext is ResumeApply with a context, see ApplyContext
+func ResumeApplyContext(ctx context.Context, db *sql.DB, schemaDir string, opts ApplyOptions) error {
+	if opts.JournalPath == "" {
+		return fmt.Errorf("resume needs a journal path")
+	}
+	j, err := ReadJournal(opts.JournalPath)
+	if err != nil {
+		return err
+	}
+	current, err := parser.FromDBContext(ctx, db)
+	if err != nil {
+		return err
+	}
+	// A batched copy the process was killed in is started over
+	if hash := SchemaHash(withoutBatchCopies(current, j.Remaining())); hash != j.SchemaHash {
+		return fmt.Errorf("database schema does not match the apply journal (schema hash %.12s, journal at %.12s), "+
+			"it changed after the last recorded commit; restore the backup instead of resuming", hash, j.SchemaHash)
+	}
+
+	if opts.DryRun {
+		return nil
+	}
+	remaining := j.Remaining()
+	if len(remaining) == 0 {
+		return removeJournal(opts.JournalPath)
+	}
+	opts.BackupPath = ""
+	opts.resumeBackup = j.BackupPath
+	opts.SchemaVersion = nil
+	opts.DataHooks = nil // Already attached when the plan was journaled
+	opts.Reorder = nil
+	return applyPlan(ctx, db, schemaDir, remaining, opts)
+}
+
+// removeJournal deletes an apply journal, if there is one
+func removeJournal(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove apply journal: %w", err)
+	}
+	return nil
+}
+
+// journaler keeps the journal of a running apply up to date. A nil
+// journaler does nothing.
+type journaler struct {
+	path    string
+	journal Journal
+}
+
+// startJournal writes the journal for changes before the first of them is
+// applied, or returns nil if journaling is off. The schema is read on conn,
+// the connection the changes are applied on.
+func startJournal(ctx context.Context, conn *sql.Conn, path, backupPath string, changes []Change) (*journaler, error) {
+	if path == "" {
+		return nil, nil
+	}
+	jr := &journaler{path: path, journal: Journal{
+		Version:    JournalVersion,
+		Started:    time.Now().UTC(),
+		BackupPath: backupPath,
+		Changes:    changes,
+	}}
+	if err := jr.record(ctx, conn, 0); err != nil {
+		return nil, err
+	}
+	return jr, nil
+}
+
+// record notes that n more changes were committed, reading the schema
+// they left on conn
+func (jr *journaler) record(ctx context.Context, conn *sql.Conn, n int) error {
+	if jr == nil {
+		return nil
+	}
+	current, err := parser.FromConn(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("write apply journal: %w", err)
+	}
+	jr.journal.Committed += n
+	jr.journal.SchemaHash = SchemaHash(current)
+	return jr.journal.write(jr.path)
+}
+
+// finish removes the journal once the apply succeeded, or failed without
+// committing anything. A partially committed apply keeps its journal, so
+// the next run reports it.
+func (jr *journaler) finish(applyErr error) error {
+	if jr == nil || (applyErr != nil && jr.journal.Committed > 0) {
+		return nil
+	}
+	return removeJournal(jr.path)
+}
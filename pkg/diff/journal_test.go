@@ -0,0 +1,115 @@
+package diff
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// interruptedApply applies two new tables in separate batches and fails
+// the checks of the second, leaving the first committed and the journal behind
+func interruptedApply(t *testing.T) (*sql.DB, string, ApplyOptions) {
+	t.Helper()
+	db, dbPath := createTestDBWithPath(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	t.Cleanup(func() { _ = db.Close() })
+	schemaDir := createSchemaDir(t, "schema.sql", `
+		CREATE TABLE users (id INTEGER PRIMARY KEY);
+		CREATE TABLE a (id INTEGER PRIMARY KEY);
+		CREATE TABLE b (id INTEGER PRIMARY KEY);
+	`)
+
+	opts := ApplyOptions{
+		JournalPath: dbPath + JournalSuffix,
+		BackupPath:  dbPath + ".backup",
+		LowPriority: true,
+		BatchPause:  time.Nanosecond,
+	}
+	failing := opts
+	failing.Checks = []Check{{Name: "always fails", SQL: "SELECT 1"}}
+	if err := Apply(db, schemaDir, failing); err == nil {
+		t.Fatal("expected the failing check to abort the apply")
+	}
+	return db, schemaDir, opts
+}
+
+func TestApply_JournalRemovedOnSuccess(t *testing.T) {
+	db, dbPath := createTestDBWithPath(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "users.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`)
+
+	if err := Apply(db, schemaDir, ApplyOptions{JournalPath: dbPath + JournalSuffix}); err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
+	if _, err := os.Stat(dbPath + JournalSuffix); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("journal still present after a successful apply: %v", err)
+	}
+}
+
+func TestApply_InterruptedJournal(t *testing.T) {
+	db, schemaDir, opts := interruptedApply(t)
+
+	j, err := ReadJournal(opts.JournalPath)
+	if err != nil {
+		t.Fatalf("ReadJournal() error: %v", err)
+	}
+	if j.Committed != 1 || len(j.Changes) != 2 {
+		t.Errorf("journal has %d of %d changes committed, want 1 of 2", j.Committed, len(j.Changes))
+	}
+	if j.BackupPath != opts.BackupPath {
+		t.Errorf("journal backup = %q, want %q", j.BackupPath, opts.BackupPath)
+	}
+	if guidance := j.Guidance(); !strings.Contains(guidance, opts.BackupPath) {
+		t.Errorf("guidance does not mention the backup:\n%s", guidance)
+	}
+
+	if err := Apply(db, schemaDir, opts); !errors.Is(err, ErrInterruptedApply) {
+		t.Fatalf("Apply() error = %v, want ErrInterruptedApply", err)
+	}
+
+	backup, err := os.Stat(opts.BackupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ResumeApply(db, schemaDir, opts); err != nil {
+		t.Fatalf("ResumeApply() error: %v", err)
+	}
+	if _, err := os.Stat(opts.JournalPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("journal still present after resuming: %v", err)
+	}
+	if after, err := os.Stat(opts.BackupPath); err != nil || !after.ModTime().Equal(backup.ModTime()) {
+		t.Errorf("resuming replaced the backup of the interrupted apply: %v", err)
+	}
+
+	changes, err := CompareWithOptions(db, schemaDir, opts.DiffOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("resumed apply left %d changes", len(changes))
+	}
+}
+
+func TestResumeApply_SchemaChanged(t *testing.T) {
+	db, schemaDir, opts := interruptedApply(t)
+
+	if _, err := db.Exec("CREATE TABLE other (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ResumeApply(db, schemaDir, opts); err == nil {
+		t.Fatal("expected resuming on a changed schema to fail")
+	}
+	if _, err := os.Stat(opts.JournalPath); err != nil {
+		t.Errorf("journal removed after a refused resume: %v", err)
+	}
+}
+
+func TestReadJournal_Missing(t *testing.T) {
+	if _, err := ReadJournal(filepath.Join(t.TempDir(), "missing"+JournalSuffix)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadJournal() error = %v, want os.ErrNotExist", err)
+	}
+}
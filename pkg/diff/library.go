@@ -1,37 +1,107 @@
 package diff
 
 import (
+	"bufio"
+	"context"
 	"database/sql"
 	"fmt"
+	"io"
 	"strings"
+	"time"
 
 	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
 )
 
 // Compare compares a database against a schema directory and returns changes.
 // If SetBaseFS was called, reads from the embedded filesystem instead.
 func Compare(db *sql.DB, schemaDir string) ([]Change, error) {
-	current, err := parser.FromDB(db)
+	return CompareWithOptions(db, schemaDir, DiffOptions{})
+}
+
+// CompareWithOptions is Compare with explicit diff options. The plan ends
+// with the maintenance of the partitioned tables (see PartitionChanges).
+func CompareWithOptions(db *sql.DB, schemaDir string, opts DiffOptions) ([]Change, error) {
+	return CompareContext(context.Background(), db, schemaDir, opts)
+}
+
+// CompareContext is CompareWithOptions with a context that cancels parsing
+// the schema files and reading the database schema
+func CompareContext(ctx context.Context, db *sql.DB, schemaDir string, opts DiffOptions) ([]Change, error) {
+	target, err := parser.ReadFilesContext(ctx, schemaDir, opts.Parse)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Partitions == nil {
+		if opts.Partitions, err = LoadPartitions(schemaDir); err != nil {
+			return nil, fmt.Errorf("load partitions: %w", err)
+		}
+	}
+	var hashes map[string]string
+	if opts.Incremental {
+		hashes = ObjectHashes(target)
+	}
+	changes, err := compareTo(ctx, db, target, hashes, opts)
 	if err != nil {
 		return nil, err
 	}
+	if changes, err = overrideChanges(schemaDir, changes); err != nil {
+		return nil, err
+	}
+	if len(opts.Partitions) == 0 {
+		return changes, nil
+	}
+	current, err := parser.FromDBWithoutColumnsContext(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	return append(changes, PartitionChanges(current, opts.Partitions, time.Now())...), nil
+}
+
+// overrideChanges applies the overrides/*.sql files of a schema directory
+func overrideChanges(schemaDir string, changes []Change) ([]Change, error) {
+	overrides, err := LoadOverrides(schemaDir)
+	if err != nil {
+		return nil, fmt.Errorf("load overrides: %w", err)
+	}
+	return ApplyOverrides(changes, overrides)
+}
 
-	target, err := parser.ReadFiles(schemaDir)
+// compareTo diffs a database against a parsed target schema, planning for
+// the database's SQLite unless opts says otherwise. With opts.Incremental,
+// targetHashes are the ObjectHashes of target.
+func compareTo(ctx context.Context, db *sql.DB, target *schema.Database, targetHashes map[string]string, opts DiffOptions) ([]Change, error) {
+	var current *schema.Database
+	var err error
+	if opts.Incremental {
+		current, err = extractIncremental(ctx, db, target, targetHashes)
+	} else {
+		current, err = parser.FromDBContext(ctx, db)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return Diff(current, target), nil
+	caps, err := DetectCapabilities(db)
+	if err != nil {
+		return nil, err
+	}
+	return DiffWithOptions(current, target, opts.WithCapabilities(caps)), nil
 }
 
 // CompareDatabases compares two databases
 func CompareDatabases(from, to *sql.DB) ([]Change, error) {
-	fromSchema, err := parser.FromDB(from)
+	return CompareDatabasesContext(context.Background(), from, to)
+}
+
+// CompareDatabasesContext is CompareDatabases with a context
+func CompareDatabasesContext(ctx context.Context, from, to *sql.DB) ([]Change, error) {
+	fromSchema, err := parser.FromDBContext(ctx, from)
 	if err != nil {
 		return nil, err
 	}
 
-	toSchema, err := parser.FromDB(to)
+	toSchema, err := parser.FromDBContext(ctx, to)
 	if err != nil {
 		return nil, err
 	}
@@ -39,29 +109,61 @@ func CompareDatabases(from, to *sql.DB) ([]Change, error) {
 	return Diff(fromSchema, toSchema), nil
 }
 
-// GenerateSQL generates a complete migration script
+// GenerateSQL generates a complete migration script. Use WriteSQL for
+// large plans.
 func GenerateSQL(changes []Change) string {
+	var sb strings.Builder
+	_ = WriteSQL(&sb, changes, SQLOptions{})
+	return sb.String()
+}
+
+// SQLOptions configures WriteSQL
+type SQLOptions struct {
+	// D1 writes the script for Cloudflare D1, see GenerateD1SQL
+	D1 bool
+
+	// Current is the schema the changes apply to. With D1 set, the plan is
+	// checked with CheckD1 against it and nothing is written if it fails.
+	Current *schema.Database
+}
+
+// WriteSQL writes the migration script for changes to w one statement at a
+// time, so that plans with thousands of objects never have to be held in
+// memory as one string. Nothing is written for an empty plan.
+func WriteSQL(w io.Writer, changes []Change, opts SQLOptions) error {
 	if len(changes) == 0 {
-		return ""
+		return nil
+	}
+	if opts.D1 && opts.Current != nil {
+		if err := CheckD1(opts.Current, changes); err != nil {
+			return err
+		}
 	}
 
-	var sb strings.Builder
-	sb.WriteString("-- Generated by sqlite-schema-diff\n")
-	sb.WriteString("PRAGMA foreign_keys = OFF;\n")
-	sb.WriteString("BEGIN TRANSACTION;\n\n")
+	bw := bufio.NewWriter(w)
+	_, _ = bw.WriteString("-- Generated by sqlite-schema-diff\n")
+	if opts.D1 {
+		_, _ = bw.WriteString("PRAGMA defer_foreign_keys = true;\n\n")
+	} else {
+		_, _ = bw.WriteString("PRAGMA foreign_keys = OFF;\n")
+		_, _ = bw.WriteString("BEGIN TRANSACTION;\n\n")
+	}
 
 	for _, c := range changes {
-		fmt.Fprintf(&sb, "-- %s: %s\n", c.Type, c.Description)
+		_, _ = fmt.Fprintf(bw, "-- %s [%s]: %s\n", c.Type, changeSeverity(c), c.Description)
 
 		for _, stmt := range c.SQL {
-			sb.WriteString(stmt)
-			sb.WriteString("\n")
+			_, _ = bw.WriteString(stmt)
+			_, _ = bw.WriteString("\n")
 		}
 
-		sb.WriteString("\n")
+		_, _ = bw.WriteString("\n")
 	}
 
-	sb.WriteString("COMMIT;\n")
-	sb.WriteString("PRAGMA foreign_keys = ON;\n")
-	return sb.String()
+	if !opts.D1 {
+		_, _ = bw.WriteString("COMMIT;\n")
+		_, _ = bw.WriteString("PRAGMA foreign_keys = ON;\n")
+	}
+	// bufio.Writer keeps the first write error and returns it here
+	return bw.Flush()
 }
@@ -1,11 +1,16 @@
 package diff
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
 	_ "modernc.org/sqlite"
 )
 
@@ -28,6 +33,28 @@ func TestCompare_IdenticalSchema(t *testing.T) {
 	}
 }
 
+func TestCompare_JSONExpressionVariants(t *testing.T) {
+	db := openTestDB(t, `
+		CREATE TABLE docs (data TEXT, name TEXT GENERATED ALWAYS AS (json_extract(data, '$.name')) VIRTUAL);
+		CREATE INDEX idx_docs_user ON docs(data ->> '$."user"');
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(
+		t,
+		"docs.sql",
+		`CREATE TABLE docs (data TEXT, name TEXT AS (json_extract(data,'$."name"')) VIRTUAL);
+		CREATE INDEX idx_docs_user ON docs(data->>'user');`,
+	)
+
+	changes, err := Compare(db, schemaDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+}
+
 func TestCompare_AddTable(t *testing.T) {
 	db := openTestDB(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
 	defer func() { _ = db.Close() }()
@@ -57,6 +84,31 @@ func TestCompare_AddTable(t *testing.T) {
 	}
 }
 
+func TestCompare_OfflineParse(t *testing.T) {
+	db := openTestDB(t, `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "schema.sql", `
+		CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY,
+			name TEXT
+		);
+		CREATE VIRTUAL TABLE user_search USING fts4(name, tokenize=icu);
+	`)
+
+	// fts4 is not compiled into the bundled SQLite
+	if _, err := Compare(db, schemaDir); err == nil {
+		t.Fatal("expected executing the schema to fail")
+	}
+
+	changes, err := CompareWithOptions(db, schemaDir, DiffOptions{Parse: parser.Options{Offline: true}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(changes) != 1 || changes[0].Type != CreateTable || changes[0].Object != "user_search" {
+		t.Errorf("expected only CREATE_TABLE user_search, got %+v", changes)
+	}
+}
+
 func TestCompare_InvalidSchemaDir(t *testing.T) {
 	db := openTestDB(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
 	defer func() { _ = db.Close() }()
@@ -67,6 +119,18 @@ func TestCompare_InvalidSchemaDir(t *testing.T) {
 	}
 }
 
+func TestCompareContext_Cancelled(t *testing.T) {
+	db := openTestDB(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "schema.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, cancelled_at TEXT);`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := CompareContext(ctx, db, schemaDir, DiffOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("CompareContext() error = %v, want context.Canceled", err)
+	}
+}
+
 func TestCompareDatabases(t *testing.T) {
 	fromDB := openTestDB(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
 	defer func() { _ = fromDB.Close() }()
@@ -111,6 +175,35 @@ func TestGenerateSQL(t *testing.T) {
 	}
 }
 
+func TestWriteSQL(t *testing.T) {
+	changes := []Change{
+		{Type: CreateTable, Object: "users", Description: "Create table users", SQL: []string{"CREATE TABLE users (id INTEGER);"}},
+		{Type: CreateIndex, Object: "idx", Description: "Create index idx", SQL: []string{"CREATE INDEX idx ON users(id);"}},
+	}
+	for _, opts := range []SQLOptions{{}, {D1: true}} {
+		var sb strings.Builder
+		if err := WriteSQL(&sb, changes, opts); err != nil {
+			t.Fatal(err)
+		}
+		want := GenerateSQL(changes)
+		if opts.D1 {
+			want = GenerateD1SQL(changes)
+		}
+		if sb.String() != want {
+			t.Errorf("WriteSQL(%+v) = %q, want %q", opts, sb.String(), want)
+		}
+	}
+
+	if err := WriteSQL(failingWriter{}, changes, SQLOptions{}); err == nil {
+		t.Error("expected the write error to be returned")
+	}
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
 func TestGenerateSQLEmpty(t *testing.T) {
 	if got := GenerateSQL(nil); got != "" {
 		t.Errorf("GenerateSQL(nil) = %q, want empty", got)
@@ -136,3 +229,48 @@ func openTestDB(t *testing.T, schema string) *sql.DB {
 
 	return db
 }
+
+// BenchmarkCompare_AutoMigrate measures an application comparing its
+// embedded schema with an up-to-date database on every start, with and
+// without the parsed schema cache
+func BenchmarkCompare_AutoMigrate(b *testing.B) {
+	schemaDir := b.TempDir()
+	var ddl strings.Builder
+	for i := range 50 {
+		fmt.Fprintf(&ddl, "CREATE TABLE t%d (id INTEGER PRIMARY KEY, name TEXT NOT NULL, created_at TEXT);\n", i)
+		fmt.Fprintf(&ddl, "CREATE INDEX idx_t%d_name ON t%d(name);\n", i, i)
+	}
+	if err := os.WriteFile(filepath.Join(schemaDir, "schema.sql"), []byte(ddl.String()), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", filepath.Join(b.TempDir(), "app.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = db.Close() }()
+	if _, err := db.Exec(ddl.String()); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, size := range []struct {
+		name string
+		n    int
+	}{
+		{"uncached", 0},
+		{"cached", parser.DefaultCacheSize},
+	} {
+		b.Run(size.name, func(b *testing.B) {
+			parser.SetCacheSize(size.n)
+			defer parser.SetCacheSize(parser.DefaultCacheSize)
+			for b.Loop() {
+				changes, err := Compare(db, schemaDir)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(changes) != 0 {
+					b.Fatalf("got %d changes, want none", len(changes))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package diff
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// TableNumber selects whether table names must be singular or plural
+type TableNumber string
+
+const (
+	TableNumberAny      TableNumber = "" // Default
+	TableNumberSingular TableNumber = "singular"
+	TableNumberPlural   TableNumber = "plural"
+)
+
+// NamingRules are the naming conventions Lint enforces. Zero fields turn
+// their rule off.
+type NamingRules struct {
+	SnakeCaseTables bool
+	// IndexPattern is the name indexes must have, with {table} and
+	// {columns} (the indexed columns joined by "_") replaced, e.g.
+	// "idx_{table}_{columns}". Indexes on expressions are not checked.
+	IndexPattern  string
+	TriggerPrefix string
+	TableNumber   TableNumber
+}
+
+// LintIssue is a schema object breaking a naming rule or a registered
+// LintRule
+type LintIssue struct {
+	Rule    string `json:"rule"`
+	Object  string `json:"object"`
+	Message string `json:"message"`
+	Fix     string `json:"fix,omitempty"` // Suggested name, empty if none
+
+	// Where the object is created, set by LocateIssues
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+}
+
+func (i LintIssue) String() string {
+	if i.Fix == "" {
+		return fmt.Sprintf("%s: %s [%s]", i.Object, i.Message, i.Rule)
+	}
+	return fmt.Sprintf("%s: %s, rename to %s [%s]", i.Object, i.Message, i.Fix, i.Rule)
+}
+
+// LocateIssues sets the file and line of each issue to where its object is
+// created, as returned by parser.Locations
+func LocateIssues(issues []LintIssue, locs map[string]parser.Location) {
+	for i := range issues {
+		if loc, ok := locs[issues[i].Object]; ok {
+			issues[i].File, issues[i].Line = loc.File, loc.Line
+		}
+	}
+}
+
+// Lint checks the names in a schema against the naming rules, and the
+// schema against the rules registered with RegisterLintRule. Issues are
+// sorted by object name.
+func Lint(db *schema.Database, rules NamingRules) []LintIssue {
+	var issues []LintIssue
+
+	for _, name := range slices.Sorted(maps.Keys(db.Tables)) {
+		if strings.HasPrefix(name, "sqlite_") {
+			continue
+		}
+		if rules.SnakeCaseTables && !isSnakeCase(name) {
+			issues = append(issues, LintIssue{
+				Rule:    "table-snake-case",
+				Object:  name,
+				Message: "table name is not snake_case",
+				Fix:     snakeCase(name),
+			})
+		}
+		switch want := tableNumber(name, rules.TableNumber); {
+		case want == name:
+		case rules.TableNumber == TableNumberSingular:
+			issues = append(issues, LintIssue{Rule: "table-singular", Object: name, Message: "table name is not singular", Fix: want})
+		case rules.TableNumber == TableNumberPlural:
+			issues = append(issues, LintIssue{Rule: "table-plural", Object: name, Message: "table name is not plural", Fix: want})
+		}
+	}
+
+	if rules.IndexPattern != "" {
+		for _, name := range slices.Sorted(maps.Keys(db.Indexes)) {
+			idx := db.Indexes[name]
+			cols, ok := indexColumns(idx.SQL)
+			if !ok || strings.HasPrefix(name, "sqlite_") {
+				continue
+			}
+			want := strings.NewReplacer("{table}", idx.Table, "{columns}", strings.Join(cols, "_")).Replace(rules.IndexPattern)
+			if !strings.EqualFold(name, want) {
+				issues = append(issues, LintIssue{
+					Rule:    "index-name",
+					Object:  name,
+					Message: fmt.Sprintf("index name does not match %s", rules.IndexPattern),
+					Fix:     want,
+				})
+			}
+		}
+	}
+
+	if rules.TriggerPrefix != "" {
+		for _, name := range slices.Sorted(maps.Keys(db.Triggers)) {
+			if !strings.HasPrefix(name, rules.TriggerPrefix) {
+				issues = append(issues, LintIssue{
+					Rule:    "trigger-prefix",
+					Object:  name,
+					Message: fmt.Sprintf("trigger name does not start with %s", rules.TriggerPrefix),
+					Fix:     rules.TriggerPrefix + name,
+				})
+			}
+		}
+	}
+
+	issues = append(issues, checkLintRules(db)...)
+	slices.SortStableFunc(issues, func(a, b LintIssue) int { return strings.Compare(a.Object, b.Object) })
+	return issues
+}
+
+var snakeCaseRe = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+
+func isSnakeCase(name string) bool {
+	return snakeCaseRe.MatchString(name)
+}
+
+// snakeCase converts names like UserRoles, userRoles or "user roles" to
+// user_roles
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		switch {
+		case unicode.IsUpper(r):
+			// A new word starts at an upper case letter after a lower case
+			// one, or at the last upper case letter of an acronym
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1])) {
+				b.WriteRune('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	parts := strings.FieldsFunc(b.String(), func(r rune) bool { return r == '_' })
+	return strings.Join(parts, "_")
+}
+
+// tableNumber returns name with its last word made singular or plural
+func tableNumber(name string, number TableNumber) string {
+	i := strings.LastIndexByte(name, '_') + 1
+	prefix, word := name[:i], name[i:]
+	switch number {
+	case TableNumberSingular:
+		return prefix + singular(word)
+	case TableNumberPlural:
+		if singular(word) != word {
+			return name
+		}
+		return prefix + plural(word)
+	}
+	return name
+}
+
+// singular undoes the common English plural endings
+func singular(word string) string {
+	lower := strings.ToLower(word)
+	switch {
+	case strings.HasSuffix(lower, "ies") && len(word) > 3:
+		return word[:len(word)-3] + matchCase(word, "y")
+	case strings.HasSuffix(lower, "sses"), strings.HasSuffix(lower, "xes"), strings.HasSuffix(lower, "zes"),
+		strings.HasSuffix(lower, "ches"), strings.HasSuffix(lower, "shes"):
+		return word[:len(word)-2]
+	case strings.HasSuffix(lower, "ss"), strings.HasSuffix(lower, "us"), strings.HasSuffix(lower, "is"):
+		return word
+	case strings.HasSuffix(lower, "s") && len(word) > 1:
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+// plural adds the common English plural ending
+func plural(word string) string {
+	lower := strings.ToLower(word)
+	switch {
+	case strings.HasSuffix(lower, "y") && len(word) > 1 && !strings.ContainsRune("aeiou", rune(lower[len(lower)-2])):
+		return word[:len(word)-1] + matchCase(word, "ies")
+	case strings.HasSuffix(lower, "s"), strings.HasSuffix(lower, "x"), strings.HasSuffix(lower, "z"),
+		strings.HasSuffix(lower, "ch"), strings.HasSuffix(lower, "sh"):
+		return word + matchCase(word, "es")
+	}
+	return word + matchCase(word, "s")
+}
+
+// matchCase upper-cases suffix if word ends in an upper case letter
+func matchCase(word, suffix string) string {
+	if r := word[len(word)-1]; r >= 'A' && r <= 'Z' {
+		return strings.ToUpper(suffix)
+	}
+	return suffix
+}
+
+// indexColumnsRe matches the indexed table and the column list of CREATE INDEX
+var indexColumnsRe = regexp.MustCompile(`(?is)\bON\s+(?:"(?:[^"]|"")*"|[^\s(]+)\s*\((.*)\)`)
+
+// partialIndexRe matches the start of the WHERE clause of a partial index
+var partialIndexRe = regexp.MustCompile(`(?is)\)\s*WHERE\b`)
+
+// simpleColumnRe matches an indexed column, optionally quoted, collated
+// and ordered
+var simpleColumnRe = regexp.MustCompile(`(?i)^("(?:[^"]|"")*"|\[[^\]]*\]|` + "`[^`]*`" + `|\w+)(?:\s+COLLATE\s+\S+)?(?:\s+(?:ASC|DESC))?$`)
+
+// indexColumns returns the columns of an index, false if it indexes
+// expressions
+func indexColumns(sql string) ([]string, bool) {
+	sql = stripComments(sql)
+	// Only the column list, not a partial index's WHERE clause
+	if i := partialIndexRe.FindStringIndex(sql); i != nil {
+		sql = sql[:i[0]+1]
+	}
+	m := indexColumnsRe.FindStringSubmatch(sql)
+	if m == nil {
+		return nil, false
+	}
+	var cols []string
+	for _, part := range strings.Split(m[1], ",") {
+		c := simpleColumnRe.FindStringSubmatch(strings.TrimSpace(part))
+		if c == nil {
+			return nil, false
+		}
+		cols = append(cols, unquoteIdent(c[1]))
+	}
+	return cols, true
+}
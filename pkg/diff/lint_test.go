@@ -0,0 +1,118 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+func TestLint(t *testing.T) {
+	db, err := parser.FromSQL(`
+		CREATE TABLE UserRoles (id INTEGER PRIMARY KEY, user_id INT, role TEXT);
+		CREATE TABLE categories (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE status (id INTEGER PRIMARY KEY);
+		CREATE INDEX idx_UserRoles_user_id_role ON UserRoles (user_id, role DESC);
+		CREATE INDEX by_name ON categories ("name" COLLATE NOCASE) WHERE name IS NOT NULL;
+		CREATE INDEX lower_name ON categories (lower(name));
+		CREATE TRIGGER trg_touch AFTER INSERT ON status BEGIN SELECT 1; END;
+		CREATE TRIGGER audit AFTER INSERT ON categories BEGIN SELECT 1; END;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		rules NamingRules
+		want  []string
+	}{
+		{"no rules", NamingRules{}, nil},
+		{"snake case", NamingRules{SnakeCaseTables: true}, []string{"UserRoles: table name is not snake_case, rename to user_roles [table-snake-case]"}},
+		{"index pattern", NamingRules{IndexPattern: "idx_{table}_{columns}"}, []string{
+			"by_name: index name does not match idx_{table}_{columns}, rename to idx_categories_name [index-name]",
+		}},
+		{"trigger prefix", NamingRules{TriggerPrefix: "trg_"}, []string{
+			"audit: trigger name does not start with trg_, rename to trg_audit [trigger-prefix]",
+		}},
+		{"singular", NamingRules{TableNumber: TableNumberSingular}, []string{
+			"UserRoles: table name is not singular, rename to UserRole [table-singular]",
+			"categories: table name is not singular, rename to category [table-singular]",
+		}},
+		{"plural", NamingRules{TableNumber: TableNumberPlural}, []string{
+			"status: table name is not plural, rename to statuses [table-plural]",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, issue := range Lint(db, tt.rules) {
+				got = append(got, issue.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Lint() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"UserRoles", "user_roles"},
+		{"userRoles", "user_roles"},
+		{"user roles", "user_roles"},
+		{"HTTPRequests", "http_requests"},
+		{"order-items2", "order_items2"},
+		{"already_snake", "already_snake"},
+	}
+	for _, tt := range tests {
+		if got := snakeCase(tt.in); got != tt.want {
+			t.Errorf("snakeCase(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	issues := []LintIssue{
+		{Rule: "table-snake-case", Object: "UserRoles", Message: "table name is not snake_case", Fix: "user_roles"},
+		{Rule: "trigger-prefix", Object: "audit", Message: "trigger name does not start with trg_", Fix: "trg_audit"},
+	}
+	LocateIssues(issues, map[string]parser.Location{"UserRoles": {File: filepath.Join("schema", "users.sql"), Line: 3}})
+
+	var buf bytes.Buffer
+	if err := WriteSARIF(&buf, issues, "1.2.3"); err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("log = %+v, want one SARIF 2.1.0 run", log)
+	}
+	run := log.Runs[0]
+	if run.Tool.Driver.Version != "1.2.3" || len(run.Tool.Driver.Rules) != len(lintRules) {
+		t.Errorf("driver = %+v", run.Tool.Driver)
+	}
+	if len(run.Results) != 2 {
+		t.Fatalf("got %d results, want 2", len(run.Results))
+	}
+
+	located := run.Results[0]
+	if located.RuleID != "table-snake-case" || located.Message.Text != "UserRoles: table name is not snake_case, rename to user_roles" {
+		t.Errorf("result = %+v", located)
+	}
+	if len(located.Locations) != 1 {
+		t.Fatalf("locations = %+v, want one", located.Locations)
+	}
+	loc := located.Locations[0].PhysicalLocation
+	if loc.ArtifactLocation.URI != "schema/users.sql" || loc.Region == nil || loc.Region.StartLine != 3 {
+		t.Errorf("location = %+v, want schema/users.sql line 3", loc)
+	}
+	if len(run.Results[1].Locations) != 0 {
+		t.Errorf("unlocated issue got locations %+v", run.Results[1].Locations)
+	}
+}
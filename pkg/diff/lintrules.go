@@ -0,0 +1,79 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// LintRule is a schema policy Lint checks next to the naming rules, e.g.
+// "every table has created_at and updated_at". Register it with
+// RegisterLintRule.
+type LintRule interface {
+	// Name identifies the rule in issues and SARIF output, e.g.
+	// "table-timestamps"
+	Name() string
+	// Check returns the objects of the schema breaking the rule. Issues
+	// without a Rule get the rule's name.
+	Check(db *schema.Database) []LintIssue
+}
+
+// A LintRule can also describe itself for the SARIF rule metadata
+type lintRuleDescriber interface {
+	Description() string
+}
+
+var (
+	customLintRulesMu sync.RWMutex
+	customLintRules   = make(map[string]LintRule)
+)
+
+// RegisterLintRule adds a rule every later Lint call checks. Rule names
+// must be unique and must not be those of the built-in rules. Registration
+// is global; call it at startup, e.g. from the init function of the package
+// defining the rule.
+func RegisterLintRule(rule LintRule) error {
+	name := rule.Name()
+	if name == "" {
+		return errors.New("lint rule has no name")
+	}
+	customLintRulesMu.Lock()
+	defer customLintRulesMu.Unlock()
+	if _, builtin := lintRules[name]; builtin {
+		return fmt.Errorf("lint rule %s is built in", name)
+	}
+	if _, exists := customLintRules[name]; exists {
+		return fmt.Errorf("lint rule %s is already registered", name)
+	}
+	customLintRules[name] = rule
+	return nil
+}
+
+// registeredLintRules returns the registered rules sorted by name
+func registeredLintRules() []LintRule {
+	customLintRulesMu.RLock()
+	defer customLintRulesMu.RUnlock()
+	rules := make([]LintRule, 0, len(customLintRules))
+	for _, name := range slices.Sorted(maps.Keys(customLintRules)) {
+		rules = append(rules, customLintRules[name])
+	}
+	return rules
+}
+
+// checkLintRules runs the registered rules
+func checkLintRules(db *schema.Database) []LintIssue {
+	var issues []LintIssue
+	for _, rule := range registeredLintRules() {
+		for _, issue := range rule.Check(db) {
+			if issue.Rule == "" {
+				issue.Rule = rule.Name()
+			}
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
@@ -0,0 +1,81 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// timestampsRule requires created_at and updated_at on every table
+type timestampsRule struct{}
+
+func (timestampsRule) Name() string        { return "table-timestamps" }
+func (timestampsRule) Description() string { return "Tables have created_at and updated_at" }
+
+func (timestampsRule) Check(db *schema.Database) []LintIssue {
+	var issues []LintIssue
+	for _, name := range slices.Sorted(maps.Keys(db.Tables)) {
+		t := db.Tables[name]
+		if !t.HasColumn("created_at") || !t.HasColumn("updated_at") {
+			issues = append(issues, LintIssue{Object: name, Message: "table has no created_at and updated_at columns"})
+		}
+	}
+	return issues
+}
+
+type namedRule string
+
+func (r namedRule) Name() string                     { return string(r) }
+func (namedRule) Check(*schema.Database) []LintIssue { return nil }
+
+func TestRegisterLintRule(t *testing.T) {
+	if err := RegisterLintRule(timestampsRule{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { delete(customLintRules, "table-timestamps") })
+
+	for _, rule := range []LintRule{timestampsRule{}, namedRule("index-name"), namedRule("")} {
+		if err := RegisterLintRule(rule); err == nil {
+			t.Errorf("registering %q should fail", rule.Name())
+		}
+	}
+
+	db, err := parser.FromSQL(`
+		CREATE TABLE Posts (id INTEGER PRIMARY KEY, created_at TEXT, updated_at TEXT);
+		CREATE TABLE tags (id INTEGER PRIMARY KEY);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issues := Lint(db, NamingRules{SnakeCaseTables: true})
+	var got []string
+	for _, issue := range issues {
+		got = append(got, issue.String())
+	}
+	want := []string{
+		"Posts: table name is not snake_case, rename to posts [table-snake-case]",
+		"tags: table has no created_at and updated_at columns [table-timestamps]",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Lint() = %q, want %q", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSARIF(&buf, issues, ""); err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	rules := log.Runs[0].Tool.Driver.Rules
+	if i := slices.IndexFunc(rules, func(r sarifRule) bool { return r.ID == "table-timestamps" }); i < 0 ||
+		rules[i].ShortDescription.Text != "Tables have created_at and updated_at" {
+		t.Errorf("SARIF rules = %+v, want the custom rule with its description", rules)
+	}
+}
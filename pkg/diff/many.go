@@ -0,0 +1,205 @@
+package diff
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// Source loads the desired schema. Loading stops when ctx is cancelled.
+type Source func(ctx context.Context) (*schema.Database, error)
+
+// DirSource reads the desired schema from the .sql files in a directory
+func DirSource(dir string, opts parser.Options) Source {
+	return func(ctx context.Context) (*schema.Database, error) {
+		return parser.ReadFilesContext(ctx, dir, opts)
+	}
+}
+
+// SchemaSource uses an already parsed schema as the desired schema
+func SchemaSource(s *schema.Database) Source {
+	return func(context.Context) (*schema.Database, error) {
+		return s, nil
+	}
+}
+
+// DatabaseDrift is the result of comparing one database of a batch
+type DatabaseDrift struct {
+	Name    string
+	Changes []Change
+	Err     error // Set if the database could not be compared
+}
+
+// Drifted reports whether the database differs from the desired schema
+func (d DatabaseDrift) Drifted() bool {
+	return d.Err == nil && len(d.Changes) > 0
+}
+
+// DriftSummary aggregates the drift of a batch of databases
+type DriftSummary struct {
+	Databases   int
+	InSync      int
+	Drifted     int
+	Failed      int
+	Destructive int                // Drifted databases whose plan has destructive changes
+	Changes     map[ChangeType]int // Planned changes by type across all databases
+}
+
+// DriftReport is the result of CompareMany
+type DriftReport struct {
+	Databases []DatabaseDrift // Sorted by name
+	Summary   DriftSummary
+}
+
+// CompareMany compares every database against the desired schema, which is
+// loaded once. A database that cannot be compared is recorded in the report
+// instead of stopping the batch. Cancelling ctx stops between databases and
+// returns the report so far along with the context's error.
+func CompareMany(ctx context.Context, dbs map[string]*sql.DB, desired Source) (*DriftReport, error) {
+	return CompareManyWithOptions(ctx, dbs, desired, DiffOptions{})
+}
+
+// CompareManyWithOptions is CompareMany with explicit diff options
+func CompareManyWithOptions(ctx context.Context, dbs map[string]*sql.DB, desired Source, opts DiffOptions) (*DriftReport, error) {
+	target, err := desired(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load desired schema: %w", err)
+	}
+
+	// Hashed once for the batch
+	var hashes map[string]string
+	if opts.Incremental {
+		hashes = ObjectHashes(target)
+	}
+
+	report := &DriftReport{Summary: DriftSummary{Changes: make(map[ChangeType]int)}}
+	for _, name := range slices.Sorted(maps.Keys(dbs)) {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		drift := DatabaseDrift{Name: name}
+		drift.Changes, drift.Err = compareTo(ctx, dbs[name], target, hashes, opts)
+		report.add(drift)
+	}
+	return report, nil
+}
+
+// add records the drift of one database
+func (r *DriftReport) add(d DatabaseDrift) {
+	r.Databases = append(r.Databases, d)
+
+	s := &r.Summary
+	s.Databases++
+	switch {
+	case d.Err != nil:
+		s.Failed++
+	case len(d.Changes) == 0:
+		s.InSync++
+	default:
+		s.Drifted++
+		if HasDestructive(d.Changes) {
+			s.Destructive++
+		}
+		for _, c := range d.Changes {
+			s.Changes[c.Type]++
+		}
+	}
+}
+
+// SchemaPair is the comparison of two databases of a matrix
+type SchemaPair struct {
+	From, To string
+	Changes  []Change // Turn the schema of From into that of To
+}
+
+// Identical reports whether both databases have the same schema
+func (p SchemaPair) Identical() bool {
+	return len(p.Changes) == 0
+}
+
+// MatrixSummary aggregates a comparison matrix
+type MatrixSummary struct {
+	Databases int
+	Pairs     int // Unordered pairs of readable databases
+	Identical int
+	Differ    int
+	Distinct  int // Distinct schemas among the readable databases
+	Failed    int
+}
+
+// SchemaMatrix is the result of CompareMatrix
+type SchemaMatrix struct {
+	Names   []string         // Readable databases, sorted
+	Failed  map[string]error // Databases whose schema could not be read
+	Pairs   []SchemaPair     // Both directions of every pair of readable databases
+	Summary MatrixSummary
+}
+
+// Pair returns the comparison of two databases of the matrix
+func (m *SchemaMatrix) Pair(from, to string) (SchemaPair, bool) {
+	for _, p := range m.Pairs {
+		if p.From == from && p.To == to {
+			return p, true
+		}
+	}
+	return SchemaPair{}, false
+}
+
+// CompareMatrix reads the schema of every database once and compares every
+// pair, e.g. to see whether a primary, its replicas and its backups have
+// diverged. A database whose schema cannot be read is recorded in the
+// matrix instead of stopping it. Cancelling ctx stops between databases.
+func CompareMatrix(ctx context.Context, dbs map[string]*sql.DB, opts DiffOptions) (*SchemaMatrix, error) {
+	m := &SchemaMatrix{Failed: make(map[string]error)}
+	schemas := make(map[string]*schema.Database, len(dbs))
+	for _, name := range slices.Sorted(maps.Keys(dbs)) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		s, err := parser.FromDBContext(ctx, dbs[name])
+		if err != nil {
+			m.Failed[name] = err
+			continue
+		}
+		schemas[name] = s
+		m.Names = append(m.Names, name)
+	}
+
+	// Each database joins the group of the first identical one before it
+	group := make(map[string]string, len(m.Names))
+	for i, from := range m.Names {
+		for j, to := range m.Names {
+			if i == j {
+				continue
+			}
+			p := SchemaPair{From: from, To: to, Changes: DiffWithOptions(schemas[from], schemas[to], opts)}
+			m.Pairs = append(m.Pairs, p)
+			if i > j {
+				continue // Counted once per unordered pair
+			}
+			m.Summary.Pairs++
+			if p.Identical() {
+				m.Summary.Identical++
+				if _, ok := group[to]; !ok {
+					group[to] = cmp.Or(group[from], from)
+				}
+			} else {
+				m.Summary.Differ++
+			}
+		}
+		if _, ok := group[from]; !ok {
+			group[from] = from
+			m.Summary.Distinct++
+		}
+	}
+	m.Summary.Databases = len(dbs)
+	m.Summary.Failed = len(m.Failed)
+	return m, nil
+}
@@ -0,0 +1,120 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+func TestCompareMany(t *testing.T) {
+	inSync := openTestDB(t, `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`)
+	defer func() { _ = inSync.Close() }()
+	behind := openTestDB(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	defer func() { _ = behind.Close() }()
+	extra := openTestDB(t, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE legacy (id INTEGER PRIMARY KEY);
+	`)
+	defer func() { _ = extra.Close() }()
+	closed := openTestDB(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	_ = closed.Close()
+
+	schemaDir := createSchemaDir(t, "users.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`)
+	dbs := map[string]*sql.DB{"c": inSync, "a": behind, "b": extra, "d": closed}
+
+	report, err := CompareMany(context.Background(), dbs, DirSource(schemaDir, parser.Options{}))
+	if err != nil {
+		t.Fatalf("CompareMany() error = %v", err)
+	}
+
+	var names []string
+	for _, d := range report.Databases {
+		names = append(names, d.Name)
+	}
+	if !slices.Equal(names, []string{"a", "b", "c", "d"}) {
+		t.Errorf("databases = %v, want sorted by name", names)
+	}
+
+	if !report.Databases[0].Drifted() || report.Databases[2].Drifted() || report.Databases[3].Err == nil {
+		t.Errorf("unexpected per-database results: %+v", report.Databases)
+	}
+
+	want := DriftSummary{
+		Databases:   4,
+		InSync:      1,
+		Drifted:     2,
+		Failed:      1,
+		Destructive: 1,
+	}
+	got := report.Summary
+	if got.Databases != want.Databases || got.InSync != want.InSync || got.Drifted != want.Drifted ||
+		got.Failed != want.Failed || got.Destructive != want.Destructive {
+		t.Errorf("summary = %+v, want %+v", got, want)
+	}
+	if got.Changes[AddColumn] != 1 || got.Changes[DropTable] != 1 {
+		t.Errorf("change counts = %v, want one ADD_COLUMN and one DROP_TABLE", got.Changes)
+	}
+}
+
+func TestCompareMany_Errors(t *testing.T) {
+	db := openTestDB(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	defer func() { _ = db.Close() }()
+	dbs := map[string]*sql.DB{"a": db, "b": db}
+
+	errLoad := errors.New("no schema")
+	_, err := CompareMany(context.Background(), dbs, func(context.Context) (*schema.Database, error) { return nil, errLoad })
+	if !errors.Is(err, errLoad) {
+		t.Errorf("error = %v, want %v", err, errLoad)
+	}
+
+	target, err := parser.FromSQL(`CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err := CompareMany(ctx, dbs, SchemaSource(target))
+	if !errors.Is(err, context.Canceled) || report == nil || len(report.Databases) != 0 {
+		t.Errorf("CompareMany() = %+v, %v, want empty report and context.Canceled", report, err)
+	}
+}
+
+func TestCompareMatrix(t *testing.T) {
+	users := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`
+	primary := openTestDB(t, users)
+	defer func() { _ = primary.Close() }()
+	replica := openTestDB(t, users)
+	defer func() { _ = replica.Close() }()
+	backup := openTestDB(t, `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT);`)
+	defer func() { _ = backup.Close() }()
+
+	m, err := CompareMatrix(context.Background(), map[string]*sql.DB{
+		"primary": primary, "replica": replica, "backup": backup,
+	}, DiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := MatrixSummary{Databases: 3, Pairs: 3, Identical: 1, Differ: 2, Distinct: 2}
+	if got := m.Summary; got.Databases != want.Databases || got.Pairs != want.Pairs || got.Identical != want.Identical ||
+		got.Differ != want.Differ || got.Distinct != want.Distinct || got.Failed != want.Failed {
+		t.Errorf("summary = %+v, want %+v", got, want)
+	}
+	if !slices.Equal(m.Names, []string{"backup", "primary", "replica"}) {
+		t.Errorf("names = %v", m.Names)
+	}
+	if p, ok := m.Pair("primary", "replica"); !ok || !p.Identical() {
+		t.Errorf("primary -> replica = %+v, %v, want identical", p, ok)
+	}
+	if p, ok := m.Pair("primary", "backup"); !ok || len(p.Changes) != 1 || p.Changes[0].Type != AddColumn {
+		t.Errorf("primary -> backup = %+v, %v, want one ADD_COLUMN", p, ok)
+	}
+	if _, ok := m.Pair("primary", "primary"); ok {
+		t.Error("a database is not compared with itself")
+	}
+}
@@ -0,0 +1,56 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// MemoryBudget limits the memory used while applying, so migrations on
+// constrained hardware don't evict the host application's working set.
+// Zero fields keep SQLite's defaults.
+type MemoryBudget struct {
+	CacheSize     int64 // Page cache of the migration connection in bytes
+	MmapSize      int64 // Memory-mapped I/O of the migration connection in bytes, -1 to disable
+	SoftHeapLimit int64 // Process-wide soft heap limit in bytes while applying
+}
+
+// prepareMemory applies the memory budget to the connection changes are
+// applied on and returns a function restoring the previous settings
+func prepareMemory(ctx context.Context, conn *sql.Conn, budget MemoryBudget) (func() error, error) {
+	var restores []func() error
+	restore := func() error { return restoreAll(restores) }
+
+	var pragmas [][2]string
+	if budget.CacheSize < 0 {
+		return nil, fmt.Errorf("invalid cache size %d", budget.CacheSize)
+	}
+	if budget.CacheSize > 0 {
+		// Negative values are KiB instead of pages
+		pragmas = append(pragmas, [2]string{"cache_size", fmt.Sprint(-max(budget.CacheSize>>10, 1))})
+	}
+	switch {
+	case budget.MmapSize == -1:
+		pragmas = append(pragmas, [2]string{"mmap_size", "0"})
+	case budget.MmapSize > 0:
+		pragmas = append(pragmas, [2]string{"mmap_size", fmt.Sprint(budget.MmapSize)})
+	case budget.MmapSize < 0:
+		return nil, fmt.Errorf("invalid mmap size %d", budget.MmapSize)
+	}
+	if budget.SoftHeapLimit < 0 {
+		return nil, fmt.Errorf("invalid soft heap limit %d", budget.SoftHeapLimit)
+	}
+	if budget.SoftHeapLimit > 0 {
+		pragmas = append(pragmas, [2]string{"soft_heap_limit", fmt.Sprint(budget.SoftHeapLimit)})
+	}
+
+	for _, p := range pragmas {
+		r, err := setPragma(ctx, conn, p[0], p[1])
+		if err != nil {
+			_ = restore()
+			return nil, err
+		}
+		restores = append(restores, r)
+	}
+	return restore, nil
+}
@@ -0,0 +1,77 @@
+package diff
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPrepareMemory(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	defer func() { _ = db.Close() }()
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	names := []string{"cache_size", "mmap_size", "soft_heap_limit"}
+	before := map[string]string{}
+	for _, name := range names {
+		before[name] = pragmaValue(t, conn, name)
+	}
+
+	budget := MemoryBudget{CacheSize: 4 << 20, MmapSize: 8 << 20, SoftHeapLimit: 32 << 20}
+	restore, err := prepareMemory(ctx, conn, budget)
+	if err != nil {
+		t.Fatalf("prepareMemory() error = %v", err)
+	}
+	want := map[string]string{"cache_size": "-4096", "mmap_size": "8388608", "soft_heap_limit": "33554432"}
+	for _, name := range names {
+		if got := pragmaValue(t, conn, name); got != want[name] {
+			t.Errorf("%s = %s, want %s", name, got, want[name])
+		}
+	}
+
+	if err := restore(); err != nil {
+		t.Fatalf("restore() error = %v", err)
+	}
+	for _, name := range names {
+		if got := pragmaValue(t, conn, name); got != before[name] {
+			t.Errorf("%s after restore = %s, want %s", name, got, before[name])
+		}
+	}
+}
+
+func TestApply_Memory(t *testing.T) {
+	tests := []struct {
+		name    string
+		budget  MemoryBudget
+		wantErr string
+	}{
+		{"small budget", MemoryBudget{CacheSize: 256 << 10, MmapSize: -1, SoftHeapLimit: 16 << 20}, ""},
+		{"negative cache size", MemoryBudget{CacheSize: -1}, "invalid cache size"},
+		{"negative mmap size", MemoryBudget{MmapSize: -2}, "invalid mmap size"},
+		{"negative heap limit", MemoryBudget{SoftHeapLimit: -1}, "invalid soft heap limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := createTestDBWithPath(t, `
+				CREATE TABLE items (id INTEGER PRIMARY KEY, body TEXT);
+				INSERT INTO items (body) VALUES ('a'), ('b');
+			`)
+			defer func() { _ = db.Close() }()
+			schemaDir := createSchemaDir(t, "items.sql", `CREATE TABLE items (id INTEGER PRIMARY KEY, body TEXT NOT NULL);`)
+
+			err := Apply(db, schemaDir, ApplyOptions{Memory: tt.budget})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Apply() error = %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Apply() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,63 +1,480 @@
 package diff
 
 import (
+	"bytes"
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
 )
 
 // stringLiteralRe matches SQLite string literals, including escaped quotes (e.g. 'O”Neil')
 var stringLiteralRe = regexp.MustCompile(`'((?:[^']|'')*)'`)
 
-func normalizeSQL(sql string) string {
-	// Mask string literals to protect them from normalization
-	var literals []string
-	maskedSQL := stringLiteralRe.ReplaceAllStringFunc(sql, func(match string) string {
-		literals = append(literals, match)
-		// Use a unique placeholder that will survive tokenization and lowercasing
-		return fmt.Sprintf(" __str_protect_%d__ ", len(literals)-1)
-	})
+// jsonQuotedKeyRe matches a quoted object key in a JSON path that needs no quoting
+var jsonQuotedKeyRe = regexp.MustCompile(`\."([A-Za-z_][A-Za-z0-9_]*)"`)
+
+// jsonLabelRe matches the plain label shorthand accepted by -> and ->>
+var jsonLabelRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
 
-	normalized := performNormalization(maskedSQL)
+// jsonPathArg reports whether argument i (from 0) of a call to fn is a
+// JSON path
+func jsonPathArg(fn string, i int) bool {
+	switch strings.ToLower(fn) {
+	case "json_extract", "jsonb_extract", "json_remove", "jsonb_remove":
+		return i >= 1
+	case "json_set", "jsonb_set", "json_insert", "jsonb_insert", "json_replace", "jsonb_replace":
+		return i%2 == 1 // The others are the values
+	case "json_type", "json_array_length", "json_each", "json_tree":
+		return i == 1
+	}
+	return false
+}
 
-	// Unmask string literals, the normalization process lowercases the placeholder, so we match that
-	for i, lit := range literals {
-		placeholder := fmt.Sprintf("__str_protect_%d__", i)
-		normalized = strings.Replace(normalized, placeholder, lit, 1)
+// normalizeJSONPaths rewrites equivalent spellings of JSON paths to one form:
+// "$.a", '$."a"' and (after -> or ->>) 'a' all become '$.a'. Only operands
+// of -> and ->> and the path arguments of the json_* functions are paths;
+// other strings starting with "$" are left alone.
+func normalizeJSONPaths(sql string) string {
+	tokens := parser.Tokenize(sql)
+	type call struct {
+		fn  string // Empty for parentheses that are not a call
+		arg int
 	}
+	var calls []call
+	var b strings.Builder
+	last := 0
+	for i, t := range tokens {
+		if t.Kind == parser.TokenPunct {
+			switch t.Text {
+			case "(":
+				fn := ""
+				if i > 0 && tokens[i-1].Kind == parser.TokenWord {
+					fn = tokens[i-1].Text
+				}
+				calls = append(calls, call{fn: fn})
+			case ",":
+				if len(calls) > 0 {
+					calls[len(calls)-1].arg++
+				}
+			case ")":
+				if len(calls) > 0 {
+					calls = calls[:len(calls)-1]
+				}
+			}
+			continue
+		}
+		if t.Kind != parser.TokenQuoted || i == 0 {
+			continue
+		}
 
-	return normalized
+		prev := tokens[i-1].Text
+		arrow := prev == "->" || prev == "->>"
+		if !arrow {
+			// Only a whole argument, not e.g. '$.' || key
+			if len(calls) == 0 || (prev != "(" && prev != ",") || i+1 == len(tokens) ||
+				(tokens[i+1].Text != "," && tokens[i+1].Text != ")") {
+				continue
+			}
+			if c := calls[len(calls)-1]; !jsonPathArg(c.fn, c.arg) {
+				continue
+			}
+		}
+		if path, ok := jsonPath(t.Text, arrow); ok {
+			b.WriteString(sql[last:t.Pos])
+			b.WriteString(path)
+			last = t.Pos + len(t.Text)
+		}
+	}
+	if last == 0 {
+		return sql
+	}
+	b.WriteString(sql[last:])
+	return b.String()
 }
 
-func performNormalization(sql string) string {
-	sql = strings.TrimSpace(sql)
-	sql = strings.TrimSuffix(sql, ";")
+// jsonPath returns a quoted JSON path in the form normalizeJSONPaths uses,
+// or false if token is not one. With arrow, a plain label is a path.
+func jsonPath(token string, arrow bool) (string, bool) {
+	if len(token) < 2 || (token[0] != '\'' && token[0] != '"') || token[len(token)-1] != token[0] {
+		return "", false
+	}
+	content := token[1 : len(token)-1]
+	if token[0] == '"' {
+		content = strings.ReplaceAll(content, `""`, `"`)
+	}
 
-	// Remove quotes around identifiers (SQLite accepts both quoted and unquoted)
-	// We MUST do this because SQLite's ALTER TABLE RENAME TO forces double quotes
-	// around the new table name in sqlite_master, meaning unquoted schema tables
-	// would infinitely recreate if we did not treat them as identical.
-	sql = strings.ReplaceAll(sql, "\"", "")
-	sql = strings.ReplaceAll(sql, "`", "")
-	sql = strings.ReplaceAll(sql, "[", "")
-	sql = strings.ReplaceAll(sql, "]", "")
+	switch {
+	case strings.HasPrefix(content, "$"):
+		content = jsonQuotedKeyRe.ReplaceAllString(content, ".$1")
+	case arrow && token[0] == '\'' && jsonLabelRe.MatchString(content):
+		content = "$." + content
+	default:
+		return "", false
+	}
+	return "'" + strings.ReplaceAll(content, "'", "''") + "'", true
+}
 
-	// Collapse all whitespace to single spaces and lowercase everything
-	sql = strings.ToLower(strings.Join(strings.Fields(sql), " "))
+// Equivalence classes SQLEquivalent reports for equivalent statements, from
+// the strictest to the loosest
+const (
+	EquivalentIdentical  = "identical"
+	EquivalentFormatting = "same up to whitespace, comments and case"
+	EquivalentQuoting    = "same up to identifier quoting"
+	EquivalentNormalized = "same after normalization"
+)
 
-	// Normalize spacing around punctuation
-	for _, ch := range []string{"(", ")", ",", "="} {
-		sql = strings.ReplaceAll(sql, " "+ch, ch)
-		sql = strings.ReplaceAll(sql, ch+" ", ch)
+// SQLEquivalent reports whether two DDL statements define the same object
+// the way the diff engine compares them, so it never plans a change for
+// one against the other. For equivalent statements the reason is the
+// strictest equivalence class that holds (see EquivalentIdentical); for
+// different ones it names the first token that differs after
+// normalization. Any input is accepted.
+func SQLEquivalent(a, b string) (bool, string) {
+	if !sqlEquivalent(a, b) {
+		return false, firstDifference(normalizeSQL(a), normalizeSQL(b))
+	}
+	switch {
+	case a == b:
+		return true, EquivalentIdentical
+	case sameTokens(a, b, false):
+		return true, EquivalentFormatting
+	case sameTokens(a, b, true):
+		return true, EquivalentQuoting
 	}
+	return true, EquivalentNormalized
+}
 
-	// Add space after comma for consistency
-	sql = strings.ReplaceAll(sql, ",", ", ")
+// sqlEquivalent is the equivalence the diff engine uses for object SQL
+func sqlEquivalent(a, b string) bool {
+	return normalizeSQL(a) == normalizeSQL(b)
+}
 
-	// Collapse any double spaces created
-	for strings.Contains(sql, "  ") {
-		sql = strings.ReplaceAll(sql, "  ", " ")
+// sameTokens compares the code tokens of two statements case-insensitively,
+// ignoring comments and a final semicolon. With unquote, quoted identifiers
+// compare equal to bare words.
+func sameTokens(a, b string, unquote bool) bool {
+	ta, tb := equivalenceTokens(a, unquote), equivalenceTokens(b, unquote)
+	if len(ta) != len(tb) {
+		return false
 	}
+	for i := range ta {
+		x, y := ta[i], tb[i]
+		if x.Kind != y.Kind || x.Text != y.Text && (x.Kind != parser.TokenWord || !strings.EqualFold(x.Text, y.Text)) {
+			return false
+		}
+	}
+	return true
+}
 
-	return strings.TrimSpace(sql)
+// equivalenceTokens returns the tokens sameTokens compares
+func equivalenceTokens(sql string, unquote bool) []parser.Token {
+	var tokens []parser.Token
+	for _, t := range parser.Tokenize(sql) {
+		if t.Kind == parser.TokenComment {
+			continue
+		}
+		if unquote && t.Kind == parser.TokenQuoted && t.Text[0] != '\'' {
+			t = parser.Token{Text: unquoteIdent(t.Text), Kind: parser.TokenWord, Pos: t.Pos}
+		}
+		tokens = append(tokens, t)
+	}
+	if n := len(tokens); n > 0 && tokens[n-1].Kind == parser.TokenPunct && tokens[n-1].Text == ";" {
+		tokens = tokens[:n-1]
+	}
+	return tokens
+}
+
+// firstDifference describes where two normalized statements start to differ
+func firstDifference(a, b string) string {
+	ta, tb := parser.Tokenize(a), parser.Tokenize(b)
+	text := func(tokens []parser.Token, i int) string {
+		if i < len(tokens) {
+			return fmt.Sprintf("%q", tokens[i].Text)
+		}
+		return "end of statement"
+	}
+	i := 0
+	for i < len(ta) && i < len(tb) && ta[i].Text == tb[i].Text {
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("differs at the start: %s vs %s", text(ta, i), text(tb, i))
+	}
+	return fmt.Sprintf("differs after %q: %s vs %s", ta[i-1].Text, text(ta, i), text(tb, i))
+}
+
+// normalizeSQL reduces object SQL to the form the diff engine compares:
+// comments, identifier quotes, IF NOT EXISTS, schema qualifiers and
+// optional keywords removed, whitespace collapsed and everything outside
+// string literals lowercased. It runs for every object pair of every diff,
+// so the common case is a single pass over the bytes with pooled buffers.
+func normalizeSQL(sql string) string {
+	// Comments are not part of the definition
+	if strings.Contains(sql, "--") || strings.Contains(sql, "/*") {
+		sql = blankComments(sql)
+	}
+	if strings.Contains(sql, "->") || strings.Contains(sql, "$") {
+		sql = normalizeJSONPaths(sql)
+	}
+	return normalizeSQLFast(sql)
+}
+
+// literalMark stands in for a string literal in the buffer of
+// normalizeSQLFast until the literal is copied back in
+const literalMark = 0
+
+// normalizeBuffer is the scratch space of normalizeSQLFast
+type normalizeBuffer struct {
+	out      []byte
+	literals [][2]int // Start and end of each string literal in the input
+}
+
+var normalizeBuffers = sync.Pool{New: func() any { return new(normalizeBuffer) }}
+
+// normalizeSQLFast is normalizeSQL after comments and JSON paths, in one
+// pass over the input. Only the result is allocated; the rare rewrites (IF
+// NOT EXISTS, qualifiers, GENERATED ALWAYS) then work in place on the
+// pooled buffer.
+func normalizeSQLFast(sql string) string {
+	buf := normalizeBuffers.Get().(*normalizeBuffer)
+	defer normalizeBuffers.Put(buf)
+	out, literals := buf.out[:0], buf.literals[:0]
+
+	sql = strings.TrimSuffix(strings.TrimSpace(sql), ";")
+
+	// Drop identifier quotes, collapse whitespace and lowercase, with each
+	// string literal replaced by a mark surrounded by spaces
+	space := false
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '\'':
+			if end := literalEnd(sql, i); end > 0 {
+				if len(out) > 0 {
+					out = append(out, ' ')
+				}
+				out = append(out, literalMark)
+				literals = append(literals, [2]int{i, end})
+				space, i = true, end
+				continue
+			}
+		case c == literalMark:
+			// A NUL byte would read as the literal mark, so it is
+			// copied back like a one-byte literal
+			if space && len(out) > 0 {
+				out = append(out, ' ')
+			}
+			out = append(out, literalMark)
+			literals = append(literals, [2]int{i, i + 1})
+			space = false
+			i++
+			continue
+		case c == '"' || c == '`' || c == '[' || c == ']':
+			i++
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\v' || c == '\f' || c == '\r':
+			space = true
+			i++
+			continue
+		case c >= utf8.RuneSelf:
+			r, size := utf8.DecodeRuneInString(sql[i:])
+			i += size
+			if r == utf8.RuneError && size == 1 {
+				// Invalid UTF-8 is kept as it is
+				if space && len(out) > 0 {
+					out = append(out, ' ')
+				}
+				out = append(out, c)
+				space = false
+				continue
+			}
+			if unicode.IsSpace(r) {
+				space = true
+				continue
+			}
+			if space && len(out) > 0 {
+				out = append(out, ' ')
+			}
+			out = utf8.AppendRune(out, unicode.ToLower(r))
+			space = false
+			continue
+		}
+		if space && len(out) > 0 {
+			out = append(out, ' ')
+		}
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		out = append(out, c)
+		space = false
+		i++
+	}
+
+	out = stripIfNotExists(out)
+	if bytes.Contains(out, []byte("main")) || bytes.Contains(out, []byte("temp")) {
+		out = stripQualifiers(out)
+	}
+	if bytes.Contains(out, []byte("generated always as")) {
+		out = stripGeneratedAlways(out)
+	}
+	out = bytes.TrimSpace(out)
+	out = compactPunctuation(out)
+
+	// Copy the literals back in and put a space after every comma
+	size := len(out) + bytes.Count(out, []byte(","))
+	for _, l := range literals {
+		size += l[1] - l[0] - 1
+	}
+	var sb strings.Builder
+	sb.Grow(size)
+	n := 0
+	for i, c := range out {
+		switch c {
+		case literalMark:
+			sb.WriteString(sql[literals[n][0]:literals[n][1]])
+			n++
+		case ',':
+			sb.WriteByte(',')
+			if i < len(out)-1 {
+				sb.WriteByte(' ')
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	buf.out, buf.literals = out[:0], literals[:0]
+	return sb.String()
+}
+
+// literalEnd returns the end of the string literal starting at i, or 0 if
+// it is not terminated. Like stringLiteralRe, an unterminated literal
+// containing doubled quotes ends at the last of them.
+func literalEnd(sql string, i int) int {
+	last := 0
+	for j := i + 1; j < len(sql); j++ {
+		if sql[j] != '\'' {
+			continue
+		}
+		if j+1 < len(sql) && sql[j+1] == '\'' {
+			last = j + 1
+			j++
+			continue
+		}
+		return j + 1
+	}
+	return last
+}
+
+// isNormalizedWordChar reports whether c is part of a word, as \w, or
+// stands in for a literal
+func isNormalizedWordChar(c byte) bool {
+	return c == literalMark || c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// stripIfNotExists removes IF NOT EXISTS from a normalized CREATE
+// statement
+func stripIfNotExists(b []byte) []byte {
+	rest, ok := bytes.CutPrefix(b, []byte("create "))
+	if !ok {
+		return b
+	}
+	for _, optional := range [][]string{{"temp ", "temporary "}, {"unique ", "virtual "}} {
+		for _, word := range optional {
+			if r, ok := bytes.CutPrefix(rest, []byte(word)); ok {
+				rest = r
+				break
+			}
+		}
+	}
+	for _, kind := range []string{"table", "index", "view", "trigger"} {
+		if r, ok := bytes.CutPrefix(rest, []byte(kind)); ok {
+			if r, ok := bytes.CutPrefix(r, []byte(" if not exists ")); ok {
+				at := len(b) - len(r)
+				return append(b[:at-len("if not exists ")], r...)
+			}
+			break
+		}
+	}
+	return b
+}
+
+// stripQualifiers removes main. and temp. qualifiers, but not the last part
+// of a longer dotted name
+func stripQualifiers(b []byte) []byte {
+	w, matched := 0, 0
+	for i := 0; i < len(b); {
+		// A qualifier starts after a character that is neither a dot nor
+		// part of a word, and not right after the previous qualifier
+		if (i == 0 || i > matched && b[i-1] != '.' && !isNormalizedWordChar(b[i-1])) &&
+			(bytes.HasPrefix(b[i:], []byte("main")) || bytes.HasPrefix(b[i:], []byte("temp"))) {
+			j := i + 4
+			if j < len(b) && b[j] == ' ' {
+				j++
+			}
+			if j < len(b) && b[j] == '.' {
+				j++
+				if j < len(b) && b[j] == ' ' {
+					j++
+				}
+				i, matched = j, j
+				continue
+			}
+		}
+		b[w] = b[i]
+		w++
+		i++
+	}
+	return b[:w]
+}
+
+// stripGeneratedAlways shortens GENERATED ALWAYS AS to AS where it
+// stands as whole words
+func stripGeneratedAlways(b []byte) []byte {
+	const phrase = "generated always as"
+	w := 0
+	for i := 0; i < len(b); {
+		end := i + len(phrase)
+		if bytes.HasPrefix(b[i:], []byte(phrase)) &&
+			(i == 0 || !isNormalizedWordChar(b[i-1])) &&
+			(end == len(b) || !isNormalizedWordChar(b[end])) {
+			w += copy(b[w:], "as")
+			i = end
+			continue
+		}
+		b[w] = b[i]
+		w++
+		i++
+	}
+	return b[:w]
+}
+
+// compactPunctuation removes the spaces around ( ) , = -> and ->>
+func compactPunctuation(b []byte) []byte {
+	isPunct := func(c byte) bool {
+		return c == '(' || c == ')' || c == ',' || c == '='
+	}
+	// The characters before the current one, as they were before any
+	// space was removed
+	var p1, p2, p3 byte
+	w := 0
+	for i, c := range b {
+		drop := c == ' ' && (isPunct(p1) ||
+			p2 == '-' && p1 == '>' ||
+			p3 == '-' && p2 == '>' && p1 == '>' ||
+			i+1 < len(b) && isPunct(b[i+1]) ||
+			i+2 < len(b) && b[i+1] == '-' && b[i+2] == '>')
+		p3, p2, p1 = p2, p1, c
+		if drop {
+			continue
+		}
+		b[w] = c
+		w++
+	}
+	return b[:w]
 }
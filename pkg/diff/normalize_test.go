@@ -1,6 +1,8 @@
 package diff
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -35,6 +37,94 @@ func TestNormalizeSQL(t *testing.T) {
 			input: `CREATE TABLE "MyTable" ([id] INT)`,
 			want:  `create table mytable(id int)`,
 		},
+		{
+			name:  "Strips IF NOT EXISTS and schema qualifier",
+			input: `CREATE TABLE IF NOT EXISTS main."users" (id INT)`,
+			want:  `create table users(id int)`,
+		},
+		{
+			name:  "Strips qualifiers inside bodies",
+			input: `CREATE UNIQUE INDEX if  not exists "main" . idx ON main.users(email)`,
+			want:  `create unique index idx on users(email)`,
+		},
+		{
+			name:  "Strips temp qualifier",
+			input: `CREATE VIEW temp.v AS SELECT u.id FROM temp.users u`,
+			want:  `create view v as select u.id from users u`,
+		},
+		{
+			name:  "Keeps columns named main or temp",
+			input: `CREATE VIEW v AS SELECT t.main, r.temp FROM t, r`,
+			want:  `create view v as select t.main, r.temp from t, r`,
+		},
+		{
+			name:  "Keeps qualifiers in string literals",
+			input: `CREATE VIEW IF NOT EXISTS v AS SELECT 'main.users' AS n`,
+			want:  `create view v as select 'main.users' as n`,
+		},
+		{
+			name:  "Strips comments",
+			input: "CREATE TABLE t (\n  id INT, -- row id, (primary)\n  /* note */ name TEXT DEFAULT '--x'\n)",
+			want:  "create table t(id int, name text default '--x')",
+		},
+		{
+			name:  "Compacts punctuation around generated columns",
+			input: "CREATE TABLE IF NOT EXISTS main.t (a INT, b TEXT DEFAULT 'x,  y', c AS (a * 2) STORED);",
+			want:  "create table t(a int, b text default 'x,  y', c as(a * 2)stored)",
+		},
+		{
+			name:  "Strips spaced qualifiers",
+			input: "CREATE TEMPORARY VIEW IF NOT EXISTS temp . v AS SELECT main.t.a FROM main . t WHERE b = 'main.t'",
+			want:  "create temporary view v as select t.a from t where b='main.t'",
+		},
+		{
+			name:  "Strips one qualifier per name",
+			input: "main.main.t, temp. main.x ,domain.x",
+			want:  "main.t, main.x, domain.x",
+		},
+		{
+			name:  "Compacts JSON operators",
+			input: "CREATE INDEX idx ON t (a , b) WHERE b ->> 'k' = 1 AND b -> '$.j' IS NOT NULL",
+			want:  "create index idx on t(a, b)where b->>'$.k'=1 and b->'$.j' is not null",
+		},
+		{
+			name:  "Lowercases Unicode and collapses Unicode spaces",
+			input: "CREATE TABLE t (\"Ünïcode\" TEXT,  x INT\u0085, y TEXT DEFAULT 'ÄÖ')",
+			want:  "create table t(ünïcode text, x int, y text default 'ÄÖ')",
+		},
+		{
+			name:  "Unterminated literal ends at its last doubled quote",
+			input: "CREATE TABLE t (a TEXT DEFAULT 'unterminated''quote",
+			want:  "create table t(a text default 'unterminated' 'quote",
+		},
+		{
+			name:  "Doubled quotes",
+			input: "CREATE TABLE t (a TEXT DEFAULT 'it''s', b TEXT DEFAULT '''', c)",
+			want:  "create table t(a text default 'it''s', b text default '''', c)",
+		},
+		{
+			name:  "Strips GENERATED ALWAYS only as whole words",
+			input: "CREATE TABLE t (a GENERATED ALWAYS AS (1), xgenerated always as (2))",
+			want:  "create table t(a as(1), xgenerated always as(2))",
+		},
+		{
+			name:  "Strips the trailing semicolon only",
+			input: "CREATE TRIGGER trg AFTER INSERT ON t BEGIN UPDATE t SET a = a+1; END ;  ",
+			want:  "create trigger trg after insert on t begin update t set a=a+1; end",
+		},
+		{
+			name:  "Keeps NUL bytes",
+			input: "CREATE TABLE t (a TEXT DEFAULT 'x\x00y', b\x00C)",
+			want:  "create table t(a text default 'x\x00y', b\x00c)",
+		},
+		{
+			name:  "Keeps invalid UTF-8",
+			input: "CREATE TABLE t (\"\xc3\"\xa4\" TEXT, B\xff)",
+			want:  "create table t(\xc3\xa4 text, b\xff)",
+		},
+		{name: "Empty", input: "", want: ""},
+		{name: "Only a semicolon", input: ";", want: ""},
+		{name: "Only a comma", input: "  ,  ", want: ","},
 	}
 
 	for _, tt := range tests {
@@ -46,3 +136,179 @@ func TestNormalizeSQL(t *testing.T) {
 		})
 	}
 }
+
+func FuzzNormalizeSQL(f *testing.F) {
+	f.Add("CREATE TABLE IF NOT EXISTS main.t (a INT, b TEXT DEFAULT 'x,  y')")
+	f.Add("CREATE VIEW v AS SELECT d ->> 'k', 'a''b' FROM temp . t")
+	f.Add("c GENERATED ALWAYS AS (a = b) , \"q\"[x]`y`")
+	f.Fuzz(func(t *testing.T, sql string) {
+		// The pooled buffers must not carry anything over between calls
+		if got, want := normalizeSQL(sql), normalizeSQL(sql); got != want {
+			t.Errorf("normalizeSQL(%q) = %q, then %q", sql, got, want)
+		}
+		for _, l := range stringLiteralRe.FindAllString(sql, -1) {
+			if !strings.Contains(normalizeSQLFast(sql), l) {
+				t.Errorf("normalizeSQLFast(%q) lost the literal %q", sql, l)
+			}
+		}
+	})
+}
+
+// BenchmarkNormalizeSQL measures normalizing the SQL of a large schema,
+// allocations included
+func BenchmarkNormalizeSQL(b *testing.B) {
+	var stmts []string
+	for i := range 500 {
+		stmts = append(stmts,
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "t%d" (id INTEGER PRIMARY KEY, name TEXT NOT NULL DEFAULT 'n/a', data TEXT, `+
+				`kind TEXT GENERATED ALWAYS AS (data ->> '$.kind') VIRTUAL, created_at TEXT DEFAULT CURRENT_TIMESTAMP)`, i),
+			fmt.Sprintf(`CREATE INDEX idx_t%d_name ON main.t%d (name, created_at) WHERE name <> ''`, i, i),
+		)
+	}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		for _, stmt := range stmts {
+			normalizeSQL(stmt)
+		}
+	}
+}
+
+func TestNormalizeSQL_JSONExpressions(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{
+			name: "double-quoted path",
+			a:    `CREATE INDEX idx ON docs(json_extract(data, '$.name'))`,
+			b:    `CREATE INDEX idx ON docs(json_extract(data, "$.name"))`,
+		},
+		{
+			name: "quoted object key",
+			a:    `CREATE INDEX idx ON docs(json_extract(data, '$.user.name'))`,
+			b:    `CREATE INDEX idx ON docs(json_extract(data,'$."user"."name"'))`,
+		},
+		{
+			name: "arrow operator spacing and label shorthand",
+			a:    `CREATE INDEX idx ON docs(data->>'$.name')`,
+			b:    `CREATE INDEX idx ON docs(data ->> 'name')`,
+		},
+		{
+			name: "generated column",
+			a:    `CREATE TABLE docs (data TEXT, name TEXT GENERATED ALWAYS AS (data -> '$.name') VIRTUAL)`,
+			b:    `CREATE TABLE docs (data TEXT, name TEXT AS (data->"$.name") VIRTUAL)`,
+		},
+		{
+			name: "path arguments of json_set",
+			a:    `CREATE VIEW v AS SELECT json_set(data, '$.a', 1, '$.b', 2) FROM docs`,
+			b:    `CREATE VIEW v AS SELECT json_set(data, '$."a"', 1, "$.b", 2) FROM docs`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if a, b := normalizeSQL(tt.a), normalizeSQL(tt.b); a != b {
+				t.Errorf("normalizeSQL() differs:\n%s\n%s", a, b)
+			}
+		})
+	}
+
+	different := []struct{ a, b string }{
+		{`SELECT json_extract(d, '$.a b')`, `SELECT json_extract(d, '$."a b"')`},
+		{`SELECT d->>'$[0]'`, `SELECT d->>'$.0'`},
+		{`SELECT 'name'`, `SELECT '$.name'`},
+		// Strings starting with $ are only paths where SQLite reads them as paths
+		{`CREATE TABLE t (x TEXT CHECK (x <> '$."a"'))`, `CREATE TABLE t (x TEXT CHECK (x <> '$.a'))`},
+		{`SELECT json_set(d, '$.a', '$."b"')`, `SELECT json_set(d, '$.a', '$.b')`},
+		{`SELECT json_extract(d, '$."a"' || k)`, `SELECT json_extract(d, '$.a' || k)`},
+		{`SELECT coalesce(d, '$."a"')`, `SELECT coalesce(d, '$.a')`},
+	}
+	for _, tt := range different {
+		if normalizeSQL(tt.a) == normalizeSQL(tt.b) {
+			t.Errorf("normalizeSQL(%q) should differ from normalizeSQL(%q)", tt.a, tt.b)
+		}
+	}
+}
+
+func TestSQLEquivalent(t *testing.T) {
+	tests := []struct {
+		name   string
+		a, b   string
+		want   bool
+		reason string
+	}{
+		{
+			name:   "identical",
+			a:      "CREATE INDEX idx ON users (email)",
+			b:      "CREATE INDEX idx ON users (email)",
+			want:   true,
+			reason: EquivalentIdentical,
+		},
+		{
+			name:   "whitespace, comments and case",
+			a:      "CREATE INDEX idx ON users (email);",
+			b:      "create index IDX\n  on users(email) -- login",
+			want:   true,
+			reason: EquivalentFormatting,
+		},
+		{
+			name:   "identifier quoting",
+			a:      `CREATE INDEX "idx" ON [users] (` + "`email`" + `)`,
+			b:      "CREATE INDEX idx ON users (email)",
+			want:   true,
+			reason: EquivalentQuoting,
+		},
+		{
+			name:   "normalization",
+			a:      "CREATE INDEX IF NOT EXISTS main.idx ON users (email)",
+			b:      "CREATE INDEX idx ON users (email)",
+			want:   true,
+			reason: EquivalentNormalized,
+		},
+		{
+			name:   "different column",
+			a:      "CREATE INDEX idx ON users (email)",
+			b:      "CREATE INDEX idx ON users (name)",
+			reason: `differs after "(": "email" vs "name"`,
+		},
+		{
+			name:   "string literal case",
+			a:      "CREATE VIEW v AS SELECT 'a'",
+			b:      "CREATE VIEW v AS SELECT 'A'",
+			reason: `differs after "select": "'a'" vs "'A'"`,
+		},
+		{
+			name:   "extra clause",
+			a:      "CREATE INDEX idx ON users (email)",
+			b:      "CREATE INDEX idx ON users (email) WHERE email IS NOT NULL",
+			reason: `differs after ")": end of statement vs "where"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := SQLEquivalent(tt.a, tt.b)
+			if got != tt.want || reason != tt.reason {
+				t.Errorf("SQLEquivalent() = %v, %q, want %v, %q", got, reason, tt.want, tt.reason)
+			}
+		})
+	}
+}
+
+func FuzzSQLEquivalent(f *testing.F) {
+	f.Add("CREATE TABLE t (a INT)", `create table "t"(a int);`)
+	f.Add("CREATE VIEW v AS SELECT 'x' -- c", "CREATE VIEW v AS SELECT 'x")
+	f.Add("/* unterminated", "[")
+	f.Fuzz(func(t *testing.T, a, b string) {
+		if ok, reason := SQLEquivalent(a, a); !ok || reason != EquivalentIdentical {
+			t.Errorf("SQLEquivalent(a, a) = %v, %q", ok, reason)
+		}
+		ab, reason := SQLEquivalent(a, b)
+		if ba, _ := SQLEquivalent(b, a); ab != ba {
+			t.Errorf("SQLEquivalent() is not symmetric for %q and %q", a, b)
+		}
+		if reason == "" {
+			t.Errorf("SQLEquivalent(%q, %q) gave no reason", a, b)
+		}
+	})
+}
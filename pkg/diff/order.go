@@ -0,0 +1,106 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ReorderChanges applies a custom order to a plan, e.g. to create an index
+// before the data migration that backfills its table. The order function
+// must return the same changes, only reordered; the result is checked with
+// ValidateOrder.
+func ReorderChanges(changes []Change, order func(changes []Change) []Change) ([]Change, error) {
+	reordered := order(append([]Change(nil), changes...))
+	if !samePlan(changes, reordered) {
+		return nil, errors.New("reordered plan must contain the same changes")
+	}
+	if err := ValidateOrder(reordered); err != nil {
+		return nil, err
+	}
+	return reordered, nil
+}
+
+// ValidateOrder checks that no change runs before a change it depends on.
+// The order Diff produces always passes; custom orders may move changes
+// freely as long as:
+//   - an object is dropped before it is created again
+//   - indexes and triggers are created after their table is created,
+//     recreated or gets new or renamed columns
+//   - triggers are dropped before their table is recreated
+//   - views are dropped before, and created after, any table change
+//   - data migrations run after the changes they are attached to
+func ValidateOrder(changes []Change) error {
+	for i, later := range changes {
+		for j := i + 1; j < len(changes); j++ {
+			if reason, ok := mustPrecede(changes[j], later); ok {
+				return fmt.Errorf(
+					"%s %q must run before %s %q: %s",
+					changes[j].Type, changes[j].Object, later.Type, later.Object, reason,
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// mustPrecede reports whether change a has to run before change b
+func mustPrecede(a, b Change) (string, bool) {
+	same := func(x, y string) bool { return strings.EqualFold(x, y) }
+	tableChange := func(c Change) bool {
+		switch c.Type {
+		case CreateTable, RecreateTable, AddColumn, RenameColumn, DropColumn, AlterColumnDefault, RenameTable:
+			return true
+		}
+		return false
+	}
+
+	switch {
+	case isDrop(a.Type) && isCreate(b.Type) && objectKind(a.Type) == objectKind(b.Type) && same(a.Object, b.Object):
+		return "an object is dropped before it is created again", true
+	case tableChange(a) && (b.Type == CreateIndex || b.Type == CreateTrigger) && same(a.Object, b.Table):
+		return fmt.Sprintf("it depends on table %q", a.Object), true
+	case a.Type == DropTrigger && (b.RecreatesTable() || b.backfills()) && same(a.Table, b.Object):
+		return "triggers are dropped before their table is recreated or backfilled", true
+	case a.Type == DropView && (b.Type == DropTable || b.Type == RenameTable || b.Type == RenameColumn || b.RecreatesTable()):
+		return "views are dropped before the tables they may use change", true
+	case tableChange(a) && b.Type == CreateView:
+		return "views are created after the tables they may use", true
+	case a.Type != DataMigration && b.Type == DataMigration && same(a.Object, b.Object) &&
+		(a.Column == "" || same(a.Column, b.Column)):
+		return "data migrations run after the changes they are attached to", true
+	}
+	return "", false
+}
+
+func isDrop(t ChangeType) bool {
+	return t == DropTable || t == DropIndex || t == DropView || t == DropTrigger
+}
+
+func isCreate(t ChangeType) bool {
+	return t == CreateTable || t == CreateIndex || t == CreateView || t == CreateTrigger
+}
+
+// samePlan reports whether both lists hold the same changes in any order
+func samePlan(a, b []Change) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[string]int, len(a))
+	for _, c := range a {
+		counts[changeKey(c)]++
+	}
+	for _, c := range b {
+		key := changeKey(c)
+		if counts[key] == 0 {
+			return false
+		}
+		counts[key]--
+	}
+	return true
+}
+
+// changeKey identifies a change by its content
+func changeKey(c Change) string {
+	return strings.Join(append([]string{string(c.Type), c.Object, c.Column, c.Description}, c.SQL...), "\x00")
+}
@@ -0,0 +1,211 @@
+package diff
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+func TestValidateOrder_DiffOrder(t *testing.T) {
+	from, err := parser.FromSQL(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER, body TEXT);
+		CREATE TABLE legacy (id INTEGER PRIMARY KEY);
+		CREATE INDEX idx_posts_user ON posts(user_id);
+		CREATE INDEX idx_legacy ON legacy(id);
+		CREATE VIEW user_names AS SELECT name FROM users;
+		CREATE TRIGGER posts_ai AFTER INSERT ON posts BEGIN SELECT 1; END;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := parser.FromSQL(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, status TEXT);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, body TEXT NOT NULL, user_id INTEGER);
+		CREATE TABLE tags (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE INDEX idx_posts_user ON posts(user_id);
+		CREATE INDEX idx_users_status ON users(status);
+		CREATE INDEX idx_tags_name ON tags(name);
+		CREATE VIEW user_names AS SELECT name, status FROM users;
+		CREATE TRIGGER posts_ai AFTER INSERT ON posts BEGIN SELECT 1; END;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := AttachDataHooks(Diff(from, to), []DataHook{
+		{Name: "status.sql", After: AddColumn, Object: "users", Column: "status", SQL: "UPDATE users SET status = 'active';"},
+	})
+	if err := ValidateOrder(changes); err != nil {
+		t.Errorf("ValidateOrder() on the default order = %v", err)
+	}
+}
+
+func TestValidateOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes []Change
+		wantErr string
+	}{
+		{
+			name: "index before data migration",
+			changes: []Change{
+				{Type: AddColumn, Object: "users", Column: "status"},
+				{Type: CreateIndex, Object: "idx_users_status", Table: "users"},
+				{Type: DataMigration, Object: "users", Column: "status"},
+			},
+		},
+		{
+			name: "data migration before its change",
+			changes: []Change{
+				{Type: DataMigration, Object: "users", Column: "status"},
+				{Type: AddColumn, Object: "users", Column: "status"},
+			},
+			wantErr: "data migrations run after",
+		},
+		{
+			name: "index before its table",
+			changes: []Change{
+				{Type: CreateIndex, Object: "idx_tags_name", Table: "tags"},
+				{Type: CreateTable, Object: "tags"},
+			},
+			wantErr: `depends on table "tags"`,
+		},
+		{
+			name: "index before the recreate that would drop it",
+			changes: []Change{
+				{Type: CreateIndex, Object: "idx_posts_user", Table: "posts"},
+				{Type: RecreateTable, Object: "posts"},
+			},
+			wantErr: `depends on table "posts"`,
+		},
+		{
+			name: "create before drop",
+			changes: []Change{
+				{Type: CreateView, Object: "v"},
+				{Type: DropView, Object: "v"},
+			},
+			wantErr: "dropped before it is created again",
+		},
+		{
+			name: "trigger dropped after recreate",
+			changes: []Change{
+				{Type: RecreateTable, Object: "posts"},
+				{Type: DropTrigger, Object: "posts_ai", Table: "posts"},
+			},
+			wantErr: "triggers are dropped before",
+		},
+		{
+			name: "trigger dropped after backfill",
+			changes: []Change{
+				{Type: AddColumn, Object: "users", Column: "status", SQL: []string{"ALTER TABLE users ADD status;", "UPDATE users SET status = 1;"}},
+				{Type: DropTrigger, Object: "users_au", Table: "users"},
+			},
+			wantErr: "triggers are dropped before",
+		},
+		{
+			name: "view created before a new column",
+			changes: []Change{
+				{Type: CreateView, Object: "v"},
+				{Type: AddColumn, Object: "users", Column: "status"},
+			},
+			wantErr: "views are created after",
+		},
+		{
+			name: "unrelated changes in any order",
+			changes: []Change{
+				{Type: CreateIndex, Object: "idx_a", Table: "a"},
+				{Type: DropIndex, Object: "idx_b", Table: "b"},
+				{Type: CreateTable, Object: "c"},
+				{Type: DropTable, Object: "d"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateOrder(tt.changes)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateOrder() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateOrder() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReorderChanges(t *testing.T) {
+	changes := []Change{
+		{Type: AddColumn, Object: "users", Column: "status", SQL: []string{"ALTER"}},
+		{Type: DataMigration, Object: "users", Column: "status", SQL: []string{"UPDATE"}},
+		{Type: CreateIndex, Object: "idx_users_status", Table: "users", SQL: []string{"CREATE INDEX"}},
+	}
+
+	indexFirst := func(changes []Change) []Change {
+		slices.SortStableFunc(changes, func(a, b Change) int {
+			if a.Type == CreateIndex && b.Type == DataMigration {
+				return -1
+			}
+			if a.Type == DataMigration && b.Type == CreateIndex {
+				return 1
+			}
+			return 0
+		})
+		return changes
+	}
+	got, err := ReorderChanges(changes, indexFirst)
+	if err != nil {
+		t.Fatalf("ReorderChanges() error = %v", err)
+	}
+	if got[1].Type != CreateIndex || got[2].Type != DataMigration {
+		t.Errorf("ReorderChanges() = %v, want the index before the data migration", got)
+	}
+	if changes[1].Type != DataMigration {
+		t.Error("ReorderChanges() modified its input")
+	}
+
+	if _, err := ReorderChanges(changes, func(changes []Change) []Change { return changes[:2] }); err == nil {
+		t.Error("ReorderChanges() accepted a plan with a change removed")
+	}
+	if _, err := ReorderChanges(changes, slices.Clip[[]Change]); err != nil {
+		t.Errorf("ReorderChanges() rejected the unchanged order: %v", err)
+	}
+	reversed := func(changes []Change) []Change {
+		slices.Reverse(changes)
+		return changes
+	}
+	if _, err := ReorderChanges(changes, reversed); err == nil {
+		t.Error("ReorderChanges() accepted a data migration before its column")
+	}
+}
+
+func TestApply_Reorder(t *testing.T) {
+	db, dbPath := createTestDBWithPath(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "users.sql", `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, status TEXT);
+		CREATE INDEX idx_users_status ON users(status);
+	`)
+
+	reversed := func(changes []Change) []Change {
+		slices.Reverse(changes)
+		return changes
+	}
+	err := Apply(db, schemaDir, ApplyOptions{BackupPath: dbPath + ".backup", Reorder: reversed})
+	if err == nil || !strings.Contains(err.Error(), "reorder changes") {
+		t.Fatalf("Apply() = %v, want a reorder error", err)
+	}
+	if changes, _ := Compare(db, schemaDir); len(changes) != 2 {
+		t.Errorf("Apply() changed the database despite the invalid order: %d changes left", len(changes))
+	}
+	if _, err := os.Stat(dbPath + ".backup"); !os.IsNotExist(err) {
+		t.Errorf("backup created before the order was validated (stat error %v)", err)
+	}
+}
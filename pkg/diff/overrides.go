@@ -0,0 +1,113 @@
+package diff
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+// Override replaces the generated SQL of a specific change, for migrations
+// the user knows a better path for. The change is still detected, ordered
+// and reported as usual; only the statements that run differ.
+type Override struct {
+	Name     string     // Shown in output and errors, e.g. the file name
+	Replaces ChangeType // Type of the change to replace
+	Object   string     // Object of the change, usually a table name
+	Column   string     // Optional column for column changes like ADD_COLUMN
+	SQL      string     // Statements to execute instead
+}
+
+// matches reports whether the override is declared to replace c. A
+// RECREATE_TABLE override also replaces column changes applied as recreates.
+func (o Override) matches(c Change) bool {
+	return (c.Type == o.Replaces || o.Replaces == RecreateTable && c.RecreatesTable()) &&
+		strings.EqualFold(c.Object, o.Object) &&
+		(o.Column == "" || strings.EqualFold(c.Column, o.Column))
+}
+
+// replacesAnnotationRe matches the "-- @replaces: RECREATE_TABLE users" header of an override file
+var replacesAnnotationRe = regexp.MustCompile(`(?m)^\s*--\s*@replaces:\s*([A-Za-z_]+)\s+(\S+)\s*$`)
+
+// LoadOverrides reads the overrides/ subdirectory of a schema directory.
+// Every file must declare the change it replaces:
+//
+//	-- overrides/recreate_users.sql
+//	-- @replaces: RECREATE_TABLE users
+//	ALTER TABLE users RENAME TO users_old;
+//	...
+//
+// If parser.SetBaseFS was called, reads from that filesystem instead.
+func LoadOverrides(schemaDir string) ([]Override, error) {
+	files, err := readSQLFiles(filepath.Join(schemaDir, parser.OverridesDir))
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := make([]Override, 0, len(files))
+	for _, file := range files {
+		o, err := parseOverride(file.name, file.content)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, nil
+}
+
+// parseOverride parses an override file with its @replaces header
+func parseOverride(name, content string) (Override, error) {
+	m := replacesAnnotationRe.FindStringSubmatch(content)
+	if m == nil {
+		return Override{}, fmt.Errorf("%s: missing \"-- @replaces: <CHANGE_TYPE> <object>\" header", name)
+	}
+
+	o := Override{
+		Name:     name,
+		Replaces: ChangeType(strings.ToUpper(m[1])),
+		Object:   m[2],
+		SQL:      strings.TrimSpace(content),
+	}
+	if o.Replaces == AddColumn || o.Replaces == RenameColumn {
+		o.Object, o.Column, _ = strings.Cut(m[2], ".")
+	}
+	if _, ok := changePriority[o.Replaces]; !ok {
+		return Override{}, fmt.Errorf("%s: unknown change type %q", name, m[1])
+	}
+	return o, nil
+}
+
+// ApplyOverrides returns changes with the SQL of every change an override
+// is declared for replaced by the override's SQL. Overrides without a
+// matching change are not part of the plan. An override matching several
+// changes, or several overrides for one change, are an error since the
+// override SQL would not run exactly once.
+func ApplyOverrides(changes []Change, overrides []Override) ([]Change, error) {
+	if len(overrides) == 0 {
+		return changes, nil
+	}
+
+	matched := make([]string, len(overrides))
+	result := make([]Change, len(changes))
+	for i, c := range changes {
+		used := ""
+		for j, o := range overrides {
+			if !o.matches(c) {
+				continue
+			}
+			if used != "" {
+				return nil, fmt.Errorf("%s and %s both replace %s %q", used, o.Name, c.Type, c.Object)
+			}
+			if matched[j] != "" {
+				return nil, fmt.Errorf("%s matches both %s and %s", o.Name, matched[j], c.Description)
+			}
+			used, matched[j] = o.Name, c.Description
+			c.SQL = parser.SplitStatements(o.SQL)
+			c.Description = fmt.Sprintf("%s (override %s)", c.Description, o.Name)
+		}
+		result[i] = c
+	}
+	return result, nil
+}
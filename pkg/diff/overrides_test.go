@@ -0,0 +1,167 @@
+package diff
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+func TestParseOverride(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    Override
+		wantErr bool
+	}{
+		{
+			name:    "table override",
+			content: "-- @replaces: RECREATE_TABLE users\nALTER TABLE users RENAME TO users_old;",
+			want:    Override{Replaces: RecreateTable, Object: "users"},
+		},
+		{
+			name:    "column override",
+			content: "-- Backfill while adding\n-- @replaces: add_column users.status\nALTER TABLE users ADD COLUMN status TEXT;",
+			want:    Override{Replaces: AddColumn, Object: "users", Column: "status"},
+		},
+		{
+			name:    "missing header",
+			content: "-- @after: RECREATE_TABLE users\nSELECT 1;",
+			wantErr: true,
+		},
+		{
+			name:    "unknown change type",
+			content: "-- @replaces: TRUNCATE users\nSELECT 1;",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOverride("override.sql", tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOverride() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Replaces != tt.want.Replaces || got.Object != tt.want.Object || got.Column != tt.want.Column {
+				t.Errorf("parseOverride() = %+v, want %+v", got, tt.want)
+			}
+			if got.SQL != strings.TrimSpace(tt.content) {
+				t.Errorf("SQL = %q, want the file content", got.SQL)
+			}
+		})
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	changes := []Change{
+		{Type: AddColumn, Object: "users", Column: "name", Description: "Add column users.name", SQL: []string{"ALTER 1"}},
+		{Type: AddColumn, Object: "users", Column: "status", Description: "Add column users.status", SQL: []string{"ALTER 2"}},
+		{Type: CreateIndex, Object: "idx_users_status", SQL: []string{"CREATE INDEX"}},
+	}
+
+	got, err := ApplyOverrides(changes, []Override{
+		{Name: "status.sql", Replaces: AddColumn, Object: "USERS", Column: "status", SQL: "CUSTOM 1;\nCUSTOM 2;"},
+		{Name: "unmatched.sql", Replaces: DropTable, Object: "users", SQL: "UNUSED"},
+	})
+	if err != nil {
+		t.Fatalf("ApplyOverrides() error = %v", err)
+	}
+	if len(got) != 3 || !slices.Equal(got[1].SQL, []string{"CUSTOM 1;", "CUSTOM 2;"}) || got[0].SQL[0] != "ALTER 1" {
+		t.Errorf("ApplyOverrides() = %+v, want only the status column replaced", got)
+	}
+	if !strings.Contains(got[1].Description, "override status.sql") {
+		t.Errorf("Description = %q, want the override named", got[1].Description)
+	}
+	if changes[1].SQL[0] != "ALTER 2" {
+		t.Error("ApplyOverrides() modified its input")
+	}
+
+	dropColumn := []Change{{
+		Type:   DropColumn,
+		Object: "users",
+		Column: "legacy",
+		SQL:    []string{`CREATE TABLE "users__new" (id INTEGER);`, `INSERT INTO "users__new" ("id") SELECT "id" FROM "users";`},
+	}}
+	got, err = ApplyOverrides(dropColumn, []Override{{Name: "users.sql", Replaces: RecreateTable, Object: "users", SQL: "CUSTOM"}})
+	if err != nil || got[0].SQL[0] != "CUSTOM;" {
+		t.Errorf("ApplyOverrides() = %+v, %v, want a RECREATE_TABLE override to replace DROP_COLUMN", got, err)
+	}
+
+	tests := []struct {
+		name      string
+		overrides []Override
+		wantErr   string
+	}{
+		{
+			name:      "override matches several changes",
+			overrides: []Override{{Name: "columns.sql", Replaces: AddColumn, Object: "users", SQL: "CUSTOM"}},
+			wantErr:   "matches both",
+		},
+		{
+			name: "two overrides for one change",
+			overrides: []Override{
+				{Name: "a.sql", Replaces: CreateIndex, Object: "idx_users_status", SQL: "A"},
+				{Name: "b.sql", Replaces: CreateIndex, Object: "idx_users_status", SQL: "B"},
+			},
+			wantErr: "both replace",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ApplyOverrides(changes, tt.overrides)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ApplyOverrides() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApply_Override(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+		INSERT INTO users (id, name) VALUES (1, NULL), (2, 'bob');
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "users.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);`)
+
+	// The generated recreate would fail on the NULL name, the override fills it in
+	if err := os.Mkdir(filepath.Join(schemaDir, parser.OverridesDir), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	override := `-- @replaces: RECREATE_TABLE users
+		CREATE TABLE users_new (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+		INSERT INTO users_new (id, name) SELECT id, coalesce(name, 'unknown') FROM users;
+		DROP TABLE users;
+		ALTER TABLE users_new RENAME TO users;`
+	if err := os.WriteFile(filepath.Join(schemaDir, parser.OverridesDir, "recreate_users.sql"), []byte(override), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := Compare(db, schemaDir)
+	if err != nil {
+		t.Fatalf("Compare() error = %v", err)
+	}
+	if len(changes) != 1 || changes[0].Type != RecreateTable || !strings.Contains(changes[0].Description, "recreate_users.sql") {
+		t.Fatalf("Compare() = %+v, want the overridden recreate", changes)
+	}
+	if !changes[0].Destructive {
+		t.Error("overridden recreate is no longer destructive")
+	}
+
+	if err := Apply(db, schemaDir, ApplyOptions{}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	var name string
+	if err := db.QueryRow("SELECT name FROM users WHERE id = 1").Scan(&name); err != nil || name != "unknown" {
+		t.Errorf("name = %q (err %v), want the override's value", name, err)
+	}
+	if remaining, err := Compare(db, schemaDir); err != nil || len(remaining) != 0 {
+		t.Errorf("changes remaining after Apply: %v (err %v)", remaining, err)
+	}
+}
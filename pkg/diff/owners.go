@@ -0,0 +1,146 @@
+package diff
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// ErrApprovalRequired is returned when a plan changes objects owned by
+// teams that did not approve it
+var ErrApprovalRequired = errors.New("plan changes objects owned by other teams")
+
+// ownerAnnotationRe matches an "-- @owner: <team>" annotation
+var ownerAnnotationRe = regexp.MustCompile(`--\s*@owner:\s*(\S+)`)
+
+// objectOwner returns the team named by an "-- @owner:" annotation in a
+// CREATE statement, or ""
+func objectOwner(sql string) string {
+	if m := ownerAnnotationRe.FindStringSubmatch(sql); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// Owners maps object names to the teams owning them
+type Owners map[string]string
+
+// SchemaOwners returns the owners annotated in the CREATE statements of two
+// schemas, e.g.
+//
+//	CREATE TABLE invoices ( -- @owner: payments
+//
+// The annotation is inside the statement, so SQLite stores it and tables
+// dropped from the schema files keep their owner in the current schema. The
+// target schema wins where both annotate an object. A renamed table keeps
+// the owner of its old name, and indexes and triggers without an
+// annotation belong to the owner of their table.
+func SchemaOwners(current, target *schema.Database) Owners {
+	owners := make(Owners)
+	for _, s := range []*schema.Database{current, target} {
+		if s == nil {
+			continue
+		}
+		for name, t := range s.Tables {
+			if owner := objectOwner(t.SQL); owner != "" {
+				owners[name] = owner
+			}
+		}
+		for name, v := range s.Views {
+			if owner := objectOwner(v.SQL); owner != "" {
+				owners[name] = owner
+			}
+		}
+	}
+	if current != nil && target != nil {
+		if from, to := renamedTable(current, target); from != "" && owners[to] == "" && owners[from] != "" {
+			owners[to] = owners[from]
+		}
+	}
+	for _, s := range []*schema.Database{current, target} {
+		if s == nil {
+			continue
+		}
+		for name, idx := range s.Indexes {
+			if owner := cmp.Or(objectOwner(idx.SQL), owners[idx.Table]); owner != "" {
+				owners[name] = owner
+			}
+		}
+		for name, tr := range s.Triggers {
+			if owner := cmp.Or(objectOwner(tr.SQL), owners[tr.Table]); owner != "" {
+				owners[name] = owner
+			}
+		}
+	}
+	return owners
+}
+
+// ChangeOwner returns the team owning the object a change touches, or the
+// table of the index or trigger it touches, or "" if nobody owns it
+func (o Owners) ChangeOwner(c Change) string {
+	return cmp.Or(o[c.Object], o[c.Table])
+}
+
+// OwnerGroup is the changes of a plan touching objects of one owner
+type OwnerGroup struct {
+	Owner   string // "" for unowned objects
+	Changes []Change
+}
+
+// GroupByOwner groups a plan by owner, keeping the order of the changes
+// within each group. Groups are sorted by owner, unowned changes last.
+func GroupByOwner(changes []Change, owners Owners) []OwnerGroup {
+	byOwner := make(map[string][]Change)
+	for _, c := range changes {
+		owner := owners.ChangeOwner(c)
+		byOwner[owner] = append(byOwner[owner], c)
+	}
+	var groups []OwnerGroup
+	for _, owner := range slices.Sorted(maps.Keys(byOwner)) {
+		if owner != "" {
+			groups = append(groups, OwnerGroup{Owner: owner, Changes: byOwner[owner]})
+		}
+	}
+	if unowned := byOwner[""]; len(unowned) > 0 {
+		groups = append(groups, OwnerGroup{Changes: unowned})
+	}
+	return groups
+}
+
+// sameTeam compares team names, ignoring case and a leading @ as in
+// CODEOWNERS files
+func sameTeam(a, b string) bool {
+	return strings.EqualFold(strings.TrimPrefix(a, "@"), strings.TrimPrefix(b, "@"))
+}
+
+// CheckApprovals fails with ErrApprovalRequired unless every team owning
+// an object the plan touches is team, the team applying it, or has approved
+// the plan (approvedBy)
+func CheckApprovals(changes []Change, owners Owners, team string, approvedBy []string) error {
+	var missing []string
+	for _, g := range GroupByOwner(changes, owners) {
+		if g.Owner == "" || sameTeam(g.Owner, team) {
+			continue
+		}
+		if slices.ContainsFunc(approvedBy, func(a string) bool { return sameTeam(a, g.Owner) }) {
+			continue
+		}
+		objects := make([]string, 0, len(g.Changes))
+		for _, c := range g.Changes {
+			if !slices.Contains(objects, c.Object) {
+				objects = append(objects, c.Object)
+			}
+		}
+		missing = append(missing, fmt.Sprintf("%s (%s)", g.Owner, strings.Join(objects, ", ")))
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w, approval needed from:\n  %s", ErrApprovalRequired, strings.Join(missing, "\n  "))
+	}
+	return nil
+}
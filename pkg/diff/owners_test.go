@@ -0,0 +1,98 @@
+package diff
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+func TestSchemaOwners(t *testing.T) {
+	current := &schema.Database{
+		Tables: map[string]*schema.Table{
+			"invoices": {Name: "invoices", SQL: "CREATE TABLE invoices ( -- @owner: payments\n  id INTEGER PRIMARY KEY\n)"},
+			"users":    {Name: "users", SQL: "CREATE TABLE users (\n  -- @owner: identity\n  id INTEGER PRIMARY KEY\n)"},
+		},
+		Indexes: map[string]*schema.Index{
+			"idx_invoices_id": {Name: "idx_invoices_id", Table: "invoices", SQL: "CREATE INDEX idx_invoices_id ON invoices(id)"},
+		},
+	}
+	target := &schema.Database{
+		Tables: map[string]*schema.Table{
+			"users": {Name: "users", SQL: "CREATE TABLE users ( -- @owner: @acme/accounts\n  id INTEGER PRIMARY KEY\n)"},
+			"notes": {Name: "notes", SQL: "CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT)"},
+		},
+		Triggers: map[string]*schema.Trigger{
+			"audit_users": {Name: "audit_users", Table: "users", SQL: "CREATE TRIGGER audit_users AFTER INSERT ON users BEGIN SELECT 1; END"},
+		},
+		Views: map[string]*schema.View{
+			"report": {Name: "report", SQL: "CREATE VIEW report AS -- @owner: analytics\nSELECT 1"},
+		},
+	}
+
+	owners := SchemaOwners(current, target)
+	want := map[string]string{
+		"invoices":        "payments", // Dropped from the schema files, still annotated in the database
+		"idx_invoices_id": "payments",
+		"users":           "@acme/accounts",
+		"audit_users":     "@acme/accounts",
+		"report":          "analytics",
+	}
+	if len(owners) != len(want) {
+		t.Errorf("owners = %v, want %v", owners, want)
+	}
+	for name, owner := range want {
+		if owners[name] != owner {
+			t.Errorf("owner of %s = %q, want %q", name, owners[name], owner)
+		}
+	}
+}
+
+func TestCheckApprovals(t *testing.T) {
+	owners := Owners{"invoices": "payments", "users": "@acme/accounts"}
+	changes := []Change{
+		{Type: AddColumn, Object: "users", Column: "email"},
+		{Type: DropTable, Object: "invoices"},
+		{Type: CreateIndex, Object: "idx_invoices_total", Table: "invoices"},
+		{Type: CreateTable, Object: "notes"},
+	}
+
+	groups := GroupByOwner(changes, owners)
+	var got []string
+	for _, g := range groups {
+		got = append(got, g.Owner)
+	}
+	if strings.Join(got, ",") != "@acme/accounts,payments," || len(groups[1].Changes) != 2 {
+		t.Errorf("groups = %+v, want accounts, payments with two changes, unowned", groups)
+	}
+
+	err := CheckApprovals(changes, owners, "acme/accounts", nil)
+	if !errors.Is(err, ErrApprovalRequired) || !strings.Contains(err.Error(), "payments (invoices, idx_invoices_total)") {
+		t.Errorf("error = %v, want an approval needed from payments", err)
+	}
+	if strings.Contains(err.Error(), "accounts") {
+		t.Errorf("the applying team should not need to approve: %v", err)
+	}
+	if err := CheckApprovals(changes, owners, "", []string{"@Payments", "acme/accounts"}); err != nil {
+		t.Errorf("approved plan refused: %v", err)
+	}
+}
+
+func TestApply_RequireApproval(t *testing.T) {
+	db := openTestDB(t, `CREATE TABLE invoices ( -- @owner: payments
+		id INTEGER PRIMARY KEY
+	);`)
+	defer func() { _ = db.Close() }()
+	// Renaming the table needs the approval of its owner
+	schemaDir := createSchemaDir(t, "schema.sql", `CREATE TABLE notes (id INTEGER PRIMARY KEY);`)
+
+	opts := ApplyOptions{RequireApproval: true, Team: "docs"}
+	if err := Apply(db, schemaDir, opts); !errors.Is(err, ErrApprovalRequired) {
+		t.Fatalf("Apply() error = %v, want ErrApprovalRequired", err)
+	}
+	opts.ApprovedBy = []string{"payments"}
+	if err := Apply(db, schemaDir, opts); err != nil {
+		t.Errorf("Apply() with approval error: %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package diff
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// PartitionInterval is the period of time one partition table holds
+type PartitionInterval string
+
+const (
+	PartitionDaily   PartitionInterval = "daily"   // events_2024_01_31
+	PartitionMonthly PartitionInterval = "monthly" // events_2024_01
+	PartitionYearly  PartitionInterval = "yearly"  // events_2024
+)
+
+// partitionLayouts are the time layouts of the partition name suffixes
+var partitionLayouts = map[PartitionInterval]string{
+	PartitionDaily:   "2006_01_02",
+	PartitionMonthly: "2006_01",
+	PartitionYearly:  "2006",
+}
+
+// Partitioning declares a time-partitioned table: a template table whose
+// copies hold one period each, named after the template and the period,
+// e.g. events_2024_01. Partitions are managed by the plan instead of the
+// schema files: the partitions of upcoming periods are created ahead of
+// time, expired ones are dropped, and none of them is reported as drift.
+type Partitioning struct {
+	Name     string            // Shown in output and errors, e.g. the file name
+	Table    string            // Template table, the prefix of the partition names
+	Interval PartitionInterval // Period of one partition
+	Retain   int               // Periods kept before the current one, 0 keeps all
+	Ahead    int               // Periods created after the current one
+	SQL      string            // CREATE TABLE of the template and CREATE INDEX statements on it
+}
+
+var (
+	// partitionAnnotationRe matches the "-- @partition: monthly" header of a partition template
+	partitionAnnotationRe = regexp.MustCompile(`(?m)^\s*--\s*@partition:\s*(\S+)\s*$`)
+	// retainAnnotationRe matches the "-- @retain: 12" header of a partition template
+	retainAnnotationRe = regexp.MustCompile(`(?m)^\s*--\s*@retain:\s*(\S+)\s*$`)
+	// aheadAnnotationRe matches the "-- @ahead: 2" header of a partition template
+	aheadAnnotationRe = regexp.MustCompile(`(?m)^\s*--\s*@ahead:\s*(\S+)\s*$`)
+	// indexHeaderRe matches the name and table of a CREATE INDEX statement
+	indexHeaderRe = regexp.MustCompile(`(?is)^(CREATE\s+(?:UNIQUE\s+)?INDEX\s+(?:IF\s+NOT\s+EXISTS\s+)?)("(?:[^"]|"")*"|\[[^\]]*\]|\x60(?:[^\x60]|\x60\x60)*\x60|\w+)(\s+ON\s+)(?:"(?:[^"]|"")*"|\[[^\]]*\]|\x60(?:[^\x60]|\x60\x60)*\x60|\w+)`)
+)
+
+// LoadPartitions reads the partition templates from the partitions/
+// subdirectory of a schema directory. Every file declares one partitioned
+// table and its interval, and optionally how many past periods to retain
+// and how many upcoming ones to create (default 1):
+//
+//	-- partitions/events.sql
+//	-- @partition: monthly
+//	-- @retain: 12
+//	-- @ahead: 2
+//	CREATE TABLE events (id INTEGER PRIMARY KEY, at TEXT NOT NULL, payload TEXT);
+//	CREATE INDEX idx_events_at ON events (at);
+//
+// If parser.SetBaseFS was called, reads from that filesystem instead.
+func LoadPartitions(schemaDir string) ([]Partitioning, error) {
+	files, err := readSQLFiles(filepath.Join(schemaDir, parser.PartitionsDir))
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := make([]Partitioning, 0, len(files))
+	for _, file := range files {
+		p, err := parsePartitioning(file.name, file.content)
+		if err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, p)
+	}
+	return partitions, nil
+}
+
+// parsePartitioning parses a partition template with its headers
+func parsePartitioning(name, content string) (Partitioning, error) {
+	m := partitionAnnotationRe.FindStringSubmatch(content)
+	if m == nil {
+		return Partitioning{}, fmt.Errorf("%s: missing \"-- @partition: daily|monthly|yearly\" header", name)
+	}
+	p := Partitioning{Name: name, Interval: PartitionInterval(strings.ToLower(m[1])), Ahead: 1, SQL: strings.TrimSpace(content)}
+	if _, ok := partitionLayouts[p.Interval]; !ok {
+		return Partitioning{}, fmt.Errorf("%s: unknown partition interval %q, must be daily, monthly or yearly", name, m[1])
+	}
+	for header, field := range map[*regexp.Regexp]*int{retainAnnotationRe: &p.Retain, aheadAnnotationRe: &p.Ahead} {
+		if m := header.FindStringSubmatch(content); m != nil {
+			n, err := strconv.Atoi(m[1])
+			if err != nil || n < 0 {
+				return Partitioning{}, fmt.Errorf("%s: %q is not a number of periods", name, m[0])
+			}
+			*field = n
+		}
+	}
+
+	template, err := parser.FromSQL(content)
+	if err != nil {
+		return Partitioning{}, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(template.Tables) != 1 || len(template.Views) > 0 || len(template.Triggers) > 0 {
+		return Partitioning{}, fmt.Errorf("%s: a partition template holds exactly one CREATE TABLE and its indexes", name)
+	}
+	for table := range template.Tables {
+		p.Table = table
+	}
+	return p, nil
+}
+
+// PartitionName returns the name of the partition holding t
+func (p Partitioning) PartitionName(t time.Time) string {
+	return p.Table + "_" + t.UTC().Format(partitionLayouts[p.Interval])
+}
+
+// partitionStart returns the start of the period of a partition name, and
+// false if the name is not one of p's partitions
+func (p Partitioning) partitionStart(name string) (time.Time, bool) {
+	suffix, ok := strings.CutPrefix(name, p.Table+"_")
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(partitionLayouts[p.Interval], suffix)
+	if err != nil || p.PartitionName(t) != name {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// period returns the start of the period n periods after the one holding t
+func (p Partitioning) period(t time.Time, n int) time.Time {
+	t = t.UTC()
+	switch p.Interval {
+	case PartitionDaily:
+		return time.Date(t.Year(), t.Month(), t.Day()+n, 0, 0, 0, 0, time.UTC)
+	case PartitionMonthly:
+		return time.Date(t.Year(), t.Month()+time.Month(n), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(t.Year()+n, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// isPartition reports whether a table is a partition of any of partitions
+func isPartition(table string, partitions []Partitioning) bool {
+	return slices.ContainsFunc(partitions, func(p Partitioning) bool {
+		_, ok := p.partitionStart(table)
+		return ok
+	})
+}
+
+// withoutPartitions returns s without the partition tables of partitions
+// and their indexes and triggers, or s itself if there are none
+func withoutPartitions(s *schema.Database, partitions []Partitioning) *schema.Database {
+	if len(partitions) == 0 || s == nil {
+		return s
+	}
+	stripped := &schema.Database{
+		Tables:   maps.Clone(s.Tables),
+		Indexes:  maps.Clone(s.Indexes),
+		Views:    s.Views,
+		Triggers: maps.Clone(s.Triggers),
+	}
+	maps.DeleteFunc(stripped.Tables, func(name string, _ *schema.Table) bool { return isPartition(name, partitions) })
+	maps.DeleteFunc(stripped.Indexes, func(_ string, idx *schema.Index) bool { return isPartition(idx.Table, partitions) })
+	maps.DeleteFunc(stripped.Triggers, func(_ string, tr *schema.Trigger) bool { return isPartition(tr.Table, partitions) })
+	return stripped
+}
+
+// PartitionChanges plans the maintenance of the partitioned tables at time
+// now: creating the partitions of the current and the next Ahead periods
+// that current lacks, and dropping the partitions more than Retain periods
+// before the current one. Partitions of past periods that are missing are
+// not created. Indexes of the template are created on every new partition,
+// with the template's table name in the index name replaced by the
+// partition's.
+func PartitionChanges(current *schema.Database, partitions []Partitioning, now time.Time) []Change {
+	var changes []Change
+	for _, p := range partitions {
+		if p.Retain > 0 {
+			oldest := p.period(now, -p.Retain)
+			for _, name := range slices.Sorted(maps.Keys(current.Tables)) {
+				if start, ok := p.partitionStart(name); ok && start.Before(oldest) {
+					changes = append(changes, Change{
+						Type:        DropTable,
+						Object:      name,
+						Description: fmt.Sprintf("Drop expired partition %q (%s retains %d)", name, p.Name, p.Retain),
+						SQL:         []string{fmt.Sprintf("DROP TABLE %q;", name)},
+						Destructive: true,
+						Reason:      PartitionExpired,
+					})
+				}
+			}
+		}
+
+		for i := 0; i <= p.Ahead; i++ {
+			name := p.PartitionName(p.period(now, i))
+			if _, exists := current.Tables[name]; exists {
+				continue
+			}
+			changes = append(changes, p.createPartition(name)...)
+		}
+	}
+	setSeverities(changes)
+	return changes
+}
+
+// createPartition returns the changes creating a partition and its indexes
+// from the template
+func (p Partitioning) createPartition(name string) []Change {
+	var changes []Change
+	for _, stmt := range parser.SplitStatements(p.SQL) {
+		stmt = strings.TrimSpace(stripComments(stmt))
+		if m := indexHeaderRe.FindStringSubmatch(stmt); m != nil {
+			template := unquoteIdent(m[2])
+			index := strings.Replace(template, p.Table, name, 1)
+			if index == template {
+				index += "_" + strings.TrimPrefix(name, p.Table+"_")
+			}
+			changes = append(changes, Change{
+				Type:        CreateIndex,
+				Object:      index,
+				Table:       name,
+				Description: fmt.Sprintf("Create index %q on partition %q", index, name),
+				SQL:         []string{ensureSemicolon(indexHeaderRe.ReplaceAllString(stmt, fmt.Sprintf("${1}%q${3}%q", index, name)))},
+				Reason:      PartitionScheduled,
+			})
+			continue
+		}
+		changes = append(changes, Change{
+			Type:        CreateTable,
+			Object:      name,
+			Description: fmt.Sprintf("Create partition %q from %s", name, p.Name),
+			SQL:         []string{ensureSemicolon(replaceTableName(stmt, name))},
+			Reason:      PartitionScheduled,
+		})
+	}
+	return changes
+}
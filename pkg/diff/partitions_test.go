@@ -0,0 +1,160 @@
+package diff
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+const eventsTemplate = `-- @partition: monthly
+-- @retain: 2
+CREATE TABLE events (id INTEGER PRIMARY KEY, at TEXT NOT NULL, payload TEXT);
+CREATE INDEX idx_events_at ON events (at);
+`
+
+func TestParsePartitioning(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    Partitioning
+		wantErr bool
+	}{
+		{
+			name:    "monthly with retention",
+			content: eventsTemplate,
+			want:    Partitioning{Table: "events", Interval: PartitionMonthly, Retain: 2, Ahead: 1},
+		},
+		{
+			name:    "daily without retention",
+			content: "-- @partition: Daily\n-- @ahead: 7\nCREATE TABLE hits (path TEXT);",
+			want:    Partitioning{Table: "hits", Interval: PartitionDaily, Ahead: 7},
+		},
+		{
+			name:    "missing header",
+			content: "CREATE TABLE hits (path TEXT);",
+			wantErr: true,
+		},
+		{
+			name:    "unknown interval",
+			content: "-- @partition: hourly\nCREATE TABLE hits (path TEXT);",
+			wantErr: true,
+		},
+		{
+			name:    "negative retention",
+			content: "-- @partition: yearly\n-- @retain: -1\nCREATE TABLE hits (path TEXT);",
+			wantErr: true,
+		},
+		{
+			name:    "two tables",
+			content: "-- @partition: yearly\nCREATE TABLE hits (path TEXT);\nCREATE TABLE misses (path TEXT);",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePartitioning("template.sql", tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePartitioning() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Table != tt.want.Table || got.Interval != tt.want.Interval || got.Retain != tt.want.Retain || got.Ahead != tt.want.Ahead {
+				t.Errorf("parsePartitioning() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPartitionChanges(t *testing.T) {
+	p, err := parsePartitioning("events.sql", eventsTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := parser.FromSQL(`
+		CREATE TABLE events_2023_12 (id INTEGER PRIMARY KEY, at TEXT NOT NULL, payload TEXT);
+		CREATE TABLE events_2024_01 (id INTEGER PRIMARY KEY, at TEXT NOT NULL, payload TEXT);
+		CREATE TABLE events_2024_03 (id INTEGER PRIMARY KEY, at TEXT NOT NULL, payload TEXT);
+		CREATE TABLE events_archive (id INTEGER PRIMARY KEY);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := PartitionChanges(current, []Partitioning{p}, time.Date(2024, 3, 31, 23, 0, 0, 0, time.UTC))
+	var got []string
+	for _, c := range changes {
+		got = append(got, string(c.Type)+" "+c.Object)
+	}
+	// March and the two months before are kept, April is created ahead
+	want := []string{"DROP_TABLE events_2023_12", "CREATE_TABLE events_2024_04", "CREATE_INDEX idx_events_2024_04_at"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("changes = %q, want %q", got, want)
+	}
+	if !changes[0].Destructive || changes[0].Reason != PartitionExpired {
+		t.Errorf("drop = %+v, want a destructive expired partition", changes[0])
+	}
+	if sql := changes[2].SQL[0]; sql != `CREATE INDEX "idx_events_2024_04_at" ON "events_2024_04" (at);` {
+		t.Errorf("index SQL = %s", sql)
+	}
+}
+
+func TestCompare_Partitions(t *testing.T) {
+	now := time.Now().UTC()
+	p := Partitioning{Table: "events", Interval: PartitionMonthly}
+	stale := p.PartitionName(p.period(now, -3))
+
+	db := openTestDB(t, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY);
+		CREATE TABLE "`+stale+`" (id INTEGER PRIMARY KEY, at TEXT NOT NULL, payload TEXT);
+	`)
+	defer func() { _ = db.Close() }()
+
+	schemaDir := createSchemaDir(t, "users.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	if err := os.MkdirAll(filepath.Join(schemaDir, parser.PartitionsDir), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(schemaDir, parser.PartitionsDir, "events.sql"), []byte(eventsTemplate), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := Compare(db, schemaDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var descriptions []string
+	for _, c := range changes {
+		if c.Reason != PartitionScheduled && c.Reason != PartitionExpired {
+			t.Errorf("partition reported as drift: %s", c.Description)
+		}
+		descriptions = append(descriptions, c.Description)
+	}
+	if len(changes) != 5 || changes[0].Object != stale {
+		t.Fatalf("changes = %q, want dropping %s and creating two partitions with their indexes", descriptions, stale)
+	}
+
+	if err := Apply(db, schemaDir, ApplyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	changes, err = Compare(db, schemaDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("changes after apply = %+v, want none", changes)
+	}
+	for _, name := range []string{p.PartitionName(now), p.PartitionName(p.period(now, 1))} {
+		var n int
+		if err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE tbl_name = ?", name).Scan(&n); err != nil || n != 2 {
+			t.Errorf("%s has %d objects (%v), want the table and its index", name, n, err)
+		}
+	}
+	if tables, _ := queryStrings(db, "SELECT name FROM sqlite_master WHERE name = ?", stale); len(tables) > 0 {
+		t.Errorf("expired partition %s was not dropped", stale)
+	}
+}
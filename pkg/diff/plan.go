@@ -0,0 +1,270 @@
+package diff
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// PlanFormatVersion is the version of the plan JSON structure
+const PlanFormatVersion = "1.0"
+
+// Plan actions, as used by infrastructure plan formats
+const (
+	ActionCreate = "create"
+	ActionDelete = "delete"
+	ActionUpdate = "update"
+)
+
+// Plan is a machine-readable migration plan in the shape of a Terraform
+// plan: one resource change per addressed object, with its prior and new
+// values. Addresses are stable across runs, e.g. table.users,
+// table.users.column.email, index.idx_users_email.
+type Plan struct {
+	FormatVersion   string           `json:"format_version"`
+	Destructive     bool             `json:"destructive"`
+	Severity        Severity         `json:"severity,omitempty"` // Highest severity of the changes, empty without changes
+	ResourceChanges []ResourceChange `json:"resource_changes"`
+}
+
+// ResourceChange is the planned change of one object
+type ResourceChange struct {
+	Address         string         `json:"address"`
+	PreviousAddress string         `json:"previous_address,omitempty"` // Prior address of a renamed column
+	Type            string         `json:"type"`                       // table, column, index, view, trigger or data_migration
+	Name            string         `json:"name"`
+	Change          ResourceDetail `json:"change"`
+}
+
+// ResourceDetail describes how an object changes. Actions is [create],
+// [delete], [update], or [delete, create] for an object that is dropped and
+// created again. Before is null for created objects, After for deleted ones.
+type ResourceDetail struct {
+	Actions     []string     `json:"actions"`
+	Before      any          `json:"before"`
+	After       any          `json:"after"`
+	Destructive bool         `json:"destructive"`
+	Severity    Severity     `json:"severity"` // Highest severity of the changes to the object
+	Reasons     []Reason     `json:"reasons"`
+	ChangeTypes []ChangeType `json:"change_types"`
+	ChangeIDs   []string     `json:"change_ids"` // Change.ID of every change to the object
+	SQL         []string     `json:"sql"`
+}
+
+// TableValues are the attributes of a table in a plan
+type TableValues struct {
+	Name    string         `json:"name"`
+	SQL     string         `json:"sql"`
+	Columns []ColumnValues `json:"columns"`
+}
+
+// ColumnValues are the attributes of a column in a plan
+type ColumnValues struct {
+	Name       string  `json:"name"`
+	Type       string  `json:"type"`
+	NotNull    bool    `json:"not_null"`
+	Default    *string `json:"default"`
+	PrimaryKey int     `json:"primary_key"`
+	Generated  bool    `json:"generated"`
+}
+
+// ObjectValues are the attributes of an index, view or trigger in a plan
+type ObjectValues struct {
+	Name  string `json:"name"`
+	Table string `json:"table,omitempty"`
+	SQL   string `json:"sql"`
+}
+
+// BuildPlan describes a list of changes between two schemas as a Plan. A
+// drop and create of the same object are merged into one resource change.
+func BuildPlan(from, to *schema.Database, changes []Change) *Plan {
+	plan := &Plan{FormatVersion: PlanFormatVersion, Severity: MaxSeverity(changes), ResourceChanges: []ResourceChange{}}
+	seen := make(map[string]int) // Address to index in ResourceChanges
+
+	for _, c := range changes {
+		rc := resourceChange(from, to, c)
+		plan.Destructive = plan.Destructive || c.Destructive
+
+		if i, ok := seen[rc.Address]; ok {
+			merged := &plan.ResourceChanges[i].Change
+			for _, a := range rc.Change.Actions {
+				if !slices.Contains(merged.Actions, a) {
+					merged.Actions = append(merged.Actions, a)
+				}
+			}
+			if slices.Contains(merged.Actions, ActionDelete) && slices.Contains(merged.Actions, ActionCreate) {
+				merged.Actions = []string{ActionDelete, ActionCreate}
+			}
+			merged.Before = firstNonNil(merged.Before, rc.Change.Before)
+			merged.After = firstNonNil(merged.After, rc.Change.After)
+			merged.Destructive = merged.Destructive || rc.Change.Destructive
+			if rc.Change.Severity.Above(merged.Severity) {
+				merged.Severity = rc.Change.Severity
+			}
+			merged.Reasons = appendUnique(merged.Reasons, rc.Change.Reasons...)
+			merged.ChangeTypes = appendUnique(merged.ChangeTypes, rc.Change.ChangeTypes...)
+			merged.ChangeIDs = append(merged.ChangeIDs, rc.Change.ChangeIDs...)
+			merged.SQL = append(merged.SQL, rc.Change.SQL...)
+			continue
+		}
+		seen[rc.Address] = len(plan.ResourceChanges)
+		plan.ResourceChanges = append(plan.ResourceChanges, rc)
+	}
+	return plan
+}
+
+// PlanJSON renders BuildPlan as indented JSON
+func PlanJSON(from, to *schema.Database, changes []Change) ([]byte, error) {
+	return json.MarshalIndent(BuildPlan(from, to, changes), "", "  ")
+}
+
+// resourceChange maps a single change to the object it affects
+func resourceChange(from, to *schema.Database, c Change) ResourceChange {
+	kind := objectKind(c.Type)
+	rc := ResourceChange{Address: kind + "." + c.Object, Type: kind, Name: c.Object}
+	detail := ResourceDetail{
+		Destructive: c.Destructive,
+		Severity:    changeSeverity(c),
+		Reasons:     []Reason{c.Reason},
+		ChangeTypes: []ChangeType{c.Type},
+		ChangeIDs:   []string{c.ID()},
+		SQL:         slices.Clone(c.SQL),
+	}
+
+	switch c.Type {
+	case CreateTable, CreateIndex, CreateView, CreateTrigger:
+		detail.Actions = []string{ActionCreate}
+		detail.After = objectValues(to, kind, c.Object)
+	case DropTable, DropIndex, DropView, DropTrigger:
+		detail.Actions = []string{ActionDelete}
+		detail.Before = objectValues(from, kind, c.Object)
+	case RecreateTable:
+		detail.Actions = []string{ActionUpdate}
+		detail.Before = objectValues(from, kind, c.Object)
+		detail.After = objectValues(to, kind, c.Object)
+	case RenameTable:
+		detail.Actions = []string{ActionUpdate}
+		if old, _ := renamedTable(from, to); old != "" {
+			rc.PreviousAddress = kind + "." + old
+			detail.Before = objectValues(from, kind, old)
+		}
+		detail.After = objectValues(to, kind, c.Object)
+	case DropColumn:
+		rc.Address += ".column." + c.Column
+		rc.Type, rc.Name = "column", c.Column
+		detail.Actions = []string{ActionDelete}
+		detail.Before = columnValues(from.Tables[c.Object], c.Column)
+	case AlterColumnDefault:
+		rc.Address += ".column." + c.Column
+		rc.Type, rc.Name = "column", c.Column
+		detail.Actions = []string{ActionUpdate}
+		detail.Before = columnValues(from.Tables[c.Object], c.Column)
+		detail.After = columnValues(to.Tables[c.Object], c.Column)
+	case AddColumn:
+		rc.Address += ".column." + c.Column
+		rc.Type, rc.Name = "column", c.Column
+		detail.Actions = []string{ActionCreate}
+		detail.After = columnValues(to.Tables[c.Object], c.Column)
+	case RenameColumn:
+		rc.Address += ".column." + c.Column
+		rc.Type, rc.Name = "column", c.Column
+		detail.Actions = []string{ActionUpdate}
+		if old := renamedColumn(from.Tables[c.Object], to.Tables[c.Object]); old != "" {
+			rc.PreviousAddress = kind + "." + c.Object + ".column." + old
+			detail.Before = columnValues(from.Tables[c.Object], old)
+		}
+		detail.After = columnValues(to.Tables[c.Object], c.Column)
+	case DataMigration:
+		rc.Address = "data_migration." + c.Object
+		if c.Column != "" {
+			rc.Address += "." + c.Column
+		}
+		rc.Type = "data_migration"
+		detail.Actions = []string{ActionUpdate}
+	}
+	rc.Change = detail
+	return rc
+}
+
+// objectValues returns the plan values of a named object, or nil if the
+// schema has no such object
+func objectValues(s *schema.Database, kind, name string) any {
+	switch kind {
+	case "table":
+		if t, ok := s.Tables[name]; ok {
+			v := TableValues{Name: t.Name, SQL: t.SQL, Columns: make([]ColumnValues, len(t.Columns))}
+			for i, col := range t.Columns {
+				v.Columns[i] = newColumnValues(col)
+			}
+			return v
+		}
+	case "index":
+		if idx, ok := s.Indexes[name]; ok {
+			return ObjectValues{Name: idx.Name, Table: idx.Table, SQL: idx.SQL}
+		}
+	case "view":
+		if v, ok := s.Views[name]; ok {
+			return ObjectValues{Name: v.Name, SQL: v.SQL}
+		}
+	case "trigger":
+		if tr, ok := s.Triggers[name]; ok {
+			return ObjectValues{Name: tr.Name, Table: tr.Table, SQL: tr.SQL}
+		}
+	}
+	return nil
+}
+
+// columnValues returns the plan values of a column, or nil if the table or
+// column does not exist
+func columnValues(t *schema.Table, name string) any {
+	if t == nil {
+		return nil
+	}
+	if col := t.GetColumn(name); col != nil {
+		return newColumnValues(*col)
+	}
+	return nil
+}
+
+func newColumnValues(col schema.Column) ColumnValues {
+	return ColumnValues{
+		Name:       col.Name,
+		Type:       col.Type,
+		NotNull:    col.NotNull,
+		Default:    col.Default,
+		PrimaryKey: col.PrimaryKey,
+		Generated:  col.Hidden == 2 || col.Hidden == 3,
+	}
+}
+
+// renamedColumn returns the column of from that is missing in to, the old
+// name of a renamed column
+func renamedColumn(from, to *schema.Table) string {
+	if from == nil || to == nil {
+		return ""
+	}
+	for _, col := range from.Columns {
+		if !to.HasColumn(col.Name) {
+			return col.Name
+		}
+	}
+	return ""
+}
+
+// firstNonNil returns a unless it is nil, then b
+func firstNonNil(a, b any) any {
+	if a != nil {
+		return a
+	}
+	return b
+}
+
+func appendUnique[T comparable](s []T, values ...T) []T {
+	for _, v := range values {
+		if !slices.Contains(s, v) {
+			s = append(s, v)
+		}
+	}
+	return s
+}
@@ -0,0 +1,136 @@
+package diff
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+func TestBuildPlan(t *testing.T) {
+	from, err := parser.FromSQL(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, mail TEXT);
+		CREATE TABLE legacy (id INTEGER PRIMARY KEY);
+		CREATE VIEW active AS SELECT id FROM users;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := parser.FromSQL(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, title TEXT NOT NULL);
+		CREATE INDEX idx_posts_title ON posts(title);
+		CREATE VIEW active AS SELECT id, email FROM users;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan := BuildPlan(from, to, Diff(from, to))
+	if plan.FormatVersion != PlanFormatVersion || !plan.Destructive {
+		t.Errorf("plan header = %q destructive %v", plan.FormatVersion, plan.Destructive)
+	}
+
+	byAddress := make(map[string]ResourceChange)
+	for _, rc := range plan.ResourceChanges {
+		byAddress[rc.Address] = rc
+	}
+
+	tests := []struct {
+		address       string
+		actions       []string
+		before, after bool
+	}{
+		{"table.legacy", []string{ActionDelete}, true, false},
+		{"table.posts", []string{ActionCreate}, false, true},
+		{"index.idx_posts_title", []string{ActionCreate}, false, true},
+		{"table.users.column.email", []string{ActionUpdate}, true, true},
+		{"view.active", []string{ActionDelete, ActionCreate}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			rc, ok := byAddress[tt.address]
+			if !ok {
+				t.Fatalf("no resource change for %s, have %v", tt.address, slices.Collect(maps.Keys(byAddress)))
+			}
+			if !slices.Equal(rc.Change.Actions, tt.actions) {
+				t.Errorf("actions = %v, want %v", rc.Change.Actions, tt.actions)
+			}
+			if (rc.Change.Before != nil) != tt.before || (rc.Change.After != nil) != tt.after {
+				t.Errorf("before = %v, after = %v", rc.Change.Before, rc.Change.After)
+			}
+			if len(rc.Change.SQL) == 0 {
+				t.Error("resource change has no SQL")
+			}
+		})
+	}
+
+	rename := byAddress["table.users.column.email"]
+	if rename.PreviousAddress != "table.users.column.mail" || rename.Type != "column" {
+		t.Errorf("rename = %+v, want previous address table.users.column.mail", rename)
+	}
+	if after, ok := rename.Change.After.(ColumnValues); !ok || after.Type != "TEXT" {
+		t.Errorf("rename after = %#v", rename.Change.After)
+	}
+	if view := byAddress["view.active"]; len(view.Change.SQL) != 2 {
+		t.Errorf("view SQL = %v, want the drop and the create", view.Change.SQL)
+	}
+}
+
+func TestBuildPlan_ColumnLevelTypes(t *testing.T) {
+	from, err := parser.FromSQL(`
+		CREATE TABLE people (id INTEGER PRIMARY KEY);
+		CREATE TABLE users (id INTEGER PRIMARY KEY, role TEXT DEFAULT 'user', legacy TEXT);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := parser.FromSQL(`
+		CREATE TABLE persons (id INTEGER PRIMARY KEY);
+		CREATE TABLE users (id INTEGER PRIMARY KEY, role TEXT DEFAULT 'user');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		address  string
+		previous string
+		actions  []string
+	}{
+		{"table.persons", "table.people", []string{ActionUpdate}},
+		{"table.users.column.legacy", "", []string{ActionDelete}},
+	}
+	plan := BuildPlan(from, to, Diff(from, to))
+	if len(plan.ResourceChanges) != len(tests) {
+		t.Fatalf("resource changes = %+v", plan.ResourceChanges)
+	}
+	for i, tt := range tests {
+		rc := plan.ResourceChanges[i]
+		if rc.Address != tt.address || rc.PreviousAddress != tt.previous || !slices.Equal(rc.Change.Actions, tt.actions) {
+			t.Errorf("resource change %d = %s from %q %v, want %s from %q %v",
+				i, rc.Address, rc.PreviousAddress, rc.Change.Actions, tt.address, tt.previous, tt.actions)
+		}
+	}
+}
+
+func TestPlanJSON_Empty(t *testing.T) {
+	s, err := parser.FromSQL(`CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := PlanJSON(s, s, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded map[string]any
+	if err := json.Unmarshal(out, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if changes, ok := decoded["resource_changes"].([]any); !ok || len(changes) != 0 {
+		t.Errorf("resource_changes = %v, want an empty list", decoded["resource_changes"])
+	}
+}
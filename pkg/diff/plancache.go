@@ -0,0 +1,179 @@
+package diff
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+// CompareCached is CompareWithOptions with the plan cached in cacheDir,
+// for many processes migrating the same database on startup. Plans are
+// keyed by the database schema as stored in sqlite_master, the content of
+// the schema directory, the diff options and the SQLite version, so a
+// cached plan is only reused for exactly the situation it was made for.
+// Only the first process parses the schema files and diffs; the others
+// read the plan, which is usually empty once the first one applied it.
+func CompareCached(db *sql.DB, schemaDir string, opts DiffOptions, cacheDir string) ([]Change, error) {
+	return compareCached(context.Background(), db, schemaDir, opts, cacheDir)
+}
+
+// compareCached is CompareCached with a context
+func compareCached(ctx context.Context, db *sql.DB, schemaDir string, opts DiffOptions, cacheDir string) ([]Change, error) {
+	key, err := planCacheKey(db, schemaDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	if changes, ok := readCachedPlan(cacheDir, key); ok {
+		return changes, nil
+	}
+
+	changes, err := CompareContext(ctx, db, schemaDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeCachedPlan(cacheDir, key, changes); err != nil {
+		warn("plan cache", "%v", err)
+	}
+	return changes, nil
+}
+
+// planCacheKey fingerprints everything a plan depends on
+func planCacheKey(db *sql.DB, schemaDir string, opts DiffOptions) (string, error) {
+	h := sha256.New()
+
+	var version string
+	if err := db.QueryRow("SELECT sqlite_version()").Scan(&version); err != nil {
+		return "", fmt.Errorf("read SQLite version: %w", err)
+	}
+	fmt.Fprintf(h, "sqlite\x00%s\x00", version)
+
+	rows, err := db.Query("SELECT type, name, coalesce(sql, '') FROM sqlite_master ORDER BY type, name")
+	if err != nil {
+		return "", fmt.Errorf("read schema: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var kind, name, sql string
+		if err := rows.Scan(&kind, &name, &sql); err != nil {
+			return "", fmt.Errorf("read schema: %w", err)
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00", kind, name, sql)
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("read schema: %w", err)
+	}
+
+	if err := hashSchemaDir(h, schemaDir, opts.Parse); err != nil {
+		return "", err
+	}
+
+	hashPlanOptions(h, opts)
+
+	// Partitions are created and dropped by date
+	templates, err := readSQLFiles(filepath.Join(schemaDir, parser.PartitionsDir))
+	if err != nil {
+		return "", err
+	}
+	if len(templates) > 0 || len(opts.Partitions) > 0 {
+		fmt.Fprintf(h, "partitions\x00%+v\x00%s\x00", opts.Partitions, time.Now().UTC().Format(time.DateOnly))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashPlanOptions hashes the diff options that change the plan: all of
+// them except Incremental, which only changes how the schema is read, and
+// the callbacks. Partitions are hashed by planCacheKey along with the date.
+// TestPlanCacheKey_Options fails for new fields missing here.
+func hashPlanOptions(h hash.Hash, opts DiffOptions) {
+	fmt.Fprintf(h, "options\x00%s\x00%s\x00%v\x00%+v\x00%v\x00",
+		opts.ColumnOrder, opts.TargetVersion, opts.Modules, opts.Parse, opts.PreserveRowids)
+	for _, m := range []map[string]string{opts.Backfill, opts.ColumnExpressions} {
+		for _, k := range slices.Sorted(maps.Keys(m)) {
+			fmt.Fprintf(h, "%s\x00%s\x00", k, m[k])
+		}
+		h.Write([]byte{1})
+	}
+}
+
+// hashSchemaDir hashes the path and content of every schema file read with
+// opts, and of the checks, overrides and partition templates. If
+// parser.SetBaseFS was called, reads from that filesystem instead.
+func hashSchemaDir(h hash.Hash, schemaDir string, opts parser.Options) error {
+	fsys, files, err := parser.SchemaFiles(schemaDir, opts)
+	if err != nil {
+		return err
+	}
+	for _, p := range files {
+		content, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", p, err)
+		}
+		fmt.Fprintf(h, "file\x00%s\x00%d\x00", p, len(content))
+		h.Write(content)
+	}
+
+	for _, sub := range []string{parser.ChecksDir, parser.OverridesDir, parser.PartitionsDir} {
+		sqlFiles, err := readSQLFiles(filepath.Join(schemaDir, sub))
+		if err != nil {
+			return err
+		}
+		for _, f := range sqlFiles {
+			fmt.Fprintf(h, "%s\x00%s\x00%d\x00%s", sub, f.name, len(f.content), f.content)
+		}
+	}
+	return nil
+}
+
+// readCachedPlan returns a cached plan. A missing or unreadable entry is a
+// cache miss.
+func readCachedPlan(cacheDir, key string) ([]Change, bool) {
+	data, err := os.ReadFile(filepath.Join(cacheDir, key+".json"))
+	if err != nil {
+		return nil, false
+	}
+	pf, err := ReadPlanFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	return pf.Changes, true
+}
+
+// writeCachedPlan stores a plan, replacing the entry atomically so that
+// concurrent readers never see a partial file
+func writeCachedPlan(cacheDir, key string, changes []Change) error {
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return fmt.Errorf("create plan cache: %w", err)
+	}
+	tmp, err := os.CreateTemp(cacheDir, key+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("write plan cache: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	pf := &PlanFile{Version: PlanFileVersion, Changes: changes}
+	if pf.Changes == nil {
+		pf.Changes = []Change{}
+	}
+	err = WritePlanFile(tmp, pf)
+	err = errors.Join(err, tmp.Close())
+	if err != nil {
+		return fmt.Errorf("write plan cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(cacheDir, key+".json")); err != nil {
+		return fmt.Errorf("write plan cache: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package diff
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCompareCached(t *testing.T) {
+	db := openTestDB(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "users.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);`)
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+
+	changes, err := CompareCached(db, schemaDir, DiffOptions{}, cacheDir)
+	if err != nil {
+		t.Fatalf("CompareCached() error = %v", err)
+	}
+	if len(changes) != 1 || changes[0].Type != AddColumn {
+		t.Fatalf("CompareCached() = %+v, want one ADD_COLUMN", changes)
+	}
+	entries, _ := filepath.Glob(filepath.Join(cacheDir, "*.json"))
+	if len(entries) != 1 {
+		t.Fatalf("cache has %d entries, want 1", len(entries))
+	}
+
+	// A hit returns the cached plan without planning
+	marker := []Change{{Type: AddColumn, Object: "users", Column: "email", Description: "cached", SQL: []string{"SELECT 1;"}}}
+	key := filepath.Base(entries[0][:len(entries[0])-len(".json")])
+	if err := writeCachedPlan(cacheDir, key, marker); err != nil {
+		t.Fatal(err)
+	}
+	if changes, err = CompareCached(db, schemaDir, DiffOptions{}, cacheDir); err != nil || len(changes) != 1 || changes[0].Description != "cached" {
+		t.Errorf("CompareCached() = %+v (err %v), want the cached plan", changes, err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(t *testing.T) DiffOptions
+	}{
+		{"options", func(t *testing.T) DiffOptions { return DiffOptions{ColumnOrder: ColumnOrderIgnore} }},
+		{"schema files", func(t *testing.T) DiffOptions {
+			if err := os.WriteFile(filepath.Join(schemaDir, "posts.sql"), []byte(`CREATE TABLE posts (id INTEGER);`), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			return DiffOptions{}
+		}},
+		{"database", func(t *testing.T) DiffOptions {
+			if _, err := db.Exec(`CREATE TABLE other (id INTEGER)`); err != nil {
+				t.Fatal(err)
+			}
+			return DiffOptions{}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := CompareCached(db, schemaDir, tt.modify(t), cacheDir)
+			if err != nil {
+				t.Fatalf("CompareCached() error = %v", err)
+			}
+			for _, c := range changes {
+				if c.Description == "cached" {
+					t.Errorf("CompareCached() reused the cached plan after the %s changed", tt.name)
+				}
+			}
+		})
+	}
+}
+
+func TestPlanCacheKey_Options(t *testing.T) {
+	db := openTestDB(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "users.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+
+	base, err := planCacheKey(db, schemaDir, DiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Options that leave the plan as is
+	unhashed := map[string]bool{"Incremental": true, "OnEvent": true, "OnWarning": true}
+
+	typ := reflect.TypeFor[DiffOptions]()
+	for i := range typ.NumField() {
+		field := typ.Field(i)
+		if unhashed[field.Name] {
+			continue
+		}
+		t.Run(field.Name, func(t *testing.T) {
+			var opts DiffOptions
+			setNonZero(t, reflect.ValueOf(&opts).Elem().Field(i))
+			key, err := planCacheKey(db, schemaDir, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key == base {
+				t.Errorf("DiffOptions.%s is not part of the plan cache key", field.Name)
+			}
+		})
+	}
+}
+
+// setNonZero sets v to a value other than its zero value
+func setNonZero(t *testing.T, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		setNonZero(t, v.Index(0))
+	case reflect.Map:
+		key, elem := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+		setNonZero(t, key)
+		setNonZero(t, elem)
+		v.Set(reflect.MakeMapWithSize(v.Type(), 1))
+		v.SetMapIndex(key, elem)
+	case reflect.Struct:
+		setNonZero(t, v.Field(0))
+	default:
+		t.Fatalf("cannot set a %s, add it here or to the unhashed options", v.Type())
+	}
+}
+
+func TestApply_PlanCache(t *testing.T) {
+	db := openTestDB(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "users.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);`)
+	cacheDir := t.TempDir()
+
+	if err := Apply(db, schemaDir, ApplyOptions{PlanCacheDir: cacheDir}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	// The migrated schema was planned too, so the next process only reads the cache
+	entries, _ := filepath.Glob(filepath.Join(cacheDir, "*.json"))
+	if len(entries) != 2 {
+		t.Fatalf("cache has %d entries, want the plan before and after applying", len(entries))
+	}
+	changes, err := CompareCached(db, schemaDir, DiffOptions{}, cacheDir)
+	if err != nil || len(changes) != 0 {
+		t.Errorf("CompareCached() after Apply = %+v (err %v), want no changes", changes, err)
+	}
+	if after, _ := filepath.Glob(filepath.Join(cacheDir, "*.json")); len(after) != 2 {
+		t.Errorf("CompareCached() after Apply missed the cache")
+	}
+}
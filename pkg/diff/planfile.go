@@ -0,0 +1,146 @@
+package diff
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// PlanFileVersion is the version of the plan file format
+const PlanFileVersion = 1
+
+// PlanFile is a saved migration plan. It may be edited before it is applied:
+// changes can be deleted, reordered or given different SQL. Validate checks
+// the edited plan before it runs.
+type PlanFile struct {
+	Version    int      `json:"version"`
+	SchemaHash string   `json:"schema_hash"` // SchemaHash of the database the plan was made for
+	Changes    []Change `json:"changes"`
+}
+
+// NewPlanFile saves a plan made for the current schema
+func NewPlanFile(current *schema.Database, changes []Change) *PlanFile {
+	return &PlanFile{Version: PlanFileVersion, SchemaHash: SchemaHash(current), Changes: changes}
+}
+
+// SchemaHash returns a SHA-256 hash of the SQL of every object in a schema
+func SchemaHash(s *schema.Database) string {
+	h := sha256.New()
+	write := func(kind, name, sql string) {
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00", kind, name, sql)
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Tables)) {
+		write("table", name, s.Tables[name].SQL)
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Indexes)) {
+		write("index", name, s.Indexes[name].SQL)
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Views)) {
+		write("view", name, s.Views[name].SQL)
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Triggers)) {
+		write("trigger", name, s.Triggers[name].SQL)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// WritePlanFile writes a plan file as indented JSON
+func WritePlanFile(w io.Writer, pf *PlanFile) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(pf); err != nil {
+		return fmt.Errorf("write plan file: %w", err)
+	}
+	return nil
+}
+
+// ReadPlanFile reads a plan file. Unknown fields are rejected, so that a
+// mistyped edit is not silently ignored.
+func ReadPlanFile(r io.Reader) (*PlanFile, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var pf PlanFile
+	if err := dec.Decode(&pf); err != nil {
+		return nil, fmt.Errorf("read plan file: %w", err)
+	}
+	if pf.Version != PlanFileVersion {
+		return nil, fmt.Errorf("unsupported plan file version %d, want %d", pf.Version, PlanFileVersion)
+	}
+	return &pf, nil
+}
+
+// Validate checks an edited plan against the database it is about to run
+// on. The database schema must be the one the plan was made for, every
+// change must be well-formed, the order must keep the dependencies intact
+// (see ValidateOrder) and the whole plan must run on an in-memory copy of
+// the schema, so that SQL errors and missing objects are found before the
+// database is touched. Drops and recreates are always treated as
+// destructive, whatever the file says.
+func (pf *PlanFile) Validate(db *sql.DB) error {
+	return pf.ValidateContext(context.Background(), db)
+}
+
+// ValidateContext is Validate with a context, which cancels reading the
+// database and running the plan on the copy
+func (pf *PlanFile) ValidateContext(ctx context.Context, db *sql.DB) error {
+	current, err := parser.FromDBContext(ctx, db)
+	if err != nil {
+		return err
+	}
+	if hash := SchemaHash(current); hash != pf.SchemaHash {
+		return fmt.Errorf("database schema changed since the plan was made (schema hash %.12s, plan made for %.12s)", hash, pf.SchemaHash)
+	}
+
+	for i := range pf.Changes {
+		c := &pf.Changes[i]
+		if _, known := changePriority[c.Type]; !known && c.Type != DataMigration {
+			return fmt.Errorf("change %d: unknown type %q", i+1, c.Type)
+		}
+		if c.Object == "" {
+			return fmt.Errorf("change %d (%s): object is required", i+1, c.Type)
+		}
+		if len(c.SQL) == 0 {
+			return fmt.Errorf("change %d (%s %q): no SQL", i+1, c.Type, c.Object)
+		}
+		if info, _ := LookupChangeType(c.Type); info.Destructive {
+			c.Destructive = true
+		}
+		// Editing a plan may raise a severity, never lower it
+		if c.Severity != "" {
+			if _, err := ParseSeverity(string(c.Severity)); err != nil {
+				return fmt.Errorf("change %d (%s %q): %w", i+1, c.Type, c.Object, err)
+			}
+		}
+		if planned := severityOf(*c); c.Severity == "" || planned.Above(c.Severity) {
+			c.Severity = planned
+		}
+		c.Destructive = c.Severity == SeverityDanger
+	}
+
+	if err := ValidateOrder(pf.Changes); err != nil {
+		return err
+	}
+
+	scratch, err := buildDatabase(current)
+	if err != nil {
+		return fmt.Errorf("build current schema: %w", err)
+	}
+	defer func() { _ = scratch.Close() }()
+
+	if _, err := scratch.Exec("PRAGMA foreign_keys = OFF"); err != nil {
+		return fmt.Errorf("disable foreign keys: %w", err)
+	}
+	if err := executeChanges(ctx, scratch, pf.Changes, nil); err != nil {
+		return fmt.Errorf("plan does not run: %w", err)
+	}
+	return nil
+}
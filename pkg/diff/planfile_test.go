@@ -0,0 +1,188 @@
+package diff
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+func TestPlanFile_RoundTrip(t *testing.T) {
+	db := openTestDB(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "users.sql", `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);
+		CREATE INDEX idx_users_email ON users(email);
+	`)
+
+	current, err := parser.FromDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := Compare(db, schemaDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WritePlanFile(&buf, NewPlanFile(current, changes)); err != nil {
+		t.Fatal(err)
+	}
+	pf, err := ReadPlanFile(&buf)
+	if err != nil {
+		t.Fatalf("ReadPlanFile() error = %v", err)
+	}
+	if len(pf.Changes) != 2 || pf.Changes[1].Table != "users" {
+		t.Errorf("changes = %+v, want both changes with the index table", pf.Changes)
+	}
+	if err := pf.Validate(db); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	if err := ApplyPlan(db, schemaDir, pf.Changes, ApplyOptions{}); err != nil {
+		t.Fatalf("ApplyPlan() error = %v", err)
+	}
+	if remaining, _ := Compare(db, schemaDir); len(remaining) != 0 {
+		t.Errorf("changes remaining after ApplyPlan: %v", remaining)
+	}
+
+	// The plan no longer matches the database
+	if err := pf.Validate(db); err == nil || !strings.Contains(err.Error(), "schema changed") {
+		t.Errorf("Validate() on a changed database = %v, want schema changed error", err)
+	}
+}
+
+func TestReadPlanFile_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"unknown field", `{"version": 1, "schema_hash": "", "chnages": []}`, "unknown field"},
+		{"version", `{"version": 2, "schema_hash": "", "changes": []}`, "unsupported plan file version"},
+		{"malformed", `{"version": 1,`, "read plan file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadPlanFile(strings.NewReader(tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ReadPlanFile() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPlanFile_ValidateEdits(t *testing.T) {
+	db := openTestDB(t, `CREATE TABLE users (id INTEGER PRIMARY KEY); CREATE TABLE legacy (id INTEGER);`)
+	defer func() { _ = db.Close() }()
+	current, err := parser.FromDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		changes []Change
+		wantErr string
+	}{
+		{
+			name: "modified SQL",
+			changes: []Change{
+				{Type: AddColumn, Object: "users", Column: "email", SQL: []string{`ALTER TABLE users ADD COLUMN email TEXT DEFAULT '';`}},
+			},
+		},
+		{
+			name:    "SQL does not parse",
+			changes: []Change{{Type: AddColumn, Object: "users", Column: "email", SQL: []string{`ALTER TABLE users ADD COLUMN;`}}},
+			wantErr: "plan does not run",
+		},
+		{
+			name:    "missing object",
+			changes: []Change{{Type: CreateIndex, Object: "idx", Table: "posts", SQL: []string{`CREATE INDEX idx ON posts(id);`}}},
+			wantErr: "no such table",
+		},
+		{
+			name: "broken dependency",
+			changes: []Change{
+				{Type: CreateIndex, Object: "idx", Table: "posts", SQL: []string{`CREATE INDEX idx ON posts(id);`}},
+				{Type: CreateTable, Object: "posts", SQL: []string{`CREATE TABLE posts (id INTEGER);`}},
+			},
+			wantErr: "must run before",
+		},
+		{
+			name:    "unknown type",
+			changes: []Change{{Type: "TRUNCATE", Object: "users", SQL: []string{`DELETE FROM users;`}}},
+			wantErr: "unknown type",
+		},
+		{
+			name:    "no SQL",
+			changes: []Change{{Type: DropTable, Object: "legacy"}},
+			wantErr: "no SQL",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewPlanFile(current, tt.changes).Validate(db)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Validation never touches the database itself
+	if after, err := parser.FromDB(db); err != nil || SchemaHash(after) != SchemaHash(current) {
+		t.Errorf("Validate() modified the database (err %v)", err)
+	}
+}
+
+func TestPlanFile_DestructiveCannotBeEditedAway(t *testing.T) {
+	db := openTestDB(t, `CREATE TABLE legacy (id INTEGER);`)
+	defer func() { _ = db.Close() }()
+	current, err := parser.FromDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pf := NewPlanFile(current, []Change{{Type: DropTable, Object: "legacy", SQL: []string{`DROP TABLE legacy;`}}})
+	if err := pf.Validate(db); err != nil {
+		t.Fatal(err)
+	}
+	if !pf.Changes[0].Destructive {
+		t.Error("DROP_TABLE not marked destructive after Validate")
+	}
+}
+
+func TestPlanFile_SeverityCannotBeLowered(t *testing.T) {
+	db := openTestDB(t, `CREATE TABLE legacy (id INTEGER); CREATE VIEW v AS SELECT 1;`)
+	defer func() { _ = db.Close() }()
+	current, err := parser.FromDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pf := NewPlanFile(current, []Change{
+		{Type: DropView, Object: "v", Severity: SeverityDanger, SQL: []string{`DROP VIEW v;`}},
+		{Type: DropTable, Object: "legacy", Severity: SeverityInfo, SQL: []string{`DROP TABLE legacy;`}},
+	})
+	if err := pf.Validate(db); err != nil {
+		t.Fatal(err)
+	}
+	if c := pf.Changes[1]; c.Severity != SeverityDanger || !c.Destructive {
+		t.Errorf("DROP_TABLE after Validate: severity %q, destructive %v", c.Severity, c.Destructive)
+	}
+	if c := pf.Changes[0]; c.Severity != SeverityDanger || !c.Destructive {
+		t.Errorf("raised DROP_VIEW after Validate: severity %q, destructive %v", c.Severity, c.Destructive)
+	}
+
+	pf.Changes[0].Severity = "fatal"
+	if err := pf.Validate(db); err == nil {
+		t.Error("Validate() accepted an unknown severity")
+	}
+}
@@ -0,0 +1,263 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// ErrPolicyDenied is returned when a deny policy matches the plan
+var ErrPolicyDenied = errors.New("plan denied by policy")
+
+// PolicyAction is what happens when a policy matches
+type PolicyAction string
+
+const (
+	PolicyDeny    PolicyAction = "deny"    // Refuse the plan
+	PolicyWarn    PolicyAction = "warn"    // Report a warning and go on
+	PolicyApprove PolicyAction = "approve" // Refuse the plan unless Approver approved it
+)
+
+// Policy is a guardrail written as an expression over the plan and the
+// target schema, evaluated at plan time. It matches when the expression is
+// true, e.g. for
+//
+//	changes.exists(c, c.type == "DROP_TABLE" && c.object.startsWith("billing_")) -> deny
+//
+// The expression sees the variables changes, a list with the fields type,
+// object, column, table, description, sql, destructive, severity, class,
+// reason and sensitive, and schema, with the lists tables (name, sql,
+// columns with name, type, not_null, default, primary_key, sensitive),
+// indexes (name, table, sql), views (name, sql) and triggers (name, table,
+// sql). See expr.go for the language, a subset of CEL.
+type Policy struct {
+	Expr     string
+	Action   PolicyAction
+	Approver string // Team that has to approve plans an approve policy matches
+	Message  string // Shown when the policy matches, defaults to Expr
+	Line     int    // Line the policy starts on in its file, 0 if none
+
+	eval exprFunc
+}
+
+// ParsePolicy compiles a policy expression. The action of an approve
+// policy names the approver, as in "approve security".
+func ParsePolicy(expr string, action PolicyAction, message string) (Policy, error) {
+	name, approver, _ := strings.Cut(string(action), " ")
+	p := Policy{Expr: expr, Action: PolicyAction(name), Approver: strings.TrimSpace(approver), Message: message}
+	switch {
+	case p.Action != PolicyDeny && p.Action != PolicyWarn && p.Action != PolicyApprove,
+		p.Action != PolicyApprove && p.Approver != "":
+		return Policy{}, fmt.Errorf("invalid policy action %q: must be deny, warn or approve <team>", action)
+	case p.Action == PolicyApprove && p.Approver == "":
+		return Policy{}, fmt.Errorf("approve policy %q names no approver, e.g. -> approve security", expr)
+	}
+	eval, err := compileExpr(expr)
+	if err != nil {
+		return Policy{}, fmt.Errorf("invalid policy %q: %w", expr, err)
+	}
+	p.eval = eval
+	return p, nil
+}
+
+// ParsePolicies parses a policy file. Every policy is an expression
+// followed by "-> deny", "-> warn" or "-> approve <team>" and may span
+// lines; the comment lines (starting with #) directly above it are its
+// message:
+//
+//	# Billing tables are owned by the payments team
+//	changes.exists(c, c.type == "DROP_TABLE" && c.object.startsWith("billing_")) -> deny
+//
+//	changes.exists(c, c.destructive) && size(changes) > 10 -> warn
+//
+//	# Personal data needs a privacy review
+//	changes.exists(c, c.sensitive) -> approve privacy
+func ParsePolicies(src string) ([]Policy, error) {
+	var policies []Policy
+	var expr, comments []string
+	start := 0
+	for i, line := range strings.Split(src, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if len(expr) == 0 {
+			switch {
+			case trimmed == "":
+				comments = nil
+				continue
+			case strings.HasPrefix(trimmed, "#"):
+				comments = append(comments, strings.TrimSpace(strings.TrimPrefix(trimmed, "#")))
+				continue
+			}
+			start = i + 1
+		}
+		expr = append(expr, trimmed)
+
+		body, action, ok := cutPolicyAction(trimmed)
+		if !ok {
+			continue
+		}
+		expr[len(expr)-1] = body
+		p, err := ParsePolicy(strings.Join(expr, " "), action, strings.Join(comments, " "))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", start, err)
+		}
+		p.Line = start
+		policies = append(policies, p)
+		expr, comments = nil, nil
+	}
+	if len(expr) > 0 {
+		return nil, fmt.Errorf("line %d: policy does not end in -> deny, -> warn or -> approve <team>", start)
+	}
+	return policies, nil
+}
+
+// cutPolicyAction splits "<expr> -> deny" into the expression and action,
+// which for approve policies includes the approver
+func cutPolicyAction(line string) (string, PolicyAction, bool) {
+	i := strings.LastIndex(line, "->")
+	if i < 0 {
+		return "", "", false
+	}
+	action := PolicyAction(strings.TrimSpace(line[i+2:]))
+	if action != PolicyDeny && action != PolicyWarn && !strings.HasPrefix(string(action), string(PolicyApprove)+" ") {
+		return "", "", false
+	}
+	return strings.TrimSpace(line[:i]), action, true
+}
+
+// LoadPolicies reads a policy file (see ParsePolicies)
+func LoadPolicies(path string) ([]Policy, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := ParsePolicies(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return policies, nil
+}
+
+// MatchPolicies returns the policies that match a plan for the target
+// schema, which may be nil if no policy uses it. An expression that fails
+// to evaluate or is not a bool is an error.
+func MatchPolicies(policies []Policy, changes []Change, target *schema.Database) ([]Policy, error) {
+	vars := map[string]any{"changes": policyChanges(changes), "schema": policySchema(target)}
+	var matched []Policy
+	for _, p := range policies {
+		if p.eval == nil {
+			return nil, fmt.Errorf("policy %q was not parsed with ParsePolicy", p.Expr)
+		}
+		ok, err := evalBool(p.eval, vars)
+		if err != nil {
+			return nil, fmt.Errorf("evaluate policy %q: %w", p.Expr, err)
+		}
+		if ok {
+			matched = append(matched, p)
+		}
+	}
+	return matched, nil
+}
+
+// CheckPolicies matches the policies against a plan, reports matching warn
+// policies as warnings and fails with ErrPolicyDenied if a deny policy
+// matches, or with ErrApprovalRequired if an approve policy matches whose
+// approver is not in approvedBy
+func CheckPolicies(policies []Policy, changes []Change, target *schema.Database, approvedBy ...string) error {
+	matched, err := MatchPolicies(policies, changes, target)
+	if err != nil {
+		return err
+	}
+	var denied, unapproved []string
+	for _, p := range matched {
+		msg := p.Message
+		if msg == "" {
+			msg = p.Expr
+		}
+		switch p.Action {
+		case PolicyWarn:
+			warn("policy", "%s", msg)
+		case PolicyApprove:
+			if !slices.ContainsFunc(approvedBy, func(a string) bool { return sameTeam(a, p.Approver) }) {
+				unapproved = append(unapproved, fmt.Sprintf("%s (%s)", p.Approver, msg))
+			}
+		default:
+			denied = append(denied, msg)
+		}
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("%w:\n  %s", ErrPolicyDenied, strings.Join(denied, "\n  "))
+	}
+	if len(unapproved) > 0 {
+		return fmt.Errorf("%w, approval needed from:\n  %s", ErrApprovalRequired, strings.Join(unapproved, "\n  "))
+	}
+	return nil
+}
+
+// policyChanges turns a plan into expression values
+func policyChanges(changes []Change) []any {
+	list := make([]any, len(changes))
+	for i, c := range changes {
+		sql := make([]any, len(c.SQL))
+		for j, stmt := range c.SQL {
+			sql[j] = stmt
+		}
+		list[i] = map[string]any{
+			"type":        string(c.Type),
+			"object":      c.Object,
+			"column":      c.Column,
+			"table":       c.Table,
+			"description": c.Description,
+			"sql":         sql,
+			"destructive": c.Destructive,
+			"severity":    string(changeSeverity(c)),
+			"class":       string(c.Class),
+			"reason":      string(c.Reason),
+			"sensitive":   c.Sensitive,
+		}
+	}
+	return list
+}
+
+// policySchema turns a schema into expression values, objects in name order
+func policySchema(s *schema.Database) map[string]any {
+	tables, indexes, views, triggers := []any{}, []any{}, []any{}, []any{}
+	if s != nil {
+		for _, name := range slices.Sorted(maps.Keys(s.Tables)) {
+			t := s.Tables[name]
+			columns := make([]any, len(t.Columns))
+			for i, col := range t.Columns {
+				var def any
+				if col.Default != nil {
+					def = *col.Default
+				}
+				columns[i] = map[string]any{
+					"name":        col.Name,
+					"type":        col.Type,
+					"not_null":    col.NotNull,
+					"default":     def,
+					"primary_key": int64(col.PrimaryKey),
+					"sensitive":   col.Sensitive,
+				}
+			}
+			tables = append(tables, map[string]any{"name": t.Name, "sql": t.SQL, "columns": columns})
+		}
+		for _, name := range slices.Sorted(maps.Keys(s.Indexes)) {
+			idx := s.Indexes[name]
+			indexes = append(indexes, map[string]any{"name": idx.Name, "table": idx.Table, "sql": idx.SQL})
+		}
+		for _, name := range slices.Sorted(maps.Keys(s.Views)) {
+			v := s.Views[name]
+			views = append(views, map[string]any{"name": v.Name, "sql": v.SQL})
+		}
+		for _, name := range slices.Sorted(maps.Keys(s.Triggers)) {
+			tr := s.Triggers[name]
+			triggers = append(triggers, map[string]any{"name": tr.Name, "table": tr.Table, "sql": tr.SQL})
+		}
+	}
+	return map[string]any{"tables": tables, "indexes": indexes, "views": views, "triggers": triggers}
+}
@@ -0,0 +1,158 @@
+package diff
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+func TestCompileExpr(t *testing.T) {
+	vars := map[string]any{
+		"changes": policyChanges([]Change{
+			{Type: DropTable, Object: "billing_invoices", Destructive: true},
+			{Type: AddColumn, Object: "users", Column: "email"},
+		}),
+		"name": "users",
+	}
+	tests := []struct {
+		expr    string
+		want    any
+		wantErr string
+	}{
+		{`changes.exists(c, c.type == "DROP_TABLE" && c.object.startsWith("billing_"))`, true, ""},
+		{`changes.all(c, c.destructive)`, false, ""},
+		{`changes.exists_one(c, !c.destructive)`, true, ""},
+		{`size(changes.filter(c, c.column != "")) == 1`, true, ""},
+		{`changes.map(c, c.object)`, []any{"billing_invoices", "users"}, ""},
+		{`changes[1].column + "@" + name`, "email@users", ""},
+		{`'users' in changes.map(c, c.object)`, true, ""},
+		{`"severity" in changes[0]`, true, ""},
+		{`name.matches("^u[a-z]+$") && name.upper() == "USERS"`, true, ""},
+		{`size(name) * 2 - 10 / 5 % 3`, int64(8), ""},
+		{`-(1 + 2) < 0 || missing`, true, ""}, // || skips the right side
+		{`[1, 2] == [1, 2] && null == null`, true, ""},
+		{`name.contains("se") ? 1 : 2`, nil, "unexpected"},
+		{`missing`, nil, "undeclared variable missing"},
+		{`changes[0].owner`, nil, "no such field owner"},
+		{`name < 1`, nil, "cannot compare"},
+		{`changes.exists(c, c.object)`, nil, "expected a bool"},
+		{`1 / 0`, nil, "division by zero"},
+		{`unknown(1)`, nil, "unknown function"},
+		{`"unterminated`, nil, "unterminated string"},
+		{`(1 + 2`, nil, "expected \")\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			fn, err := compileExpr(tt.expr)
+			var got any
+			if err == nil {
+				got, err = fn(vars)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePolicies(t *testing.T) {
+	policies, err := ParsePolicies(`
+# Billing tables are owned by the payments team
+changes.exists(c, c.type == "DROP_TABLE" && c.object.startsWith("billing_")) -> deny
+
+# A comment separated by a blank line is not a message
+
+changes.exists(c, c.destructive) &&
+  size(changes) > 1 -> warn
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 2 {
+		t.Fatalf("got %d policies, want 2", len(policies))
+	}
+	if p := policies[0]; p.Action != PolicyDeny || p.Line != 3 || p.Message != "Billing tables are owned by the payments team" {
+		t.Errorf("first policy = %+v", p)
+	}
+	if p := policies[1]; p.Action != PolicyWarn || p.Line != 7 || p.Message != "" ||
+		p.Expr != "changes.exists(c, c.destructive) && size(changes) > 1" {
+		t.Errorf("second policy = %+v", p)
+	}
+
+	for _, src := range []string{"size(changes) > 0", "size(changes) > -> deny", "true -> block"} {
+		if _, err := ParsePolicies(src); err == nil {
+			t.Errorf("ParsePolicies(%q) should fail", src)
+		}
+	}
+}
+
+func TestCheckPolicies(t *testing.T) {
+	var warnings []Warning
+	SetWarningHandler(func(w Warning) { warnings = append(warnings, w) })
+	defer SetWarningHandler(nil)
+
+	policies, err := ParsePolicies(`
+# Tables need a primary key
+schema.tables.exists(t, !t.columns.exists(c, c.primary_key > 0)) -> warn
+# Billing tables are owned by the payments team
+changes.exists(c, c.type == "DROP_TABLE" && c.object.startsWith("billing_")) -> deny
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := &schema.Database{Tables: map[string]*schema.Table{
+		"events": {Name: "events", Columns: []schema.Column{{Name: "payload", Type: "TEXT"}}},
+	}}
+
+	if err := CheckPolicies(policies, []Change{{Type: DropTable, Object: "sessions"}}, target); err != nil {
+		t.Errorf("CheckPolicies() error: %v", err)
+	}
+	if len(warnings) != 1 || warnings[0].Message != "Tables need a primary key" {
+		t.Errorf("warnings = %v, want the primary key policy", warnings)
+	}
+
+	err = CheckPolicies(policies, []Change{{Type: DropTable, Object: "billing_invoices"}}, nil)
+	if !errors.Is(err, ErrPolicyDenied) || !strings.Contains(err.Error(), "payments team") {
+		t.Errorf("error = %v, want ErrPolicyDenied with the policy message", err)
+	}
+}
+
+func TestApply_Policies(t *testing.T) {
+	db := openTestDB(t, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY);
+		CREATE TABLE billing_invoices (id INTEGER PRIMARY KEY);
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "schema.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+
+	deny, err := ParsePolicy(`changes.exists(c, c.type == "DROP_TABLE" && c.object.startsWith("billing_"))`, PolicyDeny, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(db, schemaDir, ApplyOptions{Policies: []Policy{deny}}); !errors.Is(err, ErrPolicyDenied) {
+		t.Fatalf("Apply() error = %v, want ErrPolicyDenied", err)
+	}
+	// Skipped changes are not applied, so they are not denied
+	if err := Apply(db, schemaDir, ApplyOptions{Policies: []Policy{deny}, SkipDestructive: true}); err != nil {
+		t.Errorf("Apply() with SkipDestructive error: %v", err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE name = 'billing_invoices'").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Error("billing_invoices was dropped")
+	}
+}
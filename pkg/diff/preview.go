@@ -0,0 +1,94 @@
+package diff
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// maxPreviewValue is the length values are shortened to in a data preview
+const maxPreviewValue = 60
+
+// DataPreview is a sample of the rows whose data a destructive change loses
+type DataPreview struct {
+	Table    string      `json:"table"`
+	Dropped  bool        `json:"dropped"` // The whole table is dropped, not only Columns
+	Columns  []string    `json:"columns"`
+	Rows     [][]*string `json:"rows"`               // Values as SQL literals, nil for NULL
+	Redacted []string    `json:"redacted,omitempty"` // Sensitive columns, whose values are shown as [redacted]
+}
+
+// PreviewLostData reads the first n rows of the tables and columns the
+// destructive changes lose, as a last check of what is about to be deleted.
+// Values are SQL literals shortened to 60 characters; values of columns
+// tagged as sensitive (see schema.Column.Sensitive) are redacted.
+func PreviewLostData(db *sql.DB, changes []Change, n int) ([]DataPreview, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	var previews []DataPreview
+	for _, c := range changes {
+		if !c.Destructive {
+			continue
+		}
+		lost, err := lostColumns(db, c)
+		if err != nil {
+			return nil, err
+		}
+		if len(lost) == 0 {
+			continue
+		}
+		tags, err := sensitiveColumns(db, c.Object)
+		if err != nil {
+			return nil, err
+		}
+		p, err := previewRows(db, c.Object, lost, tags, n)
+		if err != nil {
+			return nil, fmt.Errorf("preview %q: %w", c.Object, err)
+		}
+		p.Dropped = c.Type == DropTable
+		previews = append(previews, p)
+	}
+	return previews, nil
+}
+
+func previewRows(db *sql.DB, table string, cols []string, tags map[string]string, n int) (DataPreview, error) {
+	p := DataPreview{Table: table, Columns: cols, Rows: [][]*string{}}
+	exprs := make([]string, len(cols))
+	for i, col := range cols {
+		value := fmt.Sprintf("quote(%q)", col)
+		if tags[col] != "" {
+			value = "'" + redacted + "'"
+			p.Redacted = append(p.Redacted, col)
+		}
+		exprs[i] = fmt.Sprintf("CASE WHEN %q IS NULL THEN NULL ELSE %s END", col, value)
+	}
+	rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %q LIMIT %d", strings.Join(exprs, ", "), table, n))
+	if err != nil {
+		return p, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		values := make([]sql.NullString, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return p, err
+		}
+		row := make([]*string, len(cols))
+		for i, v := range values {
+			if v.Valid {
+				s := v.String
+				if len(s) > maxPreviewValue {
+					s = s[:maxPreviewValue-3] + "..."
+				}
+				row[i] = &s
+			}
+		}
+		p.Rows = append(p.Rows, row)
+	}
+	return p, rows.Err()
+}
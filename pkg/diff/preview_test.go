@@ -0,0 +1,56 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+func TestPreviewLostData(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, legacy TEXT);
+		INSERT INTO users VALUES (1, 'ann', 'it''s'), (2, 'bob', NULL), (3, 'cy', 'c');
+		CREATE TABLE blobs (data BLOB, note TEXT);
+		INSERT INTO blobs VALUES (x'00ff', '`+strings.Repeat("x", 100)+`');
+	`)
+	defer func() { _ = db.Close() }()
+
+	current, err := parser.FromDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := parser.FromSQL(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previews, err := PreviewLostData(db, Diff(current, target), 2)
+	if err != nil {
+		t.Fatalf("PreviewLostData() error = %v", err)
+	}
+	got := map[string]DataPreview{}
+	for _, p := range previews {
+		got[p.Table] = p
+	}
+
+	blobs := got["blobs"]
+	if !blobs.Dropped || len(blobs.Rows) != 1 || *blobs.Rows[0][0] != "X'00FF'" {
+		t.Errorf("blobs preview = %+v, want dropped table with X'00FF'", blobs)
+	}
+	if note := *blobs.Rows[0][1]; len(note) != maxPreviewValue || !strings.HasSuffix(note, "...") {
+		t.Errorf("long value = %q, want shortened to %d characters", note, maxPreviewValue)
+	}
+
+	users := got["users"]
+	if users.Dropped || strings.Join(users.Columns, ",") != "legacy" {
+		t.Fatalf("users preview = %+v, want only the legacy column", users)
+	}
+	if len(users.Rows) != 2 || *users.Rows[0][0] != "'it''s'" || users.Rows[1][0] != nil {
+		t.Errorf("users rows = %v, want 'it''s' and NULL", users.Rows)
+	}
+
+	if previews, _ := PreviewLostData(db, Diff(current, target), 0); previews != nil {
+		t.Errorf("PreviewLostData(n = 0) = %v, want nil", previews)
+	}
+}
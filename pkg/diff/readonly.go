@@ -0,0 +1,69 @@
+package diff
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+// OpenReadOnly opens an existing database file that cannot be modified
+// through the returned handle: the file is opened with mode=ro and every
+// connection is query_only. With immutable, SQLite also assumes nobody else
+// changes the file and skips locking, which is only safe for files that are
+// not being written, such as backups or snapshots.
+func OpenReadOnly(path string, immutable bool) (*sql.DB, error) {
+	return openReadOnly(path, immutable)
+}
+
+// schemaOnlyMmapSize is how much of the file OpenSchemaOnly memory-maps
+const schemaOnlyMmapSize = 256 << 20
+
+// OpenSchemaOnly opens a database read-only for schema extraction. The file
+// is memory-mapped and the page cache kept small, so reading sqlite_schema
+// and the column pragmas faults in only the schema pages, even for databases
+// of many gigabytes. Size annotation and CHECK scans read table data and
+// should not be run on it.
+func OpenSchemaOnly(path string, immutable bool) (*sql.DB, error) {
+	return openReadOnly(path, immutable,
+		fmt.Sprintf("mmap_size(%d)", schemaOnlyMmapSize),
+		"cache_size(-512)",
+	)
+}
+
+// openReadOnly opens an existing database read-only, setting pragmas on
+// every connection
+func openReadOnly(path string, immutable bool, pragmas ...string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+
+	query := url.Values{}
+	query.Set("mode", "ro")
+	query.Add("_pragma", "query_only(1)")
+	for _, pragma := range pragmas {
+		query.Add("_pragma", pragma)
+	}
+	if immutable {
+		query.Set("immutable", "1")
+	}
+
+	uri := filepath.ToSlash(path)
+	if filepath.VolumeName(path) != "" {
+		uri = "/" + uri // file:///C:/app.db
+	}
+	dsn := fmt.Sprintf("file:%s?%s", (&url.URL{Path: uri}).EscapedPath(), query.Encode())
+
+	db, err := parser.Open(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	return db, nil
+}
@@ -0,0 +1,123 @@
+package diff
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+func TestOpenReadOnly(t *testing.T) {
+	writer, path := createTestDBWithPath(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	_ = writer.Close()
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, immutable := range []bool{false, true} {
+		db, err := OpenReadOnly(path, immutable)
+		if err != nil {
+			t.Fatalf("OpenReadOnly(immutable=%v) error: %v", immutable, err)
+		}
+
+		if _, err := Compare(db, createSchemaDir(t, "users.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY);`)); err != nil {
+			t.Errorf("Compare() on read-only database: %v", err)
+		}
+		if _, err := db.Exec("CREATE TABLE t (id INT)"); err == nil {
+			t.Errorf("write succeeded on read-only database (immutable=%v)", immutable)
+		}
+		_ = db.Close()
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Error("database file changed while opened read-only")
+	}
+
+	if _, err := OpenReadOnly(filepath.Join(t.TempDir(), "missing.db"), false); err == nil {
+		t.Error("expected error for a missing database")
+	}
+}
+
+func TestOpenSchemaOnly(t *testing.T) {
+	writer, path := createTestDBWithPath(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	_ = writer.Close()
+
+	db, err := OpenSchemaOnly(path, false)
+	if err != nil {
+		t.Fatalf("OpenSchemaOnly() error: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	var mmapSize int64
+	if err := db.QueryRow("PRAGMA mmap_size").Scan(&mmapSize); err != nil {
+		t.Fatal(err)
+	}
+	if mmapSize != schemaOnlyMmapSize {
+		t.Errorf("mmap_size = %d, want %d", mmapSize, schemaOnlyMmapSize)
+	}
+	if _, err := Compare(db, createSchemaDir(t, "users.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY);`)); err != nil {
+		t.Errorf("Compare() on schema-only database: %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE t (id INT)"); err == nil {
+		t.Error("write succeeded on schema-only database")
+	}
+}
+
+// BenchmarkExtractSchema_LargeDatabase measures schema extraction on a
+// database whose data dwarfs its schema. Extraction must not depend on the
+// amount of data, e.g. go test -bench LargeDatabase -benchtime 20x.
+func BenchmarkExtractSchema_LargeDatabase(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "large.db")
+	writer, err := sql.Open("sqlite", path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var ddl strings.Builder
+	for i := range 200 {
+		fmt.Fprintf(&ddl, "CREATE TABLE t%03d (id INTEGER PRIMARY KEY, name TEXT NOT NULL, body BLOB);\n", i)
+		fmt.Fprintf(&ddl, "CREATE INDEX idx_t%03d_name ON t%03d(name);\n", i, i)
+	}
+	ddl.WriteString(`
+		CREATE TABLE events (id INTEGER PRIMARY KEY, kind TEXT, payload BLOB);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 250000)
+		INSERT INTO events (kind, payload) SELECT 'k' || (i % 10), randomblob(200) FROM n;
+	`)
+	if _, err := writer.Exec(ddl.String()); err != nil {
+		b.Fatal(err)
+	}
+	_ = writer.Close()
+
+	for _, mode := range []struct {
+		name string
+		open func(string, bool) (*sql.DB, error)
+	}{
+		{"read-only", OpenReadOnly},
+		{"schema-only", OpenSchemaOnly},
+	} {
+		b.Run(mode.name, func(b *testing.B) {
+			for b.Loop() {
+				db, err := mode.open(path, false)
+				if err != nil {
+					b.Fatal(err)
+				}
+				s, err := parser.FromDB(db)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(s.Tables) != 201 {
+					b.Fatalf("extracted %d tables, want 201", len(s.Tables))
+				}
+				_ = db.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,364 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// ApplyStrategy selects how Apply changes the database file
+type ApplyStrategy string
+
+const (
+	// StrategyInPlace runs the planned SQL on the database. This is the default.
+	StrategyInPlace ApplyStrategy = ""
+	// StrategyRebuild builds a new database file from the target schema next
+	// to the database, copies the data table by table and then renames it
+	// over the database. The database is untouched until the rename, so a
+	// crash leaves either the old or the new file, and the new file is as
+	// compact as after a VACUUM. Plans that rename tables or columns or run
+	// data migrations cannot be rebuilt. Needs the database to be otherwise
+	// unused, connections opened elsewhere keep seeing the old file.
+	StrategyRebuild ApplyStrategy = "rebuild"
+	// StrategyAuto rebuilds when the plan recreates most tables and can be
+	// rebuilt, and applies in place otherwise. Only WAL databases are
+	// rebuilt: leaving WAL mode fails while other connections use the
+	// database, whereas with a rollback journal nothing tells that another
+	// process would go on writing to the replaced file.
+	StrategyAuto ApplyStrategy = "auto"
+)
+
+// RebuildSuffix is appended to the database path to name the file a
+// rebuild is built in
+const RebuildSuffix = ".rebuild"
+
+// useRebuild decides whether the changes are applied with StrategyRebuild,
+// wal telling whether the database is in WAL mode
+func (opts ApplyOptions) useRebuild(current *schema.Database, changes []Change, wal bool) (bool, error) {
+	switch opts.Strategy {
+	case StrategyInPlace:
+		return false, nil
+	case StrategyRebuild:
+		if opts.LowPriority || opts.DeferIndexes || opts.QuarantineViolations {
+			return false, fmt.Errorf("strategy %q cannot be combined with low-priority mode, deferred indexes or quarantine", opts.Strategy)
+		}
+		if err := canRebuild(changes); err != nil {
+			return false, fmt.Errorf("strategy %q: %w", opts.Strategy, err)
+		}
+		return true, nil
+	case StrategyAuto:
+		if !wal || opts.LowPriority || opts.DeferIndexes || opts.QuarantineViolations || canRebuild(changes) != nil {
+			return false, nil
+		}
+		recreated := make(map[string]bool)
+		for _, c := range changes {
+			if c.RecreatesTable() {
+				recreated[c.Object] = true
+			}
+		}
+		return len(recreated) > len(current.Tables)/2, nil
+	default:
+		return false, fmt.Errorf("unknown apply strategy %q", opts.Strategy)
+	}
+}
+
+// canRebuild reports why a plan cannot be rebuilt from its target schema.
+// Rows are copied between tables of the same name, so renames and data
+// migrations would lose data, and in-place table changes must be single
+// statements that need no data of their own.
+func canRebuild(changes []Change) error {
+	for _, c := range changes {
+		switch {
+		case c.Type == RenameTable || c.Type == RenameColumn || c.Type == DataMigration:
+			return fmt.Errorf("cannot rebuild with %s %q", c.Type, c.Object)
+		case c.RecreatesTable() || objectKind(c.Type) != "table" || c.Type == CreateTable || c.Type == DropTable:
+		default:
+			var stmts int
+			for _, stmt := range c.SQL {
+				if !isCommentOnly(stmt) {
+					stmts++
+				}
+			}
+			if stmts > 1 {
+				return fmt.Errorf("cannot rebuild with %s %q, it fills existing rows", c.Type, c.Object)
+			}
+		}
+	}
+	return nil
+}
+
+// rebuildDatabase applies changes with StrategyRebuild
+func rebuildDatabase(ctx context.Context, db *sql.DB, current *schema.Database, changes []Change, checks []Check) error {
+	path, err := databasePath(db)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		return fmt.Errorf("rebuild needs a database file, not an in-memory database")
+	}
+
+	target, err := planResult(ctx, current, changes)
+	if err != nil {
+		return err
+	}
+	for name, t := range target.Tables {
+		if isVirtualTableSQL(t.SQL) {
+			return fmt.Errorf("cannot rebuild virtual table %q", name)
+		}
+	}
+	copies := rebuildCopies(current, target, changes)
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire connection: %w", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// Idle connections would keep reading the old file after the swap, and
+	// in WAL mode keep the WAL from being dropped
+	discardIdleConns(ctx, db)
+
+	// A WAL left next to the new file would be replayed into it, so the old
+	// file moves its WAL content into the database and drops the WAL first
+	mode, err := journalMode(ctx, conn, "")
+	if err != nil {
+		return err
+	}
+	if mode == "wal" {
+		if mode, err := journalMode(ctx, conn, "DELETE"); err != nil || mode != "delete" {
+			return fmt.Errorf("switch to journal_mode=DELETE for the rebuild: mode %q: %v, is the database in use?", mode, err)
+		}
+	}
+	restoreMode := func() error {
+		if mode != "wal" {
+			return nil
+		}
+		var got string
+		if err := db.QueryRowContext(ctx, "PRAGMA journal_mode = WAL").Scan(&got); err != nil || !strings.EqualFold(got, "wal") {
+			return fmt.Errorf("restore journal_mode=WAL: mode %q: %v", got, err)
+		}
+		return nil
+	}
+
+	// Hold the write lock, so nothing is written to the old file once copied
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return errors.Join(fmt.Errorf("lock database: %w", err), restoreMode())
+	}
+
+	newPath := path + RebuildSuffix
+	if err := buildRebuild(ctx, path, newPath, target, copies, checks); err != nil {
+		_ = removeRebuild(newPath)
+		_, _ = conn.ExecContext(ctx, "ROLLBACK")
+		return errors.Join(fmt.Errorf("rebuild: %w", err), restoreMode())
+	}
+
+	if err := os.Rename(newPath, path); err != nil {
+		_ = removeRebuild(newPath)
+		_, _ = conn.ExecContext(ctx, "ROLLBACK")
+		return errors.Join(fmt.Errorf("swap rebuilt database: %w", err), restoreMode())
+	}
+	syncDir(filepath.Dir(path))
+
+	// The locked connection and the idle ones still read the old file,
+	// discard them
+	_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+	discardIdleConns(ctx, db)
+	return restoreMode()
+}
+
+// discardIdleConns closes the idle connections of db, so the next queries
+// open new ones. database/sql hands out idle connections first, so taking
+// as many connections as are idle takes all of them.
+func discardIdleConns(ctx context.Context, db *sql.DB) {
+	conns := make([]*sql.Conn, 0, db.Stats().Idle)
+	for range cap(conns) {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			break
+		}
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+		_ = conn.Close()
+	}
+}
+
+// planResult returns the schema the changes produce from current
+func planResult(ctx context.Context, current *schema.Database, changes []Change) (*schema.Database, error) {
+	scratch, err := buildDatabase(current)
+	if err != nil {
+		return nil, fmt.Errorf("build current schema: %w", err)
+	}
+	defer func() { _ = scratch.Close() }()
+
+	if _, err := scratch.Exec("PRAGMA foreign_keys = OFF"); err != nil {
+		return nil, fmt.Errorf("disable foreign keys: %w", err)
+	}
+	if err := executeChanges(ctx, scratch, changes, nil); err != nil {
+		return nil, fmt.Errorf("plan does not run: %w", err)
+	}
+	return parser.FromDBContext(ctx, scratch)
+}
+
+// rebuildCopies returns the statements copying the rows of every target
+// table from the attached old database. Recreated tables are copied with
+// the column mapping of their recreate, the others by column name.
+func rebuildCopies(current, target *schema.Database, changes []Change) []string {
+	recreated := make(map[string]Change)
+	for _, c := range changes {
+		if c.RecreatesTable() {
+			recreated[c.Object] = c
+		}
+	}
+
+	var copies []string
+	for _, name := range slices.Sorted(maps.Keys(target.Tables)) {
+		if c, ok := recreated[name]; ok {
+			if rc, found := findRecreateCopy(c); found {
+				copies = append(copies, fmt.Sprintf("INSERT INTO main.%q (%s) SELECT %s FROM old.%s;", name, rc.cols, rc.exprs, rc.table))
+			}
+			continue
+		}
+		from := current.Tables[name]
+		if from == nil {
+			continue // Created by the plan
+		}
+		cols := commonColumns(from, target.Tables[name])
+		if len(cols) == 0 {
+			continue
+		}
+		quoted := make([]string, len(cols))
+		for i, col := range cols {
+			quoted[i] = fmt.Sprintf("%q", col)
+		}
+		list := strings.Join(quoted, ", ")
+		copies = append(copies, fmt.Sprintf("INSERT INTO main.%q (%s) SELECT %s FROM old.%q;", name, list, list, name))
+	}
+	return copies
+}
+
+// buildRebuild creates the target schema in a new file at newPath, copies
+// the data of the database at oldPath into it and checks the result. The
+// file is synced to disk before it is returned.
+func buildRebuild(ctx context.Context, oldPath, newPath string, target *schema.Database, copies []string, checks []Check) error {
+	if err := removeRebuild(newPath); err != nil {
+		return err
+	}
+	nb, err := parser.Open(newPath)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", newPath, err)
+	}
+	defer func() { _ = nb.Close() }()
+	nb.SetMaxOpenConns(1) // The attached database belongs to one connection
+
+	if _, err := nb.ExecContext(ctx, "ATTACH DATABASE ? AS old", oldPath); err != nil {
+		return fmt.Errorf("attach database: %w", err)
+	}
+	var pageSize, autoVacuum, userVersion, applicationID int64
+	for query, value := range map[string]*int64{
+		"PRAGMA old.page_size":      &pageSize,
+		"PRAGMA old.auto_vacuum":    &autoVacuum,
+		"PRAGMA old.user_version":   &userVersion,
+		"PRAGMA old.application_id": &applicationID,
+	} {
+		if err := nb.QueryRowContext(ctx, query).Scan(value); err != nil {
+			return fmt.Errorf("read %s: %w", strings.TrimPrefix(query, "PRAGMA old."), err)
+		}
+	}
+	// The file is discarded on failure, journaling it would only slow it down
+	for _, stmt := range []string{
+		fmt.Sprintf("PRAGMA main.page_size = %d", pageSize),
+		fmt.Sprintf("PRAGMA main.auto_vacuum = %d", autoVacuum),
+		"PRAGMA main.journal_mode = OFF",
+		"PRAGMA main.synchronous = OFF",
+		"PRAGMA foreign_keys = OFF",
+	} {
+		if _, err := nb.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+
+	if err := createTables(nb, target); err != nil {
+		return err
+	}
+	for _, stmt := range copies {
+		if _, err := nb.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("copy rows: %w\nSQL: %s", err, stmt)
+		}
+	}
+	// Keep AUTOINCREMENT counters, rows deleted at the end are not reused
+	var sequences int
+	if err := nb.QueryRowContext(ctx, "SELECT count(*) FROM main.sqlite_master m JOIN old.sqlite_master o USING (name) WHERE name = 'sqlite_sequence'").
+		Scan(&sequences); err != nil {
+		return fmt.Errorf("copy sequences: %w", err)
+	}
+	if sequences > 0 {
+		if _, err := nb.ExecContext(ctx, `
+			DELETE FROM main.sqlite_sequence;
+			INSERT INTO main.sqlite_sequence (name, seq) SELECT name, seq FROM old.sqlite_sequence
+				WHERE name IN (SELECT name FROM main.sqlite_master WHERE type = 'table');`); err != nil {
+			return fmt.Errorf("copy sequences: %w", err)
+		}
+	}
+	// Indexes are built once, after the rows are in; triggers do not fire on the copy
+	if err := createObjects(nb, target); err != nil {
+		return err
+	}
+	if _, err := nb.ExecContext(ctx, fmt.Sprintf("PRAGMA main.user_version = %d; PRAGMA main.application_id = %d", userVersion, applicationID)); err != nil {
+		return fmt.Errorf("copy user version: %w", err)
+	}
+	if _, err := nb.ExecContext(ctx, "DETACH DATABASE old"); err != nil {
+		return fmt.Errorf("detach database: %w", err)
+	}
+
+	if err := checkForeignKeys(ctx, nb); err != nil {
+		return err
+	}
+	if err := runChecks(ctx, nb, checks); err != nil {
+		return err
+	}
+	if err := nb.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", newPath, err)
+	}
+
+	f, err := os.OpenFile(filepath.Clean(newPath), os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("sync %s: %w", newPath, err)
+	}
+	err = f.Sync()
+	return errors.Join(err, f.Close())
+}
+
+// removeRebuild deletes a left-over rebuild file
+func removeRebuild(path string) error {
+	for _, p := range []string{path, path + "-journal", path + "-wal", path + "-shm"} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// syncDir syncs a directory, so a rename in it survives a power loss. Not
+// every platform can sync directories, so errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(filepath.Clean(dir))
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
@@ -0,0 +1,220 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+func TestApply_Rebuild(t *testing.T) {
+	db, dbPath := createTestDBWithPath(t, `
+		PRAGMA journal_mode = WAL;
+		PRAGMA user_version = 7;
+		CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id), title TEXT);
+		CREATE INDEX idx_posts_user ON posts(user_id);
+		INSERT INTO users (name) VALUES ('ann'), ('bob'), ('cid');
+		DELETE FROM users WHERE name = 'cid';
+		INSERT INTO posts (user_id, title) VALUES (1, 'hello'), (2, NULL);
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "schema.sql", `
+		CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, email TEXT);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id), title TEXT NOT NULL DEFAULT '');
+		CREATE INDEX idx_posts_user ON posts(user_id);
+		CREATE TABLE tags (id INTEGER PRIMARY KEY, name TEXT);
+	`)
+
+	if err := Apply(db, schemaDir, ApplyOptions{Strategy: StrategyRebuild}); err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
+
+	changes, err := CompareWithOptions(db, schemaDir, DiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("rebuild left %d changes: %v", len(changes), changes)
+	}
+
+	var users, posts, seq, version int
+	var mode string
+	for query, dest := range map[string]any{
+		"SELECT count(*) FROM users":                           &users,
+		"SELECT count(*) FROM posts WHERE title IS NOT NULL":   &posts,
+		"SELECT seq FROM sqlite_sequence WHERE name = 'users'": &seq,
+		"PRAGMA user_version":                                  &version,
+		"PRAGMA journal_mode":                                  &mode,
+	} {
+		if err := db.QueryRow(query).Scan(dest); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	if users != 2 || posts != 2 {
+		t.Errorf("got %d users and %d posts, want 2 and 2", users, posts)
+	}
+	if seq != 3 {
+		t.Errorf("AUTOINCREMENT sequence = %d, want 3", seq)
+	}
+	if version != 7 {
+		t.Errorf("user_version = %d, want 7", version)
+	}
+	if !strings.EqualFold(mode, "wal") {
+		t.Errorf("journal_mode = %q, want wal", mode)
+	}
+	if _, err := os.Stat(dbPath + RebuildSuffix); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("rebuild file left behind: %v", err)
+	}
+}
+
+func TestApply_RebuildFailureKeepsDatabase(t *testing.T) {
+	db, dbPath := createTestDBWithPath(t, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+		INSERT INTO users (name) VALUES ('ann');
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "schema.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, age INTEGER);`)
+
+	opts := ApplyOptions{
+		Strategy: StrategyRebuild,
+		Checks:   []Check{{Name: "always fails", SQL: "SELECT 1"}},
+	}
+	if err := Apply(db, schemaDir, opts); err == nil {
+		t.Fatal("expected the failing check to abort the rebuild")
+	}
+
+	current, err := parser.FromDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Tables["users"].HasColumn("age") {
+		t.Error("failed rebuild changed the database")
+	}
+	if _, err := os.Stat(dbPath + RebuildSuffix); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("rebuild file left behind: %v", err)
+	}
+}
+
+func TestUseRebuild(t *testing.T) {
+	current := schema.NewDatabase()
+	current.Tables["a"] = nil
+	current.Tables["b"] = nil
+	current.Tables["c"] = nil
+	recreate := func(name string) Change {
+		return Change{Type: RecreateTable, Object: name, SQL: []string{"CREATE TABLE x (id);"}}
+	}
+
+	tests := []struct {
+		name    string
+		opts    ApplyOptions
+		changes []Change
+		want    bool
+		wantErr bool
+	}{
+		{"in place", ApplyOptions{}, []Change{recreate("a"), recreate("b")}, false, false},
+		{"auto, most tables", ApplyOptions{Strategy: StrategyAuto}, []Change{recreate("a"), recreate("b")}, true, false},
+		{"auto, few tables", ApplyOptions{Strategy: StrategyAuto}, []Change{recreate("a")}, false, false},
+		{"auto, low priority", ApplyOptions{Strategy: StrategyAuto, LowPriority: true}, []Change{recreate("a"), recreate("b")}, false, false},
+		{
+			"auto, rename",
+			ApplyOptions{Strategy: StrategyAuto},
+			[]Change{recreate("a"), recreate("b"), {Type: RenameColumn, Object: "c", SQL: []string{"ALTER TABLE c RENAME x TO y;"}}},
+			false, false,
+		},
+		{"rebuild", ApplyOptions{Strategy: StrategyRebuild}, []Change{recreate("a")}, true, false},
+		{
+			"rebuild, backfill",
+			ApplyOptions{Strategy: StrategyRebuild},
+			[]Change{{Type: AddColumn, Object: "c", SQL: []string{"ALTER TABLE c ADD x;", "UPDATE c SET x = 1;"}}},
+			false, true,
+		},
+		{"rebuild, deferred indexes", ApplyOptions{Strategy: StrategyRebuild, DeferIndexes: true}, []Change{recreate("a")}, false, true},
+		{"unknown", ApplyOptions{Strategy: "swap"}, nil, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opts.useRebuild(current, tt.changes, true)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("useRebuild() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("useRebuild() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Without WAL, other connections cannot be detected, so auto applies in place
+	opts := ApplyOptions{Strategy: StrategyAuto}
+	if got, err := opts.useRebuild(current, []Change{recreate("a"), recreate("b")}, false); got || err != nil {
+		t.Errorf("useRebuild() without WAL = %v, %v, want false", got, err)
+	}
+}
+
+func TestApply_RebuildKeepsIdleConns(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		PRAGMA journal_mode = WAL;
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+	`)
+	defer func() { _ = db.Close() }()
+	db.SetMaxIdleConns(5)
+	schemaDir := createSchemaDir(t, "schema.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);`)
+
+	// Hold a few connections, so they are idle when the rebuild starts
+	var conns []*sql.Conn
+	for range 3 {
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+
+	if err := Apply(db, schemaDir, ApplyOptions{Strategy: StrategyRebuild}); err != nil {
+		t.Fatal(err)
+	}
+	// Every connection must see the rebuilt file
+	for range 5 {
+		conns = conns[:0]
+		for range 3 {
+			conn, err := db.Conn(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			conns = append(conns, conn)
+		}
+		for _, conn := range conns {
+			var sqlText string
+			if err := conn.QueryRowContext(context.Background(), "SELECT sql FROM sqlite_master WHERE name = 'users'").Scan(&sqlText); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(sqlText, "NOT NULL") {
+				t.Errorf("connection reads the replaced file: %s", sqlText)
+			}
+			_ = conn.Close()
+		}
+	}
+	// The caller's setting survives the rebuild
+	conns = conns[:0]
+	for range 4 {
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	if idle := db.Stats().Idle; idle != 4 {
+		t.Errorf("idle connections = %d, want 4 with SetMaxIdleConns(5)", idle)
+	}
+}
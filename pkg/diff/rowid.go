@@ -0,0 +1,135 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// rowidNames are the names SQLite accepts for the rowid, unless a column
+// takes them
+var rowidNames = []string{"rowid", "_rowid_", "oid"}
+
+// withoutRowid reports whether a table is declared WITHOUT ROWID
+func withoutRowid(t *schema.Table) bool {
+	_, _, tail, ok := tableDefinitions(t.SQL)
+	return ok && strings.Contains(strings.ToLower(strings.Join(strings.Fields(tail), " ")), "without rowid")
+}
+
+// rowidAlias returns the column that is an alias for the rowid of a table,
+// or "" if it has none: the only primary key column, declared with the type
+// INTEGER, of a rowid table. "INTEGER PRIMARY KEY DESC" in the column
+// definition is not an alias (a quirk SQLite keeps for compatibility).
+func rowidAlias(t *schema.Table) string {
+	if isVirtualTableSQL(t.SQL) || withoutRowid(t) {
+		return ""
+	}
+	var pk []schema.Column
+	for _, col := range t.Columns {
+		if col.PrimaryKey > 0 {
+			pk = append(pk, col)
+		}
+	}
+	if len(pk) != 1 || !strings.EqualFold(pk[0].Type, "INTEGER") {
+		return ""
+	}
+	if _, defs, _, ok := tableDefinitions(t.SQL); ok {
+		def := strings.ToLower(strings.Join(strings.Fields(columnDefinition(defs, pk[0].Name)), " "))
+		if strings.Contains(def, "primary key desc") {
+			return ""
+		}
+	}
+	return pk[0].Name
+}
+
+// rowidName returns a name that refers to the rowid in both tables, or "" if
+// columns take all of them
+func rowidName(from, to *schema.Table) string {
+	taken := func(t *schema.Table, name string) bool {
+		for _, col := range t.Columns {
+			if strings.EqualFold(col.Name, name) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, name := range rowidNames {
+		if !taken(from, name) && !taken(to, name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// aliasSource returns the old column the rowid alias of the new table is
+// copied from on recreate, or "" if it is computed or new
+func aliasSource(from, to *schema.Table, alias string, opts DiffOptions) string {
+	col := to.GetColumn(alias)
+	if expr := opts.columnExpression(from, to, *col); expr != "" {
+		if name := unquoteIdent(strings.TrimSpace(expr)); from.HasColumn(name) {
+			return name
+		}
+		return ""
+	}
+	if from.HasColumn(alias) {
+		return alias
+	}
+	return ""
+}
+
+// preservedRowid returns the name to copy the rowid of the old rows with on
+// recreate, or "" if the rowids are not preserved. They are preserved on
+// request when both tables are rowid tables and the new one has no rowid
+// alias, which would take the rowid from its own column instead; the copy
+// would otherwise renumber the rows, closing the gaps deletes left.
+func preservedRowid(from, to *schema.Table, opts DiffOptions) string {
+	if !opts.PreserveRowids || isVirtualTableSQL(from.SQL) || isVirtualTableSQL(to.SQL) {
+		return ""
+	}
+	if withoutRowid(from) || withoutRowid(to) || rowidAlias(to) != "" {
+		return ""
+	}
+	return rowidName(from, to)
+}
+
+// copyKey returns a name for the rowid of both tables if recreating from
+// as to copies every row with its rowid, or "" if it does not: the rowids
+// are preserved, or the rowid alias is copied from the old alias
+func copyKey(from, to *schema.Table, opts DiffOptions) string {
+	if name := preservedRowid(from, to, opts); name != "" {
+		return name
+	}
+	fromAlias, toAlias := rowidAlias(from), rowidAlias(to)
+	if fromAlias == "" || toAlias == "" || aliasSource(from, to, toAlias, opts) != fromAlias {
+		return ""
+	}
+	return rowidName(from, to)
+}
+
+// rowidAliasChange describes how recreating a table changes which column
+// is the alias for its rowid, or returns "" if it does not. Such recreates
+// keep the data but not the rowids: triggers, last_insert_rowid() callers
+// and external tables (like FTS content) referencing them silently break.
+func rowidAliasChange(from, to *schema.Table, opts DiffOptions) string {
+	fromAlias, toAlias := rowidAlias(from), rowidAlias(to)
+	switch {
+	case fromAlias == "" && toAlias == "":
+		return ""
+	case toAlias == "":
+		if preservedRowid(from, to, opts) != "" {
+			return fmt.Sprintf("column %q stops being an alias for the rowid; the rowids are preserved", fromAlias)
+		}
+		return fmt.Sprintf("column %q stops being an alias for the rowid; the copied rows get new rowids (see PreserveRowids)", fromAlias)
+	case fromAlias == "":
+		return fmt.Sprintf("column %q becomes an alias for the rowid; the copied rows take their rowid from it and the old rowids are lost", toAlias)
+	}
+	source := aliasSource(from, to, toAlias, opts)
+	if source == fromAlias {
+		return ""
+	}
+	if source == "" {
+		return fmt.Sprintf("the rowid alias moves from column %q to the new column %q; the old rowids are lost", fromAlias, toAlias)
+	}
+	return fmt.Sprintf("the rowid alias moves from column %q to %q; the copied rows take their rowid from %q", fromAlias, toAlias, source)
+}
@@ -0,0 +1,225 @@
+package diff
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+func TestRowidAlias(t *testing.T) {
+	tests := []struct {
+		name    string
+		sql     string
+		columns []schema.Column
+		want    string
+	}{
+		{
+			"integer primary key",
+			"CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT)",
+			[]schema.Column{{Name: "id", Type: "INTEGER", PrimaryKey: 1}, {Name: "name", Type: "TEXT"}},
+			"id",
+		},
+		{
+			"lowercase, table constraint",
+			"CREATE TABLE t (id integer, name TEXT, PRIMARY KEY (id DESC))",
+			[]schema.Column{{Name: "id", Type: "integer", PrimaryKey: 1}, {Name: "name", Type: "TEXT"}},
+			"id",
+		},
+		{
+			"int is not integer",
+			"CREATE TABLE t (id INT PRIMARY KEY)",
+			[]schema.Column{{Name: "id", Type: "INT", PrimaryKey: 1}},
+			"",
+		},
+		{
+			"primary key desc",
+			"CREATE TABLE t (id INTEGER PRIMARY KEY DESC)",
+			[]schema.Column{{Name: "id", Type: "INTEGER", PrimaryKey: 1}},
+			"",
+		},
+		{
+			"composite",
+			"CREATE TABLE t (a INTEGER, b INTEGER, PRIMARY KEY (a, b))",
+			[]schema.Column{{Name: "a", Type: "INTEGER", PrimaryKey: 1}, {Name: "b", Type: "INTEGER", PrimaryKey: 2}},
+			"",
+		},
+		{
+			"without rowid",
+			"CREATE TABLE t (id INTEGER PRIMARY KEY) WITHOUT ROWID",
+			[]schema.Column{{Name: "id", Type: "INTEGER", PrimaryKey: 1}},
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rowidAlias(&schema.Table{Name: "t", SQL: tt.sql, Columns: tt.columns}); got != tt.want {
+				t.Errorf("rowidAlias() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiff_RowidAliasChange(t *testing.T) {
+	var warnings []Warning
+	SetWarningHandler(func(w Warning) { warnings = append(warnings, w) })
+	defer SetWarningHandler(nil)
+
+	from := &schema.Database{Tables: map[string]*schema.Table{
+		"docs": {
+			Name:    "docs",
+			SQL:     "CREATE TABLE docs (id INTEGER PRIMARY KEY, body TEXT)",
+			Columns: []schema.Column{{Name: "id", Type: "INTEGER", PrimaryKey: 1}, {Name: "body", Type: "TEXT"}},
+		},
+	}}
+	to := &schema.Database{Tables: map[string]*schema.Table{
+		"docs": {
+			Name:    "docs",
+			SQL:     "CREATE TABLE docs (id INT PRIMARY KEY, body TEXT)",
+			Columns: []schema.Column{{Name: "id", Type: "INT", PrimaryKey: 1}, {Name: "body", Type: "TEXT"}},
+		},
+	}}
+	initMaps(from)
+	initMaps(to)
+
+	changes := Diff(from, to)
+	if len(changes) != 1 || !changes[0].RecreatesTable() {
+		t.Fatalf("expected one recreate, got %+v", changes)
+	}
+	if !strings.HasPrefix(changes[0].SQL[0], "-- Rowid alias changed:") {
+		t.Errorf("SQL should start with the rowid alias note, got %v", changes[0].SQL)
+	}
+	if len(warnings) != 1 || warnings[0].Object != "docs" {
+		t.Errorf("expected one warning for table docs, got %v", warnings)
+	}
+	copyRowids := `INSERT INTO "docs__new" (rowid, "id", "body") SELECT rowid, "id", "body" FROM "docs";`
+	if slices.Contains(changes[0].SQL, copyRowids) {
+		t.Errorf("rowids copied without PreserveRowids: %v", changes[0].SQL)
+	}
+
+	changes = DiffWithOptions(from, to, DiffOptions{PreserveRowids: true})
+	if len(changes) != 1 || !slices.Contains(changes[0].SQL, copyRowids) {
+		t.Errorf("SQL = %v, want it to contain %s", changes[0].SQL, copyRowids)
+	}
+
+	// Gaining the alias cannot preserve anything, but is reported too
+	warnings = nil
+	changes = DiffWithOptions(to, from, DiffOptions{PreserveRowids: true})
+	if len(changes) != 1 || strings.Contains(strings.Join(changes[0].SQL, "\n"), "rowid,") {
+		t.Errorf("expected a plain recreate, got %+v", changes)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Message, "becomes an alias") {
+		t.Errorf("expected one warning about the new alias, got %v", warnings)
+	}
+}
+
+func TestApply_PreserveRowids(t *testing.T) {
+	db := openTestDB(t, `
+		CREATE TABLE docs (id INTEGER PRIMARY KEY, body TEXT);
+		INSERT INTO docs (id, body) VALUES (5, 'a'), (9, 'b');
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "schema.sql", `CREATE TABLE docs (id INT PRIMARY KEY, body TEXT);`)
+
+	opts := ApplyOptions{DiffOptions: DiffOptions{PreserveRowids: true}}
+	if err := Apply(db, schemaDir, opts); err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
+	var mismatched int
+	if err := db.QueryRow("SELECT count(*) FROM docs WHERE rowid != id").Scan(&mismatched); err != nil {
+		t.Fatal(err)
+	}
+	if mismatched != 0 {
+		t.Errorf("%d rows got new rowids", mismatched)
+	}
+}
+
+func TestApply_PreserveRowidsForContentTable(t *testing.T) {
+	db := openTestDB(t, `
+		CREATE TABLE docs (body TEXT);
+		CREATE VIRTUAL TABLE docs_fts USING fts5(body, content='docs');
+		INSERT INTO docs (body) VALUES ('alpha'), ('beta'), ('gamma');
+		DELETE FROM docs WHERE body = 'alpha';
+		INSERT INTO docs_fts (docs_fts) VALUES ('rebuild');
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "schema.sql", `
+		CREATE TABLE docs (body TEXT NOT NULL);
+		CREATE VIRTUAL TABLE docs_fts USING fts5(body, content='docs');
+	`)
+
+	opts := ApplyOptions{DiffOptions: DiffOptions{PreserveRowids: true}}
+	if err := Apply(db, schemaDir, opts); err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
+	// The index still points at the right rows
+	var body string
+	if err := db.QueryRow("SELECT body FROM docs_fts WHERE docs_fts MATCH 'gamma'").Scan(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body != "gamma" {
+		t.Errorf("search for gamma found %q", body)
+	}
+}
+
+func TestDiff_PreserveRowids(t *testing.T) {
+	table := func(sql string, columns ...schema.Column) *schema.Database {
+		db := &schema.Database{Tables: map[string]*schema.Table{
+			"docs": {Name: "docs", SQL: sql, Columns: columns},
+		}}
+		initMaps(db)
+		return db
+	}
+	body, title := schema.Column{Name: "body", Type: "TEXT"}, schema.Column{Name: "title", Type: "TEXT"}
+	from := table("CREATE TABLE docs (body TEXT, title TEXT)", body, title)
+
+	tests := []struct {
+		name string
+		from *schema.Database
+		to   *schema.Database
+		want string // Expected copy statement, "" for no rowid
+	}{
+		{
+			"rowid table",
+			from,
+			table("CREATE TABLE docs (body TEXT NOT NULL, title TEXT)", schema.Column{Name: "body", Type: "TEXT", NotNull: true}, title),
+			`INSERT INTO "docs__new" (rowid, "body", "title") SELECT rowid, COALESCE("body", ''), "title" FROM "docs";`,
+		},
+		{
+			"column named rowid",
+			table("CREATE TABLE docs (body TEXT, rowid TEXT)", body, schema.Column{Name: "rowid", Type: "TEXT"}),
+			table("CREATE TABLE docs (rowid TEXT, body TEXT)", schema.Column{Name: "rowid", Type: "TEXT"}, body),
+			`INSERT INTO "docs__new" (_rowid_, "rowid", "body") SELECT _rowid_, "rowid", "body" FROM "docs";`,
+		},
+		{
+			"new integer primary key",
+			from,
+			table("CREATE TABLE docs (id INTEGER PRIMARY KEY, body TEXT, title TEXT)", schema.Column{Name: "id", Type: "INTEGER", PrimaryKey: 1}, body, title),
+			"",
+		},
+		{
+			"without rowid",
+			from,
+			table("CREATE TABLE docs (body TEXT PRIMARY KEY, title TEXT) WITHOUT ROWID", schema.Column{Name: "body", Type: "TEXT", PrimaryKey: 1, NotNull: true}, title),
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := DiffWithOptions(tt.from, tt.to, DiffOptions{PreserveRowids: true})
+			if len(changes) != 1 || !changes[0].RecreatesTable() {
+				t.Fatalf("expected one recreate, got %+v", changes)
+			}
+			if tt.want == "" {
+				if sql := strings.Join(changes[0].SQL, "\n"); strings.Contains(sql, "rowid,") {
+					t.Errorf("rowids copied into a table that cannot keep them: %s", sql)
+				}
+				return
+			}
+			if !slices.Contains(changes[0].SQL, tt.want) {
+				t.Errorf("SQL = %v, want it to contain %s", changes[0].SQL, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package diff
+
+import (
+	"encoding/json"
+	"io"
+	"maps"
+	"path/filepath"
+	"slices"
+)
+
+// lintRules describes the built-in rules of the lint command, including
+// matched policies, for SARIF rule metadata
+var lintRules = map[string]string{
+	"table-snake-case": "Table names are snake_case",
+	"table-singular":   "Table names are singular",
+	"table-plural":     "Table names are plural",
+	"index-name":       "Index names follow the configured pattern",
+	"trigger-prefix":   "Trigger names start with the configured prefix",
+	"policy-deny":      "A deny policy matches the schema",
+	"policy-warn":      "A warn policy matches the schema",
+	"policy-approve":   "An approve policy matches the schema",
+}
+
+// SARIF 2.1.0, only the parts lint results need
+type (
+	sarifLog struct {
+		Schema  string     `json:"$schema"`
+		Version string     `json:"version"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+	sarifDriver struct {
+		Name           string      `json:"name"`
+		Version        string      `json:"version,omitempty"`
+		InformationURI string      `json:"informationUri"`
+		Rules          []sarifRule `json:"rules"`
+	}
+	sarifRule struct {
+		ID               string       `json:"id"`
+		ShortDescription sarifMessage `json:"shortDescription"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifResult struct {
+		RuleID    string          `json:"ruleId"`
+		Level     string          `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations,omitempty"`
+	}
+	sarifLocation struct {
+		PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	}
+	sarifPhysicalLocation struct {
+		ArtifactLocation sarifArtifact `json:"artifactLocation"`
+		Region           *sarifRegion  `json:"region,omitempty"`
+	}
+	sarifArtifact struct {
+		URI string `json:"uri"`
+	}
+	sarifRegion struct {
+		StartLine int `json:"startLine"`
+	}
+)
+
+// WriteSARIF writes lint issues as a SARIF 2.1.0 log, the format GitHub code
+// scanning and other review tools import. Issues located with LocateIssues
+// point at their file and line; file paths are written as given, so run it
+// with a schema directory relative to the repository root. toolVersion may
+// be empty.
+func WriteSARIF(w io.Writer, issues []LintIssue, toolVersion string) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "sqlite-schema-diff",
+			Version:        toolVersion,
+			InformationURI: "https://github.com/mizuchilabs/sqlite-schema-diff",
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+	for _, id := range slices.Sorted(maps.Keys(lintRules)) {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: id, ShortDescription: sarifMessage{Text: lintRules[id]}})
+	}
+	for _, rule := range registeredLintRules() {
+		description := rule.Name()
+		if d, ok := rule.(lintRuleDescriber); ok {
+			description = d.Description()
+		}
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: rule.Name(), ShortDescription: sarifMessage{Text: description}})
+	}
+
+	for _, issue := range issues {
+		message := issue.Object + ": " + issue.Message
+		if issue.Fix != "" {
+			message += ", rename to " + issue.Fix
+		}
+		result := sarifResult{RuleID: issue.Rule, Level: "warning", Message: sarifMessage{Text: message}}
+		if issue.File != "" {
+			loc := sarifPhysicalLocation{ArtifactLocation: sarifArtifact{URI: filepath.ToSlash(issue.File)}}
+			if issue.Line > 0 {
+				loc.Region = &sarifRegion{StartLine: issue.Line}
+			}
+			result.Locations = []sarifLocation{{PhysicalLocation: loc}}
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
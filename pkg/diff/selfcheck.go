@@ -0,0 +1,208 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// ErrSelfCheckFailed is returned by ApplyPlan with ApplyOptions.SelfCheck
+// when applying the plan in memory does not reproduce the target schema
+var ErrSelfCheckFailed = errors.New("plan self-check failed")
+
+// Mismatch is an object whose schema after applying a plan differs from
+// the target
+type Mismatch struct {
+	Object  string   // Kind and name, e.g. "table users"
+	Problem string   // "missing", "unexpected" or "differs"
+	Want    []string // Target details absent from the result
+	Got     []string // Result details absent from the target
+}
+
+// SelfCheckReport is the outcome of applying a plan to an in-memory copy
+// of the current schema and comparing the result with the target
+type SelfCheckReport struct {
+	Changes    []Change   // The checked plan
+	ApplyError error      // Set if the plan failed to apply
+	Residual   []Change   // Changes a second diff still finds
+	Mismatches []Mismatch // Objects that differ structurally
+}
+
+// OK reports whether the plan reproduced the target schema
+func (r *SelfCheckReport) OK() bool {
+	return r.ApplyError == nil && len(r.Residual) == 0 && len(r.Mismatches) == 0
+}
+
+func (r *SelfCheckReport) String() string {
+	if r.OK() {
+		return fmt.Sprintf("Self-check passed: %d changes reproduce the target schema.\n", len(r.Changes))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Self-check failed for a plan of %d changes:\n", len(r.Changes))
+	if r.ApplyError != nil {
+		fmt.Fprintf(&b, "  plan failed to apply: %v\n", r.ApplyError)
+	}
+	for _, c := range r.Residual {
+		fmt.Fprintf(&b, "  residual %s: %s\n", c.Type, c.Description)
+	}
+	for _, m := range r.Mismatches {
+		fmt.Fprintf(&b, "  %s: %s\n", m.Object, m.Problem)
+		for _, line := range m.Want {
+			fmt.Fprintf(&b, "    - %s\n", line)
+		}
+		for _, line := range m.Got {
+			fmt.Fprintf(&b, "    + %s\n", line)
+		}
+	}
+	return b.String()
+}
+
+// SelfCheck diffs from against to, applies the plan to an in-memory copy of
+// from and checks the result equals to. Use it as a guard before applying
+// to production or in property-based tests of the differ.
+func SelfCheck(from, to *schema.Database) (*SelfCheckReport, error) {
+	return SelfCheckWithOptions(from, to, DiffOptions{})
+}
+
+// SelfCheckWithOptions is SelfCheck with diff options
+func SelfCheckWithOptions(from, to *schema.Database, opts DiffOptions) (*SelfCheckReport, error) {
+	opts.OnEvent = nil
+	return SelfCheckPlan(from, to, DiffWithOptions(from, to, opts), opts)
+}
+
+// SelfCheckPlan checks an existing plan the way SelfCheck does. Unlike
+// VerifyPlan, which only diffs the result again, it also compares every
+// object SQLite creates by name, and tables by their PRAGMA fingerprint,
+// so differences the differ's normalization hides are reported too. opts
+// must match the options the plan was generated with. The error is only
+// set when the check itself cannot run.
+func SelfCheckPlan(from, to *schema.Database, changes []Change, opts DiffOptions) (*SelfCheckReport, error) {
+	return SelfCheckPlanContext(context.Background(), from, to, changes, opts)
+}
+
+// SelfCheckPlanContext is SelfCheckPlan with a context, which cancels
+// applying the plan to the copy
+func SelfCheckPlanContext(ctx context.Context, from, to *schema.Database, changes []Change, opts DiffOptions) (*SelfCheckReport, error) {
+	report := &SelfCheckReport{Changes: changes}
+
+	db, err := buildDatabase(from)
+	if err != nil {
+		return nil, fmt.Errorf("build current schema: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	if _, err := db.Exec("PRAGMA foreign_keys = OFF"); err != nil {
+		return nil, fmt.Errorf("disable foreign keys: %w", err)
+	}
+	if err := executeChanges(ctx, db, changes, nil); err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		report.ApplyError = err
+		return report, nil
+	}
+
+	result, err := parser.FromDBContext(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	opts.OnEvent = nil // Residual diffs are not part of the plan
+	report.Residual = DiffWithOptions(result, to, opts)
+
+	report.Mismatches = append(report.Mismatches, missingObjects("table", to.Tables, result.Tables)...)
+	report.Mismatches = append(report.Mismatches, missingObjects("index", to.Indexes, result.Indexes)...)
+	report.Mismatches = append(report.Mismatches, missingObjects("view", to.Views, result.Views)...)
+	report.Mismatches = append(report.Mismatches, missingObjects("trigger", to.Triggers, result.Triggers)...)
+
+	// Column order only matters to the fingerprint under the strict policy
+	if opts.ColumnOrder == ColumnOrderIgnore {
+		return report, nil
+	}
+	for _, name := range slices.Sorted(maps.Keys(to.Tables)) {
+		want, got := to.Tables[name], result.Tables[name]
+		if got == nil || isVirtualTableSQL(want.SQL) {
+			continue // Virtual tables have no table_xinfo of their own
+		}
+		wantPrint, err := tableFingerprint(want.SQL)
+		if err != nil {
+			return nil, fmt.Errorf("fingerprint target table %q: %w", name, err)
+		}
+		gotPrint, err := tableFingerprint(got.SQL)
+		if err != nil {
+			return nil, fmt.Errorf("fingerprint result table %q: %w", name, err)
+		}
+		if wantPrint == gotPrint {
+			continue
+		}
+		wantLines, gotLines := strings.Split(wantPrint, "\n"), strings.Split(gotPrint, "\n")
+		report.Mismatches = append(report.Mismatches, Mismatch{
+			Object:  "table " + name,
+			Problem: "differs",
+			Want:    without(wantLines, gotLines),
+			Got:     without(gotLines, wantLines),
+		})
+	}
+	return report, nil
+}
+
+// selfCheckApply runs SelfCheckPlan for the changes ApplyPlan is about to
+// apply to db, against target or else the schema in schemaDir
+func selfCheckApply(ctx context.Context, db *sql.DB, schemaDir string, target *schema.Database, changes []Change, opts DiffOptions) error {
+	current, err := parser.FromDBContext(ctx, db)
+	if err != nil {
+		return fmt.Errorf("self-check: %w", err)
+	}
+	if target == nil {
+		if target, err = parser.ReadFilesContext(ctx, schemaDir, opts.Parse); err != nil {
+			return fmt.Errorf("self-check: %w", err)
+		}
+	}
+	caps, err := DetectCapabilities(db)
+	if err != nil {
+		return fmt.Errorf("self-check: %w", err)
+	}
+	report, err := SelfCheckPlanContext(ctx, current, target, changes, opts.WithCapabilities(caps))
+	if err != nil {
+		return fmt.Errorf("self-check: %w", err)
+	}
+	if !report.OK() {
+		return fmt.Errorf("%w:\n%s", ErrSelfCheckFailed, strings.TrimSuffix(report.String(), "\n"))
+	}
+	return nil
+}
+
+// missingObjects reports objects of one kind the target has and the
+// result lacks, and the other way around
+func missingObjects[T any](kind string, want, got map[string]T) []Mismatch {
+	var mismatches []Mismatch
+	for _, name := range slices.Sorted(maps.Keys(want)) {
+		if _, ok := got[name]; !ok {
+			mismatches = append(mismatches, Mismatch{Object: kind + " " + name, Problem: "missing"})
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(got)) {
+		if _, ok := want[name]; !ok {
+			mismatches = append(mismatches, Mismatch{Object: kind + " " + name, Problem: "unexpected"})
+		}
+	}
+	return mismatches
+}
+
+// without returns the lines of a that are not in b
+func without(a, b []string) []string {
+	var lines []string
+	for _, line := range a {
+		if !slices.Contains(b, line) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,152 @@
+package diff
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+func TestSelfCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+	}{
+		{
+			name: "add column and index",
+			from: `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`,
+			to: `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT);
+				CREATE INDEX idx_users_email ON users (email);`,
+		},
+		{
+			name: "recreate with foreign key and view",
+			from: `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+				CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER);`,
+			to: `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+				CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id));
+				CREATE VIEW post_authors AS SELECT p.id, u.name FROM posts p JOIN users u ON u.id = p.user_id;`,
+		},
+		{
+			name: "drop everything",
+			from: `CREATE TABLE old (id INTEGER); CREATE INDEX idx_old_id ON old (id);`,
+			to:   ``,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, err := parser.FromSQL(tt.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			to, err := parser.FromSQL(tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			report, err := SelfCheck(from, to)
+			if err != nil {
+				t.Fatalf("SelfCheck() error = %v", err)
+			}
+			if !report.OK() {
+				t.Errorf("SelfCheck() =\n%s", report)
+			}
+			if len(report.Changes) == 0 {
+				t.Error("SelfCheck() checked no changes")
+			}
+		})
+	}
+}
+
+func TestSelfCheckPlan_Mismatches(t *testing.T) {
+	from, err := parser.FromSQL(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := parser.FromSQL(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		changes []Change
+		want    []string
+	}{
+		{
+			name:    "empty plan",
+			changes: nil,
+			want:    []string{"residual CREATE_TABLE", "table posts: missing", "table users: differs", "+ 1|name|TEXT|0||0|0"},
+		},
+		{
+			name: "wrong table",
+			changes: []Change{{
+				Type:   CreateTable,
+				Object: "posts",
+				SQL:    []string{"CREATE TABLE posts (id INTEGER PRIMARY KEY)", "CREATE TABLE extra (id INTEGER)"},
+			}},
+			want: []string{"table extra: unexpected", "table users: differs"},
+		},
+		{
+			name: "failing statement",
+			changes: []Change{{
+				Type:        CreateTable,
+				Object:      "posts",
+				Description: "Create table posts",
+				SQL:         []string{"CREATE TABLE users (id INTEGER)"},
+			}},
+			want: []string{"plan failed to apply: Create table posts", "already exists"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := SelfCheckPlan(from, to, tt.changes, DiffOptions{})
+			if err != nil {
+				t.Fatalf("SelfCheckPlan() error = %v", err)
+			}
+			if report.OK() {
+				t.Fatal("SelfCheckPlan() passed a broken plan")
+			}
+			got := report.String()
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("SelfCheckPlan() =\n%s\nwant it to contain %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyPlan_SelfCheck(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "schema.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT);`)
+	opts := ApplyOptions{SelfCheck: true, SkipDiskCheck: true}
+
+	broken := []Change{{
+		Type:   AddColumn,
+		Object: "users",
+		SQL:    []string{`ALTER TABLE "users" ADD COLUMN "mail" TEXT`},
+	}}
+	if err := ApplyPlan(db, schemaDir, broken, opts); !errors.Is(err, ErrSelfCheckFailed) {
+		t.Fatalf("ApplyPlan() error = %v, want ErrSelfCheckFailed", err)
+	}
+	if cols := columnNames(db, "users"); len(cols) != 2 {
+		t.Fatalf("columns after refused apply = %v", cols)
+	}
+
+	changes, err := CompareWithOptions(db, schemaDir, DiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyPlan(db, schemaDir, changes, opts); err != nil {
+		t.Fatalf("ApplyPlan() error = %v", err)
+	}
+	if cols := columnNames(db, "users"); len(cols) != 3 {
+		t.Errorf("columns after apply = %v", cols)
+	}
+}
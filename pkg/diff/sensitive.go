@@ -0,0 +1,89 @@
+package diff
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// redacted replaces the values of sensitive columns in data previews and
+// column statistics
+const redacted = "[redacted]"
+
+// markSensitive flags the changes touching columns tagged as sensitive in
+// either schema (see schema.Column.Sensitive): column changes of a tagged
+// column, and changes creating, dropping, renaming or copying a table with
+// tagged columns
+func markSensitive(from, to *schema.Database, changes []Change) {
+	for i, c := range changes {
+		tables := []*schema.Table{from.Tables[c.Object], to.Tables[c.Object]}
+		switch c.Type {
+		case CreateTable, DropTable, RecreateTable:
+			changes[i].Sensitive = tagged(tables, "")
+		case RenameTable:
+			if old, _ := renamedTable(from, to); old != "" {
+				tables = append(tables, from.Tables[old])
+			}
+			changes[i].Sensitive = tagged(tables, "")
+		case AddColumn, DropColumn, AlterColumnDefault:
+			changes[i].Sensitive = tagged(tables, c.Column)
+		case RenameColumn:
+			// Column is the new name, a tag on the old one is only in from
+			changes[i].Sensitive = tagged(tables, c.Column) || droppedTag(tables[0], tables[1])
+		}
+	}
+}
+
+// tagged reports whether the column of any of the tables is tagged as
+// sensitive, or any column if column is empty
+func tagged(tables []*schema.Table, column string) bool {
+	for _, t := range tables {
+		if t == nil {
+			continue
+		}
+		for _, col := range t.Columns {
+			if col.Sensitive != "" && (column == "" || col.Name == column) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// droppedTag reports whether a tagged column of from is missing in to
+func droppedTag(from, to *schema.Table) bool {
+	if from == nil || to == nil {
+		return false
+	}
+	for _, col := range from.Columns {
+		if col.Sensitive != "" && !to.HasColumn(col.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// sensitiveColumns returns the sensitivity tags of the columns of a table
+// in db, by column name
+func sensitiveColumns(db *sql.DB, table string) (map[string]string, error) {
+	s, err := parser.FromDBWithoutColumns(db)
+	if err != nil {
+		return nil, err
+	}
+	t := s.Tables[table]
+	if t == nil {
+		return nil, nil
+	}
+	if err := parser.ReadColumns(db, t); err != nil {
+		return nil, fmt.Errorf("read columns of %q: %w", table, err)
+	}
+	tags := make(map[string]string)
+	for _, col := range t.Columns {
+		if col.Sensitive != "" {
+			tags[col.Name] = col.Sensitive
+		}
+	}
+	return tags, nil
+}
@@ -0,0 +1,123 @@
+package diff
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+func TestDiff_Sensitive(t *testing.T) {
+	current, err := parser.FromSQL(`
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY,
+			email TEXT, -- @pii
+			ssn TEXT -- @sensitive
+		);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, body TEXT);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := parser.FromSQL(`
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY,
+			email TEXT -- @pii
+		);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, body TEXT, author TEXT);
+		CREATE TABLE cards (number TEXT -- @encrypted
+		);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, c := range Diff(current, target) {
+		if c.Sensitive {
+			got = append(got, string(c.Type)+" "+c.Object)
+		}
+	}
+	// Adding author to posts touches no tagged column
+	want := []string{"CREATE_TABLE cards", "DROP_COLUMN users"}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("sensitive changes = %q, want %q", got, want)
+	}
+}
+
+func TestPreviewLostData_Redacted(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE users (
+			id INTEGER PRIMARY KEY,
+			name TEXT,
+			email TEXT -- @pii
+		);
+		INSERT INTO users VALUES (1, 'ann', 'ann@example.com'), (2, 'bob', NULL);
+	`)
+	defer func() { _ = db.Close() }()
+
+	current, err := parser.FromDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := Diff(current, schema.NewDatabase())
+
+	previews, err := PreviewLostData(db, changes, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(previews) != 1 || !slices.Equal(previews[0].Redacted, []string{"email"}) {
+		t.Fatalf("previews = %+v, want the users table with email redacted", previews)
+	}
+	rows := previews[0].Rows
+	if *rows[0][1] != "'ann'" || *rows[0][2] != redacted || rows[1][2] != nil {
+		t.Errorf("rows = %v, want names and the redacted email, NULL kept", rows)
+	}
+
+	stats, err := DestructiveColumnStats(db, changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range stats {
+		if strings.Contains(s.String(), "example.com") {
+			t.Errorf("stats leak the email: %s", s)
+		}
+		if s.Column == "email" && !strings.HasSuffix(s.String(), "values redacted (pii)") {
+			t.Errorf("email stats = %s, want redacted values", s)
+		}
+	}
+}
+
+func TestCheckPolicies_Approve(t *testing.T) {
+	policies, err := ParsePolicies(`
+# Personal data needs a privacy review
+changes.exists(c, c.sensitive) -> approve privacy
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := policies[0]; p.Action != PolicyApprove || p.Approver != "privacy" {
+		t.Fatalf("policy = %+v, want approve by privacy", p)
+	}
+	for _, src := range []string{"true -> approve", "true -> deny privacy"} {
+		if _, err := ParsePolicies(src); err == nil {
+			t.Errorf("ParsePolicies(%q) should fail", src)
+		}
+	}
+
+	changes := []Change{{Type: DropColumn, Object: "users", Column: "email", Sensitive: true}}
+	err = CheckPolicies(policies, changes, nil)
+	if !errors.Is(err, ErrApprovalRequired) || !strings.Contains(err.Error(), "privacy (Personal data needs a privacy review)") {
+		t.Errorf("error = %v, want an approval needed from privacy", err)
+	}
+	if err := CheckPolicies(policies, changes, nil, "@Privacy"); err != nil {
+		t.Errorf("approved plan refused: %v", err)
+	}
+	if err := CheckPolicies(policies, []Change{{Type: DropColumn, Object: "users", Column: "age"}}, nil); err != nil {
+		t.Errorf("plan without sensitive changes refused: %v", err)
+	}
+}
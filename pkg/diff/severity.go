@@ -0,0 +1,87 @@
+package diff
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Severity rates the impact of a change on a scale from info to danger.
+// Destructive is kept for compatibility and is set exactly for danger.
+type Severity string
+
+const (
+	SeverityInfo   Severity = "info"   // Adds to the schema, e.g. a new table, column or index
+	SeverityWarn   Severity = "warn"   // Removes or renames something that can be recreated from the schema, e.g. a view or index
+	SeverityDanger Severity = "danger" // May destroy data, e.g. dropping a table or column
+)
+
+// severities in ascending order
+var severities = []Severity{SeverityInfo, SeverityWarn, SeverityDanger}
+
+// ParseSeverity parses "info", "warn" or "danger"
+func ParseSeverity(s string) (Severity, error) {
+	if !slices.Contains(severities, Severity(s)) {
+		return "", fmt.Errorf("invalid severity %q: must be info, warn or danger", s)
+	}
+	return Severity(s), nil
+}
+
+// Above reports whether s is more severe than t
+func (s Severity) Above(t Severity) bool {
+	return slices.Index(severities, s) > slices.Index(severities, t)
+}
+
+// severityOf returns the severity a change is planned with: danger if it
+// is destructive, otherwise that of its type. Unknown types warn.
+func severityOf(c Change) Severity {
+	if c.Destructive {
+		return SeverityDanger
+	}
+	info, ok := LookupChangeType(c.Type)
+	if !ok || info.Severity == SeverityDanger {
+		return SeverityWarn
+	}
+	return info.Severity
+}
+
+// setSeverities sets the Severity of changes that have none
+func setSeverities(changes []Change) {
+	for i := range changes {
+		if changes[i].Severity == "" {
+			changes[i].Severity = severityOf(changes[i])
+		}
+	}
+}
+
+// MaxSeverity returns the highest severity of the changes, empty without
+// changes
+func MaxSeverity(changes []Change) Severity {
+	var highest Severity
+	for _, c := range changes {
+		s := changeSeverity(c)
+		if highest == "" || s.Above(highest) {
+			highest = s
+		}
+	}
+	return highest
+}
+
+// SkipAbove returns the changes of at most the given severity. Skipping
+// above warn is the same as skipping destructive changes.
+func SkipAbove(changes []Change, limit Severity) []Change {
+	var kept []Change
+	for _, c := range changes {
+		if !changeSeverity(c).Above(limit) {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// changeSeverity returns the severity of a change, as planned if it is set
+func changeSeverity(c Change) Severity {
+	if c.Severity != "" {
+		return c.Severity
+	}
+	return severityOf(c)
+}
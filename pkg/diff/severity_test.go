@@ -0,0 +1,114 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+func TestDiff_Severity(t *testing.T) {
+	from, err := parser.FromSQL(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, legacy TEXT);
+		CREATE TABLE old (id INTEGER);
+		CREATE TABLE tags (name TEXT);
+		CREATE INDEX idx_tags_name ON tags (name);
+		CREATE VIEW user_names AS SELECT name FROM users;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := parser.FromSQL(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY);
+		CREATE TABLE tags (name TEXT);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[ChangeType]Severity{
+		DropColumn:  SeverityDanger,
+		DropTable:   SeverityDanger,
+		DropIndex:   SeverityWarn,
+		DropView:    SeverityWarn,
+		CreateTable: SeverityInfo,
+	}
+	changes := Diff(from, to)
+	for _, c := range changes {
+		if c.Severity != want[c.Type] {
+			t.Errorf("%s %s: severity %q, want %q", c.Type, c.Object, c.Severity, want[c.Type])
+		}
+		if c.Destructive != (c.Severity == SeverityDanger) {
+			t.Errorf("%s %s: destructive %v with severity %q", c.Type, c.Object, c.Destructive, c.Severity)
+		}
+	}
+	if got := MaxSeverity(changes); got != SeverityDanger {
+		t.Errorf("MaxSeverity() = %q", got)
+	}
+	if got := len(SkipAbove(changes, SeverityWarn)); got != 3 {
+		t.Errorf("SkipAbove(warn) kept %d changes, want 3", got)
+	}
+	if got := len(SkipAbove(changes, SeverityInfo)); got != 1 {
+		t.Errorf("SkipAbove(info) kept %d changes, want 1", got)
+	}
+}
+
+func TestSeverityOf(t *testing.T) {
+	tests := []struct {
+		name   string
+		change Change
+		want   Severity
+	}{
+		{"create", Change{Type: CreateIndex}, SeverityInfo},
+		{"drop view", Change{Type: DropView}, SeverityWarn},
+		{"recreating rename", Change{Type: RenameColumn, Destructive: true}, SeverityDanger},
+		{"classified safe drop", Change{Type: DropTable}, SeverityWarn},
+		{"unknown type", Change{Type: "ALTER_UNIVERSE"}, SeverityWarn},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := severityOf(tt.change); got != tt.want {
+				t.Errorf("severityOf() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseSeverity("critical"); err == nil {
+		t.Error("ParseSeverity() accepted an unknown severity")
+	}
+	if !SeverityDanger.Above(SeverityWarn) || SeverityInfo.Above(SeverityInfo) {
+		t.Error("Above() mismatch")
+	}
+}
+
+func TestApplyPlan_SkipAbove(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE INDEX idx_users_name ON users (name);
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "schema.sql", `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT);
+	`)
+
+	changes, err := CompareWithOptions(db, schemaDir, DiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyPlan(db, schemaDir, changes, ApplyOptions{SkipAbove: "none"}); err == nil {
+		t.Fatal("ApplyPlan() accepted an unknown severity")
+	}
+	if err := ApplyPlan(db, schemaDir, changes, ApplyOptions{SkipAbove: SeverityInfo, SkipDiskCheck: true}); err != nil {
+		t.Fatalf("ApplyPlan() error = %v", err)
+	}
+	if cols := columnNames(db, "users"); len(cols) != 3 {
+		t.Errorf("columns after apply = %v", cols)
+	}
+	var indexes int
+	if err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type='index' AND name='idx_users_name'").Scan(&indexes); err != nil {
+		t.Fatal(err)
+	}
+	if indexes != 1 {
+		t.Error("ApplyPlan() dropped the index above SkipAbove")
+	}
+}
@@ -0,0 +1,244 @@
+package diff
+
+import (
+	"database/sql"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// IndexSuggestion is an index the schema may be missing. Suggestions are
+// advisory and never part of a plan.
+type IndexSuggestion struct {
+	Table   string   `json:"table"`
+	Columns []string `json:"columns"`
+	Reasons []string `json:"reasons"` // Why the index is suggested, e.g. "foreign key to users"
+	SQL     string   `json:"sql"`
+}
+
+func (s IndexSuggestion) String() string {
+	return fmt.Sprintf("%s -- %s", s.SQL, strings.Join(s.Reasons, ", "))
+}
+
+// SuggestIndexes proposes indexes for foreign key columns no index starts
+// with, which SQLite otherwise scans the child table for on every parent
+// delete or update, and for columns views filter or join on
+func SuggestIndexes(s *schema.Database) ([]IndexSuggestion, error) {
+	db, err := buildDatabase(s)
+	if err != nil {
+		return nil, fmt.Errorf("build schema: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	var suggestions []IndexSuggestion
+	suggest := func(table string, cols []string, reason string) {
+		for i := range suggestions {
+			if suggestions[i].Table == table && slices.Equal(suggestions[i].Columns, cols) {
+				suggestions[i].Reasons = append(suggestions[i].Reasons, reason)
+				return
+			}
+		}
+		quoted := make([]string, len(cols))
+		for i, c := range cols {
+			quoted[i] = fmt.Sprintf("%q", c)
+		}
+		suggestions = append(suggestions, IndexSuggestion{
+			Table:   table,
+			Columns: cols,
+			Reasons: []string{reason},
+			SQL: fmt.Sprintf("CREATE INDEX %q ON %q (%s);",
+				"idx_"+table+"_"+strings.Join(cols, "_"), table, strings.Join(quoted, ", ")),
+		})
+	}
+
+	// Leading columns of the usable indexes of every table
+	indexed := map[string][][]string{}
+	for _, table := range slices.Sorted(maps.Keys(s.Tables)) {
+		if isVirtualTableSQL(s.Tables[table].SQL) {
+			continue
+		}
+		if indexed[table], err = tableIndexes(db, table); err != nil {
+			return nil, fmt.Errorf("read indexes of %q: %w", table, err)
+		}
+	}
+	covered := func(table string, cols []string) bool {
+		return slices.ContainsFunc(indexed[table], func(idx []string) bool {
+			return len(idx) >= len(cols) && slices.Equal(idx[:len(cols)], cols)
+		})
+	}
+
+	for _, table := range slices.Sorted(maps.Keys(indexed)) {
+		rows, err := queryStrings(db, `SELECT id, "table", "from" FROM pragma_foreign_key_list(?) ORDER BY id, seq`, table)
+		if err != nil {
+			return nil, fmt.Errorf("read foreign keys of %q: %w", table, err)
+		}
+		var cols []string
+		for i, row := range rows {
+			f := strings.SplitN(row, "|", 3)
+			cols = append(cols, f[2])
+			if i+1 < len(rows) && strings.SplitN(rows[i+1], "|", 2)[0] == f[0] {
+				continue // Composite key continues
+			}
+			if !covered(table, cols) {
+				suggest(table, cols, "foreign key to "+f[1])
+			}
+			cols = nil
+		}
+	}
+
+	for _, view := range slices.Sorted(maps.Keys(s.Views)) {
+		for _, ref := range predicateColumns(s, s.Views[view].SQL) {
+			cols := []string{ref[1]}
+			if _, ok := indexed[ref[0]]; ok && !covered(ref[0], cols) {
+				suggest(ref[0], cols, "filtered or joined on in view "+view)
+			}
+		}
+	}
+	return suggestions, nil
+}
+
+// tableIndexes returns the columns of the indexes SQLite can search a table
+// with: its rowid alias and the full (not partial) indexes
+func tableIndexes(db *sql.DB, table string) ([][]string, error) {
+	var indexes [][]string
+
+	pk, err := queryStrings(db, `SELECT name, upper(type) FROM pragma_table_info(?) WHERE pk > 0`, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk) == 1 && strings.HasSuffix(pk[0], "|INTEGER") {
+		indexes = append(indexes, []string{strings.TrimSuffix(pk[0], "|INTEGER")})
+	}
+
+	names, err := queryStrings(db, `SELECT name FROM pragma_index_list(?) WHERE partial = 0`, table)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		cols, err := queryStrings(db, `SELECT coalesce(name, '') FROM pragma_index_info(?) ORDER BY seqno`, name)
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, cols)
+	}
+	return indexes, nil
+}
+
+// sqlTokenRe matches the tokens of a query, quoted ones whole
+var sqlTokenRe = regexp.MustCompile("\"(?:[^\"]|\"\")*\"|\\[[^\\]]*\\]|`[^`]*`|'(?:[^']|'')*'|[A-Za-z_][\\w$]*|\\d+(?:\\.\\d+)?|<=|>=|==|!=|<>|\\S")
+
+// identStartRe matches the start of an identifier token
+var identStartRe = regexp.MustCompile("^[A-Z_\"`[]")
+
+// predicateOperators compare a column in a way an index can serve
+var predicateOperators = []string{"=", "==", "<", ">", "<=", ">=", "IN", "IS", "BETWEEN"}
+
+// predicateColumns finds the table columns a query compares in WHERE and
+// JOIN ... ON clauses, as [table, column] pairs. It is a heuristic: names
+// are resolved through the table aliases of the whole query, and bare
+// column names only when a single table in the query has them.
+func predicateColumns(s *schema.Database, query string) [][2]string {
+	tokens := sqlTokenRe.FindAllString(stripComments(query), -1)
+	upper := func(i int) string {
+		if i < 0 || i >= len(tokens) {
+			return ""
+		}
+		return strings.ToUpper(tokens[i])
+	}
+	table := func(name string) (*schema.Table, bool) {
+		t, ok := s.Tables[unquoteIdent(name)]
+		return t, ok
+	}
+
+	// Tables after FROM, JOIN and commas in FROM, with their aliases
+	aliases := map[string]string{}
+	var tables []string
+	inFrom := false
+	for i, tok := range tokens {
+		switch upper(i) {
+		case "FROM", "JOIN":
+			inFrom = true
+			continue
+		case "WHERE", "ON", "USING", "GROUP", "ORDER", "LIMIT", "HAVING", "WINDOW", "SELECT", "UNION", "EXCEPT", "INTERSECT", ")":
+			inFrom = false
+		}
+		if !inFrom || !(upper(i-1) == "FROM" || upper(i-1) == "JOIN" || upper(i-1) == ",") {
+			continue
+		}
+		t, ok := table(tok)
+		if !ok {
+			continue
+		}
+		tables = append(tables, t.Name)
+		aliases[strings.ToLower(t.Name)] = t.Name
+		alias := i + 1
+		if upper(alias) == "AS" {
+			alias++
+		}
+		if a := upper(alias); a != "" && identStartRe.MatchString(a) && !slices.Contains(clauseWords, a) {
+			aliases[strings.ToLower(unquoteIdent(tokens[alias]))] = t.Name
+		}
+	}
+
+	var refs [][2]string
+	add := func(tbl, col string) {
+		t, ok := s.Tables[tbl]
+		if !ok || !t.HasColumn(col) {
+			return
+		}
+		ref := [2]string{tbl, col}
+		if !slices.Contains(refs, ref) {
+			refs = append(refs, ref)
+		}
+	}
+
+	inPredicate := false
+	for i, tok := range tokens {
+		switch upper(i) {
+		case "WHERE", "ON":
+			inPredicate = true
+			continue
+		case "GROUP", "ORDER", "LIMIT", "HAVING", "WINDOW", "SELECT", "FROM", "JOIN", "UNION", "EXCEPT", "INTERSECT":
+			inPredicate = false
+		}
+		if !inPredicate || upper(i+1) == "." || upper(i+1) == "(" {
+			continue
+		}
+		before := i - 1
+		if upper(i-1) == "." {
+			before = i - 3 // Before the qualifier
+		}
+		if !slices.Contains(predicateOperators, upper(before)) && !slices.Contains(predicateOperators, upper(i+1)) &&
+			!(upper(i+1) == "NOT" && slices.Contains(predicateOperators, upper(i+2))) {
+			continue
+		}
+		col := unquoteIdent(tok)
+		if upper(i-1) == "." {
+			if tbl, ok := aliases[strings.ToLower(unquoteIdent(tokens[i-2]))]; ok {
+				add(tbl, col)
+			}
+			continue
+		}
+		// A bare name belongs to the one table of the query having it
+		var owners []string
+		for _, tbl := range tables {
+			if s.Tables[tbl].HasColumn(col) && !slices.Contains(owners, tbl) {
+				owners = append(owners, tbl)
+			}
+		}
+		if len(owners) == 1 {
+			add(owners[0], col)
+		}
+	}
+	return refs
+}
+
+// clauseWords follow a table name in FROM without being its alias
+var clauseWords = []string{
+	"WHERE", "ON", "USING", "JOIN", "LEFT", "RIGHT", "FULL", "INNER", "CROSS", "NATURAL", "OUTER",
+	"GROUP", "ORDER", "LIMIT", "HAVING", "WINDOW", "UNION", "EXCEPT", "INTERSECT", "INDEXED", "NOT",
+}
@@ -0,0 +1,53 @@
+package diff
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+func TestSuggestIndexes(t *testing.T) {
+	db, err := parser.FromSQL(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT, org_id INT REFERENCES orgs (id));
+		CREATE TABLE orgs (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE posts (
+			id INTEGER PRIMARY KEY,
+			user_id INT REFERENCES users (id),
+			org_id INT,
+			region TEXT,
+			published INT,
+			FOREIGN KEY (org_id, region) REFERENCES regions (org_id, code)
+		);
+		CREATE TABLE regions (org_id INT, code TEXT, PRIMARY KEY (org_id, code));
+		CREATE TABLE comments (id INTEGER PRIMARY KEY, post_id INT REFERENCES posts (id));
+		CREATE INDEX idx_comments_post_id ON comments (post_id);
+		CREATE INDEX idx_users_email_partial ON users (email) WHERE email IS NOT NULL;
+		CREATE VIEW published_posts AS
+			SELECT p.id, u.email FROM posts AS p JOIN users u ON u.id = p.user_id
+			WHERE p.published = 1 AND email = 'a@b.c' AND region NOT IN ('x') ORDER BY p.id;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suggestions, err := SuggestIndexes(db)
+	if err != nil {
+		t.Fatalf("SuggestIndexes() error = %v", err)
+	}
+	var got []string
+	for _, s := range suggestions {
+		got = append(got, s.String())
+	}
+	want := []string{
+		`CREATE INDEX "idx_posts_org_id_region" ON "posts" ("org_id", "region"); -- foreign key to regions`,
+		`CREATE INDEX "idx_posts_user_id" ON "posts" ("user_id"); -- foreign key to users, filtered or joined on in view published_posts`,
+		`CREATE INDEX "idx_users_org_id" ON "users" ("org_id"); -- foreign key to orgs`,
+		`CREATE INDEX "idx_posts_published" ON "posts" ("published"); -- filtered or joined on in view published_posts`,
+		`CREATE INDEX "idx_users_email" ON "users" ("email"); -- filtered or joined on in view published_posts`,
+		`CREATE INDEX "idx_posts_region" ON "posts" ("region"); -- filtered or joined on in view published_posts`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("SuggestIndexes() =\n%s\nwant\n%s", got, want)
+	}
+}
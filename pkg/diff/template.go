@@ -0,0 +1,109 @@
+package diff
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+)
+
+// TB is the part of testing.TB a Template needs
+type TB interface {
+	Helper()
+	TempDir() string
+	Cleanup(func())
+	Fatalf(format string, args ...any)
+}
+
+// Template is a database built from a schema directory once per test run
+// and handed out as a cheap file copy to every test, instead of creating
+// the schema for each of them. Declare it at package level and close it
+// in TestMain:
+//
+//	var tmpl = diff.NewTemplate("../schema", diff.InitOptions{SeedDir: "../seeds"})
+//
+//	func TestMain(m *testing.M) {
+//		code := m.Run()
+//		_ = tmpl.Close()
+//		os.Exit(code)
+//	}
+//
+//	func TestSignup(t *testing.T) {
+//		db := tmpl.DB(t)
+//		...
+//	}
+//
+// A Template is safe for concurrent use by parallel tests.
+type Template struct {
+	schemaDir string
+	opts      InitOptions
+
+	once  sync.Once
+	dir   string
+	path  string
+	err   error
+	count atomic.Int64
+}
+
+// NewTemplate returns a template built from schemaDir with InitDatabase on
+// first use
+func NewTemplate(schemaDir string, opts InitOptions) *Template {
+	return &Template{schemaDir: schemaDir, opts: opts}
+}
+
+// build creates the template database, once
+func (t *Template) build() error {
+	t.once.Do(func() {
+		if t.dir, t.err = os.MkdirTemp("", "sqlite-schema-diff-template-*"); t.err != nil {
+			t.err = fmt.Errorf("create template directory: %w", t.err)
+			return
+		}
+		t.path = filepath.Join(t.dir, "template.db")
+		if t.err = InitDatabase(t.path, t.schemaDir, t.opts); t.err != nil {
+			t.err = fmt.Errorf("build template: %w", t.err)
+		}
+	})
+	return t.err
+}
+
+// CopyTo writes a copy of the template database to path
+func (t *Template) CopyTo(path string) error {
+	if err := t.build(); err != nil {
+		return err
+	}
+	return copyFile(t.path, path)
+}
+
+// Open copies the template into dir and opens the copy
+func (t *Template) Open(dir string) (*sql.DB, error) {
+	path := filepath.Join(dir, fmt.Sprintf("test-%d.db", t.count.Add(1)))
+	if err := t.CopyTo(path); err != nil {
+		return nil, err
+	}
+	return parser.Open(path)
+}
+
+// DB opens a copy of the template in the test's temporary directory and
+// closes it when the test finishes. Failing to build the template fails
+// the test.
+func (t *Template) DB(tb TB) *sql.DB {
+	tb.Helper()
+	db, err := t.Open(tb.TempDir())
+	if err != nil {
+		tb.Fatalf("template database: %v", err)
+	}
+	tb.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// Close removes the template database. Copies are not affected.
+func (t *Template) Close() error {
+	if t.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(t.dir)
+}
@@ -0,0 +1,83 @@
+package diff
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplate(t *testing.T) {
+	schemaDir := createSchemaDir(t, "schema.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`)
+	tmpl := NewTemplate(schemaDir, InitOptions{})
+	defer func() { _ = tmpl.Close() }()
+
+	first := tmpl.DB(t)
+	if _, err := first.Exec("INSERT INTO users (name) VALUES ('ann')"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every copy starts from the template, not from an earlier copy
+	second := tmpl.DB(t)
+	var count int
+	if err := second.QueryRow("SELECT count(*) FROM users").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("second copy has %d users, want 0", count)
+	}
+
+	changes, err := Compare(second, schemaDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("copy differs from the schema: %v", changes)
+	}
+}
+
+func TestTemplate_BuildError(t *testing.T) {
+	schemaDir := createSchemaDir(t, "schema.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	tmpl := NewTemplate(schemaDir, InitOptions{Pragmas: []string{"not a pragma"}})
+	defer func() { _ = tmpl.Close() }()
+
+	if _, err := tmpl.Open(t.TempDir()); err == nil {
+		t.Error("expected building the template to fail")
+	}
+	// The failure is remembered, not retried
+	if _, err := tmpl.Open(t.TempDir()); err == nil {
+		t.Error("expected the second copy to fail too")
+	}
+}
+
+func BenchmarkTemplate(b *testing.B) {
+	schemaDir := b.TempDir()
+	var content strings.Builder
+	for i := range 50 {
+		fmt.Fprintf(&content, "CREATE TABLE t%d (id INTEGER PRIMARY KEY, name TEXT, created_at TEXT);\n", i)
+		fmt.Fprintf(&content, "CREATE INDEX idx_t%d_name ON t%d(name);\n", i, i)
+	}
+	if err := os.WriteFile(filepath.Join(schemaDir, "schema.sql"), []byte(content.String()), 0o644); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("init", func(b *testing.B) {
+		for b.Loop() {
+			if err := InitDatabase(filepath.Join(b.TempDir(), "test.db"), schemaDir, InitOptions{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("template", func(b *testing.B) {
+		tmpl := NewTemplate(schemaDir, InitOptions{})
+		defer func() { _ = tmpl.Close() }()
+		for b.Loop() {
+			db, err := tmpl.Open(b.TempDir())
+			if err != nil {
+				b.Fatal(err)
+			}
+			_ = db.Close()
+		}
+	})
+}
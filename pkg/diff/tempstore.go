@@ -0,0 +1,92 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TempStore selects where SQLite keeps temporary tables and indexes, such
+// as the sorter of a new index or the copy of a large recreate
+type TempStore string
+
+const (
+	TempStoreDefault TempStore = ""       // Compile-time default, usually files
+	TempStoreFile    TempStore = "file"   // Temporary files, in TempDir if set
+	TempStoreMemory  TempStore = "memory" // Memory, for devices with little disk
+)
+
+// prepareTempStore applies the temp store options to the connection changes
+// are applied on and returns a function restoring the previous settings
+func prepareTempStore(ctx context.Context, conn *sql.Conn, opts ApplyOptions) (func() error, error) {
+	var restores []func() error
+	restore := func() error { return restoreAll(restores) }
+
+	switch opts.TempStore {
+	case TempStoreDefault:
+	case TempStoreFile, TempStoreMemory:
+		r, err := setPragma(ctx, conn, "temp_store", strings.ToUpper(string(opts.TempStore)))
+		if err != nil {
+			return nil, err
+		}
+		restores = append(restores, r)
+	default:
+		return nil, fmt.Errorf("unknown temp store %q", opts.TempStore)
+	}
+
+	if opts.TempDir != "" {
+		info, err := os.Stat(opts.TempDir)
+		if err == nil && !info.IsDir() {
+			err = errors.New("not a directory")
+		}
+		if err != nil {
+			_ = restore()
+			return nil, fmt.Errorf("temp directory %s: %w", opts.TempDir, err)
+		}
+		// Deprecated and process-wide, but the only way to move temporary
+		// files without changing the environment of the host application
+		r, err := setPragma(ctx, conn, "temp_store_directory", quoteString(opts.TempDir))
+		if err != nil {
+			_ = restore()
+			return nil, err
+		}
+		restores = append(restores, r)
+	}
+	return restore, nil
+}
+
+// setPragma sets a pragma on conn and returns a function restoring its
+// previous value
+func setPragma(ctx context.Context, conn *sql.Conn, name, value string) (func() error, error) {
+	var old string
+	err := conn.QueryRowContext(ctx, "PRAGMA "+name).Scan(&old)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("read %s: %w", name, err)
+	}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA %s = %s", name, value)); err != nil {
+		return nil, fmt.Errorf("set %s: %w", name, err)
+	}
+	return func() error {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA %s = %s", name, quoteString(old))); err != nil {
+			return fmt.Errorf("restore %s: %w", name, err)
+		}
+		return nil
+	}, nil
+}
+
+// restoreAll runs restore functions in reverse order
+func restoreAll(restores []func() error) error {
+	var errs []error
+	for i := len(restores) - 1; i >= 0; i-- {
+		errs = append(errs, restores[i]())
+	}
+	return errors.Join(errs...)
+}
+
+// quoteString quotes s as an SQL string literal
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
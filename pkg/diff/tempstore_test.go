@@ -0,0 +1,106 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrepareTempStore(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+	defer func() { _ = db.Close() }()
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	dir := filepath.Join(t.TempDir(), "it's tmp")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	restore, err := prepareTempStore(ctx, conn, ApplyOptions{TempStore: TempStoreMemory, TempDir: dir})
+	if err != nil {
+		t.Fatalf("prepareTempStore() error = %v", err)
+	}
+	if got := pragmaValue(t, conn, "temp_store"); got != "2" {
+		t.Errorf("temp_store = %q, want 2 (memory)", got)
+	}
+	if got := pragmaValue(t, conn, "temp_store_directory"); got != dir {
+		t.Errorf("temp_store_directory = %q, want %q", got, dir)
+	}
+
+	if err := restore(); err != nil {
+		t.Fatalf("restore() error = %v", err)
+	}
+	if got := pragmaValue(t, conn, "temp_store"); got != "0" {
+		t.Errorf("temp_store after restore = %q, want 0", got)
+	}
+	if got := pragmaValue(t, conn, "temp_store_directory"); got != "" {
+		t.Errorf("temp_store_directory after restore = %q, want unset", got)
+	}
+}
+
+func TestApply_TempStore(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE items (id INTEGER PRIMARY KEY, body TEXT);
+		INSERT INTO items (body) VALUES ('a'), ('b');
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "items.sql", `CREATE TABLE items (id INTEGER PRIMARY KEY, body TEXT NOT NULL);`)
+
+	opts := ApplyOptions{TempStore: TempStoreFile, TempDir: t.TempDir()}
+	if err := Apply(db, schemaDir, opts); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if changes, _ := Compare(db, schemaDir); len(changes) != 0 {
+		t.Errorf("Compare() after Apply() = %d changes, want 0", len(changes))
+	}
+}
+
+func TestApply_TempStoreErrors(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		opts    ApplyOptions
+		wantErr string
+	}{
+		{"unknown temp store", ApplyOptions{TempStore: "disk"}, "unknown temp store"},
+		{"missing dir", ApplyOptions{TempDir: filepath.Join(t.TempDir(), "missing")}, "temp directory"},
+		{"not a dir", ApplyOptions{TempDir: file}, "not a directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := createTestDBWithPath(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+			defer func() { _ = db.Close() }()
+			schemaDir := createSchemaDir(t, "users.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);`)
+
+			err := Apply(db, schemaDir, tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Apply() = %v, want error containing %q", err, tt.wantErr)
+			}
+			if changes, _ := Compare(db, schemaDir); len(changes) != 1 {
+				t.Errorf("Apply() changed the database despite the error")
+			}
+		})
+	}
+}
+
+// pragmaValue reads a pragma on conn, empty if it returns no rows
+func pragmaValue(t *testing.T, conn *sql.Conn, name string) string {
+	t.Helper()
+	var v string
+	err := conn.QueryRowContext(context.Background(), "PRAGMA "+name).Scan(&v)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		t.Fatal(err)
+	}
+	return v
+}
@@ -0,0 +1,84 @@
+package diff
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// Snapshot is the schema of a database at a point in time, e.g. a backup
+type Snapshot struct {
+	Name   string
+	Time   time.Time
+	Schema Source
+}
+
+// FileSnapshot returns a snapshot of the database file at path, dated by
+// its modification time. The schema is only read when the snapshot is
+// used. Backups nobody writes can be opened immutable (see OpenReadOnly).
+func FileSnapshot(path string, immutable bool) (Snapshot, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{
+		Name: path,
+		Time: info.ModTime(),
+		Schema: func(ctx context.Context) (*schema.Database, error) {
+			db, err := OpenSchemaOnly(path, immutable)
+			if err != nil {
+				return nil, err
+			}
+			defer func() { _ = db.Close() }()
+			return parser.FromDBContext(ctx, db)
+		},
+	}, nil
+}
+
+// TimelineStep is what changed between two successive snapshots
+type TimelineStep struct {
+	From     string    `json:"from"`
+	FromTime time.Time `json:"from_time"`
+	To       string    `json:"to"`
+	ToTime   time.Time `json:"to_time"`
+	Changes  []Change  `json:"changes"`
+}
+
+// Timeline orders the snapshots by time and compares each with the next,
+// reconstructing the schema changes made between them. With the backups of
+// a database followed by the database itself, it answers "what changed
+// between backup N and now". Steps without changes are kept, so every
+// snapshot appears in the timeline. Only two schemas are held at a time.
+func Timeline(ctx context.Context, snapshots []Snapshot, opts DiffOptions) ([]TimelineStep, error) {
+	snapshots = slices.Clone(snapshots)
+	slices.SortStableFunc(snapshots, func(a, b Snapshot) int { return a.Time.Compare(b.Time) })
+
+	var steps []TimelineStep
+	var prev *schema.Database
+	for i, s := range snapshots {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		current, err := s.Schema(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("read snapshot %s: %w", s.Name, err)
+		}
+		if i > 0 {
+			from := snapshots[i-1]
+			steps = append(steps, TimelineStep{
+				From:     from.Name,
+				FromTime: from.Time,
+				To:       s.Name,
+				ToTime:   s.Time,
+				Changes:  DiffWithOptions(prev, current, opts),
+			})
+		}
+		prev = current
+	}
+	return steps, nil
+}
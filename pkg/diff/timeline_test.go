@@ -0,0 +1,79 @@
+package diff
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+func TestTimeline(t *testing.T) {
+	snapshot := func(name string, day int, sql string) Snapshot {
+		s, err := parser.FromSQL(sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Snapshot{Name: name, Time: time.Date(2026, 10, day, 2, 0, 0, 0, time.UTC), Schema: SchemaSource(s)}
+	}
+	users := `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`
+
+	db, dbPath := createTestDBWithPath(t, users+`CREATE INDEX idx_users_name ON users(name);`)
+	_ = db.Close()
+	live, err := FileSnapshot(dbPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live.Time = time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	// Out of order on purpose: the timeline follows the snapshot times
+	steps, err := Timeline(context.Background(), []Snapshot{
+		live,
+		snapshot("backup-2", 2, users),
+		snapshot("backup-1", 1, `CREATE TABLE users (id INTEGER PRIMARY KEY);`),
+		snapshot("backup-3", 3, users),
+	}, DiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		from, to string
+		change   ChangeType
+	}{
+		{"backup-1", "backup-2", AddColumn},
+		{"backup-2", "backup-3", ""},
+		{"backup-3", dbPath, CreateIndex},
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("got %d steps, want %d: %+v", len(steps), len(want), steps)
+	}
+	for i, w := range want {
+		step := steps[i]
+		if step.From != w.from || step.To != w.to {
+			t.Errorf("step %d goes from %s to %s, want %s to %s", i, step.From, step.To, w.from, w.to)
+		}
+		if w.change == "" {
+			if len(step.Changes) != 0 {
+				t.Errorf("step %d: unexpected changes %+v", i, step.Changes)
+			}
+			continue
+		}
+		if len(step.Changes) != 1 || step.Changes[0].Type != w.change {
+			t.Errorf("step %d: changes = %+v, want one %s", i, step.Changes, w.change)
+		}
+	}
+}
+
+func TestTimeline_Error(t *testing.T) {
+	errCorrupt := errors.New("file is not a database")
+	_, err := Timeline(context.Background(), []Snapshot{
+		{Name: "backup-1", Schema: SchemaSource(&schema.Database{})},
+		{Name: "backup-2", Schema: func(context.Context) (*schema.Database, error) { return nil, errCorrupt }},
+	}, DiffOptions{})
+	if !errors.Is(err, errCorrupt) {
+		t.Errorf("error = %v, want %v", err, errCorrupt)
+	}
+}
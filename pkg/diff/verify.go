@@ -0,0 +1,383 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// Warning is a non-fatal observation made while diffing, such as a
+// suspected normalization false positive that was suppressed
+type Warning struct {
+	Object  string
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Object, w.Message)
+}
+
+var warningHandler func(Warning)
+
+// SetWarningHandler sets a function that receives warnings emitted outside
+// of a diff, and while diffing without DiffOptions.OnWarning. It is process
+// wide and not safe to change while diffs run, so prefer OnWarning.
+// Pass nil to discard warnings.
+func SetWarningHandler(fn func(Warning)) {
+	warningHandler = fn
+}
+
+func warn(object, format string, args ...any) {
+	if warningHandler != nil {
+		warningHandler(Warning{Object: object, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// warn sends a warning to OnWarning, or to the global handler if unset
+func (opts DiffOptions) warn(object, format string, args ...any) {
+	if opts.OnWarning == nil {
+		warn(object, format, args...)
+		return
+	}
+	opts.OnWarning(Warning{Object: object, Message: fmt.Sprintf(format, args...)})
+}
+
+// VerifyPlan applies changes to an in-memory copy of the current schema and
+// diffs the result against the target. Any returned change is a difference
+// the plan fails to resolve, which indicates a bug in plan generation.
+// opts must match the options the plan was generated with.
+func VerifyPlan(current, target *schema.Database, changes []Change, opts DiffOptions) ([]Change, error) {
+	return VerifyPlanContext(context.Background(), current, target, changes, opts)
+}
+
+// VerifyPlanContext is VerifyPlan with a context, which cancels applying
+// the plan to the copy
+func VerifyPlanContext(ctx context.Context, current, target *schema.Database, changes []Change, opts DiffOptions) ([]Change, error) {
+	db, err := buildDatabase(current)
+	if err != nil {
+		return nil, fmt.Errorf("build current schema: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	if _, err := db.Exec("PRAGMA foreign_keys = OFF"); err != nil {
+		return nil, fmt.Errorf("disable foreign keys: %w", err)
+	}
+	if err := executeChanges(ctx, db, changes, nil); err != nil {
+		return nil, fmt.Errorf("apply plan: %w", err)
+	}
+
+	result, err := parser.FromDBContext(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	opts.OnEvent = nil // Residual diffs are not part of the plan
+	return DiffWithOptions(result, target, opts), nil
+}
+
+// VerifyMigration runs a migration script, e.g. a hand-written or previously
+// generated migration file, against an in-memory copy of the current schema
+// and diffs the result against the target. Any returned change is drift
+// between the migration and the declared schema.
+func VerifyMigration(current, target *schema.Database, migrationSQL string, opts DiffOptions) ([]Change, error) {
+	db, err := buildDatabase(current)
+	if err != nil {
+		return nil, fmt.Errorf("build current schema: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	for i, stmt := range parser.SplitStatements(migrationSQL) {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, fmt.Errorf("run migration: statement %d: %w\nSQL: %s", i+1, err, stmt)
+		}
+	}
+
+	result, err := parser.FromDB(db)
+	if err != nil {
+		return nil, err
+	}
+	opts.OnEvent = nil // Residual diffs are not part of the plan
+	return DiffWithOptions(result, target, opts), nil
+}
+
+// buildDatabase creates an in-memory database containing the given schema
+func buildDatabase(s *schema.Database) (*sql.DB, error) {
+	db, err := parser.Open(":memory:")
+	if err != nil {
+		return nil, fmt.Errorf("create scratch database: %w", err)
+	}
+
+	// Every connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+
+	if err := createTables(db, s); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if err := createObjects(db, s); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// createTables creates the tables of a schema, virtual tables first
+func createTables(db *sql.DB, s *schema.Database) error {
+	// Virtual tables first, they create their own shadow tables
+	var virtual, regular []string
+	for _, name := range slices.Sorted(maps.Keys(s.Tables)) {
+		if isVirtualTableSQL(s.Tables[name].SQL) {
+			virtual = append(virtual, name)
+		} else {
+			regular = append(regular, name)
+		}
+	}
+
+	for _, name := range append(virtual, regular...) {
+		var exists int
+		if err := db.QueryRow(
+			"SELECT count(*) FROM sqlite_master WHERE type='table' AND name=?", name,
+		).Scan(&exists); err != nil {
+			return err
+		}
+		if exists > 0 {
+			continue // shadow table of a virtual table
+		}
+		if _, err := db.Exec(s.Tables[name].SQL); err != nil {
+			return fmt.Errorf("create table %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// createObjects creates the indexes, views and triggers of a schema
+func createObjects(db *sql.DB, s *schema.Database) error {
+	var stmts []string
+	for _, name := range slices.Sorted(maps.Keys(s.Indexes)) {
+		stmts = append(stmts, s.Indexes[name].SQL)
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Views)) {
+		stmts = append(stmts, s.Views[name].SQL)
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Triggers)) {
+		stmts = append(stmts, s.Triggers[name].SQL)
+	}
+
+	// Views and triggers may reference each other regardless of name order,
+	// so keep retrying failed statements while progress is being made
+	for len(stmts) > 0 {
+		var failed []string
+		var lastErr error
+		for _, stmt := range stmts {
+			if _, err := db.Exec(stmt); err != nil {
+				failed = append(failed, stmt)
+				lastErr = err
+			}
+		}
+		if len(failed) == len(stmts) {
+			return lastErr
+		}
+		stmts = failed
+	}
+	return nil
+}
+
+func isVirtualTableSQL(sql string) bool {
+	return strings.HasPrefix(strings.ToUpper(strings.Join(strings.Fields(sql), " ")), "CREATE VIRTUAL TABLE")
+}
+
+// structurallyEqual creates both table definitions in scratch in-memory
+// databases and compares everything SQLite exposes about them. It is used to
+// double-check recreates that were only triggered by a normalized-SQL mismatch.
+func structurallyEqual(from, to *schema.Table) (bool, error) {
+	fromPrint, err := tableFingerprint(from.SQL)
+	if err != nil {
+		return false, err
+	}
+	toPrint, err := tableFingerprint(to.SQL)
+	if err != nil {
+		return false, err
+	}
+	return fromPrint == toPrint, nil
+}
+
+// tableFingerprint returns a canonical description of a table built from
+// PRAGMA output rather than from its SQL text
+func tableFingerprint(createSQL string) (string, error) {
+	db, err := parser.Open(":memory:")
+	if err != nil {
+		return "", fmt.Errorf("create scratch database: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	// Every connection to :memory: is a separate database
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(createSQL); err != nil {
+		return "", fmt.Errorf("create scratch table: %w", err)
+	}
+
+	var name string
+	if err := db.QueryRow(
+		"SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%'",
+	).Scan(&name); err != nil {
+		return "", err
+	}
+
+	var parts []string
+
+	listRows, err := queryStrings(db,
+		"SELECT type, ncol, wr, strict FROM pragma_table_list WHERE schema='main' AND name=?", name)
+	if err != nil {
+		return "", err
+	}
+	parts = append(parts, listRows...)
+
+	autoinc, err := queryStrings(db,
+		"SELECT name FROM sqlite_master WHERE name='sqlite_sequence'")
+	if err != nil {
+		return "", err
+	}
+	parts = append(parts, fmt.Sprintf("autoincrement=%v", len(autoinc) > 0))
+
+	cols, err := queryStrings(db, `
+		SELECT cid, name, upper(type), "notnull", trim(coalesce(dflt_value, '')), pk, hidden
+		FROM pragma_table_xinfo(?) ORDER BY cid`, name)
+	if err != nil {
+		return "", err
+	}
+	parts = append(parts, cols...)
+
+	// Column collations are only visible through an index on the column
+	for i, col := range columnNames(db, name) {
+		idx := fmt.Sprintf("__collation_probe_%d", i)
+		if _, err := db.Exec(fmt.Sprintf("CREATE INDEX %q ON %q(%q)", idx, name, col)); err != nil {
+			return "", err
+		}
+		coll, err := queryStrings(db, "SELECT coll FROM pragma_index_xinfo(?) WHERE key=1", idx)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, fmt.Sprintf("collate %s %v", col, coll))
+		if _, err := db.Exec(fmt.Sprintf("DROP INDEX %q", idx)); err != nil {
+			return "", err
+		}
+	}
+
+	indexes, err := queryStrings(db, `
+		SELECT il."unique", il.origin, il.partial,
+			(SELECT group_concat(coalesce(ii.name, ii.cid) || ' ' || ii.desc || ' ' || ii.coll, ',')
+			 FROM pragma_index_xinfo(il.name) ii WHERE ii.key = 1)
+		FROM pragma_index_list(?) il`, name)
+	if err != nil {
+		return "", err
+	}
+	slices.Sort(indexes)
+	parts = append(parts, indexes...)
+
+	fks, err := queryStrings(db, `
+		SELECT id, seq, "table", "from", coalesce("to", ''), on_update, on_delete, match
+		FROM pragma_foreign_key_list(?)`, name)
+	if err != nil {
+		return "", err
+	}
+	slices.Sort(fks)
+	parts = append(parts, fks...)
+
+	parts = append(parts, unexposedClauses(normalizeSQL(createSQL))...)
+
+	return strings.Join(parts, "\n"), nil
+}
+
+// queryStrings runs a query and renders every row as a single string
+func queryStrings(db *sql.DB, query string, args ...any) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for rows.Next() {
+		values := make([]sql.NullString, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+
+		fields := make([]string, len(values))
+		for i, v := range values {
+			fields[i] = v.String
+		}
+		result = append(result, strings.Join(fields, "|"))
+	}
+	return result, rows.Err()
+}
+
+func columnNames(db *sql.DB, table string) []string {
+	names, err := queryStrings(db, "SELECT name FROM pragma_table_xinfo(?) ORDER BY cid", table)
+	if err != nil {
+		return nil
+	}
+	return names
+}
+
+// unexposedClauseRe matches the start of CHECK constraints and generated
+// column expressions, neither of which is exposed by any pragma
+var unexposedClauseRe = regexp.MustCompile(`\b(check|as)\(`)
+
+// unexposedKeywordRe matches the conflict and deferrable clauses, which no
+// pragma exposes either
+var unexposedKeywordRe = regexp.MustCompile(`\bon conflict\b|\bdeferrable\b`)
+
+// unexposedClauses extracts CHECK and generated column expressions, and the
+// definitions with conflict or deferrable clauses, from normalized SQL so
+// they can be compared textually
+func unexposedClauses(norm string) []string {
+	var clauses []string
+	for _, loc := range unexposedClauseRe.FindAllStringSubmatchIndex(norm, -1) {
+		rest := norm[loc[1]:]
+
+		depth := 1
+		end := 0
+		for end < len(rest) && depth > 0 {
+			switch rest[end] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			}
+			end++
+		}
+		clauses = append(clauses, norm[loc[2]:loc[3]]+" "+rest[:max(end-1, 0)])
+	}
+
+	// Definitions with a conflict or deferrable clause must match textually
+	if _, defs, _, ok := splitTableDefinitions(norm); ok {
+		var keyworded []string
+		for _, def := range defs {
+			if unexposedKeywordRe.MatchString(def) {
+				keyworded = append(keyworded, "definition "+def)
+			}
+		}
+		slices.Sort(keyworded)
+		clauses = append(clauses, keyworded...)
+	}
+	return clauses
+}
@@ -0,0 +1,292 @@
+package diff
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+func TestStructurallyEqual(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want bool
+	}{
+		{
+			name: "comment inside definition",
+			from: "CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT)",
+			to:   "CREATE TABLE t (\n-- primary key\nid INTEGER PRIMARY KEY, name TEXT)",
+			want: true,
+		},
+		{
+			name: "column constraint order",
+			from: "CREATE TABLE t (id INTEGER NOT NULL PRIMARY KEY)",
+			to:   "CREATE TABLE t (id INTEGER PRIMARY KEY NOT NULL)",
+			want: true,
+		},
+		{
+			name: "table level primary key",
+			from: "CREATE TABLE t (a TEXT, b TEXT, PRIMARY KEY (a, b))",
+			to:   "CREATE TABLE t (a TEXT, b TEXT, CONSTRAINT pk_t PRIMARY KEY (a, b))",
+			want: true,
+		},
+		{
+			name: "unique added",
+			from: "CREATE TABLE t (id INTEGER PRIMARY KEY, email TEXT)",
+			to:   "CREATE TABLE t (id INTEGER PRIMARY KEY, email TEXT UNIQUE)",
+			want: false,
+		},
+		{
+			name: "collation added",
+			from: "CREATE TABLE t (id INTEGER PRIMARY KEY, email TEXT)",
+			to:   "CREATE TABLE t (id INTEGER PRIMARY KEY, email TEXT COLLATE NOCASE)",
+			want: false,
+		},
+		{
+			name: "check changed",
+			from: "CREATE TABLE t (age INTEGER CHECK (age > 0))",
+			to:   "CREATE TABLE t (age INTEGER CHECK (age >= 18))",
+			want: false,
+		},
+		{
+			name: "generated expression changed",
+			from: "CREATE TABLE t (a INTEGER, b INTEGER AS (a + 1))",
+			to:   "CREATE TABLE t (a INTEGER, b INTEGER AS (a + 2))",
+			want: false,
+		},
+		{
+			name: "foreign key action changed",
+			from: "CREATE TABLE t (p INTEGER REFERENCES parent(id))",
+			to:   "CREATE TABLE t (p INTEGER REFERENCES parent(id) ON DELETE CASCADE)",
+			want: false,
+		},
+		{
+			name: "foreign key made deferrable",
+			from: "CREATE TABLE t (p INTEGER REFERENCES parent(id))",
+			to:   "CREATE TABLE t (p INTEGER REFERENCES parent(id) DEFERRABLE INITIALLY DEFERRED)",
+			want: false,
+		},
+		{
+			name: "unique conflict clause added",
+			from: "CREATE TABLE t (email TEXT UNIQUE)",
+			to:   "CREATE TABLE t (email TEXT UNIQUE ON CONFLICT REPLACE)",
+			want: false,
+		},
+		{
+			name: "not null conflict clause added",
+			from: "CREATE TABLE t (name TEXT NOT NULL)",
+			to:   "CREATE TABLE t (name TEXT NOT NULL ON CONFLICT IGNORE)",
+			want: false,
+		},
+		{
+			name: "conflict clause with reordered column constraints",
+			from: "CREATE TABLE t (name TEXT NOT NULL ON CONFLICT IGNORE)",
+			to:   "CREATE TABLE t (name TEXT NOT NULL ON CONFLICT IGNORE -- required\n)",
+			want: true,
+		},
+		{
+			name: "autoincrement added",
+			from: "CREATE TABLE t (id INTEGER PRIMARY KEY)",
+			to:   "CREATE TABLE t (id INTEGER PRIMARY KEY AUTOINCREMENT)",
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := structurallyEqual(&schema.Table{SQL: tt.from}, &schema.Table{SQL: tt.to})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("structurallyEqual() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiff_SuppressesNormalizationFalsePositive(t *testing.T) {
+	var warnings []Warning
+	opts := DiffOptions{OnWarning: func(w Warning) { warnings = append(warnings, w) }}
+
+	cols := []schema.Column{{Name: "id", Type: "INTEGER", NotNull: true, PrimaryKey: 1}}
+	from := &schema.Database{Tables: map[string]*schema.Table{
+		"t": {Name: "t", SQL: "CREATE TABLE t (id INTEGER PRIMARY KEY NOT NULL)", Columns: cols},
+	}}
+	to := &schema.Database{Tables: map[string]*schema.Table{
+		"t": {Name: "t", SQL: "CREATE TABLE t (id INTEGER NOT NULL PRIMARY KEY)", Columns: cols},
+	}}
+	initMaps(from)
+	initMaps(to)
+
+	if changes := DiffWithOptions(from, to, opts); len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+	if len(warnings) != 1 || warnings[0].Object != "t" {
+		t.Errorf("expected one warning for table t, got %v", warnings)
+	}
+
+	// Offline parsing executes no SQL, so the recreate is kept
+	opts.Parse.Offline = true
+	if changes := DiffWithOptions(from, to, opts); len(changes) != 1 {
+		t.Errorf("expected the recreate offline, got %+v", changes)
+	}
+}
+
+func TestDiff_KeepsRecreateForUnexposedClauses(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+	}{
+		{
+			name: "deferrable foreign key",
+			from: "CREATE TABLE t (id INTEGER PRIMARY KEY, p INTEGER REFERENCES parent(id));",
+			to:   "CREATE TABLE t (id INTEGER PRIMARY KEY, p INTEGER REFERENCES parent(id) DEFERRABLE INITIALLY DEFERRED);",
+		},
+		{
+			name: "unique on conflict",
+			from: "CREATE TABLE t (id INTEGER PRIMARY KEY, email TEXT UNIQUE);",
+			to:   "CREATE TABLE t (id INTEGER PRIMARY KEY, email TEXT UNIQUE ON CONFLICT REPLACE);",
+		},
+		{
+			name: "not null on conflict",
+			from: "CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT NOT NULL);",
+			to:   "CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT NOT NULL ON CONFLICT IGNORE);",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, err := parser.FromSQL("CREATE TABLE parent (id INTEGER PRIMARY KEY);" + tt.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			to, err := parser.FromSQL("CREATE TABLE parent (id INTEGER PRIMARY KEY);" + tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var warnings []Warning
+			changes := DiffWithOptions(from, to, DiffOptions{OnWarning: func(w Warning) { warnings = append(warnings, w) }})
+			if len(changes) != 1 || changes[0].Type != RecreateTable {
+				t.Errorf("changes = %+v, want a recreate of t", changes)
+			}
+			if len(warnings) != 0 {
+				t.Errorf("warnings = %v, want none", warnings)
+			}
+		})
+	}
+}
+
+func TestVerifyPlan(t *testing.T) {
+	current, err := parser.FromSQL(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE INDEX idx_users_name ON users(name);
+		CREATE VIEW user_names AS SELECT name FROM users;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := parser.FromSQL(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL, email TEXT);
+		CREATE INDEX idx_users_name ON users(name);
+		CREATE VIEW user_names AS SELECT name, email FROM users;
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users(id));
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := Diff(current, target)
+	residual, err := VerifyPlan(current, target, changes, DiffOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(residual) != 0 {
+		t.Errorf("expected plan to verify, got residual %+v", residual)
+	}
+
+	// A plan missing a change must be reported
+	var partial []Change
+	for _, c := range changes {
+		if c.Type != CreateTable {
+			partial = append(partial, c)
+		}
+	}
+	residual, err = VerifyPlan(current, target, partial, DiffOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(residual) != 1 || residual[0].Type != CreateTable {
+		t.Errorf("expected missing CREATE_TABLE as residual, got %+v", residual)
+	}
+}
+
+func TestVerifyMigration(t *testing.T) {
+	current, err := parser.FromSQL(`CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := parser.FromSQL(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT);
+		CREATE INDEX idx_users_email ON users(email);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		migration string
+		want      []ChangeType
+		wantErr   bool
+	}{
+		{
+			name: "matches declared schema",
+			migration: `BEGIN;
+				ALTER TABLE users ADD COLUMN email TEXT;
+				CREATE INDEX idx_users_email ON users(email);
+				COMMIT;`,
+		},
+		{
+			name:      "generated migration",
+			migration: GenerateSQL(Diff(current, target)),
+		},
+		{
+			name:      "missing index",
+			migration: `ALTER TABLE users ADD COLUMN email TEXT;`,
+			want:      []ChangeType{CreateIndex},
+		},
+		{
+			name: "extra table",
+			migration: `ALTER TABLE users ADD COLUMN email TEXT;
+				CREATE INDEX idx_users_email ON users(email);
+				CREATE TABLE audit (id INTEGER PRIMARY KEY);`,
+			want: []ChangeType{DropTable},
+		},
+		{
+			name:      "invalid migration",
+			migration: `ALTER TABLE nope ADD COLUMN email TEXT;`,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			residual, err := VerifyMigration(current, target, tt.migration, DiffOptions{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VerifyMigration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []ChangeType
+			for _, c := range residual {
+				got = append(got, c.Type)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("residual = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
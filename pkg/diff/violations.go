@@ -0,0 +1,327 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// maxSampleRowids is the number of violating rowids reported per CHECK
+const maxSampleRowids = 5
+
+// QuarantineSuffix is appended to a table's name to name the table that
+// QuarantineViolations moves its violating rows into. Quarantine tables of
+// declared tables are left out of diffs unless the schema declares them.
+const QuarantineSuffix = "_quarantine"
+
+// CheckViolation describes existing rows that would violate a CHECK
+// constraint of a recreated table
+type CheckViolation struct {
+	Table        string
+	Check        string  // CHECK expression as written in the schema
+	Count        int     // Number of violating rows
+	SampleRowids []int64 // First few violating rowids
+}
+
+func (v CheckViolation) String() string {
+	rowids := make([]string, len(v.SampleRowids))
+	for i, id := range v.SampleRowids {
+		rowids[i] = fmt.Sprint(id)
+	}
+	return fmt.Sprintf(
+		"table %q: %d rows violate CHECK (%s), e.g. rowid %s",
+		v.Table,
+		v.Count,
+		v.Check,
+		strings.Join(rowids, ", "),
+	)
+}
+
+// recreateCopyRe matches the copy statement of a generated table recreate,
+// also after quarantineViolations changed it
+var recreateCopyRe = regexp.MustCompile(
+	`(?s)^INSERT INTO ("(?:[^"]|"")*") \((.*)\) SELECT (.*) FROM ("(?:[^"]|"")*")(?: WHERE (rowid NOT IN .*))?;$`,
+)
+
+// recreateCopy is the parsed copy step of a RECREATE_TABLE change
+type recreateCopy struct {
+	index  int    // Position of the INSERT in Change.SQL
+	target string // Quoted name of the new table
+	table  string // Quoted name of the old table
+	cols   string // Quoted target columns
+	exprs  string // Expressions selected from the old table
+	where  string // Condition on the copied rows, if quarantineViolations added one
+	checks []string
+}
+
+// parseRecreateCopy finds the CHECK constraints of the new table and the
+// statement that copies rows into it
+func parseRecreateCopy(c Change) (recreateCopy, bool) {
+	rc, found := findRecreateCopy(c)
+	return rc, found && len(rc.checks) > 0
+}
+
+// findRecreateCopy finds the statement that copies rows into the new table
+// of a recreate, and the new table's CHECK constraints if any
+func findRecreateCopy(c Change) (recreateCopy, bool) {
+	var rc recreateCopy
+	found := false
+	for i, stmt := range c.SQL {
+		switch {
+		case strings.HasPrefix(strings.ToUpper(stripComments(stmt)), "CREATE"):
+			rc.checks = checkExpressions(stripComments(stmt))
+		case recreateCopyRe.MatchString(stmt):
+			m := recreateCopyRe.FindStringSubmatch(stmt)
+			rc.index, rc.target, rc.cols, rc.exprs, rc.table, rc.where = i, m[1], m[2], m[3], m[4], m[5]
+			found = true
+		}
+	}
+	return rc, found
+}
+
+// violationQuery selects the rowids of rows that would fail a condition
+// once copied, evaluating it against the mapped target columns
+func (rc recreateCopy) violationQuery(condition string) string {
+	return fmt.Sprintf(
+		`WITH src("__rowid", %s) AS (SELECT rowid, %s FROM %s) SELECT "__rowid" FROM src WHERE %s`,
+		rc.cols, rc.exprs, rc.table, condition,
+	)
+}
+
+// ScanCheckViolations finds existing rows that would violate the CHECK
+// constraints a recreate adds or changes, which would otherwise abort the
+// migration with an opaque constraint error. CHECKs the old table already
+// has are not scanned, nor are CHECKs that cannot be evaluated before the
+// copy (e.g. on columns that only get a default).
+func ScanCheckViolations(db *sql.DB, changes []Change) ([]CheckViolation, error) {
+	return ScanCheckViolationsContext(context.Background(), db, changes)
+}
+
+// ScanCheckViolationsContext is ScanCheckViolations with a context, which
+// cancels the scans
+func ScanCheckViolationsContext(ctx context.Context, db *sql.DB, changes []Change) ([]CheckViolation, error) {
+	var violations []CheckViolation
+	for _, c := range changes {
+		if !c.RecreatesTable() {
+			continue
+		}
+		rc, ok := parseRecreateCopy(c)
+		if !ok {
+			continue
+		}
+		checks, err := newChecks(db, c.Object, rc.checks)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, check := range checks {
+			v, err := scanCheck(ctx, db, rc, check)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if err != nil {
+				continue // Not evaluable against the old rows
+			}
+			if v.Count > 0 {
+				v.Table = c.Object
+				violations = append(violations, v)
+			}
+		}
+	}
+	return violations, nil
+}
+
+// newChecks returns the checks that the current table does not have yet
+func newChecks(db *sql.DB, table string, checks []string) ([]string, error) {
+	current, err := queryStrings(db, "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table)
+	if err != nil {
+		return nil, fmt.Errorf("read table %q: %w", table, err)
+	}
+	if len(current) == 0 {
+		return checks, nil
+	}
+	existing := checkExpressions(current[0])
+	return slices.DeleteFunc(slices.Clone(checks), func(check string) bool {
+		return slices.ContainsFunc(existing, func(e string) bool { return sqlEquivalent(e, check) })
+	}), nil
+}
+
+func scanCheck(ctx context.Context, db *sql.DB, rc recreateCopy, check string) (CheckViolation, error) {
+	v := CheckViolation{Check: check}
+
+	rows, err := db.QueryContext(ctx, rc.violationQuery(fmt.Sprintf("NOT (%s)", check)))
+	if err != nil {
+		return v, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		v.Count++
+		if len(v.SampleRowids) < maxSampleRowids {
+			var rowid int64
+			if err := rows.Scan(&rowid); err != nil {
+				return v, err
+			}
+			v.SampleRowids = append(v.SampleRowids, rowid)
+		}
+	}
+	return v, rows.Err()
+}
+
+// quarantineViolations changes a recreate so that rows violating any of the
+// given CHECKs are moved into the table's quarantine table instead of
+// failing the copy. The quarantine table is created on the first
+// quarantine and appended to afterwards, gaining the columns it lacks; the
+// time of the apply in "__quarantined_at" tells the batches apart.
+func quarantineViolations(db *sql.DB, c *Change, checks []string) error {
+	rc, ok := parseRecreateCopy(*c)
+	if !ok || len(checks) == 0 {
+		return nil
+	}
+
+	conditions := make([]string, len(checks))
+	for i, check := range checks {
+		conditions[i] = fmt.Sprintf("NOT (%s)", check)
+	}
+	name := c.Object + QuarantineSuffix
+	quarantine := fmt.Sprintf("%q", name)
+	at := fmt.Sprintf("'%s'", time.Now().UTC().Format(time.RFC3339Nano))
+	violating := rc.violationQuery(strings.Join(conditions, " OR "))
+
+	existing, err := queryStrings(db, "SELECT name FROM pragma_table_xinfo(?)", name)
+	if err != nil {
+		return fmt.Errorf("read quarantine table %s: %w", quarantine, err)
+	}
+	var moves []string
+	if len(existing) == 0 {
+		moves = []string{fmt.Sprintf(
+			`CREATE TABLE %s AS SELECT rowid AS "__rowid", %s AS "__quarantined_at", * FROM %s WHERE rowid IN (%s);`,
+			quarantine, at, rc.table, violating,
+		)}
+	} else {
+		// Hidden columns of virtual tables are not part of "*"
+		cols, err := queryStrings(db, "SELECT name FROM pragma_table_xinfo(?) WHERE hidden != 1", c.Object)
+		if err != nil {
+			return fmt.Errorf("read table %q: %w", c.Object, err)
+		}
+		quoted := []string{`"__rowid"`, `"__quarantined_at"`}
+		for _, col := range append([]string{"__rowid", "__quarantined_at"}, cols...) {
+			if !slices.Contains(existing, col) {
+				moves = append(moves, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %q;", quarantine, col))
+			}
+		}
+		for _, col := range cols {
+			quoted = append(quoted, fmt.Sprintf("%q", col))
+		}
+		moves = append(moves, fmt.Sprintf(
+			"INSERT INTO %s (%s) SELECT rowid, %s, %s FROM %s WHERE rowid IN (%s);",
+			quarantine, strings.Join(quoted, ", "), at, strings.Join(quoted[2:], ", "), rc.table, violating,
+		))
+	}
+
+	stmts := slices.Insert(slices.Clone(c.SQL), rc.index, moves...)
+	copyIdx := rc.index + len(moves)
+	stmts[copyIdx] = fmt.Sprintf(
+		`%s WHERE rowid NOT IN (SELECT "__rowid" FROM %s WHERE "__quarantined_at" = %s);`,
+		strings.TrimSuffix(stmts[copyIdx], ";"),
+		quarantine,
+		at,
+	)
+
+	c.SQL = stmts
+	c.Description += fmt.Sprintf(" (CHECK violations moved to %s)", quarantine)
+	return nil
+}
+
+// withoutQuarantines returns current without the quarantine tables of the
+// tables in target that target does not declare itself, or current itself
+// if there are none
+func withoutQuarantines(current, target *schema.Database) *schema.Database {
+	if current == nil || target == nil {
+		return current
+	}
+	quarantined := func(name string) bool {
+		table, ok := strings.CutSuffix(name, QuarantineSuffix)
+		return ok && target.Tables[table] != nil && target.Tables[name] == nil
+	}
+	if !slices.ContainsFunc(slices.Collect(maps.Keys(current.Tables)), quarantined) {
+		return current
+	}
+	stripped := &schema.Database{
+		Tables:   maps.Clone(current.Tables),
+		Indexes:  maps.Clone(current.Indexes),
+		Views:    current.Views,
+		Triggers: maps.Clone(current.Triggers),
+	}
+	maps.DeleteFunc(stripped.Tables, func(name string, _ *schema.Table) bool { return quarantined(name) })
+	maps.DeleteFunc(stripped.Indexes, func(_ string, idx *schema.Index) bool { return quarantined(idx.Table) })
+	maps.DeleteFunc(stripped.Triggers, func(_ string, tr *schema.Trigger) bool { return quarantined(tr.Table) })
+	return stripped
+}
+
+// checkConstraintRe matches the start of a CHECK constraint
+var checkConstraintRe = regexp.MustCompile(`(?i)\bCHECK\s*\(`)
+
+// checkExpressions returns the expressions of all CHECK constraints in a
+// CREATE TABLE statement
+func checkExpressions(createSQL string) []string {
+	masked := maskQuoted(createSQL)
+
+	var exprs []string
+	for _, loc := range checkConstraintRe.FindAllStringIndex(masked, -1) {
+		depth := 1
+		for i := loc[1]; i < len(masked); i++ {
+			switch masked[i] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			}
+			if depth == 0 {
+				exprs = append(exprs, strings.TrimSpace(createSQL[loc[1]:i]))
+				break
+			}
+		}
+	}
+	return exprs
+}
+
+// maskQuoted replaces the contents of string literals and quoted identifiers
+// with spaces, keeping byte offsets intact
+func maskQuoted(s string) string {
+	b := []byte(s)
+	var quote byte
+	for i := 0; i < len(b); i++ {
+		switch {
+		case quote == 0 && (b[i] == '\'' || b[i] == '"' || b[i] == '`'):
+			quote = b[i]
+		case quote == 0 && b[i] == '[':
+			quote = ']'
+		case quote != 0 && b[i] == quote:
+			quote = 0
+		case quote != 0:
+			b[i] = ' '
+		}
+	}
+	return string(b)
+}
+
+// stripComments removes leading comment lines from a statement
+func stripComments(stmt string) string {
+	for {
+		stmt = strings.TrimSpace(stmt)
+		if !strings.HasPrefix(stmt, "--") {
+			return stmt
+		}
+		_, stmt, _ = strings.Cut(stmt, "\n")
+	}
+}
@@ -0,0 +1,195 @@
+package diff
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+func TestCheckExpressions(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want []string
+	}{
+		{
+			sql:  `CREATE TABLE t (a INT CHECK (a > 0), b TEXT, CHECK(length(b) < 10 AND b != ')'))`,
+			want: []string{"a > 0", "length(b) < 10 AND b != ')'"},
+		},
+		{
+			sql:  `CREATE TABLE t ("check" INT, note TEXT DEFAULT 'check(')`,
+			want: nil,
+		},
+		{
+			sql:  `CREATE TABLE t (status TEXT check ( status IN ('a', 'b') ))`,
+			want: []string{"status IN ('a', 'b')"},
+		},
+	}
+	for _, tt := range tests {
+		if got := checkExpressions(tt.sql); !slices.Equal(got, tt.want) {
+			t.Errorf("checkExpressions(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestScanCheckViolations(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE orders (id INTEGER PRIMARY KEY, qty INTEGER, status TEXT);
+		INSERT INTO orders (id, qty, status) VALUES
+			(1, 5, 'open'), (2, -1, 'open'), (3, 0, 'bogus'), (4, -7, NULL), (5, 1, 'done');
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "orders.sql", `CREATE TABLE orders (
+		id INTEGER PRIMARY KEY,
+		qty INT CHECK (qty >= 0),
+		status TEXT CHECK (status IN ('open', 'done')),
+		note TEXT DEFAULT 'x' CHECK (note != '')
+	);`)
+
+	changes, err := Compare(db, schemaDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	violations, err := ScanCheckViolations(db, changes)
+	if err != nil {
+		t.Fatalf("ScanCheckViolations() error: %v", err)
+	}
+
+	// The CHECK on the new column is not part of the copy and is skipped
+	var got []string
+	for _, v := range violations {
+		got = append(got, v.String())
+	}
+	want := []string{
+		`table "orders": 2 rows violate CHECK (qty >= 0), e.g. rowid 2, 4`,
+		`table "orders": 1 rows violate CHECK (status IN ('open', 'done')), e.g. rowid 3`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("violations =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// A cancelled scan fails instead of passing as "not evaluable"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ScanCheckViolationsContext(ctx, db, changes); !errors.Is(err, context.Canceled) {
+		t.Errorf("ScanCheckViolationsContext() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestApply_CheckViolations(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE orders (id INTEGER PRIMARY KEY, qty INTEGER);
+		INSERT INTO orders (id, qty) VALUES (1, 5), (2, -1), (3, 2);
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "orders.sql",
+		`CREATE TABLE orders (id INTEGER PRIMARY KEY, qty INTEGER CHECK (qty >= 0));`)
+
+	err := Apply(db, schemaDir, ApplyOptions{})
+	if err == nil || !strings.Contains(err.Error(), "1 rows violate CHECK (qty >= 0), e.g. rowid 2") {
+		t.Fatalf("expected violation report, got %v", err)
+	}
+
+	if err := Apply(db, schemaDir, ApplyOptions{QuarantineViolations: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var kept, quarantined string
+	if err := db.QueryRow("SELECT group_concat(id) FROM orders").Scan(&kept); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`SELECT group_concat("__rowid" || ':' || qty) FROM orders_quarantine`).
+		Scan(&quarantined); err != nil {
+		t.Fatal(err)
+	}
+	if kept != "1,3" || quarantined != "2:-1" {
+		t.Errorf("kept = %q, quarantined = %q", kept, quarantined)
+	}
+
+	// The quarantine table is not drift
+	changes, err := Compare(db, schemaDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("changes after quarantine = %+v, want none", changes)
+	}
+
+	// A later quarantine appends, and a live row reusing a quarantined
+	// rowid is kept
+	if _, err := db.Exec("INSERT INTO orders (id, qty) VALUES (2, 7), (4, 0)"); err != nil {
+		t.Fatal(err)
+	}
+	schemaDir = createSchemaDir(t, "orders.sql",
+		`CREATE TABLE orders (id INTEGER PRIMARY KEY, qty INTEGER CHECK (qty >= 1));`)
+	if err := Apply(db, schemaDir, ApplyOptions{QuarantineViolations: true}); err != nil {
+		t.Fatalf("second quarantine: %v", err)
+	}
+	if err := db.QueryRow("SELECT group_concat(id) FROM orders").Scan(&kept); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`SELECT group_concat("__rowid" || ':' || qty) FROM orders_quarantine`).
+		Scan(&quarantined); err != nil {
+		t.Fatal(err)
+	}
+	if kept != "1,2,3" || quarantined != "2:-1,4:0" {
+		t.Errorf("after second quarantine kept = %q, quarantined = %q", kept, quarantined)
+	}
+}
+
+func TestScanCheckViolations_OnlyNewChecks(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		CREATE TABLE orders (id INTEGER PRIMARY KEY, qty INTEGER CHECK (qty >= 0), status TEXT);
+		PRAGMA ignore_check_constraints = ON;
+		INSERT INTO orders (id, qty, status) VALUES (1, -1, 'open'), (2, 3, '');
+		PRAGMA ignore_check_constraints = OFF;
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "orders.sql", `CREATE TABLE orders (
+		id INTEGER PRIMARY KEY,
+		qty INTEGER CHECK ( qty >= 0 ),
+		status TEXT CHECK (status != '')
+	);`)
+
+	changes, err := Compare(db, schemaDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	violations, err := ScanCheckViolations(db, changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The unchanged CHECK on qty is not scanned, although row 1 violates it
+	if len(violations) != 1 || violations[0].Check != "status != ''" {
+		t.Errorf("violations = %v, want only the new CHECK on status", violations)
+	}
+}
+
+func TestWithoutQuarantines(t *testing.T) {
+	current := &schema.Database{Tables: map[string]*schema.Table{
+		"orders":              {Name: "orders"},
+		"orders_quarantine":   {Name: "orders_quarantine"},
+		"payments_quarantine": {Name: "payments_quarantine"},
+		"audit_quarantine":    {Name: "audit_quarantine"},
+	}}
+	target := &schema.Database{Tables: map[string]*schema.Table{
+		"orders":           {Name: "orders"},
+		"audit":            {Name: "audit"},
+		"audit_quarantine": {Name: "audit_quarantine"},
+	}}
+
+	got := slices.Sorted(maps.Keys(withoutQuarantines(current, target).Tables))
+	// Declared quarantine tables and those of undeclared tables are diffed
+	want := []string{"audit_quarantine", "orders", "payments_quarantine"}
+	if !slices.Equal(got, want) {
+		t.Errorf("tables = %q, want %q", got, want)
+	}
+	if len(current.Tables) != 4 {
+		t.Error("withoutQuarantines modified its input")
+	}
+}
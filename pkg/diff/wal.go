@@ -0,0 +1,104 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// WALPolicy controls how far the write-ahead log may grow while applying,
+// for devices with little free disk space
+type WALPolicy string
+
+const (
+	// WALKeep leaves the journal alone. This is the default.
+	WALKeep WALPolicy = ""
+	// WALCheckpoint checkpoints and truncates the WAL after every commit
+	// that left it larger than ApplyOptions.MaxWALSize. A transaction can
+	// not be checkpointed before it commits, so combine it with LowPriority
+	// or DeferIndexes to bound the WAL by the largest batch.
+	WALCheckpoint WALPolicy = "checkpoint"
+	// WALDelete switches a database in WAL mode to journal_mode=DELETE for
+	// the apply and back to WAL afterwards. The rollback journal only holds
+	// the original content of changed pages, so copying a table into new
+	// pages barely grows it. Needs the database to be otherwise unused.
+	WALDelete WALPolicy = "delete"
+)
+
+// prepareWAL applies the WAL policy to the connection changes are applied
+// on and returns a function restoring the previous settings
+func prepareWAL(ctx context.Context, conn *sql.Conn, opts ApplyOptions) (func() error, error) {
+	noop := func() error { return nil }
+
+	switch opts.WAL {
+	case WALKeep:
+		return noop, nil
+	case WALCheckpoint:
+		if opts.MaxWALSize <= 0 {
+			return nil, fmt.Errorf("WAL policy %q needs MaxWALSize", opts.WAL)
+		}
+		var limit int64
+		if err := conn.QueryRowContext(ctx, "PRAGMA journal_size_limit").Scan(&limit); err != nil {
+			return nil, fmt.Errorf("read journal size limit: %w", err)
+		}
+		// Truncate the WAL to the limit after every checkpoint
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA journal_size_limit = %d", opts.MaxWALSize)); err != nil {
+			return nil, fmt.Errorf("set journal size limit: %w", err)
+		}
+		return func() error {
+			_, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA journal_size_limit = %d", limit))
+			return err
+		}, nil
+	case WALDelete:
+		mode, err := journalMode(ctx, conn, "")
+		if err != nil || mode != "wal" {
+			return noop, err
+		}
+		if mode, err = journalMode(ctx, conn, "DELETE"); err != nil {
+			return nil, err
+		}
+		if mode != "delete" {
+			return nil, fmt.Errorf("switch to journal_mode=DELETE: still %q, is the database in use?", mode)
+		}
+		return func() error {
+			if mode, err := journalMode(ctx, conn, "WAL"); err != nil || mode != "wal" {
+				return fmt.Errorf("restore journal_mode=WAL: mode %q: %v", mode, err)
+			}
+			return nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown WAL policy %q", opts.WAL)
+	}
+}
+
+// journalMode reads the journal mode, or sets it if mode is not empty, and
+// returns the mode in effect
+func journalMode(ctx context.Context, conn *sql.Conn, mode string) (string, error) {
+	query := "PRAGMA journal_mode"
+	if mode != "" {
+		query += " = " + mode
+	}
+	var current string
+	if err := conn.QueryRowContext(ctx, query).Scan(&current); err != nil {
+		return "", fmt.Errorf("journal mode: %w", err)
+	}
+	return strings.ToLower(current), nil
+}
+
+// limitWAL checkpoints and truncates the WAL if a commit left it larger
+// than allowed by the WALCheckpoint policy
+func limitWAL(ctx context.Context, conn *sql.Conn, walPath string, opts ApplyOptions) error {
+	if opts.WAL != WALCheckpoint || walPath == "" {
+		return nil
+	}
+	info, err := os.Stat(walPath)
+	if err != nil || info.Size() <= opts.MaxWALSize {
+		return nil // No WAL, or small enough
+	}
+	if _, err := conn.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package diff
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestApply_WALDelete(t *testing.T) {
+	db, _ := createTestDBWithPath(t, `
+		PRAGMA journal_mode = WAL;
+		CREATE TABLE users (id INTEGER PRIMARY KEY);
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "users.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);`)
+
+	var during string
+	opts := ApplyOptions{WAL: WALDelete, OnCommit: func([]Change) {
+		_ = db.QueryRow("PRAGMA journal_mode").Scan(&during)
+	}}
+	if err := Apply(db, schemaDir, opts); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if during != "delete" {
+		t.Errorf("journal mode while applying = %q, want delete", during)
+	}
+	var after string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&after); err != nil || after != "wal" {
+		t.Errorf("journal mode after applying = %q (err %v), want wal", after, err)
+	}
+}
+
+func TestApply_WALCheckpoint(t *testing.T) {
+	db, dbPath := createTestDBWithPath(t, `
+		PRAGMA journal_mode = WAL;
+		CREATE TABLE items (id INTEGER PRIMARY KEY, body TEXT);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 2000)
+		INSERT INTO items (body) SELECT printf('%0500d', i) FROM n;
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "items.sql", `
+		CREATE TABLE items (id INTEGER PRIMARY KEY, body TEXT);
+		CREATE INDEX idx_items_body ON items(body);
+	`)
+
+	const limit = 64 << 10
+	opts := ApplyOptions{WAL: WALCheckpoint, MaxWALSize: limit, DeferIndexes: true}
+	if err := Apply(db, schemaDir, opts); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	info, err := os.Stat(dbPath + "-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > limit {
+		t.Errorf("WAL is %d bytes after applying, want at most %d", info.Size(), limit)
+	}
+}
+
+func TestApply_WALPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    ApplyOptions
+		wantErr string
+	}{
+		{"unknown policy", ApplyOptions{WAL: "truncate"}, "unknown WAL policy"},
+		{"checkpoint without size", ApplyOptions{WAL: WALCheckpoint}, "needs MaxWALSize"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := createTestDBWithPath(t, `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+			defer func() { _ = db.Close() }()
+			schemaDir := createSchemaDir(t, "users.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT);`)
+
+			err := Apply(db, schemaDir, tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Apply() = %v, want error containing %q", err, tt.wantErr)
+			}
+			if changes, _ := Compare(db, schemaDir); len(changes) != 1 {
+				t.Errorf("Apply() changed the database despite the error")
+			}
+		})
+	}
+}
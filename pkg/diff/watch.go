@@ -0,0 +1,195 @@
+package diff
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/parser"
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// WatchOptions configures a Watcher
+type WatchOptions struct {
+	DiffOptions
+
+	// SchemaDir makes drift events also list the changes that bring the
+	// database back to these schema files. Events without pending changes
+	// then come from migrations of the schema files themselves.
+	SchemaDir string
+
+	Interval   time.Duration // Time between polls (default 10s)
+	WebhookURL string        // URL drift events are POSTed to as JSON (empty = none)
+	Client     *http.Client  // HTTP client (default http.DefaultClient)
+
+	// OnDrift is called for every schema change found
+	OnDrift func(DriftEvent)
+	// OnError is called when a database cannot be read or an event cannot
+	// be delivered; the watcher keeps going
+	OnError func(path string, err error)
+}
+
+// DriftEvent reports a schema change of a watched database
+type DriftEvent struct {
+	Path            string    `json:"path"`
+	Time            time.Time `json:"time"`
+	SchemaVersion   int64     `json:"schema_version"`
+	PreviousVersion int64     `json:"previous_version"`
+	Changes         []string  `json:"changes"`           // What changed since the previous poll
+	Pending         []string  `json:"pending,omitempty"` // What brings the database back to SchemaDir
+}
+
+// watchedFile is what a Watcher last saw of a database
+type watchedFile struct {
+	stamp   string // Modification times and sizes of the file and its WAL
+	version int64
+	schema  *schema.Database
+}
+
+// Watcher polls database files for schema changes made behind the
+// schema files' back, e.g. someone running ALTER TABLE by hand in
+// production. A database is only opened when it or its WAL was modified,
+// and only read when PRAGMA schema_version moved. The first poll records
+// the schema each database starts from.
+type Watcher struct {
+	paths []string
+	opts  WatchOptions
+	files map[string]*watchedFile
+}
+
+// NewWatcher returns a watcher of the database files at paths
+func NewWatcher(paths []string, opts WatchOptions) *Watcher {
+	return &Watcher{paths: paths, opts: opts, files: make(map[string]*watchedFile)}
+}
+
+// Poll checks every database once and returns the schema changes found
+// since the previous poll. Events are also passed to OnDrift and posted to
+// WebhookURL; read and delivery failures go to OnError.
+func (w *Watcher) Poll(ctx context.Context) []DriftEvent {
+	var events []DriftEvent
+	for _, path := range w.paths {
+		event, err := w.poll(ctx, path)
+		if err != nil {
+			w.fail(path, err)
+			continue
+		}
+		if event == nil {
+			continue
+		}
+		events = append(events, *event)
+		if w.opts.OnDrift != nil {
+			w.opts.OnDrift(*event)
+		}
+		if w.opts.WebhookURL != "" {
+			if err := postJSON(ctx, w.opts.Client, w.opts.WebhookURL, event); err != nil {
+				w.fail(path, fmt.Errorf("post drift event: %w", err))
+			}
+		}
+	}
+	return events
+}
+
+// poll checks one database, returning an event if its schema changed
+func (w *Watcher) poll(ctx context.Context, path string) (*DriftEvent, error) {
+	stamp, err := fileStamp(path)
+	if err != nil {
+		return nil, err
+	}
+	last := w.files[path]
+	if last != nil && last.stamp == stamp {
+		return nil, nil
+	}
+
+	db, err := OpenReadOnly(path, false)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = db.Close() }()
+
+	version, err := SchemaVersionContext(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if last != nil && last.version == version {
+		last.stamp = stamp // Only data changed
+		return nil, nil
+	}
+	current, err := parser.FromDBContext(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("read schema: %w", err)
+	}
+	w.files[path] = &watchedFile{stamp: stamp, version: version, schema: current}
+	if last == nil {
+		return nil, nil
+	}
+
+	changes := DiffWithOptions(last.schema, current, w.opts.DiffOptions)
+	if len(changes) == 0 {
+		return nil, nil // E.g. a schema change that was undone again
+	}
+	event := &DriftEvent{
+		Path:            path,
+		Time:            time.Now().UTC(),
+		SchemaVersion:   version,
+		PreviousVersion: last.version,
+	}
+	for _, c := range changes {
+		event.Changes = append(event.Changes, c.Description)
+	}
+	if w.opts.SchemaDir != "" {
+		pending, err := CompareContext(ctx, db, w.opts.SchemaDir, w.opts.DiffOptions)
+		if err != nil {
+			return nil, fmt.Errorf("compare with schema files: %w", err)
+		}
+		for _, c := range pending {
+			event.Pending = append(event.Pending, c.Description)
+		}
+	}
+	return event, nil
+}
+
+func (w *Watcher) fail(path string, err error) {
+	if w.opts.OnError != nil {
+		w.opts.OnError(path, err)
+	}
+}
+
+// fileStamp identifies the current content of a database file and its WAL,
+// where changes of WAL databases land until they are checkpointed
+func fileStamp(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	stamp := fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size())
+	if wal, err := os.Stat(path + "-wal"); err == nil {
+		stamp += fmt.Sprintf(":%d:%d", wal.ModTime().UnixNano(), wal.Size())
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	return stamp, nil
+}
+
+// Watch polls the databases every Interval until ctx is cancelled (see
+// Watcher)
+func Watch(ctx context.Context, paths []string, opts WatchOptions) error {
+	if len(paths) == 0 {
+		return errors.New("no databases to watch")
+	}
+	w := NewWatcher(paths, opts)
+	ticker := time.NewTicker(cmp.Or(opts.Interval, 10*time.Second))
+	defer ticker.Stop()
+
+	for {
+		w.Poll(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
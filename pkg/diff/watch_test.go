@@ -0,0 +1,80 @@
+package diff
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWatcher(t *testing.T) {
+	db, dbPath := createTestDBWithPath(t, `
+		CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);
+		INSERT INTO users (name) VALUES ('ann');
+	`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "schema.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`)
+
+	var posted []DriftEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event DriftEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		posted = append(posted, event)
+	}))
+	defer srv.Close()
+
+	missing := filepath.Join(t.TempDir(), "missing.db")
+	var failed []string
+	w := NewWatcher([]string{dbPath, missing}, WatchOptions{
+		SchemaDir:  schemaDir,
+		WebhookURL: srv.URL,
+		OnError:    func(path string, err error) { failed = append(failed, path) },
+	})
+	ctx := context.Background()
+
+	if events := w.Poll(ctx); len(events) != 0 {
+		t.Errorf("first poll reported %+v, want only a baseline", events)
+	}
+	if len(failed) != 1 || failed[0] != missing {
+		t.Errorf("errors reported for %v, want %s", failed, missing)
+	}
+
+	// Data changes are not drift
+	if _, err := db.Exec("INSERT INTO users (name) VALUES ('bob')"); err != nil {
+		t.Fatal(err)
+	}
+	if events := w.Poll(ctx); len(events) != 0 {
+		t.Errorf("data change reported as %+v", events)
+	}
+
+	if _, err := db.Exec("ALTER TABLE users ADD COLUMN email TEXT"); err != nil {
+		t.Fatal(err)
+	}
+	events := w.Poll(ctx)
+	if len(events) != 1 {
+		t.Fatalf("got %d events after ALTER TABLE, want 1", len(events))
+	}
+	event := events[0]
+	if event.Path != dbPath || event.SchemaVersion <= event.PreviousVersion {
+		t.Errorf("event = %+v", event)
+	}
+	if len(event.Changes) != 1 || !strings.Contains(event.Changes[0], `"email"`) {
+		t.Errorf("changes = %v, want the new column", event.Changes)
+	}
+	if len(event.Pending) != 1 {
+		t.Errorf("pending = %v, want the change that drops the column again", event.Pending)
+	}
+	if len(posted) != 1 || posted[0].Path != dbPath {
+		t.Errorf("webhook got %+v", posted)
+	}
+
+	if events := w.Poll(ctx); len(events) != 0 {
+		t.Errorf("unchanged database reported %+v", events)
+	}
+}
@@ -0,0 +1,142 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrOutsideWindow is returned when destructive or expensive changes would
+// be applied outside every maintenance window
+var ErrOutsideWindow = errors.New("destructive and expensive changes are only applied inside a maintenance window")
+
+// Window is a recurring maintenance window in local time, such as the idle
+// hours of an embedded device
+type Window struct {
+	Days  []time.Weekday // Days the window starts on, empty for every day
+	Start time.Duration  // Start as an offset from midnight
+	End   time.Duration  // End as an offset from midnight, before Start for windows past midnight
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseWindow parses a window like "02:00-04:00", "Sat,Sun 00:00-06:00" or
+// "Mon-Fri 22:00-02:00". A window ending before it starts runs past
+// midnight and belongs to the day it starts on.
+func ParseWindow(s string) (Window, error) {
+	var w Window
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return w, fmt.Errorf("invalid window %q: want [days] HH:MM-HH:MM", s)
+	}
+	if len(fields) == 2 {
+		days, err := parseDays(fields[0])
+		if err != nil {
+			return w, fmt.Errorf("invalid window %q: %w", s, err)
+		}
+		w.Days = days
+	}
+
+	start, end, ok := strings.Cut(fields[len(fields)-1], "-")
+	if !ok {
+		return w, fmt.Errorf("invalid window %q: want [days] HH:MM-HH:MM", s)
+	}
+	var err error
+	if w.Start, err = parseClock(start); err != nil {
+		return w, fmt.Errorf("invalid window %q: %w", s, err)
+	}
+	if w.End, err = parseClock(end); err != nil {
+		return w, fmt.Errorf("invalid window %q: %w", s, err)
+	}
+	if w.Start == w.End {
+		return w, fmt.Errorf("invalid window %q: empty", s)
+	}
+	return w, nil
+}
+
+// ParseWindows parses windows separated by semicolons, e.g.
+// "Sat,Sun 00:00-06:00; Mon-Fri 02:00-04:00"
+func ParseWindows(s string) ([]Window, error) {
+	var windows []Window
+	for part := range strings.SplitSeq(s, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		w, err := ParseWindow(part)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// parseDays parses "Mon", "Sat,Sun" or a range like "Mon-Fri"
+func parseDays(s string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for part := range strings.SplitSeq(strings.ToLower(s), ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdays[from]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdays[to]; !ok {
+				return nil, fmt.Errorf("unknown day %q", to)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			if !slices.Contains(days, d) {
+				days = append(days, d)
+			}
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClock parses HH:MM into an offset from midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether t falls inside the window, in t's location
+func (w Window) Contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	onDay := func(d time.Weekday) bool { return len(w.Days) == 0 || slices.Contains(w.Days, d) }
+
+	if w.Start < w.End {
+		return onDay(t.Weekday()) && offset >= w.Start && offset < w.End
+	}
+	// Past midnight: the evening part on the start day, the morning part on the next
+	return (onDay(t.Weekday()) && offset >= w.Start) ||
+		(onDay(midnight.AddDate(0, 0, -1).Weekday()) && offset < w.End)
+}
+
+// CheckWindows returns ErrOutsideWindow if changes are destructive or
+// classified as expensive (see Classify) and now is outside every window.
+// No windows means any time.
+func CheckWindows(changes []Change, windows []Window, now time.Time) error {
+	if len(windows) == 0 || !(HasDestructive(changes) || HasClass(changes, ClassExpensive)) {
+		return nil
+	}
+	for _, w := range windows {
+		if w.Contains(now) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w (now %s)", ErrOutsideWindow, now.Format("Mon 15:04"))
+}
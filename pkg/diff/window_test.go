@@ -0,0 +1,121 @@
+package diff
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Window
+		wantErr bool
+	}{
+		{input: "02:00-04:00", want: Window{Start: 2 * time.Hour, End: 4 * time.Hour}},
+		{input: "Sat,Sun 00:00-06:30", want: Window{Days: []time.Weekday{time.Saturday, time.Sunday}, End: 6*time.Hour + 30*time.Minute}},
+		{input: "mon-wed 22:00-02:00", want: Window{Days: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday}, Start: 22 * time.Hour, End: 2 * time.Hour}},
+		{input: "Fri-Mon 01:00-02:00", want: Window{Days: []time.Weekday{time.Friday, time.Saturday, time.Sunday, time.Monday}, Start: time.Hour, End: 2 * time.Hour}},
+		{input: "Funday 01:00-02:00", wantErr: true},
+		{input: "01:00", wantErr: true},
+		{input: "25:00-26:00", wantErr: true},
+		{input: "03:00-03:00", wantErr: true},
+		{input: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseWindow(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseWindow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !slices.Equal(got.Days, tt.want.Days) || got.Start != tt.want.Start || got.End != tt.want.End {
+				t.Errorf("ParseWindow() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWindows(t *testing.T) {
+	windows, err := ParseWindows("Sat,Sun 00:00-06:00; Mon-Fri 02:00-04:00;")
+	if err != nil || len(windows) != 2 {
+		t.Fatalf("ParseWindows() = %+v (err %v), want 2 windows", windows, err)
+	}
+	if windows, err := ParseWindows(""); err != nil || windows != nil {
+		t.Errorf("ParseWindows(\"\") = %+v (err %v), want none", windows, err)
+	}
+}
+
+func TestWindow_Contains(t *testing.T) {
+	// 2026-01-05 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.January, 5+day, hour, minute, 0, 0, time.UTC)
+	}
+	weeknights, err := ParseWindow("Mon-Fri 22:00-02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want bool
+	}{
+		{"Monday evening", at(0, 23, 0), true},
+		{"Tuesday early morning", at(1, 1, 59), true},
+		{"end is exclusive", at(1, 2, 0), false},
+		{"Monday early morning belongs to Sunday", at(0, 1, 0), false},
+		{"Saturday early morning belongs to Friday", at(5, 1, 0), true},
+		{"Saturday evening", at(5, 23, 0), false},
+		{"afternoon", at(2, 15, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := weeknights.Contains(tt.t); got != tt.want {
+				t.Errorf("Contains(%s) = %v, want %v", tt.t.Format("Mon 15:04"), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckWindows(t *testing.T) {
+	night := []Window{{Start: 2 * time.Hour, End: 4 * time.Hour}}
+	noon := time.Date(2026, time.January, 5, 12, 0, 0, 0, time.UTC)
+	destructive := []Change{{Type: DropTable, Object: "legacy", Destructive: true}}
+	safe := []Change{{Type: CreateTable, Object: "users"}}
+
+	if err := CheckWindows(destructive, night, noon); !errors.Is(err, ErrOutsideWindow) {
+		t.Errorf("CheckWindows() = %v, want ErrOutsideWindow", err)
+	}
+	if err := CheckWindows(destructive, night, noon.Add(-9*time.Hour)); err != nil {
+		t.Errorf("CheckWindows() inside the window = %v", err)
+	}
+	if err := CheckWindows(safe, night, noon); err != nil {
+		t.Errorf("CheckWindows() with only safe changes = %v", err)
+	}
+	if err := CheckWindows(destructive, nil, noon); err != nil {
+		t.Errorf("CheckWindows() without windows = %v", err)
+	}
+}
+
+func TestApply_OutsideWindow(t *testing.T) {
+	db := openTestDB(t, `CREATE TABLE users (id INTEGER PRIMARY KEY); CREATE TABLE legacy (id INTEGER);`)
+	defer func() { _ = db.Close() }()
+	schemaDir := createSchemaDir(t, "users.sql", `CREATE TABLE users (id INTEGER PRIMARY KEY);`)
+
+	// A one-minute window that is never now
+	now := time.Now()
+	start := time.Duration((now.Hour()+12)%24) * time.Hour
+	windows := []Window{{Start: start, End: start + time.Minute}}
+
+	err := Apply(db, schemaDir, ApplyOptions{Windows: windows})
+	if !errors.Is(err, ErrOutsideWindow) {
+		t.Fatalf("Apply() = %v, want ErrOutsideWindow", err)
+	}
+	if err := Apply(db, schemaDir, ApplyOptions{Windows: windows, SkipDestructive: true}); err != nil {
+		t.Errorf("Apply() skipping destructive changes = %v", err)
+	}
+}
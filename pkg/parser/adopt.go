@@ -0,0 +1,85 @@
+package parser
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// Adopt returns the new content of every schema file in dir whose CREATE
+// statements are not stored verbatim in current, with those statements
+// replaced by the text SQLite stores. Comments between statements are kept;
+// comments inside a replaced statement are not. Use it once a database is
+// in sync with the schema, so that later diffs of the text are clean.
+// Statements for objects missing from current are left alone.
+func Adopt(dir string, current *schema.Database) (map[string]string, error) {
+	fsys, files, err := SchemaFiles(dir, Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	adopted := make(map[string]string)
+	for _, p := range files {
+		content, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", displayPath(dir, p), err)
+		}
+
+		var out strings.Builder
+		rest := string(content)
+		changed := false
+		for _, stmt := range parseStatements(rest, path.Base(p)) {
+			declared := strings.TrimSuffix(stmt.sql, ";")
+			stored, ok := storedSQL(current, declared)
+			if !ok || stored == declared {
+				continue
+			}
+			i := strings.Index(rest, declared)
+			if i < 0 {
+				continue
+			}
+			out.WriteString(rest[:i])
+			out.WriteString(stored)
+			rest = rest[i+len(declared):]
+			changed = true
+		}
+		if changed {
+			out.WriteString(rest)
+			adopted[displayPath(dir, p)] = out.String()
+		}
+	}
+	return adopted, nil
+}
+
+// storedSQL returns the SQL current stores for the object a CREATE
+// statement declares
+func storedSQL(current *schema.Database, stmt string) (string, bool) {
+	declared := schema.NewDatabase()
+	if err := parseStatementOffline(declared, stripSchemaQualifiers(stmt)); err != nil {
+		return "", false
+	}
+	for name := range declared.Tables {
+		if t, ok := current.Tables[name]; ok {
+			return t.SQL, true
+		}
+	}
+	for name := range declared.Indexes {
+		if idx, ok := current.Indexes[name]; ok {
+			return idx.SQL, true
+		}
+	}
+	for name := range declared.Views {
+		if v, ok := current.Views[name]; ok {
+			return v.SQL, true
+		}
+	}
+	for name := range declared.Triggers {
+		if trig, ok := current.Triggers[name]; ok {
+			return trig.SQL, true
+		}
+	}
+	return "", false
+}
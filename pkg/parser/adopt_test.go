@@ -0,0 +1,50 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAdopt(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"users.sql": "-- Users\ncreate table if not exists main.users (id integer primary key);\n\n-- Lookups\ncreate index idx_users on users(id);\n",
+		"posts.sql": "CREATE TABLE posts (id INTEGER PRIMARY KEY)",
+		"other.sql": "CREATE TABLE missing (id INTEGER);\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = db.Close() }()
+	if _, err := db.Exec(`
+		CREATE TABLE users (id integer primary key, email text);
+		CREATE INDEX idx_users on users(id);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY);
+	`); err != nil {
+		t.Fatal(err)
+	}
+	current, err := FromDB(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adopted, err := Adopt(dir, current)
+	if err != nil {
+		t.Fatalf("Adopt() error = %v", err)
+	}
+	if len(adopted) != 1 {
+		t.Fatalf("Adopt() rewrote %d files, want only users.sql", len(adopted))
+	}
+	want := "-- Users\nCREATE TABLE users (id integer primary key, email text);\n\n-- Lookups\nCREATE INDEX idx_users on users(id);\n"
+	if got := adopted[filepath.Join(dir, "users.sql")]; got != want {
+		t.Errorf("users.sql = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,263 @@
+package parser
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// Anonymize returns a copy of a schema with the names of tables, columns,
+// indexes, views, triggers, constraints and column aliases replaced by
+// pseudonyms, so a problematic schema can be shared without revealing what
+// it is about. Types, constraints and literals are kept, comments dropped.
+//
+// A pseudonym is a hash of the name salted with key, so a name gets the
+// same pseudonym everywhere and snapshots anonymized with the same key can
+// be diffed against each other. Without a key, common names can be guessed
+// by hashing them.
+func Anonymize(s *schema.Database, key string) (*schema.Database, error) {
+	a := anonymizer{key: key, names: map[string]string{}, tables: map[string]bool{}}
+	for _, name := range slices.Sorted(maps.Keys(s.Tables)) {
+		a.assign("t", name)
+		a.tables[strings.ToLower(name)] = true
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Views)) {
+		a.assign("v", name)
+		a.tables[strings.ToLower(name)] = true
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Indexes)) {
+		a.assign("i", name)
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Triggers)) {
+		a.assign("r", name)
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Tables)) {
+		for _, col := range s.Tables[name].Columns {
+			a.assign("c", col.Name)
+		}
+	}
+
+	var stmts []string
+	for _, name := range slices.Sorted(maps.Keys(s.Tables)) {
+		stmts = append(stmts, s.Tables[name].SQL)
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Indexes)) {
+		stmts = append(stmts, s.Indexes[name].SQL)
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Views)) {
+		stmts = append(stmts, s.Views[name].SQL)
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Triggers)) {
+		stmts = append(stmts, s.Triggers[name].SQL)
+	}
+	for _, stmt := range stmts {
+		a.collect(stmt)
+	}
+	for i, stmt := range stmts {
+		stmts[i] = a.rewrite(stmt)
+	}
+
+	db, err := Open(":memory:")
+	if err != nil {
+		return nil, fmt.Errorf("create in-memory database: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+	db.SetMaxOpenConns(1)
+
+	// Views and triggers may reference each other regardless of name order,
+	// so keep retrying failed statements while progress is being made
+	for len(stmts) > 0 {
+		var failed []string
+		var lastErr error
+		for _, stmt := range stmts {
+			if _, err := db.Exec(stmt); err != nil {
+				failed = append(failed, stmt)
+				lastErr = err
+			}
+		}
+		if len(failed) == len(stmts) {
+			return nil, fmt.Errorf("anonymize: %w", lastErr)
+		}
+		stmts = failed
+	}
+
+	out, err := extractSchema(context.Background(), db)
+	if err != nil {
+		return nil, err
+	}
+	for name, t := range s.Tables {
+		if t.AsSelect != "" {
+			out.Tables[a.names[strings.ToLower(name)]].AsSelect = a.rewrite(t.AsSelect)
+		}
+	}
+	if err := a.verify(s, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+type anonymizer struct {
+	key    string
+	names  map[string]string // Lowercase name to pseudonym
+	tables map[string]bool   // Lowercase names of tables and views
+}
+
+// assign gives a name a pseudonym with the prefix of its kind, unless it
+// already has one or is an internal sqlite_ name
+func (a *anonymizer) assign(prefix, name string) {
+	lower := strings.ToLower(name)
+	if _, ok := a.names[lower]; ok || strings.HasPrefix(lower, "sqlite_") {
+		return
+	}
+	sum := sha256.Sum256([]byte(a.key + "\x00" + lower))
+	a.names[lower] = prefix + "_" + hex.EncodeToString(sum[:4])
+}
+
+// collect assigns pseudonyms to the constraint names and column aliases of
+// a statement
+func (a *anonymizer) collect(sql string) {
+	tokens := lex(sql)
+	kw := keywords(tokens)
+	code := codeIndexes(tokens)
+	for j, i := range code {
+		t := tokens[i]
+		if !kw[i] || !t.is("CONSTRAINT", "AS") || j+1 >= len(code) {
+			continue
+		}
+		n := tokens[code[j+1]]
+		if n.kind == fmtPunct || kw[code[j+1]] || n.text[0] == '\'' || n.text[0] >= '0' && n.text[0] <= '9' {
+			continue
+		}
+		if t.is("CONSTRAINT") {
+			a.assign("k", unquoteIdent(n.text))
+			continue
+		}
+		// Aliases in select lists and FROM, not the types of CAST
+		if j+2 == len(code) || tokens[code[j+2]].kind != fmtPunct || !tokens[code[j+2]].punct(")") {
+			a.assign("a", unquoteIdent(n.text))
+		}
+	}
+}
+
+// rewrite replaces the names in a statement by their pseudonyms and drops
+// its comments. Words that are keywords, types or function names in their
+// place are kept even if a name is spelled the same.
+func (a *anonymizer) rewrite(sql string) string {
+	tokens := lex(sql)
+	kw := keywords(tokens)
+	code := codeIndexes(tokens)
+	ct := codeTokens(tokens, code)
+	table := len(ct) > 2 && ct[0].is("CREATE") && slices.ContainsFunc(ct[1:3], func(t fmtToken) bool { return t.is("TABLE") })
+
+	repl := map[int]string{}
+	depth, inType := 0, false
+	for j, i := range code {
+		t := tokens[i]
+		var prev, next fmtToken
+		if j > 0 {
+			prev = ct[j-1]
+		}
+		if j+1 < len(ct) {
+			next = ct[j+1]
+		}
+		switch {
+		case t.punct("("):
+			depth++
+		case t.punct(")"):
+			depth--
+		}
+		if t.kind == fmtPunct || kw[i] || t.text[0] == '\'' || t.text[0] >= '0' && t.text[0] <= '9' {
+			inType = false
+			continue
+		}
+
+		// In column definitions the words after the name are its type
+		if table && depth == 1 && (prev.punct("(") || prev.punct(",")) {
+			inType = true
+		} else if inType && t.kind == fmtWord {
+			continue
+		}
+
+		name := strings.ToLower(unquoteIdent(t.text))
+		pseudonym, ok := a.names[name]
+		switch {
+		case !ok:
+		case t.kind == fmtWord && next.punct("(") && !a.tables[name]:
+			// Function call or type with a size
+		case prev.is("AS") && next.punct(")"):
+			// Type of a CAST
+		case (name == "new" || name == "old") && next.punct("."):
+			// Row of a trigger
+		default:
+			repl[i] = pseudonym
+		}
+	}
+
+	var b strings.Builder
+	last := 0
+	for i, t := range tokens {
+		r, ok := repl[i]
+		if t.kind == fmtComment {
+			r, ok = "", true
+		}
+		if !ok {
+			continue
+		}
+		b.WriteString(sql[last:t.pos])
+		b.WriteString(r)
+		last = t.pos + len(t.text)
+	}
+	b.WriteString(sql[last:])
+
+	lines := strings.Split(b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// verify checks that the anonymized schema has the structure of the
+// original, so a name rewritten in the wrong place is never shared as a
+// different schema
+func (a *anonymizer) verify(s, out *schema.Database) error {
+	pseudonym := func(name string) string { return a.names[strings.ToLower(name)] }
+	if len(out.Tables) != len(s.Tables) || len(out.Indexes) != len(s.Indexes) ||
+		len(out.Views) != len(s.Views) || len(out.Triggers) != len(s.Triggers) {
+		return fmt.Errorf("anonymize: anonymized schema has different objects")
+	}
+	for name, t := range s.Tables {
+		got := out.Tables[pseudonym(name)]
+		if got == nil || len(got.Columns) != len(t.Columns) {
+			return fmt.Errorf("anonymize: table %q changed structure", name)
+		}
+		for i, c := range t.Columns {
+			g := got.Columns[i]
+			if g.Name != pseudonym(c.Name) || g.Type != c.Type || g.NotNull != c.NotNull ||
+				g.PrimaryKey != c.PrimaryKey || g.Hidden != c.Hidden || (g.Default == nil) != (c.Default == nil) {
+				return fmt.Errorf("anonymize: column %q of table %q changed", c.Name, name)
+			}
+		}
+	}
+	for name, idx := range s.Indexes {
+		if got := out.Indexes[pseudonym(name)]; got == nil || got.Table != pseudonym(idx.Table) {
+			return fmt.Errorf("anonymize: index %q changed", name)
+		}
+	}
+	for name := range s.Views {
+		if out.Views[pseudonym(name)] == nil {
+			return fmt.Errorf("anonymize: view %q changed", name)
+		}
+	}
+	for name, tr := range s.Triggers {
+		if got := out.Triggers[pseudonym(name)]; got == nil || got.Table != pseudonym(tr.Table) {
+			return fmt.Errorf("anonymize: trigger %q changed", name)
+		}
+	}
+	return nil
+}
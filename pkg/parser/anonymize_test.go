@@ -0,0 +1,129 @@
+package parser
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const anonymizeSchema = `
+	-- Customers of the shop
+	CREATE TABLE customers (
+		id INTEGER PRIMARY KEY,
+		email TEXT NOT NULL UNIQUE, -- login
+		text TEXT,
+		key VARCHAR(20) DEFAULT 'vip',
+		date TEXT DEFAULT (date('now')),
+		CONSTRAINT email_has_at CHECK (email LIKE '%@%')
+	);
+	CREATE TABLE orders (
+		id INTEGER PRIMARY KEY,
+		customer_id INTEGER NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+		total REAL CHECK (total >= 0),
+		net REAL GENERATED ALWAYS AS (total / 1.2) VIRTUAL
+	);
+	CREATE INDEX idx_orders_customer_id ON orders (customer_id);
+	CREATE VIEW big_spenders AS
+		SELECT c.email, CAST(sum(o.total) AS TEXT) AS revenue
+		FROM customers c JOIN orders o ON o.customer_id = c.id
+		GROUP BY c.email;
+	CREATE TRIGGER orders_touch AFTER UPDATE OF total ON orders
+	BEGIN
+		UPDATE customers SET date = date('now') WHERE id = NEW.customer_id;
+	END;
+`
+
+func TestAnonymize(t *testing.T) {
+	s, err := FromSQL(anonymizeSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Anonymize(s, "secret")
+	if err != nil {
+		t.Fatalf("Anonymize() error = %v", err)
+	}
+	if len(got.Tables) != 2 || len(got.Indexes) != 1 || len(got.Views) != 1 || len(got.Triggers) != 1 {
+		t.Fatalf("Anonymize() = %d tables, %d indexes, %d views, %d triggers",
+			len(got.Tables), len(got.Indexes), len(got.Views), len(got.Triggers))
+	}
+
+	var all strings.Builder
+	for _, table := range got.Tables {
+		all.WriteString(table.SQL + "\n")
+	}
+	for _, idx := range got.Indexes {
+		all.WriteString(idx.SQL + "\n")
+	}
+	for _, view := range got.Views {
+		all.WriteString(view.SQL + "\n")
+	}
+	for _, trigger := range got.Triggers {
+		all.WriteString(trigger.SQL + "\n")
+	}
+	sql := all.String()
+	for _, leak := range []string{"customer", "order", "email", "total", "revenue", "spender", "login", "shop", "touch", "email_has_at"} {
+		if strings.Contains(strings.ToLower(sql), leak) {
+			t.Errorf("anonymized schema contains %q:\n%s", leak, sql)
+		}
+	}
+	for _, kept := range []string{"PRIMARY KEY", "VARCHAR(20)", "'vip'", "date('now')", "LIKE '%@%'", "ON DELETE CASCADE", "AS TEXT", "NEW."} {
+		if !strings.Contains(sql, kept) {
+			t.Errorf("anonymized schema lost %q:\n%s", kept, sql)
+		}
+	}
+
+	// Types and constraints are unchanged
+	for _, table := range got.Tables {
+		for _, col := range table.Columns {
+			if !strings.HasPrefix(col.Name, "c_") {
+				t.Errorf("column %q of %q has no pseudonym", col.Name, table.Name)
+			}
+		}
+	}
+	var types []string
+	for _, name := range slices.Sorted(maps.Keys(s.Tables)) {
+		for _, col := range s.Tables[name].Columns {
+			types = append(types, col.Type)
+		}
+	}
+	var gotTypes []string
+	for _, name := range []string{"customers", "orders"} {
+		for _, col := range got.Tables[pseudonym("secret", "t", name)].Columns {
+			gotTypes = append(gotTypes, col.Type)
+		}
+	}
+	if !slices.Equal(gotTypes, types) {
+		t.Errorf("column types = %v, want %v", gotTypes, types)
+	}
+}
+
+func TestAnonymize_Stable(t *testing.T) {
+	s, err := FromSQL(anonymizeSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := func(key string) []string {
+		got, err := Anonymize(s, key)
+		if err != nil {
+			t.Fatalf("Anonymize() error = %v", err)
+		}
+		return slices.Sorted(maps.Keys(got.Tables))
+	}
+
+	first, second, other := names("k"), names("k"), names("other")
+	if !slices.Equal(first, second) {
+		t.Errorf("same key gave %v and %v", first, second)
+	}
+	if slices.Equal(first, other) {
+		t.Errorf("different keys both gave %v", first)
+	}
+}
+
+// pseudonym returns the pseudonym Anonymize gives a name of a kind
+func pseudonym(key, prefix, name string) string {
+	a := anonymizer{key: key, names: map[string]string{}}
+	a.assign(prefix, name)
+	return a.names[name]
+}
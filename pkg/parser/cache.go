@@ -0,0 +1,111 @@
+package parser
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// DefaultCacheSize is how many parsed schemas are kept unless SetCacheSize
+// is called
+const DefaultCacheSize = 16
+
+// cache holds the schemas most recently parsed from SQL, keyed by a hash of
+// the statements executed to build them
+var cache = &schemaCache{capacity: DefaultCacheSize}
+
+// SetCacheSize sets how many schemas parsed by FromSQL and ReadFiles are
+// kept, so that parsing identical SQL again, e.g. an embedded schema
+// compared on every start, skips building an in-memory database. Zero
+// disables the cache. Only successful parses are cached, and callers always
+// get a copy they may change.
+func SetCacheSize(n int) {
+	cache.resize(max(n, 0))
+}
+
+// cacheKey identifies the SQL a schema was parsed from. kind separates
+// SQL executed at once from SQL executed one statement at a time.
+type cacheKey [sha256.Size]byte
+
+// newCacheKey hashes the statements in execution order
+func newCacheKey(kind string, stmts ...string) cacheKey {
+	h := sha256.New()
+	h.Write([]byte(kind))
+	for _, stmt := range stmts {
+		h.Write([]byte{0})
+		h.Write([]byte(stmt))
+	}
+	var key cacheKey
+	h.Sum(key[:0])
+	return key
+}
+
+// schemaCache is a least recently used cache of parsed schemas
+type schemaCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    list.List // of *cacheEntry, most recently used first
+	entries  map[cacheKey]*list.Element
+}
+
+type cacheEntry struct {
+	key    cacheKey
+	schema *schema.Database
+}
+
+// get returns a copy of the schema cached for key
+func (c *schemaCache) get(key cacheKey) (*schema.Database, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).schema.Clone(), true
+}
+
+// put caches a copy of s for key, evicting the least recently used schema
+// if the cache is full
+func (c *schemaCache) put(key cacheKey, s *schema.Database) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.capacity == 0 {
+		return
+	}
+	if c.entries == nil {
+		c.entries = make(map[cacheKey]*list.Element)
+	}
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, schema: s.Clone()})
+	c.evict()
+}
+
+// resize changes the capacity, dropping schemas that no longer fit
+func (c *schemaCache) resize(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.capacity = n
+	c.evict()
+}
+
+// purge drops every cached schema
+func (c *schemaCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	clear(c.entries)
+}
+
+func (c *schemaCache) evict() {
+	for c.order.Len() > c.capacity {
+		e := c.order.Back()
+		delete(c.entries, e.Value.(*cacheEntry).key)
+		c.order.Remove(e)
+	}
+}
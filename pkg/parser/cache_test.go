@@ -0,0 +1,78 @@
+package parser
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestFromSQL_Cache(t *testing.T) {
+	var opens int
+	SetOpener(func(dsn string) (*sql.DB, error) {
+		opens++
+		return sql.Open("sqlite", dsn)
+	})
+	defer SetOpener(nil)
+
+	const ddl = `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT DEFAULT 'x');`
+	first, err := FromSQL(ddl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Changing a result must not change what later callers get
+	first.Tables["users"].Columns[1].Name = "changed"
+	*first.Tables["users"].Columns[1].Default = "'changed'"
+	delete(first.Tables, "users")
+
+	second, err := FromSQL(ddl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opens != 1 {
+		t.Errorf("opened %d in-memory databases, want 1", opens)
+	}
+	users := second.Tables["users"]
+	if users == nil || users.Columns[1].Name != "name" || *users.Columns[1].Default != "'x'" {
+		t.Errorf("cached schema was changed by an earlier caller: %+v", users)
+	}
+
+	SetCacheSize(0)
+	defer SetCacheSize(DefaultCacheSize)
+	if _, err := FromSQL(ddl); err != nil {
+		t.Fatal(err)
+	}
+	if opens != 2 {
+		t.Errorf("opened %d in-memory databases with the cache disabled, want 2", opens)
+	}
+}
+
+func TestFromSQL_CacheSkipsErrors(t *testing.T) {
+	for range 2 {
+		if _, err := FromSQL(`CREATE TABLE t (id INTEGER PRIMARY KEY`); err == nil {
+			t.Fatal("expected invalid SQL to fail")
+		}
+	}
+}
+
+func TestSchemaCache_Evicts(t *testing.T) {
+	c := &schemaCache{capacity: 2}
+	s, err := FromSQL(`CREATE TABLE t (id INTEGER PRIMARY KEY);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b, d := newCacheKey("sql", "a"), newCacheKey("sql", "b"), newCacheKey("sql", "d")
+	c.put(a, s)
+	c.put(b, s)
+	c.get(a) // a is now used more recently than b
+	c.put(d, s)
+
+	if _, ok := c.get(b); ok {
+		t.Error("least recently used schema was kept")
+	}
+	if _, ok := c.get(a); !ok {
+		t.Error("recently used schema was evicted")
+	}
+	c.resize(0)
+	if _, ok := c.get(d); ok {
+		t.Error("schema kept after disabling the cache")
+	}
+}
@@ -0,0 +1,43 @@
+package parser
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"modernc.org/sqlite"
+	"modernc.org/sqlite/vtab"
+)
+
+// Extension bundles Go implementations of the SQL functions, collations and
+// virtual table modules a schema relies on. The pure Go driver cannot load
+// native extensions (.so, .dylib, .dll), so these must be ported to Go.
+type Extension struct {
+	Functions  map[string]*sqlite.FunctionImpl
+	Collations map[string]func(left, right string) int
+	Modules    map[string]vtab.Module
+}
+
+// RegisterExtension makes an extension available on every connection the
+// SQLite driver opens afterwards, both the in-memory databases schema files
+// are parsed with and databases changes are applied to. Registration is
+// global to the driver and cannot be undone, so call it once at startup,
+// before opening any database.
+func RegisterExtension(ext Extension) error {
+	for _, name := range slices.Sorted(maps.Keys(ext.Functions)) {
+		if err := sqlite.RegisterFunction(name, ext.Functions[name]); err != nil {
+			return fmt.Errorf("register function %s: %w", name, err)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(ext.Collations)) {
+		if err := sqlite.RegisterCollationUtf8(name, ext.Collations[name]); err != nil {
+			return fmt.Errorf("register collation %s: %w", name, err)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(ext.Modules)) {
+		if err := vtab.RegisterModule(nil, name, ext.Modules[name]); err != nil {
+			return fmt.Errorf("register module %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package parser
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"modernc.org/sqlite"
+)
+
+func TestRegisterExtension(t *testing.T) {
+	schemaSQL := `
+		CREATE TABLE articles (
+			id INTEGER PRIMARY KEY,
+			title TEXT COLLATE ext_test_nocase,
+			slug TEXT AS (ext_test_slug(title))
+		);
+		CREATE INDEX idx_articles_slug ON articles(ext_test_slug(title));
+	`
+
+	// Executing the schema needs the function and the collation
+	if _, err := FromSQL(schemaSQL); err == nil {
+		t.Fatal("expected parsing to fail before registering the extension")
+	}
+
+	err := RegisterExtension(Extension{
+		Functions: map[string]*sqlite.FunctionImpl{
+			"ext_test_slug": {
+				NArgs:         1,
+				Deterministic: true,
+				Scalar: func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+					s, _ := args[0].(string)
+					return strings.ReplaceAll(strings.ToLower(s), " ", "-"), nil
+				},
+			},
+		},
+		Collations: map[string]func(left, right string) int{
+			"ext_test_nocase": func(left, right string) int {
+				return strings.Compare(strings.ToLower(left), strings.ToLower(right))
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("RegisterExtension() error = %v", err)
+	}
+
+	s, err := FromSQL(schemaSQL)
+	if err != nil {
+		t.Fatalf("FromSQL() error = %v", err)
+	}
+	if s.Tables["articles"] == nil || s.Indexes["idx_articles_slug"] == nil {
+		t.Errorf("expected articles table and index, got %v and %v", keys(s.Tables), keys(s.Indexes))
+	}
+
+	// Names can only be registered once
+	err = RegisterExtension(Extension{
+		Collations: map[string]func(left, right string) int{"ext_test_nocase": strings.Compare},
+	})
+	if err == nil || !strings.Contains(err.Error(), "ext_test_nocase") {
+		t.Errorf("expected duplicate registration error, got %v", err)
+	}
+}
@@ -0,0 +1,716 @@
+package parser
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// KeywordCase selects how Format spells keywords
+type KeywordCase string
+
+const (
+	KeywordUpper    KeywordCase = ""         // SELECT, the default
+	KeywordLower    KeywordCase = "lower"    // select
+	KeywordPreserve KeywordCase = "preserve" // As written
+)
+
+// FormatOptions selects the style Format produces
+type FormatOptions struct {
+	KeywordCase KeywordCase
+	// Indent indents column definitions and trigger bodies, two spaces if
+	// empty
+	Indent string
+	// LeadingCommas starts column definitions with the comma separating
+	// them from the previous one instead of ending lines with it
+	LeadingCommas bool
+}
+
+// Format pretty-prints schema SQL to a canonical style: one column
+// definition per line, one statement per trigger body line, a blank line
+// between statements and keywords in one case. Comments are kept. Names are
+// never changed, and spacing only changes where the schema diff ignores it,
+// so formatted files produce the same schema and no changes.
+func Format(sql string, opts FormatOptions) (string, error) {
+	if opts.Indent == "" {
+		opts.Indent = "  "
+	}
+	f := formatter{opts: opts}
+	tokens := lex(sql)
+	stmts, tail := groupStatements(tokens)
+
+	var b strings.Builder
+	for i, st := range stmts {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		f.comments(&b, st.leading)
+		if len(st.leading) > 0 && st.tokens[0].breaks > 1 {
+			b.WriteString("\n")
+		}
+		b.WriteString(f.statement(st.tokens))
+		b.WriteString(";")
+		for _, c := range st.trailing {
+			b.WriteString(" " + c.text)
+		}
+		b.WriteString("\n")
+	}
+	if len(tail) > 0 {
+		if len(stmts) > 0 {
+			b.WriteString("\n")
+		}
+		f.comments(&b, tail)
+	}
+
+	out := b.String()
+	if err := sameTokens(tokens, lex(out)); err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+type fmtKind int
+
+const (
+	fmtWord fmtKind = iota
+	fmtQuoted
+	fmtPunct
+	fmtComment
+)
+
+// fmtToken is a token of schema SQL that keeps the layout around it
+type fmtToken struct {
+	text   string
+	kind   fmtKind
+	space  bool // Whitespace before the token
+	breaks int  // Line breaks before the token
+	pos    int  // Offset in the lexed SQL
+}
+
+func (t fmtToken) is(words ...string) bool {
+	return t.kind == fmtWord && slices.ContainsFunc(words, func(w string) bool {
+		return strings.EqualFold(t.text, w)
+	})
+}
+
+func (t fmtToken) punct(p string) bool {
+	return t.kind == fmtPunct && t.text == p
+}
+
+func (t fmtToken) lineComment() bool {
+	return t.kind == fmtComment && strings.HasPrefix(t.text, "--")
+}
+
+// operators are the punctuation tokens longer than one character
+var operators = []string{"->>", "->", "||", "<=", ">=", "<>", "!=", "==", "<<", ">>"}
+
+// lex splits SQL into tokens, comments included
+func lex(sql string) []fmtToken {
+	var tokens []fmtToken
+	space, breaks := false, 0
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		start := i
+		kind := fmtPunct
+		switch {
+		case c <= ' ':
+			space = true
+			if c == '\n' {
+				breaks++
+			}
+			i++
+			continue
+		case strings.HasPrefix(sql[i:], "--"):
+			kind = fmtComment
+			if j := strings.IndexByte(sql[i:], '\n'); j >= 0 {
+				i += j
+			} else {
+				i = len(sql)
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			kind = fmtComment
+			i = skipComment(sql, i)
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			kind = fmtQuoted
+			i = skipQuoted(sql, i)
+		case isWordChar(c):
+			kind = fmtWord
+			number := c >= '0' && c <= '9'
+			for i < len(sql) && (isWordChar(sql[i]) || number && (sql[i] == '.' ||
+				(sql[i] == '+' || sql[i] == '-') && (sql[i-1] == 'e' || sql[i-1] == 'E'))) {
+				i++
+			}
+		default:
+			i++
+			for _, op := range operators {
+				if strings.HasPrefix(sql[start:], op) {
+					i = start + len(op)
+					break
+				}
+			}
+		}
+		text := sql[start:i]
+		if kind == fmtComment && strings.HasPrefix(text, "--") {
+			text = strings.TrimRight(text, " \t\r")
+		}
+		tokens = append(tokens, fmtToken{text: text, kind: kind, space: space, breaks: breaks, pos: start})
+		space, breaks = false, 0
+	}
+	return tokens
+}
+
+// fmtStatement is a statement with the comments around it
+type fmtStatement struct {
+	leading  []fmtToken // Comments before the statement
+	tokens   []fmtToken // The statement without its semicolon
+	trailing []fmtToken // Comments after the semicolon on the same line
+}
+
+// groupStatements groups tokens into statements the way SplitStatements
+// does, and returns the comments after the last statement
+func groupStatements(tokens []fmtToken) ([]fmtStatement, []fmtToken) {
+	var stmts []fmtStatement
+	var cur fmtStatement
+	var code []fmtToken
+	depth, trigger, afterSemicolon := 0, false, false
+
+	for _, t := range tokens {
+		switch {
+		case t.kind == fmtComment && len(code) == 0:
+			if afterSemicolon && t.breaks == 0 {
+				last := &stmts[len(stmts)-1]
+				last.trailing = append(last.trailing, t)
+				continue
+			}
+			cur.leading = append(cur.leading, t)
+		case t.punct(";") && depth == 0:
+			if len(code) > 0 {
+				stmts = append(stmts, cur)
+				cur, code = fmtStatement{}, nil
+				afterSemicolon = true
+			}
+			continue
+		default:
+			cur.tokens = append(cur.tokens, t)
+			if t.kind == fmtComment {
+				break
+			}
+			qualified := len(code) > 0 && code[len(code)-1].punct(".")
+			code = append(code, t)
+			if len(code) <= 3 {
+				trigger = isTriggerHeader(code)
+			}
+			if !trigger || qualified {
+				break
+			}
+			if t.is("BEGIN", "CASE") {
+				depth++
+			} else if t.is("END") && depth > 0 {
+				depth--
+			}
+		}
+		afterSemicolon = false
+	}
+	if len(code) > 0 {
+		stmts = append(stmts, cur)
+		cur.leading = nil
+	}
+	return stmts, cur.leading
+}
+
+// isTriggerHeader reports whether code starts a CREATE [TEMP] TRIGGER
+func isTriggerHeader(code []fmtToken) bool {
+	switch {
+	case len(code) >= 2 && code[0].is("CREATE") && code[1].is("TRIGGER"):
+		return true
+	case len(code) >= 3 && code[0].is("CREATE") && code[1].is("TEMP", "TEMPORARY") && code[2].is("TRIGGER"):
+		return true
+	}
+	return false
+}
+
+type formatter struct {
+	opts FormatOptions
+}
+
+// comments writes comments on lines of their own, keeping a blank line
+// where the source had one
+func (f formatter) comments(b *strings.Builder, comments []fmtToken) {
+	for i, c := range comments {
+		if c.breaks > 1 && i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(c.text + "\n")
+	}
+}
+
+// statement formats a statement without its semicolon
+func (f formatter) statement(tokens []fmtToken) string {
+	kw := keywords(tokens)
+	if s, ok := f.table(tokens, kw); ok {
+		return s
+	}
+	if s, ok := f.trigger(tokens, kw); ok {
+		return s
+	}
+	var b strings.Builder
+	f.inline(&b, tokens, kw, true, "")
+	return b.String()
+}
+
+// table lays out CREATE TABLE with one column definition or constraint
+// per line
+func (f formatter) table(tokens []fmtToken, kw []bool) (string, bool) {
+	code := codeIndexes(tokens)
+	if len(code) < 3 || !tokens[code[0]].is("CREATE") {
+		return "", false
+	}
+	i := 1
+	if tokens[code[i]].is("TEMP", "TEMPORARY") {
+		i++
+	}
+	if !tokens[code[i]].is("TABLE") {
+		return "", false
+	}
+	open := -1
+	for _, j := range code[i:] {
+		if tokens[j].is("AS") {
+			return "", false // CREATE TABLE ... AS SELECT
+		}
+		if tokens[j].punct("(") {
+			open = j
+			break
+		}
+	}
+	closing := matchingParen(tokens, open)
+	if open < 0 || closing < 0 {
+		return "", false
+	}
+
+	items, after := splitItems(tokens[open+1:closing], kw[open+1:closing])
+	if len(items) == 0 {
+		return "", false
+	}
+
+	var b strings.Builder
+	f.inline(&b, tokens[:open], kw[:open], false, "")
+	b.WriteString(" (\n")
+	for n, item := range items {
+		for _, c := range item.leading {
+			b.WriteString(f.opts.Indent + c.text + "\n")
+		}
+		b.WriteString(f.opts.Indent)
+		if f.opts.LeadingCommas && n > 0 {
+			b.WriteString(", ")
+		}
+		f.inline(&b, item.tokens, item.kw, true, f.opts.Indent)
+		if !f.opts.LeadingCommas && n < len(items)-1 {
+			b.WriteString(",")
+		}
+		for _, c := range item.trailing {
+			b.WriteString(" " + c.text)
+		}
+		b.WriteString("\n")
+	}
+	for _, c := range after {
+		b.WriteString(f.opts.Indent + c.text + "\n")
+	}
+	b.WriteString(")")
+	if rest := tokens[closing+1:]; len(rest) > 0 {
+		b.WriteString(" ")
+		f.inline(&b, rest, kw[closing+1:], false, "")
+	}
+	return b.String(), true
+}
+
+// fmtItem is a column definition or table constraint
+type fmtItem struct {
+	leading  []fmtToken // Comments on lines before the item
+	tokens   []fmtToken
+	kw       []bool
+	trailing []fmtToken // Comments after the item on its line
+}
+
+// splitItems splits the body of CREATE TABLE on top-level commas and
+// returns the comments on lines after the last item
+func splitItems(tokens []fmtToken, kw []bool) ([]fmtItem, []fmtToken) {
+	var items []fmtItem
+	var cur fmtItem
+	var pending []fmtToken // Comments waiting for the next item
+	depth := 0
+
+	finish := func() {
+		// Comments after the item's code on its line trail it, the ones
+		// on later lines belong to what follows
+		end := len(cur.tokens)
+		for end > 0 && cur.tokens[end-1].kind == fmtComment {
+			end--
+		}
+		k := end
+		for k < len(cur.tokens) && cur.tokens[k].breaks == 0 {
+			k++
+		}
+		cur.trailing = append(cur.trailing, cur.tokens[end:k]...)
+		pending = append(pending, cur.tokens[k:]...)
+		cur.tokens, cur.kw = cur.tokens[:end], cur.kw[:end]
+		items = append(items, cur)
+		cur = fmtItem{}
+	}
+
+	for i, t := range tokens {
+		switch {
+		case t.kind == fmtComment && len(cur.tokens) == 0:
+			if len(items) > 0 && len(pending) == 0 && t.breaks == 0 {
+				last := &items[len(items)-1]
+				last.trailing = append(last.trailing, t)
+				continue
+			}
+			pending = append(pending, t)
+			continue
+		case t.punct("(") || t.punct(")"):
+			if t.punct("(") {
+				depth++
+			} else {
+				depth--
+			}
+		case t.punct(",") && depth == 0:
+			if len(cur.tokens) == 0 {
+				return nil, nil // Empty item, leave it alone
+			}
+			finish()
+			continue
+		}
+		if len(cur.tokens) == 0 {
+			cur.leading, pending = pending, nil
+		}
+		cur.tokens = append(cur.tokens, t)
+		cur.kw = append(cur.kw, kw[i])
+	}
+	if len(cur.tokens) == 0 {
+		return nil, nil
+	}
+	finish()
+	return items, pending
+}
+
+// trigger lays out CREATE TRIGGER with one body statement per line
+func (f formatter) trigger(tokens []fmtToken, kw []bool) (string, bool) {
+	code := codeIndexes(tokens)
+	if !isTriggerHeader(codeTokens(tokens, code)) || !tokens[code[len(code)-1]].is("END") {
+		return "", false
+	}
+	begin := -1
+	for _, j := range code {
+		if tokens[j].is("BEGIN") && kw[j] {
+			begin = j
+			break
+		}
+	}
+	end := code[len(code)-1]
+	if begin < 0 {
+		return "", false
+	}
+
+	var b strings.Builder
+	f.inline(&b, tokens[:begin+1], kw[:begin+1], false, "")
+	b.WriteString("\n")
+
+	body, bodyKW := tokens[begin+1:end], kw[begin+1:end]
+	start, depth := 0, 0
+	for i := 0; i <= len(body); i++ {
+		if i < len(body) {
+			t := body[i]
+			switch {
+			case !bodyKW[i]:
+			case t.is("CASE"):
+				depth++
+			case t.is("END") && depth > 0:
+				depth--
+			}
+			if !t.punct(";") || depth > 0 {
+				continue
+			}
+		}
+		stmt, stmtKW := body[start:i], bodyKW[start:i]
+		for len(stmt) > 0 && stmt[0].kind == fmtComment {
+			b.WriteString(f.opts.Indent + stmt[0].text + "\n")
+			stmt, stmtKW = stmt[1:], stmtKW[1:]
+		}
+		if len(stmt) > 0 {
+			b.WriteString(f.opts.Indent)
+			f.inline(&b, stmt, stmtKW, true, f.opts.Indent)
+			if i < len(body) {
+				b.WriteString(";")
+			}
+			// Comments after the semicolon on its line
+			for i+1 < len(body) && body[i+1].kind == fmtComment && body[i+1].breaks == 0 {
+				i++
+				b.WriteString(" " + body[i].text)
+			}
+			b.WriteString("\n")
+		}
+		start = i + 1
+	}
+	b.WriteString(f.word(tokens[end], true))
+	return b.String(), true
+}
+
+// clauseKeywords start lines at the indentation of their statement, other
+// lines are continued one level deeper
+var clauseKeywords = []string{
+	"SELECT", "FROM", "WHERE", "GROUP", "HAVING", "ORDER", "LIMIT", "SET", "VALUES",
+	"RETURNING", "UNION", "EXCEPT", "INTERSECT", "WITH",
+}
+
+// inline writes tokens on one line, or on the lines of the source if
+// keepBreaks is set. Lines starting with a clause are indented with base,
+// other continued lines with base and one more level.
+func (f formatter) inline(b *strings.Builder, tokens []fmtToken, kw []bool, keepBreaks bool, base string) {
+	depth := 0
+	for i, t := range tokens {
+		if i > 0 {
+			prev := tokens[i-1]
+			switch {
+			case prev.lineComment() || keepBreaks && t.breaks > 0:
+				if depth == 0 && kw[i] && t.is(clauseKeywords...) {
+					b.WriteString("\n" + base)
+				} else {
+					b.WriteString("\n" + base + f.opts.Indent)
+				}
+			case t.kind == fmtComment:
+				b.WriteString(" ")
+			case t.punct(",") || t.punct(")") || prev.punct("("):
+			case prev.punct(",") || prev.punct(")") && t.kind != fmtPunct:
+				b.WriteString(" ")
+			case t.punct("(") && kw[i-1] && !prev.is("CAST", "RAISE"):
+				b.WriteString(" ")
+			case t.space:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString(f.word(t, kw[i]))
+		if t.punct("(") {
+			depth++
+		} else if t.punct(")") {
+			depth--
+		}
+	}
+}
+
+// word spells a token, applying the keyword case to keywords
+func (f formatter) word(t fmtToken, keyword bool) string {
+	if !keyword {
+		return t.text
+	}
+	switch f.opts.KeywordCase {
+	case KeywordLower:
+		return strings.ToLower(t.text)
+	case KeywordPreserve:
+		return t.text
+	default:
+		return strings.ToUpper(t.text)
+	}
+}
+
+// codeIndexes returns the indexes of the tokens that are not comments
+func codeIndexes(tokens []fmtToken) []int {
+	var code []int
+	for i, t := range tokens {
+		if t.kind != fmtComment {
+			code = append(code, i)
+		}
+	}
+	return code
+}
+
+func codeTokens(tokens []fmtToken, code []int) []fmtToken {
+	out := make([]fmtToken, len(code))
+	for i, j := range code {
+		out[i] = tokens[j]
+	}
+	return out
+}
+
+// matchingParen returns the index of the parenthesis closing the one at
+// open, or -1
+func matchingParen(tokens []fmtToken, open int) int {
+	if open < 0 {
+		return -1
+	}
+	depth := 0
+	for i := open; i < len(tokens); i++ {
+		switch {
+		case tokens[i].punct("("):
+			depth++
+		case tokens[i].punct(")"):
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// reservedKeywords can never be names unless quoted, so changing their
+// case never changes what a statement means
+var reservedKeywords = map[string]bool{
+	"ADD": true, "ALL": true, "ALTER": true, "AND": true, "AS": true, "AUTOINCREMENT": true,
+	"BETWEEN": true, "CASE": true, "CHECK": true, "COLLATE": true, "COMMIT": true,
+	"CONSTRAINT": true, "CREATE": true, "DEFAULT": true, "DEFERRABLE": true, "DELETE": true,
+	"DISTINCT": true, "DROP": true, "ELSE": true, "ESCAPE": true, "EXCEPT": true, "EXISTS": true,
+	"FOREIGN": true, "FROM": true, "GROUP": true, "HAVING": true, "IN": true, "INDEX": true,
+	"INSERT": true, "INTERSECT": true, "INTO": true, "IS": true, "ISNULL": true, "JOIN": true,
+	"LIMIT": true, "NOT": true, "NOTHING": true, "NOTNULL": true, "NULL": true, "ON": true,
+	"OR": true, "ORDER": true, "PRIMARY": true, "REFERENCES": true, "RETURNING": true,
+	"SELECT": true, "SET": true, "TABLE": true, "THEN": true, "TO": true, "TRANSACTION": true,
+	"UNION": true, "UNIQUE": true, "UPDATE": true, "USING": true, "VALUES": true, "WHEN": true,
+	"WHERE": true,
+}
+
+// keywords reports which tokens are keywords. Words SQLite also accepts as
+// names only count where the surrounding tokens make them keywords, e.g.
+// KEY after PRIMARY, so that a column named key keeps its spelling.
+func keywords(tokens []fmtToken) []bool {
+	code := codeIndexes(tokens)
+	ct := codeTokens(tokens, code)
+	trigger := isTriggerHeader(ct)
+	begin := len(ct)
+	if trigger {
+		for i, t := range ct {
+			if t.is("BEGIN") {
+				begin = i
+				break
+			}
+		}
+	}
+	at := func(i int) string {
+		if i < 0 || i >= len(ct) || ct[i].kind == fmtQuoted {
+			return ""
+		}
+		return strings.ToUpper(ct[i].text)
+	}
+
+	kw := make([]bool, len(tokens))
+	for i, t := range ct {
+		if t.kind != fmtWord || t.text[0] >= '0' && t.text[0] <= '9' {
+			continue
+		}
+		w, p1, p2, n1 := at(i), at(i-1), at(i-2), at(i+1)
+		in := func(s string, set ...string) bool { return slices.Contains(set, s) }
+		var ok bool
+		switch {
+		case p1 == ".":
+			ok = false
+		case reservedKeywords[w]:
+			ok = true
+		default:
+			switch w {
+			case "KEY":
+				ok = in(p1, "PRIMARY", "FOREIGN")
+			case "IF":
+				ok = in(p1, "TABLE", "INDEX", "VIEW", "TRIGGER") && in(n1, "NOT", "EXISTS")
+			case "TEMP", "TEMPORARY":
+				ok = p1 == "CREATE"
+			case "VIEW", "TRIGGER":
+				ok = in(p1, "CREATE", "TEMP", "TEMPORARY", "DROP")
+			case "VIRTUAL":
+				ok = in(p1, "CREATE", ")")
+			case "STORED":
+				ok = p1 == ")"
+			case "ACTION":
+				ok = p1 == "NO"
+			case "NO":
+				ok = in(p1, "DELETE", "UPDATE") && n1 == "ACTION"
+			case "CASCADE", "RESTRICT":
+				ok = in(p1, "DELETE", "UPDATE")
+			case "ROWID":
+				ok = p1 == "WITHOUT"
+			case "WITHOUT":
+				ok = in(p1, ")", ",") && n1 == "ROWID"
+			case "STRICT":
+				ok = in(p1, ")", ",") && in(n1, ",", "")
+			case "CONFLICT":
+				ok = p1 == "ON"
+			case "REPLACE", "ABORT", "FAIL", "IGNORE", "ROLLBACK":
+				ok = in(p1, "CONFLICT", "OR") || p1 == "(" && p2 == "RAISE"
+			case "GENERATED":
+				ok = n1 == "ALWAYS"
+			case "ALWAYS":
+				ok = p1 == "GENERATED"
+			case "INITIALLY":
+				ok = p1 == "DEFERRABLE"
+			case "DEFERRED", "IMMEDIATE":
+				ok = p1 == "INITIALLY"
+			case "ASC", "DESC":
+				ok = !in(p1, "(", ",", "") && in(n1, ",", ")", "")
+			case "LIKE", "GLOB", "REGEXP", "MATCH":
+				ok = !in(p1, "(", ",", "", "AS", "SELECT") && !in(n1, ",", ")", "", ".", "FROM")
+			case "CAST", "RAISE":
+				ok = n1 == "("
+			case "CURRENT_TIMESTAMP", "CURRENT_DATE", "CURRENT_TIME":
+				ok = p1 == "DEFAULT" || p1 == "(" && p2 == "DEFAULT"
+			case "BEFORE", "AFTER", "INSTEAD":
+				ok = trigger && i < begin && !in(p1, "(", ",")
+			case "OF":
+				ok = trigger && i < begin && in(p1, "INSTEAD", "UPDATE")
+			case "FOR":
+				ok = trigger && i < begin && n1 == "EACH"
+			case "EACH":
+				ok = p1 == "FOR"
+			case "ROW":
+				ok = p1 == "EACH"
+			case "BEGIN":
+				ok = trigger && i == begin
+			case "END":
+				ok = trigger && i > begin
+			case "LEFT", "RIGHT", "FULL", "INNER", "CROSS", "NATURAL", "OUTER":
+				ok = in(n1, "JOIN", "OUTER", "INNER", "LEFT", "RIGHT", "FULL", "CROSS")
+			case "BY":
+				ok = in(p1, "ORDER", "GROUP", "PARTITION")
+			case "WITH":
+				ok = p1 == "AS" || n1 == "RECURSIVE"
+			case "RECURSIVE":
+				ok = p1 == "WITH"
+			case "NULLS":
+				ok = in(n1, "FIRST", "LAST")
+			case "FIRST", "LAST":
+				ok = p1 == "NULLS"
+			case "OVER", "FILTER":
+				ok = p1 == ")" && n1 == "("
+			case "PARTITION":
+				ok = n1 == "BY"
+			case "OFFSET":
+				ok = p2 == "LIMIT"
+			case "INDEXED":
+				ok = p1 == "NOT" || n1 == "BY"
+			}
+		}
+		kw[code[i]] = ok
+	}
+	return kw
+}
+
+// sameTokens checks that formatting kept every token, comments included,
+// and the spacing the schema diff does not ignore
+func sameTokens(in, out []fmtToken) error {
+	// Format ends every statement with a semicolon and drops empty ones
+	in = slices.DeleteFunc(slices.Clone(in), func(t fmtToken) bool { return t.punct(";") })
+	out = slices.DeleteFunc(out, func(t fmtToken) bool { return t.punct(";") })
+	if len(in) != len(out) {
+		return errors.New("format: output has different tokens than the input")
+	}
+	ignored := func(t fmtToken) bool {
+		return t.kind == fmtComment || t.kind == fmtPunct && strings.Contains("(),=;", t.text)
+	}
+	for i := range in {
+		a, b := in[i], out[i]
+		if a.kind != b.kind || a.text != b.text && (a.kind != fmtWord || !strings.EqualFold(a.text, b.text)) {
+			return errors.New("format: output has different tokens than the input")
+		}
+		if i > 0 && !ignored(a) && !ignored(in[i-1]) && a.space != b.space {
+			return errors.New("format: output has different spacing than the input")
+		}
+	}
+	return nil
+}
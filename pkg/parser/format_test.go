@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		opts FormatOptions
+		want string
+	}{
+		{
+			name: "table",
+			sql:  "create table users(id integer primary key,email text not null unique , created_at datetime default current_timestamp);",
+			want: "CREATE TABLE users (\n  id integer PRIMARY KEY,\n  email text NOT NULL UNIQUE,\n  created_at datetime DEFAULT CURRENT_TIMESTAMP\n);\n",
+		},
+		{
+			name: "names that are also keywords",
+			sql:  "create table t (key text, \"end\" int, primary key(key)) without rowid, strict;",
+			want: "CREATE TABLE t (\n  key text,\n  \"end\" int,\n  PRIMARY KEY (key)\n) WITHOUT ROWID, STRICT;\n",
+		},
+		{
+			name: "comments",
+			sql:  "-- users\n\n-- more\ncreate table users (\n  id int, -- the id\n  -- own line\n  name text /* last */\n); -- done\n/* end of file */\n",
+			want: "-- users\n\n-- more\nCREATE TABLE users (\n  id int, -- the id\n  -- own line\n  name text /* last */\n); -- done\n\n/* end of file */\n",
+		},
+		{
+			name: "statements separated by one blank line",
+			sql:  "CREATE INDEX a ON t (x);\n\n\n\nCREATE INDEX b ON t (y);CREATE INDEX c ON t (z)",
+			want: "CREATE INDEX a ON t (x);\n\nCREATE INDEX b ON t (y);\n\nCREATE INDEX c ON t (z);\n",
+		},
+		{
+			name: "clauses keep their lines",
+			sql:  "create index idx on posts (published)\n  where\n      published = 1\n  and id > 0;",
+			want: "CREATE INDEX idx ON posts (published)\nWHERE\n  published = 1\n  AND id > 0;\n",
+		},
+		{
+			name: "trigger",
+			sql:  "create trigger trg after update of key on t for each row begin\nupdate t set n = case when new.end > 0 then 1 else 0 end where id = new.id; -- note\nselect raise(abort, 'no');\nend;",
+			want: "CREATE TRIGGER trg AFTER UPDATE OF key ON t FOR EACH ROW BEGIN\n  UPDATE t SET n = CASE WHEN new.end > 0 THEN 1 ELSE 0 END WHERE id = new.id; -- note\n  SELECT RAISE(ABORT, 'no');\nEND;\n",
+		},
+		{
+			name: "lower keywords and leading commas",
+			sql:  "CREATE TABLE t (a INT NOT NULL, b TEXT);",
+			opts: FormatOptions{KeywordCase: KeywordLower, Indent: "\t", LeadingCommas: true},
+			want: "create table t (\n\ta INT not null\n\t, b TEXT\n);\n",
+		},
+		{
+			name: "preserve keywords",
+			sql:  "Create Table t (a int Not Null);",
+			opts: FormatOptions{KeywordCase: KeywordPreserve},
+			want: "Create Table t (\n  a int Not Null\n);\n",
+		},
+		{
+			name: "significant spacing is kept",
+			sql:  "create view v as select a+b, a + b, data->>'$.x' from t;",
+			want: "CREATE VIEW v AS SELECT a+b, a + b, data->>'$.x' FROM t;\n",
+		},
+		{
+			name: "virtual table stays inline",
+			sql:  "create virtual table docs using fts5(title, body);",
+			want: "CREATE VIRTUAL TABLE docs USING fts5(title, body);\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Format(tt.sql, tt.opts)
+			if err != nil {
+				t.Fatalf("Format() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Format() =\n%s\nwant\n%s", got, tt.want)
+			}
+			again, err := Format(got, tt.opts)
+			if err != nil {
+				t.Fatalf("Format() of formatted SQL error = %v", err)
+			}
+			if again != got {
+				t.Errorf("Format() is not idempotent:\n%s", again)
+			}
+		})
+	}
+}
+
+func TestFormat_SameSchema(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "..", "examples", "schema", "*.sql"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no example schema: %v", err)
+	}
+	var sql, formatted string
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := Format(string(data), FormatOptions{KeywordCase: KeywordLower, LeadingCommas: true})
+		if err != nil {
+			t.Fatalf("Format(%s) error = %v", file, err)
+		}
+		sql += string(data) + "\n"
+		formatted += out
+	}
+
+	before, err := FromSQL(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := FromSQL(formatted)
+	if err != nil {
+		t.Fatalf("FromSQL(formatted) error = %v\n%s", err, formatted)
+	}
+	for name, table := range before.Tables {
+		if got := after.Tables[name]; got == nil || len(got.Columns) != len(table.Columns) {
+			t.Errorf("formatted table %s = %+v, want %d columns", name, got, len(table.Columns))
+		}
+	}
+	if len(after.Indexes) != len(before.Indexes) || len(after.Triggers) != len(before.Triggers) {
+		t.Errorf("formatted schema has %d indexes, %d triggers, want %d, %d",
+			len(after.Indexes), len(after.Triggers), len(before.Indexes), len(before.Triggers))
+	}
+}
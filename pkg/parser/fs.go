@@ -0,0 +1,143 @@
+package parser
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// SchemaFS returns the filesystem the files in dir are read from and the
+// path of dir inside it. Without SetBaseFS, dir is opened with os.DirFS and
+// the path is "."; with it, dir is turned into an io/fs path, so that
+// "schema", "./schema/" and `schema\tables` work against an embed.FS on
+// every platform.
+func SchemaFS(dir string) (fs.FS, string, error) {
+	if baseFS == nil {
+		if dir == "" {
+			dir = "."
+		}
+		return os.DirFS(dir), ".", nil
+	}
+
+	root := strings.ReplaceAll(dir, `\`, "/")
+	root = path.Clean(strings.TrimLeft(root, "/"))
+	if !fs.ValidPath(root) {
+		return nil, "", fmt.Errorf("invalid schema directory %q: must be inside the base filesystem", dir)
+	}
+	return baseFS, root, nil
+}
+
+// SchemaFiles returns the filesystem of dir, as SchemaFS, and the .sql
+// files below it that ReadFilesWithOptions reads with opts
+func SchemaFiles(dir string, opts Options) (fs.FS, []string, error) {
+	fsys, root, err := SchemaFS(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := fromFS(fsys, root, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fsys, files, nil
+}
+
+// fromFS lists all .sql files below root in fsys, in io/fs path order so
+// that files are read in the same order on every platform. Reserved
+// subdirectories directly below root are skipped, as are hidden files,
+// symlinks and files below opts.MaxDepth unless opts allow them.
+func fromFS(fsys fs.FS, root string, opts Options) ([]string, error) {
+	var files []string
+	// Directories already walked, so that symlinks cannot loop or read the
+	// same files twice
+	var walked []fs.FileInfo
+	seen := func(info fs.FileInfo) bool {
+		if slices.ContainsFunc(walked, func(w fs.FileInfo) bool { return os.SameFile(w, info) }) {
+			return true
+		}
+		walked = append(walked, info)
+		return false
+	}
+
+	var walk func(dir string, depth int) error
+	walk = func(dir string, depth int) error {
+		return fs.WalkDir(fsys, dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if p == dir {
+				if info, err := fs.Stat(fsys, p); err == nil {
+					seen(info)
+				}
+				return nil
+			}
+
+			rel := p
+			if dir != "." {
+				rel = strings.TrimPrefix(p, dir+"/")
+			}
+			level := depth + strings.Count(rel, "/") + 1
+			isDir := d.IsDir()
+			switch {
+			case !opts.IncludeHidden && strings.HasPrefix(d.Name(), "."):
+				return skipEntry(isDir)
+			case path.Dir(p) == root && isReservedDir(d.Name()):
+				return skipEntry(isDir)
+			case d.Type()&fs.ModeSymlink != 0:
+				if !opts.FollowSymlinks {
+					return nil
+				}
+				info, err := fs.Stat(fsys, p)
+				if err != nil {
+					return fmt.Errorf("follow %s: %w", p, err)
+				}
+				if !info.IsDir() {
+					break
+				}
+				if (opts.MaxDepth > 0 && level >= opts.MaxDepth) || seen(info) {
+					return nil
+				}
+				return walk(p, level)
+			case isDir:
+				if opts.MaxDepth > 0 && level >= opts.MaxDepth {
+					return fs.SkipDir
+				}
+				if info, err := d.Info(); err == nil && seen(info) {
+					return fs.SkipDir
+				}
+				return nil
+			}
+
+			if strings.HasSuffix(strings.ToLower(p), ".sql") {
+				files = append(files, p)
+			}
+			return nil
+		})
+	}
+	if err := walk(root, 0); err != nil {
+		return nil, err
+	}
+
+	slices.Sort(files)
+	return files, nil
+}
+
+// skipEntry skips a directory or a file during fs.WalkDir
+func skipEntry(isDir bool) error {
+	if isDir {
+		return fs.SkipDir
+	}
+	return nil
+}
+
+// displayPath returns the path of file p from SchemaFS(dir) for messages:
+// the OS path when reading from disk, the io/fs path otherwise
+func displayPath(dir, p string) string {
+	if baseFS != nil {
+		return p
+	}
+	return filepath.Join(dir, filepath.FromSlash(p))
+}
@@ -0,0 +1,146 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSchemaFS_BaseFS(t *testing.T) {
+	SetBaseFS(fstest.MapFS{})
+	defer SetBaseFS(nil)
+
+	tests := []struct {
+		dir      string
+		wantRoot string
+		wantErr  bool
+	}{
+		{"schema", "schema", false},
+		{"./schema/", "schema", false},
+		{"/schema", "schema", false},
+		{`schema\tables`, "schema/tables", false},
+		{`.\schema\tables\`, "schema/tables", false},
+		{"", ".", false},
+		{".", ".", false},
+		{"../schema", "", true},
+		{`..\schema`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			_, root, err := SchemaFS(tt.dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SchemaFS(%q) error = %v, wantErr %v", tt.dir, err, tt.wantErr)
+			}
+			if root != tt.wantRoot {
+				t.Errorf("SchemaFS(%q) root = %q, want %q", tt.dir, root, tt.wantRoot)
+			}
+		})
+	}
+}
+
+func TestReadFiles_BaseFSWindowsPath(t *testing.T) {
+	SetBaseFS(fstest.MapFS{
+		"db/schema/users.sql":       {Data: []byte("CREATE TABLE users (id INTEGER PRIMARY KEY);")},
+		"db/schema/views/names.sql": {Data: []byte("CREATE VIEW names AS SELECT id FROM users;")},
+	})
+	defer SetBaseFS(nil)
+
+	for _, dir := range []string{"db/schema", `db\schema`, `.\db\schema\`} {
+		s, err := ReadFiles(dir)
+		if err != nil {
+			t.Fatalf("ReadFiles(%q) error = %v", dir, err)
+		}
+		if s.Tables["users"] == nil || s.Views["names"] == nil {
+			t.Errorf("ReadFiles(%q) = %d tables, %d views, want users and names", dir, len(s.Tables), len(s.Views))
+		}
+	}
+}
+
+func TestFromFS_OrderIsPlatformIndependent(t *testing.T) {
+	// '/' sorts before '0' but '\' after it, so ordering OS paths would
+	// read these files in a different order on Windows
+	dir := t.TempDir()
+	for _, name := range []string{filepath.Join("a", "x.sql"), "a0.sql", "A.sql"} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fsys, root, err := SchemaFS(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := fromFS(fsys, root, Options{})
+	if err != nil {
+		t.Fatalf("fromFS() error = %v", err)
+	}
+	want := []string{"A.sql", "a/x.sql", "a0.sql"}
+	if !slices.Equal(files, want) {
+		t.Errorf("fromFS() = %v, want %v", files, want)
+	}
+	if got, want := displayPath(dir, "a/x.sql"), filepath.Join(dir, "a", "x.sql"); got != want {
+		t.Errorf("displayPath() = %q, want %q", got, want)
+	}
+}
+
+func TestFromFS_WalkOptions(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "schema")
+	for _, name := range []string{
+		"users.sql",
+		".hidden.sql",
+		filepath.Join(".git", "x.sql"),
+		filepath.Join("nested", "n.sql"),
+		filepath.Join("nested", "deep", "d.sql"),
+		filepath.Join("..", "vendor", "v.sql"),
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"vendored":     filepath.Join(base, "vendor"),
+		"linked.sql":   filepath.Join(base, "vendor", "v.sql"),
+		"nested/loop":  dir,
+		"nested/again": filepath.Join(dir, "nested"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{"defaults", Options{}, []string{"nested/deep/d.sql", "nested/n.sql", "users.sql"}},
+		{"include hidden", Options{IncludeHidden: true}, []string{".git/x.sql", ".hidden.sql", "nested/deep/d.sql", "nested/n.sql", "users.sql"}},
+		{"max depth 1", Options{MaxDepth: 1}, []string{"users.sql"}},
+		{"max depth 2", Options{MaxDepth: 2}, []string{"nested/n.sql", "users.sql"}},
+		{"follow symlinks", Options{FollowSymlinks: true}, []string{"linked.sql", "nested/deep/d.sql", "nested/n.sql", "users.sql", "vendored/v.sql"}},
+		{"follow symlinks max depth 1", Options{FollowSymlinks: true, MaxDepth: 1}, []string{"linked.sql", "users.sql"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, files, err := SchemaFiles(dir, tt.opts)
+			if err != nil {
+				t.Fatalf("SchemaFiles() error = %v", err)
+			}
+			if !slices.Equal(files, tt.want) {
+				t.Errorf("SchemaFiles() = %v, want %v", files, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// Location is where a schema file creates an object
+type Location struct {
+	File string `json:"file"` // Path of the file, as in error messages
+	Line int    `json:"line"` // 1-based line the CREATE statement starts on
+}
+
+func (l Location) String() string {
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+// Locations returns where the schema files in dir create each object, keyed
+// by object name. The files are only split into statements, not executed,
+// so it also works for schemas that do not parse. If an object is created
+// more than once, the first statement in file order wins.
+func Locations(dir string, opts Options) (map[string]Location, error) {
+	fsys, files, err := SchemaFiles(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	locs := make(map[string]Location)
+	for _, p := range files {
+		content, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", displayPath(dir, p), err)
+		}
+		// Stripping schema qualifiers keeps line breaks, so lines still match
+		text := stripSchemaQualifiers(string(content))
+		for _, stmt := range splitStatements(text) {
+			name, ok := createdObject(stmt.sql)
+			if !ok {
+				continue
+			}
+			if _, exists := locs[name]; !exists {
+				locs[name] = Location{File: displayPath(dir, p), Line: 1 + strings.Count(text[:stmt.start], "\n")}
+			}
+		}
+	}
+	return locs, nil
+}
+
+// createdObject returns the unquoted name of the object a CREATE statement
+// creates, skipping temporary objects
+func createdObject(sql string) (string, bool) {
+	tokens := tokenize(sql)
+	if len(tokens) < 3 || !tokens[0].is("CREATE") || tokens[1].is("TEMP", "TEMPORARY") {
+		return "", false
+	}
+	i := 1
+	for i < len(tokens) && tokens[i].is("UNIQUE", "VIRTUAL") {
+		i++
+	}
+	if i >= len(tokens) || !tokens[i].is("TABLE", "INDEX", "VIEW", "TRIGGER") {
+		return "", false
+	}
+	i++
+	if i+2 < len(tokens) && tokens[i].is("IF") && tokens[i+1].is("NOT") && tokens[i+2].is("EXISTS") {
+		i += 3
+	}
+	if i >= len(tokens) {
+		return "", false
+	}
+	return unquoteIdent(tokens[i].text), true
+}
@@ -0,0 +1,51 @@
+package parser
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocations(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"users.sql": `-- Users and their roles
+CREATE TABLE main.users (
+	id INTEGER PRIMARY KEY,
+	name TEXT
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_users_name" ON users(name);
+CREATE TEMP TABLE scratch (id INTEGER);
+INSERT INTO users (name) VALUES ('a;b');
+CREATE TRIGGER touch AFTER UPDATE ON users BEGIN
+	UPDATE users SET name = name WHERE id = new.id;
+END;`,
+		"views/names.sql": "\n\nCREATE VIEW [names] AS SELECT name FROM users",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := Locations(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := filepath.Join(dir, "users.sql")
+	want := map[string]Location{
+		"users":          {File: users, Line: 2},
+		"idx_users_name": {File: users, Line: 7},
+		"touch":          {File: users, Line: 10},
+		"names":          {File: filepath.Join(dir, "views", "names.sql"), Line: 3},
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("Locations() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,389 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+// token is a lexical token of an SQL statement. Parenthesized groups are
+// kept as a single token.
+type token struct {
+	text       string
+	start, end int // Byte offsets into the statement
+}
+
+// is reports whether the token is one of the keywords, ignoring case
+func (t token) is(keywords ...string) bool {
+	return slices.ContainsFunc(keywords, func(k string) bool {
+		return strings.EqualFold(t.text, k)
+	})
+}
+
+// inner returns the content of a parenthesized group token
+func (t token) inner() string {
+	if len(t.text) >= 2 && t.text[0] == '(' {
+		return t.text[1 : len(t.text)-1]
+	}
+	return t.text
+}
+
+// tokenize splits SQL into tokens, skipping whitespace and comments
+func tokenize(sql string) []token {
+	var tokens []token
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		start := i
+		switch {
+		case c <= ' ':
+			i++
+			continue
+		case strings.HasPrefix(sql[i:], "--"):
+			if j := strings.IndexByte(sql[i:], '\n'); j >= 0 {
+				i += j + 1
+			} else {
+				i = len(sql)
+			}
+			continue
+		case strings.HasPrefix(sql[i:], "/*"):
+			if j := strings.Index(sql[i+2:], "*/"); j >= 0 {
+				i += j + 4
+			} else {
+				i = len(sql)
+			}
+			continue
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			i = skipQuoted(sql, i)
+		case c == '(':
+			depth := 0
+			for i < len(sql) {
+				switch {
+				case sql[i] == '(':
+					depth++
+					i++
+				case sql[i] == ')':
+					depth--
+					i++
+				case sql[i] == '\'' || sql[i] == '"' || sql[i] == '`' || sql[i] == '[':
+					i = skipQuoted(sql, i)
+				case strings.HasPrefix(sql[i:], "--") || strings.HasPrefix(sql[i:], "/*"):
+					i = skipComment(sql, i)
+				default:
+					i++
+				}
+				if depth == 0 {
+					break
+				}
+			}
+		case isWordChar(c):
+			number := c >= '0' && c <= '9'
+			for i < len(sql) && (isWordChar(sql[i]) || number && (sql[i] == '.' ||
+				(sql[i] == '+' || sql[i] == '-') && (sql[i-1] == 'e' || sql[i-1] == 'E'))) {
+				i++
+			}
+		default:
+			i++
+		}
+		tokens = append(tokens, token{text: sql[start:i], start: start, end: i})
+	}
+	return tokens
+}
+
+// skipQuoted returns the offset after the quoted string or identifier at i
+func skipQuoted(sql string, i int) int {
+	closing := sql[i]
+	if closing == '[' {
+		closing = ']'
+	}
+	for i++; i < len(sql); i++ {
+		if sql[i] == closing {
+			if closing != ']' && i+1 < len(sql) && sql[i+1] == closing {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+// skipComment returns the offset after the comment at i
+func skipComment(sql string, i int) int {
+	end := "*/"
+	if strings.HasPrefix(sql[i:], "--") {
+		end = "\n"
+	}
+	if j := strings.Index(sql[i+2:], end); j >= 0 {
+		return i + 2 + j + len(end)
+	}
+	return len(sql)
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 ||
+		c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// splitTokens splits tokens on top-level commas
+func splitTokens(tokens []token) [][]token {
+	var parts [][]token
+	var current []token
+	for _, t := range tokens {
+		if t.text == "," {
+			parts = append(parts, current)
+			current = nil
+			continue
+		}
+		current = append(current, t)
+	}
+	return append(parts, current)
+}
+
+// parseOffline builds a schema from DDL statements without executing them.
+// SQL is stored the way SQLite stores it in sqlite_schema, so that the
+// result compares equal to an extracted schema.
+func parseOffline(stmts []sqlStatement) (*schema.Database, error) {
+	s := schema.NewDatabase()
+	var errs []error
+	failed := make(map[string]bool)
+	for _, stmt := range stmts {
+		if failed[stmt.fileName] {
+			continue
+		}
+		if err := parseStatementOffline(s, stmt.sql); err != nil {
+			if stmt.fileName == "" {
+				return nil, err
+			}
+			// Report the first failure of every file, not just of the first one
+			errs = append(errs, fmt.Errorf("parse %s: %w", stmt.fileName, err))
+			failed[stmt.fileName] = true
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func parseStatementOffline(s *schema.Database, sql string) error {
+	tokens := tokenize(sql)
+	if n := len(tokens); n > 0 && tokens[n-1].text == ";" {
+		tokens = tokens[:n-1]
+	}
+	if len(tokens) < 3 || !tokens[0].is("CREATE") {
+		return fmt.Errorf("offline parsing only supports CREATE statements: %s", firstLine(sql))
+	}
+
+	i := 1
+	temp := tokens[i].is("TEMP", "TEMPORARY")
+	if temp {
+		i++
+	}
+	unique := tokens[i].is("UNIQUE")
+	if unique {
+		i++
+	}
+	virtual := tokens[i].is("VIRTUAL")
+	if virtual {
+		i++
+	}
+	if i+1 >= len(tokens) {
+		return fmt.Errorf("incomplete statement: %s", firstLine(sql))
+	}
+	kind := strings.ToUpper(tokens[i].text)
+	i++
+	if i+2 < len(tokens) && tokens[i].is("IF") && tokens[i+1].is("NOT") && tokens[i+2].is("EXISTS") {
+		i += 3
+	}
+	if i >= len(tokens) {
+		return fmt.Errorf("incomplete statement: %s", firstLine(sql))
+	}
+	nameTok := tokens[i]
+	name := unquoteIdent(nameTok.text)
+	rest := tokens[i+1:]
+
+	// Temporary objects are not part of the main schema
+	if temp || strings.HasPrefix(strings.ToLower(name), "sqlite_") {
+		return nil
+	}
+
+	// SQLite stores the statement from the object name on, behind a
+	// canonical prefix (dropping TEMP and IF NOT EXISTS)
+	body := sql[nameTok.start:tokens[len(tokens)-1].end]
+
+	switch kind {
+	case "TABLE":
+		if _, exists := s.Tables[name]; exists {
+			return fmt.Errorf("table %s already exists", name)
+		}
+		var table *schema.Table
+		var err error
+		if virtual {
+			table, err = parseVirtualTable(name, rest)
+			if table != nil {
+				table.SQL = "CREATE VIRTUAL TABLE " + body
+			}
+		} else {
+			table, err = parseTable(name, rest)
+			if table != nil {
+				table.SQL = "CREATE TABLE " + body
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if err := annotateColumns(table); err != nil {
+			return err
+		}
+		s.Tables[name] = table
+	case "INDEX":
+		on := slices.IndexFunc(rest, func(t token) bool { return t.is("ON") })
+		if on < 0 || on+1 >= len(rest) {
+			return fmt.Errorf("index %s: missing ON clause", name)
+		}
+		prefix := "CREATE INDEX "
+		if unique {
+			prefix = "CREATE UNIQUE INDEX "
+		}
+		s.Indexes[name] = &schema.Index{Name: name, Table: unquoteIdent(rest[on+1].text), SQL: prefix + body}
+	case "VIEW":
+		s.Views[name] = &schema.View{Name: name, SQL: "CREATE VIEW " + body}
+	case "TRIGGER":
+		on := slices.IndexFunc(rest, func(t token) bool { return t.is("ON") })
+		if on < 0 || on+1 >= len(rest) {
+			return fmt.Errorf("trigger %s: missing ON clause", name)
+		}
+		s.Triggers[name] = &schema.Trigger{Name: name, Table: unquoteIdent(rest[on+1].text), SQL: "CREATE TRIGGER " + body}
+	default:
+		return fmt.Errorf("offline parsing does not support CREATE %s", kind)
+	}
+	return nil
+}
+
+// parseTable reads the columns of a CREATE TABLE statement from the tokens
+// after the table name
+func parseTable(name string, tokens []token) (*schema.Table, error) {
+	if len(tokens) == 0 || tokens[0].is("AS") {
+		return nil, fmt.Errorf("table %s: CREATE TABLE ... AS SELECT cannot be parsed offline", name)
+	}
+	if !strings.HasPrefix(tokens[0].text, "(") {
+		return nil, fmt.Errorf("table %s: missing column definitions", name)
+	}
+
+	table := &schema.Table{Name: name}
+	var pkColumns []string
+	defs := tokens[0].inner()
+	for _, def := range splitTokens(tokenize(defs)) {
+		if len(def) == 0 {
+			return nil, fmt.Errorf("table %s: empty definition", name)
+		}
+		if def[0].is("CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN") {
+			if i := slices.IndexFunc(def, func(t token) bool { return t.is("PRIMARY") }); i >= 0 && i+2 < len(def) {
+				for _, part := range splitTokens(tokenize(def[i+2].inner())) {
+					if len(part) > 0 {
+						pkColumns = append(pkColumns, unquoteIdent(part[0].text))
+					}
+				}
+			}
+			continue
+		}
+		table.Columns = append(table.Columns, parseColumn(defs, def))
+	}
+
+	for pos, pk := range pkColumns {
+		for i := range table.Columns {
+			if strings.EqualFold(table.Columns[i].Name, pk) {
+				table.Columns[i].PrimaryKey = pos + 1
+			}
+		}
+	}
+
+	// Primary key columns of WITHOUT ROWID tables are implicitly NOT NULL
+	options := tokens[1:]
+	for i := range options {
+		if options[i].is("WITHOUT") && i+1 < len(options) && options[i+1].is("ROWID") {
+			for j := range table.Columns {
+				if table.Columns[j].PrimaryKey > 0 {
+					table.Columns[j].NotNull = true
+				}
+			}
+		}
+	}
+	return table, nil
+}
+
+// columnConstraintKeywords start the constraints that follow a column type
+var columnConstraintKeywords = []string{
+	"CONSTRAINT", "PRIMARY", "NOT", "NULL", "UNIQUE", "CHECK", "DEFAULT",
+	"COLLATE", "REFERENCES", "GENERATED", "AS",
+}
+
+// parseColumn reads a column definition (tokens of src) the way PRAGMA
+// table_xinfo reports it
+func parseColumn(src string, def []token) schema.Column {
+	col := schema.Column{Name: unquoteIdent(def[0].text)}
+
+	i := 1
+	for i < len(def) && !def[i].is(columnConstraintKeywords...) {
+		i++
+	}
+	if i > 1 {
+		col.Type = src[def[1].start:def[i-1].end]
+	}
+
+	for ; i < len(def); i++ {
+		switch {
+		case def[i].is("PRIMARY"):
+			col.PrimaryKey = 1
+		case def[i].is("NOT") && i+1 < len(def) && def[i+1].is("NULL"):
+			col.NotNull = true
+			i++
+		case def[i].is("DEFAULT") && i+1 < len(def):
+			i++
+			value := def[i].text
+			if strings.HasPrefix(value, "(") {
+				value = strings.TrimSpace(def[i].inner())
+			} else if (value == "-" || value == "+") && i+1 < len(def) {
+				i++
+				value += def[i].text
+			}
+			col.Default = &value
+		case def[i].is("AS"):
+			col.Hidden = 2
+			if i+2 < len(def) && def[i+2].is("STORED") {
+				col.Hidden = 3
+			}
+		}
+	}
+	return col
+}
+
+// parseVirtualTable reads the columns of a virtual table from its module
+// arguments. Arguments of the form key=value are module options. Hidden
+// columns a module adds on its own are not reported.
+func parseVirtualTable(name string, tokens []token) (*schema.Table, error) {
+	if len(tokens) < 2 || !tokens[0].is("USING") {
+		return nil, fmt.Errorf("virtual table %s: missing USING clause", name)
+	}
+
+	table := &schema.Table{Name: name}
+	if len(tokens) < 3 || !strings.HasPrefix(tokens[2].text, "(") {
+		return table, nil
+	}
+	for _, arg := range splitTokens(tokenize(tokens[2].inner())) {
+		if len(arg) == 0 || slices.ContainsFunc(arg, func(t token) bool { return t.text == "=" }) {
+			continue
+		}
+		table.Columns = append(table.Columns, schema.Column{Name: unquoteIdent(arg[0].text)})
+	}
+	return table, nil
+}
+
+// firstLine returns the first line of a statement for error messages
+func firstLine(sql string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(sql), "\n")
+	return line
+}
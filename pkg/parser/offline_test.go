@@ -0,0 +1,211 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
+)
+
+func TestFromSQLOffline_MatchesExecution(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+	}{
+		{
+			name: "columns and constraints",
+			sql: `CREATE TABLE users (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				email VARCHAR(255) NOT NULL UNIQUE COLLATE NOCASE,
+				score DOUBLE   PRECISION DEFAULT -1.5,
+				price DECIMAL(10, 2) DEFAULT 0,
+				created_at TEXT DEFAULT CURRENT_TIMESTAMP,
+				status TEXT DEFAULT 'active' CHECK (status IN ('active', 'banned')),
+				total INTEGER DEFAULT (1 + 1),
+				untyped,
+				"quoted ""name""" TEXT,
+				parent_id INTEGER REFERENCES users(id) ON DELETE CASCADE
+			);`,
+		},
+		{
+			name: "table constraints",
+			sql: `CREATE TABLE IF NOT EXISTS memberships (
+				user_id INTEGER NOT NULL,
+				group_id INTEGER,
+				role TEXT,
+				CONSTRAINT pk_memberships PRIMARY KEY (group_id, user_id DESC),
+				FOREIGN KEY (user_id) REFERENCES users(id),
+				UNIQUE (user_id, role)
+			) WITHOUT ROWID;`,
+		},
+		{
+			name: "generated columns and strict",
+			sql: `CREATE TABLE items (
+				id INTEGER PRIMARY KEY,
+				a INTEGER,
+				b INTEGER GENERATED ALWAYS AS (a * 2) STORED,
+				c INTEGER AS (a + 1),
+				data TEXT,
+				kind TEXT AS (data ->> '$.kind') VIRTUAL
+			) STRICT;`,
+		},
+		{
+			name: "indexes views and triggers",
+			sql: `CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT, deleted INTEGER);
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_t_name ON t (name) WHERE deleted = 0;
+				CREATE INDEX "idx t lower" ON t(lower(name)); -- trailing comment
+				CREATE VIEW IF NOT EXISTS live AS SELECT id, name FROM t WHERE deleted = 0;
+				CREATE TRIGGER IF NOT EXISTS t_soft_delete BEFORE DELETE ON t
+				BEGIN
+					UPDATE t SET deleted = 1 WHERE id = old.id;
+					SELECT RAISE(IGNORE);
+				END;`,
+		},
+		{
+			name: "annotations and comments",
+			sql: `CREATE TABLE people (
+				id INTEGER PRIMARY KEY, -- surrogate key
+				/* display name */ name TEXT NOT NULL,
+				-- @backfill: 'unknown'
+				nickname TEXT NOT NULL DEFAULT ''
+			);`,
+		},
+		{
+			name: "schema qualified",
+			sql:  `CREATE TABLE main.accounts (id INTEGER PRIMARY KEY, name TEXT);`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := FromSQL(tt.sql)
+			if err != nil {
+				t.Fatalf("FromSQL() error = %v", err)
+			}
+			got, err := FromSQLWithOptions(tt.sql, Options{Offline: true})
+			if err != nil {
+				t.Fatalf("FromSQLWithOptions() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("offline schema differs from executed schema")
+				compareSchemas(t, got, want)
+			}
+		})
+	}
+}
+
+func compareSchemas(t *testing.T, got, want *schema.Database) {
+	t.Helper()
+	for name, w := range want.Tables {
+		g := got.Tables[name]
+		if g == nil {
+			t.Errorf("table %s missing", name)
+			continue
+		}
+		if g.SQL != w.SQL {
+			t.Errorf("table %s SQL:\n got %q\nwant %q", name, g.SQL, w.SQL)
+		}
+		if !reflect.DeepEqual(g.Columns, w.Columns) {
+			t.Errorf("table %s columns:\n got %+v\nwant %+v", name, g.Columns, w.Columns)
+		}
+	}
+	for name, w := range want.Indexes {
+		if g := got.Indexes[name]; g == nil || *g != *w {
+			t.Errorf("index %s:\n got %+v\nwant %+v", name, g, w)
+		}
+	}
+	for name, w := range want.Views {
+		if g := got.Views[name]; g == nil || *g != *w {
+			t.Errorf("view %s:\n got %+v\nwant %+v", name, g, w)
+		}
+	}
+	for name, w := range want.Triggers {
+		if g := got.Triggers[name]; g == nil || *g != *w {
+			t.Errorf("trigger %s:\n got %+v\nwant %+v", name, g, w)
+		}
+	}
+}
+
+func TestFromSQLOffline_UnknownModules(t *testing.T) {
+	// Executing fails for modules the bundled SQLite lacks
+	sql := `CREATE VIRTUAL TABLE docs USING fts4(title, body, tokenize=icu);
+		CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT COLLATE icu_und);`
+	if _, err := FromSQL(sql); err == nil {
+		t.Fatal("expected executing the schema to fail")
+	}
+
+	s, err := FromSQLWithOptions(sql, Options{Offline: true})
+	if err != nil {
+		t.Fatalf("FromSQLWithOptions() error = %v", err)
+	}
+	docs := s.Tables["docs"]
+	if docs == nil || !reflect.DeepEqual(docs.ColumnNames(), []string{"title", "body"}) {
+		t.Errorf("docs = %+v, want columns title and body", docs)
+	}
+	if docs.SQL != "CREATE VIRTUAL TABLE docs USING fts4(title, body, tokenize=icu)" {
+		t.Errorf("docs SQL = %q", docs.SQL)
+	}
+	if s.Tables["notes"] == nil {
+		t.Error("notes table missing")
+	}
+}
+
+func TestFromSQLOffline_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{
+			name: "create table as select",
+			sql:  `CREATE TABLE t AS SELECT 1 AS x;`,
+			want: "AS SELECT",
+		},
+		{
+			name: "alter table",
+			sql:  `CREATE TABLE t (id INTEGER); ALTER TABLE t ADD COLUMN name TEXT;`,
+			want: "only supports CREATE",
+		},
+		{
+			name: "duplicate table",
+			sql:  `CREATE TABLE t (id INTEGER); CREATE TABLE t (id INTEGER);`,
+			want: "already exists",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := FromSQLWithOptions(tt.sql, Options{Offline: true})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadFilesOffline(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"01_tables.sql": `CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);`,
+		"02_search.sql": `CREATE VIRTUAL TABLE user_search USING fts4(name, tokenize=icu);`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := ReadFiles(dir); err == nil {
+		t.Fatal("expected executing the schema to fail")
+	}
+	s, err := ReadFilesWithOptions(dir, Options{Offline: true})
+	if err != nil {
+		t.Fatalf("ReadFilesWithOptions() error = %v", err)
+	}
+	if len(s.Tables) != 2 {
+		t.Errorf("expected 2 tables, got %d", len(s.Tables))
+	}
+}
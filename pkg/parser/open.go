@@ -0,0 +1,28 @@
+package parser
+
+import "database/sql"
+
+var opener func(dsn string) (*sql.DB, error)
+
+// SetOpener sets how the library opens databases of its own: the in-memory
+// databases schema files are parsed and plans are verified on, read-only
+// inspection and backup checks. DSNs are file names, ":memory:" or "file:"
+// URIs as understood by the bundled modernc.org/sqlite driver.
+//
+// Applications that set up connections in their own driver, e.g. to register
+// collations or functions in a connect hook, pass the same setup here so that
+// schemas relying on it can be parsed and verified. Pass nil to revert to the
+// bundled driver. Schemas cached from earlier parses are dropped.
+func SetOpener(fn func(dsn string) (*sql.DB, error)) {
+	opener = fn
+	cache.purge()
+}
+
+// Open opens a database with the function set by SetOpener, or the bundled
+// driver if none was set
+func Open(dsn string) (*sql.DB, error) {
+	if opener != nil {
+		return opener(dsn)
+	}
+	return sql.Open("sqlite", dsn)
+}
@@ -0,0 +1,30 @@
+package parser
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestSetOpener(t *testing.T) {
+	var dsns []string
+	SetOpener(func(dsn string) (*sql.DB, error) {
+		dsns = append(dsns, dsn)
+		return sql.Open("sqlite", dsn)
+	})
+	defer SetOpener(nil)
+
+	if _, err := FromSQL(`CREATE TABLE t (id INTEGER PRIMARY KEY);`); err != nil {
+		t.Fatalf("FromSQL() error = %v", err)
+	}
+	if len(dsns) != 1 || dsns[0] != ":memory:" {
+		t.Errorf("opener calls = %v, want one :memory:", dsns)
+	}
+
+	// Errors of the opener are reported by the caller
+	errOpen := errors.New("no driver")
+	SetOpener(func(string) (*sql.DB, error) { return nil, errOpen })
+	if _, err := FromSQL(`CREATE TABLE t (id INTEGER PRIMARY KEY);`); !errors.Is(err, errOpen) {
+		t.Errorf("FromSQL() error = %v, want %v", err, errOpen)
+	}
+}
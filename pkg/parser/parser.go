@@ -2,15 +2,18 @@
 package parser
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io/fs"
-	"os"
 	"path"
-	"path/filepath"
 	"regexp"
+	"runtime"
 	"slices"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"github.com/mizuchilabs/sqlite-schema-diff/pkg/schema"
 	_ "modernc.org/sqlite"
@@ -18,6 +21,26 @@ import (
 
 var baseFS fs.FS
 
+// ChecksDir is the subdirectory of a schema directory holding post-apply
+// assertion queries. It is not read as part of the schema.
+const ChecksDir = "checks"
+
+// OverridesDir is the subdirectory of a schema directory holding SQL that
+// replaces the generated SQL of specific changes. It is not read as part of
+// the schema.
+const OverridesDir = "overrides"
+
+// PartitionsDir is the subdirectory of a schema directory holding the
+// templates of time-partitioned tables. It is not read as part of the
+// schema.
+const PartitionsDir = "partitions"
+
+// isReservedDir reports whether name is a top-level subdirectory that does
+// not hold schema files
+func isReservedDir(name string) bool {
+	return name == ChecksDir || name == OverridesDir || name == PartitionsDir
+}
+
 // sqlStatement represents a SQL statement with its source file
 type sqlStatement struct {
 	sql      string
@@ -37,12 +60,108 @@ func BaseFS() fs.FS {
 
 // FromDB extracts the schema from an open database connection
 func FromDB(db *sql.DB) (*schema.Database, error) {
-	return extractSchema(db)
+	return FromDBContext(context.Background(), db)
+}
+
+// FromDBContext is FromDB with a context that cancels the schema queries
+func FromDBContext(ctx context.Context, db *sql.DB) (*schema.Database, error) {
+	return extractSchema(ctx, db)
+}
+
+// FromDBWithoutColumns extracts the stored SQL of every object but leaves
+// the columns of tables empty, skipping the query per table FromDB makes.
+// Read the columns of the tables that need them with ReadColumns.
+func FromDBWithoutColumns(db *sql.DB) (*schema.Database, error) {
+	return FromDBWithoutColumnsContext(context.Background(), db)
+}
+
+// FromDBWithoutColumnsContext is FromDBWithoutColumns with a context
+func FromDBWithoutColumnsContext(ctx context.Context, db *sql.DB) (*schema.Database, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	s := schema.NewDatabase()
+	tables, err := extractObjects(ctx, conn, s)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		s.Tables[table.Name] = table
+	}
+	return s, nil
+}
+
+// ReadColumns reads the columns of tables extracted by FromDBWithoutColumns
+func ReadColumns(db *sql.DB, tables ...*schema.Table) error {
+	return ReadColumnsContext(context.Background(), db, tables...)
+}
+
+// ReadColumnsContext is ReadColumns with a context
+func ReadColumnsContext(ctx context.Context, db *sql.DB, tables ...*schema.Table) error {
+	if len(tables) == 0 {
+		return nil
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	return extractColumns(ctx, conn, tables)
+}
+
+// Options selects how schema SQL is turned into a schema
+type Options struct {
+	// Offline parses the DDL text instead of executing it against an
+	// in-memory database. Use it for schemas that need extensions or
+	// modules the bundled SQLite lacks. CREATE TABLE ... AS SELECT is not
+	// supported, and nothing validates the SQL.
+	Offline bool
+
+	// FollowSymlinks reads .sql files and directories below the schema
+	// directory that are symlinks. By default they are skipped, so a
+	// checkout linking to SQL elsewhere does not pull it into the schema.
+	FollowSymlinks bool
+	// IncludeHidden reads files and directories whose name starts with a
+	// dot, like .git. By default they are skipped.
+	IncludeHidden bool
+	// MaxDepth limits how deep below the schema directory files are read:
+	// 1 reads only the files directly in it. Zero means no limit.
+	MaxDepth int
 }
 
 // FromSQL parses SQL by executing it against an in-memory SQLite database
 func FromSQL(sqlContent string) (*schema.Database, error) {
-	db, err := sql.Open("sqlite", ":memory:")
+	return FromSQLWithOptions(sqlContent, Options{})
+}
+
+// FromSQLWithOptions is FromSQL with explicit parse options
+func FromSQLWithOptions(sqlContent string, opts Options) (*schema.Database, error) {
+	return FromSQLContext(context.Background(), sqlContent, opts)
+}
+
+// FromSQLContext is FromSQLWithOptions with a context that cancels
+// executing the SQL
+func FromSQLContext(ctx context.Context, sqlContent string, opts Options) (*schema.Database, error) {
+	// Strip schema qualifiers to allow SQL to work in any database context
+	cleanedSQL := stripSchemaQualifiers(sqlContent)
+
+	if opts.Offline {
+		return parseOffline(filterDDL(parseStatements(cleanedSQL, "")))
+	}
+
+	key := newCacheKey("sql", cleanedSQL)
+	if s, ok := cache.get(key); ok {
+		return s, nil
+	}
+
+	db, err := Open(":memory:")
 	if err != nil {
 		return nil, fmt.Errorf("create in-memory database: %w", err)
 	}
@@ -50,59 +169,49 @@ func FromSQL(sqlContent string) (*schema.Database, error) {
 		_ = db.Close()
 	}()
 
-	// Strip schema qualifiers to allow SQL to work in any database context
-	cleanedSQL := stripSchemaQualifiers(sqlContent)
-
-	if _, err := db.Exec(cleanedSQL); err != nil {
+	if _, err := db.ExecContext(ctx, cleanedSQL); err != nil {
 		return nil, fmt.Errorf("execute schema SQL: %w", err)
 	}
 
-	return extractSchema(db)
+	s, err := extractSchema(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	markCreateTableAs(s, parseStatements(cleanedSQL, ""))
+	cache.put(key, s)
+	return s, nil
 }
 
+// ReadFiles reads the schema from the .sql files in a directory
 func ReadFiles(dir string) (*schema.Database, error) {
-	var err error
-	var files []string
-	if baseFS != nil {
-		files, err = fromFS(baseFS, dir)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		files, err = fromDir(dir)
-		if err != nil {
-			return nil, err
-		}
-	}
+	return ReadFilesWithOptions(dir, Options{})
+}
 
-	// Create the in-memory database once
-	db, err := sql.Open("sqlite", ":memory:")
+// ReadFilesWithOptions is ReadFiles with explicit parse options
+func ReadFilesWithOptions(dir string, opts Options) (*schema.Database, error) {
+	return ReadFilesContext(context.Background(), dir, opts)
+}
+
+// ReadFilesContext is ReadFilesWithOptions with a context that cancels
+// executing the schema files
+func ReadFilesContext(ctx context.Context, dir string, opts Options) (*schema.Database, error) {
+	fsys, files, err := SchemaFiles(dir, opts)
 	if err != nil {
-		return nil, fmt.Errorf("create in-memory database: %w", err)
+		return nil, err
 	}
-	defer func() { _ = db.Close() }()
-
-	// Read all files first and categorize statements
-	var tableStmts, otherStmts []sqlStatement
-
-	for _, path := range files {
-		var content []byte
-		if baseFS != nil {
-			content, err = fs.ReadFile(baseFS, path)
-		} else {
-			content, err = os.ReadFile(filepath.Clean(path))
-		}
-		if err != nil {
-			return nil, fmt.Errorf("read %s: %w", path, err)
-		}
 
-		// Strip schema qualifiers (e.g., "main.table_name" -> "table_name")
-		cleanedContent := stripSchemaQualifiers(string(content))
+	perFile, err := readStatements(fsys, dir, files)
+	if err != nil {
+		return nil, err
+	}
 
-		// Categorize statements: tables first, then everything else
-		stmts := parseStatements(cleanedContent, filepath.Base(path))
+	// Categorize statements in file order: tables first, then everything else
+	var tableStmts, ctasStmts, otherStmts []sqlStatement
+	for _, stmts := range perFile {
 		for _, stmt := range stmts {
-			if isTableStatement(stmt.sql) {
+			if _, ok := createTableAsName(stmt.sql); ok {
+				ctasStmts = append(ctasStmts, stmt)
+			} else if isTableStatement(stmt.sql) {
 				tableStmts = append(tableStmts, stmt)
 			} else {
 				otherStmts = append(otherStmts, stmt)
@@ -110,218 +219,388 @@ func ReadFiles(dir string) (*schema.Database, error) {
 		}
 	}
 
-	// Execute tables first, then indexes/views/triggers
-	allStmts := append(tableStmts, otherStmts...)
+	// Execute tables first, then tables created from a SELECT on them,
+	// then indexes/views/triggers
+	allStmts := slices.Concat(tableStmts, ctasStmts, otherStmts)
+	if opts.Offline {
+		return parseOffline(allStmts)
+	}
+
+	sqls := make([]string, len(allStmts))
+	for i, stmt := range allStmts {
+		sqls[i] = stmt.sql
+	}
+	key := newCacheKey("files", sqls...)
+	if s, ok := cache.get(key); ok {
+		return s, nil
+	}
+
+	// Create the in-memory database once
+	db, err := Open(":memory:")
+	if err != nil {
+		return nil, fmt.Errorf("create in-memory database: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	// Keep going after a failure so that every broken file is reported at
+	// once, but only report the first failure of each file
+	var errs []error
+	failed := make(map[string]bool)
 	for _, stmt := range allStmts {
-		if _, err := db.Exec(stmt.sql); err != nil {
-			return nil, fmt.Errorf("execute %s: %w", stmt.fileName, err)
+		if failed[stmt.fileName] {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := db.ExecContext(ctx, stmt.sql); err != nil {
+			errs = append(errs, fmt.Errorf("execute %s: %w", stmt.fileName, err))
+			failed[stmt.fileName] = true
 		}
 	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
 
-	return extractSchema(db)
+	s, err := extractSchema(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	markCreateTableAs(s, ctasStmts)
+	cache.put(key, s)
+	return s, nil
 }
 
-// fromDir loads all .sql files from a directory
-func fromDir(dir string) ([]string, error) {
-	var files []string
-	if err := filepath.WalkDir(filepath.Clean(dir), func(path string, d os.DirEntry, err error) error {
-		if err != nil || d.IsDir() || !strings.HasSuffix(strings.ToLower(path), ".sql") {
-			return err
-		}
-		files = append(files, path)
-		return nil
-	}); err != nil {
+// readStatements reads, checks and splits files concurrently and returns
+// their DDL statements in the order of files. The error reports every file
+// that could not be read, not just the first.
+func readStatements(fsys fs.FS, dir string, files []string) ([][]sqlStatement, error) {
+	perFile := make([][]sqlStatement, len(files))
+	errs := make([]error, len(files))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	for i, p := range files {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			perFile[i], errs[i] = readFileStatements(fsys, p)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("read %s: %w", displayPath(dir, p), errs[i])
+			}
+		})
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return perFile, nil
+}
+
+// readFileStatements reads one schema file and returns its DDL statements
+func readFileStatements(fsys fs.FS, p string) ([]sqlStatement, error) {
+	content, err := fs.ReadFile(fsys, p)
+	if err != nil {
 		return nil, err
 	}
+	if !utf8.Valid(content) {
+		return nil, errors.New("not valid UTF-8 (saved as UTF-16?)")
+	}
+
+	// Strip schema qualifiers (e.g., "main.table_name" -> "table_name")
+	cleanedContent := stripSchemaQualifiers(string(content))
 
-	slices.Sort(files)
-	return files, nil
+	// Non-DDL statements (e.g. from sqlite3 .dump output) are dropped
+	return filterDDL(parseStatements(cleanedContent, path.Base(p))), nil
 }
 
-// fromFS loads all .sql files from an fs.FS
-func fromFS(fsys fs.FS, dir string) ([]string, error) {
-	var files []string
-	if err := fs.WalkDir(fsys, path.Clean(dir), func(path string, d fs.DirEntry, err error) error {
-		if err != nil || d.IsDir() || !strings.HasSuffix(strings.ToLower(path), ".sql") {
-			return err
+// SplitStatements splits SQL into statements, each ending in a semicolon.
+// Semicolons inside string literals, quoted identifiers, comments and the
+// BEGIN ... END body of a trigger do not end a statement. Comments before a
+// statement are dropped; the statement itself is kept verbatim. Use it to
+// execute SQL one statement at a time instead of relying on the driver.
+func SplitStatements(sql string) []string {
+	var stmts []string
+	for _, stmt := range splitStatements(sql) {
+		stmts = append(stmts, stmt.sql)
+	}
+	return stmts
+}
+
+// statementAt is a statement of SplitStatements and its byte offset in
+// the SQL it was split from
+type statementAt struct {
+	sql   string
+	start int
+}
+
+func splitStatements(sql string) []statementAt {
+	var stmts []statementAt
+	tokens := tokenize(sql)
+	emit := func(from, to int) {
+		if from > to || (from == to && tokens[from].text == ";") {
+			return
 		}
-		files = append(files, path)
-		return nil
-	}); err != nil {
-		return nil, err
+		stmt := sql[tokens[from].start:tokens[to].end]
+		if tokens[to].text != ";" {
+			stmt += ";"
+		}
+		stmts = append(stmts, statementAt{sql: stmt, start: tokens[from].start})
 	}
 
-	slices.Sort(files)
-	return files, nil
+	start, depth := 0, 0
+	trigger := false
+	for i, t := range tokens {
+		if i == start {
+			trigger = isTriggerStart(tokens[i:])
+			depth = 0
+		}
+		switch {
+		case t.text == ";" && depth == 0:
+			emit(start, i)
+			start = i + 1
+		case i > start && tokens[i-1].text == ".":
+			// new.end is a column, not the end of the body
+		case trigger && t.is("BEGIN", "CASE"):
+			depth++
+		case trigger && t.is("END") && depth > 0:
+			depth--
+		}
+	}
+	emit(start, len(tokens)-1)
+	return stmts
+}
+
+// isTriggerStart reports whether tokens start a CREATE [TEMP] TRIGGER
+// statement, whose body may contain semicolons
+func isTriggerStart(tokens []token) bool {
+	i := 1
+	if len(tokens) > 2 && tokens[1].is("TEMP", "TEMPORARY") {
+		i++
+	}
+	return len(tokens) > i && tokens[0].is("CREATE") && tokens[i].is("TRIGGER")
 }
 
 // parseStatements splits SQL content into individual statements
 func parseStatements(content, fileName string) []sqlStatement {
 	var stmts []sqlStatement
-	var current strings.Builder
+	for _, stmt := range SplitStatements(content) {
+		stmts = append(stmts, sqlStatement{sql: stmt, fileName: fileName})
+	}
+	return stmts
+}
 
-	inString := false
-	var stringChar rune
-	inLineComment := false
-	inBlockComment := false
-	beginDepth := 0
+// isTableStatement checks if a SQL statement creates a table
+func isTableStatement(sql string) bool {
+	sql = strings.TrimSpace(strings.ToUpper(sql))
+	return strings.HasPrefix(sql, "CREATE TABLE") || strings.HasPrefix(sql, "CREATE VIRTUAL TABLE")
+}
 
-	runes := []rune(content)
+// createTableAsRe matches CREATE TABLE ... AS SELECT, capturing the table
+// name and the query
+var createTableAsRe = regexp.MustCompile(
+	`(?is)^CREATE\s+(?:TEMP\s+|TEMPORARY\s+)?TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?` +
+		`("(?:[^"]|"")*"|\x60(?:[^\x60]|\x60\x60)*\x60|\[[^\]]*\]|'(?:[^']|'')*'|[^\s("'\x60\[]+)` +
+		`\s+AS\s+(.*?)\s*;?\s*$`,
+)
 
-	for i := 0; i < len(runes); i++ {
-		r := runes[i]
+// createTableAsName returns the unquoted table name if sql is a
+// CREATE TABLE ... AS SELECT statement
+func createTableAsName(sql string) (string, bool) {
+	m := createTableAsRe.FindStringSubmatch(sql)
+	if m == nil {
+		return "", false
+	}
+	return unquoteIdent(m[1]), true
+}
 
-		if inLineComment {
-			if r == '\n' {
-				inLineComment = false
-			}
-			current.WriteRune(r)
+// markCreateTableAs records the query of CREATE TABLE ... AS SELECT
+// statements on their tables. SQLite stores such tables with a plain
+// CREATE TABLE derived from the result columns, which is what gets diffed.
+func markCreateTableAs(s *schema.Database, stmts []sqlStatement) {
+	for _, stmt := range stmts {
+		m := createTableAsRe.FindStringSubmatch(stmt.sql)
+		if m == nil {
 			continue
 		}
-
-		if inBlockComment {
-			if r == '*' && i+1 < len(runes) && runes[i+1] == '/' {
-				inBlockComment = false
-				current.WriteRune(r)
-				current.WriteRune('/')
-				i++
-			} else {
-				current.WriteRune(r)
-			}
-			continue
+		if table, ok := s.Tables[unquoteIdent(m[1])]; ok {
+			table.AsSelect = m[2]
 		}
+	}
+}
 
-		if inString {
-			if r == stringChar {
-				// handle escaped quotes like '' -> '
-				if i+1 < len(runes) && runes[i+1] == stringChar &&
-					(stringChar == '\'' || stringChar == '"') {
-					current.WriteRune(r)
-					current.WriteRune(stringChar)
-					i++
-				} else {
-					inString = false
-					current.WriteRune(r)
-				}
-			} else {
-				current.WriteRune(r)
-			}
-			continue
+// unquoteIdent strips SQLite identifier quoting
+func unquoteIdent(name string) string {
+	if len(name) >= 2 {
+		switch first, last := name[0], name[len(name)-1]; {
+		case (first == '"' || first == '\'' || first == '`') && last == first:
+			q := string(first)
+			return strings.ReplaceAll(name[1:len(name)-1], q+q, q)
+		case first == '[' && last == ']':
+			return name[1 : len(name)-1]
 		}
+	}
+	return name
+}
 
-		isWordBoundary := r <= ' ' || r == ';' || r == '(' || r == ')' || r == ','
+// columnAnnotationRe matches a "-- @backfill: <expr>" or "-- @from: <expr>"
+// column annotation, or a sensitivity tag like "-- @pii" with an optional
+// note
+var columnAnnotationRe = regexp.MustCompile(`^--\s*@(backfill|from|pii|sensitive|encrypted)\b:?\s*(.*?)\s*$`)
+
+// sensitiveTags are the column annotations marking sensitive data
+var sensitiveTags = []string{"pii", "sensitive", "encrypted"}
+
+// columnAnnotations returns the annotations on the columns of a CREATE TABLE
+// statement, keyed by column name and then annotation name. An annotation
+// after a column definition applies to the definition ending right before
+// it, even if the line holds several; an annotation on a line of its own
+// applies to the next definition, which must then be the only one starting
+// on its line.
+func columnAnnotations(createSQL string) (map[string]map[string]string, error) {
+	annotations := make(map[string]map[string]string)
+	stmt := tokenize(createSQL)
+	i := slices.IndexFunc(stmt, func(t token) bool { return strings.HasPrefix(t.text, "(") })
+	if i < 0 {
+		return annotations, nil
+	}
+	body := stmt[i].inner()
 
-		if isWordBoundary && current.Len() > 0 {
-			s := current.String()
+	// Top-level definitions with the offsets of their first and last token
+	type definition struct {
+		name       string
+		start, end int
+	}
+	var defs []definition
+	for _, def := range splitTokens(tokenize(body)) {
+		if len(def) > 0 {
+			defs = append(defs, definition{unquoteIdent(def[0].text), def[0].start, def[len(def)-1].end})
+		}
+	}
+	sameLine := func(from, to int) bool { return !strings.Contains(body[from:to], "\n") }
 
-			// Only track BEGIN/END if we are inside a CREATE TRIGGER statement
-			stmtUpper := strings.ToUpper(stripLeadingComments(s))
-			isTrigger := strings.HasPrefix(stmtUpper, "CREATE TRIGGER") ||
-				strings.HasPrefix(stmtUpper, "CREATE TEMP TRIGGER") ||
-				strings.HasPrefix(stmtUpper, "CREATE TEMPORARY TRIGGER")
+	for at, comment := range lineComments(body) {
+		m := columnAnnotationRe.FindStringSubmatch(comment)
+		if m == nil {
+			continue
+		}
 
-			if isTrigger {
-				lastWordStart := -1
-				for j := len(s) - 1; j >= 0; j-- {
-					ch := rune(s[j])
-					if ch <= ' ' || ch == ';' || ch == '(' || ch == ')' || ch == ',' {
-						lastWordStart = j
-						break
-					}
-				}
-				lastWord := strings.ToUpper(s[lastWordStart+1:])
-				switch lastWord {
-				case "BEGIN", "CASE":
-					beginDepth++
-				case "END":
-					beginDepth--
-					if beginDepth < 0 {
-						beginDepth = 0
-					}
-				}
+		// The definition before the comment on the same line, if any, or
+		// else the next one
+		var owner *definition
+		for j := range defs {
+			if defs[j].start < at && sameLine(min(defs[j].end, at), at) {
+				owner = &defs[j]
 			}
 		}
-
-		switch r {
-		case '-', '/':
-			if r == '-' && i+1 < len(runes) && runes[i+1] == '-' {
-				inLineComment = true
-				current.WriteRune('-')
-				current.WriteRune('-')
-				i++
-			} else if r == '/' && i+1 < len(runes) && runes[i+1] == '*' {
-				inBlockComment = true
-				current.WriteRune('/')
-				current.WriteRune('*')
-				i++
-			} else {
-				current.WriteRune(r)
+		if owner == nil {
+			next := slices.IndexFunc(defs, func(d definition) bool { return d.start > at })
+			if next < 0 {
+				continue
 			}
-		case '\'', '"', '`':
-			inString = true
-			stringChar = r
-			current.WriteRune(r)
-		case '[':
-			inString = true
-			stringChar = ']'
-			current.WriteRune(r)
-		case ';':
-			current.WriteRune(r)
-			if beginDepth == 0 {
-				stmt := stripLeadingComments(current.String())
-				if stmt != "" && stmt != ";" {
-					stmts = append(stmts, sqlStatement{
-						sql:      stmt,
-						fileName: fileName,
-					})
-				}
-				current.Reset()
+			owner = &defs[next]
+			if next+1 < len(defs) && sameLine(owner.start, defs[next+1].start) {
+				return nil, fmt.Errorf("annotation %q is ambiguous: columns %s and %s start on the line after it",
+					strings.TrimSpace(comment), owner.name, defs[next+1].name)
 			}
+		}
+		if annotations[owner.name] == nil {
+			annotations[owner.name] = make(map[string]string)
+		}
+		annotations[owner.name][m[1]] = m[2]
+	}
+	return annotations, nil
+}
+
+// lineComments returns the "--" comments of sql outside of string literals
+// and quoted identifiers, keyed by their offset
+func lineComments(sql string) map[int]string {
+	comments := make(map[int]string)
+	for i := 0; i < len(sql); {
+		switch {
+		case sql[i] == '\'' || sql[i] == '"' || sql[i] == '`' || sql[i] == '[':
+			i = skipQuoted(sql, i)
+		case strings.HasPrefix(sql[i:], "--"):
+			end := skipComment(sql, i)
+			comments[i] = strings.TrimSuffix(sql[i:end], "\n")
+			i = end
+		case strings.HasPrefix(sql[i:], "/*"):
+			i = skipComment(sql, i)
 		default:
-			current.WriteRune(r)
+			i++
 		}
 	}
+	return comments
+}
 
-	stmt := stripLeadingComments(current.String())
-	if stmt != "" && stmt != ";" {
-		if !strings.HasSuffix(stmt, ";") {
-			stmt += ";"
+// annotateColumns sets the column expressions and sensitivity tags
+// annotated in the table SQL
+func annotateColumns(table *schema.Table) error {
+	annotated, err := columnAnnotations(table.SQL)
+	if err != nil {
+		return fmt.Errorf("table %s: %w", table.Name, err)
+	}
+	for name, annotations := range annotated {
+		for i := range table.Columns {
+			if strings.EqualFold(table.Columns[i].Name, name) {
+				table.Columns[i].Backfill = annotations["backfill"]
+				table.Columns[i].From = annotations["from"]
+				for _, tag := range sensitiveTags {
+					if _, ok := annotations[tag]; ok {
+						table.Columns[i].Sensitive = tag
+					}
+				}
+			}
 		}
-		stmts = append(stmts, sqlStatement{
-			sql:      stmt,
-			fileName: fileName,
-		})
 	}
+	return nil
+}
 
-	return stmts
+// dumpSchemaInsertRe matches the writable_schema inserts that sqlite3 .dump
+// emits for virtual tables, capturing the quoted CREATE statement
+var dumpSchemaInsertRe = regexp.MustCompile(
+	`(?is)^INSERT\s+INTO\s+(?:sqlite_schema|sqlite_master)\s*\([^)]*\)\s*VALUES\s*\(` +
+		`\s*'(?:[^']|'')*'\s*,\s*'(?:[^']|'')*'\s*,\s*'(?:[^']|'')*'\s*,\s*\d+\s*,` +
+		`\s*'((?:[^']|'')*)'\s*\)\s*;?$`,
+)
+
+// dumpOnlyKeywords are statement prefixes that only carry data or session
+// state (as found in sqlite3 .dump output) and never define schema
+var dumpOnlyKeywords = []string{
+	"PRAGMA", "BEGIN", "COMMIT", "END", "ROLLBACK", "SAVEPOINT", "RELEASE",
+	"INSERT", "REPLACE", "UPDATE", "DELETE", "ANALYZE", "VACUUM",
 }
 
-func stripLeadingComments(sql string) string {
-	for {
-		sql = strings.TrimSpace(sql)
-		if strings.HasPrefix(sql, "--") {
-			idx := strings.Index(sql, "\n")
-			if idx == -1 {
-				return ""
-			}
-			sql = sql[idx:]
-		} else if strings.HasPrefix(sql, "/*") {
-			idx := strings.Index(sql, "*/")
-			if idx == -1 {
-				return ""
-			}
-			sql = sql[idx+2:]
-		} else {
-			break
+// filterDDL drops data and session statements so that full dump files
+// (PRAGMAs, BEGIN/COMMIT, INSERTs, sqlite_sequence writes) can be used as
+// a schema source
+func filterDDL(stmts []sqlStatement) []sqlStatement {
+	var filtered []sqlStatement
+	for _, stmt := range stmts {
+		if m := dumpSchemaInsertRe.FindStringSubmatch(stmt.sql); m != nil {
+			stmt.sql = ensureSemicolon(strings.ReplaceAll(m[1], "''", "'"))
+			filtered = append(filtered, stmt)
+			continue
 		}
+
+		fields := strings.FieldsFunc(stmt.sql, func(r rune) bool {
+			return r <= ' ' || r == ';' || r == '('
+		})
+		if len(fields) > 0 && slices.Contains(dumpOnlyKeywords, strings.ToUpper(fields[0])) {
+			continue
+		}
+		filtered = append(filtered, stmt)
 	}
-	return strings.TrimSpace(sql)
+	return filtered
 }
 
-// isTableStatement checks if a SQL statement creates a table
-func isTableStatement(sql string) bool {
-	sql = strings.TrimSpace(strings.ToUpper(sql))
-	return strings.HasPrefix(sql, "CREATE TABLE")
+func ensureSemicolon(sql string) string {
+	sql = strings.TrimSpace(sql)
+	if !strings.HasSuffix(sql, ";") {
+		sql += ";"
+	}
+	return sql
 }
 
 // stripSchemaQualifiers removes schema qualifiers ("main.") from SQL
@@ -330,153 +609,107 @@ func stripSchemaQualifiers(sql string) string {
 	return schemaQualifierRe.ReplaceAllString(sql, "")
 }
 
-// extractSchema extracts the complete schema from a database connection
-func extractSchema(db *sql.DB) (*schema.Database, error) {
-	s := schema.NewDatabase()
-
-	if err := extractTables(db, s); err != nil {
-		return nil, err
-	}
-	if err := extractIndexes(db, s); err != nil {
-		return nil, err
-	}
-	if err := extractViews(db, s); err != nil {
-		return nil, err
-	}
-	if err := extractTriggers(db, s); err != nil {
+// extractSchema extracts the complete schema from a database connection.
+// sqlite_master is read in a single query, then the columns of every table
+// with one prepared statement, all on the same connection.
+func extractSchema(ctx context.Context, db *sql.DB) (*schema.Database, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
 		return nil, err
 	}
-
-	return s, nil
+	defer func() {
+		_ = conn.Close()
+	}()
+	return FromConn(ctx, conn)
 }
 
-func extractTables(db *sql.DB, s *schema.Database) error {
-	// First pass: collect all table names and SQL
-	// We must close this query before running nested queries (driver limitation)
-	rows, err := db.Query(`
-		SELECT name, sql FROM sqlite_master 
-		WHERE type='table' AND name NOT LIKE 'sqlite_%' 
-		ORDER BY name
-	`)
+// FromConn is FromDBContext on a connection the caller holds, e.g. to read
+// the schema without waiting for another connection of a pool limited to
+// one
+func FromConn(ctx context.Context, conn *sql.Conn) (*schema.Database, error) {
+	s := schema.NewDatabase()
+	tables, err := extractObjects(ctx, conn, s)
 	if err != nil {
-		return err
-	}
-
-	type tableInfo struct {
-		name string
-		sql  string
+		return nil, err
 	}
-	var tables []tableInfo
-
-	for rows.Next() {
-		var ti tableInfo
-		if err := rows.Scan(&ti.name, &ti.sql); err != nil {
-			_ = rows.Close()
-			return err
-		}
-		tables = append(tables, ti)
+	if err := extractColumns(ctx, conn, tables); err != nil {
+		return nil, err
 	}
-	_ = rows.Close()
-
-	if err := rows.Err(); err != nil {
-		return err
+	for _, table := range tables {
+		s.Tables[table.Name] = table
 	}
-
-	// Second pass: get column info for each table
-	for _, ti := range tables {
-		table := &schema.Table{Name: ti.name, SQL: ti.sql}
-
-		colRows, err := db.Query(fmt.Sprintf("PRAGMA table_xinfo(%q)", ti.name))
-		if err != nil {
-			return err
-		}
-
-		for colRows.Next() {
-			var cid int
-			var cname, ctype string
-			var notnull, pk int
-			var dflt sql.NullString
-			var hidden int
-
-			if err := colRows.Scan(&cid, &cname, &ctype, &notnull, &dflt, &pk, &hidden); err != nil {
-				_ = colRows.Close()
-				return err
-			}
-
-			col := schema.Column{
-				Name:       cname,
-				Type:       ctype,
-				NotNull:    notnull == 1,
-				PrimaryKey: pk,
-				Hidden:     hidden,
-			}
-			if dflt.Valid {
-				col.Default = &dflt.String
-			}
-			table.Columns = append(table.Columns, col)
-		}
-		_ = colRows.Close()
-
-		s.Tables[ti.name] = table
-	}
-
-	return nil
+	return s, nil
 }
 
-func extractIndexes(db *sql.DB, s *schema.Database) error {
-	rows, err := db.Query(`
-		SELECT name, tbl_name, sql FROM sqlite_master 
-		WHERE type='index' AND sql IS NOT NULL AND name NOT LIKE 'sqlite_%'
-		ORDER BY name
+// extractObjects reads every object from sqlite_master into s, except the
+// tables, which are returned without their columns. Shadow tables (e.g.
+// "places_node" of an rtree) are managed by their virtual table and never
+// diffed on their own.
+func extractObjects(ctx context.Context, conn *sql.Conn, s *schema.Database) ([]*schema.Table, error) {
+	rows, err := conn.QueryContext(ctx, `
+		SELECT type, name, tbl_name, sql FROM sqlite_master
+		WHERE type IN ('table', 'index', 'view', 'trigger')
+		AND (type IN ('view', 'trigger') OR name NOT LIKE 'sqlite_%')
+		AND NOT (type = 'table' AND name IN (SELECT name FROM pragma_table_list WHERE schema='main' AND type='shadow'))
+		ORDER BY type, name
 	`)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() {
 		_ = rows.Close()
 	}()
 
+	var tables []*schema.Table
 	for rows.Next() {
-		var name, table string
+		var typ, name, table string
 		var sqlText sql.NullString
-		if err := rows.Scan(&name, &table, &sqlText); err != nil {
-			return err
+		if err := rows.Scan(&typ, &name, &table, &sqlText); err != nil {
+			return nil, err
 		}
-		if !sqlText.Valid {
-			continue
+		switch typ {
+		case "table":
+			tables = append(tables, &schema.Table{Name: name, SQL: sqlText.String})
+		case "index":
+			// Indexes without SQL are created by constraints
+			if sqlText.Valid {
+				s.Indexes[name] = &schema.Index{Name: name, Table: table, SQL: sqlText.String}
+			}
+		case "view":
+			s.Views[name] = &schema.View{Name: name, SQL: sqlText.String}
+		case "trigger":
+			s.Triggers[name] = &schema.Trigger{Name: name, Table: table, SQL: sqlText.String}
 		}
-		s.Indexes[name] = &schema.Index{Name: name, Table: table, SQL: sqlText.String}
 	}
-
-	return rows.Err()
+	return tables, rows.Err()
 }
 
-func extractViews(db *sql.DB, s *schema.Database) error {
-	rows, err := db.Query(`
-		SELECT name, sql FROM sqlite_master WHERE type='view' ORDER BY name
-	`)
+// extractColumns reads the columns of each table. The sqlite_master query
+// must be closed before, as the driver cannot run nested queries.
+func extractColumns(ctx context.Context, conn *sql.Conn, tables []*schema.Table) error {
+	stmt, err := conn.PrepareContext(ctx, `SELECT cid, name, type, "notnull", dflt_value, pk, hidden FROM pragma_table_xinfo(?)`)
 	if err != nil {
 		return err
 	}
 	defer func() {
-		_ = rows.Close()
+		_ = stmt.Close()
 	}()
 
-	for rows.Next() {
-		var name, sqlText string
-		if err := rows.Scan(&name, &sqlText); err != nil {
+	for _, table := range tables {
+		if err := extractTableColumns(ctx, stmt, table); err != nil {
+			return fmt.Errorf("columns of %s: %w", table.Name, err)
+		}
+		if err := annotateColumns(table); err != nil {
 			return err
 		}
-		s.Views[name] = &schema.View{Name: name, SQL: sqlText}
 	}
-
-	return rows.Err()
+	return nil
 }
 
-func extractTriggers(db *sql.DB, s *schema.Database) error {
-	rows, err := db.Query(`
-		SELECT name, tbl_name, sql FROM sqlite_master WHERE type='trigger' ORDER BY name
-	`)
+// extractTableColumns reads the columns of one table with the prepared
+// table_xinfo statement
+func extractTableColumns(ctx context.Context, stmt *sql.Stmt, table *schema.Table) error {
+	rows, err := stmt.QueryContext(ctx, table.Name)
 	if err != nil {
 		return err
 	}
@@ -485,12 +718,27 @@ func extractTriggers(db *sql.DB, s *schema.Database) error {
 	}()
 
 	for rows.Next() {
-		var name, table, sqlText string
-		if err := rows.Scan(&name, &table, &sqlText); err != nil {
+		var cid int
+		var cname, ctype string
+		var notnull, pk int
+		var dflt sql.NullString
+		var hidden int
+
+		if err := rows.Scan(&cid, &cname, &ctype, &notnull, &dflt, &pk, &hidden); err != nil {
 			return err
 		}
-		s.Triggers[name] = &schema.Trigger{Name: name, Table: table, SQL: sqlText}
-	}
 
+		col := schema.Column{
+			Name:       cname,
+			Type:       ctype,
+			NotNull:    notnull == 1,
+			PrimaryKey: pk,
+			Hidden:     hidden,
+		}
+		if dflt.Valid {
+			col.Default = &dflt.String
+		}
+		table.Columns = append(table.Columns, col)
+	}
 	return rows.Err()
 }
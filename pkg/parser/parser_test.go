@@ -1,9 +1,15 @@
 package parser
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"testing/fstest"
 
@@ -61,6 +67,15 @@ func TestFromSQL(t *testing.T) {
 			wantTables:   []string{"users"},
 			wantTriggers: []string{"update_timestamp"},
 		},
+		{
+			name: "virtual tables without shadow tables",
+			sql: `
+				CREATE VIRTUAL TABLE places USING rtree(id, min_x, max_x, min_y, max_y);
+				CREATE VIRTUAL TABLE shapes USING geopoly(name);
+				CREATE VIRTUAL TABLE docs USING fts5(body);
+			`,
+			wantTables: []string{"docs", "places", "shapes"},
+		},
 		{
 			name:    "invalid SQL",
 			sql:     `INSERT INTO nonexistent_table VALUES (1);`,
@@ -195,6 +210,62 @@ func TestFromDB(t *testing.T) {
 	}
 }
 
+func TestFromDB_AllObjects(t *testing.T) {
+	sqlDB, err := openAndExec(filepath.Join(t.TempDir(), "test.db"), `
+		CREATE TABLE "odd ""name" (id INTEGER PRIMARY KEY, body TEXT NOT NULL DEFAULT '', UNIQUE (body));
+		CREATE INDEX idx_body ON "odd ""name"(body);
+		CREATE VIEW v AS SELECT id FROM "odd ""name";
+		CREATE TRIGGER trg AFTER INSERT ON "odd ""name" BEGIN SELECT 1; END;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = sqlDB.Close() }()
+
+	s, err := FromDB(sqlDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := s.Tables[`odd "name`]
+	if table == nil || len(table.Columns) != 2 || !table.Columns[1].NotNull || *table.Columns[1].Default != "''" {
+		t.Fatalf("table = %+v, want two columns with body NOT NULL DEFAULT ''", table)
+	}
+	// The index backing UNIQUE has no SQL and is not part of the schema
+	if len(s.Indexes) != 1 || s.Indexes["idx_body"].Table != `odd "name` {
+		t.Errorf("indexes = %v, want idx_body", slices.Collect(maps.Keys(s.Indexes)))
+	}
+	if s.Views["v"] == nil || s.Triggers["trg"] == nil || s.Triggers["trg"].Table != `odd "name` {
+		t.Errorf("views = %v, triggers = %v", s.Views, s.Triggers)
+	}
+}
+
+// BenchmarkFromDB measures extracting a schema of 1,000 objects, e.g.
+// compared across commits with benchstat
+func BenchmarkFromDB(b *testing.B) {
+	var ddl strings.Builder
+	for i := range 250 {
+		fmt.Fprintf(&ddl, "CREATE TABLE t%03d (id INTEGER PRIMARY KEY, name TEXT NOT NULL DEFAULT '', created_at TEXT);\n", i)
+		fmt.Fprintf(&ddl, "CREATE INDEX idx_t%03d_name ON t%03d(name);\n", i, i)
+		fmt.Fprintf(&ddl, "CREATE VIEW v%03d AS SELECT id, name FROM t%03d;\n", i, i)
+		fmt.Fprintf(&ddl, "CREATE TRIGGER trg%03d AFTER INSERT ON t%03d BEGIN SELECT 1; END;\n", i, i)
+	}
+	sqlDB, err := openAndExec(filepath.Join(b.TempDir(), "bench.db"), ddl.String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = sqlDB.Close() }()
+
+	for b.Loop() {
+		s, err := FromDB(sqlDB)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if n := len(s.Tables) + len(s.Indexes) + len(s.Views) + len(s.Triggers); n != 1000 {
+			b.Fatalf("extracted %d objects, want 1000", n)
+		}
+	}
+}
+
 func TestFromDirectory(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -284,6 +355,62 @@ func TestFromDirectory_InvalidSQL(t *testing.T) {
 	}
 }
 
+func TestFromDirectory_ReportsAllFileErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	var want []string
+	for i := range 40 {
+		name := fmt.Sprintf("%02d.sql", i)
+		content := fmt.Sprintf("CREATE TABLE t%d (id INTEGER PRIMARY KEY);\nCREATE INDEX idx_t%d ON t%d(id);", i, i, i)
+		if i%10 == 3 {
+			// Broken twice, but only the first failure of a file is reported
+			content = fmt.Sprintf("CREATE TABLE t%d (id INTEGER PRIMARY KEY,);\nCREATE INDEX idx_t%d ON missing(id);", i, i)
+			want = append(want, "execute "+name)
+		}
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	utf16 := []byte{0xff, 0xfe, 'C', 0, 'R', 0, 'E', 0, 'A', 0, 'T', 0, 'E', 0}
+	if err := os.WriteFile(filepath.Join(tmpDir, "windows.sql"), utf16, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file that cannot be read is reported before anything runs
+	_, err := ReadFiles(tmpDir)
+	if err == nil || !strings.Contains(err.Error(), "windows.sql: not valid UTF-8") {
+		t.Fatalf("ReadFiles() error = %v, want UTF-8 error", err)
+	}
+	if err := os.Remove(filepath.Join(tmpDir, "windows.sql")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every broken file is reported, in file order, on every run
+	for range 5 {
+		_, err = ReadFiles(tmpDir)
+		if err == nil {
+			t.Fatal("expected error for invalid SQL")
+		}
+		var got []string
+		for line := range strings.SplitSeq(err.Error(), "\n") {
+			got = append(got, line[:strings.Index(line, ".sql")+4])
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("ReadFiles() reported %v, want %v", got, want)
+		}
+	}
+
+	// Offline parsing reports every file too
+	if err := os.WriteFile(filepath.Join(tmpDir, "13.sql"), []byte("INSERT INTO t1 VALUES (1);\nDROP TABLE t1;"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ReadFilesWithOptions(tmpDir, Options{Offline: true})
+	for _, name := range []string{"03.sql", "13.sql", "23.sql", "33.sql"} {
+		if err == nil || !strings.Contains(err.Error(), "parse "+name) {
+			t.Errorf("ReadFilesWithOptions() offline error = %v, want it to report %s", err, name)
+		}
+	}
+}
+
 func TestFromDirectory_IgnoreNonSQL(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -341,6 +468,22 @@ func openAndExec(path, sqlStr string) (*sql.DB, error) {
 	return db, err
 }
 
+func TestReadFilesContext_Cancelled(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "users.sql"), []byte(`CREATE TABLE users (id INTEGER PRIMARY KEY, cancelled INTEGER);`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ReadFilesContext(ctx, tmpDir, Options{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadFilesContext() error = %v, want context.Canceled", err)
+	}
+	if _, err := FromSQLContext(ctx, `CREATE TABLE users (id INTEGER PRIMARY KEY, cancelled TEXT);`, Options{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("FromSQLContext() error = %v, want context.Canceled", err)
+	}
+}
+
 func TestReadFiles_WithBaseFS(t *testing.T) {
 	// Create a mock filesystem using fstest.MapFS
 	mockFS := fstest.MapFS{
@@ -518,3 +661,320 @@ func TestFromDirectory_IndexBeforeTable(t *testing.T) {
 		t.Error("expected index 'idx_users_username' to exist")
 	}
 }
+
+func TestFromDirectory_SQLiteDump(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Output in the style of `sqlite3 app.db .dump`
+	dump := `PRAGMA foreign_keys=OFF;
+BEGIN TRANSACTION;
+CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT);
+INSERT INTO users VALUES(1,'O''Neil; Jr.');
+INSERT INTO users VALUES(2,'bob');
+DELETE FROM sqlite_sequence;
+INSERT INTO sqlite_sequence VALUES('users',2);
+CREATE INDEX idx_users_name ON users(name);
+PRAGMA writable_schema=ON;
+INSERT INTO sqlite_schema(type,name,tbl_name,rootpage,sql)VALUES('table','docs','docs',0,'CREATE VIRTUAL TABLE docs USING fts5(body, tokenize=''porter'')');
+CREATE TABLE IF NOT EXISTS 'docs_data'(id INTEGER PRIMARY KEY, block BLOB);
+PRAGMA writable_schema=OFF;
+COMMIT;
+`
+	if err := os.WriteFile(filepath.Join(tmpDir, "backup.sql"), []byte(dump), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := ReadFiles(tmpDir)
+	if err != nil {
+		t.Fatalf("ReadFiles() with dump file failed: %v", err)
+	}
+
+	if _, ok := db.Tables["users"]; !ok {
+		t.Error("expected table 'users' to exist")
+	}
+	if _, ok := db.Tables["docs"]; !ok {
+		t.Error("expected virtual table 'docs' to exist")
+	}
+	if _, ok := db.Indexes["idx_users_name"]; !ok {
+		t.Error("expected index 'idx_users_name' to exist")
+	}
+}
+
+func TestFromDirectory_CreateTableAsSelect(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// The CTAS file sorts before the table it selects from
+	files := map[string]string{
+		"a_report.sql": `CREATE TABLE IF NOT EXISTS "order totals" AS
+			SELECT customer, sum(amount) AS total FROM orders GROUP BY customer;`,
+		"b_orders.sql": `CREATE TABLE orders (id INTEGER PRIMARY KEY, customer TEXT NOT NULL, amount REAL);`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := ReadFiles(tmpDir)
+	if err != nil {
+		t.Fatalf("ReadFiles() with CREATE TABLE AS failed: %v", err)
+	}
+
+	table, ok := db.Tables["order totals"]
+	if !ok {
+		t.Fatal("expected table 'order totals' to exist")
+	}
+	if strings.Contains(strings.ToUpper(table.SQL), "SELECT") {
+		t.Errorf("SQL should be a plain CREATE TABLE, got: %s", table.SQL)
+	}
+	if got := table.ColumnNames(); !slices.Equal(got, []string{"customer", "total"}) {
+		t.Errorf("columns = %v, want [customer total]", got)
+	}
+	if !strings.HasPrefix(table.AsSelect, "SELECT customer") {
+		t.Errorf("AsSelect = %q, want the SELECT query", table.AsSelect)
+	}
+	if db.Tables["orders"].AsSelect != "" {
+		t.Error("regular table should not have AsSelect")
+	}
+
+	// The derived definition must round-trip
+	again, err := FromSQL(table.SQL)
+	if err != nil {
+		t.Fatalf("FromSQL() of derived SQL failed: %v", err)
+	}
+	if again.Tables["order totals"].SQL != table.SQL {
+		t.Errorf("derived SQL does not round-trip:\n%s\n%s", again.Tables["order totals"].SQL, table.SQL)
+	}
+}
+
+func TestCreateTableAsName(t *testing.T) {
+	tests := []struct {
+		sql  string
+		name string
+		ok   bool
+	}{
+		{"CREATE TABLE t AS SELECT 1;", "t", true},
+		{"create temp table if not exists \"a b\" as select * from x", "a b", true},
+		{"CREATE TABLE [t] AS\nWITH c AS (SELECT 1) SELECT * FROM c;", "t", true},
+		{"CREATE TABLE t (a AS (1));", "", false},
+		{"CREATE TABLE t (id INTEGER);", "", false},
+		{"CREATE VIEW v AS SELECT 1;", "", false},
+	}
+	for _, tt := range tests {
+		name, ok := createTableAsName(tt.sql)
+		if name != tt.name || ok != tt.ok {
+			t.Errorf("createTableAsName(%q) = %q, %v, want %q, %v", tt.sql, name, ok, tt.name, tt.ok)
+		}
+	}
+}
+
+func TestColumnAnnotations(t *testing.T) {
+	sql := `CREATE TABLE users (id INTEGER PRIMARY KEY, -- @backfill: 1
+    email TEXT NOT NULL,
+    "email lower" TEXT NOT NULL, -- @backfill: lower(email)
+    -- @backfill: 'unknown'
+    [status] TEXT NOT NULL,
+    -- plain comment
+    created_at TEXT,
+    -- @from: first_name || ' ' || last_name
+    name TEXT NOT NULL -- @backfill: ''
+)`
+	got, err := columnAnnotations(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]string{
+		"id":          {"backfill": "1"},
+		"email lower": {"backfill": "lower(email)"},
+		"status":      {"backfill": "'unknown'"},
+		"name":        {"from": "first_name || ' ' || last_name", "backfill": "''"},
+	}
+	if !maps.EqualFunc(got, want, maps.Equal) {
+		t.Errorf("columnAnnotations() = %v, want %v", got, want)
+	}
+}
+
+func TestColumnAnnotations_SeveralColumnsPerLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		sql     string
+		want    map[string]map[string]string
+		wantErr bool
+	}{
+		{
+			name: "after the last column",
+			sql:  "CREATE TABLE t (a INT, b INT -- @backfill: 0\n)",
+			want: map[string]map[string]string{"b": {"backfill": "0"}},
+		},
+		{
+			name: "after a comma",
+			sql:  "CREATE TABLE t (\n  a INT, b INT, -- @pii\n  c INT\n)",
+			want: map[string]map[string]string{"b": {"pii": ""}},
+		},
+		{
+			name: "in a multi-line definition",
+			sql:  "CREATE TABLE t (\n  a INT,\n  b INT\n    CHECK (b > 0) -- @backfill: 1\n)",
+			want: map[string]map[string]string{"b": {"backfill": "1"}},
+		},
+		{
+			name: "in a string",
+			sql:  "CREATE TABLE t (a TEXT DEFAULT '-- @pii', b INT)",
+			want: map[string]map[string]string{},
+		},
+		{
+			name:    "before a line with several columns",
+			sql:     "CREATE TABLE t (\n  -- @backfill: 0\n  a INT, b INT\n)",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := columnAnnotations(tt.sql)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("columnAnnotations() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !maps.EqualFunc(got, tt.want, maps.Equal) {
+				t.Errorf("columnAnnotations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromSQL_BackfillAnnotation(t *testing.T) {
+	db, err := FromSQL(`CREATE TABLE users (
+		id INTEGER PRIMARY KEY,
+		email TEXT,
+		email_lower TEXT NOT NULL -- @backfill: lower(email)
+	);`)
+	if err != nil {
+		t.Fatalf("FromSQL() error: %v", err)
+	}
+
+	table := db.Tables["users"]
+	if got := table.GetColumn("email_lower").Backfill; got != "lower(email)" {
+		t.Errorf("Backfill = %q, want %q", got, "lower(email)")
+	}
+	if got := table.GetColumn("email").Backfill; got != "" {
+		t.Errorf("unannotated column has Backfill %q", got)
+	}
+}
+
+func TestFromSQL_SensitiveAnnotation(t *testing.T) {
+	db, err := FromSQL(`CREATE TABLE users (
+		id INTEGER PRIMARY KEY,
+		email TEXT NOT NULL, -- @pii
+		-- @encrypted: AES-GCM, key in the vault
+		token BLOB,
+		name TEXT -- @sensitive: legal name
+	);`)
+	if err != nil {
+		t.Fatalf("FromSQL() error: %v", err)
+	}
+
+	table := db.Tables["users"]
+	for col, want := range map[string]string{"id": "", "email": "pii", "token": "encrypted", "name": "sensitive"} {
+		if got := table.GetColumn(col).Sensitive; got != want {
+			t.Errorf("%s: Sensitive = %q, want %q", col, got, want)
+		}
+	}
+}
+
+func TestFromDirectory_SkipsChecks(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, dir := range []string{ChecksDir, OverridesDir, PartitionsDir} {
+		if err := os.MkdirAll(filepath.Join(tmpDir, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "users.sql"), []byte(`CREATE TABLE users (id INTEGER PRIMARY KEY);`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	check := `SELECT id FROM users WHERE id < 0;`
+	if err := os.WriteFile(filepath.Join(tmpDir, ChecksDir, "positive_ids.sql"), []byte(check), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	override := `CREATE TABLE users_new (id INTEGER PRIMARY KEY);`
+	if err := os.WriteFile(filepath.Join(tmpDir, OverridesDir, "recreate_users.sql"), []byte(override), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	partition := `CREATE TABLE users (id INTEGER PRIMARY KEY, at TEXT);`
+	if err := os.WriteFile(filepath.Join(tmpDir, PartitionsDir, "events.sql"), []byte(partition), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := ReadFiles(tmpDir)
+	if err != nil {
+		t.Fatalf("ReadFiles() should skip the checks, overrides and partitions directories: %v", err)
+	}
+	if len(db.Tables) != 1 {
+		t.Errorf("expected 1 table, got %d", len(db.Tables))
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{
+			name: "simple",
+			sql:  "CREATE TABLE a (id);\nCREATE TABLE b (id);",
+			want: []string{"CREATE TABLE a (id);", "CREATE TABLE b (id);"},
+		},
+		{
+			name: "semicolons in strings, identifiers and comments",
+			sql:  "INSERT INTO t VALUES ('a;b', \"c;d\", [e;f], `g;h`); -- x; y\n/* z; */ SELECT 1;",
+			want: []string{"INSERT INTO t VALUES ('a;b', \"c;d\", [e;f], `g;h`);", "SELECT 1;"},
+		},
+		{
+			name: "escaped quotes",
+			sql:  "SELECT 'it''s; fine'; SELECT 2;",
+			want: []string{"SELECT 'it''s; fine';", "SELECT 2;"},
+		},
+		{
+			name: "trigger body",
+			sql:  "CREATE TRIGGER trg AFTER INSERT ON t BEGIN\n  UPDATE t SET n = 1;\n  DELETE FROM u;\nEND;\nSELECT 1;",
+			want: []string{"CREATE TRIGGER trg AFTER INSERT ON t BEGIN\n  UPDATE t SET n = 1;\n  DELETE FROM u;\nEND;", "SELECT 1;"},
+		},
+		{
+			name: "trigger keywords on separate lines with CASE",
+			sql:  "create\ntemp  trigger trg after update on t begin\n  update t set n = case when new.n > 0 then 1 else 0 end;\nend;\nSELECT 2;",
+			want: []string{"create\ntemp  trigger trg after update on t begin\n  update t set n = case when new.n > 0 then 1 else 0 end;\nend;", "SELECT 2;"},
+		},
+		{
+			name: "column named end in trigger body",
+			sql:  "CREATE TRIGGER trg AFTER INSERT ON t BEGIN UPDATE t SET n = new.end; END; SELECT 3;",
+			want: []string{"CREATE TRIGGER trg AFTER INSERT ON t BEGIN UPDATE t SET n = new.end; END;", "SELECT 3;"},
+		},
+		{
+			name: "transactions are not trigger bodies",
+			sql:  "BEGIN TRANSACTION;\nCREATE TABLE a (id);\nCOMMIT;",
+			want: []string{"BEGIN TRANSACTION;", "CREATE TABLE a (id);", "COMMIT;"},
+		},
+		{
+			name: "leading comments dropped, inner comments kept",
+			sql:  "-- header\n/* block */\nCREATE TABLE a (\n  id -- the key\n);",
+			want: []string{"CREATE TABLE a (\n  id -- the key\n);"},
+		},
+		{
+			name: "missing final semicolon before a comment",
+			sql:  "CREATE TABLE a (id);\nCREATE TABLE b (id) -- no semicolon",
+			want: []string{"CREATE TABLE a (id);", "CREATE TABLE b (id);"},
+		},
+		{
+			name: "empty statements",
+			sql:  ";;\n-- only a comment\n",
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SplitStatements(tt.sql); !slices.Equal(got, tt.want) {
+				t.Errorf("SplitStatements() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
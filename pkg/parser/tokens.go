@@ -0,0 +1,30 @@
+package parser
+
+// TokenKind classifies a Token
+type TokenKind int
+
+const (
+	TokenWord    TokenKind = iota // Keyword, identifier or number
+	TokenQuoted                   // String literal or quoted identifier
+	TokenPunct                    // Operator or punctuation
+	TokenComment                  // Line or block comment
+)
+
+// Token is a token of schema SQL
+type Token struct {
+	Text string
+	Kind TokenKind
+	Pos  int // Byte offset in the tokenized SQL
+}
+
+// Tokenize splits SQL into tokens, comments included, with the lexer the
+// formatter uses. It accepts any input: unterminated strings and comments
+// run to the end of the SQL.
+func Tokenize(sql string) []Token {
+	lexed := lex(sql)
+	tokens := make([]Token, len(lexed))
+	for i, t := range lexed {
+		tokens[i] = Token{Text: t.text, Kind: TokenKind(t.kind), Pos: t.pos}
+	}
+	return tokens
+}
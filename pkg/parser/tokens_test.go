@@ -0,0 +1,49 @@
+package parser
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []Token
+	}{
+		{
+			name: "statement",
+			sql:  `CREATE TABLE "t" (a->>'$.x') -- note`,
+			want: []Token{
+				{Text: "CREATE", Kind: TokenWord, Pos: 0},
+				{Text: "TABLE", Kind: TokenWord, Pos: 7},
+				{Text: `"t"`, Kind: TokenQuoted, Pos: 13},
+				{Text: "(", Kind: TokenPunct, Pos: 17},
+				{Text: "a", Kind: TokenWord, Pos: 18},
+				{Text: "->>", Kind: TokenPunct, Pos: 19},
+				{Text: "'$.x'", Kind: TokenQuoted, Pos: 22},
+				{Text: ")", Kind: TokenPunct, Pos: 27},
+				{Text: "-- note", Kind: TokenComment, Pos: 29},
+			},
+		},
+		{
+			name: "unterminated",
+			sql:  "x 'open /* no",
+			want: []Token{
+				{Text: "x", Kind: TokenWord, Pos: 0},
+				{Text: "'open /* no", Kind: TokenQuoted, Pos: 2},
+			},
+		},
+		{
+			name: "empty",
+			sql:  "  ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Tokenize(tt.sql); !slices.Equal(got, tt.want) {
+				t.Errorf("Tokenize() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
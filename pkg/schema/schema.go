@@ -24,6 +24,10 @@ type Table struct {
 	Name    string
 	Columns []Column
 	SQL     string // Original CREATE TABLE statement
+
+	// AsSelect is the query of a CREATE TABLE ... AS SELECT schema statement.
+	// SQL then holds the column definitions SQLite derived from it.
+	AsSelect string
 }
 
 // Column represents a table column (from PRAGMA table_info)
@@ -32,8 +36,11 @@ type Column struct {
 	Type       string
 	NotNull    bool
 	Default    *string
-	PrimaryKey int // 0 = not PK, 1+ = PK position
-	Hidden     int // 0 = normal, 2 = virtual/generated, 3 = stored
+	PrimaryKey int    // 0 = not PK, 1+ = PK position
+	Hidden     int    // 0 = normal, 2 = virtual/generated, 3 = stored
+	Backfill   string // Expression from a "-- @backfill:" annotation, fills existing rows
+	From       string // Expression from a "-- @from:" annotation, computes the column from the old row on recreate
+	Sensitive  string // Tag from a "-- @pii", "-- @sensitive" or "-- @encrypted" annotation, empty if untagged
 }
 
 // Index represents a SQLite index
@@ -84,3 +91,38 @@ func (t *Table) GetColumn(name string) *Column {
 	}
 	return nil
 }
+
+// Clone returns a deep copy of the schema
+func (d *Database) Clone() *Database {
+	c := NewDatabase()
+	for name, t := range d.Tables {
+		c.Tables[name] = t.Clone()
+	}
+	for name, idx := range d.Indexes {
+		ci := *idx
+		c.Indexes[name] = &ci
+	}
+	for name, v := range d.Views {
+		cv := *v
+		c.Views[name] = &cv
+	}
+	for name, tr := range d.Triggers {
+		ct := *tr
+		c.Triggers[name] = &ct
+	}
+	return c
+}
+
+// Clone returns a deep copy of the table
+func (t *Table) Clone() *Table {
+	c := *t
+	c.Columns = make([]Column, len(t.Columns))
+	for i, col := range t.Columns {
+		if col.Default != nil {
+			def := *col.Default
+			col.Default = &def
+		}
+		c.Columns[i] = col
+	}
+	return &c
+}
@@ -105,3 +105,24 @@ func TestTableGetColumn(t *testing.T) {
 		t.Error("GetColumn should return nil for empty string")
 	}
 }
+
+func TestDatabaseClone(t *testing.T) {
+	def := "0"
+	db := NewDatabase()
+	db.Tables["users"] = &Table{Name: "users", Columns: []Column{{Name: "id", Default: &def}}}
+	db.Indexes["idx"] = &Index{Name: "idx", Table: "users"}
+
+	clone := db.Clone()
+	*clone.Tables["users"].Columns[0].Default = "1"
+	clone.Tables["users"].Columns[0].Name = "changed"
+	clone.Indexes["idx"].Table = "changed"
+	delete(clone.Tables, "users")
+
+	users := db.Tables["users"]
+	if users == nil || users.Columns[0].Name != "id" || *users.Columns[0].Default != "0" {
+		t.Errorf("changing the clone changed the original table: %+v", users)
+	}
+	if db.Indexes["idx"].Table != "users" {
+		t.Error("changing the clone changed the original index")
+	}
+}